@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Bisect(c *git.Client, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("Missing bisect subcommand")
+	}
+	subcmd, args := args[0], args[1:]
+	switch subcmd {
+	case "start":
+		for _, a := range args {
+			if a == "--" {
+				break
+			}
+			if a == "--no-checkout" || a == "--first-parent" || strings.HasPrefix(a, "--term-") {
+				return fmt.Errorf("git bisect start %v is unimplemented", a)
+			}
+		}
+		return git.BisectStart(c, args)
+	case "bad":
+		if len(args) > 1 {
+			return fmt.Errorf("'git bisect bad' can take only one argument.")
+		}
+		revs, err := bisectRevs(c, args)
+		if err != nil {
+			return err
+		}
+		if len(revs) == 0 {
+			return git.BisectBad(c, nil)
+		}
+		return git.BisectBad(c, revs[0])
+	case "good":
+		revs, err := bisectRevs(c, args)
+		if err != nil {
+			return err
+		}
+		return git.BisectGood(c, revs)
+	case "skip":
+		// Ranges of commits can be skipped, as well as single commits.
+		var revs []git.Commitish
+		for _, a := range args {
+			if !strings.Contains(a, "..") {
+				r, err := bisectRevs(c, []string{a})
+				if err != nil {
+					return err
+				}
+				revs = append(revs, r...)
+				continue
+			}
+			rs, err := git.ParseRevisions(c, []string{a})
+			if err != nil {
+				return err
+			}
+			cmts, err := git.RevList(c, git.RevListOptions{Quiet: true}, nil, rs.Includes, rs.Excludes)
+			if err != nil {
+				return err
+			}
+			for _, cmt := range cmts {
+				revs = append(revs, git.CommitID(cmt))
+			}
+		}
+		return git.BisectSkip(c, revs)
+	case "reset":
+		if len(args) > 1 {
+			return fmt.Errorf("'git bisect reset' requires either no argument or a commit")
+		}
+		commit := ""
+		if len(args) == 1 {
+			commit = args[0]
+		}
+		return git.BisectReset(c, commit)
+	case "log":
+		if len(args) > 0 {
+			return fmt.Errorf("'git bisect log' requires 0 arguments")
+		}
+		return git.BisectLog(c, os.Stdout)
+	case "replay":
+		if len(args) != 1 {
+			return fmt.Errorf("no logfile given")
+		}
+		return git.BisectReplay(c, git.File(args[0]))
+	case "run":
+		err := git.BisectRun(c, args)
+		if err == git.ErrBisectOnlySkipped {
+			fmt.Fprintln(os.Stderr, "bisect run cannot continue any more")
+		}
+		return err
+	case "visualize", "view", "terms", "new", "old", "next":
+		return fmt.Errorf("git bisect %v is unimplemented", subcmd)
+	default:
+		return fmt.Errorf("Invalid bisect subcommand: %v", subcmd)
+	}
+}
+
+// Resolves the commits given to bisect good, bad or skip.
+func bisectRevs(c *git.Client, args []string) ([]git.Commitish, error) {
+	var revs []git.Commitish
+	for _, a := range args {
+		cmt, err := git.RevParseCommit(c, &git.RevParseOptions{}, a)
+		if err != nil {
+			return nil, fmt.Errorf("Bad rev input: %v", a)
+		}
+		revs = append(revs, cmt)
+	}
+	return revs, nil
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func CherryPick(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("cherry-pick", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	opts := git.CherryPickOptions{}
+
+	flags.BoolVar(&opts.Edit, "edit", false, "Allow the commit message to be edited prior to committing")
+	flags.BoolVar(&opts.Edit, "e", false, "Alias of --edit")
+
+	flags.BoolVar(&opts.RecordOrigin, "x", false, "Append a line saying which commit was cherry-picked to the commit message")
+
+	flags.IntVar(&opts.MergeParent, "mainline", 0, "Choose which parent of a merge commit to cherry-pick (1 indexed)")
+	flags.IntVar(&opts.MergeParent, "m", 0, "Alias of --mainline")
+
+	flags.BoolVar(&opts.NoCommit, "no-commit", false, "Do not create a commit, apply the change against your index instead")
+	flags.BoolVar(&opts.NoCommit, "n", false, "Alias of --no-commit")
+
+	flags.BoolVar(&opts.SignOff, "signoff", false, "Add a Signed-off-by line at the end of the commit message")
+	flags.BoolVar(&opts.SignOff, "s", false, "Alias of --signoff")
+
+	flags.BoolVar(&opts.AllowEmpty, "allow-empty", false, "Allow commits which are empty to be cherry-picked")
+	flags.BoolVar(&opts.AllowEmpty, "keep-redundant-commits", false, "Keep commits which become empty when they are cherry-picked")
+
+	flags.StringVar(&opts.MergeStrategy, "strategy", "", "Use the given merge strategy")
+	flags.StringVar(&opts.MergeStrategyOption, "strategy-option", "", "Pass a merge strategy specific option to the merge strategy")
+	flags.StringVar(&opts.MergeStrategyOption, "X", "", "Alias of --strategy-option")
+
+	// Sequencer subcommands
+	flags.BoolVar(&opts.Continue, "continue", false, "Continue the operation in progress using the information in .git/sequencer")
+	flags.BoolVar(&opts.Skip, "skip", false, "Skip the current commit and continue with the rest of the sequence")
+	flags.BoolVar(&opts.Quit, "quit", false, "Forget the current operation in progress.")
+	flags.BoolVar(&opts.Abort, "abort", false, "Cancel the operation and return to the pre-sequence state")
+
+	flags.Parse(args)
+
+	if opts.Continue || opts.Skip || opts.Quit || opts.Abort {
+		if flags.NArg() > 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		return git.CherryPick(c, opts, nil)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	commits, err := sequencerCommits(c, flags.Args(), true)
+	if err != nil {
+		return err
+	}
+	return git.CherryPick(c, opts, commits)
+}
+
+// Returns the commits named by args for cherry-pick or revert. Commits
+// which are named individually are used in the order given. If there are
+// any ranges, the commits in them are used, newest first unless oldestFirst
+// is set.
+func sequencerCommits(c *git.Client, args []string, oldestFirst bool) ([]git.Commitish, error) {
+	revs, err := git.ParseRevisions(c, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs.Paths) > 0 {
+		return nil, fmt.Errorf("bad revision '%v'", revs.Paths[0])
+	}
+	if len(revs.Excludes) == 0 {
+		return revs.Includes, nil
+	}
+	shas, err := git.RevList(c, git.RevListOptions{Quiet: true, Reverse: oldestFirst}, nil, revs.Includes, revs.Excludes)
+	if err != nil {
+		return nil, err
+	}
+	if len(shas) == 0 {
+		return nil, fmt.Errorf("empty commit set passed")
+	}
+	commits := make([]git.Commitish, len(shas))
+	for i, s := range shas {
+		commits[i] = git.CommitID(s)
+	}
+	return commits, nil
+}
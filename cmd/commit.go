@@ -94,6 +94,16 @@ func Commit(c *git.Client, args []string) (string, error) {
 		opts.NoEdit = false
 	}
 
+	if len(message) == 0 {
+		// Start with the message prepared by merge, if there is one.
+		for _, f := range []git.File{"MERGE_MSG", "SQUASH_MSG"} {
+			if m, err := c.GitDir.ReadFile(f); err == nil {
+				message = append(message, strings.TrimRight(string(m), "\n"))
+				break
+			}
+		}
+	}
+
 	finalMessage := strings.Join(message, "\n\n") + "\n"
 
 	if !opts.NoEdit {
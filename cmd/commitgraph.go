@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Implements the git commit-graph command line parsing.
+func CommitGraph(c *git.Client, args []string) error {
+	if len(args) < 1 || args[0] != "write" {
+		return fmt.Errorf("usage: commit-graph write [--reachable | --stdin-packs | --stdin-commits] [--append]")
+	}
+	flags := flag.NewFlagSet("commit-graph write", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	for _, bf := range []string{"split", "changed-paths"} {
+		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
+	}
+	for _, sf := range []string{"object-dir", "max-new-filters", "size-multiple", "max-commits", "expire-time"} {
+		flags.Var(newNotimplStringValue(), sf, "Not implemented")
+	}
+
+	opts := git.CommitGraphWriteOptions{}
+	flags.BoolVar(&opts.Reachable, "reachable", false, "Include the commits reachable from every ref")
+	stdinPacks := flags.Bool("stdin-packs", false, "Include the commits in the pack index files read from stdin")
+	stdinCommits := flags.Bool("stdin-commits", false, "Include the commits read from stdin, and their ancestors")
+	flags.BoolVar(&opts.Append, "append", false, "Include the commits in the existing commit-graph")
+	// Progress is never shown, so these are accepted and ignored.
+	flags.Bool("progress", false, "Ignored")
+	flags.Bool("no-progress", false, "Ignored")
+
+	flags.Parse(args[1:])
+	if flags.NArg() > 0 || (*stdinPacks && *stdinCommits) || (opts.Reachable && (*stdinPacks || *stdinCommits)) {
+		flags.Usage()
+		return fmt.Errorf("Invalid usage of commit-graph write")
+	}
+
+	var commits []git.Commitish
+	if *stdinPacks || *stdinCommits {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if *stdinPacks {
+				opts.Packs = append(opts.Packs, line)
+				continue
+			}
+			cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, line)
+			if err != nil {
+				return err
+			}
+			commits = append(commits, cmt)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if *stdinPacks && len(opts.Packs) == 0 {
+			return nil
+		}
+	}
+	return git.CommitGraphWrite(c, opts, commits)
+}
@@ -4,6 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/driusan/dgit/git"
 )
@@ -25,18 +28,302 @@ func parseCommonDiffFlags(c *git.Client, options *git.DiffCommonOptions, default
 	U0 := flags.Bool("U0", false, "Alias of -U 0. (This is primarily for test compatibility)")
 	flags.BoolVar(&options.Raw, "raw", true, "Generate the diff in raw format")
 	flags.BoolVar(&options.ExitCode, "exit-code", false, "Exit with an exit code of 1 if there are any diffs")
+	flags.StringVar(&options.DiffAlgorithm, "diff-algorithm", "", "Choose a diff algorithm (default, myers, minimal, patience, or histogram)")
+	minimal := flags.Bool("minimal", false, "Alias of --diff-algorithm=minimal")
+	patience := flags.Bool("patience", false, "Alias of --diff-algorithm=patience")
+	histogram := flags.Bool("histogram", false, "Alias of --diff-algorithm=histogram")
+	flags.BoolVar(&options.NameOnly, "name-only", false, "Show only the names of changed files")
+	flags.BoolVar(&options.NameStatus, "name-status", false, "Show only the names and status of changed files")
+	flags.BoolVar(&options.FindCopiesHarder, "find-copies-harder", false, "Look for the source of copies in unmodified files too")
+	norenames := flags.Bool("no-renames", false, "Turn off rename detection")
+	flags.IntVar(&options.StatWidth, "stat-width", 0, "Limit the width of the --stat output")
+	flags.IntVar(&options.StatNameWidth, "stat-name-width", 0, "Limit the width of the filename part of the --stat output")
+	flags.IntVar(&options.StatGraphWidth, "stat-graph-width", 0, "Limit the width of the graph part of the --stat output")
+	flags.IntVar(&options.StatCount, "stat-count", 0, "Limit the --stat output to the first <n> files")
+	flags.BoolVar(&options.NumStat, "numstat", false, "Show the number of added and deleted lines in a machine readable format")
+	flags.BoolVar(&options.ShortStat, "shortstat", false, "Show only the last line of the --stat output")
+	flags.BoolVar(&options.Summary, "summary", false, "Show a summary of created, deleted, renamed and mode changed files")
+	cumulative := flags.Bool("cumulative", false, "Synonym for --dirstat=cumulative")
+	patchWithStat := flags.Bool("patch-with-stat", false, "Synonym for -p --stat")
+	wordDiffRegex := flags.String("word-diff-regex", "", "Use <regex> to decide what a word is for --word-diff")
+	flags.BoolVar(&options.Check, "check", false, "Warn if changes introduce conflict markers or whitespace errors")
+	wsErrorHighlight := flags.String("ws-error-highlight", "", "Highlight whitespace errors in the context, old or new lines of the diff")
+	flags.BoolVar(&options.IgnoreSpaceAtEOL, "ignore-space-at-eol", false, "Ignore changes in whitespace at EOL")
+	flags.BoolVar(&options.IgnoreSpaceChange, "ignore-space-change", false, "Ignore changes in amount of whitespace")
+	b := flags.Bool("b", false, "Alias of --ignore-space-change")
+	flags.BoolVar(&options.IgnoreAllSpace, "ignore-all-space", false, "Ignore whitespace when comparing lines")
+	w := flags.Bool("w", false, "Alias of --ignore-all-space")
+	flags.BoolVar(&options.IgnoreBlankLines, "ignore-blank-lines", false, "Ignore changes whose lines are all blank")
+	flags.BoolVar(&options.FullIndex, "full-index", false, "Show the full object names on the index lines of patches")
+	flags.BoolVar(&options.Binary, "binary", false, "Output a binary diff that can be applied with git apply. Implies --patch")
 
-	flags.Parse(args)
+	if defaultPatch {
+		// Porcelain commands detect renames unless diff.renames is
+		// turned off.
+		switch c.GetConfig("diff.renames") {
+		case "false", "no", "off", "0":
+		case "copies", "copy":
+			options.DetectRenames = true
+			options.DetectCopies = true
+		default:
+			options.DetectRenames = true
+		}
+	}
+
+	// -M, -C, -l, --stat, --dirstat, --color, --word-diff and
+	// --color-words take an optional value which is attached to the
+	// flag, which the flag package can't parse, so handle them before the
+	// rest.
+	var remaining []string
+	var dirstatParams []string
+	var color, wordRegex string
+	for i, a := range args {
+		if a == "--" {
+			remaining = append(remaining, args[i:]...)
+			break
+		}
+		var score string
+		switch {
+		case a == "--stat" || strings.HasPrefix(a, "--stat="):
+			options.Stat = true
+			if a == "--stat" {
+				continue
+			}
+			// --stat=<width>[,<name-width>[,<count>]]
+			for j, v := range strings.Split(a[len("--stat="):], ",") {
+				n, err := strconv.Atoi(v)
+				if err != nil || j > 2 {
+					return nil, fmt.Errorf("Invalid --stat value: %v", a[len("--stat="):])
+				}
+				switch j {
+				case 0:
+					options.StatWidth = n
+				case 1:
+					options.StatNameWidth = n
+				case 2:
+					options.StatCount = n
+				}
+			}
+			continue
+		case a == "--dirstat" || strings.HasPrefix(a, "--dirstat="):
+			options.DirStat = true
+			if a != "--dirstat" {
+				dirstatParams = append(dirstatParams, a[len("--dirstat="):])
+			}
+			continue
+		case strings.HasPrefix(a, "-X"):
+			options.DirStat = true
+			if a != "-X" {
+				dirstatParams = append(dirstatParams, a[2:])
+			}
+			continue
+		case a == "--dirstat-by-file" || strings.HasPrefix(a, "--dirstat-by-file="):
+			options.DirStat = true
+			dirstatParams = append(dirstatParams, "files")
+			if a != "--dirstat-by-file" {
+				dirstatParams = append(dirstatParams, a[len("--dirstat-by-file="):])
+			}
+			continue
+		case a == "--color" || strings.HasPrefix(a, "--color="):
+			color = "always"
+			if a != "--color" {
+				color = a[len("--color="):]
+			}
+			continue
+		case a == "--no-color":
+			color = "never"
+			continue
+		case a == "--word-diff" || strings.HasPrefix(a, "--word-diff="):
+			options.WordDiff = "plain"
+			if a != "--word-diff" {
+				options.WordDiff = a[len("--word-diff="):]
+			}
+			switch options.WordDiff {
+			case "color", "plain", "porcelain":
+			case "none":
+				options.WordDiff = ""
+			default:
+				return nil, fmt.Errorf("bad --word-diff argument: %v", options.WordDiff)
+			}
+			continue
+		case a == "--color-words" || strings.HasPrefix(a, "--color-words="):
+			options.WordDiff = "color"
+			if a != "--color-words" {
+				wordRegex = a[len("--color-words="):]
+			}
+			continue
+		case strings.HasPrefix(a, "-M"):
+			options.DetectRenames = true
+			score = a[2:]
+		case a == "--find-renames" || strings.HasPrefix(a, "--find-renames="):
+			options.DetectRenames = true
+			score = strings.TrimPrefix(strings.TrimPrefix(a, "--find-renames"), "=")
+		case strings.HasPrefix(a, "-C"):
+			// -C -C is the same as --find-copies-harder
+			if options.DetectCopies {
+				options.FindCopiesHarder = true
+			}
+			options.DetectRenames = true
+			options.DetectCopies = true
+			score = a[2:]
+		case a == "--find-copies" || strings.HasPrefix(a, "--find-copies="):
+			options.DetectRenames = true
+			options.DetectCopies = true
+			score = strings.TrimPrefix(strings.TrimPrefix(a, "--find-copies"), "=")
+		case strings.HasPrefix(a, "-l") && len(a) > 2:
+			limit, err := strconv.Atoi(a[2:])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid rename limit: %v", a[2:])
+			}
+			if limit <= 0 {
+				limit = 32767
+			}
+			options.RenameLimit = limit
+			continue
+		default:
+			remaining = append(remaining, a)
+			continue
+		}
+		if score != "" {
+			s, err := git.ParseRenameScore(score)
+			if err != nil {
+				return nil, err
+			}
+			options.RenameScore = s
+		}
+	}
+
+	flags.Parse(remaining)
 	args = flags.Args()
 
-	if *patch || *p || *u {
+	if *b {
+		options.IgnoreSpaceChange = true
+	}
+	if *w {
+		options.IgnoreAllSpace = true
+	}
+
+	if *norenames {
+		options.DetectRenames = false
+		options.DetectCopies = false
+		options.FindCopiesHarder = false
+	}
+
+	if *cumulative {
+		options.DirStat = true
+		dirstatParams = append(dirstatParams, "cumulative")
+	}
+	options.DirStatParams = strings.Join(dirstatParams, ",")
+	if *patchWithStat {
+		options.Stat = true
+		*patch = true
+	}
+
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	// -U and --binary imply --patch.
+	unifiedPatch := explicit["unified"] || explicit["U"] || *U0 || options.Binary
+	if *patch || *p || *u || unifiedPatch {
 		options.Patch = true
-		options.Raw = false
+		if !explicit["raw"] {
+			options.Raw = false
+		}
 	}
 	if *nopatch || *s {
 		options.Patch = false
 	}
 
+	if explicit["raw"] || options.Stat || options.NumStat || options.ShortStat || options.DirStat || options.Summary || options.Check {
+		// The raw, diffstat and check formats replace the default
+		// format, but not one which was explicitly asked for.
+		if !explicit["patch"] && !explicit["p"] && !explicit["u"] && !unifiedPatch && !*patchWithStat {
+			options.Patch = false
+		}
+		if !explicit["raw"] {
+			options.Raw = false
+		}
+	}
+	if *wordDiffRegex != "" {
+		wordRegex = *wordDiffRegex
+		if options.WordDiff == "" {
+			options.WordDiff = "plain"
+		}
+	}
+	if defaultPatch {
+		// Porcelain commands use the color.diff (or color.ui),
+		// diff.wordRegex and diff.wsErrorHighlight config.
+		if color == "" {
+			color = c.GetConfig("color.diff")
+		}
+		if color == "" {
+			color = c.GetConfig("color.ui")
+		}
+		if wordRegex == "" && options.WordDiff != "" {
+			wordRegex = c.GetConfig("diff.wordregex")
+		}
+		if *wsErrorHighlight == "" {
+			*wsErrorHighlight = c.GetConfig("diff.wserrorhighlight")
+		}
+	}
+	on, off := true, false
+	switch color {
+	case "always":
+		options.Color = &on
+	case "never", "false", "no", "off", "0":
+		options.Color = &off
+	case "":
+		// Plumbing commands only use colour if asked to, porcelain
+		// uses it if the output is a terminal.
+		if !defaultPatch {
+			options.Color = &off
+		}
+	case "auto", "true", "yes", "on", "1":
+	default:
+		return nil, fmt.Errorf("option `color' expects \"always\", \"auto\", or \"never\"")
+	}
+	if wordRegex != "" {
+		re, err := regexp.Compile(wordRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", wordRegex)
+		}
+		options.WordDiffRegex = re
+	}
+	if *wsErrorHighlight != "" {
+		for _, kind := range strings.Split(*wsErrorHighlight, ",") {
+			switch kind {
+			case "old", "new", "context", "all", "none", "default":
+				options.WhitespaceErrorHighlight = append(options.WhitespaceErrorHighlight, kind)
+			default:
+				return nil, fmt.Errorf("unknown value after ws-error-highlight=%v", kind)
+			}
+		}
+	}
+
+	if defaultPatch {
+		// Porcelain commands use the width of the terminal and the
+		// diff.statGraphWidth config for diffstats unless told
+		// otherwise.
+		if options.StatWidth == 0 {
+			if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+				options.StatWidth = cols
+			}
+		}
+		if options.StatGraphWidth == 0 {
+			if width, err := strconv.Atoi(c.GetConfig("diff.statgraphwidth")); err == nil {
+				options.StatGraphWidth = width
+			}
+		}
+	}
+
+	switch {
+	case *minimal:
+		options.DiffAlgorithm = "minimal"
+	case *patience:
+		options.DiffAlgorithm = "patience"
+	case *histogram:
+		options.DiffAlgorithm = "histogram"
+	}
+
 	if *unified != 3 && *U != 3 {
 		fmt.Fprintf(flag.CommandLine.Output(), "Can not specify both --unified and -U\n")
 		flags.Usage()
@@ -56,13 +343,28 @@ func parseCommonDiffFlags(c *git.Client, options *git.DiffCommonOptions, default
 // Print the diffs that come back from either diff-files, diff-index, or diff-tree
 // in the appropriate format according to options.
 func printDiffs(c *git.Client, options git.DiffCommonOptions, diffs []git.HashDiff) error {
-	if err := git.GeneratePatch(c, options, diffs, nil); err != nil {
+	// --check exits with 2 if there were problems, which is combined
+	// with the exit code of --exit-code.
+	status := 0
+	if err := git.GeneratePatch(c, options, diffs, nil); err == git.ErrCheckFailed {
+		status = 2
+	} else if err != nil {
 		return err
 	}
 	if options.ExitCode {
-		if len(diffs) > 0 {
-			os.Exit(1)
+		for _, d := range diffs {
+			changed, err := d.HasChanges(c, options)
+			if err != nil {
+				return err
+			}
+			if changed {
+				status |= 1
+				break
+			}
 		}
 	}
+	if status != 0 {
+		os.Exit(status)
+	}
 	return nil
 }
@@ -1,10 +1,12 @@
 package cmd
 
 import (
-	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/driusan/dgit/git"
 )
@@ -19,120 +21,325 @@ func Config(c *git.Client, args []string) error {
 	}
 
 	get := flags.Bool("get", false, "Get the value for a given key")
+	getall := flags.Bool("get-all", false, "Get all of the values for a given key")
+	getregexp := flags.Bool("get-regexp", false, "Get the names and values of the keys which match a regex")
+	add := flags.Bool("add", false, "Add a new value for a key without changing any existing values")
+	replaceall := flags.Bool("replace-all", false, "Replace all of the values of a key which match")
 	unset := flags.Bool("unset", false, "Remove the line matching the key")
 	unsetall := flags.Bool("unset-all", false, "Remove all lines matching the key")
 	list := flags.Bool("list", false, "List all variables along with their values")
+	flags.BoolVar(list, "l", false, "Alias of --list")
+
 	global := flags.Bool("global", false, "For writing options: write to global file rather than respository")
+	system := flags.Bool("system", false, "For writing options: write to the system config file rather than repository")
+	local := flags.Bool("local", false, "For writing options: write to the repository config file (the default)")
+	worktree := flags.Bool("worktree", false, "For writing options: write to the worktree config file")
+	file := flags.String("file", "", "Use the given config file instead of the repository's")
+	flags.StringVar(file, "f", "", "Alias of --file")
+	includes := flags.Bool("includes", false, "Respect include directives when reading a single config file")
+	noincludes := flags.Bool("no-includes", false, "Don't respect include directives, even when reading every config file")
+
+	showorigin := flags.Bool("show-origin", false, "Show the file each value was read from")
+	showscope := flags.Bool("show-scope", false, "Show the scope each value was read from")
 
-	// Type canonicalization isn't currently supported
-	//  and so we just allow them and return the raw value
-	//  with no validation
-	flags.String("type", "", "")
-	flags.Bool("bool", false, "")
-	flags.Bool("int", false, "")
-	flags.Bool("bool-or-int", false, "")
-	flags.Bool("path", false, "")
+	typ := flags.String("type", "", "Canonicalize values as bool, int, bool-or-int or path")
+	isbool := flags.Bool("bool", false, "Alias of --type=bool")
+	isint := flags.Bool("int", false, "Alias of --type=int")
+	isboolorint := flags.Bool("bool-or-int", false, "Alias of --type=bool-or-int")
+	ispath := flags.Bool("path", false, "Alias of --type=path")
+	// Expiry dates aren't canonicalized, and are returned with no
+	// validation.
 	flags.Bool("expiry-date", false, "")
 
 	flags.Parse(args)
 
-	var config git.GitConfig
-	var err error
-
-	if *global {
-		config, err = git.LoadGlobalConfig()
-	} else {
-		config, err = git.LoadLocalConfig(c)
+	switch {
+	case *isbool:
+		*typ = "bool"
+	case *isint:
+		*typ = "int"
+	case *isboolorint:
+		*typ = "bool-or-int"
+	case *ispath:
+		*typ = "path"
+	}
+	switch *typ {
+	case "", "bool", "int", "bool-or-int", "path":
+	case "expiry-date":
+		*typ = ""
+	default:
+		return fmt.Errorf("unrecognized --type argument, %v", *typ)
 	}
 
-	if err != nil {
-		return err
+	// The file which is read and written. If no file was specified,
+	// every config file is read and the local one is written.
+	var fname string
+	scope := git.LocalScope
+	switch {
+	case *file != "":
+		scope = git.CommandScope
+		fname = *file
+	case *system:
+		scope = git.SystemScope
+		fname = git.SystemConfigFile()
+	case *global:
+		scope = git.GlobalScope
+		globalfile, err := git.GlobalConfigFile()
+		if err != nil {
+			return err
+		}
+		fname = globalfile
+	case *worktree:
+		scope = git.WorktreeScope
+		fname = c.GitDir.File("config").String()
+		if c.GetConfig("extensions.worktreeconfig") == "true" {
+			fname = c.GitDir.File("config.worktree").String()
+		}
+	case *local:
+		fname = c.GitDir.File("config").String()
 	}
 
 	var action string
-	if *get {
+	switch {
+	case *get:
 		action = "get"
-	} else if *unset {
+	case *getall:
+		action = "get-all"
+	case *getregexp:
+		action = "get-regexp"
+	case *add:
+		action = "add"
+	case *replaceall:
+		action = "replace-all"
+	case *unset:
 		action = "unset"
-	} else if *unsetall {
-		action = "unsetall"
-	} else if *list {
+	case *unsetall:
+		action = "unset-all"
+	case *list:
 		action = "list"
-	} else if flags.NArg() == 1 {
+	case flags.NArg() == 1:
 		action = "get"
-	} else if flags.NArg() == 2 {
+	case flags.NArg() == 2 || flags.NArg() == 3:
 		action = "set"
 	}
 
+	nargs := map[string][2]int{
+		"get":         {1, 2},
+		"get-all":     {1, 2},
+		"get-regexp":  {1, 2},
+		"add":         {2, 2},
+		"replace-all": {2, 3},
+		"set":         {2, 3},
+		"unset":       {1, 2},
+		"unset-all":   {1, 2},
+		"list":        {0, 0},
+	}
+	if n, ok := nargs[action]; !ok || flags.NArg() < n[0] || flags.NArg() > n[1] {
+		if ok {
+			fmt.Fprintf(flag.CommandLine.Output(), "wrong number of arguments, should be from %d to %d\n", n[0], n[1])
+		}
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// The regex which the values must match, which is the argument after
+	// the value for commands which set a value, or after the name
+	// otherwise.
+	var match func(string) bool
+	valueArg := 1
 	switch action {
-	case "get":
-		if flags.NArg() < 1 {
-			fmt.Fprintf(flag.CommandLine.Output(), "Missing value to get\n")
-			flags.Usage()
-			os.Exit(2)
-		}
-		val := c.GetCachedConfig(flags.Arg(0))
-		if val != "" {
-			fmt.Printf("%s\n", val)
-			return nil
+	case "replace-all", "set", "add":
+		valueArg = 2
+	}
+	if flags.NArg() > valueArg {
+		pattern := flags.Arg(valueArg)
+		negate := strings.HasPrefix(pattern, "!")
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, "!"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid pattern: %v\n", pattern)
+			os.Exit(6)
 		}
-		val, code := config.GetConfig(flags.Arg(0))
-		if code != 0 {
-			os.Exit(code)
-		}
-		fmt.Printf("%s\n", val)
-		return nil
-	case "set":
-		if flags.NArg() < 2 {
-			fmt.Fprintf(flag.CommandLine.Output(), "Missing value to set config to\n")
-			flags.Usage()
-			os.Exit(2)
-		}
-
-		config.SetConfig(flags.Arg(0), flags.Arg(1))
-		return config.WriteConfig()
-	case "unset":
-		if flags.NArg() < 1 {
-			fmt.Fprintf(flag.CommandLine.Output(), "Missing value to unset\n")
-			flags.Usage()
-			os.Exit(2)
-		}
-		code := config.Unset(flags.Arg(0))
-		if code != 0 {
-			os.Exit(code)
-		}
-		return config.WriteConfig()
-	case "unsetall":
-		if flags.NArg() < 1 {
-			fmt.Fprintf(flag.CommandLine.Output(), "Missing value to unset all\n")
-			flags.Usage()
-			os.Exit(2)
-		}
-		found := false
-		for {
-			code := config.Unset(flags.Arg(0))
-			if code == 5 {
-				break
+		match = func(value string) bool {
+			return re.MatchString(value) != negate
+		}
+	}
+
+	switch action {
+	case "get", "get-all", "get-regexp", "list":
+		var values []git.ConfigValue
+		var err error
+		switch {
+		case fname != "":
+			values, err = c.ReadConfigFile(fname, scope, *includes && !*noincludes)
+		case *noincludes:
+			values, err = c.ConfigValuesWithoutIncludes()
+		default:
+			values, err = c.ConfigValues()
+		}
+		if err != nil {
+			return err
+		}
+		return printConfig(c, values, action, flags.Arg(0), match, *typ, *showorigin, *showscope)
+	}
+
+	var config git.GitConfig
+	var err error
+	switch {
+	case fname == "":
+		config, err = git.LoadLocalConfig(c)
+	default:
+		config, err = git.LoadConfigFile(fname)
+	}
+	if err != nil {
+		return err
+	}
+
+	name := flags.Arg(0)
+	var code int
+	switch action {
+	case "set", "add", "replace-all":
+		value := flags.Arg(1)
+		if *typ != "path" {
+			value, err = git.CanonicalConfigValue(*typ, value, false)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: invalid value '%v' for %v: %v\n", flags.Arg(1), name, err)
+				os.Exit(128)
 			}
-			found = true
-			if code != 0 {
-				os.Exit(code)
+		}
+		if action == "add" {
+			if _, err := git.CanonicalConfigName(name); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
 			}
+			err = config.AddConfig(name, value)
+		} else {
+			code, err = config.SetConfigMatching(name, value, match, action == "replace-all")
 		}
-		if !found {
-			os.Exit(5)
+	case "unset", "unset-all":
+		code, err = config.UnsetMatching(name, match, action == "unset-all")
+	}
+	if code != 0 {
+		switch {
+		case code == 5 && err != nil:
+			fmt.Fprintf(os.Stderr, "warning: %v has multiple values\n", name)
+			if action != "unset" {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			}
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		}
-		return config.WriteConfig()
+		os.Exit(code)
+	}
+	if err != nil {
+		return err
+	}
+	return config.WriteConfig()
+}
+
+// Prints the values for the git config get or list action, with the value
+// canonicalized according to typ. Exits with status 1 if there are no
+// matching values to get.
+func printConfig(c *git.Client, values []git.ConfigValue, action, name string, match func(string) bool, typ string, showorigin, showscope bool) error {
+	var keep func(v git.ConfigValue) bool
+	switch action {
 	case "list":
-		list := config.GetConfigList()
-		for _, entry := range list {
-			fmt.Printf("%s\n", entry)
+		keep = func(git.ConfigValue) bool { return true }
+	case "get-regexp":
+		// The section and key are case insensitive, so they're lower
+		// cased in the regex, but the subsection isn't.
+		pattern := name
+		if dot := strings.IndexByte(pattern, '.'); dot >= 0 {
+			last := strings.LastIndexByte(pattern, '.')
+			pattern = strings.ToLower(pattern[:dot]) + pattern[dot:last] + strings.ToLower(pattern[last:])
+		} else {
+			pattern = strings.ToLower(pattern)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid key pattern: %v\n", name)
+			os.Exit(6)
+		}
+		keep = func(v git.ConfigValue) bool {
+			return re.MatchString(v.Name)
+		}
+	default:
+		canonical, err := git.CanonicalConfigName(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		keep = func(v git.ConfigValue) bool {
+			return v.Name == canonical
 		}
-		return nil
 	}
 
-	flags.Usage()
-	os.Exit(2)
+	var matches []git.ConfigValue
+	for _, v := range values {
+		if keep(v) && (match == nil || match(v.Value)) {
+			matches = append(matches, v)
+		}
+	}
+	if len(matches) == 0 {
+		if action == "list" {
+			return nil
+		}
+		os.Exit(1)
+	}
+	if action == "get" {
+		matches = matches[len(matches)-1:]
+	}
+
+	for _, v := range matches {
+		value := v.Value
+		if typ != "" {
+			canonical, err := git.CanonicalConfigValue(typ, v.Value, v.NoValue)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %v for '%v'\n", err, v.Name)
+				os.Exit(128)
+			}
+			value = canonical
+		}
+		if showscope {
+			fmt.Printf("%v\t", v.Scope)
+		}
+		if showorigin {
+			if v.File == "" {
+				fmt.Print("command line:\t")
+			} else {
+				fmt.Printf("file:%v\t", configOrigin(c, v.File))
+			}
+		}
+		switch {
+		case action == "list" && v.NoValue && typ == "":
+			fmt.Println(v.Name)
+		case action == "list":
+			fmt.Printf("%v=%v\n", v.Name, value)
+		case action == "get-regexp" && v.NoValue && typ == "":
+			fmt.Println(v.Name)
+		case action == "get-regexp":
+			fmt.Printf("%v %v\n", v.Name, value)
+		default:
+			fmt.Println(value)
+		}
+	}
+	return nil
+}
 
-	return errors.New("Unhandled action")
+// Returns the name of the config file fname to show as the origin of its
+// values. Like git, files in the repository are shown relative to the top
+// of the work tree, or to the git directory if it's bare.
+func configOrigin(c *git.Client, fname string) string {
+	top := c.WorkDir.String()
+	if top == "" {
+		top = c.GitDir.String()
+	}
+	abs, err := filepath.Abs(fname)
+	if err != nil || top == "" {
+		return fname
+	}
+	rel, err := filepath.Rel(top, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fname
+	}
+	return rel
 }
@@ -4,7 +4,6 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 
 	"github.com/driusan/dgit/git"
 )
@@ -37,18 +36,11 @@ func Diff(c *git.Client, args []string) error {
 	}
 	diffs, err := git.Diff(c, options, files)
 	if err != nil {
-		if options.NoIndex {
-			switch e := err.(type) {
-			case *exec.ExitError:
-				if e.Exited() {
-					// We don't want the exit status printed by returning it,
-					// so we just call os.Exit.
-					// (If there were no diffs err will be nil)
-					os.Exit(1)
-				}
-
-			default:
-			}
+		if err == git.ErrFilesDiffer {
+			// We don't want the error printed by returning it,
+			// so we just call os.Exit.
+			// (If there were no diffs err will be nil)
+			os.Exit(1)
 		}
 		return err
 	}
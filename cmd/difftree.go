@@ -18,16 +18,6 @@ func DiffTree(c *git.Client, args []string) error {
 	}
 	options := git.DiffTreeOptions{}
 
-	patch := flags.Bool("index", false, "Generate patch")
-	p := flags.Bool("p", false, "Alias for --patch")
-	u := flags.Bool("u", false, "Alias for --patch")
-
-	nopatch := flags.Bool("no-patch", false, "Suppress patch generation")
-	s := flags.Bool("s", false, "Alias of --no-patch")
-
-	unified := flags.Int("unified", 3, "Generate <n> lines of context")
-	U := flags.Int("U", 3, "Alias of --unified")
-	flags.BoolVar(&options.Raw, "raw", true, "Generate the diff in raw format")
 	flags.BoolVar(&options.Recurse, "r", false, "Recurse into subtrees")
 
 	adjustedArgs := []string{}
@@ -39,26 +29,16 @@ func DiffTree(c *git.Client, args []string) error {
 		adjustedArgs = append(adjustedArgs, a)
 	}
 
-	flags.Parse(adjustedArgs)
-	args = flags.Args()
-
-	if *patch || *p || *u {
-		options.Patch = true
-	}
-	if *nopatch || *s {
-		options.Patch = false
+	args, err := parseCommonDiffFlags(c, &options.DiffCommonOptions, false, flags, adjustedArgs)
+	if err != nil {
+		return err
 	}
-
-	if unified != nil && U != nil && *unified != *U {
-		return fmt.Errorf("Can not specify both --unified and -U %+v", adjustedArgs)
-	} else if unified != nil {
-		options.NumContextLines = *unified
-	} else if U != nil {
-		options.NumContextLines = *U
+	if o := options.DiffCommonOptions; o.Patch || o.Stat || o.NumStat || o.ShortStat || o.DirStat || o.Summary || o.Check {
+		// Formats which look at the content of files always recurse,
+		// since trees have no content to compare.
+		options.Recurse = true
 	}
 
-	options.NumContextLines = 3
-
 	if len(args) < 2 {
 		flags.Usage()
 		return fmt.Errorf("Must provide two <tree-ish>es. (One not yet supported)")
@@ -72,8 +52,8 @@ func DiffTree(c *git.Client, args []string) error {
 		return err
 	}
 	diffs, err := git.DiffTree(c, &options, treeish, treeish2, args[2:])
-	for _, diff := range diffs {
-		fmt.Printf("%v\n", diff)
+	if err != nil {
+		return err
 	}
-	return err
+	return printDiffs(c, options.DiffCommonOptions, diffs)
 }
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
+	"strings"
 )
 
 // A string value compatible with a flag var
@@ -80,3 +82,45 @@ func (b *notimplBoolValue) Get() interface{} { return bool(*b) }
 func (b *notimplBoolValue) String() string { return "false" }
 
 func (b *notimplBoolValue) IsBoolFlag() bool { return true }
+
+// Separates the revision arguments of a command which walks history from
+// its options, so that they may be given in any order like they can with
+// git. Revision pseudo-options such as --not and --all are returned with
+// the revisions, since their position matters. Everything after "--" is
+// a revision argument (or path.)
+func splitRevisionArgs(flags *flag.FlagSet, args []string) (options, revisions []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return options, append(revisions, args[i:]...)
+		case arg == "--not", arg == "--all",
+			arg == "--branches", strings.HasPrefix(arg, "--branches="),
+			arg == "--tags", strings.HasPrefix(arg, "--tags="),
+			arg == "--remotes", strings.HasPrefix(arg, "--remotes="),
+			strings.HasPrefix(arg, "--glob="), strings.HasPrefix(arg, "--exclude="):
+			revisions = append(revisions, arg)
+		case len(arg) > 1 && arg[0] == '-':
+			options = append(options, arg)
+			name := strings.TrimLeft(arg, "-")
+			if strings.Contains(name, "=") {
+				continue
+			}
+			f := flags.Lookup(name)
+			if f == nil {
+				continue
+			}
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				continue
+			}
+			// The flag takes a value as the next argument.
+			if i+1 < len(args) {
+				i++
+				options = append(options, args[i])
+			}
+		default:
+			revisions = append(revisions, arg)
+		}
+	}
+	return options, revisions
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Implements the git gc command line parsing.
+func Gc(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	// These flags can be moved out of these lists and below as proper flags as they are implemented
+	for _, bf := range []string{"force", "keep-largest-pack", "cruft"} {
+		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
+	}
+
+	opts := git.GcOptions{}
+	flags.BoolVar(&opts.Aggressive, "aggressive", false, "Optimize the repository more aggressively, at the expense of time")
+	flags.BoolVar(&opts.Auto, "auto", false, "Only run if there are enough loose objects or packs")
+	flags.BoolVar(&opts.Quiet, "quiet", false, "Suppress all progress reports")
+	flags.BoolVar(&opts.Quiet, "q", false, "Alias of --quiet")
+	flags.StringVar(&opts.Prune, "prune", "", "Prune unreachable loose objects older than date (default 2.weeks.ago)")
+	flags.BoolVar(&opts.NoPrune, "no-prune", false, "Do not prune any unreachable objects")
+
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return fmt.Errorf("Invalid usage of gc")
+	}
+	return git.Gc(c, opts)
+}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"flag"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 
@@ -19,7 +18,6 @@ func Log(c *git.Client, args []string) error {
 		flags.PrintDefaults()
 	}
 
-	flags.Var(newNotimplBoolValue(), "follow", "Not implemented")
 	flags.Var(newNotimplBoolValue(), "no-decorate", "Not implemented")
 	flags.Var(newNotimplStringValue(), "decorate", "Not implemented")
 	flags.Var(newNotimplStringValue(), "decorate-refs", "Not implemented")
@@ -29,12 +27,13 @@ func Log(c *git.Client, args []string) error {
 	flags.Var(newNotimplBoolValue(), "full-diff", "Not implemented")
 	flags.Var(newNotimplStringValue(), "log-size", "Not implemented")
 	flags.Var(newNotimplStringValue(), "L", "Not implemented")
-	maxCount := -1
-	flags.IntVar(&maxCount, "n", -1, "Limit the number of commits.")
-	flags.IntVar(&maxCount, "max-count", -1, "Alias for -n")
 	format := "medium" // The default
 	flags.StringVar(&format, "format", "medium", "Pretty print the commit logs")
 
+	opts := git.RevListOptions{Quiet: true}
+	finishWalkFlags := addRevWalkFlags(flags, &opts)
+	flags.BoolVar(&opts.Follow, "follow", false, "Continue listing the history of a file beyond renames")
+
 	adjustedArgs := []string{}
 	for _, a := range args {
 		if strings.HasPrefix(a, "-n") && a != "-n" {
@@ -50,29 +49,29 @@ func Log(c *git.Client, args []string) error {
 		adjustedArgs = append(adjustedArgs, a)
 	}
 
-	flags.Parse(adjustedArgs)
-
-	if flags.NArg() > 1 {
-		fmt.Fprintf(flag.CommandLine.Output(), "Paths are not yet implemented, just the revision")
-		flags.Usage()
-		os.Exit(2)
-	}
+	options, revargs := splitRevisionArgs(flags, adjustedArgs)
+	flags.Parse(options)
+	finishWalkFlags()
 
-	var commit git.Commitish
-	var err error
-	if flags.NArg() == 0 {
-		commit, err = git.RevParseCommitish(c, &git.RevParseOptions{}, "HEAD")
-	} else {
-		commit, err = git.RevParseCommitish(c, &git.RevParseOptions{}, flags.Arg(0))
-	}
+	revs, err := git.ParseRevisions(c, revargs)
 	if err != nil {
 		return err
 	}
+	if opts.Paths, err = revisionPaths(c, revs); err != nil {
+		return err
+	}
+	if len(revs.Includes) == 0 && len(revs.Excludes) == 0 {
+		head, err := git.RevParseCommitish(c, &git.RevParseOptions{}, "HEAD")
+		if err != nil {
+			return err
+		}
+		revs.Includes = []git.Commitish{head}
+	}
+	opts.Left, opts.Right = revs.Left, revs.Right
 
-	opts := git.RevListOptions{Quiet: true}
-	if maxCount >= 0 {
-		mc := uint(maxCount)
-		opts.MaxCount = &mc
+	mark, err := git.RevListMarker(c, opts)
+	if err != nil {
+		return err
 	}
 
 	var commitPrinter func(s git.Sha1) error
@@ -83,12 +82,15 @@ func Log(c *git.Client, args []string) error {
 			if err != nil {
 				return err
 			}
+			if m := mark(s); m != "" {
+				output = strings.Replace(output, "commit ", "commit "+m+" ", 1)
+			}
 			fmt.Printf("%s", output)
 			return nil
 		}
 	} else if strings.HasPrefix(format, "format:") {
 		commitPrinter = func(s git.Sha1) error {
-			output, err := git.CommitID(s).Format(c, format[7:])
+			output, err := git.CommitID(s).Format(c, strings.Replace(format[7:], "%m", mark(s), -1))
 			if err != nil {
 				return err
 			}
@@ -99,5 +101,5 @@ func Log(c *git.Client, args []string) error {
 		return fmt.Errorf("Format %s is not supported\n", format)
 	}
 
-	return git.RevListCallback(c, opts, []git.Commitish{commit}, nil, commitPrinter)
+	return git.RevListCallback(c, opts, revs.Includes, revs.Excludes, commitPrinter)
 }
@@ -12,6 +12,12 @@ import (
 func addSharedMergeFlags(flags *flag.FlagSet, options *git.MergeOptions) {
 	flags.BoolVar(&options.FastForwardOnly, "ff-only", false, "Only allow fast-forward merges")
 	flags.BoolVar(&options.NoFastForward, "no-ff", false, "Create a merge commit even when it's a fast-forward merge.")
+	flags.BoolVar(&options.NoCommit, "no-commit", false, "Perform the merge but stop before committing")
+	flags.BoolVar(&options.Squash, "squash", false, "Update the work tree and index as if merged, but do not commit or record the merge")
+	strategy := newAliasedStringValue((*string)(&options.Strategy), "")
+	flags.Var(strategy, "strategy", "Use the given merge strategy")
+	flags.Var(strategy, "s", "Alias of --strategy")
+	flags.BoolVar(&options.NoEdit, "no-edit", false, "Accept the auto-generated merge message")
 }
 
 func Merge(c *git.Client, args []string) error {
@@ -27,12 +33,22 @@ func Merge(c *git.Client, args []string) error {
 
 	// Add flags here that should only work when merge is invoked directly and
 	//  not from another subcommand such as pull.
-	abort := flag.Bool("abort", false, "Abort an in-progress merge")
+	abort := flags.Bool("abort", false, "Abort an in-progress merge")
+	cont := flags.Bool("continue", false, "Conclude an in-progress merge after resolving conflicts")
+	flags.StringVar(&options.Message, "m", "", "Use the given message for the merge commit")
+	flags.BoolVar(&options.Quiet, "quiet", false, "Operate quietly")
+	flags.BoolVar(&options.Quiet, "q", false, "Alias of --quiet")
 	flags.Parse(args)
 
 	if *abort {
 		return git.MergeAbort(c, options)
 	}
+	if *cont {
+		if _, err := git.MergeContinue(c, options); err != nil && err != git.NoGlobalConfig {
+			return err
+		}
+		return nil
+	}
 	merges := flags.Args()
 	if len(merges) < 1 {
 		flags.Usage()
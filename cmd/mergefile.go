@@ -8,64 +8,92 @@ import (
 	"os"
 )
 
-func MergeFile(c *git.Client, args []string) error {
+// Returns the number of conflicts, along with git.MergeConflict if there
+// were any.
+func MergeFile(c *git.Client, args []string) (int, error) {
 	flags := flag.NewFlagSet("merge-file", flag.ExitOnError)
 	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
 	options := git.MergeFileOptions{}
 
+	var labels []string
+	flags.Var(NewMultiStringValue(&labels), "L", "Use label instead of the file name in conflicts. May be specified up to three times")
+	flags.BoolVar(&options.Stdout, "p", false, "Send results to stdout instead of overwriting the current file")
+	flags.BoolVar(&options.Quiet, "q", false, "Do not warn about conflicts")
+	flags.BoolVar(&options.Diff3, "diff3", false, "Show conflicts in diff3 style")
+	zdiff3 := flags.Bool("zdiff3", false, "Show conflicts in zdiff3 style")
+	ours := flags.Bool("ours", false, "Resolve conflicts in favour of the current file")
+	theirs := flags.Bool("theirs", false, "Resolve conflicts in favour of the other file")
+	union := flags.Bool("union", false, "Resolve conflicts by including both sides")
+	flags.IntVar(&options.MarkerSize, "marker-size", 7, "Use markers of the given size for conflicts")
+	flags.StringVar(&options.DiffAlgorithm, "diff-algorithm", "", "Use the given diff algorithm")
+
 	flags.Parse(args)
 	args = flags.Args()
 
-	if len(args) <= 1 {
-		flag.Usage()
-		return fmt.Errorf("Invalid usage of merge-file")
+	if *zdiff3 {
+		if options.Diff3 {
+			return 0, fmt.Errorf("--diff3 and --zdiff3 are mutually exclusive")
+		}
+		options.Style = "zdiff3"
 	}
 
-	// -L may be specified multiple times, so we need to manually look for it instead of
-	// using flag
-	nEls := 0
-LabelLoop:
-	for args[0] == "-L" {
-		if len(args) < 2 {
-			break LabelLoop
-		}
-		switch nEls {
-		case 0:
-			options.Current.Label = args[1]
-		case 1:
-			options.Base.Label = args[1]
+	nFavour := 0
+	if *ours {
+		options.Favour = git.MergeFileFavourOurs
+		nFavour++
+	}
+	if *theirs {
+		options.Favour = git.MergeFileFavourTheirs
+		nFavour++
+	}
+	if *union {
+		options.Favour = git.MergeFileFavourUnion
+		nFavour++
+	}
+	if nFavour > 1 {
+		return 0, fmt.Errorf("Only one of --ours, --theirs, or --union may be specified")
+	}
 
-		case 2:
-			options.Other.Label = args[1]
-		default:
-			flag.Usage()
-			return fmt.Errorf("May only specify -L up to three times.")
-		}
-		nEls++
+	if len(labels) > 3 {
+		flags.Usage()
+		return 0, fmt.Errorf("May only specify -L up to three times.")
 	}
 	if len(args) != 3 {
-		flag.Usage()
-		return fmt.Errorf("Invalid usage of merge-file")
+		flags.Usage()
+		return 0, fmt.Errorf("Invalid usage of merge-file")
 	}
-	for i, file := range args {
+	options.Current.Filename = git.File(args[0])
+	options.Base.Filename = git.File(args[1])
+	options.Other.Filename = git.File(args[2])
+
+	// Labels default to the filenames if not specified.
+	options.Current.Label = args[0]
+	options.Base.Label = args[1]
+	options.Other.Label = args[2]
+	for i, label := range labels {
 		switch i {
 		case 0:
-			options.Current.Filename = git.File(file)
+			options.Current.Label = label
 		case 1:
-			options.Base.Filename = git.File(file)
+			options.Base.Label = label
 		case 2:
-			options.Other.Filename = git.File(file)
+			options.Other.Label = label
 		}
 	}
-	newcontent, err := git.MergeFile(c, options)
+
+	newcontent, conflicts, err := git.MergeFile(c, options)
 	if newcontent != nil {
 		if options.Stdout {
 			io.Copy(os.Stdout, newcontent)
 		} else {
 			f, err := os.Create(options.Current.Filename.String())
 			if err != nil {
-				println("Error opening")
-				return err
+				return 0, err
 			}
 
 			io.Copy(f, newcontent)
@@ -73,5 +101,5 @@ LabelLoop:
 			f.Close()
 		}
 	}
-	return err
+	return conflicts, err
 }
@@ -2,16 +2,18 @@ package cmd
 
 import (
 	"bufio"
-	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/driusan/dgit/git"
 )
 
-func PackObjects(c *git.Client, input io.Reader, args []string) {
+func PackObjects(c *git.Client, input io.Reader, args []string) error {
 	flags := flag.NewFlagSet("pack-objects", flag.ExitOnError)
 	flags.SetOutput(flag.CommandLine.Output())
 	flags.Usage = func() {
@@ -21,35 +23,115 @@ func PackObjects(c *git.Client, input io.Reader, args []string) {
 	}
 
 	// These flags can be moved out of these lists and below as proper flags as they are implemented
-	for _, bf := range []string{"q", "progress", "all-progress", "all-project-implied", "no-reuse-delta", "delta-base-offset", "non-empty", "local", "incremental", "revs", "unpacked", "all", "stdout", "shallow", "keep-true-parents"} {
+	for _, bf := range []string{"all-progress", "all-project-implied", "non-empty", "local", "incremental", "unpacked", "all", "shallow", "keep-true-parents"} {
 		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
 	}
-	for _, sf := range []string{"window", "depth", "keep-pack"} {
+	for _, sf := range []string{"keep-pack"} {
 		flags.Var(newNotimplStringValue(), sf, "Not implemented")
 	}
 
+	opts := git.PackObjectsOptions{}
+	quiet := flags.Bool("q", false, "Do not show progress")
+	progress := flags.Bool("progress", false, "Show progress even if stderr is not a terminal")
+	flags.IntVar(&opts.Window, "window", 10, "Number of objects to consider as delta bases for each object")
+	flags.IntVar(&opts.Depth, "depth", 50, "Maximum length of delta chains")
+	flags.BoolVar(&opts.DeltaBaseOffset, "delta-base-offset", false, "Write deltas which refer to their base by offset")
+	flags.BoolVar(&opts.NoReuseDelta, "no-reuse-delta", false, "Do not reuse existing deltas")
+	revs := flags.Bool("revs", false, "Read revisions instead of objects from stdin, and pack the objects reachable from them")
+	stdout := flags.Bool("stdout", false, "Write the pack to stdout instead of a file")
+
 	flags.Parse(args)
 
-	if flags.NArg() != 1 {
-		flag.Usage()
+	if (*stdout && flags.NArg() != 0) || (!*stdout && flags.NArg() != 1) {
+		flags.Usage()
 		os.Exit(2)
 	}
-
-	f, _ := os.Create(flags.Arg(0) + ".pack")
-	defer f.Close()
+	opts.Progress = *progress && !*quiet
 
 	var objects []git.Sha1
+	opts.Names = make(map[git.Sha1]string)
 	scanner := bufio.NewScanner(input)
-	for scanner.Scan() {
-		b, err := hex.DecodeString(scanner.Text())
+	if *revs {
+		// The input is a list of revisions, one per line, as they
+		// would be passed to rev-list.
+		var revargs []string
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				revargs = append(revargs, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		revisions, err := git.ParseRevisions(c, revargs)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		s, err := git.Sha1FromSlice(b)
+		o, err := git.RevList(c, git.RevListOptions{Objects: true, Quiet: true}, ioutil.Discard, revisions.Includes, revisions.Excludes)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		objects = append(objects, s)
+		objects = o
+	} else {
+		// The input is a list of objects, one per line, optionally
+		// followed by a name as printed by rev-list --objects.
+		for scanner.Scan() {
+			pieces := strings.SplitN(scanner.Text(), " ", 2)
+			s, err := git.Sha1FromString(pieces[0])
+			if err != nil {
+				return err
+			}
+			if len(pieces) == 2 {
+				opts.Names[s] = pieces[1]
+			}
+			objects = append(objects, s)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if *stdout {
+		_, err := git.PackObjects(c, opts, os.Stdout, objects)
+		return err
+	}
+
+	// Write to a temporary file, and then rename it to base-name-<sha>
+	// once we know the name.
+	base := flags.Arg(0)
+	tmp, err := ioutil.TempFile(filepath.Dir(base), ".tmp-pack")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	idx, err := git.PackObjects(c, opts, tmp, objects)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// The packfile is named after its trailer, like git does.
+	packsha, _ := idx.GetTrailer()
+	name := packsha.String()
+	f, err := os.Create(fmt.Sprintf("%s-%s.idx", base, name))
+	if err != nil {
+		return err
+	}
+	if err := idx.WriteIndex(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), fmt.Sprintf("%s-%s.pack", base, name)); err != nil {
+		return err
 	}
-	git.SendPackfile(c, f, objects)
+	fmt.Println(name)
+	return nil
 }
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Implements the git pack-refs command line parsing.
+func PackRefs(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("pack-refs", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	opts := git.PackRefsOptions{}
+	flags.BoolVar(&opts.All, "all", false, "Pack all refs, not just tags and already packed refs")
+	flags.BoolVar(&opts.Prune, "prune", true, "Remove loose refs after packing them")
+	noprune := flags.Bool("no-prune", false, "Do not remove loose refs after packing them")
+
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return fmt.Errorf("Invalid usage of pack-refs")
+	}
+	if *noprune {
+		opts.Prune = false
+	}
+	return git.PackRefs(c, opts)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Implements the git prune command line parsing.
+func Prune(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Var(newNotimplBoolValue(), "progress", "Not implemented")
+
+	opts := git.PruneOptions{}
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "Do not remove anything, only report what would be removed")
+	flags.BoolVar(&opts.DryRun, "n", false, "Alias of --dry-run")
+	flags.BoolVar(&opts.Verbose, "verbose", false, "Report all removed objects")
+	flags.BoolVar(&opts.Verbose, "v", false, "Alias of --verbose")
+	flags.StringVar(&opts.Expire, "expire", "", "Only expire loose objects older than date")
+
+	flags.Parse(args)
+
+	var heads []git.Commitish
+	for _, arg := range flags.Args() {
+		cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, arg)
+		if err != nil {
+			return err
+		}
+		heads = append(heads, cmt)
+	}
+	return git.Prune(c, opts, heads)
+}
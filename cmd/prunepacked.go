@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Implements the git prune-packed command line parsing.
+func PrunePacked(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("prune-packed", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	opts := git.PrunePackedOptions{}
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "Print the commands that would be run instead of removing anything")
+	flags.BoolVar(&opts.DryRun, "n", false, "Alias of --dry-run")
+	// There is no progress to suppress, but -q is accepted for
+	// compatibility.
+	flags.Bool("quiet", false, "Do not show progress")
+	flags.Bool("q", false, "Alias of --quiet")
+
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return fmt.Errorf("Invalid usage of prune-packed")
+	}
+	return git.PrunePacked(c, opts)
+}
@@ -3,15 +3,38 @@ package cmd
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strings"
 
 	"github.com/driusan/dgit/git"
 )
 
+// A flag value for --force-with-lease, which may be given with or without
+// a value.
+type leaseValue struct {
+	all    *bool
+	leases *[]git.PushLease
+}
+
+func (l leaseValue) Set(val string) error {
+	if val == "true" {
+		*l.all = true
+		return nil
+	}
+	lease := git.PushLease{Ref: val}
+	if pos := strings.Index(val, ":"); pos >= 0 {
+		lease.Ref, lease.Expect = val[:pos], val[pos+1:]
+	}
+	*l.leases = append(*l.leases, lease)
+	return nil
+}
+
+func (l leaseValue) String() string { return "" }
+
+func (l leaseValue) IsBoolFlag() bool { return true }
+
 func Push(c *git.Client, args []string) error {
-	flags := flag.NewFlagSet("branch", flag.ExitOnError)
+	flags := flag.NewFlagSet("push", flag.ExitOnError)
 	flags.SetOutput(flag.CommandLine.Output())
 	flags.Usage = func() {
 		flag.Usage()
@@ -20,120 +43,74 @@ func Push(c *git.Client, args []string) error {
 	}
 
 	// These flags can be moved out of these lists and below as proper flags as they are implemented
-	for _, bf := range []string{"all", "mirror", "tags", "follow-tags", "atomic", "n", "dry-run", "f", "force", "delete", "prune", "v", "verbose", "u", "no-signed", "no-verify"} {
+	for _, bf := range []string{"all", "mirror", "follow-tags", "prune", "no-signed", "no-verify"} {
 		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
 	}
-	for _, sf := range []string{"receive-pack", "repo", "o", "push-option", "signed", "force-with-lease"} {
+	for _, sf := range []string{"repo", "o", "push-option", "signed"} {
 		flags.Var(newNotimplStringValue(), sf, "Not implemented")
 	}
 
-	setupstream := flags.String("set-upstream", "", "Sets the upstream remote for the branch")
+	opts := git.PushOptions{}
+	flags.BoolVar(&opts.Force, "force", false, "Allow updates which are not fast-forwards")
+	flags.BoolVar(&opts.Force, "f", false, "Alias of --force")
+	flags.Var(leaseValue{&opts.ForceWithLease, &opts.Leases}, "force-with-lease", "Only force updates if the remote ref is at the expected value (<ref>[:<expect>])")
+	flags.BoolVar(&opts.Delete, "delete", false, "Delete the named refs from the remote")
+	flags.BoolVar(&opts.Delete, "d", false, "Alias of --delete")
+	flags.BoolVar(&opts.Tags, "tags", false, "Push all tags")
+	flags.BoolVar(&opts.Atomic, "atomic", false, "Update either all refs on the remote or none of them")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "Do everything except send the updates")
+	flags.BoolVar(&opts.DryRun, "n", false, "Alias of --dry-run")
+	flags.BoolVar(&opts.Quiet, "quiet", false, "Only print errors")
+	flags.BoolVar(&opts.Quiet, "q", false, "Alias of --quiet")
+	flags.BoolVar(&opts.Verbose, "verbose", false, "Be more verbose")
+	flags.BoolVar(&opts.Verbose, "v", false, "Alias of --verbose")
+	flags.BoolVar(&opts.SetUpstream, "set-upstream", false, "Set the upstream of pushed branches")
+	flags.BoolVar(&opts.SetUpstream, "u", false, "Alias of --set-upstream")
+	flags.StringVar(&opts.ReceivePack, "receive-pack", "", "Path to git-receive-pack on the remote")
+	flags.StringVar(&opts.ReceivePack, "exec", "", "Alias of --receive-pack")
 
 	flags.Parse(args)
 
-	if flags.NArg() < 1 {
-		fmt.Fprintf(flag.CommandLine.Output(), "Missing repository to push")
-		flag.Usage()
-		os.Exit(2)
-	} else if flags.NArg() > 1 {
-		fmt.Fprintf(flag.CommandLine.Output(), "Providing a refspec is not currently implemented")
-		flag.Usage()
-		os.Exit(2)
-	}
-
-	bname := flags.Arg(0)
-	config, err := git.LoadLocalConfig(c)
-	if err != nil {
-		return err
-	}
-	if *setupstream != "" {
-		config.SetConfig(fmt.Sprintf("branch.%v.remote", bname), *setupstream)
-		config.SetConfig(fmt.Sprintf("branch.%v.merge", bname), fmt.Sprintf("refs/heads/%v", bname))
-		if err := config.WriteConfig(); err != nil {
-			return err
+	var remote git.Remote
+	var refs []git.RefSpec
+	switch flags.NArg() {
+	case 0:
+		remote = "origin"
+		if head := c.GetHeadBranch(); head != "" {
+			if r := c.GetConfig("branch." + head.BranchName() + ".remote"); r != "" {
+				remote = git.Remote(r)
+			}
 		}
-	}
-	remote, _ := config.GetConfig("branch." + bname + ".remote")
-	if remote == "" {
-		return fmt.Errorf(`The branch %v has no upstream set.
+	case 1:
+		remote = git.Remote(flags.Arg(0))
+		if _, err := remote.PushURL(c); err != nil {
+			// dgit used to be invoked as "dgit push branchname",
+			// so if the argument isn't a remote but is a branch
+			// push the branch to its upstream.
+			bname := flags.Arg(0)
+			if !git.Branch("refs/heads/" + bname).Exists(c) {
+				return err
+			}
+			r := c.GetConfig("branch." + bname + ".remote")
+			if r == "" {
+				return fmt.Errorf(`The branch %v has no upstream set.
 To push and set the upstream to the remote named "origin" use:
 
 	%v push --set-upstream origin %v
 
 `, bname, os.Args[0], bname)
-
-	}
-	mergebranch, _ := config.GetConfig("branch." + bname + ".merge")
-	mergebranch = strings.TrimSpace(mergebranch)
-	repoid, _ := config.GetConfig("remote." + remote + ".url")
-	println(remote, " on ", repoid)
-	var ups git.Uploadpack
-	if repoid[0:7] == "http://" || repoid[0:8] == "https://" {
-		ups = &git.SmartHTTPServerRetriever{Location: repoid,
-			C: c,
+			}
+			remote = git.Remote(r)
+			refs = []git.RefSpec{git.RefSpec(bname)}
+			if merge := c.GetConfig("branch." + bname + ".merge"); merge != "" {
+				refs[0] = git.RefSpec(bname + ":" + merge)
+			}
 		}
-	} else {
-		return fmt.Errorf("Unknown protocol.")
-	}
-
-	refs, err := ups.NegotiateSendPack()
-	if err != nil {
-		return err
-	}
-
-	localSha, _, err := RevParse(c, []string{flags.Arg(0)})
-	if err != nil {
-		return err
-	}
-	var remoteCommits []git.Commitish
-	var remoteHead git.CommitID
-	for _, ref := range refs {
-		trimmed := ref.Refname.String()
-		refsha, err := git.Sha1FromString(ref.Sha1)
-		if err != nil {
-			return err
-		}
-		if trimmed == mergebranch {
-			remoteHead = git.CommitID(refsha)
-		}
-		if have, _, err := c.HaveObject(refsha); have && err == nil {
-			remoteCommits = append(remoteCommits, git.CommitID(refsha))
+	default:
+		remote = git.Remote(flags.Arg(0))
+		for _, arg := range flags.Args()[1:] {
+			refs = append(refs, git.RefSpec(arg))
 		}
 	}
-	var objects strings.Builder
-	if _, err := git.RevList(c, git.RevListOptions{Objects: true}, &objects, []git.Commitish{localSha[0]}, remoteCommits); err != nil {
-		return err
-	}
-
-	f, err := ioutil.TempFile("", "sendpack")
-	if err != nil {
-		panic(err)
-	}
-	os.Remove(f.Name())
-	fmt.Fprintf(f, objects.String())
-
-	PackObjects(c, strings.NewReader(objects.String()), []string{f.Name()})
-	f, err = os.Open(f.Name() + ".pack")
-	if err != nil {
-		return err
-	}
-	defer os.Remove(f.Name())
-	stat, err := f.Stat()
-	if err != nil {
-		return err
-	}
-	ups.SendPack(git.UpdateReference{
-		LocalSha1:  localSha[0].Id.String(),
-		RemoteSha1: remoteHead.String(),
-		Refname:    git.RefSpec(mergebranch),
-	}, f, stat.Size())
-
-	// We don't do anything special for setupstream here, because it was saved above
-	if rmtname := c.GetConfig(fmt.Sprintf("branch.%v.remote", bname)); rmtname != "" {
-		rmtref := git.RefSpec(fmt.Sprintf("refs/remotes/%v/%v", rmtname, bname))
-		if git.UpdateRefSpec(c, git.UpdateRefOptions{}, rmtref, git.CommitID(localSha[0].Id), "update by push"); err != nil {
-			return err
-		}
-	}
-	return nil
+	return git.Push(c, opts, remote, refs)
 }
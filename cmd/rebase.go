@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Rebase(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("rebase", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	opts := git.RebaseOptions{}
+
+	onto := flags.String("onto", "", "Starting point at which to create the new commits")
+
+	flags.BoolVar(&opts.Interactive, "interactive", false, "Make a list of the commits to be rebased and let the user edit it")
+	flags.BoolVar(&opts.Interactive, "i", false, "Alias of --interactive")
+
+	flags.BoolVar(&opts.AutoSquash, "autosquash", c.GetConfig("rebase.autosquash") == "true", "Move fixup! and squash! commits after the commits they modify (only with --interactive)")
+	noautosquash := flags.Bool("no-autosquash", false, "Negate --autosquash")
+
+	flags.BoolVar(&opts.Continue, "continue", false, "Restart the rebasing process after having resolved a merge conflict")
+	flags.BoolVar(&opts.Skip, "skip", false, "Restart the rebasing process by skipping the current patch")
+	flags.BoolVar(&opts.Abort, "abort", false, "Abort the rebase operation and reset HEAD to the original branch")
+	flags.BoolVar(&opts.Quit, "quit", false, "Abort the rebase operation but leave HEAD and the work tree where they are")
+	flags.BoolVar(&opts.EditTodo, "edit-todo", false, "Edit the todo list during an interactive rebase")
+
+	flags.Parse(args)
+
+	if *noautosquash || !opts.Interactive {
+		opts.AutoSquash = false
+	}
+	if opts.Continue || opts.Skip || opts.Abort || opts.Quit || opts.EditTodo {
+		if flags.NArg() > 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		return git.Rebase(c, opts, nil, "")
+	}
+
+	if flags.NArg() > 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var upstream git.Commitish
+	if flags.NArg() > 0 {
+		cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("invalid upstream '%v'", flags.Arg(0))
+		}
+		upstream = cmt
+	}
+	if *onto != "" {
+		cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, *onto)
+		if err != nil {
+			return fmt.Errorf("Does not point to a valid commit '%v'", *onto)
+		}
+		opts.Onto = cmt
+	}
+	return git.Rebase(c, opts, upstream, flags.Arg(1))
+}
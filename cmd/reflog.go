@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -36,8 +37,23 @@ func Reflog(c *git.Client, args []string) error {
 	case "show", "delete":
 		return fmt.Errorf("reflog subcommand %v not implemented", subcmd)
 	case "expire":
-		// FIXME: parse and pass params
-		return git.ReflogExpire(c, git.ReflogExpireOptions{Expire: "now", All: true}, nil)
+		flags := flag.NewFlagSet("reflog expire", flag.ExitOnError)
+		flags.SetOutput(flag.CommandLine.Output())
+		flags.Usage = func() {
+			flag.Usage()
+			fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+			flags.PrintDefaults()
+		}
+		for _, bf := range []string{"dry-run", "n", "stale-fix", "rewrite", "updateref", "verbose"} {
+			flags.Var(newNotimplBoolValue(), bf, "Not implemented")
+		}
+
+		opts := git.ReflogExpireOptions{}
+		flags.StringVar(&opts.Expire, "expire", "", "Remove entries older than date (default gc.reflogExpire or 90.days.ago)")
+		flags.StringVar(&opts.ExpireUnreachable, "expire-unreachable", "", "Remove entries older than date which aren't reachable from the ref (default gc.reflogExpireUnreachable or 30.days.ago)")
+		flags.BoolVar(&opts.All, "all", false, "Expire the reflogs of all refs")
+		flags.Parse(args[1:])
+		return git.ReflogExpire(c, opts, flags.Args())
 	case "exists":
 		if len(args) != 2 {
 			return fmt.Errorf("usage: %v reflog exists <ref>", os.Args[0])
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Implements the git repack command line parsing.
+func Repack(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("repack", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	// These flags can be moved out of these lists and below as proper flags as they are implemented
+	for _, bf := range []string{"l", "F", "n", "b", "write-bitmap-index", "k", "keep-unreachable", "i", "delta-islands", "cruft", "geometric"} {
+		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
+	}
+	for _, sf := range []string{"window-memory", "max-pack-size", "keep-pack", "unpack-unreachable", "threads", "filter"} {
+		flags.Var(newNotimplStringValue(), sf, "Not implemented")
+	}
+
+	opts := git.RepackOptions{}
+	flags.BoolVar(&opts.All, "a", false, "Pack everything reachable into a single pack")
+	flags.BoolVar(&opts.LoosenUnreachable, "A", false, "Like -a, but make unreachable objects in old packs loose with -d")
+	flags.BoolVar(&opts.Delete, "d", false, "Remove redundant packs and loose objects after packing")
+	flags.BoolVar(&opts.NoReuseDelta, "f", false, "Do not reuse existing deltas")
+	flags.BoolVar(&opts.Quiet, "quiet", false, "Do not show progress")
+	flags.BoolVar(&opts.Quiet, "q", false, "Alias of --quiet")
+	flags.IntVar(&opts.Window, "window", 10, "Number of objects to consider as delta bases for each object")
+	flags.IntVar(&opts.Depth, "depth", 50, "Maximum length of delta chains")
+
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return fmt.Errorf("Invalid usage of repack")
+	}
+	return git.Repack(c, opts)
+}
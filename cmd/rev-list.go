@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/driusan/dgit/git"
 )
@@ -21,38 +22,108 @@ func RevList(c *git.Client, args []string) error {
 	flags.BoolVar(&opts.Objects, "objects", false, "include non-commit objects in output")
 	flags.BoolVar(&opts.Quiet, "quiet", false, "prevent printing of revisions")
 	flags.BoolVar(&opts.VerifyObjects, "verify-objects", false, "verify objects instead of printing them")
-	flags.BoolVar(&opts.All, "all", false, "pretend as if all refs were passed on the command line")
+	finishWalkFlags := addRevWalkFlags(flags, &opts)
 
-	flags.Parse(args)
-	args = flags.Args()
+	options, revargs := splitRevisionArgs(flags, args)
+	flags.Parse(options)
+	finishWalkFlags()
 	if opts.VerifyObjects {
 		opts.Objects = true
 	}
-	// First get a map of excluded commitIDs
-	var excludes []git.Commitish
-	var includes []git.Commitish
-	for _, rev := range args {
-		if rev == "" {
-			continue
-		}
-		if rev[0] == '^' && len(rev) > 1 {
-			commits, _, err := RevParse(c, []string{rev[1:]})
-			if err != nil {
-				return fmt.Errorf("%s:%v", rev, err)
-			}
-			for _, cmt := range commits {
-				excludes = append(excludes, cmt)
-			}
-		} else {
-			commits, _, err := RevParse(c, []string{rev})
-			if err != nil {
-				return fmt.Errorf("%s:%v", rev, err)
-			}
-			for _, cmt := range commits {
-				includes = append(includes, cmt)
-			}
-		}
+	revs, err := git.ParseRevisions(c, revargs)
+	if err != nil {
+		return err
+	}
+	if opts.Paths, err = revisionPaths(c, revs); err != nil {
+		return err
 	}
-	_, err := git.RevList(c, opts, os.Stdout, includes, excludes)
+	opts.Left, opts.Right = revs.Left, revs.Right
+	_, err = git.RevList(c, opts, os.Stdout, revs.Includes, revs.Excludes)
 	return err
 }
+
+// Adds the options which select and order the commits walked by rev-list
+// and log to flags. The returned function must be called after the flags
+// are parsed.
+func addRevWalkFlags(flags *flag.FlagSet, opts *git.RevListOptions) func() {
+	maxCount := -1
+	flags.IntVar(&maxCount, "n", -1, "Limit the number of commits.")
+	flags.IntVar(&maxCount, "max-count", -1, "Alias for -n")
+	flags.UintVar(&opts.Skip, "skip", 0, "Skip this many commits before starting to list them")
+
+	flags.BoolVar(&opts.TopoOrder, "topo-order", false, "Show no parents before their children, and avoid interleaving lines of history")
+	flags.BoolVar(&opts.DateOrder, "date-order", false, "Show no parents before their children, otherwise in commit timestamp order")
+	flags.BoolVar(&opts.Reverse, "reverse", false, "List the selected commits in reverse order")
+	flags.BoolVar(&opts.FirstParent, "first-parent", false, "Only follow the first parent of merge commits")
+
+	flags.StringVar(&opts.Since, "since", "", "Show commits more recent than a specific date")
+	flags.StringVar(&opts.Since, "after", "", "Alias of --since")
+	flags.StringVar(&opts.Until, "until", "", "Show commits older than a specific date")
+	flags.StringVar(&opts.Until, "before", "", "Alias of --until")
+	maxAge := flags.String("max-age", "", "Show commits more recent than a unix timestamp")
+	minAge := flags.String("min-age", "", "Show commits older than a unix timestamp")
+
+	flags.Var(NewMultiStringValue(&opts.Author), "author", "Only show commits with an author matching the pattern")
+	flags.Var(NewMultiStringValue(&opts.Committer), "committer", "Only show commits with a committer matching the pattern")
+	flags.Var(NewMultiStringValue(&opts.Grep), "grep", "Only show commits with a message matching the pattern")
+	flags.BoolVar(&opts.AllMatch, "all-match", false, "Only show commits which match all of the --grep patterns")
+	flags.BoolVar(&opts.InvertGrep, "invert-grep", false, "Only show commits which don't match the --grep patterns")
+	flags.BoolVar(&opts.RegexpIgnoreCase, "regexp-ignore-case", false, "Match patterns case insensitively")
+	flags.BoolVar(&opts.RegexpIgnoreCase, "i", false, "Alias of --regexp-ignore-case")
+
+	merges := flags.Bool("merges", false, "Only show merge commits")
+	noMerges := flags.Bool("no-merges", false, "Do not show merge commits")
+	flags.IntVar(&opts.MinParents, "min-parents", 0, "Only show commits with at least this many parents")
+	maxParents := flags.Int("max-parents", -1, "Only show commits with at most this many parents")
+
+	flags.BoolVar(&opts.FullHistory, "full-history", false, "Follow every parent of merges when limiting to paths")
+	flags.BoolVar(&opts.SimplifyMerges, "simplify-merges", false, "Hide merges which don't change the paths after simplifying their parents")
+
+	flags.BoolVar(&opts.LeftRight, "left-right", false, "Mark which side of a symmetric difference commits are from")
+	flags.BoolVar(&opts.LeftOnly, "left-only", false, "Only show commits from the left side of a symmetric difference")
+	flags.BoolVar(&opts.RightOnly, "right-only", false, "Only show commits from the right side of a symmetric difference")
+	flags.BoolVar(&opts.CherryMark, "cherry-mark", false, "Mark equivalent commits on both sides of a symmetric difference with =")
+	flags.BoolVar(&opts.CherryPick, "cherry-pick", false, "Omit equivalent commits on both sides of a symmetric difference")
+
+	return func() {
+		if maxCount >= 0 {
+			mc := uint(maxCount)
+			opts.MaxCount = &mc
+		}
+		// --max-age and --min-age are timestamps, which are
+		// parsed like the raw dates in commits.
+		if _, err := strconv.ParseInt(*maxAge, 10, 64); err == nil {
+			opts.Since = *maxAge + " +0000"
+		}
+		if _, err := strconv.ParseInt(*minAge, 10, 64); err == nil {
+			opts.Until = *minAge + " +0000"
+		}
+		if *merges {
+			opts.MinParents = 2
+		}
+		if *noMerges {
+			*maxParents = 1
+		}
+		if *maxParents >= 0 {
+			opts.MaxParents = maxParents
+		}
+	}
+}
+
+// Converts the paths in revs, which are relative to the current directory,
+// to paths relative to the root of the repository.
+func revisionPaths(c *git.Client, revs git.RevisionSet) ([]git.IndexPath, error) {
+	var paths []git.IndexPath
+	for _, p := range revs.Paths {
+		ip, err := p.IndexPath(c)
+		if err != nil {
+			return nil, err
+		}
+		if string(ip) == c.WorkDir.String() {
+			// The root of the work tree.
+			ip = ""
+		}
+		paths = append(paths, ip)
+	}
+	return paths, nil
+}
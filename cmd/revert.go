@@ -3,8 +3,6 @@ package cmd
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"os"
 
 	"github.com/driusan/dgit/git"
@@ -43,6 +41,7 @@ func Revert(c *git.Client, args []string) error {
 
 	// Sequencer subcommands
 	flags.BoolVar(&opts.Continue, "continue", false, "Continue the operation in progress using the information in .git/sequencer")
+	flags.BoolVar(&opts.Skip, "skip", false, "Skip the current commit and continue with the rest of the sequence")
 	flags.BoolVar(&opts.Quit, "quit", false, "Forget the current operation in progress.")
 	flags.BoolVar(&opts.Abort, "abort", false, "Cancel the operation and return to the pre-sequence state")
 
@@ -67,60 +66,21 @@ func Revert(c *git.Client, args []string) error {
 	if *X != "" {
 		opts.MergeStrategyOption = *X
 	}
-	cstrings := flags.Args()
-	commits := make([]git.Commitish, len(cstrings), len(cstrings))
-	for i := range cstrings {
-		com, err := git.RevParseCommitish(c, &git.RevParseOptions{}, cstrings[i])
-		if err != nil {
-			return err
-		}
-		commits[i] = com
 
+	if opts.Continue || opts.Skip || opts.Quit || opts.Abort {
+		if flags.NArg() > 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		return git.Revert(c, opts, nil)
 	}
-	if len(commits) < 0 {
+	if flags.NArg() == 0 {
 		flags.Usage()
 		os.Exit(2)
 	}
-	cid, err := commits[0].CommitID(c)
-	if err != nil {
-		return err
-	}
-
-	cmessage, err := cid.GetCommitMessage(c)
-	if err != nil {
-		return err
-	}
-
-	editmessage := fmt.Sprintf("Revert \"%v\"\n\nThis reverts commit %v\n", cmessage.Subject(), cid.String())
-
-	st, err := git.StatusLong(c, nil, git.StatusUntrackedAll, "# ")
+	commits, err := sequencerCommits(c, flags.Args(), false)
 	if err != nil {
 		return err
 	}
-	if err := c.GitDir.WriteFile("COMMIT_EDITMSG", []byte(editmessage+"\n"+st), 0660); err != nil {
-		return err
-	}
-	if err := c.ExecEditor(c.GitDir.File("COMMIT_EDITMSG")); err != nil {
-		log.Println(err)
-	}
-	message, err := ioutil.ReadFile(c.GitDir.File("COMMIT_EDITMSG").String())
-	if err != nil {
-		return err
-	}
-	if err := git.Revert(c, opts, commits); err != nil {
-		return err
-	}
-	if !opts.NoCommit {
-		if _, err := git.Commit(c, git.CommitOptions{
-			NoEdit:  !opts.Edit,
-			Signoff: opts.SignOff,
-			All:     true,
-		},
-			git.CommitMessage(message),
-			nil,
-		); err != nil {
-			return err
-		}
-	}
-	return nil
+	return git.Revert(c, opts, commits)
 }
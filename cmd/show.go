@@ -19,8 +19,9 @@ func Show(c *git.Client, args []string) error {
 	opts := git.ShowOptions{}
 	flags.Var(newAliasedStringValue((*string)(&opts.Format), ""), "format", "Print the contents of commit logs in a specified format")
 	flags.Var(newAliasedStringValue((*string)(&opts.Format), ""), "pretty", "Alias for --format")
-	flags.Parse(args)
-
-	objects := flags.Args()
+	objects, err := parseCommonDiffFlags(c, &opts.DiffCommonOptions, true, flags, args)
+	if err != nil {
+		return err
+	}
 	return git.Show(c, opts, objects)
 }
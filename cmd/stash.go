@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Stash(c *git.Client, args []string) error {
+	subcmd := "push"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		subcmd, args = args[0], args[1:]
+	}
+	switch subcmd {
+	case "push", "save":
+		flags := newFlagSet("stash-" + subcmd)
+		opts := git.StashPushOptions{}
+		flags.BoolVar(&opts.KeepIndex, "keep-index", false, "Leave the changes already added to the index intact")
+		flags.BoolVar(&opts.KeepIndex, "k", false, "Alias of --keep-index")
+		nokeepindex := flags.Bool("no-keep-index", false, "Negate --keep-index")
+		flags.BoolVar(&opts.IncludeUntracked, "include-untracked", false, "Stash untracked files too, and clean them up afterwards")
+		flags.BoolVar(&opts.IncludeUntracked, "u", false, "Alias of --include-untracked")
+		flags.BoolVar(&opts.All, "all", false, "Stash ignored and untracked files too, and clean them up afterwards")
+		flags.BoolVar(&opts.All, "a", false, "Alias of --all")
+		flags.BoolVar(&opts.Quiet, "quiet", false, "Suppress feedback messages")
+		flags.BoolVar(&opts.Quiet, "q", false, "Alias of --quiet")
+		flags.Var(newNotimplBoolValue(), "patch", "Not implemented")
+		flags.Var(newNotimplBoolValue(), "p", "Not implemented")
+		if subcmd == "push" {
+			flags.StringVar(&opts.Message, "message", "", "Use the given message to describe the stash entry")
+			flags.StringVar(&opts.Message, "m", "", "Alias of --message")
+		}
+		flags.Parse(args)
+		if *nokeepindex {
+			opts.KeepIndex = false
+		}
+
+		var files []git.File
+		if subcmd == "save" {
+			opts.Message = strings.Join(flags.Args(), " ")
+		} else {
+			for _, f := range flags.Args() {
+				files = append(files, git.File(f))
+			}
+		}
+		return git.StashPush(c, opts, files)
+	case "apply", "pop", "branch":
+		flags := newFlagSet("stash-" + subcmd)
+		opts := git.StashApplyOptions{}
+		flags.BoolVar(&opts.Quiet, "quiet", false, "Suppress feedback messages")
+		flags.BoolVar(&opts.Quiet, "q", false, "Alias of --quiet")
+		if subcmd != "branch" {
+			flags.BoolVar(&opts.Index, "index", false, "Restore the changes to the index as well as the working tree")
+		}
+		flags.Parse(args)
+		args = flags.Args()
+
+		if subcmd == "branch" {
+			if len(args) < 1 || len(args) > 2 {
+				flags.Usage()
+				os.Exit(2)
+			}
+			return git.StashBranch(c, opts, args[0], stashArg(flags, args[1:]))
+		}
+		if subcmd == "pop" {
+			return git.StashPop(c, opts, stashArg(flags, args))
+		}
+		return git.StashApply(c, opts, stashArg(flags, args))
+	case "drop":
+		flags := newFlagSet("stash-drop")
+		opts := git.StashDropOptions{}
+		flags.BoolVar(&opts.Quiet, "quiet", false, "Suppress feedback messages")
+		flags.BoolVar(&opts.Quiet, "q", false, "Alias of --quiet")
+		flags.Parse(args)
+		return git.StashDrop(c, opts, stashArg(flags, flags.Args()))
+	case "clear":
+		if len(args) > 0 {
+			return fmt.Errorf("git stash clear with arguments is unimplemented")
+		}
+		return git.StashClear(c)
+	case "list":
+		flags := newFlagSet("stash-list")
+		flags.Parse(args)
+		entries, err := git.StashList(c)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fmt.Printf("%v: %v\n", e.Name, e.Message)
+		}
+		return nil
+	case "show":
+		return stashShow(c, args)
+	default:
+		return fmt.Errorf("Invalid stash subcommand: %v", subcmd)
+	}
+}
+
+// Returns the stash entry named in args, or the empty string for the
+// newest entry.
+func stashArg(flags *flag.FlagSet, args []string) string {
+	switch len(args) {
+	case 0:
+		return ""
+	case 1:
+		return args[0]
+	}
+	fmt.Fprintf(os.Stderr, "Too many revisions specified: %v\n", strings.Join(args, " "))
+	flags.Usage()
+	os.Exit(2)
+	panic("unreachable")
+}
+
+func stashShow(c *git.Client, args []string) error {
+	flags := newFlagSet("stash-show")
+	opts := git.StashShowOptions{}
+
+	// -u means --include-untracked, not --patch, for stash show, so the
+	// untracked options are taken out before the diff options are
+	// parsed.
+	opts.IncludeUntracked = c.GetConfig("stash.showincludeuntracked") == "true"
+	var diffArgs []string
+	for _, a := range args {
+		switch a {
+		case "-u", "--include-untracked":
+			opts.IncludeUntracked = true
+		case "--no-include-untracked":
+			opts.IncludeUntracked = false
+		case "--only-untracked":
+			opts.OnlyUntracked = true
+		default:
+			diffArgs = append(diffArgs, a)
+		}
+	}
+
+	// With no diff options, the stash.showStat and stash.showPatch config
+	// decide what's shown.
+	hasOptions := false
+	for _, a := range diffArgs {
+		if strings.HasPrefix(a, "-") {
+			hasOptions = true
+		}
+	}
+	if !hasOptions {
+		var defaults []string
+		if c.GetConfig("stash.showstat") != "false" {
+			defaults = append(defaults, "--stat")
+		}
+		if c.GetConfig("stash.showpatch") == "true" {
+			defaults = append(defaults, "-p")
+		}
+		diffArgs = append(defaults, diffArgs...)
+	}
+
+	args, err := parseCommonDiffFlags(c, &opts.DiffCommonOptions, true, flags, diffArgs)
+	if err != nil {
+		return err
+	}
+	diffs, err := git.StashShow(c, opts, stashArg(flags, args))
+	if err != nil {
+		return err
+	}
+	return printDiffs(c, opts.DiffCommonOptions, diffs)
+}
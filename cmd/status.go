@@ -25,9 +25,10 @@ func Status(c *git.Client, args []string) error {
 	flags.BoolVar(&opts.Branch, "branch", false, "Short branch and tracking info, even in short mode")
 	flags.BoolVar(&opts.Branch, "b", false, "Alias of --branch")
 
-	flags.BoolVar(&opts.ShowStash, "show-stash", false, "Show the number of entries currently stashed")
+	flags.BoolVar(&opts.ShowStash, "show-stash", c.GetConfig("status.showstash") == "true", "Show the number of entries currently stashed")
+	noshowstash := flags.Bool("no-show-stash", false, "Negate --show-stash")
 
-	porcelain := flags.Int("porcelain", 0, "Give the output in a porcelain format")
+	porcelain := flags.String("porcelain", "", "Give the output in a porcelain format (v1 or v2)")
 
 	flags.BoolVar(&opts.Long, "long", true, "Give the output in long format")
 
@@ -40,7 +41,7 @@ func Status(c *git.Client, args []string) error {
 
 	ignoresubmodules := flags.String("ignore-submodules", "", "When to ignore submodules")
 
-	flags.BoolVar(&opts.Ignored, "ignored", false, "Show ignored files as well")
+	ignored := flags.String("ignored", "no", "Show ignored files as well (traditional, matching or no)")
 
 	flags.BoolVar(&opts.NullTerminate, "z", false, "Terminate entries with NULL, not LF. Implies --porcelain=v1 if not specified")
 
@@ -53,6 +54,9 @@ func Status(c *git.Client, args []string) error {
 		if a == "--porcelain" {
 			a = "--porcelain=1"
 		}
+		if a == "--ignored" {
+			a = "--ignored=traditional"
+		}
 		if a == "--ignore-submodules" {
 			a = "--ignore-submodules=all"
 		}
@@ -63,12 +67,31 @@ func Status(c *git.Client, args []string) error {
 	}
 
 	flags.Parse(adjustedArgs)
+	if *noshowstash {
+		opts.ShowStash = false
+	}
 
 	switch *porcelain {
-	case 0, 1, 2:
-		opts.Porcelain = uint8(*porcelain)
+	case "":
+	case "1", "v1":
+		opts.Porcelain = 1
+	case "2", "v2":
+		opts.Porcelain = 2
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "Invalid value for --porcelain, must be v1 or v2\n")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	switch *ignored {
+	case "no":
+		opts.Ignored = git.StatusIgnoredNo
+	case "traditional":
+		opts.Ignored = git.StatusIgnoredTraditional
+	case "matching":
+		opts.Ignored = git.StatusIgnoredMatching
 	default:
-		fmt.Fprintf(flag.CommandLine.Output(), "Invalid value for --porcelain, must be 0, 1 or 2\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Invalid value for --ignored, must be traditional, matching or no\n")
 		flags.Usage()
 		os.Exit(2)
 	}
@@ -22,7 +22,7 @@ func UpdateRef(c *git.Client, args []string) error {
 	reason := flags.String("m", "", "Reason to record in reflog for updating the reference")
 	flags.BoolVar(&opts.Delete, "d", false, "Delete the reference after verifying oldvalue")
 	flags.BoolVar(&opts.NoDeref, "no-deref", false, "Do not dereference symbolic references")
-	flags.BoolVar(&opts.NoDeref, "create-reflog", false, "Create a reflog if it doesn't exist")
+	flags.BoolVar(&opts.CreateReflog, "create-reflog", false, "Create a reflog if it doesn't exist")
 
 	stdin := flags.Bool("stdin", false, "Read references from stdin in batch mode")
 	flags.BoolVar(&opts.NullTerminate, "z", false, `Use \0 instead of \n to terminate lines in batch mode`)
@@ -31,7 +31,12 @@ func UpdateRef(c *git.Client, args []string) error {
 	vals := flags.Args()
 
 	if *stdin {
+		if len(vals) != 0 || opts.Delete {
+			flags.Usage()
+			os.Exit(2)
+		}
 		opts.Stdin = os.Stdin
+		return git.UpdateRef(c, opts, "", git.CommitID{}, *reason)
 	}
 
 	switch len(vals) {
@@ -92,26 +92,17 @@ func Var(c *git.Client, args []string) error {
 		os.Exit(1)
 	}
 
-	fname := c.GitDir.String() + "/config"
-
-	file, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-	config := git.ParseConfig(file)
-	err = file.Close()
-	if err != nil {
-		return err
-	}
-
 	switch {
 	case flags.NArg() == 1:
 		fmt.Printf("%s\n", getLogVar(c, flags.Arg(0), os.Getenv(flags.Arg(0))))
 		return nil
 	case *list:
-		list := config.GetConfigList()
-		for _, entry := range list {
-			fmt.Printf("%s\n", entry)
+		values, err := c.ConfigValues()
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			fmt.Printf("%s\n", v)
 		}
 		for _, logVar := range []string{"GIT_AUTHOR_IDENT", "GIT_COMMITTER_IDENT", "GIT_EDITOR", "GIT_PAGER"} {
 			fmt.Printf("%s=%s\n", logVar, getLogVar(c, logVar, os.Getenv(logVar)))
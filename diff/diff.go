@@ -0,0 +1,390 @@
+// Package diff implements line based diffing of text in pure Go.
+//
+// It supports the same algorithms as the canonical git implementation
+// ("myers", "minimal", "patience", and "histogram") and can generate
+// the hunks of a unified diff in memory, without invoking an external
+// diff tool.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// An Algorithm is the name of a diff algorithm, as would be passed to
+// git's --diff-algorithm option.
+type Algorithm string
+
+const (
+	// The default algorithm. Currently the same as Myers.
+	Default = Algorithm("default")
+
+	// The basic greedy diff algorithm. It will give up trying to find
+	// the smallest diff for very large inputs in order to save time.
+	Myers = Algorithm("myers")
+
+	// The Myers algorithm without any heuristics, which always produces
+	// the smallest possible diff.
+	Minimal = Algorithm("minimal")
+
+	// The patience diff algorithm, which anchors the diff on lines that
+	// are unique to both inputs.
+	Patience = Algorithm("patience")
+
+	// The histogram algorithm, which extends patience to anchor on low
+	// occurrence lines rather than only unique lines.
+	Histogram = Algorithm("histogram")
+)
+
+// ParseAlgorithm converts the string s into an Algorithm, returning an
+// error if it's not one of the supported values. The empty string is
+// treated as the default algorithm.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(s); a {
+	case "", Default:
+		return Default, nil
+	case Myers, Minimal, Patience, Histogram:
+		return a, nil
+	default:
+		return "", fmt.Errorf("Invalid diff algorithm: %v", s)
+	}
+}
+
+// An Op describes what happened to a Line in a diff. The value of each
+// Op is the prefix character used for the line in unified diff format.
+type Op byte
+
+const (
+	Equal  = Op(' ')
+	Delete = Op('-')
+	Insert = Op('+')
+)
+
+// A Line represents a single line of a diff.
+type Line struct {
+	Op Op
+
+	// The content of the line, including the trailing newline if
+	// there is one.
+	Text string
+}
+
+// Lines splits the content of a file into lines. Each line retains its
+// trailing newline, so that a missing newline at the end of a file is
+// considered to be a change.
+func Lines(content []byte) []string {
+	var lines []string
+	for len(content) > 0 {
+		i := bytes.IndexByte(content, '\n')
+		if i < 0 {
+			lines = append(lines, string(content))
+			break
+		}
+		lines = append(lines, string(content[:i+1]))
+		content = content[i+1:]
+	}
+	return lines
+}
+
+// IsBinary returns true if content looks like binary data rather than
+// text, using the same heuristic as git (a NUL byte in the first 8000
+// bytes.)
+func IsBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// Whitespace is a set of flags describing which changes to whitespace
+// are ignored when comparing lines.
+type Whitespace uint8
+
+const (
+	// Ignore whitespace at the end of lines.
+	IgnoreSpaceAtEOL = Whitespace(1 << iota)
+
+	// Ignore changes in the amount of whitespace. Runs of whitespace
+	// are equal to each other, and whitespace at the end of lines is
+	// ignored.
+	IgnoreSpaceChange
+
+	// Ignore all whitespace.
+	IgnoreAllSpace
+
+	// Don't show changes which only add or remove blank lines, unless
+	// they're near other changes. This only affects the hunks, not the
+	// comparison of lines.
+	IgnoreBlankLines
+)
+
+// The characters which are whitespace in the C locale.
+const space = " \t\n\v\f\r"
+
+// Returns the text that line is compared by when ignoring the whitespace
+// in ws.
+func (ws Whitespace) key(line string) string {
+	switch {
+	case ws&IgnoreAllSpace != 0:
+		return strings.Map(func(r rune) rune {
+			if strings.ContainsRune(space, r) {
+				return -1
+			}
+			return r
+		}, line)
+	case ws&IgnoreSpaceChange != 0:
+		key := strings.Join(strings.FieldsFunc(line, func(r rune) bool {
+			return strings.ContainsRune(space, r)
+		}), " ")
+		if key != "" && strings.ContainsRune(space, rune(line[0])) {
+			key = " " + key
+		}
+		return key
+	case ws&IgnoreSpaceAtEOL != 0:
+		return strings.TrimRight(line, space)
+	}
+	return line
+}
+
+// Diff calculates the differences between the lines a and b using the
+// algorithm alg and returns the full script of lines, including lines
+// that are unchanged.
+func Diff(alg Algorithm, a, b []string) []Line {
+	return DiffWhitespace(alg, 0, a, b)
+}
+
+// DiffWhitespace is like Diff, but lines which only differ in the
+// whitespace described by ws are considered to be unchanged. As with git,
+// the text of unchanged lines in the script comes from b.
+func DiffWhitespace(alg Algorithm, ws Whitespace, a, b []string) []Line {
+	d := newDiffer(a, b, ws)
+	d.run(alg)
+	return d.script()
+}
+
+// A differ holds the state of a comparison between two sets of lines.
+//
+// Lines are interned into integers so that comparisons in the hot loops of
+// the algorithms are cheap, and the result of the algorithms is recorded in
+// the changed slices, which has an entry for each line which is true if the
+// line was removed (for a) or added (for b.)
+type differ struct {
+	linesA, linesB []string
+
+	a, b []int
+
+	changedA, changedB []bool
+}
+
+func newDiffer(a, b []string, ws Whitespace) *differ {
+	d := &differ{
+		linesA:   a,
+		linesB:   b,
+		a:        make([]int, len(a)),
+		b:        make([]int, len(b)),
+		changedA: make([]bool, len(a)),
+		changedB: make([]bool, len(b)),
+	}
+	ids := make(map[string]int)
+	intern := func(s string) int {
+		s = ws.key(s)
+		if id, ok := ids[s]; ok {
+			return id
+		}
+		id := len(ids)
+		ids[s] = id
+		return id
+	}
+	for i, l := range a {
+		d.a[i] = intern(l)
+	}
+	for i, l := range b {
+		d.b[i] = intern(l)
+	}
+	return d
+}
+
+func (d *differ) run(alg Algorithm) {
+	switch alg {
+	case Patience:
+		d.patience(0, len(d.a), 0, len(d.b))
+	case Histogram:
+		d.histogram(0, len(d.a), 0, len(d.b))
+	case Minimal:
+		d.myers(0, len(d.a), 0, len(d.b), true)
+	default:
+		d.myers(0, len(d.a), 0, len(d.b), false)
+	}
+	d.compact(d.a, d.changedA, d.changedB)
+	d.compact(d.b, d.changedB, d.changedA)
+}
+
+// Marks the lines in a[aLo:aHi] as deleted and b[bLo:bHi] as inserted.
+func (d *differ) markChanged(aLo, aHi, bLo, bHi int) {
+	for i := aLo; i < aHi; i++ {
+		d.changedA[i] = true
+	}
+	for i := bLo; i < bHi; i++ {
+		d.changedB[i] = true
+	}
+}
+
+// Converts the changed lines into an edit script. Deletions are always
+// before insertions for any given change.
+func (d *differ) script() []Line {
+	lines := make([]Line, 0, len(d.a)+len(d.b))
+	i, j := 0, 0
+	for i < len(d.a) || j < len(d.b) {
+		switch {
+		case i < len(d.a) && d.changedA[i]:
+			lines = append(lines, Line{Delete, d.linesA[i]})
+			i++
+		case j < len(d.b) && d.changedB[j]:
+			lines = append(lines, Line{Insert, d.linesB[j]})
+			j++
+		default:
+			lines = append(lines, Line{Equal, d.linesB[j]})
+			i++
+			j++
+		}
+	}
+	return lines
+}
+
+// compact slides groups of changes in lines (with the changed slice
+// changed) to make the diff more readable. This is the same algorithm as
+// git's xdl_change_compact without any indent heuristic: a group of changes
+// is slid down as far as it can go, unless it can be lined up with a group
+// of changes in the other file, which is tracked by otherChanged.
+//
+// For instance, adding a function after an existing function might have
+// the diff algorithm find the closing brace of the existing function as
+// the last line added, but compacting it will make it the closing brace of
+// the new function.
+func (d *differ) compact(lines []int, changed, otherChanged []bool) {
+	g := newGroup(changed)
+	og := newGroup(otherChanged)
+
+	for {
+		if g.end != g.start {
+			var earliestEnd, endMatchingOther, groupSize int
+			for {
+				groupSize = g.end - g.start
+				endMatchingOther = -1
+
+				// Shift the group up as far as possible.
+				for g.slideUp(lines) {
+					og.previous()
+				}
+				earliestEnd = g.end
+				if og.end > og.start {
+					endMatchingOther = g.end
+				}
+
+				// Then down as far as possible, keeping
+				// track of where it lined up with a change in
+				// the other file.
+				for g.slideDown(lines) {
+					og.next()
+					if og.end > og.start {
+						endMatchingOther = g.end
+					}
+				}
+
+				// If the group merged with another group while
+				// sliding, go through it again.
+				if groupSize == g.end-g.start {
+					break
+				}
+			}
+
+			if g.end != earliestEnd && endMatchingOther != -1 {
+				// Move it back up to line up with the change in
+				// the other file.
+				for og.end == og.start {
+					g.slideUp(lines)
+					og.previous()
+				}
+			}
+		}
+		if !g.next() {
+			break
+		}
+		og.next()
+	}
+}
+
+// A group is a run of changed lines [start, end) in a set of lines. Every
+// unchanged line is separated by a (possibly empty) group, so groups in the
+// two files being compared map one to one with each other.
+type group struct {
+	changed    []bool
+	start, end int
+}
+
+func newGroup(changed []bool) *group {
+	g := &group{changed: changed}
+	for g.end < len(changed) && changed[g.end] {
+		g.end++
+	}
+	return g
+}
+
+// Moves to the next group, returning false if there is none.
+func (g *group) next() bool {
+	if g.end == len(g.changed) {
+		return false
+	}
+	g.start = g.end + 1
+	g.end = g.start
+	for g.end < len(g.changed) && g.changed[g.end] {
+		g.end++
+	}
+	return true
+}
+
+// Moves to the previous group, returning false if there is none.
+func (g *group) previous() bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	g.start = g.end
+	for g.start > 0 && g.changed[g.start-1] {
+		g.start--
+	}
+	return true
+}
+
+// Slides the group up by one line if the line before it is the same as the
+// last line of the group, merging with any group that it runs into.
+func (g *group) slideUp(lines []int) bool {
+	if g.start > 0 && lines[g.start-1] == lines[g.end-1] {
+		g.start--
+		g.end--
+		g.changed[g.start] = true
+		g.changed[g.end] = false
+		for g.start > 0 && g.changed[g.start-1] {
+			g.start--
+		}
+		return true
+	}
+	return false
+}
+
+// Slides the group down by one line if the line after it is the same as the
+// first line of the group, merging with any group that it runs into.
+func (g *group) slideDown(lines []int) bool {
+	if g.end < len(lines) && lines[g.start] == lines[g.end] {
+		g.changed[g.start] = false
+		g.changed[g.end] = true
+		g.start++
+		g.end++
+		for g.end < len(lines) && g.changed[g.end] {
+			g.end++
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+var algorithms = []Algorithm{Myers, Minimal, Patience, Histogram}
+
+// Reconstructs the source and destination from a script, so that we can
+// verify that the script is a valid diff.
+func reconstruct(script []Line) (src, dst string) {
+	var s, d strings.Builder
+	for _, l := range script {
+		switch l.Op {
+		case Equal:
+			s.WriteString(l.Text)
+			d.WriteString(l.Text)
+		case Delete:
+			s.WriteString(l.Text)
+		case Insert:
+			d.WriteString(l.Text)
+		}
+	}
+	return s.String(), d.String()
+}
+
+// TestDiffScript tests that every algorithm produces a script which can
+// reconstruct both inputs.
+func TestDiffScript(t *testing.T) {
+	tests := []struct {
+		src, dst string
+		changes  int
+	}{
+		{"", "", 0},
+		{"foo\n", "foo\n", 0},
+		{"", "foo\nbar\n", 2},
+		{"foo\nbar\n", "", 2},
+		{"foo\n", "bar\n", 2},
+		{"foo\n", "foo", 2},
+		{"a\nb\nc\nd\n", "a\nc\nd\ne\n", 2},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+		{"x\ny\nz\nx\ny\nz\n", "z\ny\nx\nz\ny\nx\n", 6},
+	}
+	for i, tc := range tests {
+		for _, alg := range algorithms {
+			script := Diff(alg, Lines([]byte(tc.src)), Lines([]byte(tc.dst)))
+			src, dst := reconstruct(script)
+			if src != tc.src || dst != tc.dst {
+				t.Errorf("Case %d (%v): script reconstructed (%q, %q) want (%q, %q)", i, alg, src, dst, tc.src, tc.dst)
+			}
+			var changes int
+			for _, l := range script {
+				if l.Op != Equal {
+					changes++
+				}
+			}
+			if alg == Minimal && changes != tc.changes {
+				t.Errorf("Case %d: got %d changes, want %d", i, changes, tc.changes)
+			}
+		}
+	}
+}
+
+// TestUnified tests the unified diff output of hunks.
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		src, dst string
+		context  int
+		want     string
+	}{
+		{"foo\n", "bar\n", 3, "@@ -1 +1 @@\n-foo\n+bar\n"},
+		{"", "foo\nbar\n", 3, "@@ -0,0 +1,2 @@\n+foo\n+bar\n"},
+		{"foo\nbar\n", "", 3, "@@ -1,2 +0,0 @@\n-foo\n-bar\n"},
+		{"foo\n", "foo", 3, "@@ -1 +1 @@\n-foo\n+foo\n\\ No newline at end of file\n"},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"1\n2\n3\nfour\n5\n6\n7\n8\n9\n10\n",
+			3,
+			"@@ -1,7 +1,7 @@\n 1\n 2\n 3\n-4\n+four\n 5\n 6\n 7\n",
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"1\n2\n3\n4\n5\n7\n8\n9\n10\n",
+			0,
+			"@@ -6 +5,0 @@\n-6\n",
+		},
+		{
+			// Changes separated by more than 2*context lines
+			// are separate hunks.
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			2,
+			"@@ -1,3 +1,3 @@\n-1\n+one\n 2\n 3\n@@ -8,3 +8,3 @@\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			// But not if they're within 2*context lines.
+			"1\n2\n3\n4\n5\n6\n",
+			"one\n2\n3\n4\n5\nsix\n",
+			2,
+			"@@ -1,6 +1,6 @@\n-1\n+one\n 2\n 3\n 4\n 5\n-6\n+six\n",
+		},
+		{
+			// Added blocks are slid down so that the closing
+			// brace is part of the new block.
+			"{\n}\n",
+			"{\n}\n{\n}\n",
+			0,
+			"@@ -2,0 +3,2 @@\n+{\n+}\n",
+		},
+	}
+	for i, tc := range tests {
+		for _, alg := range algorithms {
+			var got strings.Builder
+			for _, h := range Unified(alg, []byte(tc.src), []byte(tc.dst), tc.context) {
+				h.WriteTo(&got)
+			}
+			if got.String() != tc.want {
+				t.Errorf("Case %d (%v): got %q want %q", i, alg, got.String(), tc.want)
+			}
+		}
+	}
+}
+
+// TestPatienceAnchors tests that the patience algorithm anchors on unique
+// lines rather than the common braces that Myers matches.
+func TestPatienceAnchors(t *testing.T) {
+	src := "func a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 2\n}\n"
+	dst := "func b() {\n\treturn 2\n}\n\nfunc a() {\n\treturn 1\n}\n"
+	script := Diff(Patience, Lines([]byte(src)), Lines([]byte(dst)))
+	for _, l := range script {
+		if l.Text == "func b() {\n" && l.Op != Equal {
+			t.Errorf("Expected unique line to be used as an anchor, got %q", l.Op)
+		}
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, s := range []string{"", "default", "myers", "minimal", "patience", "histogram"} {
+		if _, err := ParseAlgorithm(s); err != nil {
+			t.Errorf("Unexpected error for %q: %v", s, err)
+		}
+	}
+	if _, err := ParseAlgorithm("bogus"); err == nil {
+		t.Error("Expected error for invalid algorithm")
+	}
+}
+
+// TestWhitespace tests that lines which only differ in ignored whitespace
+// are unchanged, and take their text from the destination.
+func TestWhitespace(t *testing.T) {
+	tests := []struct {
+		ws       Whitespace
+		src, dst string
+		equal    bool
+	}{
+		{0, "a b\n", "a  b\n", false},
+		{IgnoreSpaceAtEOL, "a b\n", "a b  \n", true},
+		{IgnoreSpaceAtEOL, "a b\n", "a  b\n", false},
+		{IgnoreSpaceChange, "a b\n", "a \t b \n", true},
+		{IgnoreSpaceChange, "a b\n", "ab\n", false},
+		{IgnoreSpaceChange, " a\n", "a\n", false},
+		{IgnoreSpaceChange, "  \n", "\n", true},
+		{IgnoreAllSpace, "a b\n", "ab\n", true},
+		{IgnoreAllSpace, " a\n", "a\n", true},
+	}
+	for i, tc := range tests {
+		script := DiffWhitespace(Myers, tc.ws, Lines([]byte(tc.src)), Lines([]byte(tc.dst)))
+		equal := len(script) == 1 && script[0].Op == Equal
+		if equal != tc.equal {
+			t.Errorf("Case %d: got %v want equal %v", i, script, tc.equal)
+		}
+		if equal && script[0].Text != tc.dst {
+			t.Errorf("Case %d: got text %q want %q", i, script[0].Text, tc.dst)
+		}
+	}
+}
+
+// TestIgnoreBlankLines tests that changes which only add or remove blank
+// lines are left out of hunks unless they're next to other changes.
+func TestIgnoreBlankLines(t *testing.T) {
+	tests := []struct {
+		src, dst string
+		ws       Whitespace
+		want     string
+	}{
+		{"1\n2\n3\n4\n5\n6\n7\n8\n", "1\n\n2\n3\n4\n5\n6\n7\n8\n", 0, ""},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"1\n\n2\n3\n4\n5\n6\n7\neight\n",
+			0,
+			"@@ -5,4 +6,4 @@\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{
+			// Blank changes within the context of another change
+			// are part of the hunk.
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"1\n2\n3\n4\n5\n6\n\n7\neight\n",
+			0,
+			"@@ -4,5 +4,6 @@\n 4\n 5\n 6\n+\n 7\n-8\n+eight\n",
+		},
+		{
+			// Lines of whitespace are only blank if whitespace
+			// is being ignored.
+			"1\n2\n3\n4\n",
+			"1\n2\n  \n3\n4\n",
+			0,
+			"@@ -1,4 +1,5 @@\n 1\n 2\n+  \n 3\n 4\n",
+		},
+		{"1\n2\n3\n4\n", "1\n2\n  \n3\n4\n", IgnoreAllSpace, ""},
+	}
+	for i, tc := range tests {
+		var got strings.Builder
+		script := DiffWhitespace(Myers, tc.ws, Lines([]byte(tc.src)), Lines([]byte(tc.dst)))
+		for _, h := range HunksIgnoringBlankLines(script, 3, tc.ws) {
+			h.WriteTo(&got)
+		}
+		if got.String() != tc.want {
+			t.Errorf("Case %d: got %q want %q", i, got.String(), tc.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package diff
+
+// Lines which occur more often than this in a range are not considered
+// as candidates for the histogram algorithm to split on.
+const maxChainLength = 64
+
+// histogram marks the changes between a[aLo:aHi] and b[bLo:bHi] using the
+// histogram diff algorithm, as described by JGit's HistogramDiff.
+//
+// It's an extension of the patience algorithm. It builds a histogram of how
+// often each line occurs in a, then finds the longest common region of
+// lines between a and b that contains the least frequently occurring line.
+// The region is used to split the range in two, and both sides are diffed
+// recursively. If every line in common is too common to be a useful split
+// point, it falls back on the Myers algorithm.
+func (d *differ) histogram(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+	if aLo == aHi || bLo == bHi {
+		d.markChanged(aLo, aHi, bLo, bHi)
+		return
+	}
+
+	// The positions in a of each line.
+	positions := make(map[int][]int)
+	for i := aLo; i < aHi; i++ {
+		positions[d.a[i]] = append(positions[d.a[i]], i)
+	}
+
+	var (
+		found, tooCommon      bool
+		bestA, bestB, bestLen int
+		bestCount             = maxChainLength + 1
+		regionBEnd            int
+	)
+	for j := bLo; j < bHi; j = regionBEnd {
+		regionBEnd = j + 1
+		candidates := positions[d.b[j]]
+		if len(candidates) == 0 {
+			continue
+		}
+		if len(candidates) > maxChainLength {
+			tooCommon = true
+			continue
+		}
+		for _, i := range candidates {
+			// Expand the region around (i, j) in both directions,
+			// keeping track of the lowest occurrence count of any
+			// line in it.
+			count := len(candidates)
+			as, bs := i, j
+			for as > aLo && bs > bLo && d.a[as-1] == d.b[bs-1] {
+				as--
+				bs--
+				if c := len(positions[d.a[as]]); c < count {
+					count = c
+				}
+			}
+			ae, be := i+1, j+1
+			for ae < aHi && be < bHi && d.a[ae] == d.b[be] {
+				if c := len(positions[d.a[ae]]); c < count {
+					count = c
+				}
+				ae++
+				be++
+			}
+
+			if count < bestCount || (count == bestCount && ae-as > bestLen) {
+				found = true
+				bestA, bestB, bestLen, bestCount = as, bs, ae-as, count
+			}
+			// Skip over the region in b, since anything in it
+			// would find the same region.
+			if be > regionBEnd {
+				regionBEnd = be
+			}
+		}
+	}
+
+	if !found {
+		if tooCommon {
+			d.myers(aLo, aHi, bLo, bHi, false)
+		} else {
+			// There's nothing in common at all.
+			d.markChanged(aLo, aHi, bLo, bHi)
+		}
+		return
+	}
+
+	d.histogram(aLo, bestA, bLo, bestB)
+	d.histogram(bestA+bestLen, aHi, bestB+bestLen, bHi)
+}
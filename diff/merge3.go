@@ -0,0 +1,445 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A ConflictStyle determines how conflicts are written in the output of a
+// three-way merge.
+type ConflictStyle string
+
+const (
+	// Conflicts show our version and their version, with any lines
+	// that are common to both moved out of the conflict.
+	StyleMerge = ConflictStyle("merge")
+
+	// Conflicts show our version, the original version, and their
+	// version.
+	StyleDiff3 = ConflictStyle("diff3")
+
+	// Like diff3, but lines at the start or end of the conflict which
+	// are the same in both our version and their version are moved out
+	// of the conflict.
+	StyleZealousDiff3 = ConflictStyle("zdiff3")
+)
+
+// ParseConflictStyle converts the string s into a ConflictStyle, as would
+// be passed to git's merge.conflictstyle configuration. The empty string is
+// treated as StyleMerge.
+func ParseConflictStyle(s string) (ConflictStyle, error) {
+	switch cs := ConflictStyle(s); cs {
+	case "":
+		return StyleMerge, nil
+	case StyleMerge, StyleDiff3, StyleZealousDiff3:
+		return cs, nil
+	default:
+		return "", fmt.Errorf("Invalid conflict style: %v", s)
+	}
+}
+
+// A Favor determines how conflicts are resolved automatically in a
+// three-way merge.
+type Favor uint8
+
+const (
+	// Conflicts are not resolved and are written with conflict markers.
+	FavorNone = Favor(iota)
+
+	// Conflicts are resolved by using our version.
+	FavorOurs
+
+	// Conflicts are resolved by using their version.
+	FavorTheirs
+
+	// Conflicts are resolved by using both our version and their
+	// version, in that order.
+	FavorUnion
+)
+
+// The default length of conflict markers.
+const DefaultMarkerSize = 7
+
+// Merge3Options are the options that may be passed to Merge3.
+type Merge3Options struct {
+	// The algorithm used to diff each side against the base.
+	Algorithm Algorithm
+
+	Style ConflictStyle
+	Favor Favor
+
+	// The length of conflict markers. If 0, DefaultMarkerSize is used.
+	MarkerSize int
+
+	// The labels written after the conflict markers for each version.
+	OursLabel, BaseLabel, TheirsLabel string
+}
+
+// A Conflict is a region of a three-way merge that could not be merged
+// automatically.
+type Conflict struct {
+	// The line number (1 indexed) of the conflict's opening marker in
+	// the merged output.
+	Line int
+
+	// The lines of each version in the conflicting region. Base is
+	// only populated for the diff3 and zdiff3 styles.
+	Ours, Base, Theirs []string
+}
+
+// The result of a three-way merge.
+type Merge3Result struct {
+	// The merged content, including conflict markers for any conflicts.
+	Content []byte
+
+	// The conflicts that were written to Content. If this is empty, the
+	// merge was clean.
+	Conflicts []Conflict
+}
+
+// Merge3 merges the changes that lead from base to theirs into ours. Regions
+// which were changed in only one version take the changed version, and
+// regions which were changed differently in both are conflicts unless
+// opts.Favor says how to resolve them.
+func Merge3(base, ours, theirs []byte, opts Merge3Options) Merge3Result {
+	if opts.MarkerSize <= 0 {
+		opts.MarkerSize = DefaultMarkerSize
+	}
+	if opts.Style == "" {
+		opts.Style = StyleMerge
+	}
+	b, o, t := Lines(base), Lines(ours), Lines(theirs)
+	chunks := merge3Chunks(opts.Algorithm, b, o, t)
+	switch opts.Style {
+	case StyleMerge:
+		chunks = simplifyConflicts(refineConflicts(opts.Algorithm, chunks))
+	case StyleZealousDiff3:
+		chunks = trimConflicts(chunks)
+	}
+
+	var w merge3Writer
+	w.opts = opts
+	for _, c := range chunks {
+		w.write(c)
+	}
+	return Merge3Result{Content: []byte(w.out.String()), Conflicts: w.conflicts}
+}
+
+// A merge3Chunk is either a region which was resolved (if conflict is false,
+// in which case the resolution is in ours) or a conflict.
+type merge3Chunk struct {
+	conflict bool
+
+	// True if the chunk is the same in all versions (or, after refining
+	// conflicts, the same in ours and theirs.)
+	unchanged bool
+
+	ours, base, theirs []string
+}
+
+// A change is a range of lines in a base which was replaced by a range of
+// lines in another version.
+type change struct {
+	baseLo, baseHi int
+	lo, hi         int
+}
+
+// changes returns the changed regions between base and other.
+func changes(alg Algorithm, base, other []string) []change {
+	var cs []change
+	var bi, oi int
+	var cur *change
+	for _, l := range Diff(alg, base, other) {
+		if l.Op == Equal {
+			if cur != nil {
+				cs = append(cs, *cur)
+				cur = nil
+			}
+			bi++
+			oi++
+			continue
+		}
+		if cur == nil {
+			cur = &change{bi, bi, oi, oi}
+		}
+		if l.Op == Delete {
+			bi++
+			cur.baseHi = bi
+		} else {
+			oi++
+			cur.hi = oi
+		}
+	}
+	if cur != nil {
+		cs = append(cs, *cur)
+	}
+	return cs
+}
+
+// merge3Chunks divides the three versions into chunks which are either
+// cleanly merged or in conflict.
+func merge3Chunks(alg Algorithm, base, ours, theirs []string) []merge3Chunk {
+	oc, tc := changes(alg, base, ours), changes(alg, base, theirs)
+
+	var chunks []merge3Chunk
+	// The position in base up to which chunks have been generated.
+	var basePos int
+	// The offset of each version's line numbers from base's, outside of
+	// changed regions.
+	var oOff, tOff int
+	for len(oc) > 0 || len(tc) > 0 {
+		// Find the group of changes from either side which overlap,
+		// starting from the earliest one. Changes which touch each
+		// other are considered overlapping.
+		var lo, hi int
+		switch {
+		case len(tc) == 0 || (len(oc) > 0 && oc[0].baseLo <= tc[0].baseLo):
+			lo, hi = oc[0].baseLo, oc[0].baseHi
+		default:
+			lo, hi = tc[0].baseLo, tc[0].baseHi
+		}
+		var oGroup, tGroup []change
+		for {
+			if len(oc) > 0 && oc[0].baseLo <= hi {
+				if oc[0].baseHi > hi {
+					hi = oc[0].baseHi
+				}
+				oGroup = append(oGroup, oc[0])
+				oc = oc[1:]
+				continue
+			}
+			if len(tc) > 0 && tc[0].baseLo <= hi {
+				if tc[0].baseHi > hi {
+					hi = tc[0].baseHi
+				}
+				tGroup = append(tGroup, tc[0])
+				tc = tc[1:]
+				continue
+			}
+			break
+		}
+
+		if basePos < lo {
+			chunks = append(chunks, merge3Chunk{unchanged: true, ours: base[basePos:lo]})
+		}
+
+		oLo, oHi := groupRange(oGroup, lo, hi, oOff)
+		tLo, tHi := groupRange(tGroup, lo, hi, tOff)
+		oOff, tOff = oHi-hi, tHi-hi
+
+		chunk := merge3Chunk{
+			ours:   ours[oLo:oHi],
+			base:   base[lo:hi],
+			theirs: theirs[tLo:tHi],
+		}
+		switch {
+		case len(tGroup) == 0:
+			// Only changed in ours.
+		case len(oGroup) == 0:
+			chunk.ours = chunk.theirs
+		case linesEqual(chunk.ours, chunk.theirs):
+			// Changed the same way in both.
+		default:
+			chunk.conflict = true
+		}
+		chunks = append(chunks, chunk)
+		basePos = hi
+	}
+	if basePos < len(base) {
+		chunks = append(chunks, merge3Chunk{unchanged: true, ours: base[basePos:]})
+	}
+	return chunks
+}
+
+// groupRange returns the range of lines in a version which corresponds to
+// base[lo:hi], given the changes to that version which lie in the range and
+// the offset of line numbers before the range.
+func groupRange(group []change, lo, hi, off int) (int, int) {
+	if len(group) == 0 {
+		return lo + off, hi + off
+	}
+	first, last := group[0], group[len(group)-1]
+	return first.lo - (first.baseLo - lo), last.hi + (hi - last.baseHi)
+}
+
+func linesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// trimConflicts moves any lines at the start or end of a conflict which are
+// the same in ours and theirs out of the conflict.
+func trimConflicts(chunks []merge3Chunk) []merge3Chunk {
+	var trimmed []merge3Chunk
+	for _, c := range chunks {
+		if !c.conflict {
+			trimmed = append(trimmed, c)
+			continue
+		}
+		var pre int
+		for pre < len(c.ours) && pre < len(c.theirs) && c.ours[pre] == c.theirs[pre] {
+			pre++
+		}
+		var suf int
+		for suf < len(c.ours)-pre && suf < len(c.theirs)-pre && c.ours[len(c.ours)-suf-1] == c.theirs[len(c.theirs)-suf-1] {
+			suf++
+		}
+		if pre > 0 {
+			trimmed = append(trimmed, merge3Chunk{unchanged: true, ours: c.ours[:pre]})
+		}
+		trimmed = append(trimmed, merge3Chunk{
+			conflict: true,
+			ours:     c.ours[pre : len(c.ours)-suf],
+			base:     c.base,
+			theirs:   c.theirs[pre : len(c.theirs)-suf],
+		})
+		if suf > 0 {
+			trimmed = append(trimmed, merge3Chunk{unchanged: true, ours: c.ours[len(c.ours)-suf:]})
+		}
+	}
+	return trimmed
+}
+
+// refineConflicts diffs ours against theirs in each conflict and splits the
+// conflict up around any lines which they have in common.
+func refineConflicts(alg Algorithm, chunks []merge3Chunk) []merge3Chunk {
+	var refined []merge3Chunk
+	for _, c := range chunks {
+		if !c.conflict {
+			refined = append(refined, c)
+			continue
+		}
+		// changes treats ours as the base, so baseLo and baseHi
+		// are lines in ours and lo and hi are lines in theirs.
+		var oi int
+		for _, ch := range changes(alg, c.ours, c.theirs) {
+			if oi < ch.baseLo {
+				refined = append(refined, merge3Chunk{unchanged: true, ours: c.ours[oi:ch.baseLo]})
+			}
+			refined = append(refined, merge3Chunk{
+				conflict: true,
+				ours:     c.ours[ch.baseLo:ch.baseHi],
+				theirs:   c.theirs[ch.lo:ch.hi],
+			})
+			oi = ch.baseHi
+		}
+		if oi < len(c.ours) {
+			refined = append(refined, merge3Chunk{unchanged: true, ours: c.ours[oi:]})
+		}
+	}
+	return refined
+}
+
+// simplifyConflicts joins conflicts back together when they're separated
+// by only a few unchanged lines or by lines without any letters or numbers
+// in them (such as blank lines or closing braces), since splitting them
+// is more confusing than helpful.
+func simplifyConflicts(chunks []merge3Chunk) []merge3Chunk {
+	var simplified []merge3Chunk
+	for i := 0; i < len(chunks); i++ {
+		c := chunks[i]
+		if c.unchanged && i > 0 && i < len(chunks)-1 && chunks[i-1].conflict && chunks[i+1].conflict {
+			if len(c.ours) <= 3 || !hasAlnum(c.ours) {
+				prev := &simplified[len(simplified)-1]
+				next := chunks[i+1]
+				prev.ours = concatLines(prev.ours, c.ours, next.ours)
+				prev.base = concatLines(prev.base, c.ours, next.base)
+				prev.theirs = concatLines(prev.theirs, c.ours, next.theirs)
+				i++
+				continue
+			}
+		}
+		simplified = append(simplified, c)
+	}
+	return simplified
+}
+
+func concatLines(slices ...[]string) []string {
+	var lines []string
+	for _, s := range slices {
+		lines = append(lines, s...)
+	}
+	return lines
+}
+
+func hasAlnum(lines []string) bool {
+	for _, l := range lines {
+		for _, r := range l {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// merge3Writer writes the chunks of a merge, keeping track of the conflicts
+// and line numbers.
+type merge3Writer struct {
+	opts      Merge3Options
+	out       strings.Builder
+	line      int
+	conflicts []Conflict
+}
+
+func (w *merge3Writer) write(c merge3Chunk) {
+	if !c.conflict {
+		w.lines(c.ours, false)
+		return
+	}
+	switch w.opts.Favor {
+	case FavorOurs:
+		w.lines(c.ours, false)
+		return
+	case FavorTheirs:
+		w.lines(c.theirs, false)
+		return
+	case FavorUnion:
+		w.lines(c.ours, true)
+		w.lines(c.theirs, false)
+		return
+	}
+
+	conflict := Conflict{Line: w.line + 1, Ours: c.ours, Theirs: c.theirs}
+	w.marker('<', w.opts.OursLabel)
+	w.lines(c.ours, true)
+	if w.opts.Style != StyleMerge {
+		conflict.Base = c.base
+		w.marker('|', w.opts.BaseLabel)
+		w.lines(c.base, true)
+	}
+	w.marker('=', "")
+	w.lines(c.theirs, true)
+	w.marker('>', w.opts.TheirsLabel)
+	w.conflicts = append(w.conflicts, conflict)
+}
+
+// lines writes lines to the output. If terminate is true, a newline is added
+// to the final line if it doesn't have one, so that whatever follows it
+// starts on a new line.
+func (w *merge3Writer) lines(lines []string, terminate bool) {
+	for _, l := range lines {
+		w.out.WriteString(l)
+		w.line++
+	}
+	if terminate && len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		w.out.WriteByte('\n')
+	}
+}
+
+func (w *merge3Writer) marker(c byte, label string) {
+	w.out.WriteString(strings.Repeat(string(c), w.opts.MarkerSize))
+	if label != "" {
+		w.out.WriteString(" " + label)
+	}
+	w.out.WriteByte('\n')
+	w.line++
+}
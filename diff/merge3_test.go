@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		base, ours, theirs string
+		opts               Merge3Options
+		want               string
+		conflicts          int
+	}{
+		// Changes in only one side are taken.
+		{"a\nb\nc\n", "a\nB\nc\n", "a\nb\nc\n", Merge3Options{}, "a\nB\nc\n", 0},
+		{"a\nb\nc\n", "a\nb\nc\n", "a\nb\nC\n", Merge3Options{}, "a\nb\nC\n", 0},
+		// Non-overlapping changes on both sides merge cleanly.
+		{"a\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", Merge3Options{}, "A\nb\nc\nd\nE\n", 0},
+		// The same change on both sides is not a conflict.
+		{"a\nb\nc\n", "a\nX\nc\n", "a\nX\nc\n", Merge3Options{}, "a\nX\nc\n", 0},
+		{
+			"a\nb\nc\n", "a\nX\nc\n", "a\nY\nc\n",
+			Merge3Options{OursLabel: "ours", TheirsLabel: "theirs"},
+			"a\n<<<<<<< ours\nX\n=======\nY\n>>>>>>> theirs\nc\n",
+			1,
+		},
+		{
+			"a\nb\nc\n", "a\nX\nc\n", "a\nY\nc\n",
+			Merge3Options{Style: StyleDiff3, MarkerSize: 3, OursLabel: "ours", BaseLabel: "base", TheirsLabel: "theirs"},
+			"a\n<<< ours\nX\n||| base\nb\n===\nY\n>>> theirs\nc\n",
+			1,
+		},
+		// The merge style moves common lines out of the conflict, but
+		// diff3 doesn't and zdiff3 only does at the edges.
+		{
+			"a\nb\nc\n", "a\nX\nsame\nZ\nc\n", "a\nX\nsame\nW\nc\n",
+			Merge3Options{},
+			"a\nX\nsame\n<<<<<<<\nZ\n=======\nW\n>>>>>>>\nc\n",
+			1,
+		},
+		{
+			"a\nb\nc\n", "a\nX\nsame\nZ\nc\n", "a\nX\nsame\nW\nc\n",
+			Merge3Options{Style: StyleDiff3},
+			"a\n<<<<<<<\nX\nsame\nZ\n|||||||\nb\n=======\nX\nsame\nW\n>>>>>>>\nc\n",
+			1,
+		},
+		{
+			"a\nb\nc\n", "a\nX\nsame\nZ\nc\n", "a\nX\nsame\nW\nc\n",
+			Merge3Options{Style: StyleZealousDiff3},
+			"a\nX\nsame\n<<<<<<<\nZ\n|||||||\nb\n=======\nW\n>>>>>>>\nc\n",
+			1,
+		},
+		// Conflicts resolved by favouring a side.
+		{"a\nb\nc\n", "a\nX\nc\n", "a\nY\nc\n", Merge3Options{Favor: FavorOurs}, "a\nX\nc\n", 0},
+		{"a\nb\nc\n", "a\nX\nc\n", "a\nY\nc\n", Merge3Options{Favor: FavorTheirs}, "a\nY\nc\n", 0},
+		{"a\nb\nc\n", "a\nX\nc\n", "a\nY\nc\n", Merge3Options{Favor: FavorUnion}, "a\nX\nY\nc\n", 0},
+		// Missing newlines at the end of a side don't break the markers.
+		{"a\n", "X", "Y", Merge3Options{}, "<<<<<<<\nX\n=======\nY\n>>>>>>>\n", 1},
+	}
+	for i, tc := range tests {
+		result := Merge3([]byte(tc.base), []byte(tc.ours), []byte(tc.theirs), tc.opts)
+		if got := string(result.Content); got != tc.want {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.want)
+		}
+		if len(result.Conflicts) != tc.conflicts {
+			t.Errorf("Case %d: got %d conflicts want %d", i, len(result.Conflicts), tc.conflicts)
+		}
+	}
+}
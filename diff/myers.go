@@ -0,0 +1,168 @@
+package diff
+
+// The minimum number of edits that the Myers algorithm will try before it
+// gives up on finding the optimal split point of a large input, unless
+// minimal is requested.
+const minMaxCost = 256
+
+// myers marks the changes between a[aLo:aHi] and b[bLo:bHi] using the
+// linear space variation of Eugene Myers' O(ND) algorithm described in "An
+// O(ND) Difference Algorithm and Its Variations". It recursively finds the
+// middle snake of the optimal path through the edit graph and divides the
+// problem in two around it.
+//
+// If minimal is false, it will stop searching for the optimal split point
+// once the cost exceeds a threshold based on the size of the input and use
+// the furthest reaching path found instead, trading the size of the diff for
+// speed.
+func (d *differ) myers(aLo, aHi, bLo, bHi int, minimal bool) {
+	// Strip any common prefix or suffix, there's no need to search
+	// for them.
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	if aLo == aHi || bLo == bHi {
+		d.markChanged(aLo, aHi, bLo, bHi)
+		return
+	}
+	x, y, ok := d.split(aLo, aHi, bLo, bHi, minimal)
+	if !ok || (x == aLo && y == bLo) || (x == aHi && y == bHi) {
+		// There's nothing in common at all, or at least nothing
+		// that we could find.
+		d.markChanged(aLo, aHi, bLo, bHi)
+		return
+	}
+	d.myers(aLo, x, bLo, y, minimal)
+	d.myers(x, aHi, y, bHi, minimal)
+}
+
+// split finds the point at which the range should be divided. The range
+// must not have a common prefix or suffix and neither side may be empty,
+// which guarantees that the edit distance is at least 2 and that the
+// point divides the range into two strictly smaller problems.
+//
+// ok is false if no split point could be found.
+func (d *differ) split(aLo, aHi, bLo, bHi int, minimal bool) (x, y int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	maxCost := (n + m + 1) / 2
+	if !minimal {
+		limit := 1
+		for limit*limit < n+m {
+			limit++
+		}
+		if limit < minMaxCost {
+			limit = minMaxCost
+		}
+		if limit < maxCost {
+			maxCost = limit
+		}
+	}
+
+	// fwd and bwd hold the furthest reaching x value on each diagonal
+	// k = x - y, offset by off so that they're never negative. For the
+	// backwards search, x and y are distances from (aHi, bHi). -1 means
+	// the diagonal hasn't been reached.
+	off := maxCost + 1
+	fwd := make([]int, 2*off+1)
+	bwd := make([]int, 2*off+1)
+	for i := range fwd {
+		fwd[i] = -1
+		bwd[i] = -1
+	}
+	fwd[off+1] = 0
+	bwd[off+1] = 0
+
+	delta := n - m
+	odd := delta&1 != 0
+
+	// Returns true if diagonal k of v has been reached and is inside
+	// the edit graph.
+	valid := func(v []int, k int) bool {
+		if k+off < 0 || k+off >= len(v) || v[off+k] < 0 {
+			return false
+		}
+		x := v[off+k]
+		y := x - k
+		return x <= n && y >= 0 && y <= m
+	}
+
+	// Diagonals that went off the edge of the edit graph are trimmed
+	// from the search.
+	var fStart, fEnd, bStart, bEnd int
+
+	bestX, bestY, best := 0, 0, -1
+	for cost := 0; cost <= maxCost; cost++ {
+		for k := -cost + fStart; k <= cost-fEnd; k += 2 {
+			var x int
+			if k == -cost || (k != cost && fwd[off+k-1] < fwd[off+k+1]) {
+				x = fwd[off+k+1]
+			} else {
+				x = fwd[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			fwd[off+k] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			default:
+				if x+y > best && x+y < n+m {
+					bestX, bestY, best = x, y, x+y
+				}
+				if bk := delta - k; odd && valid(bwd, bk) {
+					if x >= n-bwd[off+bk] {
+						return aLo + x, bLo + y, true
+					}
+				}
+			}
+		}
+
+		for k := -cost + bStart; k <= cost-bEnd; k += 2 {
+			var x int
+			if k == -cost || (k != cost && bwd[off+k-1] < bwd[off+k+1]) {
+				x = bwd[off+k+1]
+			} else {
+				x = bwd[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aHi-x-1] == d.b[bHi-y-1] {
+				x++
+				y++
+			}
+			bwd[off+k] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			default:
+				if x+y > best && x+y < n+m {
+					bestX, bestY, best = n-x, m-y, x+y
+				}
+				if fk := delta - k; !odd && valid(fwd, fk) {
+					if fx := fwd[off+fk]; fx >= n-x {
+						return aLo + fx, bLo + fx - fk, true
+					}
+				}
+			}
+		}
+	}
+
+	// We gave up on finding the optimal path, so split at whichever
+	// point made it the furthest through the edit graph.
+	if best <= 0 || (bestX == 0 && bestY == 0) || (bestX == n && bestY == m) {
+		return 0, 0, false
+	}
+	return aLo + bestX, bLo + bestY, true
+}
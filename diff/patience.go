@@ -0,0 +1,113 @@
+package diff
+
+import (
+	"sort"
+)
+
+// A patience anchor is a line which appears exactly once in both ranges
+// being compared.
+type anchor struct {
+	a, b int
+}
+
+// patience marks the changes between a[aLo:aHi] and b[bLo:bHi] using the
+// patience diff algorithm. It finds the lines which are unique to both
+// sides, takes the longest common subsequence of them, and uses that as
+// anchors to recursively diff the ranges in between. If there are no unique
+// lines in common, it falls back on the Myers algorithm.
+func (d *differ) patience(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+	if aLo == aHi || bLo == bHi {
+		d.markChanged(aLo, aHi, bLo, bHi)
+		return
+	}
+
+	anchors := d.uniqueCommonLines(aLo, aHi, bLo, bHi)
+	if len(anchors) == 0 {
+		d.myers(aLo, aHi, bLo, bHi, false)
+		return
+	}
+
+	for _, anchor := range anchors {
+		d.patience(aLo, anchor.a, bLo, anchor.b)
+		aLo, bLo = anchor.a+1, anchor.b+1
+	}
+	d.patience(aLo, aHi, bLo, bHi)
+}
+
+// uniqueCommonLines returns the longest sequence of lines that are unique
+// in both ranges, ordered by their position in each.
+func (d *differ) uniqueCommonLines(aLo, aHi, bLo, bHi int) []anchor {
+	type occurrence struct {
+		countA, countB int
+		a, b           int
+	}
+	lines := make(map[int]*occurrence)
+	for i := aLo; i < aHi; i++ {
+		o, ok := lines[d.a[i]]
+		if !ok {
+			o = &occurrence{}
+			lines[d.a[i]] = o
+		}
+		o.countA++
+		o.a = i
+	}
+	for i := bLo; i < bHi; i++ {
+		o, ok := lines[d.b[i]]
+		if !ok {
+			// Not in a, so it can't be in common.
+			continue
+		}
+		o.countB++
+		o.b = i
+	}
+
+	var unique []anchor
+	for _, o := range lines {
+		if o.countA == 1 && o.countB == 1 {
+			unique = append(unique, anchor{o.a, o.b})
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i].a < unique[j].a })
+	return longestIncreasing(unique)
+}
+
+// longestIncreasing finds the longest subsequence of anchors (which must be
+// sorted by a) that is also increasing in b, using patience sorting.
+func longestIncreasing(anchors []anchor) []anchor {
+	if len(anchors) == 0 {
+		return nil
+	}
+	// piles holds the index of the top of each pile, and prev the index
+	// of the top of the previous pile when each anchor was placed.
+	piles := make([]int, 0, len(anchors))
+	prev := make([]int, len(anchors))
+	for i, an := range anchors {
+		pile := sort.Search(len(piles), func(p int) bool {
+			return anchors[piles[p]].b > an.b
+		})
+		if pile > 0 {
+			prev[i] = piles[pile-1]
+		} else {
+			prev[i] = -1
+		}
+		if pile == len(piles) {
+			piles = append(piles, i)
+		} else {
+			piles[pile] = i
+		}
+	}
+
+	lis := make([]anchor, len(piles))
+	for i, p := len(piles)-1, piles[len(piles)-1]; i >= 0; i, p = i-1, prev[p] {
+		lis[i] = anchors[p]
+	}
+	return lis
+}
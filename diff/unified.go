@@ -0,0 +1,240 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Hunk is a group of changed lines with its surrounding context in a
+// unified diff.
+type Hunk struct {
+	// The line number (1 indexed) and number of lines that the hunk
+	// covers in the source and destination. If the number of lines is
+	// 0, the line number is the line after which the change occurs.
+	SrcStart, SrcLines int
+	DstStart, DstLines int
+
+	Lines []Line
+}
+
+// Header returns the "@@ -a,b +c,d @@" line which introduces the hunk in
+// a unified diff, without a trailing newline. As with POSIX diff, line
+// counts of 1 are omitted.
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%v +%v @@", hunkRange(h.SrcStart, h.SrcLines), hunkRange(h.DstStart, h.DstLines))
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// WriteTo writes the hunk in unified diff format to w.
+func (h Hunk) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	written, err := fmt.Fprintf(w, "%v\n", h.Header())
+	n += int64(written)
+	if err != nil {
+		return n, err
+	}
+	for _, l := range h.Lines {
+		written, err := fmt.Fprintf(w, "%c%s", l.Op, l.Text)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+		if !strings.HasSuffix(l.Text, "\n") {
+			written, err := fmt.Fprint(w, "\n\\ No newline at end of file\n")
+			n += int64(written)
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (h Hunk) String() string {
+	var s strings.Builder
+	h.WriteTo(&s)
+	return s.String()
+}
+
+// Hunks groups the changes from a script returned by Diff into hunks with
+// context lines of unchanged text around them. Changes which are within
+// 2*context lines of each other are combined into the same hunk.
+func Hunks(script []Line, context int) []Hunk {
+	return hunks(script, context, nil)
+}
+
+// HunksIgnoringBlankLines is like Hunks, but changes which only add or
+// remove blank lines are left out unless they're within context lines of
+// another change, like git's --ignore-blank-lines. Blank lines are empty,
+// or only whitespace if ws ignores any whitespace.
+func HunksIgnoringBlankLines(script []Line, context int, ws Whitespace) []Hunk {
+	blank := func(line string) bool {
+		return line == "\n" || line == ""
+	}
+	if ws&(IgnoreSpaceAtEOL|IgnoreSpaceChange|IgnoreAllSpace) != 0 {
+		blank = func(line string) bool {
+			return strings.Trim(line, space) == ""
+		}
+	}
+	return hunks(script, context, blank)
+}
+
+// An edit is a run of changed lines script[start:end] in a script.
+type edit struct {
+	start, end int
+
+	// Set if the edit doesn't need to be shown, because it only adds
+	// or removes blank lines.
+	ignore bool
+}
+
+// Groups the script into hunks. If blank isn't nil, changes where it's
+// true for every line are ignorable.
+func hunks(script []Line, context int, blank func(string) bool) []Hunk {
+	if context < 0 {
+		context = 0
+	}
+
+	// The (0 indexed) line number in the source and destination of
+	// each line of the script, with an extra entry for the end.
+	srcLine := make([]int, len(script)+1)
+	dstLine := make([]int, len(script)+1)
+	var edits []edit
+	for i, l := range script {
+		srcLine[i+1], dstLine[i+1] = srcLine[i], dstLine[i]
+		if l.Op != Insert {
+			srcLine[i+1]++
+		}
+		if l.Op != Delete {
+			dstLine[i+1]++
+		}
+		if l.Op == Equal {
+			continue
+		}
+		if len(edits) == 0 || edits[len(edits)-1].end != i {
+			edits = append(edits, edit{start: i, ignore: blank != nil})
+		}
+		e := &edits[len(edits)-1]
+		e.end = i + 1
+		if e.ignore && !blank(l.Text) {
+			e.ignore = false
+		}
+	}
+
+	var hunks []Hunk
+	prevEnd := 0
+	for len(edits) > 0 {
+		first, last := nextHunk(edits, context)
+		if first == len(edits) {
+			break
+		}
+
+		// Add the context around the changes, without overlapping
+		// the previous hunk.
+		start, end := edits[first].start, edits[last].end
+		for i := 0; i < context && start > prevEnd && script[start-1].Op == Equal; i++ {
+			start--
+		}
+		for i := 0; i < context && end < len(script) && script[end].Op == Equal; i++ {
+			end++
+		}
+		h := Hunk{
+			SrcStart: srcLine[start] + 1,
+			SrcLines: srcLine[end] - srcLine[start],
+			DstStart: dstLine[start] + 1,
+			DstLines: dstLine[end] - dstLine[start],
+			Lines:    append([]Line(nil), script[start:end]...),
+		}
+
+		// Empty ranges refer to the line before the change, rather
+		// than the line of the change.
+		if h.SrcLines == 0 {
+			h.SrcStart--
+		}
+		if h.DstLines == 0 {
+			h.DstStart--
+		}
+		hunks = append(hunks, h)
+		prevEnd = end
+		edits = edits[last+1:]
+	}
+	return hunks
+}
+
+// Finds the edits which make up the next hunk, returning the index of the
+// first and last edit in it. Ignorable edits are skipped at the start of
+// the hunk, and only included in it if they're close enough to other edits,
+// the same way as git's xdl_get_hunk. If there are only ignorable edits,
+// first is len(edits).
+func nextHunk(edits []edit, context int) (first, last int) {
+	maxCommon := 2 * context
+	maxIgnorable := context
+
+	// The number of lines in the script between two edits.
+	distance := func(a, b int) int {
+		return edits[b].start - edits[a].end
+	}
+
+	// Skip ignorable edits which are too far before the next edit.
+	for i := 0; i < len(edits) && edits[i].ignore; i++ {
+		if i+1 == len(edits) || distance(i, i+1) >= maxIgnorable {
+			first = i + 1
+		}
+	}
+	if first == len(edits) {
+		return first, first
+	}
+
+	last = first
+	for prev, i := first, first+1; i < len(edits); prev, i = i, i+1 {
+		d := distance(prev, i)
+		switch {
+		case d > maxCommon:
+			return first, last
+		case d < maxIgnorable && (!edits[i].ignore || last == prev):
+			last = i
+		case d < maxIgnorable:
+			// An ignorable edit which isn't next to the last
+			// edit of the hunk is only part of it if a later
+			// edit is.
+		case last != prev && distance(last, i) > maxCommon:
+			// The distance in the script includes the lines
+			// inserted by the ignored edits in between.
+			return first, last
+		case !edits[i].ignore:
+			last = i
+		}
+	}
+	return first, last
+}
+
+// Unified calculates the diff between src and dst using the algorithm alg
+// and returns the hunks of a unified diff with context lines of context.
+func Unified(alg Algorithm, src, dst []byte, context int) []Hunk {
+	return Hunks(Diff(alg, Lines(src), Lines(dst)), context)
+}
+
+// WriteUnified writes the hunks of a unified diff to w, preceded by the
+// "---" and "+++" lines naming the source and destination. Nothing is
+// written if there are no hunks.
+func WriteUnified(w io.Writer, srcName, dstName string, hunks []Hunk) error {
+	if len(hunks) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "--- %v\n+++ %v\n", srcName, dstName); err != nil {
+		return err
+	}
+	for _, h := range hunks {
+		if _, err := h.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,12 +1,15 @@
 package git
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -65,23 +68,56 @@ func Apply(c *Client, opts ApplyOptions, patches []File) error {
 	}
 
 	// First pass, parse the patches to figure out which files are involved
+	// and separate the binary patches, which are applied here, from the
+	// text patches, which are applied by patch.
 	files := make(map[IndexPath]bool)
+	var textPatches []string
+	var headers []patchFileHeader
+	var binaries []binaryFilePatch
 	for _, patch := range patches {
-		patch, err := ioutil.ReadFile(patch.String())
+		content, err := ioutil.ReadFile(patch.String())
 		if err != nil {
 			return err
 		}
-		hunks, err := splitPatch(string(patch), true)
+		hunks, err := splitPatch(string(content), true)
 		if err != nil {
 			return err
 		}
 		for _, hunk := range hunks {
 			files[hunk.File] = true
 		}
+
+		text, hdrs, bins, err := splitBinaryPatches(string(content), opts.Reverse)
+		if err != nil {
+			return err
+		}
+		headers = append(headers, hdrs...)
+		binaries = append(binaries, bins...)
+		if text == "" {
+			continue
+		}
+		if text == string(content) {
+			textPatches = append(textPatches, patch.String())
+			continue
+		}
+		textPatch, err := ioutil.TempFile("", "gitapplytext")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(textPatch.Name())
+		if _, err := textPatch.WriteString(text); err != nil {
+			textPatch.Close()
+			return err
+		}
+		if err := textPatch.Close(); err != nil {
+			return err
+		}
+		textPatches = append(textPatches, textPatch.Name())
 	}
 
 	// Copy all of the files. We do this in a second pass to avoid
-	// needlessly recopying the same files multiple times.
+	// needlessly recopying the same files multiple times. Files which
+	// don't exist yet are created by the patch.
 	var idx *Index
 	if opts.Index {
 		idx2, err := c.GitDir.ReadIndex()
@@ -90,6 +126,7 @@ func Apply(c *Client, opts ApplyOptions, patches []File) error {
 		}
 		idx = idx2
 	}
+	copied := make(map[IndexPath]bool)
 	for file := range files {
 		f, err := file.FilePath(c)
 		if err != nil {
@@ -98,14 +135,35 @@ func Apply(c *Client, opts ApplyOptions, patches []File) error {
 
 		dst := patchdir + "/" + file.String()
 		if opts.Cached {
+			if idx.GetSha1(file) == (Sha1{}) {
+				continue
+			}
 			if err := copyFromIndex(c, idx, file, dst); err != nil {
 				return err
 			}
 		} else {
+			if !f.Exists() {
+				continue
+			}
 			if err := copyFile(f.String(), dst); err != nil {
 				return err
 			}
 		}
+		copied[file] = true
+	}
+
+	if err := applyRenames(patchdir, headers); err != nil {
+		return err
+	}
+	for _, b := range binaries {
+		if opts.Reverse {
+			if b, err = b.reversed(); err != nil {
+				return err
+			}
+		}
+		if err := b.apply(c, patchdir); err != nil {
+			return err
+		}
 	}
 
 	var patchDirection string
@@ -114,24 +172,394 @@ func Apply(c *Client, opts ApplyOptions, patches []File) error {
 	} else {
 		patchDirection = "-N"
 	}
-	for _, patch := range patches {
-		patchcmd := exec.Command(posixPatch, "--directory", patchdir, "-i", patch.String(), patchDirection, "-p1", "-F", "0")
+	for _, patch := range textPatches {
+		patchcmd := exec.Command(posixPatch, "--directory", patchdir, "-i", patch, patchDirection, "-p1", "-F", "0")
 		patchcmd.Stderr = os.Stderr
 		_, err := patchcmd.Output()
 		if err != nil {
 			return err
 		}
 	}
+	if err := applyModes(patchdir, headers); err != nil {
+		return err
+	}
+
+	// Files which the patches removed are deleted.
+	var deleted []IndexPath
+	for file := range copied {
+		if !File(filepath.Join(patchdir, file.String())).Exists() {
+			deleted = append(deleted, file)
+		}
+	}
+	if !opts.Cached {
+		if err := copyApplyDir(c, patchdir); err != nil {
+			return err
+		}
+		for _, name := range deleted {
+			f, err := name.FilePath(c)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(f.String()); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
 	if opts.Index {
-		if err := updateApplyIndex(c, idx, patchdir); err != nil {
+		return updateApplyIndex(c, idx, patchdir, deleted, opts.Cached)
+	}
+	return nil
+}
+
+// The "diff --git" line and extended header lines of the part of a patch
+// which changes one file.
+type patchFileHeader struct {
+	// The names of the file before and after the patch. They're only
+	// different if the file is renamed or copied.
+	oldName, newName IndexPath
+
+	renamed, copied bool
+
+	// The similarity index of a rename or copy, as a percentage.
+	similarity int
+
+	// The modes of the file before and after the patch, or 0 if the
+	// patch doesn't change the mode.
+	oldMode, newMode EntryMode
+
+	newFile, deleted bool
+}
+
+// Parses the header of section, which is the part of a patch for one file,
+// where a and b are the names on its "diff --git" line.
+func parsePatchFileHeader(a, b IndexPath, section string) (patchFileHeader, error) {
+	h := patchFileHeader{oldName: a, newName: b}
+	parseMode := func(line, prefix string) (EntryMode, error) {
+		mode, err := ModeFromString(strings.TrimSpace(line[len(prefix):]))
+		if err != nil {
+			return 0, fmt.Errorf("invalid mode on line: %v", line)
+		}
+		return mode, nil
+	}
+	var err error
+	lines := strings.Split(section, "\n")
+headerLoop:
+	for _, line := range lines[1:] {
+		switch {
+		case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "@@ "),
+			strings.HasPrefix(line, "Binary files "), line == "GIT binary patch":
+			break headerLoop
+		case strings.HasPrefix(line, "old mode "):
+			h.oldMode, err = parseMode(line, "old mode ")
+		case strings.HasPrefix(line, "new mode "):
+			h.newMode, err = parseMode(line, "new mode ")
+		case strings.HasPrefix(line, "new file mode "):
+			h.newFile = true
+			h.newMode, err = parseMode(line, "new file mode ")
+		case strings.HasPrefix(line, "deleted file mode "):
+			h.deleted = true
+			h.oldMode, err = parseMode(line, "deleted file mode ")
+		case strings.HasPrefix(line, "rename from "):
+			h.renamed = true
+			h.oldName = IndexPath(line[len("rename from "):])
+		case strings.HasPrefix(line, "rename to "):
+			h.renamed = true
+			h.newName = IndexPath(line[len("rename to "):])
+		case strings.HasPrefix(line, "copy from "):
+			h.copied = true
+			h.oldName = IndexPath(line[len("copy from "):])
+		case strings.HasPrefix(line, "copy to "):
+			h.copied = true
+			h.newName = IndexPath(line[len("copy to "):])
+		case strings.HasPrefix(line, "similarity index "), strings.HasPrefix(line, "dissimilarity index "):
+			pct := strings.TrimSuffix(line[strings.LastIndex(line, " ")+1:], "%")
+			if h.similarity, err = strconv.Atoi(pct); err != nil {
+				return h, fmt.Errorf("invalid similarity index: %v", line)
+			}
+			if strings.HasPrefix(line, "dissimilarity") {
+				h.similarity = 100 - h.similarity
+			}
+		}
+		if err != nil {
+			return h, err
+		}
+	}
+	if h.oldName != h.newName && !h.renamed && !h.copied {
+		return h, fmt.Errorf("Filenames do not match")
+	}
+	if h.renamed && h.copied {
+		return h, fmt.Errorf("%v: patch both renames and copies the file", h.newName)
+	}
+	return h, nil
+}
+
+// Returns the header of the patch which undoes h.
+func (h patchFileHeader) reversed() patchFileHeader {
+	h.oldName, h.newName = h.newName, h.oldName
+	h.oldMode, h.newMode = h.newMode, h.oldMode
+	h.newFile, h.deleted = h.deleted, h.newFile
+	return h
+}
+
+// Returns section, which is the part of a patch for one file, with the
+// names of the file changed to name and the header lines for renames,
+// copies and mode changes removed, since they're done by Apply instead of
+// patch.
+func plainTextSection(name IndexPath, section string) string {
+	lines := strings.SplitAfter(section, "\n")
+	plain := fmt.Sprintf("diff --git a/%v b/%v\n", name, name)
+	for i, line := range lines[1:] {
+		switch {
+		case strings.HasPrefix(line, "@@ "):
+			return plain + strings.Join(lines[i+1:], "")
+		case strings.HasPrefix(line, "--- a/"):
+			plain += fmt.Sprintf("--- a/%v\n", name)
+		case strings.HasPrefix(line, "+++ b/"):
+			plain += fmt.Sprintf("+++ b/%v\n", name)
+		case strings.HasPrefix(line, "rename "), strings.HasPrefix(line, "copy "),
+			strings.HasPrefix(line, "similarity index "), strings.HasPrefix(line, "dissimilarity index "),
+			strings.HasPrefix(line, "old mode "), strings.HasPrefix(line, "new mode "):
+		default:
+			plain += line
+		}
+	}
+	return plain
+}
+
+// A change to a binary file in a patch.
+type binaryFilePatch struct {
+	name IndexPath
+
+	// The object names of the file before and after the patch, from
+	// the index line.
+	src, dst Sha1
+
+	newFile, deleted bool
+
+	// The hunks of a "GIT binary patch" to change the file, and to
+	// change it back, or nil if the patch only says that the files
+	// differ.
+	forward, reverse *binaryHunk
+}
+
+// Splits patch into the headers of each file that it changes, the changes
+// to binary files in it, and the rest of the patch. If reverse is set, the
+// headers are for undoing the patch. The rest of the patch only has the
+// changes to the content of text files, which are made to the name of the
+// file after any rename, and is empty if there aren't any.
+func splitBinaryPatches(patch string, reverse bool) (string, []patchFileHeader, []binaryFilePatch, error) {
+	fileRE := regexp.MustCompile(`(?m)^diff --git a/([[:graph:]]+) b/([[:graph:]]+)$`)
+	sections := fileRE.FindAllStringSubmatchIndex(patch, -1)
+	if len(sections) == 0 {
+		return patch, nil, nil, nil
+	}
+
+	text := patch[:sections[0][0]]
+	hasText := false
+	var headers []patchFileHeader
+	var binaries []binaryFilePatch
+	for i, match := range sections {
+		end := len(patch)
+		if i+1 < len(sections) {
+			end = sections[i+1][0]
+		}
+		section := patch[match[0]:end]
+		h, err := parsePatchFileHeader(IndexPath(patch[match[2]:match[3]]), IndexPath(patch[match[4]:match[5]]), section)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if reverse {
+			h = h.reversed()
+		}
+		headers = append(headers, h)
+		b, ok, err := parseBinaryFilePatch(h.newName, section)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if ok {
+			binaries = append(binaries, b)
+			continue
+		}
+		if !strings.Contains(section, "\n@@ ") {
+			// Only the name or mode of the file is changed.
+			continue
+		}
+		text += plainTextSection(h.newName, section)
+		hasText = true
+	}
+	if !hasText {
+		text = ""
+	}
+	return text, headers, binaries, nil
+}
+
+// Renames or copies the files in dir, which is the directory that apply
+// does its work in, the way the headers say, before their content is
+// patched.
+func applyRenames(dir string, headers []patchFileHeader) error {
+	for _, h := range headers {
+		if !h.renamed && !h.copied {
+			continue
+		}
+		src := filepath.Join(dir, h.oldName.String())
+		dst := filepath.Join(dir, h.newName.String())
+		if !File(src).Exists() {
+			return fmt.Errorf("%v: No such file or directory", h.oldName)
+		}
+		if File(dst).Exists() {
+			return fmt.Errorf("%v: already exists", h.newName)
+		}
+		if err := copyFile(src, dst); err != nil {
 			return err
 		}
-		if opts.Cached {
-			return nil
+		if h.renamed {
+			if err := os.Remove(src); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Sets the modes of the files in dir which the headers change the mode of,
+// after their content is patched.
+func applyModes(dir string, headers []patchFileHeader) error {
+	for _, h := range headers {
+		if h.newMode == 0 || h.deleted {
+			continue
+		}
+		perm := os.FileMode(0644)
+		if h.newMode == ModeExec {
+			perm = 0755
+		}
+		if err := os.Chmod(filepath.Join(dir, h.newName.String()), perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses the part of a patch which changes the file name, if it's a change
+// to a binary file. Returns false if it's not.
+func parseBinaryFilePatch(name IndexPath, section string) (binaryFilePatch, bool, error) {
+	b := binaryFilePatch{name: name}
+	lines := strings.Split(section, "\n")
+	hasIndex := false
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "@@ "), strings.HasPrefix(line, "--- "):
+			// It's a text patch.
+			return b, false, nil
+		case strings.HasPrefix(line, "new file mode "):
+			b.newFile = true
+		case strings.HasPrefix(line, "deleted file mode "):
+			b.deleted = true
+		case strings.HasPrefix(line, "index "):
+			shas := strings.Fields(line[len("index "):])
+			ends := strings.Split(shas[0], "..")
+			if len(ends) != 2 {
+				return b, false, fmt.Errorf("invalid index line: %v", line)
+			}
+			src, err := Sha1FromString(ends[0])
+			if err != nil {
+				continue
+			}
+			dst, err := Sha1FromString(ends[1])
+			if err != nil {
+				continue
+			}
+			b.src, b.dst, hasIndex = src, dst, true
+		case strings.HasPrefix(line, "Binary files ") && strings.HasSuffix(line, " differ"):
+			if !hasIndex {
+				return b, false, fmt.Errorf("cannot apply binary patch to '%v' without full index line", name)
+			}
+			return b, true, nil
+		case line == "GIT binary patch":
+			if !hasIndex {
+				return b, false, fmt.Errorf("cannot apply binary patch to '%v' without full index line", name)
+			}
+			var err error
+			rest := lines[i+1:]
+			if b.forward, rest, err = parseBinaryHunk(rest); err != nil {
+				return b, false, fmt.Errorf("%v: %v", name, err)
+			}
+			if len(rest) > 0 && (strings.HasPrefix(rest[0], "literal ") || strings.HasPrefix(rest[0], "delta ")) {
+				if b.reverse, _, err = parseBinaryHunk(rest); err != nil {
+					return b, false, fmt.Errorf("%v: %v", name, err)
+				}
+			}
+			return b, true, nil
+		}
+	}
+	return b, false, nil
+}
+
+// Returns the patch which undoes b.
+func (b binaryFilePatch) reversed() (binaryFilePatch, error) {
+	if b.forward != nil && b.reverse == nil {
+		return b, fmt.Errorf("cannot reverse-apply a binary patch without the reverse hunk to '%v'", b.name)
+	}
+	b.src, b.dst = b.dst, b.src
+	b.newFile, b.deleted = b.deleted, b.newFile
+	b.forward, b.reverse = b.reverse, b.forward
+	return b, nil
+}
+
+// Applies b to the copy of the file in dir, checking that the file is the
+// one that the patch was made from, and that the result is what the patch
+// expects.
+func (b binaryFilePatch) apply(c *Client, dir string) error {
+	path := filepath.Join(dir, b.name.String())
+	var content []byte
+	if b.newFile {
+		if File(path).Exists() {
+			return fmt.Errorf("%v: already exists", b.name)
+		}
+	} else {
+		var err error
+		if content, err = ioutil.ReadFile(path); err != nil {
+			return err
+		}
+		if sha, _, err := HashSlice("blob", content); err != nil {
+			return err
+		} else if sha != b.src {
+			return fmt.Errorf("the patch applies to '%v' (%v), which does not match the current contents.", b.name, b.src)
+		}
+	}
+
+	var result []byte
+	switch {
+	case b.forward != nil:
+		var err error
+		if result, err = b.forward.apply(content); err != nil {
+			return fmt.Errorf("%v: %v", b.name, err)
+		}
+	case b.deleted:
+	default:
+		// There's no data in the patch, but the result can still be
+		// used if it's in the repository.
+		obj, err := c.GetObject(b.dst)
+		if err != nil {
+			return fmt.Errorf("cannot apply binary patch to '%v' without full index line", b.name)
 		}
+		result = obj.GetContent()
 	}
-	return copyApplyDir(c, patchdir)
 
+	if b.deleted {
+		if len(result) != 0 {
+			return fmt.Errorf("removal patch leaves file contents")
+		}
+		return os.Remove(path)
+	}
+	if sha, _, err := HashSlice("blob", result); err != nil {
+		return err
+	} else if sha != b.dst {
+		return fmt.Errorf("binary patch to '%v' creates incorrect result (expecting %v, got %v)", b.name, b.dst, sha)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, result, 0644)
 }
 
 // RestoreDir takes the directory dir, which is the directory that apply did
@@ -148,9 +576,11 @@ func copyApplyDir(c *Client, dir string) error {
 	return nil
 }
 
-// RestoreDir takes the directory dir, which is the directory that apply did
-// its work in, and copies it back into the workdir.
-func updateApplyIndex(c *Client, idx *Index, dir string) error {
+// Updates the index with the files in dir, which is the directory that
+// apply did its work in, and removes the deleted files from it. New files
+// are added. If cached is set, the files are only in the index, so there's
+// no stat info to refresh for them.
+func updateApplyIndex(c *Client, idx *Index, dir string, deleted []IndexPath, cached bool) error {
 	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if info.IsDir() {
 			return nil
@@ -166,6 +596,10 @@ func updateApplyIndex(c *Client, idx *Index, dir string) error {
 		}
 
 		ipath := IndexPath(relpath)
+		mode := ModeBlob
+		if info.Mode()&0100 != 0 {
+			mode = ModeExec
+		}
 
 		for _, entry := range idx.Objects {
 			if entry.PathName != ipath {
@@ -173,13 +607,28 @@ func updateApplyIndex(c *Client, idx *Index, dir string) error {
 			}
 			log.Printf("Refreshing %v: %v", ipath, sha1)
 			entry.Sha1 = sha1
+			entry.Mode = mode
 			if err := entry.RefreshStat(c); err != nil {
 				return err
 			}
 			return nil
 		}
+		if err := idx.AddStage(c, ipath, mode, sha1, Stage0, uint32(len(contents)), 0, UpdateIndexOptions{Add: true}); err != nil {
+			return err
+		}
+		if cached {
+			return nil
+		}
+		for _, entry := range idx.Objects {
+			if entry.PathName == ipath {
+				return entry.RefreshStat(c)
+			}
+		}
 		return nil
 	})
+	for _, name := range deleted {
+		idx.RemoveFile(name)
+	}
 	// Write the index that the callback modified
 	f, err := c.GitDir.Create(File("index"))
 	if err != nil {
@@ -211,8 +660,16 @@ func copyFile(src, dst string) error {
 	}
 	defer d.Close()
 
-	_, err = io.Copy(d, s)
-	return err
+	if _, err := io.Copy(d, s); err != nil {
+		return err
+	}
+
+	// Keep the permissions, so that executable files stay executable.
+	info, err := s.Stat()
+	if err != nil {
+		return err
+	}
+	return d.Chmod(info.Mode().Perm())
 }
 
 func copyFromIndex(c *Client, idx *Index, file IndexPath, dst string) error {
@@ -230,5 +687,14 @@ func copyFromIndex(c *Client, idx *Index, file IndexPath, dst string) error {
 		}
 	}
 
-	return ioutil.WriteFile(dst, obj.GetContent(), 0644)
+	perm := os.FileMode(0644)
+	for _, entry := range idx.Objects {
+		if entry.PathName == file && entry.Mode == ModeExec {
+			perm = 0755
+		}
+	}
+	if err := ioutil.WriteFile(dst, obj.GetContent(), perm); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
 }
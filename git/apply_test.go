@@ -1,8 +1,10 @@
 package git
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -276,3 +278,201 @@ diff --git a/qux/qux.txt b/qux/qux.txt
 		t.Errorf("Did not apply --cached patch correctly. Got %v want %v", idx[0].Sha1, want)
 	}
 }
+
+// TestBinaryApply tests that binary patches made by git diff --binary can
+// be applied and reversed.
+func TestBinaryApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitapply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/bin", []byte("a\000b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	patch, err := ioutil.TempFile("", "applytestpatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(patch.Name())
+	if err := ioutil.WriteFile(patch.Name(), []byte(
+		`diff --git a/bin b/bin
+index 20b5be91886d0b6f26dc98a225c0dac05fe2c86e..39c99e870faefd5e253799f4536a8ef5ff81f090 100644
+GIT binary patch
+literal 4
+LcmYdfNJ<6(0<Qrl
+
+literal 3
+KcmYdfNCE%>hycU@
+
+diff --git a/nb b/nb
+new file mode 100644
+index 0000000000000000000000000000000000000000..c984a0442d5fba744241e9c2dd75d27f612d6cb2
+GIT binary patch
+literal 4
+Lcmc~xEoT4#1K9yf
+
+literal 0
+HcmV?d00001
+
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Apply(c, ApplyOptions{}, []File{File(patch.Name())}); err != nil {
+		t.Fatalf("Error applying binary patch: %v", err)
+	}
+	for name, want := range map[string]string{"bin": "a\000bc", "nb": "new\000"} {
+		file, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(file); got != want {
+			t.Errorf("Unexpected value of %v after binary patch: got %q want %q", name, got, want)
+		}
+	}
+
+	// The patch no longer applies, since bin has changed.
+	if err := Apply(c, ApplyOptions{}, []File{File(patch.Name())}); err == nil {
+		t.Error("Expected error applying binary patch twice, got none.")
+	}
+
+	if err := Apply(c, ApplyOptions{Reverse: true}, []File{File(patch.Name())}); err != nil {
+		t.Fatalf("Error reversing binary patch: %v", err)
+	}
+	file, err := ioutil.ReadFile("bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(file); got != "a\000b" {
+		t.Errorf("Unexpected value of bin after reversing binary patch: got %q want %q", got, "a\000b")
+	}
+	if File("nb").Exists() {
+		t.Error("nb still exists after reversing the patch which created it")
+	}
+}
+
+// TestApplyRename tests that a patch made by diff --cached which renames a
+// file and changes the mode of another can be applied to the index.
+func TestApplyRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitapply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Repeat("line\n", 10)
+	if err := ioutil.WriteFile("foo.txt", []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("run.sh", []byte("true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt", "run.sh"}); err != nil {
+		t.Fatal(err)
+	}
+	head, err := Commit(c, CommitOptions{}, CommitMessage("base"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rename and change foo.txt, and change run.sh and make it
+	// executable.
+	if err := Rm(c, RmOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("bar.txt", []byte(lines+"more\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("run.sh", []byte("exit 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := Add(c, AddOptions{}, []File{"bar.txt", "run.sh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Add doesn't keep the executable bit, so it's set in the index.
+	for _, entry := range idx.Objects {
+		if entry.PathName == "run.sh" {
+			entry.Mode = ModeExec
+		}
+	}
+	f, err := c.GitDir.Create(File("index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteIndex(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	opts := DiffCommonOptions{Patch: true, NumContextLines: 3, DetectRenames: true}
+	diffs, err := DiffIndex(c, DiffIndexOptions{DiffCommonOptions: opts, Cached: true}, idx, head, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err := ioutil.TempFile("", "applytestpatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(patch.Name())
+	if err := GeneratePatch(c, opts, diffs, patch); err != nil {
+		t.Fatal(err)
+	}
+	patch.Close()
+
+	// Applying the patch after going back to the commit should redo
+	// the changes.
+	if err := ResetMode(c, ResetOptions{Hard: true}, head); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(c, ApplyOptions{Index: true}, []File{File(patch.Name())}); err != nil {
+		t.Fatalf("Error applying rename patch: %v", err)
+	}
+	if File("foo.txt").Exists() {
+		t.Error("foo.txt still exists after being renamed")
+	}
+	if content, err := ioutil.ReadFile("bar.txt"); err != nil || string(content) != lines+"more\n" {
+		t.Errorf("Unexpected content of bar.txt: got %q, %v", content, err)
+	}
+	if info, err := os.Stat("run.sh"); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("run.sh was not made executable: %v, %v", info.Mode(), err)
+	}
+	idx, err = c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range idx.Objects {
+		got = append(got, fmt.Sprintf("%o %v", entry.Mode, entry.PathName))
+	}
+	if want := "100644 bar.txt,100755 run.sh"; strings.Join(got, ",") != want {
+		t.Errorf("Unexpected index after applying rename patch: got %v want %v", got, want)
+	}
+
+	// Reversing it should undo them.
+	if err := Apply(c, ApplyOptions{Index: true, Reverse: true}, []File{File(patch.Name())}); err != nil {
+		t.Fatalf("Error reversing rename patch: %v", err)
+	}
+	if File("bar.txt").Exists() || !File("foo.txt").Exists() {
+		t.Error("Reversing the patch didn't rename bar.txt back to foo.txt")
+	}
+	if info, err := os.Stat("run.sh"); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("run.sh was not made unexecutable: %v, %v", info.Mode(), err)
+	}
+}
@@ -0,0 +1,217 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// The alphabet of the base85 encoding used by binary patches, which is
+// not the same as ascii85.
+const base85Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+// The maximum number of bytes encoded on each line of a binary patch.
+const binaryPatchLineBytes = 52
+
+// Encodes data in base85, 4 bytes to every 5 characters. The last group is
+// padded with zeros.
+func encode85(data []byte) string {
+	var s strings.Builder
+	for len(data) > 0 {
+		var v uint32
+		for i := 0; i < 4; i++ {
+			v <<= 8
+			if i < len(data) {
+				v |= uint32(data[i])
+			}
+		}
+		var group [5]byte
+		for i := 4; i >= 0; i-- {
+			group[i] = base85Chars[v%85]
+			v /= 85
+		}
+		s.Write(group[:])
+		if len(data) < 4 {
+			break
+		}
+		data = data[4:]
+	}
+	return s.String()
+}
+
+// Decodes len(dst) bytes from the base85 encoded string s into dst.
+func decode85(dst []byte, s string) error {
+	for len(dst) > 0 {
+		if len(s) < 5 {
+			return fmt.Errorf("base85 data is too short")
+		}
+		var v uint64
+		for i := 0; i < 5; i++ {
+			c := strings.IndexByte(base85Chars, s[i])
+			if c < 0 {
+				return fmt.Errorf("invalid base85 character %q", s[i])
+			}
+			v = v*85 + uint64(c)
+		}
+		if v > 0xffffffff {
+			return fmt.Errorf("invalid base85 sequence %v", s[:5])
+		}
+		for i := 0; i < 4 && len(dst) > 0; i++ {
+			dst[0] = byte(v >> uint(24-8*i))
+			dst = dst[1:]
+		}
+		s = s[5:]
+	}
+	return nil
+}
+
+// Returns data compressed with zlib at the fastest compression level, the
+// same as git uses for binary patches.
+func deflateBinaryPatch(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Writes a "GIT binary patch" which changes src to dst to w. The patch has
+// a hunk to change src into dst, and another to change it back.
+func writeBinaryPatch(w io.Writer, src, dst []byte) error {
+	fmt.Fprintln(w, "GIT binary patch")
+	if err := writeBinaryHunk(w, src, dst); err != nil {
+		return err
+	}
+	return writeBinaryHunk(w, dst, src)
+}
+
+// Writes a hunk of a binary patch which changes from into to. The hunk is
+// either the literal content of to, or a delta from from to to, whichever
+// is smaller once it's compressed.
+func writeBinaryHunk(w io.Writer, from, to []byte) error {
+	data, err := deflateBinaryPatch(to)
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("literal %d", len(to))
+	if len(from) > 0 && len(to) > 0 {
+		delta := createDelta(from, to)
+		compressed, err := deflateBinaryPatch(delta)
+		if err != nil {
+			return err
+		}
+		if len(compressed) < len(data) {
+			header = fmt.Sprintf("delta %d", len(delta))
+			data = compressed
+		}
+	}
+	fmt.Fprintln(w, header)
+	for len(data) > 0 {
+		n := len(data)
+		if n > binaryPatchLineBytes {
+			n = binaryPatchLineBytes
+		}
+		// The length of the line is encoded as A-Z for 1-26 and a-z
+		// for 27-52.
+		length := byte('A' + n - 1)
+		if n > 26 {
+			length = byte('a' + n - 27)
+		}
+		fmt.Fprintf(w, "%c%v\n", length, encode85(data[:n]))
+		data = data[n:]
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+// A hunk of a binary patch.
+type binaryHunk struct {
+	// Set if the data is a delta to apply to the file, rather than
+	// the new content of it.
+	delta bool
+	data  []byte
+}
+
+// Parses a hunk of a binary patch from the start of lines, which don't have
+// trailing newlines. Returns the hunk and the lines after it.
+func parseBinaryHunk(lines []string) (*binaryHunk, []string, error) {
+	if len(lines) == 0 {
+		return nil, nil, fmt.Errorf("missing binary patch data")
+	}
+	var hunk binaryHunk
+	var size string
+	switch {
+	case strings.HasPrefix(lines[0], "literal "):
+		size = lines[0][len("literal "):]
+	case strings.HasPrefix(lines[0], "delta "):
+		hunk.delta = true
+		size = lines[0][len("delta "):]
+	default:
+		return nil, nil, fmt.Errorf("unrecognized binary patch line: %v", lines[0])
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 0 {
+		return nil, nil, fmt.Errorf("invalid binary patch size: %v", size)
+	}
+
+	var compressed []byte
+	lines = lines[1:]
+	for len(lines) > 0 && lines[0] != "" {
+		line := lines[0]
+		lines = lines[1:]
+		var length int
+		switch c := line[0]; {
+		case c >= 'A' && c <= 'Z':
+			length = int(c-'A') + 1
+		case c >= 'a' && c <= 'z':
+			length = int(c-'a') + 27
+		default:
+			return nil, nil, fmt.Errorf("corrupt binary patch line: %v", line)
+		}
+		if len(line)-1 != (length+3)/4*5 {
+			return nil, nil, fmt.Errorf("corrupt binary patch line: %v", line)
+		}
+		buf := make([]byte, length)
+		if err := decode85(buf, line[1:]); err != nil {
+			return nil, nil, err
+		}
+		compressed = append(compressed, buf...)
+	}
+	if len(lines) > 0 {
+		// Skip the blank line which ends the hunk.
+		lines = lines[1:]
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, nil, fmt.Errorf("corrupt binary patch: %v", err)
+	}
+	defer zr.Close()
+	hunk.data, err = ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("corrupt binary patch: %v", err)
+	}
+	if len(hunk.data) != n {
+		return nil, nil, fmt.Errorf("binary patch has %d bytes of data, expected %d", len(hunk.data), n)
+	}
+	return &hunk, lines, nil
+}
+
+// Applies the hunk to the content of the file before it.
+func (h *binaryHunk) apply(content []byte) ([]byte, error) {
+	if !h.delta {
+		return h.data, nil
+	}
+	return applyDelta(content, h.data)
+}
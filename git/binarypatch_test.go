@@ -0,0 +1,100 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBase85(t *testing.T) {
+	// The zlib compressed empty string, as it appears in binary patches
+	// made by git.
+	want := []byte{0x78, 0x01, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01}
+	got := make([]byte, len(want))
+	if err := decode85(got, "cmV?d00001"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("decode85: got %x want %x", got, want)
+	}
+	if enc := encode85(want); enc != "cmV?d00001" {
+		t.Errorf("encode85: got %v want cmV?d00001", enc)
+	}
+
+	for _, data := range []string{"", "a", "ab\000", "abcd", "\377\377\377\377\377"} {
+		got := make([]byte, len(data))
+		if err := decode85(got, encode85([]byte(data))); err != nil {
+			t.Errorf("%q: %v", data, err)
+		} else if string(got) != data {
+			t.Errorf("%q: round trip gave %q", data, got)
+		}
+	}
+}
+
+func TestBinaryPatchHunks(t *testing.T) {
+	// A hunk made by git.
+	hunk, rest, err := parseBinaryHunk([]string{"literal 4", "LcmYdfNJ<6(0<Qrl", "", "literal 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hunk.delta || string(hunk.data) != "a\000bc" {
+		t.Errorf("Unexpected hunk %v %q", hunk.delta, hunk.data)
+	}
+	if len(rest) != 1 || rest[0] != "literal 3" {
+		t.Errorf("Unexpected lines after hunk: %q", rest)
+	}
+
+	// Round trip patches through writeBinaryPatch, including one
+	// large enough to be written as a delta.
+	big := bytes.Repeat([]byte("binary\000data"), 200)
+	bigger := append(append([]byte{}, big...), "more"...)
+	tests := []struct {
+		src, dst []byte
+		delta    bool
+	}{
+		{nil, []byte("new\000file"), false},
+		{[]byte("old\000file"), nil, false},
+		{big, bigger, true},
+	}
+	for i, tc := range tests {
+		var buf bytes.Buffer
+		if err := writeBinaryPatch(&buf, tc.src, tc.dst); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(buf.String(), "\n")
+		if lines[0] != "GIT binary patch" {
+			t.Errorf("%d: unexpected first line %v", i, lines[0])
+			continue
+		}
+		forward, rest, err := parseBinaryHunk(lines[1:])
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		reverse, _, err := parseBinaryHunk(rest)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if forward.delta != tc.delta {
+			t.Errorf("%d: got delta %v want %v", i, forward.delta, tc.delta)
+		}
+		if got, err := forward.apply(tc.src); err != nil || !bytes.Equal(got, tc.dst) {
+			t.Errorf("%d: forward hunk gave %q, %v", i, got, err)
+		}
+		if got, err := reverse.apply(tc.dst); err != nil || !bytes.Equal(got, tc.src) {
+			t.Errorf("%d: reverse hunk gave %q, %v", i, got, err)
+		}
+	}
+
+	for _, corrupt := range [][]string{
+		{"literal 5", "LcmYdfNJ<6(0<Qrl"},
+		{"literal 4", "McmYdfNJ<6(0<Qrl"},
+		{"literal 4", "Lc~~~~NJ<6(0<Qrl"},
+		{"copy 4"},
+	} {
+		if _, _, err := parseBinaryHunk(corrupt); err == nil {
+			t.Errorf("%q: expected an error", corrupt)
+		}
+	}
+}
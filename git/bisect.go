@@ -0,0 +1,1015 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// ErrBisectOnlySkipped is returned when the only commits left to test in a
+// bisect have been skipped, so the first bad commit can't be found.
+var ErrBisectOnlySkipped = errors.New("There are only 'skip'ped commits left to test")
+
+// The files in the GitDir which hold the state of an in progress bisect.
+// The bad commit is refs/bisect/bad, and the good and skipped commits are
+// refs/bisect/good-<sha> and refs/bisect/skip-<sha>.
+//
+//	BISECT_START:        the branch, or the commit if HEAD was detached,
+//	                     that the bisect was started from.
+//	BISECT_TERMS:        the terms for bad and good commits.
+//	BISECT_NAMES:        the quoted paths the bisect is limited to.
+//	BISECT_LOG:          the commands run so far, which can be replayed.
+//	BISECT_EXPECTED_REV: the commit which was checked out to be tested.
+//	BISECT_ANCESTORS_OK: exists once the good commits are known to be
+//	                     ancestors of the bad commit.
+const (
+	bisectStartFile       = File("BISECT_START")
+	bisectTermsFile       = File("BISECT_TERMS")
+	bisectNamesFile       = File("BISECT_NAMES")
+	bisectLogFile         = File("BISECT_LOG")
+	bisectExpectedRevFile = File("BISECT_EXPECTED_REV")
+	bisectAncestorsOKFile = File("BISECT_ANCESTORS_OK")
+)
+
+// The state of an in progress bisect.
+type bisect struct {
+	bad        CommitID
+	good, skip []CommitID
+	paths      []IndexPath
+}
+
+// A commit which is left to test in a bisect.
+type bisectCandidate struct {
+	commit CommitID
+
+	// The indexes of the parents which are also candidates.
+	parents []int
+
+	// The number of candidates the commit can reach, including itself.
+	weight int
+
+	// The same as weight for merges, which have to count their
+	// ancestors rather than adding one to the weight of their parent.
+	distance int
+
+	// Set if the commit doesn't change the paths the bisect is
+	// limited to, so testing it wouldn't tell us anything.
+	treesame bool
+}
+
+// Returns true if there is a bisect in progress.
+func (c *Client) IsBisecting() bool {
+	content, err := c.GitDir.ReadFile(bisectStartFile)
+	return err == nil && strings.TrimSpace(string(content)) != ""
+}
+
+// Loads the state of the in progress bisect.
+func loadBisect(c *Client) (*bisect, error) {
+	if !c.IsBisecting() {
+		return nil, fmt.Errorf(`You need to start by "git bisect start"`)
+	}
+	b := &bisect{}
+	names, err := c.refNames("refs/bisect/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		ref, err := parseRef(c, name)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case name == "refs/bisect/bad":
+			b.bad = CommitID(ref.Value)
+		case strings.HasPrefix(name, "refs/bisect/good-"):
+			b.good = append(b.good, CommitID(ref.Value))
+		case strings.HasPrefix(name, "refs/bisect/skip-"):
+			b.skip = append(b.skip, CommitID(ref.Value))
+		}
+	}
+	content, err := c.GitDir.ReadFile(bisectNamesFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	paths, err := sqSplit(string(content))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) > 0 && paths[0] == "--" {
+		paths = paths[1:]
+	}
+	for _, p := range paths {
+		ip, err := File(p).IndexPath(c)
+		if err != nil {
+			return nil, err
+		}
+		b.paths = append(b.paths, ip)
+	}
+	return b, nil
+}
+
+// Removes the state of the bisect.
+func bisectCleanState(c *Client) error {
+	names, err := c.refNames("refs/bisect/")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := UpdateRef(c, UpdateRefOptions{Delete: true}, name, CommitID{}, ""); err != nil {
+			return err
+		}
+	}
+	for _, f := range []File{bisectExpectedRevFile, bisectAncestorsOKFile, bisectLogFile, bisectTermsFile, bisectNamesFile} {
+		os.Remove(c.GitDir.File(f).String())
+	}
+	// BISECT_START goes last, since it's what says there's a bisect.
+	if err := os.Remove(c.GitDir.File(bisectStartFile).String()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Appends lines to the bisect log.
+func bisectLog(c *Client, format string, a ...interface{}) error {
+	f, err := os.OpenFile(c.GitDir.File(bisectLogFile).String(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, format, a...)
+	return err
+}
+
+// Returns the subject of cmt, for the log and messages.
+func bisectSubject(c *Client, cmt CommitID) string {
+	msg, err := cmt.GetCommitMessage(c)
+	if err != nil {
+		return ""
+	}
+	return msg.Subject()
+}
+
+// Implements "git bisect start [<bad> [<good>...]] [--] [<paths>...]".
+// args are the arguments as they were given, so that they can be
+// recorded in the log.
+func BisectStart(c *Client, args []string) error {
+	hasDoubleDash := false
+	for _, arg := range args {
+		if arg == "--" {
+			hasDoubleDash = true
+			break
+		}
+	}
+	var revs []CommitID
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unrecognized option: '%v'", arg)
+		}
+		cmt, err := RevParseCommit(c, &RevParseOptions{}, arg)
+		if err != nil {
+			if hasDoubleDash {
+				return fmt.Errorf("'%v' does not appear to be a valid revision", arg)
+			}
+			break
+		}
+		revs = append(revs, cmt)
+	}
+	// The paths are saved with the "--" before them, if there was one,
+	// like git does.
+	names := args[i:]
+
+	// Go back to where the last bisect started from, if there is one,
+	// and start the new one from there.
+	var startHead string
+	if c.IsBisecting() {
+		content, err := c.GitDir.ReadFile(bisectStartFile)
+		if err != nil {
+			return err
+		}
+		startHead = strings.TrimSpace(string(content))
+		if err := Checkout(c, CheckoutOptions{Quiet: true}, startHead, nil); err != nil {
+			return fmt.Errorf("checking out '%v' failed. Try 'git bisect start <valid-branch>'.", startHead)
+		}
+	} else {
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			return fmt.Errorf("Bad HEAD - I need a HEAD")
+		}
+		switch ref, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD"); err {
+		case DetachedHead:
+			startHead = head.String()
+		case nil:
+			startHead = Branch(ref).BranchName()
+		default:
+			return err
+		}
+	}
+	if err := bisectCleanState(c); err != nil {
+		return err
+	}
+
+	if err := c.GitDir.WriteFile(bisectStartFile, []byte(startHead+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := c.GitDir.WriteFile(bisectTermsFile, []byte("bad\ngood\n"), 0644); err != nil {
+		return err
+	}
+	b := &bisect{}
+	for i, cmt := range revs {
+		term := "good"
+		if i == 0 {
+			term = "bad"
+		}
+		if err := b.write(c, term, cmt, true); err != nil {
+			return err
+		}
+	}
+	var quotedNames strings.Builder
+	for j, p := range names {
+		fmt.Fprintf(&quotedNames, " %v", sqQuote(p))
+		if j == 0 && p == "--" {
+			continue
+		}
+		ip, err := File(p).IndexPath(c)
+		if err != nil {
+			return err
+		}
+		b.paths = append(b.paths, ip)
+	}
+	if err := c.GitDir.WriteFile(bisectNamesFile, []byte(quotedNames.String()+"\n"), 0644); err != nil {
+		return err
+	}
+	var quoted strings.Builder
+	for _, arg := range args {
+		fmt.Fprintf(&quoted, " %v", sqQuote(arg))
+	}
+	if err := bisectLog(c, "git bisect start%v\n", quoted.String()); err != nil {
+		return err
+	}
+	if _, err := b.autoNext(c); err != nil {
+		bisectCleanState(c)
+		return err
+	}
+	return nil
+}
+
+// Implements "git bisect bad [<rev>]". If rev is nil, HEAD is bad.
+func BisectBad(c *Client, rev Commitish) error {
+	var revs []Commitish
+	if rev != nil {
+		revs = append(revs, rev)
+	}
+	return bisectMark(c, "bad", revs)
+}
+
+// Implements "git bisect good [<rev>...]". If there are no revs, HEAD is
+// good.
+func BisectGood(c *Client, revs []Commitish) error {
+	return bisectMark(c, "good", revs)
+}
+
+// Implements "git bisect skip [<rev>...]". If there are no revs, HEAD is
+// skipped.
+func BisectSkip(c *Client, revs []Commitish) error {
+	return bisectMark(c, "skip", revs)
+}
+
+func bisectMark(c *Client, term string, revs []Commitish) error {
+	b, err := loadBisect(c)
+	if err != nil {
+		return err
+	}
+	if len(revs) == 0 {
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+		revs = append(revs, head)
+	}
+	var cmts []CommitID
+	for _, rev := range revs {
+		cmt, err := rev.CommitID(c)
+		if err != nil {
+			return fmt.Errorf("Bad rev input: %v", rev)
+		}
+		cmts = append(cmts, cmt)
+	}
+	_, err = b.mark(c, term, cmts)
+	return err
+}
+
+// Marks the commits with term, and moves on to the next commit to test.
+// Returns true if the first bad commit was found.
+func (b *bisect) mark(c *Client, term string, cmts []CommitID) (bool, error) {
+	for _, cmt := range cmts {
+		if err := b.write(c, term, cmt, false); err != nil {
+			return false, err
+		}
+	}
+
+	// If something other than the commit we checked out was marked, the
+	// good commits need to be checked again.
+	if expected, err := c.readHeadFile(bisectExpectedRevFile); err == nil {
+		for _, cmt := range cmts {
+			if cmt != expected {
+				os.Remove(c.GitDir.File(bisectAncestorsOKFile).String())
+				os.Remove(c.GitDir.File(bisectExpectedRevFile).String())
+				break
+			}
+		}
+	}
+	return b.autoNext(c)
+}
+
+// Records cmt as a bad, good or skipped commit. Commands which are
+// replayed from the arguments to start aren't logged as commands.
+func (b *bisect) write(c *Client, term string, cmt CommitID, nolog bool) error {
+	ref := "refs/bisect/" + term
+	if term != "bad" {
+		ref += "-" + cmt.String()
+	}
+	if err := UpdateRef(c, UpdateRefOptions{}, ref, cmt, ""); err != nil {
+		return err
+	}
+	switch term {
+	case "bad":
+		b.bad = cmt
+	case "good":
+		b.good = appendCommit(b.good, cmt)
+	case "skip":
+		b.skip = appendCommit(b.skip, cmt)
+	}
+	if err := bisectLog(c, "# %v: [%v] %v\n", term, cmt, bisectSubject(c, cmt)); err != nil {
+		return err
+	}
+	if nolog {
+		return nil
+	}
+	return bisectLog(c, "git bisect %v %v\n", term, cmt)
+}
+
+// Appends cmt to cmts if it's not already in it.
+func appendCommit(cmts []CommitID, cmt CommitID) []CommitID {
+	for _, s := range cmts {
+		if s == cmt {
+			return cmts
+		}
+	}
+	return append(cmts, cmt)
+}
+
+// Moves on to the next commit to test if there are good and bad commits,
+// and otherwise says what the bisect is waiting for.
+func (b *bisect) autoNext(c *Client) (bool, error) {
+	var status string
+	switch {
+	case b.bad != (CommitID{}) && len(b.good) > 0:
+		return b.next(c)
+	case b.bad == (CommitID{}) && len(b.good) == 0:
+		status = "waiting for both good and bad commits"
+	case b.bad == (CommitID{}) && len(b.good) == 1:
+		status = "waiting for bad commit, 1 good commit known"
+	case b.bad == (CommitID{}):
+		status = fmt.Sprintf("waiting for bad commit, %d good commits known", len(b.good))
+	default:
+		status = "waiting for good commit(s), bad commit known"
+	}
+	fmt.Printf("status: %v\n", status)
+	return false, bisectLog(c, "# status: %v\n", status)
+}
+
+// Checks out the commit which best halves the commits left to test, or
+// shows the first bad commit if there's nothing left to test. Returns true
+// if the first bad commit was found.
+func (b *bisect) next(c *Client) (bool, error) {
+	if ok, err := b.checkMergeBases(c); !ok || err != nil {
+		return false, err
+	}
+	list, err := b.candidates(c)
+	if err != nil {
+		return false, err
+	}
+	skipped := make(map[CommitID]bool)
+	for _, s := range b.skip {
+		skipped[s] = true
+	}
+	list, all, reaches := findBisection(list, len(skipped) > 0)
+	list, tried := managedSkipped(list, skipped, b.bad)
+	if len(list) == 0 {
+		if len(tried) > 0 {
+			return false, b.onlySkipped(c, tried, false)
+		}
+		return false, fmt.Errorf("%v was both good and bad", b.bad)
+	}
+	if all == 0 {
+		return false, fmt.Errorf("No testable commit found.\nMaybe you started with bad path arguments?")
+	}
+
+	cmt := list[0].commit
+	if cmt == b.bad {
+		if len(tried) > 0 {
+			return false, b.onlySkipped(c, tried, true)
+		}
+		fmt.Printf("%v is the first bad commit\n", cmt)
+		output, err := FormatString("").FormatCommit(c, cmt)
+		if err != nil {
+			return false, err
+		}
+		fmt.Print(output)
+		opts := DiffCommonOptions{Stat: true, Summary: true}
+		diffs, err := showCommitDiffs(c, opts, cmt)
+		if err != nil {
+			return false, err
+		}
+		if parents, err := cmt.Parents(c); err == nil && len(parents) > 1 {
+			// Merges are shown against their first parent.
+			diffs, err = DiffTree(c, &DiffTreeOptions{DiffCommonOptions: opts, Recurse: true}, parents[0], cmt, nil)
+			if err != nil {
+				return false, err
+			}
+		}
+		if err := GeneratePatch(c, opts, diffs, nil); err != nil {
+			return false, err
+		}
+		return true, bisectLog(c, "# first bad commit: [%v] %v\n", cmt, bisectSubject(c, cmt))
+	}
+
+	nr := all - reaches - 1
+	steps := estimateBisectSteps(all)
+	revisions, stepsWord := "revisions", "steps"
+	if nr == 1 {
+		revisions = "revision"
+	}
+	if steps == 1 {
+		stepsWord = "step"
+	}
+	fmt.Printf("Bisecting: %d %v left to test after this (roughly %d %v)\n", nr, revisions, steps, stepsWord)
+	return false, bisectCheckout(c, cmt)
+}
+
+// Checks out cmt for testing.
+func bisectCheckout(c *Client, cmt CommitID) error {
+	if err := c.GitDir.WriteFile(bisectExpectedRevFile, []byte(cmt.String()+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := CheckoutCommit(c, CheckoutOptions{Quiet: true, Detach: true}, cmt); err != nil {
+		return err
+	}
+	fmt.Printf("[%v] %v\n", cmt, bisectSubject(c, cmt))
+	return nil
+}
+
+// Says which commits could be the first bad commit when the only ones
+// left to test were skipped, and returns ErrBisectOnlySkipped.
+func (b *bisect) onlySkipped(c *Client, tried []*bisectCandidate, showBad bool) error {
+	fmt.Printf("There are only 'skip'ped commits left to test.\nThe first bad commit could be any of:\n")
+	for _, p := range tried {
+		fmt.Println(p.commit)
+	}
+	if showBad {
+		fmt.Println(b.bad)
+	}
+	fmt.Printf("We cannot bisect more!\n")
+
+	if err := bisectLog(c, "# only skipped commits left to test\n"); err != nil {
+		return err
+	}
+	revs, err := RevList(c, RevListOptions{Quiet: true}, nil, []Commitish{b.bad}, b.goodCommitish())
+	if err != nil {
+		return err
+	}
+	for _, s := range revs {
+		if err := bisectLog(c, "# possible first bad commit: [%v] %v\n", s, bisectSubject(c, CommitID(s))); err != nil {
+			return err
+		}
+	}
+	return ErrBisectOnlySkipped
+}
+
+func (b *bisect) goodCommitish() []Commitish {
+	var goods []Commitish
+	for _, g := range b.good {
+		goods = append(goods, g)
+	}
+	return goods
+}
+
+// Checks that the good commits are ancestors of the bad commit. If one
+// isn't, the merge base of the two has to be tested first, so it's checked
+// out and false is returned.
+func (b *bisect) checkMergeBases(c *Client) (bool, error) {
+	if c.GitDir.File(bisectAncestorsOKFile).Exists() {
+		return true, nil
+	}
+	good := make(map[CommitID]bool)
+	skipped := make(map[CommitID]bool)
+	for _, g := range b.good {
+		good[g] = true
+	}
+	for _, s := range b.skip {
+		skipped[s] = true
+	}
+	for _, g := range b.good {
+		if g.IsAncestor(c, b.bad) {
+			continue
+		}
+		base, err := MergeBase(c, MergeBaseOptions{}, []Commitish{b.bad, g})
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case base == b.bad:
+			var goods []string
+			for _, g := range b.good {
+				goods = append(goods, g.String())
+			}
+			return false, fmt.Errorf("The merge base %v is bad.\nThis means the bug has been fixed between %v and [%v].", base, base, strings.Join(goods, " "))
+		case good[base]:
+		case skipped[base]:
+			fmt.Fprintf(os.Stderr, "Warning: the merge base between %v and [%v] must be skipped.\nSo we cannot be sure the first bad commit is between %v and %v.\nWe continue anyway.\n", b.bad, g, base, b.bad)
+		default:
+			fmt.Printf("Bisecting: a merge base must be tested\n")
+			return false, bisectCheckout(c, base)
+		}
+	}
+	return true, c.GitDir.WriteFile(bisectAncestorsOKFile, nil, 0644)
+}
+
+// Returns the commits which are left to test, which are the ancestors of
+// the bad commit which aren't ancestors of any good commit, oldest first.
+func (b *bisect) candidates(c *Client) ([]*bisectCandidate, error) {
+	revs, err := RevList(c, RevListOptions{Quiet: true}, nil, []Commitish{b.bad}, b.goodCommitish())
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*bisectCandidate, len(revs))
+	index := make(map[CommitID]int)
+	for i, s := range revs {
+		j := len(revs) - 1 - i
+		list[j] = &bisectCandidate{commit: CommitID(s)}
+		index[CommitID(s)] = j
+	}
+	for _, p := range list {
+		parents, err := p.commit.Parents(c)
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range parents {
+			if j, ok := index[parent]; ok {
+				p.parents = append(p.parents, j)
+			}
+		}
+	}
+
+	if len(b.paths) > 0 {
+		changed, err := RevList(c, RevListOptions{Quiet: true, Paths: b.paths}, nil, []Commitish{b.bad}, b.goodCommitish())
+		if err != nil {
+			return nil, err
+		}
+		changes := make(map[CommitID]bool)
+		for _, s := range changed {
+			changes[CommitID(s)] = true
+		}
+		for _, p := range list {
+			p.treesame = !changes[p.commit]
+		}
+	}
+
+	for _, p := range list {
+		if len(p.parents) < 2 {
+			continue
+		}
+		ancestors, err := p.commit.AncestorMap(c)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range list {
+			if _, ok := ancestors[q.commit]; ok && !q.treesame {
+				p.distance++
+			}
+		}
+	}
+	return list, nil
+}
+
+// Finds the commit which best halves the candidates, in the same way as
+// git so that the same commits get tested. If all is set, every candidate
+// is returned, sorted by how well it halves the others, so that the best
+// one which wasn't skipped can be picked. Otherwise only the best one is
+// returned.
+//
+// Also returns the number of candidates which change the paths being
+// bisected, and the number of them that the first commit returned reaches.
+func findBisection(list []*bisectCandidate, all bool) ([]*bisectCandidate, int, int) {
+	nr := 0
+	for _, p := range list {
+		if !p.treesame {
+			nr++
+		}
+	}
+	if len(list) == 0 {
+		return nil, nr, 0
+	}
+	best := doFindBisection(list, nr, all)
+	if best != nil {
+		return []*bisectCandidate{best}, nr, best.weight
+	}
+
+	var sorted []*bisectCandidate
+	for _, p := range list {
+		if !p.treesame {
+			sorted = append(sorted, p)
+		}
+	}
+	distance := func(p *bisectCandidate) int {
+		if nr-p.weight < p.weight {
+			return nr - p.weight
+		}
+		return p.weight
+	}
+	if !all {
+		best := list[0]
+		bestDistance := -1
+		for _, p := range sorted {
+			if d := distance(p); d > bestDistance {
+				best, bestDistance = p, d
+			}
+		}
+		return []*bisectCandidate{best}, nr, best.weight
+	}
+	if len(sorted) == 0 {
+		return nil, nr, 0
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if di, dj := distance(sorted[i]), distance(sorted[j]); di != dj {
+			return di > dj
+		}
+		return sorted[i].commit.String() < sorted[j].commit.String()
+	})
+	return sorted, nr, sorted[0].weight
+}
+
+// Weighs the candidates, and returns the first one found to be halfway
+// through them, unless all is set.
+//
+// A commit with a single parent in the list reaches one more commit than
+// its parent, so only merges need their ancestors counted.
+func doFindBisection(list []*bisectCandidate, nr int, all bool) *bisectCandidate {
+	halfway := func(p *bisectCandidate) bool {
+		if p.treesame || all {
+			return false
+		}
+		diff := 2*p.weight - nr
+		return diff >= -1 && diff <= 1
+	}
+
+	counted := 0
+	for _, p := range list {
+		switch len(p.parents) {
+		case 0:
+			if !p.treesame {
+				p.weight = 1
+				counted++
+			} else {
+				p.weight = 0
+			}
+		case 1:
+			p.weight = -1
+		default:
+			p.weight = -2
+		}
+	}
+
+	for _, p := range list {
+		if p.weight != -2 {
+			continue
+		}
+		p.weight = p.distance
+		if halfway(p) {
+			return p
+		}
+		counted++
+	}
+
+	for counted < nr {
+		progress := false
+		for _, p := range list {
+			if p.weight >= 0 {
+				continue
+			}
+			var q *bisectCandidate
+			for _, j := range p.parents {
+				if list[j].weight >= 0 {
+					q = list[j]
+					break
+				}
+			}
+			if q == nil {
+				continue
+			}
+			progress = true
+			if !p.treesame {
+				p.weight = q.weight + 1
+				counted++
+			} else {
+				p.weight = q.weight
+			}
+			if halfway(p) {
+				return p
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+	return nil
+}
+
+// Takes the skipped commits out of list, which is sorted by how well they
+// halve the bisection, and returns them separately.
+//
+// If the best commit wasn't skipped, it's the only one returned. Otherwise
+// another is picked pseudo-randomly, in the same way as git, so that the
+// commits next to a skipped one aren't tried one after another.
+func managedSkipped(list []*bisectCandidate, skipped map[CommitID]bool, bad CommitID) ([]*bisectCandidate, []*bisectCandidate) {
+	if len(skipped) == 0 {
+		return list, nil
+	}
+	var filtered, tried []*bisectCandidate
+	for i, p := range list {
+		if skipped[p.commit] {
+			tried = append(tried, p)
+			continue
+		}
+		if i == 0 {
+			return []*bisectCandidate{p}, nil
+		}
+		filtered = append(filtered, p)
+	}
+	if len(filtered) == 0 {
+		return nil, tried
+	}
+
+	count := len(filtered)
+	prn := int((uint32(count)*1103515245 + 12345) / 65536 % 32768)
+	index := (count * prn / 32768) * sqrti(prn) / sqrti(32768)
+	if index < count {
+		switch {
+		case filtered[index].commit != bad:
+			return filtered[index:], tried
+		case index > 0:
+			return filtered[index-1:], tried
+		}
+	}
+	return filtered, tried
+}
+
+// Returns the integer square root of val, computed the same way as git so
+// that the same skipped commits are picked.
+func sqrti(val int) int {
+	if val == 0 {
+		return 0
+	}
+	x := float32(val)
+	for {
+		y := (x + float32(val)/x) / 2
+		d := y - x
+		if d < 0 {
+			d = -d
+		}
+		x = y
+		if d < 0.5 {
+			return int(x)
+		}
+	}
+}
+
+// Estimates the number of steps left to bisect all commits, as git does.
+func estimateBisectSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+	n := 0
+	for 1<<uint(n+1) <= all {
+		n++
+	}
+	e := 1 << uint(n)
+	x := all - e
+	if e < 3*x {
+		return n
+	}
+	return n - 1
+}
+
+// Implements "git bisect reset [<commit>]". If commit is empty, the branch
+// or commit the bisect was started from is checked out.
+func BisectReset(c *Client, commit string) error {
+	branch := commit
+	if commit == "" {
+		if !c.IsBisecting() {
+			fmt.Println("We are not bisecting.")
+			return nil
+		}
+		content, err := c.GitDir.ReadFile(bisectStartFile)
+		if err != nil {
+			return err
+		}
+		branch = strings.TrimSpace(string(content))
+	} else if _, err := RevParseCommit(c, &RevParseOptions{}, commit); err != nil {
+		return fmt.Errorf("'%v' is not a valid commit", commit)
+	}
+	if err := Checkout(c, CheckoutOptions{}, branch, nil); err != nil {
+		return fmt.Errorf("could not check out original HEAD '%v'. Try 'git bisect reset <commit>'.", branch)
+	}
+	return bisectCleanState(c)
+}
+
+// Implements "git bisect log".
+func BisectLog(c *Client, w io.Writer) error {
+	content, err := c.GitDir.ReadFile(bisectLogFile)
+	if err != nil || len(content) == 0 {
+		return fmt.Errorf("We are not bisecting.")
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// Implements "git bisect replay <logfile>". The bisect is reset, and the
+// start, good, bad and skip commands in the log are run again.
+func BisectReplay(c *Client, file File) error {
+	content, err := ioutil.ReadFile(file.String())
+	if err != nil || len(content) == 0 {
+		return fmt.Errorf("cannot read file '%v' for replaying", file)
+	}
+	if err := BisectReset(c, ""); err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimLeft(line, " \t")
+		var rest string
+		switch {
+		case strings.HasPrefix(line, "git bisect"):
+			rest = strings.TrimPrefix(line, "git bisect")
+		case strings.HasPrefix(line, "git-bisect"):
+			rest = strings.TrimPrefix(line, "git-bisect")
+		default:
+			continue
+		}
+		if rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+			continue
+		}
+		rest = strings.TrimLeft(rest, " \t")
+		command, arg := rest, ""
+		if i := strings.IndexAny(rest, " \t"); i >= 0 {
+			command, arg = rest[:i], strings.TrimLeft(rest[i:], " \t")
+		}
+		switch command {
+		case "start":
+			args, err := sqSplit(arg)
+			if err != nil {
+				return err
+			}
+			if err := BisectStart(c, args); err != nil {
+				return err
+			}
+		case "good", "bad", "skip":
+			b, err := loadBisect(c)
+			if err != nil {
+				return err
+			}
+			cmt, err := RevParseCommit(c, &RevParseOptions{}, arg)
+			if err != nil {
+				return fmt.Errorf("couldn't get the oid of the rev '%v'", arg)
+			}
+			if err := b.write(c, command, cmt, false); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("'%v'?? what are you talking about?", command)
+		}
+	}
+	b, err := loadBisect(c)
+	if err != nil {
+		return err
+	}
+	_, err = b.autoNext(c)
+	return err
+}
+
+// Implements "git bisect run <cmd> [<arg>...]". The command is run on each
+// commit that's checked out, and its exit code says whether the commit is
+// good (0), should be skipped (125) or is bad (anything else below 128),
+// until the first bad commit is found.
+func BisectRun(c *Client, command []string) error {
+	b, err := loadBisect(c)
+	if err != nil {
+		return err
+	}
+	if b.bad == (CommitID{}) || len(b.good) == 0 {
+		return fmt.Errorf("You need to give me at least one bad and good revision.\nYou can use \"git bisect bad\" and \"git bisect good\" for that.")
+	}
+	if len(command) == 0 {
+		return fmt.Errorf("bisect run failed: no command provided.")
+	}
+	var quoted strings.Builder
+	for _, arg := range command {
+		fmt.Fprintf(&quoted, " %v", sqQuote(arg))
+	}
+	for {
+		fmt.Printf("running %v\n", quoted.String())
+		code := 0
+		if err := c.execShell(quoted.String()); err != nil {
+			exit, ok := err.(*exec.ExitError)
+			if !ok {
+				return err
+			}
+			code = exit.ExitCode()
+		}
+		if code < 0 || code >= 128 {
+			return fmt.Errorf("bisect run failed: exit code %d from '%v' is < 0 or >= 128", code, quoted.String())
+		}
+		term := "bad"
+		switch code {
+		case 0:
+			term = "good"
+		case 125:
+			term = "skip"
+		}
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+		found, err := b.mark(c, term, []CommitID{head})
+		if err != nil {
+			return err
+		}
+		if found {
+			fmt.Println("bisect found first bad commit")
+			return nil
+		}
+	}
+}
+
+// Quotes s for the shell, in the same way as git does in its bisect log.
+func sqQuote(s string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'', '!':
+			fmt.Fprintf(&quoted, `'\%c'`, r)
+		default:
+			quoted.WriteRune(r)
+		}
+	}
+	quoted.WriteByte('\'')
+	return quoted.String()
+}
+
+// Splits a line of words which were quoted with sqQuote.
+func sqSplit(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quoted && ch == '\'':
+			quoted = false
+		case quoted:
+			word.WriteByte(ch)
+		case ch == '\'':
+			quoted, inWord = true, true
+		case ch == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
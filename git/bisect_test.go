@@ -0,0 +1,170 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBisect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitbisect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bug is introduced by the seventh commit.
+	var commits []CommitID
+	for i := 1; i <= 12; i++ {
+		content := "good\n"
+		if i >= 7 {
+			content = "bad\n"
+		}
+		if err := ioutil.WriteFile("foo.txt", []byte(content+strings.Repeat("x\n", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		cmt, err := Commit(c, CommitOptions{}, CommitMessage("commit"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, cmt)
+	}
+	first, last := commits[0].String(), commits[11].String()
+	found := func() bool {
+		t.Helper()
+		var log bytes.Buffer
+		if err := BisectLog(c, &log); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Contains(log.String(), "# first bad commit: ["+commits[6].String()+"]")
+	}
+
+	if err := BisectGood(c, nil); err == nil {
+		t.Error("Was able to mark a commit good without starting")
+	}
+	if err := BisectLog(c, ioutil.Discard); err == nil {
+		t.Error("Was able to show the log without starting")
+	}
+
+	// Test each commit that's checked out until the first bad one is
+	// found.
+	if err := BisectStart(c, []string{last, first}); err != nil {
+		t.Fatal(err)
+	}
+	steps := 0
+	for ; steps < 12 && !found(); steps++ {
+		content, err := ioutil.ReadFile("foo.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(string(content), "bad") {
+			err = BisectBad(c, nil)
+		} else {
+			err = BisectGood(c, nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if steps > 4 {
+		t.Errorf("Took %v steps to bisect 12 commits", steps)
+	}
+
+	// Replaying the log should get to the same place.
+	var log bytes.Buffer
+	if err := BisectLog(c, &log); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("bisect.log", log.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := BisectReplay(c, "bisect.log"); err != nil {
+		t.Fatal(err)
+	}
+	if !found() {
+		t.Error("Replaying the log didn't find the first bad commit")
+	}
+	os.Remove("bisect.log")
+
+	if err := BisectReset(c, ""); err != nil {
+		t.Fatal(err)
+	}
+	if c.IsBisecting() {
+		t.Error("Bisect state left after reset")
+	}
+	if b := c.GetHeadBranch(); b != "refs/heads/master" {
+		t.Errorf("Unexpected branch after reset: got %v", b)
+	}
+
+	// Run should classify the commits by the command's exit code.
+	if err := BisectStart(c, []string{last, first}); err != nil {
+		t.Fatal(err)
+	}
+	if err := BisectRun(c, []string{"sh", "-c", "grep -q bad foo.txt && exit 1; exit 0"}); err != nil {
+		t.Fatal(err)
+	}
+	if !found() {
+		t.Error("Run didn't find the first bad commit")
+	}
+
+	// If the only commit left to test is skipped, the bisect can't go
+	// any further.
+	if err := BisectStart(c, []string{commits[7].String(), commits[5].String()}); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := c.GetHeadCommit(); err != nil || head != commits[6] {
+		t.Fatalf("Unexpected commit to test: got %v, %v want %v", head, err, commits[6])
+	}
+	if err := BisectSkip(c, nil); err != ErrBisectOnlySkipped {
+		t.Errorf("Unexpected error skipping the last commit: %v", err)
+	}
+	if err := BisectReset(c, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBisectSkipped(t *testing.T) {
+	var list []*bisectCandidate
+	for i := 0; i < 11; i++ {
+		p := &bisectCandidate{}
+		p.commit[0] = byte(i)
+		if i > 0 {
+			p.parents = []int{i - 1}
+		}
+		list = append(list, p)
+	}
+
+	// Without any skipped commits, the first commit halfway through a
+	// string of commits is picked.
+	best, all, reaches := findBisection(list, false)
+	if len(best) != 1 || best[0] != list[4] || all != 11 || reaches != 5 {
+		t.Errorf("Unexpected bisection: got %v (%v of %v)", best, reaches, all)
+	}
+
+	// If the best ones are skipped, another one is picked.
+	skipped := map[CommitID]bool{list[4].commit: true, list[5].commit: true}
+	best, _, _ = findBisection(list, true)
+	best, tried := managedSkipped(best, skipped, list[10].commit)
+	if len(tried) != 2 || len(best) == 0 || skipped[best[0].commit] || best[0] == list[10] {
+		t.Errorf("Unexpected commit picked with skipped commits: got %v, tried %v", best, tried)
+	}
+
+	for all, want := range map[int]int{1: 0, 2: 0, 3: 1, 6: 2, 11: 3, 1024: 9, 1500: 10} {
+		if got := estimateBisectSteps(all); got != want {
+			t.Errorf("Unexpected steps for %v commits: got %v want %v", all, got, want)
+		}
+	}
+}
@@ -128,8 +128,7 @@ func CheckoutCommit(c *Client, opts CheckoutOptions, commit Commitish) error {
 		// commit is the startpoint in the last variation, otherwise
 		// Checkout() already set it to the commit of "HEAD"
 		newRefspec = RefSpec("refs/heads/" + opts.Branch)
-		refspecfile := newRefspec.File(c)
-		if refspecfile.Exists() && !opts.ForceBranch {
+		if newRefspec.Exists(c) && !opts.ForceBranch {
 			return fmt.Errorf("fatal: A branch named '%v' already exists.", opts.Branch)
 		}
 	}
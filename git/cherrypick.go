@@ -0,0 +1,48 @@
+package git
+
+type CherryPickOptions struct {
+	Edit bool
+
+	MergeParent int
+
+	NoCommit bool
+	SignOff  bool
+
+	// Append "(cherry picked from commit ...)" to the commit message
+	RecordOrigin bool
+
+	// Commit changes which result in an empty commit, instead of
+	// stopping.
+	AllowEmpty bool
+
+	MergeStrategy       string
+	MergeStrategyOption string
+
+	Continue, Quit, Abort, Skip bool
+}
+
+// Applies the changes introduced by the given commits to HEAD, in order,
+// committing each one unless NoCommit is set. If there are conflicts, the
+// sequencer stops so that they can be resolved, and the remaining commits
+// are picked by a call with opts.Continue.
+func CherryPick(c *Client, opts CherryPickOptions, commits []Commitish) error {
+	switch {
+	case opts.Continue:
+		return sequencerContinue(c)
+	case opts.Skip:
+		return sequencerSkip(c)
+	case opts.Abort:
+		return sequencerAbort(c)
+	case opts.Quit:
+		return sequencerQuit(c)
+	}
+	return startSequence(c, sequencerOptions{
+		Edit:         opts.Edit,
+		Mainline:     opts.MergeParent,
+		NoCommit:     opts.NoCommit,
+		SignOff:      opts.SignOff,
+		RecordOrigin: opts.RecordOrigin,
+		AllowEmpty:   opts.AllowEmpty,
+		Strategy:     opts.MergeStrategy,
+	}, pickAction, commits)
+}
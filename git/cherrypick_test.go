@@ -0,0 +1,167 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCherryPick(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitcherrypick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// On one branch, add bar, change foo, then add baz. On master,
+	// change foo in a way that conflicts.
+	base := commitFile(t, c, "foo.txt", "foo\n", "base")
+	if err := CheckoutCommit(c, CheckoutOptions{Quiet: true, Detach: true}, base); err != nil {
+		t.Fatal(err)
+	}
+	bar := commitFile(t, c, "bar.txt", "bar\n", "bar")
+	foo := commitFile(t, c, "foo.txt", "foo2\n", "foo")
+	baz := commitFile(t, c, "baz.txt", "baz\n", "baz")
+	if err := Checkout(c, CheckoutOptions{Quiet: true}, "master", nil); err != nil {
+		t.Fatal(err)
+	}
+	master := commitFile(t, c, "foo.txt", "foo3\n", "master")
+
+	// The sequence should stop at the conflict in foo.
+	if err := CherryPick(c, CherryPickOptions{RecordOrigin: true}, []Commitish{bar, foo, baz}); err == nil {
+		t.Fatal("Expected a conflict")
+	}
+	if !c.IsSequencing() {
+		t.Fatal("Sequencer state was not saved")
+	}
+	if picked, err := c.GetPickHead(); err != nil || picked != foo {
+		t.Errorf("Unexpected CHERRY_PICK_HEAD: got %v, %v want %v", picked, err, foo)
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := head.GetCommitMessage(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "bar\n\n(cherry picked from commit " + bar.String() + ")\n"; msg.String() != want {
+		t.Errorf("Unexpected message: got %q want %q", msg, want)
+	}
+	if err := CherryPick(c, CherryPickOptions{}, []Commitish{baz}); err == nil {
+		t.Error("Was able to start a cherry-pick while one is in progress")
+	}
+
+	// Aborting should go back to where we started.
+	if err := CherryPick(c, CherryPickOptions{Abort: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := c.GetHeadCommit(); err != nil || head != master {
+		t.Errorf("Unexpected HEAD after abort: got %v, %v want %v", head, err, master)
+	}
+	if c.IsSequencing() || c.GitDir.File(cherryPickHeadFile).Exists() {
+		t.Error("Sequencer state left after abort")
+	}
+	expectContent(t, "foo.txt", "foo3\n")
+	if _, err := os.Stat("bar.txt"); err == nil {
+		t.Error("bar.txt left after abort")
+	}
+
+	// Resolving the conflict and continuing should pick the rest.
+	if err := CherryPick(c, CherryPickOptions{}, []Commitish{bar, foo, baz}); err == nil {
+		t.Fatal("Expected a conflict")
+	}
+	if err := CherryPick(c, CherryPickOptions{Continue: true}, nil); err == nil {
+		t.Error("Was able to continue with unmerged files")
+	}
+	commitFile(t, c, "foo.txt", "resolved\n", "resolved")
+	if err := CherryPick(c, CherryPickOptions{Continue: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c.IsSequencing() {
+		t.Error("Sequencer state left after finishing")
+	}
+	expectContent(t, "baz.txt", "baz\n")
+	head, err = c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := head.GetCommitMessage(c); err != nil || msg.String() != "baz\n" {
+		t.Errorf("Unexpected message: got %q, %v", msg, err)
+	}
+
+	// Reverting the pick should remove baz again.
+	if err := Revert(c, RevertOptions{}, []Commitish{head}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("baz.txt"); err == nil {
+		t.Error("baz.txt not removed by revert")
+	}
+	reverted, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = reverted.GetCommitMessage(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Revert \"baz\"\n\nThis reverts commit " + head.String() + ".\n"; msg.String() != want {
+		t.Errorf("Unexpected revert message: got %q want %q", msg, want)
+	}
+
+	// If the commit can't be made, continuing should make it with the
+	// same message.
+	defer os.Setenv("EDITOR", os.Getenv("EDITOR"))
+	os.Setenv("EDITOR", "false")
+	if err := Revert(c, RevertOptions{Edit: true}, []Commitish{reverted}); err == nil {
+		t.Fatal("Expected the editor to fail")
+	}
+	if !c.GitDir.File(mergeMsgFile).Exists() {
+		t.Error("MERGE_MSG not written before committing")
+	}
+	if h, err := c.GetHeadCommit(); err != nil || h != reverted {
+		t.Errorf("Unexpected HEAD after failed commit: got %v, %v want %v", h, err, reverted)
+	}
+	os.Setenv("EDITOR", "true")
+	if err := Revert(c, RevertOptions{Continue: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c.IsSequencing() || c.GitDir.File(mergeMsgFile).Exists() {
+		t.Error("Sequencer state left after continuing")
+	}
+	expectContent(t, "baz.txt", "baz\n")
+	head, err = c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Revert \"Revert \"baz\"\"\n\nThis reverts commit " + reverted.String() + ".\n"; head == reverted {
+		t.Error("Continuing didn't commit")
+	} else if msg, err := head.GetCommitMessage(c); err != nil || msg.String() != want {
+		t.Errorf("Unexpected message after continuing: got %q, %v want %q", msg, err, want)
+	}
+}
+
+func TestAddTrailer(t *testing.T) {
+	tests := []struct {
+		message, trailer, want string
+	}{
+		{"subject\n", "A: b", "subject\n\nA: b\n"},
+		{"subject\n\nbody\n", "A: b", "subject\n\nbody\n\nA: b\n"},
+		{"subject\n\nSigned-off-by: x\n", "A: b", "subject\n\nSigned-off-by: x\nA: b\n"},
+		{"subject\n\n(cherry picked from commit abc)", "A: b", "subject\n\n(cherry picked from commit abc)\nA: b\n"},
+		{"Key: value\n", "A: b", "Key: value\n\nA: b\n"},
+	}
+	for _, tc := range tests {
+		if got := addTrailer(tc.message, tc.trailer); got != tc.want {
+			t.Errorf("addTrailer(%q, %q): got %q want %q", tc.message, tc.trailer, got, tc.want)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package git
 
 import (
-	"bufio"
 	"crypto/sha1"
 	"fmt"
 	"io"
@@ -121,9 +120,19 @@ type Client struct {
 
 	objcache map[shaRef]GitObject
 
-	// Cache of previous config lookups to avoid re-parsing.
-	configCache               map[string]string
-	localConfig, globalConfig *GitConfig
+	// The indexes of the packfiles in the repository.
+	packs packStore
+
+	// The commit-graph of the repository, if it has one.
+	graph commitGraphStore
+
+	// Config variables set on the command line for this session only.
+	configCache []ConfigValue
+
+	// The values from the config files, once they've been loaded, and
+	// the error from loading them.
+	config    []ConfigValue
+	configErr error
 }
 
 func (c *Client) Close() error {
@@ -191,8 +200,9 @@ func findGitDir() GitDir {
 	if err != nil {
 		return ""
 	}
-	config := ParseConfig(configFile)
-	if err := configFile.Close(); err != nil {
+	config, err := ParseConfig(configFile)
+	configFile.Close()
+	if err != nil {
 		return ""
 	}
 
@@ -227,7 +237,7 @@ func NewClient(gitDir, workDir string) (*Client, error) {
 		}
 	}
 	m := make(map[Sha1]objectLocation)
-	return &Client{GitDir(gitdir), WorkDir(workdir), "", m, make(map[shaRef]GitObject), nil, nil, nil}, nil
+	return &Client{GitDir(gitdir), WorkDir(workdir), "", m, make(map[shaRef]GitObject), packStore{}, commitGraphStore{}, nil, nil, nil}, nil
 }
 
 // Returns the branchname of the HEAD branch, or the empty string if the
@@ -288,45 +298,26 @@ func (c *Client) ResetWorkTree() error {
 
 // Return valid branches that a Client knows about.
 func (c *Client) GetBranches() ([]Branch, error) {
-	files := []string{}
-
-	err := filepath.Walk(filepath.Join(c.GitDir.String(), "refs", "heads"),
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() {
-				files = append(files, path)
-			}
-			return nil
-		})
+	names, err := c.refNames("refs/heads/")
 	if err != nil {
 		return nil, err
 	}
 
 	branches := []Branch{}
-	for _, f := range files {
-		branches = append(branches, Branch(f[len(c.GitDir.String())+1:]))
+	for _, name := range names {
+		branches = append(branches, Branch(name))
 	}
 	return branches, nil
 }
 
 // Return valid branches that a Client knows about.
 func (c *Client) GetRemoteBranches() (branches []Branch, err error) {
-	remotes, err := ioutil.ReadDir(c.GitDir.String() + "/refs/remotes")
+	names, err := c.refNames("refs/remotes/")
 	if err != nil {
 		return nil, err
 	}
-	for _, d := range remotes {
-		if d.IsDir() {
-			brs, err := ioutil.ReadDir(c.GitDir.String() + "/refs/remotes/" + d.Name())
-			if err != nil {
-				continue
-			}
-			for _, b := range brs {
-				branches = append(branches, Branch("refs/remotes/"+d.Name()+"/"+b.Name()))
-			}
-		}
+	for _, name := range names {
+		branches = append(branches, Branch(name))
 	}
 	return
 }
@@ -490,21 +481,29 @@ func (c *Client) WriteObject(objType string, rawdata []byte) (Sha1, error) {
 		}
 		return Sha1(sha), nil
 	}
+	if err := c.writeLooseObject(Sha1(sha), obj); err != nil {
+		return Sha1{}, err
+	}
+	return Sha1(sha), nil
+}
+
+// Writes obj, which must include the object header, to the loose object
+// file for sha whether or not the object already exists in a pack.
+func (c *Client) writeLooseObject(sha Sha1, obj []byte) error {
 	directory := fmt.Sprintf("%x", sha[0:1])
 	file := fmt.Sprintf("%x", sha[1:])
 
 	os.MkdirAll(c.GitDir.String()+"/objects/"+directory, os.FileMode(0755))
 	f, err := c.GitDir.Create(File("objects/" + directory + "/" + file))
 	if err != nil {
-		return Sha1{}, err
+		return err
 	}
 	defer f.Close()
 	w := zlib.NewWriter(f)
 	if _, err := w.Write(obj); err != nil {
-		return Sha1{}, err
+		return err
 	}
-	defer w.Close()
-	return Sha1(sha), nil
+	return w.Close()
 }
 
 // Returns true if the file on the filesystem hashes to Sha1, (which is usually
@@ -563,104 +562,20 @@ func (c *Client) HaveObject(id Sha1) (found bool, packedfile File, err error) {
 	}
 
 	// Then, check if it's in a pack file.
-	files, err := ioutil.ReadDir(c.GitDir.File("objects/pack").String())
+	pack, offset, found, err := c.packs.find(c, id)
 	if err != nil {
-		// The pack directory doesn't exist. It's not an error, but it definitely
-		// doesn't have the file..
-		log.Printf("No pack directories to search for object %s\n", id)
-		return false, "", nil
-	}
-	for _, fi := range files {
-		if filepath.Ext(fi.Name()) == ".idx" {
-			// It's ambiguous if Name() has the full path or not according to what
-			// ReadDir returns, so just be very cautious on how we open it.
-			name := c.GitDir.File(File(fmt.Sprintf("objects/pack/%s", filepath.Base(fi.Name()))))
-			f, err := os.Open(name.String())
-			if err != nil {
-				log.Print(err)
-				continue
-			}
-			pfile := File(strings.TrimSuffix(name.String(), ".idx"))
-			buf := bufio.NewReader(f)
-			if v2PackIndexHasSha1(c, pfile, buf, id) {
-				// We want to return the pack file, not the index.
-				f.Close()
-				log.Printf("Found object %s in pack file %s\n", id, fi.Name())
-				return true, pfile, nil
-			}
-			f.Close()
-		}
+		return false, "", err
+	}
+	if found {
+		log.Printf("Found object %s in pack file %s\n", id, pack.name)
+		c.objectCache[id] = objectLocation{false, pack.name, pack.idx, offset}
+		return true, pack.name, nil
 	}
 
 	log.Printf("None of the pack files has object %s\n", id)
 	return false, "", nil
 }
 
-// Sets a cached config for this session only. None of these configs
-// will be persisted into the local or global configuration. Once the
-// client is closed or is garbage collected the configuration is lost.
-func (c *Client) SetCachedConfig(varname string, value string) {
-	if c.configCache == nil {
-		c.configCache = make(map[string]string)
-	}
-
-	c.configCache[varname] = value
-}
-
-// Gets a cached config variable if it is there. Otherwise, it returns
-//  and empty string.
-func (c *Client) GetCachedConfig(varname string) string {
-	if c.configCache == nil {
-		return ""
-	}
-
-	return c.configCache[varname]
-}
-
-// Loads a config variable for the git repo hosted by c.
-// If the local variable exists, it will be used, otherwise
-// it will use the global variable.
-// Non-existent variables will return the empty string.
-func (c *Client) GetConfig(varname string) string {
-	if c.configCache == nil {
-		c.configCache = make(map[string]string)
-	}
-	if val, ok := c.configCache[varname]; ok {
-		return val
-	}
-	if c.localConfig == nil {
-		config, err := LoadLocalConfig(c)
-		if err == nil {
-			c.localConfig = &config
-		} else {
-			// If there was an error we store
-			// a non-nil empty value in the localconfig
-			// cache to prevent it from being re-parsed
-			c.localConfig = &GitConfig{}
-		}
-	}
-	if val, _ := c.localConfig.GetConfig(varname); val != "" {
-		c.configCache[varname] = val
-		return val
-	}
-	if c.globalConfig == nil {
-		config, err := LoadGlobalConfig()
-		if err == nil {
-			c.globalConfig = &config
-		} else {
-			// If there was an error we store
-			// a non-nil empty value in the localconfig
-			// cache to prevent it from being re-parsed
-			c.globalConfig = &GitConfig{}
-		}
-	}
-	if val, _ := c.globalConfig.GetConfig(varname); val != "" {
-		c.configCache[varname] = val
-		return val
-	}
-	return ""
-}
-
 // Returns the .git/objects directory.
 func (c *Client) GetObjectsDir() File {
 	if objdir := os.Getenv("GIT_OBJECT_DIRECTORY"); objdir != "" {
@@ -70,6 +70,9 @@ func Commit(c *Client, opts CommitOptions, message CommitMessage, files []File)
 	if opts.Patch {
 		return CommitID{}, fmt.Errorf("Commit --patch not implemented")
 	}
+	if opts.Amend && c.IsMerging() {
+		return CommitID{}, fmt.Errorf("You are in the middle of a merge -- cannot amend.")
+	}
 
 	var idx *Index
 
@@ -108,6 +111,11 @@ func Commit(c *Client, opts CommitOptions, message CommitMessage, files []File)
 		}
 		idx = idx1
 	}
+	for _, entry := range idx.Objects {
+		if entry.Stage() != Stage0 {
+			return CommitID{}, fmt.Errorf("Committing is not possible because you have unmerged files.")
+		}
+	}
 	// Happy path: write the tree
 	treeid, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
 	if err != nil {
@@ -121,37 +129,37 @@ func Commit(c *Client, opts CommitOptions, message CommitMessage, files []File)
 		if err != nil {
 			return CommitID{}, err
 		}
-		author, err := oldHead.GetAuthor(c)
-		if err != nil {
-			return CommitID{}, err
-		}
 		if !opts.ResetAuthor {
-			// Back up the environment variables that commit uses to
-			// communicate with commit-tree, so that nothing external
-			// changes to the caller of this script.
-			defer func(oldauthorname, oldauthoremail, oldauthordate string) {
-				os.Setenv("GIT_AUTHOR_NAME", oldauthorname)
-				os.Setenv("GIT_AUTHOR_EMAIL", oldauthoremail)
-				os.Setenv("GIT_AUTHOR_DATE", oldauthordate)
-
-			}(
-				os.Getenv("GIT_AUTHOR_NAME"),
-				os.Getenv("GIT_AUTHOR_EMAIL"),
-				os.Getenv("GIT_AUTHOR_DATE"),
-			)
-			os.Setenv("GIT_AUTHOR_NAME", author.Name)
-			os.Setenv("GIT_AUTHOR_EMAIL", author.Email)
-			date, err := oldHead.GetDate(c)
+			restore, err := useCommitAuthor(c, oldHead)
 			if err != nil {
 				return CommitID{}, err
 			}
-			os.Setenv("GIT_AUTHOR_DATE", date.Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+			defer restore()
 		}
 		goto skipemptycheck
 	} else if err == nil || err == DetachedHead {
 		parents = append(parents, oldHead)
 	}
 
+	// A commit which is being cherry-picked keeps its author.
+	if picked, err := c.GetPickHead(); err == nil && !opts.ResetAuthor {
+		restore, err := useCommitAuthor(c, picked)
+		if err != nil {
+			return CommitID{}, err
+		}
+		defer restore()
+	}
+
+	if c.IsMerging() {
+		mergeHeads, err := c.GetMergeHeads()
+		if err != nil {
+			return CommitID{}, err
+		}
+		parents = append(parents, mergeHeads...)
+		// A merge commit is never empty, even if the tree is the same.
+		goto skipemptycheck
+	}
+
 	if !opts.AllowEmpty {
 		if oldtree, err := oldHead.TreeID(c); err == nil {
 			if oldtree == treeid {
@@ -182,14 +190,48 @@ skipemptycheck:
 	} else {
 		refmsg = cleanMessage[:50]
 	}
-	refmsg = fmt.Sprintf("commit: %s (dgit)", refmsg)
+	if len(parents) > 1 {
+		refmsg = fmt.Sprintf("commit (merge): %s (dgit)", refmsg)
+	} else {
+		refmsg = fmt.Sprintf("commit: %s (dgit)", refmsg)
+	}
 
 	if err := UpdateRef(c, UpdateRefOptions{OldValue: oldHead, CreateReflog: true}, "HEAD", cid, refmsg); err != nil {
 		return CommitID{}, err
 	}
+	c.clearMergeState()
 	return cid, noConfig
 }
 
+// Sets the environment variables which CommitTree uses for the author of
+// the commit to the author of cmt. It returns a function which restores
+// them, so that nothing external changes for the caller.
+func useCommitAuthor(c *Client, cmt CommitID) (func(), error) {
+	author, err := cmt.GetAuthor(c)
+	if err != nil {
+		return nil, err
+	}
+	date, err := cmt.GetDate(c)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{
+		"GIT_AUTHOR_NAME":  author.Name,
+		"GIT_AUTHOR_EMAIL": author.Email,
+		"GIT_AUTHOR_DATE":  date.Format("Mon, 02 Jan 2006 15:04:05 -0700"),
+	}
+	old := make(map[string]string)
+	for name, value := range vars {
+		old[name] = os.Getenv(name)
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, value := range old {
+			os.Setenv(name, value)
+		}
+	}, nil
+}
+
 type CommitMessage string
 
 func (cm CommitMessage) String() string {
@@ -231,11 +273,12 @@ func (cm CommitMessage) strip() string {
 		}
 		filtered = append(filtered, line)
 	}
-	return strings.Join(filtered, "\n")
+	// Removing comments may have left blank lines at the end.
+	return CommitMessage(strings.Join(filtered, "\n")).whitespace()
 }
 
 func (cm CommitMessage) Subject() string {
-	lines := strings.SplitN(cm.whitespace(), "\n", 1)
+	lines := strings.SplitN(cm.whitespace(), "\n", 2)
 	if len(lines) > 0 {
 		return strings.TrimSpace(lines[0])
 	}
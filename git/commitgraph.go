@@ -0,0 +1,529 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// The value of a parent in the commit data chunk when there is no
+	// parent.
+	graphParentNone = 0x70000000
+
+	// Set on the second parent in the commit data chunk when the
+	// remaining parents of an octopus merge are in the extra edges
+	// chunk, and on the last parent in the extra edges chunk.
+	graphParentEdges = 0x80000000
+
+	// The largest generation number that can be stored. Commits with
+	// more ancestors than this all have this generation number.
+	graphGenerationMax = 0x3FFFFFFF
+
+	// The size of each commit's entry in the commit data chunk: the
+	// root tree, two parents, and the generation number and commit
+	// time.
+	graphDataWidth = 20 + 4 + 4 + 8
+)
+
+// CommitGraphWriteOptions are the options that may be passed to
+// CommitGraphWrite.
+type CommitGraphWriteOptions struct {
+	// Walk the commits reachable from every ref and HEAD, instead of
+	// using the commits in the packfiles.
+	Reachable bool
+
+	// Only use the commits in these packfiles, named by their index
+	// file in the objects/pack directory.
+	Packs []string
+
+	// Include the commits from the existing commit-graph.
+	Append bool
+}
+
+// A commitGraph is a parsed objects/info/commit-graph file. It stores
+// the parents, root tree, generation number and commit time of commits,
+// so that walking the history doesn't need to inflate and parse every
+// commit object.
+type commitGraph struct {
+	fanout [256]uint32
+
+	// The chunks of the file that we use.
+	oids, data, edges []byte
+}
+
+// A commitGraphStore caches the commit-graph for a Client.
+type commitGraphStore struct {
+	graph  *commitGraph
+	loaded bool
+}
+
+// Parses the contents of a commit-graph file. Chunks which we don't use
+// are ignored.
+func parseCommitGraph(data []byte) (*commitGraph, error) {
+	if len(data) < 8 || string(data[:4]) != "CGPH" {
+		return nil, fmt.Errorf("Invalid commit-graph signature")
+	}
+	if data[4] != 1 {
+		return nil, fmt.Errorf("Unsupported commit-graph version %d", data[4])
+	}
+	if data[5] != 1 {
+		return nil, fmt.Errorf("Unsupported commit-graph hash version %d", data[5])
+	}
+	nchunks := int(data[6])
+	if len(data) < 8+(nchunks+1)*12 {
+		return nil, fmt.Errorf("Commit-graph is too short")
+	}
+
+	// The table of contents has an entry for each chunk with its
+	// offset, followed by an entry with the offset of the end of the
+	// last chunk.
+	chunks := make(map[string][]byte)
+	for i := 0; i < nchunks; i++ {
+		entry := data[8+i*12:]
+		start := binary.BigEndian.Uint64(entry[4:])
+		end := binary.BigEndian.Uint64(entry[16:])
+		if start > end || end > uint64(len(data)) {
+			return nil, fmt.Errorf("Invalid offset for commit-graph chunk %q", entry[:4])
+		}
+		chunks[string(entry[:4])] = data[start:end]
+	}
+
+	g := &commitGraph{
+		oids:  chunks["OIDL"],
+		data:  chunks["CDAT"],
+		edges: chunks["EDGE"],
+	}
+	fanout := chunks["OIDF"]
+	if len(fanout) != 256*4 {
+		return nil, fmt.Errorf("Commit-graph is missing the OID fanout chunk")
+	}
+	for i := range g.fanout {
+		g.fanout[i] = binary.BigEndian.Uint32(fanout[i*4:])
+	}
+	n := int(g.fanout[255])
+	if len(g.oids) != n*20 || len(g.data) != n*graphDataWidth {
+		return nil, fmt.Errorf("Commit-graph chunks do not match the number of commits")
+	}
+	return g, nil
+}
+
+// Reads the commit-graph for c. If there isn't one, nil is returned.
+func readCommitGraph(c *Client) (*commitGraph, error) {
+	data, err := ioutil.ReadFile(c.GetObjectsDir().String() + "/info/commit-graph")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseCommitGraph(data)
+}
+
+// Returns the commit-graph for the repository, or nil if there isn't one
+// or it's disabled by core.commitGraph. The file is only read once.
+func (c *Client) commitGraph() *commitGraph {
+	if !c.graph.loaded {
+		c.graph.loaded = true
+		if c.GetConfig("core.commitgraph") != "false" {
+			g, err := readCommitGraph(c)
+			if err != nil {
+				log.Printf("Could not read commit-graph: %v", err)
+			}
+			c.graph.graph = g
+		}
+	}
+	return c.graph.graph
+}
+
+// Returns the commit-graph and the position of cmt in it, if cmt is in the
+// commit-graph for c.
+func (c *Client) graphCommit(cmt CommitID) (*commitGraph, uint32, bool) {
+	g := c.commitGraph()
+	if g == nil {
+		return nil, 0, false
+	}
+	i, ok := g.find(cmt)
+	return g, i, ok
+}
+
+// Returns the position of cmt in the commit-graph, using a binary search
+// of the commits with the same first byte.
+func (g *commitGraph) find(cmt CommitID) (uint32, bool) {
+	lo := 0
+	if cmt[0] > 0 {
+		lo = int(g.fanout[cmt[0]-1])
+	}
+	hi := int(g.fanout[cmt[0]])
+	if lo > hi || hi*20 > len(g.oids) {
+		return 0, false
+	}
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(g.oids[(lo+i)*20:(lo+i+1)*20], cmt[:]) >= 0
+	})
+	if i < hi && bytes.Equal(g.oids[i*20:(i+1)*20], cmt[:]) {
+		return uint32(i), true
+	}
+	return 0, false
+}
+
+func (g *commitGraph) oid(i uint32) CommitID {
+	var cmt CommitID
+	copy(cmt[:], g.oids[i*20:])
+	return cmt
+}
+
+func (g *commitGraph) entry(i uint32) []byte {
+	return g.data[i*graphDataWidth : (i+1)*graphDataWidth]
+}
+
+// Returns the root tree of the commit at position i.
+func (g *commitGraph) tree(i uint32) TreeID {
+	var t TreeID
+	copy(t[:], g.entry(i))
+	return t
+}
+
+// Returns the generation number of the commit at position i. A commit
+// with no parents has generation 1, and any other commit has a generation
+// one more than its parent with the highest generation.
+func (g *commitGraph) generation(i uint32) uint32 {
+	return binary.BigEndian.Uint32(g.entry(i)[28:]) >> 2
+}
+
+// Returns the positions of the parents of the commit at position i.
+func (g *commitGraph) parentPositions(i uint32) ([]uint32, error) {
+	e := g.entry(i)
+	n := uint32(len(g.oids) / 20)
+	var parents []uint32
+	p1 := binary.BigEndian.Uint32(e[20:])
+	if p1 == graphParentNone {
+		return nil, nil
+	}
+	if p1 >= n {
+		return nil, fmt.Errorf("Invalid parent in commit-graph for %v", g.oid(i))
+	}
+	parents = append(parents, p1)
+
+	p2 := binary.BigEndian.Uint32(e[24:])
+	switch {
+	case p2 == graphParentNone:
+	case p2&graphParentEdges != 0:
+		for edge := p2 &^ graphParentEdges; ; edge++ {
+			if int(edge+1)*4 > len(g.edges) {
+				return nil, fmt.Errorf("Invalid extra edges in commit-graph for %v", g.oid(i))
+			}
+			p := binary.BigEndian.Uint32(g.edges[edge*4:])
+			if p&^graphParentEdges >= n {
+				return nil, fmt.Errorf("Invalid parent in commit-graph for %v", g.oid(i))
+			}
+			parents = append(parents, p&^graphParentEdges)
+			if p&graphParentEdges != 0 {
+				break
+			}
+		}
+	case p2 >= n:
+		return nil, fmt.Errorf("Invalid parent in commit-graph for %v", g.oid(i))
+	default:
+		parents = append(parents, p2)
+	}
+	return parents, nil
+}
+
+// Returns the parents of the commit at position i.
+func (g *commitGraph) parents(i uint32) ([]CommitID, error) {
+	positions, err := g.parentPositions(i)
+	if err != nil {
+		return nil, err
+	}
+	var parents []CommitID
+	for _, p := range positions {
+		parents = append(parents, g.oid(p))
+	}
+	return parents, nil
+}
+
+// Returns whether ancestor can be reached from tip by walking the
+// commit-graph. ok is false if either of them isn't in the commit-graph.
+// Commits whose generation number is too low to have ancestor as an
+// ancestor aren't walked.
+func (g *commitGraph) reachable(ancestor, tip CommitID) (reachable, ok bool, err error) {
+	a, ok := g.find(ancestor)
+	if !ok {
+		return false, false, nil
+	}
+	t, ok := g.find(tip)
+	if !ok {
+		return false, false, nil
+	}
+	min := g.generation(a)
+	seen := map[uint32]struct{}{t: {}}
+	stack := []uint32{t}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i == a {
+			return true, true, nil
+		}
+		// Any other commit with the same generation number can't
+		// have ancestor as an ancestor, unless the generation numbers
+		// are too big to store.
+		if gen := g.generation(i); gen < min || (gen == min && min < graphGenerationMax) {
+			continue
+		}
+		parents, err := g.parentPositions(i)
+		if err != nil {
+			return false, true, err
+		}
+		for _, p := range parents {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				stack = append(stack, p)
+			}
+		}
+	}
+	return false, true, nil
+}
+
+// CommitGraphWrite implements "git commit-graph write". It writes the
+// commits in the packfiles of c to objects/info/commit-graph, or the
+// commits selected by opts, or commits if any are given. Every commit
+// reachable from them is also included.
+func CommitGraphWrite(c *Client, opts CommitGraphWriteOptions, commits []Commitish) error {
+	var tips []CommitID
+	switch {
+	case len(commits) > 0:
+		for _, cmt := range commits {
+			id, err := cmt.CommitID(c)
+			if err != nil {
+				return err
+			}
+			tips = append(tips, id)
+		}
+	case opts.Reachable:
+		refs, err := refTips(c, "refs/", "", nil, "")
+		if err != nil {
+			return err
+		}
+		if head, err := c.GetHeadCommit(); err == nil {
+			refs = append(refs, head)
+		}
+		for _, ref := range refs {
+			id, err := ref.CommitID(c)
+			if err != nil {
+				return err
+			}
+			tips = append(tips, id)
+		}
+	default:
+		packs, err := c.packs.list(c)
+		if err != nil {
+			return err
+		}
+		wanted := make(map[string]bool)
+		for _, p := range opts.Packs {
+			wanted[strings.TrimSuffix(strings.TrimSuffix(filepath.Base(p), ".idx"), ".pack")] = true
+		}
+		for _, p := range packs {
+			if len(wanted) > 0 && !wanted[filepath.Base(p.name.String())] {
+				continue
+			}
+			for _, s := range p.idx.Sha1Table {
+				if t, _, err := c.GetObjectMetadata(s); err == nil && t == "commit" {
+					tips = append(tips, CommitID(s))
+				}
+			}
+		}
+	}
+	if opts.Append {
+		if g := c.commitGraph(); g != nil {
+			for i := uint32(0); i < g.fanout[255]; i++ {
+				tips = append(tips, g.oid(i))
+			}
+		}
+	}
+
+	// The commit-graph must include every parent of the commits in it.
+	parents := make(map[CommitID][]CommitID)
+	for len(tips) > 0 {
+		cmt := tips[len(tips)-1]
+		tips = tips[:len(tips)-1]
+		if _, ok := parents[cmt]; ok {
+			continue
+		}
+		ps, err := cmt.Parents(c)
+		if err != nil {
+			return err
+		}
+		parents[cmt] = ps
+		tips = append(tips, ps...)
+	}
+	if len(parents) == 0 {
+		return nil
+	}
+
+	sorted := make([]CommitID, 0, len(parents))
+	for cmt := range parents {
+		sorted = append(sorted, cmt)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+	pos := make(map[CommitID]uint32, len(sorted))
+	for i, cmt := range sorted {
+		pos[cmt] = uint32(i)
+	}
+	generations, err := commitGenerations(sorted, parents)
+	if err != nil {
+		return err
+	}
+
+	var fanout, oids, data, edges bytes.Buffer
+	var counts [256]uint32
+	for _, cmt := range sorted {
+		counts[cmt[0]]++
+	}
+	var total uint32
+	for _, n := range counts {
+		total += n
+		binary.Write(&fanout, binary.BigEndian, total)
+	}
+	for _, cmt := range sorted {
+		oids.Write(cmt[:])
+
+		tree, err := cmt.TreeID(c)
+		if err != nil {
+			return err
+		}
+		date, err := cmt.GetCommitterDate(c)
+		if err != nil {
+			return err
+		}
+		data.Write(tree[:])
+		ps := parents[cmt]
+		p1, p2 := uint32(graphParentNone), uint32(graphParentNone)
+		if len(ps) > 0 {
+			p1 = pos[ps[0]]
+		}
+		switch {
+		case len(ps) == 2:
+			p2 = pos[ps[1]]
+		case len(ps) > 2:
+			p2 = graphParentEdges | uint32(edges.Len()/4)
+			for i, p := range ps[1:] {
+				e := pos[p]
+				if i == len(ps)-2 {
+					e |= graphParentEdges
+				}
+				binary.Write(&edges, binary.BigEndian, e)
+			}
+		}
+		t := uint64(date.Unix())
+		binary.Write(&data, binary.BigEndian, p1)
+		binary.Write(&data, binary.BigEndian, p2)
+		binary.Write(&data, binary.BigEndian, generations[cmt]<<2|uint32(t>>32)&3)
+		binary.Write(&data, binary.BigEndian, uint32(t))
+	}
+
+	type chunk struct {
+		id      string
+		content []byte
+	}
+	chunks := []chunk{{"OIDF", fanout.Bytes()}, {"OIDL", oids.Bytes()}, {"CDAT", data.Bytes()}}
+	if edges.Len() > 0 {
+		chunks = append(chunks, chunk{"EDGE", edges.Bytes()})
+	}
+	var file bytes.Buffer
+	file.WriteString("CGPH")
+	file.Write([]byte{1, 1, byte(len(chunks)), 0})
+	offset := uint64(8 + (len(chunks)+1)*12)
+	for _, ch := range chunks {
+		file.WriteString(ch.id)
+		binary.Write(&file, binary.BigEndian, offset)
+		offset += uint64(len(ch.content))
+	}
+	file.Write([]byte{0, 0, 0, 0})
+	binary.Write(&file, binary.BigEndian, offset)
+	for _, ch := range chunks {
+		file.Write(ch.content)
+	}
+	sum := sha1.Sum(file.Bytes())
+	file.Write(sum[:])
+
+	infodir := c.GetObjectsDir().String() + "/info"
+	if err := os.MkdirAll(infodir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(infodir, "tmp_graph_")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(file.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// Like git, the commit-graph is read only.
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), infodir+"/commit-graph"); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.graph = commitGraphStore{}
+	return nil
+}
+
+// Calculates the generation number of each commit in commits, whose
+// parents are in parents. The history is walked without recursion, since
+// it may be very deep.
+func commitGenerations(commits []CommitID, parents map[CommitID][]CommitID) (map[CommitID]uint32, error) {
+	generations := make(map[CommitID]uint32, len(commits))
+	for _, cmt := range commits {
+		stack := []CommitID{cmt}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if _, ok := generations[top]; ok {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			var gen uint32
+			ready := true
+			for _, p := range parents[top] {
+				pgen, ok := generations[p]
+				if !ok {
+					if _, known := parents[p]; !known {
+						return nil, fmt.Errorf("Parent %v of %v is missing", p, top)
+					}
+					stack = append(stack, p)
+					ready = false
+				}
+				if pgen > gen {
+					gen = pgen
+				}
+			}
+			if !ready {
+				continue
+			}
+			if gen < graphGenerationMax {
+				gen++
+			}
+			generations[top] = gen
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return generations, nil
+}
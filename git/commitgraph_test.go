@@ -0,0 +1,125 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCommitGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitcommitgraph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := c.WriteObject("tree", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(msg string, parents ...CommitID) CommitID {
+		t.Helper()
+		cmt, err := CommitTree(c, CommitTreeOptions{}, TreeID(tree), parents, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmt
+	}
+	// An octopus merge needs the extra edges chunk.
+	//
+	//    B
+	//   / \
+	//  A-C-O-N
+	//   \ /
+	//    D
+	A := commit("A")
+	B := commit("B", A)
+	C := commit("C", A)
+	D := commit("D", A)
+	O := commit("O", B, C, D)
+	N := commit("N", O)
+
+	if g := c.commitGraph(); g != nil {
+		t.Fatal("Unexpected commit-graph before writing one")
+	}
+	if err := CommitGraphWrite(c, CommitGraphWriteOptions{}, []Commitish{N}); err != nil {
+		t.Fatal(err)
+	}
+	if !File(c.GetObjectsDir() + "/info/commit-graph").Exists() {
+		t.Fatal("commit-graph was not written")
+	}
+
+	tests := []struct {
+		cmt     CommitID
+		parents []CommitID
+		gen     uint32
+	}{
+		{A, nil, 1},
+		{B, []CommitID{A}, 2},
+		{C, []CommitID{A}, 2},
+		{O, []CommitID{B, C, D}, 3},
+		{N, []CommitID{O}, 4},
+	}
+	for _, tc := range tests {
+		g, i, ok := c.graphCommit(tc.cmt)
+		if !ok {
+			t.Errorf("%v is not in the commit-graph", tc.cmt)
+			continue
+		}
+		parents, err := g.parents(i)
+		if err != nil {
+			t.Errorf("%v: %v", tc.cmt, err)
+			continue
+		}
+		if len(parents) != len(tc.parents) {
+			t.Errorf("%v: got parents %v want %v", tc.cmt, parents, tc.parents)
+			continue
+		}
+		for j := range parents {
+			if parents[j] != tc.parents[j] {
+				t.Errorf("%v: got parents %v want %v", tc.cmt, parents, tc.parents)
+				break
+			}
+		}
+		if got := g.tree(i); got != TreeID(tree) {
+			t.Errorf("%v: got tree %v want %v", tc.cmt, got, tree)
+		}
+		if got := g.generation(i); got != tc.gen {
+			t.Errorf("%v: got generation %v want %v", tc.cmt, got, tc.gen)
+		}
+	}
+
+	ancestors := []struct {
+		child, parent CommitID
+		want          bool
+	}{
+		{A, N, true},
+		{D, O, true},
+		{N, N, true},
+		{B, C, false},
+		{O, A, false},
+	}
+	for _, tc := range ancestors {
+		if got := tc.child.IsAncestor(c, tc.parent); got != tc.want {
+			t.Errorf("%v.IsAncestor(%v): got %v want %v", tc.child, tc.parent, got, tc.want)
+		}
+	}
+
+	// Commits which aren't in the commit-graph are still found by
+	// reading the objects.
+	E := commit("E", N)
+	if parents, err := E.Parents(c); err != nil || len(parents) != 1 || parents[0] != N {
+		t.Errorf("Unexpected parents for %v: %v %v", E, parents, err)
+	}
+	if !A.IsAncestor(c, E) {
+		t.Errorf("%v should be an ancestor of %v", A, E)
+	}
+
+	if _, err := parseCommitGraph([]byte("CGPH\x02\x01\x00\x00")); err == nil {
+		t.Error("Expected an error for an unsupported version")
+	}
+}
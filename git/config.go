@@ -1,6 +1,7 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,153 +11,344 @@ import (
 	"strings"
 )
 
-// This file provides a stupid way of parsing git config files.
-// It's not very efficient, but for now it gets the job done.
-// (There's a lot more low hanging fruit before optimizing this..)
-type GitConfigValues map[string]string
+// A GitConfig is a git config file. The file is kept as the sections and
+// lines which were parsed from it, so that writing it back out only changes
+// the lines of the variables which were changed, and the comments, blank
+// lines and order of the rest of the file are kept.
+type GitConfig struct {
+	// The sections of the file, in order. The first section has no
+	// name, and holds the lines before the first section header.
+	sections []*GitConfigSection
+	fname    string
+
+	// The client whose cached config needs to be reloaded when the file
+	// is written, if any.
+	client *Client
+}
 
+// A GitConfigSection is a section of a config file and the lines following
+// its header. The same section may appear more than once in a file.
 type GitConfigSection struct {
+	// The name of the section, which is case insensitive, and the
+	// subsection, which isn't.
 	name, subsection string
-	values           GitConfigValues
+
+	// The header line, as it appears in the file. It includes anything
+	// else on the line other than a variable, including the newline.
+	header string
+
+	lines []configLine
 }
-type GitConfig struct {
-	sections []GitConfigSection
-	fname    string
+
+// A line in a config file. A variable which is continued on more than one
+// line with backslashes is a single configLine.
+type configLine struct {
+	// The text of the line in the file, including the newline. It's
+	// empty if the variable was added or changed, in which case it's
+	// written with the formatted value.
+	raw string
+
+	// The name of the variable on the line, as it appears in the file,
+	// and its value. The key is empty for blank lines and comments.
+	key, value string
+
+	// Set if the variable has no "=" or value, which means true.
+	noValue bool
 }
 
-func (g *GitConfig) SetConfig(name, value string) {
-	pieces := strings.Split(name, ".")
-	var key string
-	var sec *GitConfigSection
-	value = strings.TrimSpace(value)
-argChecker:
-	switch len(pieces) {
-	case 2:
-		key = strings.TrimSpace(pieces[1])
-		for _, section := range g.sections {
-			log.Printf("Comparing %s to %s\n", section.name, pieces[0])
-			if section.name == pieces[0] {
-				sec = &section
-				break argChecker
-			}
-		}
-		log.Printf("Couldn't find %s, creating\n", pieces[0])
-		section := GitConfigSection{pieces[0], "", make(map[string]string, 0)}
-		sec = &section
-		g.sections = append(g.sections, section)
-	case 3:
-		key = strings.TrimSpace(pieces[2])
-		for _, section := range g.sections {
-			log.Printf("Comparing %s to %s and %s to %s\n", section.name, pieces[0], section.subsection, pieces[1])
-			if section.name == pieces[0] && section.subsection == pieces[1] {
-				sec = &section
-				break argChecker
-			}
-		}
-		log.Printf("Couldn't find %s %s, creating\n", pieces[0], pieces[1])
-		section := GitConfigSection{pieces[0], pieces[1], make(map[string]string, 0)}
-		sec = &section
-		g.sections = append(g.sections, section)
+// Returns the value of a variable on the line, with values without an "="
+// being "true".
+func (l configLine) String() string {
+	if l.noValue {
+		return "true"
 	}
+	return l.value
+}
 
-	if sec != nil {
-		sec.values[key] = value
-	} else {
-		// TODO Always auto-create the sections
-		log.Printf("Couldn't find section %v\n", name)
+// Returns the name of the section, including the subsection if there is
+// one.
+func (s *GitConfigSection) String() string {
+	if s.subsection == "" {
+		return strings.ToLower(s.name)
 	}
+	return strings.ToLower(s.name) + "." + s.subsection
 }
 
-func (g *GitConfig) Unset(name string) int {
-	pieces := strings.Split(name, ".")
-	var key string
-	var sec *GitConfigSection
-
-argChecker:
-	switch len(pieces) {
-	case 2:
-		key = strings.TrimSpace(pieces[1])
-		for _, section := range g.sections {
-			log.Printf("Comparing %s to %s\n", section.name, pieces[0])
-			if section.name == pieces[0] {
-				sec = &section
-				break argChecker
-			}
+// Returns the last value of the variable key in the section, and whether
+// it was set at all.
+func (s *GitConfigSection) Get(key string) (string, bool) {
+	for i := len(s.lines) - 1; i >= 0; i-- {
+		if l := s.lines[i]; l.key != "" && strings.EqualFold(l.key, key) {
+			return l.String(), true
 		}
-	case 3:
-		key = strings.TrimSpace(pieces[2])
-		for _, section := range g.sections {
-			if section.name == pieces[0] && section.subsection == pieces[1] {
-				sec = &section
-				break argChecker
-			}
+	}
+	return "", false
+}
+
+// Splits a config variable name into its section, subsection and key. The
+// section and key are case insensitive, so they're returned in lower case.
+func parseConfigName(name string) (section, subsection, key string, err error) {
+	first, last := strings.IndexByte(name, '.'), strings.LastIndexByte(name, '.')
+	if first <= 0 {
+		return "", "", "", fmt.Errorf("key does not contain a section: %v", name)
+	}
+	if last == len(name)-1 {
+		return "", "", "", fmt.Errorf("key does not contain variable name: %v", name)
+	}
+	section, key = strings.ToLower(name[:first]), strings.ToLower(name[last+1:])
+	if first != last {
+		subsection = name[first+1 : last]
+	}
+	for _, r := range section {
+		if !isConfigNameChar(r) && r != '.' {
+			return "", "", "", fmt.Errorf("invalid key: %v", name)
 		}
 	}
+	if !isConfigKey(key) || strings.ContainsRune(subsection, '\n') {
+		return "", "", "", fmt.Errorf("invalid key: %v", name)
+	}
+	return section, subsection, key, nil
+}
+
+// Returns the canonical form of a config variable name, with the section
+// and key in lower case, or an error if it isn't a valid name.
+func CanonicalConfigName(name string) (string, error) {
+	section, subsection, key, err := parseConfigName(name)
+	if err != nil {
+		return "", err
+	}
+	if subsection == "" {
+		return section + "." + key, nil
+	}
+	return section + "." + subsection + "." + key, nil
+}
+
+func isConfigNameChar(r rune) bool {
+	return r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
 
-	if sec != nil {
-		if _, ok := sec.values[key]; !ok {
-			return 5
+// Returns true if key is a valid variable name, which starts with a letter
+// and is otherwise alphanumeric characters or "-".
+func isConfigKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	if c := key[0]; !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') {
+		return false
+	}
+	for _, r := range key {
+		if !isConfigNameChar(r) {
+			return false
 		}
-		delete(sec.values, key)
-		return 0
-	} else {
-		return 5
 	}
+	return true
 }
 
-func (g *GitConfig) GetConfig(name string) (string, int) {
+// Returns true if the section matches the canonical section and subsection
+// name.
+func (s *GitConfigSection) matches(section, subsection string) bool {
+	return strings.EqualFold(s.name, section) && s.subsection == subsection
+}
 
-	pieces := strings.Split(name, ".")
-
-	switch len(pieces) {
-	case 2:
-		for _, section := range g.sections {
-			if section.name == pieces[0] {
-				val, ok := section.values[pieces[1]]
-				if !ok {
-					return "", 1
-				}
-				return val, 0
+// The location of a variable in a config file.
+type configLoc struct {
+	section *GitConfigSection
+	line    int
+}
+
+// Returns the locations of the variable name whose values match. If match
+// is nil, all of the values match.
+func (g *GitConfig) find(name string, match func(string) bool) ([]configLoc, error) {
+	section, subsection, key, err := parseConfigName(name)
+	if err != nil {
+		return nil, err
+	}
+	var locs []configLoc
+	for _, s := range g.sections {
+		if !s.matches(section, subsection) {
+			continue
+		}
+		for i, l := range s.lines {
+			if l.key == "" || !strings.EqualFold(l.key, key) {
+				continue
+			}
+			if match == nil || match(l.value) {
+				locs = append(locs, configLoc{s, i})
 			}
 		}
-	case 3:
-		for _, section := range g.sections {
-			if section.name == pieces[0] && section.subsection == pieces[1] {
-				val, ok := section.values[pieces[2]]
-				if !ok {
-					return "", 1
-				}
-				return val, 0
+	}
+	return locs, nil
+}
+
+// Adds a new value for name to the end of the last section for it, creating
+// the section if there isn't one.
+func (g *GitConfig) insert(name, value string) error {
+	section, subsection, key, err := parseConfigName(name)
+	if err != nil {
+		return err
+	}
+	// Keep the case of the key as it was given, the same as git.
+	key = name[strings.LastIndexByte(name, '.')+1:]
+	line := configLine{key: key, value: value}
+
+	for i := len(g.sections) - 1; i >= 0; i-- {
+		s := g.sections[i]
+		if !s.matches(section, subsection) {
+			continue
+		}
+		// Add it after the last variable, so that any comments or
+		// blank lines at the end of the section stay between it and
+		// the next section.
+		pos := 0
+		for j, l := range s.lines {
+			if l.key != "" {
+				pos = j + 1
 			}
 		}
+		s.lines = append(s.lines, configLine{})
+		copy(s.lines[pos+1:], s.lines[pos:])
+		s.lines[pos] = line
+		return nil
+	}
 
+	s := &GitConfigSection{
+		name:       name[:strings.IndexByte(name, '.')],
+		subsection: subsection,
+		lines:      []configLine{line},
 	}
+	if subsection == "" {
+		s.header = fmt.Sprintf("[%v]\n", s.name)
+	} else {
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection)
+		s.header = fmt.Sprintf("[%v \"%v\"]\n", s.name, escaped)
+	}
+	g.sections = append(g.sections, s)
+	return nil
+}
 
-	return "", 1
+// Removes the variables at locs.
+func (g *GitConfig) remove(locs []configLoc) {
+	// Go backwards so that removing a line doesn't change the index of
+	// the lines before it.
+	for i := len(locs) - 1; i >= 0; i-- {
+		s, line := locs[i].section, locs[i].line
+		s.lines = append(s.lines[:line], s.lines[line+1:]...)
+	}
 }
 
-func (g *GitConfig) GetConfigList() []string {
-	list := []string{}
+// Sets name to value, replacing all of its existing values. Invalid names
+// are ignored.
+func (g *GitConfig) SetConfig(name, value string) {
+	if _, err := g.SetConfigMatching(name, value, nil, true); err != nil {
+		log.Printf("Could not set %v: %v\n", name, err)
+	}
+}
+
+// Sets name to value, replacing the existing values of name which match.
+// If match is nil, every value matches. If more than one value matches and
+// all isn't set, nothing is changed and the exit code 5 is returned, like
+// git config. Otherwise, every value which matches is removed and the new
+// value takes the place of the last of them, or is added to the end of the
+// section if none of them match.
+func (g *GitConfig) SetConfigMatching(name, value string, match func(string) bool, all bool) (int, error) {
+	locs, err := g.find(name, match)
+	if err != nil {
+		return 1, err
+	}
+	switch {
+	case len(locs) == 0:
+		return 0, g.insert(name, value)
+	case len(locs) > 1 && !all:
+		return 5, fmt.Errorf("cannot overwrite multiple values with a single value\n       Use a regexp, --add or --replace-all to change %v.", name)
+	}
+	last := locs[len(locs)-1]
+	l := &last.section.lines[last.line]
+	l.raw, l.value, l.noValue = "", value, false
+	g.remove(locs[:len(locs)-1])
+	return 0, nil
+}
+
+// Adds value as a new value for name, without changing any existing values.
+func (g *GitConfig) AddConfig(name, value string) error {
+	return g.insert(name, value)
+}
+
+// Removes the value of name. The exit code 5 is returned if name isn't set,
+// or has more than one value.
+func (g *GitConfig) Unset(name string) int {
+	code, err := g.UnsetMatching(name, nil, false)
+	if err != nil {
+		log.Printf("Could not unset %v: %v\n", name, err)
+	}
+	return code
+}
 
-	for _, section := range g.sections {
-		for key, value := range section.values {
-			if section.subsection != "" {
-				list = append(list, section.name+"."+section.subsection+"."+key+"="+value)
-			} else {
-				list = append(list, section.name+"."+key+"="+value)
+// Removes the values of name which match. If match is nil, every value
+// matches. The exit code 5 is returned if no values match, or if more than
+// one value matches and all isn't set.
+func (g *GitConfig) UnsetMatching(name string, match func(string) bool, all bool) (int, error) {
+	locs, err := g.find(name, match)
+	if err != nil {
+		return 1, err
+	}
+	switch {
+	case len(locs) == 0:
+		return 5, nil
+	case len(locs) > 1 && !all:
+		return 5, fmt.Errorf("%v has multiple values", name)
+	}
+	g.remove(locs)
+	return 0, nil
+}
+
+// Returns the last value of name, with the exit code of git config --get,
+// which is 1 if it isn't set.
+func (g *GitConfig) GetConfig(name string) (string, int) {
+	locs, err := g.find(name, nil)
+	if err != nil || len(locs) == 0 {
+		return "", 1
+	}
+	last := locs[len(locs)-1]
+	return last.section.lines[last.line].String(), 0
+}
+
+// Returns the values of every variable in the file, in order.
+func (g *GitConfig) Values() []ConfigValue {
+	var values []ConfigValue
+	for _, s := range g.sections {
+		for _, l := range s.lines {
+			if l.key == "" {
+				continue
 			}
+			values = append(values, ConfigValue{
+				Name:    s.String() + "." + strings.ToLower(l.key),
+				Value:   l.value,
+				NoValue: l.noValue,
+				File:    g.fname,
+			})
 		}
 	}
+	return values
+}
 
+// Returns every variable in the file in the format of git config --list.
+func (g *GitConfig) GetConfigList() []string {
+	list := []string{}
+	for _, v := range g.Values() {
+		list = append(list, v.String())
+	}
 	return list
 }
 
 // Gets all config sections that match name and subsection. The empty
 // string matches all names/subsections.
-func (g *GitConfig) GetConfigSections(name, subsection string) []GitConfigSection {
-	matches := make([]GitConfigSection, 0, len(g.sections))
+func (g *GitConfig) GetConfigSections(name, subsection string) []*GitConfigSection {
+	matches := make([]*GitConfigSection, 0, len(g.sections))
 	for _, sect := range g.sections {
-		if name != "" && sect.name != name {
+		if sect.header == "" {
+			continue
+		}
+		if name != "" && !strings.EqualFold(sect.name, name) {
 			continue
 		}
 		if subsection != "" && subsection != sect.subsection {
@@ -167,186 +359,424 @@ func (g *GitConfig) GetConfigSections(name, subsection string) []GitConfigSectio
 	return matches
 }
 
-func (g GitConfig) WriteFile(w io.Writer) {
-	for _, section := range g.sections {
-		if section.subsection == "" {
-			fmt.Fprintf(w, "[%s]\n", section.name)
-		} else {
-			fmt.Fprintf(w, "[%s \"%s\"]\n", section.name, section.subsection)
-		}
-
-		for key, value := range section.values {
-			fmt.Fprintf(w, "\t%s = %s\n", key, value)
+// Formats a value to be written to a config file, quoting and escaping it
+// if necessary.
+func formatConfigValue(value string) string {
+	var s strings.Builder
+	quote := strings.HasPrefix(value, " ") || strings.HasSuffix(value, " ") || strings.ContainsAny(value, ";#")
+	if quote {
+		s.WriteByte('"')
+	}
+	for _, c := range []byte(value) {
+		switch c {
+		case '\n':
+			s.WriteString(`\n`)
+		case '\t':
+			s.WriteString(`\t`)
+		case '"', '\\':
+			s.WriteByte('\\')
+			s.WriteByte(c)
+		default:
+			s.WriteByte(c)
 		}
-
 	}
+	if quote {
+		s.WriteByte('"')
+	}
+	return s.String()
 }
-func (s *GitConfigSection) ParseValues(valueslines string) {
-	lines := strings.Split(valueslines, "\n")
-	s.values = make(map[string]string)
 
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" {
-			continue
+// Writes the config file to w.
+func (g GitConfig) WriteFile(w io.Writer) error {
+	var buf bytes.Buffer
+	// Lines which were added after a line without a newline at the end
+	// of the file need to start on a new line.
+	newline := func() {
+		if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	for _, s := range g.sections {
+		if s.header != "" {
+			newline()
+			buf.WriteString(s.header)
 		}
-		split := strings.Split(trimmed, "=")
-		if len(split) < 1 {
-			panic("couldn't parse value")
+		for _, l := range s.lines {
+			switch {
+			case l.raw != "":
+				buf.WriteString(l.raw)
+			case l.noValue:
+				newline()
+				fmt.Fprintf(&buf, "\t%v\n", l.key)
+			default:
+				newline()
+				fmt.Fprintf(&buf, "\t%v = %v\n", l.key, formatConfigValue(l.value))
+			}
 		}
-		varname := strings.TrimSpace(split[0])
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
 
-		log.Printf("Parsed config variable %v\n", varname)
-		s.values[varname] = strings.TrimSpace(strings.Join(split[1:], "="))
+// Parses a config file. The error messages refer to the file by fname.
+func parseConfig(data []byte, fname string) (GitConfig, error) {
+	p := configParser{data: data, line: 1}
+	cfg := GitConfig{fname: fname}
+	section := &GitConfigSection{}
+	cfg.sections = append(cfg.sections, section)
+
+	for p.pos < len(p.data) {
+		start := p.pos
+		p.skipSpace()
+		switch c := p.peek(); {
+		case c == '\n' || c == '#' || c == ';' || c == 0 && p.pos == len(p.data):
+			p.skipLine()
+			section.lines = append(section.lines, configLine{raw: p.text(start)})
+		case c == '[':
+			name, subsection, ok := p.sectionHeader()
+			if !ok {
+				return GitConfig{}, p.error(fname)
+			}
+			// Anything other than a variable after the header is
+			// part of the header line.
+			p.skipSpace()
+			if c := p.peek(); c == '\n' || c == '#' || c == ';' || p.pos == len(p.data) {
+				p.skipLine()
+			}
+			section = &GitConfigSection{
+				name:       name,
+				subsection: subsection,
+				header:     p.text(start),
+			}
+			cfg.sections = append(cfg.sections, section)
+		default:
+			l, ok := p.variable()
+			if !ok {
+				return GitConfig{}, p.error(fname)
+			}
+			if section.header == "" {
+				return GitConfig{}, p.error(fname)
+			}
+			l.raw = p.text(start)
+			section.lines = append(section.lines, l)
+		}
+	}
+	return cfg, nil
+}
 
+// ParseConfig parses a git config file from configFile.
+func ParseConfig(configFile io.Reader) (GitConfig, error) {
+	data, err := ioutil.ReadAll(configFile)
+	if err != nil {
+		return GitConfig{}, err
 	}
+	return parseConfig(data, "")
 }
 
-func (s *GitConfigSection) ParseSectionHeader(headerline string) {
-	s.name = headerline
-	parsingSubsection := false
-	subsectionStart := 0
-	for idx, b := range headerline {
+// A configParser parses the text of a config file, with the same rules as
+// git.
+type configParser struct {
+	data []byte
+	pos  int
 
-		if b == '"' && parsingSubsection == true {
+	// The line number of the current position.
+	line int
+}
 
-			parsingSubsection = true
-			s.subsection = strings.TrimSpace(headerline[subsectionStart:idx])
+// Returns the text of the file from start to the current position.
+func (p *configParser) text(start int) string {
+	return string(p.data[start:p.pos])
+}
 
-		}
-		if b == '"' && parsingSubsection == false {
-			parsingSubsection = true
-			subsectionStart = idx + 1
+func (p *configParser) error(fname string) error {
+	if fname == "" {
+		return fmt.Errorf("bad config line %d", p.line)
+	}
+	return fmt.Errorf("bad config line %d in file %v", p.line, fname)
+}
 
-			s.name = strings.TrimSpace(headerline[0:idx])
+// Returns the next character, without consuming it. "\r\n" is returned as
+// "\n", and the end of the file is 0.
+func (p *configParser) peek() byte {
+	if p.pos >= len(p.data) {
+		return 0
+	}
+	if p.data[p.pos] == '\r' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '\n' {
+		return '\n'
+	}
+	return p.data[p.pos]
+}
 
+// Returns and consumes the next character, which is "\n" at the end of the
+// file.
+func (p *configParser) next() byte {
+	c := p.peek()
+	switch {
+	case p.pos >= len(p.data):
+		return '\n'
+	case c == '\n':
+		if p.data[p.pos] == '\r' {
+			p.pos++
 		}
+		p.line++
 	}
-	if subsectionStart == 0 {
-		s.name = headerline
+	p.pos++
+	return c
+}
+
+// Skips spaces and tabs.
+func (p *configParser) skipSpace() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.pos++
 	}
 }
 
-func ParseConfig(configFile io.Reader) GitConfig {
-	rawdata, _ := ioutil.ReadAll(configFile)
-	section := &GitConfigSection{}
-	parsingSectionName := false
-	parsingValues := false
-	var sect *GitConfigSection = nil
-	var sections []GitConfigSection
-	lastBracket := 0
-	lastClosingBracket := 0
-
-	for idx, b := range rawdata {
-		if b == '[' && parsingSectionName == false {
-
-			parsingSectionName = true
-			lastBracket = idx
-			if parsingValues == true {
-				section.ParseValues(string(rawdata[lastClosingBracket+1 : idx]))
-				parsingValues = false
-
-				if sect != nil {
-					for k, v := range section.values {
-						sect.values[k] = v
-					}
-					sect = nil
-				} else {
-					sections = append(sections, *section)
-				}
+// Skips to the start of the next line.
+func (p *configParser) skipLine() {
+	for p.pos < len(p.data) {
+		if p.next() == '\n' {
+			return
+		}
+	}
+}
+
+// Parses a section header, starting at the "[". The deprecated form of
+// subsections, "[section.subsection]", has a case insensitive subsection,
+// which is returned in lower case.
+func (p *configParser) sectionHeader() (name, subsection string, ok bool) {
+	p.pos++
+	start := p.pos
+	for {
+		c := p.peek()
+		if c == ']' {
+			name = p.text(start)
+			p.pos++
+			if name == "" {
+				return "", "", false
+			}
+			if dot := strings.IndexByte(name, '.'); dot >= 0 {
+				name, subsection = name[:dot], strings.ToLower(name[dot+1:])
 			}
-			section = &GitConfigSection{}
+			return name, subsection, true
 		}
-		if b == ']' && parsingSectionName == true {
-			section.ParseSectionHeader(string(rawdata[lastBracket+1 : idx]))
-
-			for _, s := range sections {
-				if s.name == section.name && s.subsection == section.subsection {
-					sect = &s
-					break
-				}
+		if c == ' ' || c == '\t' {
+			break
+		}
+		if !isConfigNameChar(rune(c)) && c != '.' {
+			return "", "", false
+		}
+		p.pos++
+	}
+	name = p.text(start)
+	p.skipSpace()
+	if p.next() != '"' {
+		return "", "", false
+	}
+	var sub strings.Builder
+	for {
+		c := p.next()
+		switch c {
+		case '\n':
+			return "", "", false
+		case '"':
+			if p.next() != ']' {
+				return "", "", false
+			}
+			return name, sub.String(), name != ""
+		case '\\':
+			c = p.next()
+			if c == '\n' {
+				return "", "", false
 			}
+		}
+		sub.WriteByte(c)
+	}
+}
+
+// Parses a variable and its value, and the rest of the line after it.
+func (p *configParser) variable() (l configLine, ok bool) {
+	start := p.pos
+	for isConfigNameChar(rune(p.peek())) {
+		p.pos++
+	}
+	l.key = p.text(start)
+	if !isConfigKey(l.key) {
+		return l, false
+	}
+	p.skipSpace()
+	switch p.next() {
+	case '\n':
+		l.noValue = true
+		return l, true
+	case '=':
+	default:
+		return l, false
+	}
 
-			parsingValues = true
-			parsingSectionName = false
-			lastClosingBracket = idx
+	// Whitespace inside a value is kept, but whitespace at the start or
+	// end of it, or before a comment, isn't, unless it's quoted.
+	var value strings.Builder
+	quoted, comment := false, false
+	spaces := 0
+	for {
+		c := p.next()
+		if c == '\n' {
+			if quoted {
+				return l, false
+			}
+			l.value = value.String()
+			return l, true
+		}
+		if comment {
+			continue
+		}
+		if !quoted && (c == ';' || c == '#') {
+			comment = true
+			continue
 		}
-		if idx == len(rawdata)-1 && parsingValues == true {
-			section.ParseValues(string(rawdata[lastClosingBracket+1 : idx]))
-
-			if sect != nil {
-				for k, v := range section.values {
-					sect.values[k] = v
-				}
-				sect = nil
-			} else {
-				sections = append(sections, *section)
+		if !quoted && (c == ' ' || c == '\t' || c == '\v' || c == '\f' || c == '\r') {
+			if value.Len() > 0 {
+				spaces++
 			}
+			continue
+		}
+		for ; spaces > 0; spaces-- {
+			value.WriteByte(' ')
+		}
+		switch c {
+		case '\\':
+			switch c = p.next(); c {
+			case '\n':
+				continue
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'n':
+				c = '\n'
+			case '\\', '"':
+			default:
+				return l, false
+			}
+			value.WriteByte(c)
+		case '"':
+			quoted = !quoted
+		default:
+			value.WriteByte(c)
 		}
 	}
-	return GitConfig{sections: sections}
 }
 
-func findGlobalConfigFile() (string, error) {
+// Returns the home directory from the environment.
+func homeDir() (string, error) {
 	home := os.Getenv("HOME")
 	if home == "" {
 		home = os.Getenv("home") // On some OSes, it is home
 	}
-
 	if home == "" {
 		return "", fmt.Errorf("Global git configuration could not be found since HOME and home environment variables were not defined.")
 	}
-
-	return home + "/.gitconfig", nil
+	return home, nil
 }
 
-func LoadGlobalConfig() (GitConfig, error) {
-	fname, err := findGlobalConfigFile()
+// Returns the global config files, in the order that they're read. The
+// XDG config file is read before ~/.gitconfig.
+func GlobalConfigFiles() ([]string, error) {
+	if fname := os.Getenv("GIT_CONFIG_GLOBAL"); fname != "" {
+		return []string{fname}, nil
+	}
+	home, err := homeDir()
 	if err != nil {
-		return GitConfig{}, err
+		return nil, err
 	}
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		xdg = filepath.Join(home, ".config")
+	}
+	return []string{filepath.Join(xdg, "git", "config"), filepath.Join(home, ".gitconfig")}, nil
+}
 
-	file, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0644)
+// Returns the global config file which git config --global uses, which is
+// ~/.gitconfig unless only the XDG config file exists.
+func GlobalConfigFile() (string, error) {
+	files, err := GlobalConfigFiles()
 	if err != nil {
+		return "", err
+	}
+	fname := files[len(files)-1]
+	if len(files) > 1 && !File(fname).Exists() && File(files[0]).Exists() {
+		return files[0], nil
+	}
+	return fname, nil
+}
+
+// Returns the system config file, which is /etc/gitconfig unless it's
+// overridden by GIT_CONFIG_SYSTEM.
+func SystemConfigFile() string {
+	if fname := os.Getenv("GIT_CONFIG_SYSTEM"); fname != "" {
+		return fname
+	}
+	return "/etc/gitconfig"
+}
+
+// Loads the config file fname. A file which doesn't exist is empty, and
+// will be created if it's written.
+func LoadConfigFile(fname string) (GitConfig, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil && !os.IsNotExist(err) {
 		return GitConfig{}, err
 	}
-	config := ParseConfig(file)
-	err = file.Close()
+	config, err := parseConfig(data, fname)
 	if err != nil {
 		return GitConfig{}, err
 	}
-
-	config.fname = fname
-
 	return config, nil
 }
 
-func LoadLocalConfig(c *Client) (GitConfig, error) {
-	fname := filepath.Join(c.GitDir.String(), "config")
+func LoadSystemConfig() (GitConfig, error) {
+	return LoadConfigFile(SystemConfigFile())
+}
 
-	file, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0644)
+func LoadGlobalConfig() (GitConfig, error) {
+	fname, err := GlobalConfigFile()
 	if err != nil {
 		return GitConfig{}, err
 	}
-	config := ParseConfig(file)
-	if err := file.Close(); err != nil {
+	return LoadConfigFile(fname)
+}
+
+func LoadLocalConfig(c *Client) (GitConfig, error) {
+	config, err := LoadConfigFile(filepath.Join(c.GitDir.String(), "config"))
+	if err != nil {
 		return GitConfig{}, err
 	}
-
-	config.fname = fname
-
+	config.client = c
 	return config, nil
 }
 
+// Writes the config file. The file is written to a lock file which replaces
+// it, so that it's never partially written.
 func (g GitConfig) WriteConfig() error {
-	err := os.Remove(g.fname)
+	lockname := g.fname + ".lock"
+	lock, err := os.OpenFile(lockname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
+		return fmt.Errorf("could not lock config file %v: %v", g.fname, err)
+	}
+	if err := g.WriteFile(lock); err != nil {
+		lock.Close()
+		os.Remove(lockname)
 		return err
 	}
-	configFile, err := os.Create(g.fname)
-	if err != nil {
+	if err := lock.Close(); err != nil {
+		os.Remove(lockname)
 		return err
 	}
-	g.WriteFile(configFile)
-	return configFile.Close()
+	if err := os.Rename(lockname, g.fname); err != nil {
+		os.Remove(lockname)
+		return err
+	}
+	if g.client != nil {
+		g.client.config = nil
+	}
+	return nil
 }
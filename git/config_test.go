@@ -0,0 +1,336 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		config string
+		want   []string
+	}{
+		{"", nil},
+		{"[core]\n\tbare = false\n", []string{"core.bare=false"}},
+		{
+			"# comment\n[Core]\n\tBare\n\tx = 1 ; comment\n\ty =\n",
+			[]string{"core.bare", "core.x=1", "core.y="},
+		},
+		{
+			"[remote \"Origin\"]\n\tfetch = a\n\tfetch = b\n[remote \"Origin\"]\n\tfetch = c",
+			[]string{"remote.Origin.fetch=a", "remote.Origin.fetch=b", "remote.Origin.fetch=c"},
+		},
+		{
+			// The deprecated form of subsections is case insensitive.
+			"[Section.SubSection]\nkey=value\n",
+			[]string{"section.subsection.key=value"},
+		},
+		{
+			`[a "sub \"quoted\" \\"] x = "  spaced # not a comment  "` + "\n",
+			[]string{`a.sub "quoted" \.x=  spaced # not a comment  `},
+		},
+		{
+			"[a]\n\tx = one  two\\\n  three\r\n\ty = \"tab\\there\" \\n\n",
+			[]string{"a.x=one  two  three", "a.y=tab\there \n"},
+		},
+	}
+	for i, tc := range tests {
+		cfg, err := ParseConfig(strings.NewReader(tc.config))
+		if err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+			continue
+		}
+		var got []string
+		for _, v := range cfg.Values() {
+			got = append(got, v.String())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.want)
+		}
+
+		// Writing the file back out shouldn't change it.
+		var s strings.Builder
+		if err := cfg.WriteFile(&s); err != nil {
+			t.Fatal(err)
+		}
+		if s.String() != tc.config {
+			t.Errorf("Case %d: config changed when written: got %q want %q", i, s.String(), tc.config)
+		}
+	}
+
+	for _, bad := range []string{
+		"x = 1\n",
+		"[core\n",
+		"[core]\n\t1x = 1\n",
+		"[core]\n\tx = \"unterminated\n",
+		"[core]\n\tx = bad \\escape\n",
+		"[core]\n\tx # comment\n",
+	} {
+		if _, err := ParseConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestEditConfig(t *testing.T) {
+	const original = `# leading comment
+[remote "o"]
+	url = u ; keep this
+	fetch = a
+
+# trailing comment
+[b]
+	x = 1
+[remote "o"]
+	other = 1
+`
+	edit := func(f func(cfg *GitConfig)) string {
+		t.Helper()
+		cfg, err := ParseConfig(strings.NewReader(original))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(&cfg)
+		var s strings.Builder
+		if err := cfg.WriteFile(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s.String()
+	}
+	matches := func(value string) func(string) bool {
+		return func(v string) bool { return v == value }
+	}
+
+	tests := []struct {
+		name string
+		edit func(cfg *GitConfig)
+		want string
+	}{
+		{
+			"add",
+			func(cfg *GitConfig) {
+				if err := cfg.AddConfig("remote.o.fetch", "b"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			strings.Replace(original, "\tother = 1\n", "\tother = 1\n\tfetch = b\n", 1),
+		},
+		{
+			"set existing",
+			func(cfg *GitConfig) {
+				if code, err := cfg.SetConfigMatching("remote.o.fetch", "c", nil, false); code != 0 || err != nil {
+					t.Fatalf("got %v, %v", code, err)
+				}
+			},
+			strings.Replace(original, "fetch = a", "fetch = c", 1),
+		},
+		{
+			"new section",
+			func(cfg *GitConfig) {
+				cfg.SetConfig(`New.sub"sect.Key`, "# quoted")
+			},
+			original + "[New \"sub\\\"sect\"]\n\tKey = \"# quoted\"\n",
+		},
+		{
+			"replace all",
+			func(cfg *GitConfig) {
+				cfg.AddConfig("remote.o.fetch", "b")
+				cfg.AddConfig("b.x", "2")
+				if code, err := cfg.SetConfigMatching("remote.o.fetch", "c", nil, true); code != 0 || err != nil {
+					t.Fatalf("got %v, %v", code, err)
+				}
+			},
+			strings.Replace(
+				strings.Replace(original, "\tfetch = a\n", "", 1),
+				"\tx = 1\n", "\tx = 1\n\tx = 2\n", 1,
+			) + "\tfetch = c\n",
+		},
+		{
+			"unset",
+			func(cfg *GitConfig) {
+				if code := cfg.Unset("remote.o.url"); code != 0 {
+					t.Fatalf("got %v", code)
+				}
+				if code := cfg.Unset("remote.o.url"); code != 5 {
+					t.Fatalf("unsetting a missing value: got %v want 5", code)
+				}
+			},
+			strings.Replace(original, "\turl = u ; keep this\n", "", 1),
+		},
+		{
+			"multiple values",
+			func(cfg *GitConfig) {
+				cfg.AddConfig("remote.o.fetch", "b")
+				if code, err := cfg.SetConfigMatching("remote.o.fetch", "c", nil, false); code != 5 || err == nil {
+					t.Errorf("setting multiple values: got %v, %v", code, err)
+				}
+				if code, _ := cfg.UnsetMatching("remote.o.fetch", nil, false); code != 5 {
+					t.Errorf("unsetting multiple values: got %v", code)
+				}
+				if code, err := cfg.UnsetMatching("remote.o.fetch", matches("a"), false); code != 0 || err != nil {
+					t.Errorf("unsetting a matching value: got %v, %v", code, err)
+				}
+			},
+			strings.Replace(
+				strings.Replace(original, "\tfetch = a\n", "", 1),
+				"\tother = 1\n", "\tother = 1\n\tfetch = b\n", 1,
+			),
+		},
+	}
+	for _, tc := range tests {
+		if got := edit(tc.edit); got != tc.want {
+			t.Errorf("%v: got\n%v\nwant\n%v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestConfigIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, env := range []string{"HOME", "XDG_CONFIG_HOME", "GIT_CONFIG_GLOBAL", "GIT_CONFIG_NOSYSTEM"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	home := filepath.Join(dir, "home")
+	os.Setenv("HOME", home)
+	os.Setenv("XDG_CONFIG_HOME", "")
+	os.Setenv("GIT_CONFIG_GLOBAL", "")
+	os.Setenv("GIT_CONFIG_NOSYSTEM", "true")
+
+	c, err := Init(nil, InitOptions{Quiet: true}, filepath.Join(dir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"home/.config/git/config": "[x]\n\tv = xdg\n",
+		"home/.gitconfig": `[x]
+	v = global
+[include]
+	path = inc/a
+[includeIf "gitdir:repo/"]
+	path = inc/gitdir
+[includeIf "gitdir:/nowhere/"]
+	path = inc/nowhere
+[includeIf "onbranch:mas*"]
+	path = inc/branch
+[includeIf "onbranch:feature/"]
+	path = inc/nowhere
+`,
+		"home/inc/a":       "[x]\n\tv = included\n[include]\n\tpath = ../loop\n",
+		"home/loop":        "[include]\n\tpath = loop2\n",
+		"home/loop2":       "[x]\n\tv = loop\n",
+		"home/inc/gitdir":  "[x]\n\tv = gitdir\n",
+		"home/inc/nowhere": "[x]\n\tv = nowhere\n",
+		"home/inc/branch":  "[x]\n\tv = branch\n",
+		"repo/.git/config": "[x]\n\tv = local\n\tbool\n",
+	}
+	for name, content := range files {
+		fname := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.SetCachedConfig("X.v", "command")
+
+	want := []string{"xdg", "global", "included", "loop", "gitdir", "branch", "local", "command"}
+	if got := c.GetConfigAll("x.V"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected values: got %q want %q", got, want)
+	}
+	if got := c.GetConfig("x.v"); got != "command" {
+		t.Errorf("Unexpected value: got %q want %q", got, "command")
+	}
+	if got := c.GetConfig("x.bool"); got != "true" {
+		t.Errorf("Unexpected value for variable without a value: got %q want %q", got, "true")
+	}
+
+	values, err := c.ConfigValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopes := make(map[string]ConfigScope)
+	for _, v := range values {
+		if v.Name == "x.v" {
+			scopes[v.Value] = v.Scope
+		}
+	}
+	for value, scope := range map[string]ConfigScope{
+		"xdg":      GlobalScope,
+		"included": GlobalScope,
+		"local":    LocalScope,
+		"command":  CommandScope,
+	} {
+		if scopes[value] != scope {
+			t.Errorf("Unexpected scope for %v: got %v want %v", value, scopes[value], scope)
+		}
+	}
+
+	values, err = c.ConfigValuesWithoutIncludes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range values {
+		if v.Name == "x.v" {
+			got = append(got, v.Value)
+		}
+	}
+	if want := []string{"xdg", "global", "local", "command"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected values without includes: got %q want %q", got, want)
+	}
+
+	// Writing the local config should reload it.
+	cfg, err := LoadLocalConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AddConfig("x.v", "added")
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetConfigAll("x.v"); len(got) < 2 || got[len(got)-2] != "added" {
+		t.Errorf("Config not reloaded after being written: got %q", got)
+	}
+}
+
+func TestCanonicalConfigValue(t *testing.T) {
+	tests := []struct {
+		typ, value string
+		noValue    bool
+		want       string
+		wantErr    bool
+	}{
+		{"bool", "", true, "true", false},
+		{"bool", "Yes", false, "true", false},
+		{"bool", "off", false, "false", false},
+		{"bool", "", false, "false", false},
+		{"bool", "2", false, "true", false},
+		{"bool", "maybe", false, "", true},
+		{"int", "1k", false, "1024", false},
+		{"int", "3M", false, "3145728", false},
+		{"int", "-2g", false, "-2147483648", false},
+		{"int", "1x", false, "", true},
+		{"bool-or-int", "12", false, "12", false},
+		{"bool-or-int", "on", false, "true", false},
+		{"path", "/abs", false, "/abs", false},
+	}
+	for _, tc := range tests {
+		got, err := CanonicalConfigValue(tc.typ, tc.value, tc.noValue)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("%v %q: got %q, %v want %q", tc.typ, tc.value, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package git
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A ConfigScope is where a config variable was set.
+type ConfigScope int
+
+const (
+	SystemScope ConfigScope = iota + 1
+	GlobalScope
+	LocalScope
+	WorktreeScope
+	CommandScope
+)
+
+func (s ConfigScope) String() string {
+	switch s {
+	case SystemScope:
+		return "system"
+	case GlobalScope:
+		return "global"
+	case LocalScope:
+		return "local"
+	case WorktreeScope:
+		return "worktree"
+	case CommandScope:
+		return "command"
+	default:
+		return "unknown"
+	}
+}
+
+// A ConfigValue is a value of a config variable, and where it was set.
+type ConfigValue struct {
+	// The canonical name of the variable, with the section and key in
+	// lower case.
+	Name  string
+	Value string
+
+	// Set if the variable has no value at all, which means true, as
+	// opposed to an empty value.
+	NoValue bool
+
+	Scope ConfigScope
+
+	// The file the variable was set in. It's empty for variables set on
+	// the command line.
+	File string
+}
+
+// Returns the variable in the format of git config --list.
+func (v ConfigValue) String() string {
+	if v.NoValue {
+		return v.Name
+	}
+	return v.Name + "=" + v.Value
+}
+
+// The maximum depth of nested includes, the same as git's, to stop circular
+// includes from recursing forever.
+const maxConfigIncludeDepth = 10
+
+// Returns the value of every config variable in all of the config files
+// for c, followed by those set on the command line, in the order that git
+// reads them. Later values of a variable override earlier ones.
+func (c *Client) ConfigValues() ([]ConfigValue, error) {
+	values, err := c.loadConfig()
+	return append(append([]ConfigValue(nil), values...), c.configCache...), err
+}
+
+// ConfigValuesWithoutIncludes is like ConfigValues, but the files included
+// by the config files aren't read.
+func (c *Client) ConfigValuesWithoutIncludes() ([]ConfigValue, error) {
+	values, err := c.readConfigFiles(false)
+	return append(values, c.configCache...), err
+}
+
+// Loads the config files, if they haven't already been loaded.
+func (c *Client) loadConfig() ([]ConfigValue, error) {
+	if c.config != nil {
+		return c.config, c.configErr
+	}
+	c.config, c.configErr = c.readConfigFiles(true)
+	return c.config, c.configErr
+}
+
+// Reads the config files, which are the system config, the global config
+// files, the repository's config and, if extensions.worktreeConfig is set,
+// the worktree's config. If includes is set, the files that they include
+// are read too.
+func (c *Client) readConfigFiles(includes bool) ([]ConfigValue, error) {
+	config := []ConfigValue{}
+	read := func(fname string, scope ConfigScope) error {
+		values, err := c.ReadConfigFile(fname, scope, includes)
+		config = append(config, values...)
+		return err
+	}
+	if noSystem, err := parseConfigBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); err != nil || !noSystem {
+		if err := read(SystemConfigFile(), SystemScope); err != nil {
+			return config, err
+		}
+	}
+	if files, err := GlobalConfigFiles(); err == nil {
+		for _, fname := range files {
+			if err := read(fname, GlobalScope); err != nil {
+				return config, err
+			}
+		}
+	}
+	if c.GitDir == "" {
+		return config, nil
+	}
+	if err := read(c.GitDir.File("config").String(), LocalScope); err != nil {
+		return config, err
+	}
+	if worktree, err := parseConfigBool(lastConfigValue(config, "extensions.worktreeconfig")); err == nil && worktree {
+		return config, read(c.GitDir.File("config.worktree").String(), WorktreeScope)
+	}
+	return config, nil
+}
+
+// Returns the last value of the canonical name in values, or the empty
+// string.
+func lastConfigValue(values []ConfigValue, name string) string {
+	for i := len(values) - 1; i >= 0; i-- {
+		if values[i].Name == name {
+			if values[i].NoValue {
+				return "true"
+			}
+			return values[i].Value
+		}
+	}
+	return ""
+}
+
+// Reads the values of the variables in the config file fname, which are
+// from scope. If includes is set, the values from any files included by
+// it are read in place of the include.path or includeIf.*.path variable
+// which includes them. A file which doesn't exist has no variables.
+func (c *Client) ReadConfigFile(fname string, scope ConfigScope, includes bool) ([]ConfigValue, error) {
+	return c.readConfigFile(fname, scope, includes, 0)
+}
+
+func (c *Client) readConfigFile(fname string, scope ConfigScope, includes bool, depth int) ([]ConfigValue, error) {
+	cfg, err := LoadConfigFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var values []ConfigValue
+	for _, v := range cfg.Values() {
+		v.Scope = scope
+		values = append(values, v)
+		if !includes {
+			continue
+		}
+		path, err := c.configIncludePath(v)
+		if err != nil {
+			return values, err
+		}
+		if path == "" {
+			continue
+		}
+		if depth >= maxConfigIncludeDepth {
+			return values, fmt.Errorf("exceeded maximum include depth (%d) while including %v from %v. This might be due to circular includes.", maxConfigIncludeDepth, path, fname)
+		}
+		included, err := c.readConfigFile(path, scope, true, depth+1)
+		values = append(values, included...)
+		if err != nil {
+			return values, err
+		}
+	}
+	return values, nil
+}
+
+// Returns the path of the file included by the config variable v, if it's
+// an include.path variable or an includeIf.<condition>.path variable whose
+// condition is true. Otherwise, it returns the empty string. Relative paths
+// are relative to the directory of the file with the variable.
+func (c *Client) configIncludePath(v ConfigValue) (string, error) {
+	switch {
+	case v.Name == "include.path":
+	case strings.HasPrefix(v.Name, "includeif.") && strings.HasSuffix(v.Name, ".path") && len(v.Name) > len("includeif..path"):
+		condition := v.Name[len("includeif.") : len(v.Name)-len(".path")]
+		if !c.includeConditionMatches(condition, v.File) {
+			return "", nil
+		}
+	default:
+		return "", nil
+	}
+	if v.NoValue || v.Value == "" {
+		return "", nil
+	}
+	path, err := ExpandConfigPath(v.Value)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(v.File), path)
+	}
+	return path, nil
+}
+
+// Returns true if the condition of an includeIf section is true for c. The
+// conditions are "gitdir:" (or "gitdir/i:" to ignore case), which matches
+// the path of the git directory, and "onbranch:", which matches the name of
+// the current branch. Unknown conditions are never true.
+func (c *Client) includeConditionMatches(condition, fname string) bool {
+	if c == nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(condition, "gitdir:"):
+		return c.gitDirMatches(condition[len("gitdir:"):], fname, false)
+	case strings.HasPrefix(condition, "gitdir/i:"):
+		return c.gitDirMatches(condition[len("gitdir/i:"):], fname, true)
+	case strings.HasPrefix(condition, "onbranch:"):
+		branch := c.GetHeadBranch()
+		if branch == "" {
+			return false
+		}
+		pattern := condition[len("onbranch:"):]
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		return configGlobMatch(pattern, branch.BranchName(), false)
+	}
+	return false
+}
+
+// Returns true if the git directory matches the pattern of a gitdir:
+// condition in the config file fname.
+func (c *Client) gitDirMatches(pattern, fname string, fold bool) bool {
+	if c.GitDir == "" {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(pattern, "~/"):
+		expanded, err := ExpandConfigPath(pattern)
+		if err != nil {
+			return false
+		}
+		pattern = expanded
+	case strings.HasPrefix(pattern, "./"):
+		pattern = filepath.Dir(fname) + pattern[1:]
+	case !filepath.IsAbs(pattern):
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	dir, err := filepath.Abs(c.GitDir.String())
+	if err != nil {
+		return false
+	}
+	if configGlobMatch(pattern, dir, fold) {
+		return true
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	return err == nil && real != dir && configGlobMatch(pattern, real, fold)
+}
+
+// Returns true if name matches the wildcard pattern, where "*" and "?"
+// don't match "/", but "**/" matches any number of directories and a
+// trailing "/**" matches everything inside a directory.
+func configGlobMatch(pattern, name string, fold bool) bool {
+	var re strings.Builder
+	if fold {
+		re.WriteString("(?i)")
+	}
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**") && (i == 0 || pattern[i-1] == '/') {
+				if i+2 == len(pattern) {
+					re.WriteString(".*")
+					i++
+					continue
+				}
+				if pattern[i+2] == '/' {
+					re.WriteString("(.*/)?")
+					i += 2
+					continue
+				}
+			}
+			re.WriteString("[^/]*")
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	re.WriteString("$")
+	matched, err := regexp.MatchString(re.String(), name)
+	return err == nil && matched
+}
+
+// Parses a boolean config value. "true", "yes", "on" and non-zero numbers
+// are true, and "false", "no", "off", zero and the empty string are false.
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	if n, err := parseConfigInt(value); err == nil {
+		return n != 0, nil
+	}
+	return false, fmt.Errorf("bad boolean config value '%v'", value)
+}
+
+// Parses an integer config value, which may have a "k", "m" or "g" suffix
+// to multiply it by 1024, 1024^2 or 1024^3.
+func parseConfigInt(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	multiplier := int64(1)
+	if value != "" {
+		switch strings.ToLower(value[len(value)-1:]) {
+		case "k":
+			multiplier = 1 << 10
+		case "m":
+			multiplier = 1 << 20
+		case "g":
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value '%v': invalid unit", value)
+	}
+	return n * multiplier, nil
+}
+
+// Expands a leading "~/" or "~user/" in a config path to the home directory.
+func ExpandConfigPath(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+	name, rest := path[1:], ""
+	if slash := strings.IndexByte(name, '/'); slash >= 0 {
+		name, rest = name[:slash], name[slash:]
+	}
+	if name == "" {
+		home, err := homeDir()
+		if err != nil {
+			return "", err
+		}
+		return home + rest, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand user dir in: '%v'", path)
+	}
+	return u.HomeDir + rest, nil
+}
+
+// Converts a config value to the canonical form of the type typ, which is
+// one of "bool", "int", "bool-or-int" or "path", the same as git config's
+// --type option. A variable with no value is true.
+func CanonicalConfigValue(typ string, value string, noValue bool) (string, error) {
+	switch typ {
+	case "":
+		return value, nil
+	case "bool":
+		if noValue {
+			return "true", nil
+		}
+		b, err := parseConfigBool(value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(b), nil
+	case "int":
+		n, err := parseConfigInt(value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "bool-or-int":
+		if noValue {
+			return "true", nil
+		}
+		if n, err := parseConfigInt(value); err == nil {
+			return strconv.FormatInt(n, 10), nil
+		}
+		return CanonicalConfigValue("bool", value, false)
+	case "path":
+		if noValue {
+			return "", fmt.Errorf("missing value for path")
+		}
+		return ExpandConfigPath(value)
+	}
+	return "", fmt.Errorf("unrecognized --type argument, %v", typ)
+}
+
+// Sets a cached config for this session only. None of these configs
+// will be persisted into the local or global configuration. Once the
+// client is closed or is garbage collected the configuration is lost.
+func (c *Client) SetCachedConfig(varname string, value string) {
+	name, err := CanonicalConfigName(varname)
+	if err != nil {
+		log.Printf("Invalid config variable %v: %v\n", varname, err)
+		return
+	}
+	c.configCache = append(c.configCache, ConfigValue{Name: name, Value: value, Scope: CommandScope})
+}
+
+// Gets a cached config variable if it is there. Otherwise, it returns
+// and empty string.
+func (c *Client) GetCachedConfig(varname string) string {
+	name, err := CanonicalConfigName(varname)
+	if err != nil {
+		return ""
+	}
+	return lastConfigValue(c.configCache, name)
+}
+
+// Loads a config variable for the git repo hosted by c. If the variable is
+// set more than once, the last value is used, so that local config
+// overrides global config. A variable with no value is "true".
+// Non-existent variables will return the empty string.
+func (c *Client) GetConfig(varname string) string {
+	values := c.GetConfigAll(varname)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// Returns every value of a config variable which can have more than one
+// value, such as remote.<name>.fetch, in the order they were set.
+func (c *Client) GetConfigAll(varname string) []string {
+	name, err := CanonicalConfigName(varname)
+	if err != nil {
+		return nil
+	}
+	values, err := c.loadConfig()
+	if err != nil {
+		log.Printf("Could not load config: %v\n", err)
+	}
+	var all []string
+	for _, v := range append(values[:len(values):len(values)], c.configCache...) {
+		if v.Name != name {
+			continue
+		}
+		if v.NoValue {
+			all = append(all, "true")
+		} else {
+			all = append(all, v.Value)
+		}
+	}
+	return all
+}
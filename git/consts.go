@@ -3,9 +3,6 @@
 package git
 
 const (
-	// the command to execute for a posix compliant diff implementation.
-	posixDiff  = "diff"
-	posixDiff3 = "diff3"
-
+	// the command to execute for a posix compliant patch implementation.
 	posixPatch = "patch"
 )
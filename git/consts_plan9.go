@@ -1,9 +1,6 @@
 package git
 
 const (
-	// the command to execute for a posix compliant diff implementation.
-	posixDiff  = "/bin/ape/diff"
-	posixDiff3 = "/bin/ape/diff3"
-
+	// the command to execute for a posix compliant patch implementation.
 	posixPatch = "/bin/ape/patch"
 )
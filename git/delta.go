@@ -54,21 +54,28 @@ func calculateDelta(ref resolvedDelta, delta []byte) (PackEntryType, []byte, err
 
 	d := deltaeval{}
 
-	for {
+	for uint64(len(d.value)) < targetLength {
 		if err := d.DoInstruction(deltaStream, ref.Value, targetLength); err != nil {
 			return 0, nil, err
 		}
-		if targetLength == uint64(len(d.value)) {
-			break
-		}
-		if len(d.value) > int(targetLength) {
-			panic("Read too much data from delta stream")
-		}
+	}
+	if uint64(len(d.value)) != targetLength {
+		return 0, nil, fmt.Errorf("Read too much data from delta stream")
 	}
 	return ref.Type, d.value, nil
 
 }
 
+// Applies a delta in the packfile format to base, checking that it was
+// created from a base of the same size.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	if size := ReadVariable(bytes.NewReader(delta)); size != uint64(len(base)) {
+		return nil, fmt.Errorf("Delta is for a base of %d bytes, not %d", size, len(base))
+	}
+	_, value, err := calculateDelta(resolvedDelta{Value: base}, delta)
+	return value, err
+}
+
 // Calculate an offset delta. refs must be a map of all previous references in
 // the packfile.
 func calculateOfsDelta(ref ObjectOffset, delta []byte, refs map[ObjectOffset]resolvedDelta) (PackEntryType, []byte, error) {
@@ -90,9 +97,11 @@ type resolvedDelta struct {
 func (d *deltaeval) DoInstruction(delta io.Reader, src []byte, targetSize uint64) error {
 	b := make([]byte, 1)
 
-	delta.Read(b)
+	if _, err := io.ReadFull(delta, b); err != nil {
+		return fmt.Errorf("Unexpected end of delta stream")
+	}
 	if b[0] == 0 {
-		panic("Unexpected delta opcode: 0")
+		return fmt.Errorf("Unexpected delta opcode: 0")
 	}
 	if b[0] >= 128 {
 		var offset, length uint64
@@ -133,7 +142,7 @@ func (d *deltaeval) DoInstruction(delta io.Reader, src []byte, targetSize uint64
 			length = 0x10000
 		}
 		if length > targetSize {
-			panic("Trying to read too much data.")
+			return fmt.Errorf("Trying to read too much data.")
 		}
 
 		return d.Copy(src, uint64(offset), length)
@@ -151,3 +160,127 @@ func calculateRefDelta(ref Sha1, delta []byte, refs map[Sha1]resolvedDelta) (Pac
 	}
 	return calculateDelta(refdata, delta)
 }
+
+// The size of the blocks of the base which are indexed when creating a
+// delta. Matches shorter than this are never found.
+const deltaBlockSize = 16
+
+// The largest number of bytes that a single copy instruction created by
+// createDelta will copy.
+const maxDeltaCopy = 0x10000
+
+// Writes v to w in the variable length format used for the sizes at the
+// start of a delta.
+func writeDeltaSize(w *bytes.Buffer, v uint64) {
+	for v >= 0x80 {
+		w.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	w.WriteByte(byte(v))
+}
+
+// Writes a copy instruction of length bytes from offset in the base to w.
+func writeDeltaCopy(w *bytes.Buffer, offset, length uint64) {
+	var args [7]byte
+	op := byte(0x80)
+	n := 0
+	for i := uint(0); i < 4; i++ {
+		if b := byte(offset >> (8 * i)); b != 0 {
+			op |= 1 << i
+			args[n] = b
+			n++
+		}
+	}
+	for i := uint(0); i < 3; i++ {
+		if b := byte(length >> (8 * i)); b != 0 {
+			op |= 0x10 << i
+			args[n] = b
+			n++
+		}
+	}
+	w.WriteByte(op)
+	w.Write(args[:n])
+}
+
+// Writes insert instructions for data to w.
+func writeDeltaInsert(w *bytes.Buffer, data []byte) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > 127 {
+			n = 127
+		}
+		w.WriteByte(byte(n))
+		w.Write(data[:n])
+		data = data[n:]
+	}
+}
+
+// Creates a delta which can be applied to base to create target, in the
+// format used by packfiles.
+//
+// The base is indexed in blocks of deltaBlockSize bytes, and then every
+// position in target is looked up in the index. Any match is extended as
+// far as possible in both directions and turned into a copy instruction,
+// while everything else is inserted literally.
+func createDelta(base, target []byte) []byte {
+	var delta bytes.Buffer
+	writeDeltaSize(&delta, uint64(len(base)))
+	writeDeltaSize(&delta, uint64(len(target)))
+
+	index := make(map[[deltaBlockSize]byte][]int)
+	var block [deltaBlockSize]byte
+	for i := 0; i+deltaBlockSize <= len(base); i += deltaBlockSize {
+		copy(block[:], base[i:])
+		// Limit the number of candidates for very repetitive data,
+		// so that it doesn't take quadratic time.
+		if len(index[block]) < 64 {
+			index[block] = append(index[block], i)
+		}
+	}
+
+	var pending []byte
+	for i := 0; i < len(target); {
+		if i+deltaBlockSize > len(target) {
+			pending = append(pending, target[i:]...)
+			break
+		}
+		copy(block[:], target[i:])
+		bestOffset, bestLen := 0, 0
+		for _, offset := range index[block] {
+			n := deltaBlockSize
+			for offset+n < len(base) && i+n < len(target) && base[offset+n] == target[i+n] {
+				n++
+			}
+			if n > bestLen {
+				bestOffset, bestLen = offset, n
+			}
+		}
+		if bestLen == 0 {
+			pending = append(pending, target[i])
+			i++
+			continue
+		}
+		i += bestLen
+
+		// Extend the match backwards into anything that was going to
+		// be inserted.
+		for bestOffset > 0 && len(pending) > 0 && base[bestOffset-1] == pending[len(pending)-1] {
+			bestOffset--
+			bestLen++
+			pending = pending[:len(pending)-1]
+		}
+		writeDeltaInsert(&delta, pending)
+		pending = pending[:0]
+		for bestLen > 0 {
+			n := bestLen
+			if n > maxDeltaCopy {
+				n = maxDeltaCopy
+			}
+			writeDeltaCopy(&delta, uint64(bestOffset), uint64(n))
+			bestOffset += n
+			bestLen -= n
+		}
+	}
+	writeDeltaInsert(&delta, pending)
+	return delta.Bytes()
+}
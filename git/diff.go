@@ -1,10 +1,14 @@
 package git
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
-	"os/exec"
-	"strconv"
+
+	"github.com/driusan/dgit/diff"
 )
 
 // Describes the options that may be specified on the command line for
@@ -17,6 +21,10 @@ type DiffOptions struct {
 	NoIndex bool
 }
 
+// ErrFilesDiffer is returned by Diff with the NoIndex option when the files
+// are different. The diff has still been written when it's returned.
+var ErrFilesDiffer = errors.New("Files differ")
+
 // DiffFiles implements the git diff-files command.
 // It compares the file system to the index.
 func Diff(c *Client, opt DiffOptions, paths []File) ([]HashDiff, error) {
@@ -25,17 +33,10 @@ func Diff(c *Client, opt DiffOptions, paths []File) ([]HashDiff, error) {
 			return nil, fmt.Errorf("Must provide 2 paths for git diff --no-index")
 		}
 
-		// Just invoke the system diff command, we can't return a HashDiff
-		// since we're not working things that are tracked by the repo.
-		// we just directly invoke diff if --no-index is specified.
-		diffcmd := exec.Command(posixDiff, "-U", strconv.Itoa(opt.NumContextLines), paths[0].String(), paths[1].String())
-		diffcmd.Stderr = os.Stderr
-		diffcmd.Stdout = os.Stdout
-
-		if err := diffcmd.Run(); err != nil {
-			return nil, err
-		}
-		return nil, nil
+		// We can't return a HashDiff since we're not working with
+		// things that are tracked by the repo, so the diff is written
+		// directly.
+		return nil, diffNoIndex(c, opt.DiffCommonOptions, paths[0], paths[1], os.Stdout)
 	}
 	if err := refreshIndex(c); err != nil {
 		return nil, err
@@ -61,3 +62,61 @@ func Diff(c *Client, opt DiffOptions, paths []File) ([]HashDiff, error) {
 		},
 		paths)
 }
+
+// Writes the diff between the files a and b, which don't need to be in the
+// repo, to w. ErrFilesDiffer is returned if they're different.
+func diffNoIndex(c *Client, opts DiffCommonOptions, a, b File, w io.Writer) error {
+	src, err := ioutil.ReadFile(a.String())
+	if err != nil {
+		return err
+	}
+	dst, err := ioutil.ReadFile(b.String())
+	if err != nil {
+		return err
+	}
+	srcMode, dstMode := ModeBlob, ModeBlob
+	if info, err := a.Stat(); err == nil && info.Mode()&0100 != 0 {
+		srcMode = ModeExec
+	}
+	if info, err := b.Stat(); err == nil && info.Mode()&0100 != 0 {
+		dstMode = ModeExec
+	}
+	if bytes.Equal(src, dst) && srcMode == dstMode {
+		return nil
+	}
+
+	alg, err := opts.diffAlgorithm(c)
+	if err != nil {
+		return err
+	}
+	srcSha, _, err := HashSlice("blob", src)
+	if err != nil {
+		return err
+	}
+	dstSha, _, err := HashSlice("blob", dst)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "diff --git a/%v b/%v\n", a, b)
+	if srcMode != dstMode {
+		fmt.Fprintf(w, "old mode %o\nnew mode %o\n", srcMode, dstMode)
+	}
+	if srcSha != dstSha {
+		index := fmt.Sprintf("index %v..%v", srcSha.String()[:7], dstSha.String()[:7])
+		if srcMode == dstMode {
+			index += fmt.Sprintf(" %o", srcMode)
+		}
+		fmt.Fprintln(w, index)
+	}
+	if diff.IsBinary(src) || diff.IsBinary(dst) {
+		if srcSha != dstSha {
+			fmt.Fprintf(w, "Binary files a/%v and b/%v differ\n", a, b)
+		}
+		return ErrFilesDiffer
+	}
+	hunks := diff.Unified(alg, src, dst, opts.NumContextLines)
+	if err := diff.WriteUnified(w, "a/"+a.String(), "b/"+b.String(), hunks); err != nil {
+		return err
+	}
+	return ErrFilesDiffer
+}
@@ -0,0 +1,290 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/driusan/dgit/diff"
+)
+
+// ErrCheckFailed is returned by GeneratePatch with the Check option when
+// the diffs introduce whitespace errors or leftover conflict markers. All
+// of the output has still been written when it's returned.
+var ErrCheckFailed = errors.New("Diff has whitespace errors or conflict markers")
+
+// A wsRule is a set of whitespace problems which are errors, as configured
+// by core.whitespace. The low bits are the width of a tab.
+type wsRule uint
+
+const (
+	wsBlankAtEOL wsRule = 1 << (iota + 6)
+	wsSpaceBeforeTab
+	wsIndentWithNonTab
+	wsTabInIndent
+	wsCRAtEOL
+	wsBlankAtEOF
+
+	wsTrailingSpace = wsBlankAtEOL | wsBlankAtEOF
+	wsTabWidthMask  = 077
+	wsDefaultRule   = wsTrailingSpace | wsSpaceBeforeTab | 8
+)
+
+// Returns the whitespace rule from the core.whitespace config, which is a
+// comma separated list of problems to turn on, or off if prefixed by "-".
+func whitespaceRule(c *Client) wsRule {
+	names := map[string]wsRule{
+		"trailing-space":      wsTrailingSpace,
+		"space-before-tab":    wsSpaceBeforeTab,
+		"indent-with-non-tab": wsIndentWithNonTab,
+		"tab-in-indent":       wsTabInIndent,
+		"cr-at-eol":           wsCRAtEOL,
+		"blank-at-eol":        wsBlankAtEOL,
+		"blank-at-eof":        wsBlankAtEOF,
+	}
+	rule := wsDefaultRule
+	for _, word := range strings.Split(c.GetConfig("core.whitespace"), ",") {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		if strings.HasPrefix(word, "tabwidth=") {
+			width, err := strconv.Atoi(word[len("tabwidth="):])
+			if err != nil || width < 1 || width > wsTabWidthMask {
+				log.Printf("tabwidth %v out of range\n", word[len("tabwidth="):])
+				continue
+			}
+			rule = rule&^wsTabWidthMask | wsRule(width)
+			continue
+		}
+		negate := strings.HasPrefix(word, "-")
+		bits, ok := names[strings.TrimPrefix(word, "-")]
+		if !ok {
+			log.Printf("unknown core.whitespace value '%v'\n", word)
+			continue
+		}
+		if negate {
+			rule &^= bits
+		} else {
+			rule |= bits
+		}
+	}
+	if rule&wsTabInIndent != 0 && rule&wsIndentWithNonTab != 0 {
+		log.Printf("cannot enforce both tab-in-indent and indent-with-non-tab\n")
+	}
+	return rule
+}
+
+func (rule wsRule) tabWidth() int {
+	return int(rule & wsTabWidthMask)
+}
+
+// Returns the description of the whitespace errors in bad, as used by
+// --check.
+func whitespaceErrorString(bad wsRule) string {
+	var errs []string
+	if bad&wsBlankAtEOL != 0 {
+		errs = append(errs, "trailing whitespace")
+	}
+	if bad&wsBlankAtEOF != 0 {
+		errs = append(errs, "new blank line at EOF")
+	}
+	if bad&wsSpaceBeforeTab != 0 {
+		errs = append(errs, "space before tab in indent")
+	}
+	if bad&wsIndentWithNonTab != 0 {
+		errs = append(errs, "indent with spaces")
+	}
+	if bad&wsTabInIndent != 0 {
+		errs = append(errs, "tab in indent")
+	}
+	return strings.Join(errs, ", ")
+}
+
+// Checks line (without the leading "+" or "-") for the whitespace errors
+// in rule, and returns the errors which were found. If w is not nil, the
+// line is also written to it, with the errors highlighted in the ws colour
+// and the rest of the line in the set colour.
+func checkWhitespace(w io.Writer, line string, rule wsRule, set, reset, ws string) wsRule {
+	var bad wsRule
+
+	newline := strings.HasSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\n")
+	cr := false
+	if rule&wsCRAtEOL != 0 && strings.HasSuffix(line, "\r") {
+		cr = true
+		line = line[:len(line)-1]
+	}
+
+	// Find trailing whitespace.
+	trailing := len(line)
+	if rule&wsBlankAtEOL != 0 {
+		trailing = len(strings.TrimRight(line, " \t\n\v\f\r"))
+		if trailing != len(line) {
+			bad |= wsBlankAtEOL
+		}
+	}
+
+	// Check the indentation. written is the amount of the line which
+	// has been written so far.
+	written := 0
+	i := 0
+	for ; i < trailing; i++ {
+		if line[i] == ' ' {
+			continue
+		}
+		if line[i] != '\t' {
+			break
+		}
+		if rule&wsSpaceBeforeTab != 0 && written < i {
+			bad |= wsSpaceBeforeTab
+			if w != nil {
+				fmt.Fprintf(w, "%v%v%v%c", ws, line[written:i], reset, line[i])
+			}
+		} else if rule&wsTabInIndent != 0 {
+			bad |= wsTabInIndent
+			if w != nil {
+				fmt.Fprintf(w, "%v%v%c%v", line[written:i], ws, line[i], reset)
+			}
+		} else if w != nil {
+			fmt.Fprint(w, line[written:i+1])
+		}
+		written = i + 1
+	}
+
+	// Check for indentation with spaces where a tab could be used.
+	if rule&wsIndentWithNonTab != 0 && i-written >= rule.tabWidth() {
+		bad |= wsIndentWithNonTab
+		if w != nil {
+			fmt.Fprintf(w, "%v%v%v", ws, line[written:i], reset)
+		}
+		written = i
+	}
+
+	if w != nil {
+		if trailing > written {
+			fmt.Fprintf(w, "%v%v%v", set, line[written:trailing], reset)
+		}
+		if trailing != len(line) {
+			fmt.Fprintf(w, "%v%v%v", ws, line[trailing:], reset)
+		}
+		if cr {
+			fmt.Fprint(w, "\r")
+		}
+		if newline {
+			fmt.Fprintln(w)
+		}
+	}
+	return bad
+}
+
+// Returns true if line only has whitespace.
+func blankLine(line string) bool {
+	return strings.Trim(line, " \t\n\v\f\r") == ""
+}
+
+// Returns the number of blank lines at the end of content. This is a port
+// of git's count_trailing_blank, including its quirk of not counting the
+// first line of the file unless it has at least one character before the
+// newline.
+func trailingBlankLines(content []byte) int {
+	if len(content) == 0 {
+		return 0
+	}
+	end := len(content) - 1
+	if content[end] == '\n' {
+		end--
+	}
+	n := 0
+	for end > 0 {
+		prev := bytes.LastIndexByte(content[:end+1], '\n')
+		if !blankLine(string(content[prev+1 : end+1])) {
+			break
+		}
+		n++
+		end = prev - 1
+	}
+	return n
+}
+
+// Returns the line numbers of the first of the blank lines at the end of
+// src and dst if dst adds blank lines to the end of the file, or zeros if
+// it doesn't.
+func blankAtEOF(src, dst []byte) (srcLine, dstLine int) {
+	srcBlank, dstBlank := trailingBlankLines(src), trailingBlankLines(dst)
+	if dstBlank <= srcBlank {
+		return 0, 0
+	}
+	return len(diff.Lines(src)) - srcBlank + 1, len(diff.Lines(dst)) - dstBlank + 1
+}
+
+// Returns true if line (without the leading "+") is a conflict marker
+// left over from a merge.
+func isConflictMarker(line string) bool {
+	const size = 7
+	if len(line) < size+1 || !strings.ContainsRune("<=>|", rune(line[0])) {
+		return false
+	}
+	if line[:size] != strings.Repeat(line[:1], size) {
+		return false
+	}
+	return strings.ContainsRune(" \t\n\v\f\r", rune(line[size]))
+}
+
+// Writes the whitespace errors and conflict markers introduced by d to w,
+// in the format of git diff --check. Returns true if there were any.
+func (d HashDiff) writeCheck(c *Client, w io.Writer, opts DiffCommonOptions, colors diffColors) (bool, error) {
+	if d.Src.FileMode == ModeTree || d.Dst.FileMode == ModeTree || d.Dst.FileMode == 0 {
+		return false, nil
+	}
+	alg, err := opts.diffAlgorithm(c)
+	if err != nil {
+		return false, err
+	}
+	src, dst, err := d.contents(c)
+	if err != nil {
+		return false, err
+	}
+	if diff.IsBinary(dst) || bytes.Equal(src, dst) {
+		return false, nil
+	}
+	rule := whitespaceRule(c)
+
+	found := false
+	line := 0
+	for _, l := range diff.Diff(alg, diff.Lines(src), diff.Lines(dst)) {
+		if l.Op == diff.Delete {
+			continue
+		}
+		line++
+		if l.Op != diff.Insert {
+			continue
+		}
+		if isConflictMarker(l.Text) {
+			found = true
+			fmt.Fprintf(w, "%v:%d: leftover conflict marker\n", d.Name, line)
+		}
+		bad := checkWhitespace(nil, l.Text, rule, "", "", "")
+		if bad == 0 {
+			continue
+		}
+		found = true
+		fmt.Fprintf(w, "%v:%d: %v.\n", d.Name, line, whitespaceErrorString(bad))
+		fmt.Fprintf(w, "%v+%v", colors.new, colors.reset)
+		checkWhitespace(w, l.Text, rule, colors.new, colors.reset, colors.whitespace)
+		if !strings.HasSuffix(l.Text, "\n") {
+			fmt.Fprintln(w)
+		}
+	}
+	if rule&wsBlankAtEOF != 0 {
+		if _, line := blankAtEOF(src, dst); line > 0 {
+			found = true
+			fmt.Fprintf(w, "%v:%d: %v.\n", d.Name, line, whitespaceErrorString(wsBlankAtEOF))
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,224 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The escape sequence which turns off any colours and attributes.
+const colorReset = "\033[m"
+
+// The colours used for each part of a diff, as ANSI escape sequences.
+// They're all empty if colour isn't being used.
+type diffColors struct {
+	// The "diff --git" line and extended headers.
+	meta string
+
+	// The "@@" line introducing each hunk.
+	frag string
+
+	// Unchanged, removed and added lines.
+	context, old, new string
+
+	// The "commit" line of git show.
+	commit string
+
+	// Whitespace errors in added lines.
+	whitespace string
+
+	reset string
+}
+
+// Parses a git colour value, such as "bold red" or "#ff0000 ul", into an
+// ANSI escape sequence. The first colour is the foreground and the second
+// is the background. An empty (or "normal") value means no escape sequence.
+func parseColor(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, "reset") {
+		return colorReset, nil
+	}
+	attrs := make(map[int]bool)
+	var fg, bg string
+	colors := 0
+	for _, word := range strings.Fields(value) {
+		if code, ok := parseColorAttr(word); ok {
+			attrs[code] = true
+			continue
+		}
+		if colors == 2 {
+			return "", fmt.Errorf("invalid color value: %v", value)
+		}
+		fgcode, bgcode, ok := parseColorName(word)
+		if !ok {
+			return "", fmt.Errorf("invalid color value: %v", value)
+		}
+		if colors == 0 {
+			fg = fgcode
+		} else {
+			bg = bgcode
+		}
+		colors++
+	}
+
+	// Attributes come first, in numeric order, then the colours.
+	var codes []string
+	for code := 0; code < 30; code++ {
+		if attrs[code] {
+			codes = append(codes, strconv.Itoa(code))
+		}
+	}
+	if fg != "" {
+		codes = append(codes, fg)
+	}
+	if bg != "" {
+		codes = append(codes, bg)
+	}
+	if len(codes) == 0 {
+		return "", nil
+	}
+	return "\033[" + strings.Join(codes, ";") + "m", nil
+}
+
+// Returns the SGR code for an attribute such as "bold" or "noul".
+func parseColorAttr(word string) (int, bool) {
+	attrs := map[string]int{
+		"bold":    1,
+		"dim":     2,
+		"italic":  3,
+		"ul":      4,
+		"blink":   5,
+		"reverse": 7,
+		"strike":  9,
+	}
+	word = strings.ToLower(word)
+	negate := false
+	if strings.HasPrefix(word, "no") {
+		negate = true
+		word = strings.TrimPrefix(word[2:], "-")
+	}
+	code, ok := attrs[word]
+	if !ok {
+		return 0, false
+	}
+	if negate {
+		// Bold and dim are both turned off by 22.
+		if code == 1 {
+			code = 2
+		}
+		code += 20
+	}
+	return code, true
+}
+
+// Returns the SGR codes for the colour name word as a foreground and as a
+// background. An empty code means the terminal's colour isn't changed.
+func parseColorName(word string) (fg, bg string, ok bool) {
+	names := []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+	word = strings.ToLower(word)
+	switch {
+	case word == "normal":
+		return "", "", true
+	case word == "default":
+		return "39", "49", true
+	case strings.HasPrefix(word, "#") && len(word) == 7:
+		rgb, err := strconv.ParseUint(word[1:], 16, 32)
+		if err != nil {
+			return "", "", false
+		}
+		c := fmt.Sprintf("2;%d;%d;%d", rgb>>16, (rgb>>8)&0xff, rgb&0xff)
+		return "38;" + c, "48;" + c, true
+	}
+	for i, name := range names {
+		if word == name {
+			return strconv.Itoa(30 + i), strconv.Itoa(40 + i), true
+		}
+		if word == "bright"+name {
+			return strconv.Itoa(90 + i), strconv.Itoa(100 + i), true
+		}
+	}
+	n, err := strconv.Atoi(word)
+	switch {
+	case err != nil || n < -1 || n > 255:
+		return "", "", false
+	case n == -1:
+		return "", "", true
+	case n < 8:
+		return strconv.Itoa(30 + n), strconv.Itoa(40 + n), true
+	case n < 16:
+		return strconv.Itoa(90 + n - 8), strconv.Itoa(100 + n - 8), true
+	default:
+		return fmt.Sprintf("38;5;%d", n), fmt.Sprintf("48;5;%d", n), true
+	}
+}
+
+// Returns true if w is a terminal which can show colours.
+func isColorTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	if term := os.Getenv("TERM"); term == "" || term == "dumb" {
+		return false
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Returns true if diffs written to w should be coloured.
+func (opts DiffCommonOptions) useColor(w io.Writer) bool {
+	if opts.WordDiff == "color" {
+		return true
+	}
+	if opts.Color != nil {
+		return *opts.Color
+	}
+	return isColorTerminal(w)
+}
+
+// Returns the colours to use for diffs written to w, from the
+// color.diff.<slot> config, or all empty colours if w shouldn't be
+// coloured.
+func (opts DiffCommonOptions) diffColors(c *Client, w io.Writer) (diffColors, error) {
+	if !opts.useColor(w) {
+		return diffColors{}, nil
+	}
+	colors := diffColors{
+		meta:       "\033[1m",
+		frag:       "\033[36m",
+		old:        "\033[31m",
+		new:        "\033[32m",
+		commit:     "\033[33m",
+		whitespace: "\033[41m",
+		reset:      colorReset,
+	}
+	for _, slot := range []struct {
+		name  string
+		color *string
+	}{
+		{"plain", &colors.context},
+		{"context", &colors.context},
+		{"meta", &colors.meta},
+		{"frag", &colors.frag},
+		{"old", &colors.old},
+		{"new", &colors.new},
+		{"commit", &colors.commit},
+		{"whitespace", &colors.whitespace},
+	} {
+		value := c.GetConfig("color.diff." + slot.name)
+		if value == "" {
+			continue
+		}
+		color, err := parseColor(value)
+		if err != nil {
+			return diffColors{}, err
+		}
+		*slot.color = color
+	}
+	return colors, nil
+}
@@ -0,0 +1,38 @@
+package git
+
+import (
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"", ""},
+		{"normal", ""},
+		{"reset", "\033[m"},
+		{"red", "\033[31m"},
+		{"blue bold ul", "\033[1;4;34m"},
+		{"nobold 208 black", "\033[22;38;5;208;40m"},
+		{"#ff0000 reverse", "\033[7;38;2;255;0;0m"},
+		{"brightred normal", "\033[91m"},
+		{"normal brightred", "\033[101m"},
+		{"no-ul", "\033[24m"},
+	}
+	for _, tc := range tests {
+		got, err := parseColor(tc.value)
+		if err != nil {
+			t.Errorf("%q: %v", tc.value, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %q want %q", tc.value, got, tc.want)
+		}
+	}
+
+	for _, value := range []string{"red green blue", "purple", "#ff00", "256"} {
+		if _, err := parseColor(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
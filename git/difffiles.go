@@ -2,7 +2,10 @@ package git
 
 import (
 	"log"
+	"regexp"
 	"sort"
+
+	"github.com/driusan/dgit/diff"
 )
 
 // Options that are shared between git diff, git diff-files, diff-index,
@@ -19,6 +22,147 @@ type DiffCommonOptions struct {
 
 	// Exit with a exit code of 1 if there are any diffs
 	ExitCode bool
+
+	// Can be "default", "myers", "minimal", "patience", or "histogram".
+	// If unset, the diff.algorithm config is used.
+	DiffAlgorithm string
+
+	// Only show the names of the changed files, or the names and the
+	// status of each change, instead of a raw diff or patch.
+	NameOnly, NameStatus bool
+
+	// Detect renames, and copies if DetectCopies is set, of files
+	// which are at least RenameScore similar.
+	DetectRenames, DetectCopies bool
+
+	// Also look for the source of copies in files which weren't
+	// modified. Implies DetectCopies.
+	FindCopiesHarder bool
+
+	// The minimum similarity, out of MaxRenameScore, for a pair of files
+	// to be a rename or copy. The zero value means 50%.
+	RenameScore int
+
+	// If there are more than RenameLimit squared pairs of files to
+	// compare, only exact renames are detected. The zero value uses
+	// diff.renameLimit, or 1000 if it's not set.
+	RenameLimit int
+
+	// Show a diffstat with a graph of the number of lines added and
+	// removed in each file.
+	Stat bool
+
+	// The maximum width of the diffstat, of the filename part and of
+	// the graph part of it, and the maximum number of files to show.
+	// The zero values mean 80 columns, and no limit for the others.
+	StatWidth, StatNameWidth, StatGraphWidth, StatCount int
+
+	// Show the number of lines added and removed from each file in a
+	// machine readable format, or only the total for all files.
+	NumStat, ShortStat bool
+
+	// Show the percentage of the changes in each directory. The
+	// DirStatParams are a comma separated list of the same parameters
+	// as --dirstat, which override the diff.dirstat config.
+	DirStat       bool
+	DirStatParams string
+
+	// Show a summary of the files which were created, deleted, renamed,
+	// copied or changed mode.
+	Summary bool
+
+	// Colour can have three states: "always" (true), "never" (false), or
+	// "auto" (nil), which colours the output if it's a terminal.
+	Color *bool
+
+	// Show the words which changed instead of the lines. Can be "color",
+	// "plain", "porcelain", or "none".
+	WordDiff string
+
+	// What's considered to be a word by WordDiff. The default is runs of
+	// non-whitespace characters.
+	WordDiffRegex *regexp.Regexp
+
+	// Warn if changes introduce conflict markers or whitespace errors.
+	Check bool
+
+	// Which lines of a coloured patch have whitespace errors
+	// highlighted. Valid options in the []string are "old", "new",
+	// "context", "all", "none" and "default". The default is "new".
+	WhitespaceErrorHighlight []string
+
+	// Ignore changes in whitespace when comparing lines, the same as
+	// the flags in the diff package, and changes which only add or
+	// remove blank lines.
+	IgnoreSpaceAtEOL, IgnoreSpaceChange, IgnoreAllSpace bool
+	IgnoreBlankLines                                    bool
+
+	// Show the full object names on the index lines of patches, instead
+	// of abbreviating them.
+	FullIndex bool
+
+	// Output a "GIT binary patch" which can be applied by git apply
+	// for binary files, instead of only saying that they differ. The
+	// index lines of binary files are also shown in full.
+	Binary bool
+}
+
+// Returns true if the options would produce any output for a diff.
+func (opts DiffCommonOptions) showsDiffs() bool {
+	return opts.Patch || opts.Raw || opts.NameOnly || opts.NameStatus ||
+		opts.Stat || opts.NumStat || opts.ShortStat || opts.DirStat || opts.Summary ||
+		opts.Check
+}
+
+// Returns the changes in whitespace that are ignored when comparing lines.
+func (opts DiffCommonOptions) whitespace() diff.Whitespace {
+	var ws diff.Whitespace
+	if opts.IgnoreSpaceAtEOL {
+		ws |= diff.IgnoreSpaceAtEOL
+	}
+	if opts.IgnoreSpaceChange {
+		ws |= diff.IgnoreSpaceChange
+	}
+	if opts.IgnoreAllSpace {
+		ws |= diff.IgnoreAllSpace
+	}
+	if opts.IgnoreBlankLines {
+		ws |= diff.IgnoreBlankLines
+	}
+	return ws
+}
+
+// Returns which lines of a patch should have whitespace errors
+// highlighted.
+func (opts DiffCommonOptions) wsErrorHighlight() (old, new, context bool) {
+	if len(opts.WhitespaceErrorHighlight) == 0 {
+		return false, true, false
+	}
+	for _, kind := range opts.WhitespaceErrorHighlight {
+		switch kind {
+		case "old":
+			old = true
+		case "new":
+			new = true
+		case "context":
+			context = true
+		case "all":
+			old, new, context = true, true, true
+		case "none":
+			old, new, context = false, false, false
+		case "default":
+			old, new, context = false, true, false
+		}
+	}
+	return old, new, context
+}
+
+// Returns the diff algorithm that should be used to generate patches.
+func (opts DiffCommonOptions) diffAlgorithm(c *Client) (diff.Algorithm, error) {
+	if opts.DiffAlgorithm != "" {
+		return diff.ParseAlgorithm(opts.DiffAlgorithm)
+	}
+	return diff.ParseAlgorithm(c.GetConfig("diff.algorithm"))
 }
 
 // Describes the options that may be specified on the command line for
@@ -52,12 +196,12 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 		if err != nil || !f.Exists() {
 			// If there was an error, treat it as a non-existant file
 			// and just use the empty Sha1
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), 0})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize)})
 			continue
 		}
 		stat, err := f.Lstat()
 		if err != nil {
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), 0})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize)})
 			continue
 		}
 
@@ -66,7 +210,7 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 			// Since we're diffing files in the index (which only holds files)
 			// against a directory, it means that the file was deleted and
 			// replaced by a directory.
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), 0})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize)})
 			continue
 		case !stat.Mode().IsRegular():
 			// FIXME: This doesn't take into account that the file
@@ -80,7 +224,7 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 		size := stat.Size()
 		if err := idx.CompareStat(f); err != nil {
 			log.Printf("Stat information does not match for %v: %v\n", f, err)
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), uint(size)})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize), DstSize: uint(size)})
 			continue
 		}
 
@@ -89,7 +233,7 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 		hash, _, err := HashFile("blob", f.String())
 
 		if err != nil || hash != idx.Sha1 {
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), uint(size)})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize), DstSize: uint(size)})
 		}
 	}
 
@@ -1,7 +1,7 @@
 package git
 
 import (
-//	"fmt"
+	"sort"
 )
 
 // Describes the options that may be specified on the command line for
@@ -49,9 +49,23 @@ func DiffIndex(c *Client, opt DiffIndexOptions, index *Index, tree Treeish, path
 		}{TreeEntry{path.Sha1, path.Mode}, uint(path.Fsize)}
 	}
 
+	var pathspecs []IndexPath
+	for _, p := range paths {
+		ip, err := p.IndexPath(c)
+		if err != nil {
+			return nil, err
+		}
+		pathspecs = append(pathspecs, cleanPathspec(ip))
+	}
+
 	var val []HashDiff
 
+	inIndex := make(map[IndexPath]bool)
 	for _, entry := range index.Objects {
+		if !inPathspecs(entry.PathName, pathspecs) {
+			continue
+		}
+		inIndex[entry.PathName] = true
 		f, err := entry.PathName.FilePath(c)
 		if err != nil {
 			return nil, err
@@ -77,16 +91,26 @@ func DiffIndex(c *Client, opt DiffIndexOptions, index *Index, tree Treeish, path
 		}
 
 		if entry.Sha1 != fssha {
-			val = append(val, HashDiff{entry.PathName, treeObjects[entry.PathName].Tree, TreeEntry{Sha1: Sha1{}, FileMode: mode}, treeObjects[entry.PathName].Size, 0})
+			val = append(val, HashDiff{Name: entry.PathName, Src: treeObjects[entry.PathName].Tree, Dst: TreeEntry{Sha1: Sha1{}, FileMode: mode}, SrcSize: treeObjects[entry.PathName].Size})
 		} else if !ok {
-			val = append(val, HashDiff{entry.PathName, TreeEntry{}, TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, 0, fsize})
+			val = append(val, HashDiff{Name: entry.PathName, Dst: TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, DstSize: fsize})
 		} else if entry.Sha1 != treeSha.Tree.Sha1 {
-			val = append(val, HashDiff{entry.PathName, treeSha.Tree, TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, treeSha.Size, fsize})
+			val = append(val, HashDiff{Name: entry.PathName, Src: treeSha.Tree, Dst: TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, SrcSize: treeSha.Size, DstSize: fsize})
 		} else {
 			if err != nil {
 				return nil, err
 			}
 		}
 	}
-	return val, nil
+
+	// Files which were removed from the index are deleted.
+	base := make(map[IndexPath]TreeEntry)
+	for name, entry := range treeObjects {
+		base[name] = entry.Tree
+		if !inIndex[name] {
+			val = append(val, HashDiff{Name: name, Src: entry.Tree, SrcSize: entry.Size})
+		}
+	}
+	sort.Sort(ByName(val))
+	return detectRenames(c, opt.DiffCommonOptions, val, base)
 }
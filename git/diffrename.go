@@ -0,0 +1,439 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/driusan/dgit/diff"
+)
+
+// The similarity score of identical files, which RenameScore is out of.
+const MaxRenameScore = 60000
+
+// The default minimum score for renames and copies, 50%.
+const defaultRenameScore = MaxRenameScore / 2
+
+// The maximum number of candidate sources to remember for each
+// destination while looking for inexact renames.
+const renameCandidatesPerDst = 4
+
+// ParseRenameScore parses the <n> in -M<n> or -C<n> into a score out of
+// MaxRenameScore, the same way as git. The digits are the fractional part
+// of a decimal unless they're followed by a "%", so "5", ".5" and "50%"
+// are all 50%. An empty string is 0, which means the default of 50%.
+func ParseRenameScore(s string) (int, error) {
+	num, scale := 0, 1
+	dot := false
+	for i, c := range s {
+		switch {
+		case c == '.' && !dot:
+			scale = 1
+			dot = true
+		case c == '%' && i == len(s)-1:
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+		case c >= '0' && c <= '9':
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(c-'0')
+			}
+		default:
+			return 0, fmt.Errorf("Invalid rename score: %v", s)
+		}
+	}
+	if num >= scale {
+		return MaxRenameScore, nil
+	}
+	return MaxRenameScore * num / scale, nil
+}
+
+// A renameFile is a file which may be the source or destination of a
+// rename or copy.
+type renameFile struct {
+	name  IndexPath
+	entry TreeEntry
+
+	// The index in the list of diffs that the file came from, or -1 if
+	// it's an unmodified file being considered as the source of copies.
+	diff int
+
+	// For a source, the number of destinations using it (plus one if it
+	// still exists, so that they're all copies.)
+	used int
+
+	// For a destination, the source that it was paired with and the
+	// score.
+	src   *renameFile
+	score int
+
+	data   []byte
+	loaded bool
+	spans  []span
+	hashed bool
+}
+
+// Returns the content of the file. A file with a mode but no hash is in
+// the work tree.
+func (f *renameFile) content(c *Client) ([]byte, error) {
+	if f.loaded {
+		return f.data, nil
+	}
+	if f.entry.Sha1 != (Sha1{}) {
+		obj, err := c.GetObject(f.entry.Sha1)
+		if err != nil {
+			return nil, err
+		}
+		f.data = obj.GetContent()
+	} else {
+		h := HashDiff{Name: f.name, Dst: f.entry}
+		_, dst, err := h.contents(c)
+		if err != nil {
+			return nil, err
+		}
+		f.data = dst
+	}
+	f.loaded = true
+	return f.data, nil
+}
+
+// detectRenames pairs up the files which were added in diffs with files
+// that were deleted (or, for copies, modified or in base) and are similar
+// enough, in the same way as git's diffcore-rename. Identical files are
+// paired first, and then the most similar pairs of the rest.
+//
+// base is every file on the source side of the diff, which is only used
+// if FindCopiesHarder is set.
+func detectRenames(c *Client, opts DiffCommonOptions, diffs []HashDiff, base map[IndexPath]TreeEntry) ([]HashDiff, error) {
+	copies := opts.DetectCopies || opts.FindCopiesHarder
+	if !opts.DetectRenames && !copies {
+		return diffs, nil
+	}
+	minScore := opts.RenameScore
+	if minScore == 0 {
+		minScore = defaultRenameScore
+	}
+
+	var srcs, dsts []*renameFile
+	changed := make(map[IndexPath]bool)
+	for i, d := range diffs {
+		changed[d.Name] = true
+		if d.Src.FileMode == ModeTree || d.Dst.FileMode == ModeTree {
+			continue
+		}
+		switch {
+		case d.Src.FileMode == 0 && d.Dst.FileMode != 0:
+			dsts = append(dsts, &renameFile{name: d.Name, entry: d.Dst, diff: i})
+		case d.Src.FileMode != 0 && d.Dst.FileMode == 0:
+			srcs = append(srcs, &renameFile{name: d.Name, entry: d.Src, diff: i})
+		case d.Src.FileMode != 0 && copies:
+			// The source stays, so anything using it is a copy.
+			srcs = append(srcs, &renameFile{name: d.Name, entry: d.Src, diff: i, used: 1})
+		}
+	}
+	if opts.FindCopiesHarder {
+		for name, entry := range base {
+			if changed[name] || entry.FileMode == ModeTree {
+				continue
+			}
+			srcs = append(srcs, &renameFile{name: name, entry: entry, diff: -1, used: 1})
+		}
+	}
+	if len(srcs) == 0 || len(dsts) == 0 {
+		return diffs, nil
+	}
+	sort.Slice(srcs, func(i, j int) bool { return srcs[i].name < srcs[j].name })
+
+	record := func(dst, src *renameFile, score int) {
+		dst.src = src
+		dst.score = score
+		src.used++
+	}
+
+	// Pair identical files first. A source which hasn't been used yet,
+	// and then one with the same name in a different directory, is
+	// preferred.
+	bySha := make(map[Sha1][]*renameFile)
+	for _, src := range srcs {
+		bySha[src.entry.Sha1] = append(bySha[src.entry.Sha1], src)
+	}
+	for _, dst := range dsts {
+		if dst.entry.Sha1 == (Sha1{}) {
+			continue
+		}
+		var best *renameFile
+		bestScore := -1
+		for _, src := range bySha[dst.entry.Sha1] {
+			if !src.entry.FileMode.IsRegular() || !dst.entry.FileMode.IsRegular() {
+				if src.entry.FileMode != dst.entry.FileMode {
+					continue
+				}
+			}
+			if src.used > 0 && !copies {
+				continue
+			}
+			score := 0
+			if src.used == 0 {
+				score++
+			}
+			if path.Base(src.name.String()) == path.Base(dst.name.String()) {
+				score++
+			}
+			if score > bestScore {
+				best, bestScore = src, score
+				if score == 2 {
+					break
+				}
+			}
+		}
+		if best != nil {
+			record(dst, best, MaxRenameScore)
+		}
+	}
+
+	// Then compare the content of everything else that's left.
+	var left []*renameFile
+	for _, dst := range dsts {
+		if dst.src == nil {
+			left = append(left, dst)
+		}
+	}
+	var candidates []*renameFile
+	for _, src := range srcs {
+		if copies || src.used == 0 {
+			candidates = append(candidates, src)
+		}
+	}
+	limit := opts.RenameLimit
+	if limit == 0 {
+		limit = 1000
+		if l, err := strconv.Atoi(c.GetConfig("diff.renamelimit")); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	if len(left) > 0 && len(candidates) > 0 {
+		if len(left)*len(candidates) > limit*limit {
+			needed := len(left)
+			if len(candidates) > needed {
+				needed = len(candidates)
+			}
+			fmt.Fprintln(os.Stderr, "warning: exhaustive rename detection was skipped due to too many files.")
+			fmt.Fprintf(os.Stderr, "warning: you may want to set your diff.renameLimit variable to at least %d and retry the command.\n", needed)
+		} else if err := findInexactRenames(c, left, candidates, minScore, copies, record); err != nil {
+			return nil, err
+		}
+	}
+
+	// Replace the additions with the renames and copies, and remove the
+	// deletions which were renamed. If a deleted file was the source of
+	// more than one destination, the last one is the rename and the rest
+	// are copies.
+	pairs := make(map[int]*renameFile)
+	for _, dst := range dsts {
+		if dst.src != nil {
+			pairs[dst.diff] = dst
+		}
+	}
+	renamed := make(map[int]bool)
+	for _, src := range srcs {
+		if src.diff >= 0 && diffs[src.diff].Dst.FileMode == 0 && src.used > 0 {
+			renamed[src.diff] = true
+		}
+	}
+	var val []HashDiff
+	for i, d := range diffs {
+		if renamed[i] {
+			continue
+		}
+		if dst, ok := pairs[i]; ok {
+			src := dst.src
+			d.SrcName = src.name
+			d.Src = src.entry
+			d.Similarity = dst.score * 100 / MaxRenameScore
+			src.used--
+			d.Copied = src.used > 0
+		}
+		val = append(val, d)
+	}
+	return val, nil
+}
+
+// A renameCandidate is a possible source for a destination.
+type renameCandidate struct {
+	dst, src  *renameFile
+	score     int
+	nameScore int
+}
+
+// Compares every destination in dsts with every source in srcs, and pairs
+// them up starting with the most similar. Each source is only used once
+// unless copies is set.
+func findInexactRenames(c *Client, dsts, srcs []*renameFile, minScore int, copies bool, record func(dst, src *renameFile, score int)) error {
+	var matrix []renameCandidate
+	for _, dst := range dsts {
+		var best []renameCandidate
+		for _, src := range srcs {
+			score, err := estimateSimilarity(c, src, dst, minScore)
+			if err != nil {
+				return err
+			}
+			if score < minScore {
+				continue
+			}
+			cand := renameCandidate{dst: dst, src: src, score: score}
+			if path.Base(src.name.String()) == path.Base(dst.name.String()) {
+				cand.nameScore = 1
+			}
+			// Only keep the best few candidates for each
+			// destination.
+			if len(best) < renameCandidatesPerDst {
+				best = append(best, cand)
+				continue
+			}
+			worst := 0
+			for i := range best {
+				if best[i].score < best[worst].score {
+					worst = i
+				}
+			}
+			if best[worst].score < score {
+				best[worst] = cand
+			}
+		}
+		matrix = append(matrix, best...)
+	}
+	sort.SliceStable(matrix, func(i, j int) bool {
+		if matrix[i].score == matrix[j].score {
+			return matrix[i].nameScore > matrix[j].nameScore
+		}
+		return matrix[i].score > matrix[j].score
+	})
+
+	// Find the renames first, and then the copies from sources which
+	// were already used.
+	for _, cand := range matrix {
+		if cand.dst.src != nil || cand.src.used > 0 {
+			continue
+		}
+		record(cand.dst, cand.src, cand.score)
+	}
+	if copies {
+		for _, cand := range matrix {
+			if cand.dst.src != nil {
+				continue
+			}
+			record(cand.dst, cand.src, cand.score)
+		}
+	}
+	return nil
+}
+
+// Returns a score out of MaxRenameScore of how much of dst was copied from
+// src, the same way as git. Only regular files are compared, and if the
+// sizes are too different to reach minScore they aren't read.
+func estimateSimilarity(c *Client, src, dst *renameFile, minScore int) (int, error) {
+	if !src.entry.FileMode.IsRegular() || !dst.entry.FileMode.IsRegular() {
+		return 0, nil
+	}
+	srcData, err := src.content(c)
+	if err != nil {
+		return 0, err
+	}
+	dstData, err := dst.content(c)
+	if err != nil {
+		return 0, err
+	}
+	maxSize, baseSize := uint64(len(srcData)), uint64(len(dstData))
+	if baseSize > maxSize {
+		maxSize, baseSize = baseSize, maxSize
+	}
+	if maxSize*uint64(MaxRenameScore-minScore) < (maxSize-baseSize)*MaxRenameScore {
+		return 0, nil
+	}
+	if len(dstData) == 0 {
+		return 0, nil
+	}
+	copied, _ := countChanges(src.hashSpans(), dst.hashSpans())
+	return int(copied * MaxRenameScore / maxSize), nil
+}
+
+// A span is a count of the bytes in a file which are in lines (or 64 byte
+// chunks of lines) with the same hash.
+type span struct {
+	hash, count uint32
+}
+
+// Returns the spans of the content of f, calculating them the first time
+// that they're needed.
+func (f *renameFile) hashSpans() []span {
+	if !f.hashed {
+		f.spans = hashSpans(f.data)
+		f.hashed = true
+	}
+	return f.spans
+}
+
+// Splits buf into lines and 64 byte chunks, and returns the number of
+// bytes with each hash, sorted by hash. This is the same algorithm as
+// git's diffcore-delta, so that the similarity of files matches, including
+// ignoring a last line without a newline.
+func hashSpans(buf []byte) []span {
+	const hashBase = 107927
+	text := !diff.IsBinary(buf)
+	counts := make(map[uint32]uint32)
+	var accum1, accum2 uint32
+	n := uint32(0)
+	for i := 0; i < len(buf); i++ {
+		c := uint32(buf[i])
+		old1 := accum1
+
+		// Ignore the CR in CRLF in text files.
+		if text && c == '\r' && i+1 < len(buf) && buf[i+1] == '\n' {
+			continue
+		}
+		accum1 = (accum1 << 7) ^ (accum2 >> 25)
+		accum2 = (accum2 << 7) ^ (old1 >> 25)
+		accum1 += c
+		n++
+		if n < 64 && c != '\n' {
+			continue
+		}
+		counts[(accum1+accum2*0x61)%hashBase] += n
+		n = 0
+		accum1, accum2 = 0, 0
+	}
+	spans := make([]span, 0, len(counts))
+	for h, n := range counts {
+		spans = append(spans, span{h, n})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].hash < spans[j].hash })
+	return spans
+}
+
+// Returns the number of bytes of dst which are also in src, and the
+// number of bytes of dst which aren't, given the spans of each.
+func countChanges(src, dst []span) (copied, added uint64) {
+	s := 0
+	for _, d := range dst {
+		for s < len(src) && src[s].hash < d.hash {
+			s++
+		}
+		var srcCount uint32
+		if s < len(src) && src[s].hash == d.hash {
+			srcCount = src[s].count
+			s++
+		}
+		if srcCount < d.count {
+			added += uint64(d.count - srcCount)
+			copied += uint64(srcCount)
+		} else {
+			copied += uint64(d.count)
+		}
+	}
+	return copied, added
+}
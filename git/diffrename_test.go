@@ -0,0 +1,97 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseRenameScore(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"50%", 30000},
+		{"5", 30000},
+		{"90", 54000},
+		{"100%", MaxRenameScore},
+		{"1.5%", 900},
+	}
+	for _, tc := range tests {
+		got, err := ParseRenameScore(tc.in)
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %v want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestDiffTreeRenames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitdiffrenames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := func(files ...string) TreeID {
+		t.Helper()
+		var listing bytes.Buffer
+		for i := 0; i < len(files); i += 2 {
+			blob, err := c.WriteObject("blob", []byte(files[i+1]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&listing, "100644 blob %v\t%v\n", blob, files[i])
+		}
+		tid, err := MkTree(c, MkTreeOptions{}, &listing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tid
+	}
+	lines := strings.Repeat("line\n", 10)
+	other := "one\ntwo\nthree\nfour\nfive\n"
+	from := tree("exact", "exact\n", "inexact", lines, "keep", other, "partial", "one\ntwo\nno newline at the end")
+	to := tree("exact2", "exact\n", "inexact2", lines+"more\n", "keep", other, "keep2", other, "partial2", "zero\none\ntwo\nno newline at the end")
+
+	tests := []struct {
+		name string
+		opts DiffCommonOptions
+		want []string
+	}{
+		{"no renames", DiffCommonOptions{}, []string{"D\texact", "A\texact2", "D\tinexact", "A\tinexact2", "A\tkeep2", "D\tpartial", "A\tpartial2"}},
+		{"renames", DiffCommonOptions{DetectRenames: true}, []string{"R100\texact\texact2", "R090\tinexact\tinexact2", "A\tkeep2", "D\tpartial", "A\tpartial2"}},
+		{"high score", DiffCommonOptions{DetectRenames: true, RenameScore: 57000}, []string{"R100\texact\texact2", "D\tinexact", "A\tinexact2", "A\tkeep2", "D\tpartial", "A\tpartial2"}},
+		{"copies", DiffCommonOptions{DetectRenames: true, DetectCopies: true}, []string{"R100\texact\texact2", "R090\tinexact\tinexact2", "A\tkeep2", "D\tpartial", "A\tpartial2"}},
+		{"copies harder", DiffCommonOptions{DetectRenames: true, DetectCopies: true, FindCopiesHarder: true}, []string{"R100\texact\texact2", "R090\tinexact\tinexact2", "C100\tkeep\tkeep2", "D\tpartial", "A\tpartial2"}},
+	}
+	for _, tc := range tests {
+		diffs, err := DiffTree(c, &DiffTreeOptions{DiffCommonOptions: tc.opts, Recurse: true}, from, to, nil)
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		var got []string
+		for _, d := range diffs {
+			if d.SrcName != "" {
+				got = append(got, fmt.Sprintf("%v\t%v\t%v", d.Status(), d.SrcName, d.Name))
+			} else {
+				got = append(got, fmt.Sprintf("%v\t%v", d.Status(), d.Name))
+			}
+		}
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Errorf("%v: got %q want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,558 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/driusan/dgit/diff"
+)
+
+// A fileStat is the number of lines added to and removed from a file by a
+// diff, or the sizes of the file before and after if it's binary.
+type fileStat struct {
+	// The name to show for the file, which includes the source of
+	// renames and copies, and the path of the file.
+	name, path string
+
+	added, deleted int
+	binary         bool
+}
+
+// Returns the name of a rename or copy from src to dst, with the common
+// leading and trailing directories only shown once, such as
+// "dir/{old => new}/file".
+func renameName(src, dst string) string {
+	// The length of the common leading directories.
+	pfx := 0
+	for i := 0; i < len(src) && i < len(dst) && src[i] == dst[i]; i++ {
+		if src[i] == '/' {
+			pfx = i + 1
+		}
+	}
+
+	// The length of the common trailing directories. If there's a
+	// common prefix, the slash at the end of it can be the slash at the
+	// start of the suffix.
+	sfx := 0
+	adjust := 0
+	if pfx > 0 {
+		adjust = 1
+	}
+	for i, j := len(src), len(dst); i >= pfx-adjust && j >= pfx-adjust; i, j = i-1, j-1 {
+		var a, b byte
+		if i < len(src) {
+			a = src[i]
+		}
+		if j < len(dst) {
+			b = dst[j]
+		}
+		if a != b {
+			break
+		}
+		if a == '/' {
+			sfx = len(src) - i
+		}
+	}
+
+	srcMid := len(src) - pfx - sfx
+	dstMid := len(dst) - pfx - sfx
+	if srcMid < 0 {
+		srcMid = 0
+	}
+	if dstMid < 0 {
+		dstMid = 0
+	}
+	if pfx+sfx == 0 {
+		return src + " => " + dst
+	}
+	return fmt.Sprintf("%v{%v => %v}%v", src[:pfx], src[pfx:pfx+srcMid], dst[pfx:pfx+dstMid], src[len(src)-sfx:])
+}
+
+// Counts the lines added and removed by each diff.
+func diffStats(c *Client, opts DiffCommonOptions, diffs []HashDiff) ([]fileStat, error) {
+	alg, err := opts.diffAlgorithm(c)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]fileStat, 0, len(diffs))
+	for _, d := range diffs {
+		if d.Src.FileMode == ModeTree || d.Dst.FileMode == ModeTree {
+			continue
+		}
+		s := fileStat{name: d.Name.String(), path: d.Name.String()}
+		if d.SrcName != "" {
+			s.name = renameName(d.SrcName.String(), d.Name.String())
+		}
+		src, dst, err := d.contents(c)
+		if err != nil {
+			return nil, err
+		}
+		if diff.IsBinary(src) || diff.IsBinary(dst) {
+			s.binary = true
+			if !bytes.Equal(src, dst) {
+				s.added, s.deleted = len(dst), len(src)
+			}
+		} else if !bytes.Equal(src, dst) {
+			for _, l := range diff.DiffWhitespace(alg, opts.whitespace(), diff.Lines(src), diff.Lines(dst)) {
+				switch l.Op {
+				case diff.Insert:
+					s.added++
+				case diff.Delete:
+					s.deleted++
+				}
+			}
+			if s.added == 0 && s.deleted == 0 && d.SrcName == "" && d.Src.FileMode == d.Dst.FileMode {
+				// Every change was ignored.
+				continue
+			}
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// Returns the number of digits in n.
+func decimalWidth(n int) int {
+	return len(strconv.Itoa(n))
+}
+
+// Scales n, which is at most max, to fit in width columns, making sure
+// that any change is at least one column.
+func scaleLinear(n, width, max int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/max
+}
+
+// Writes the diffstat for stats to w, sizing the name and graph parts to
+// fit in the width from opts the same way as git does. The graph uses the
+// colours for added and removed lines from colors.
+func writeStat(w io.Writer, opts DiffCommonOptions, colors diffColors, stats []fileStat) {
+	if len(stats) == 0 {
+		return
+	}
+	count := len(stats)
+	if opts.StatCount > 0 && opts.StatCount < count {
+		count = opts.StatCount
+	}
+
+	// Find the longest name and the biggest change of the files that
+	// are shown.
+	maxLen, maxChange, numberWidth, binWidth := 0, 0, 0, 0
+	for _, s := range stats[:count] {
+		if l := utf8.RuneCountInString(s.name); l > maxLen {
+			maxLen = l
+		}
+		if s.binary {
+			// "Bin XXX -> YYY bytes", with the "Bin" aligned with
+			// the number of lines of other files.
+			if bw := 14 + decimalWidth(s.added) + decimalWidth(s.deleted); bw > binWidth {
+				binWidth = bw
+			}
+			numberWidth = 3
+			continue
+		}
+		if change := s.added + s.deleted; change > maxChange {
+			maxChange = change
+		}
+	}
+
+	width := opts.StatWidth
+	if width <= 0 {
+		width = 80
+	}
+	if dw := decimalWidth(maxChange); dw > numberWidth {
+		numberWidth = dw
+	}
+	// Make sure there's room for at least 6 columns of graph and 10 of
+	// the name.
+	if width < 16+6+numberWidth {
+		width = 16 + 6 + numberWidth
+	}
+
+	// Start with the widths that are wanted, and then shrink them to
+	// fit if needed. Each line has " ", " | ", the number and a space
+	// before the graph, and a column is left empty at the end, so there
+	// are numberWidth+6 columns which aren't the name or graph.
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	if opts.StatGraphWidth > 0 && opts.StatGraphWidth < graphWidth {
+		graphWidth = opts.StatGraphWidth
+	}
+	nameWidth := maxLen
+	if opts.StatNameWidth > 0 && opts.StatNameWidth < maxLen {
+		nameWidth = opts.StatNameWidth
+	}
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = width*3/8 - numberWidth - 6
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if opts.StatGraphWidth > 0 && graphWidth > opts.StatGraphWidth {
+			graphWidth = opts.StatGraphWidth
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	for _, s := range stats[:count] {
+		// Names that are too long have the start replaced with
+		// "...", cut at a directory if possible.
+		name, prefix := s.name, ""
+		l := nameWidth
+		if nameLen := utf8.RuneCountInString(name); nameLen > nameWidth {
+			prefix = "..."
+			l -= 3
+			if l < 0 {
+				l = 0
+			}
+			for ; nameLen > l; nameLen-- {
+				_, size := utf8.DecodeRuneInString(name)
+				name = name[size:]
+			}
+			if slash := strings.IndexByte(name, '/'); slash >= 0 {
+				name = name[slash:]
+			}
+		}
+		padding := l - utf8.RuneCountInString(name)
+		if padding < 0 {
+			padding = 0
+		}
+
+		if s.binary {
+			fmt.Fprintf(w, " %v%v%*s | %*s", prefix, name, padding, "", numberWidth, "Bin")
+			if s.added == 0 && s.deleted == 0 {
+				fmt.Fprintln(w)
+			} else {
+				fmt.Fprintf(w, " %v%d%v -> %v%d%v bytes\n", colors.old, s.deleted, colors.reset, colors.new, s.added, colors.reset)
+			}
+			continue
+		}
+
+		add, del := s.added, s.deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(add+del, graphWidth, maxChange)
+			if total < 2 && add > 0 && del > 0 {
+				total = 2
+			}
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+		sep := ""
+		if s.added+s.deleted > 0 {
+			sep = " "
+		}
+		fmt.Fprintf(w, " %v%v%*s | %*d%v%v%v\n",
+			prefix, name, padding, "",
+			numberWidth, s.added+s.deleted, sep,
+			graphPart(colors.new, "+", add, colors.reset), graphPart(colors.old, "-", del, colors.reset),
+		)
+	}
+	if count < len(stats) {
+		fmt.Fprintf(w, " ...\n")
+	}
+	writeShortStat(w, stats)
+}
+
+// Returns n copies of c for the graph of a diffstat in the colour set.
+func graphPart(set, c string, n int, reset string) string {
+	if n == 0 {
+		return ""
+	}
+	return set + strings.Repeat(c, n) + reset
+}
+
+// Writes the total number of files changed, insertions and deletions in
+// stats to w. The sizes of binary files aren't included.
+func writeShortStat(w io.Writer, stats []fileStat) {
+	if len(stats) == 0 {
+		return
+	}
+	adds, dels := 0, 0
+	for _, s := range stats {
+		if !s.binary {
+			adds += s.added
+			dels += s.deleted
+		}
+	}
+	if len(stats) == 1 {
+		fmt.Fprintf(w, " 1 file changed")
+	} else {
+		fmt.Fprintf(w, " %d files changed", len(stats))
+	}
+	if adds > 0 || dels == 0 {
+		if adds == 1 {
+			fmt.Fprintf(w, ", 1 insertion(+)")
+		} else {
+			fmt.Fprintf(w, ", %d insertions(+)", adds)
+		}
+	}
+	if dels > 0 || adds == 0 {
+		if dels == 1 {
+			fmt.Fprintf(w, ", 1 deletion(-)")
+		} else {
+			fmt.Fprintf(w, ", %d deletions(-)", dels)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// Writes the number of lines added and removed from each file in stats
+// to w, or "-" for binary files.
+func writeNumStat(w io.Writer, stats []fileStat) {
+	for _, s := range stats {
+		if s.binary {
+			fmt.Fprintf(w, "-\t-\t%v\n", s.name)
+		} else {
+			fmt.Fprintf(w, "%d\t%d\t%v\n", s.added, s.deleted, s.name)
+		}
+	}
+}
+
+// The parameters for --dirstat.
+type dirStatParams struct {
+	// Count the changed lines or the number of files changed, rather
+	// than the number of bytes.
+	byLine, byFile bool
+
+	// Include the changes in subdirectories in the count for their
+	// parent directory, even if the subdirectory was shown.
+	cumulative bool
+
+	// The minimum percentage, in tenths of a percent, of the changes
+	// for a directory to be shown.
+	permille int
+}
+
+// Parses a comma separated list of --dirstat parameters into p. If any
+// are invalid, the error lists each of them.
+func (p *dirStatParams) parse(params string) error {
+	var errs string
+	for _, param := range strings.Split(params, ",") {
+		switch {
+		case param == "changes":
+			p.byLine, p.byFile = false, false
+		case param == "lines":
+			p.byLine, p.byFile = true, false
+		case param == "files":
+			p.byLine, p.byFile = false, true
+		case param == "noncumulative":
+			p.cumulative = false
+		case param == "cumulative":
+			p.cumulative = true
+		case param != "" && param[0] >= '0' && param[0] <= '9':
+			// Only the first digit after a decimal point is used.
+			end := strings.IndexFunc(param, func(r rune) bool { return r < '0' || r > '9' })
+			if end < 0 {
+				end = len(param)
+			}
+			permille, _ := strconv.Atoi(param[:end])
+			permille *= 10
+			rest := param[end:]
+			if strings.HasPrefix(rest, ".") {
+				rest = rest[1:]
+				if rest != "" && rest[0] >= '0' && rest[0] <= '9' {
+					permille += int(rest[0] - '0')
+					rest = strings.TrimLeft(rest, "0123456789")
+				}
+			}
+			if rest != "" {
+				errs += fmt.Sprintf("  Failed to parse dirstat cut-off percentage '%v'\n", param)
+				continue
+			}
+			p.permille = permille
+		default:
+			errs += fmt.Sprintf("  Unknown dirstat parameter '%v'\n", param)
+		}
+	}
+	if errs != "" {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// Returns the --dirstat parameters to use, from the diff.dirstat config
+// overridden by the DirStatParams in opts.
+func (opts DiffCommonOptions) dirStatParams(c *Client) (dirStatParams, error) {
+	p := dirStatParams{permille: 30}
+	if config := c.GetConfig("diff.dirstat"); config != "" {
+		if err := p.parse(config); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: Found errors in 'diff.dirstat' config variable:\n%v", err)
+		}
+	}
+	if opts.DirStatParams != "" {
+		if err := p.parse(opts.DirStatParams); err != nil {
+			return p, fmt.Errorf("Failed to parse --dirstat/-X option parameter:\n%v", err)
+		}
+	}
+	return p, nil
+}
+
+// The amount of a diff, in whatever unit --dirstat is counting, that was
+// in a file.
+type dirStatFile struct {
+	name    string
+	changed uint64
+}
+
+// Returns the amount of each diff for --dirstat, by the number of bytes
+// changed in the file, or by the number of files if byFile is set.
+func dirStatChanges(c *Client, diffs []HashDiff, byFile bool) ([]dirStatFile, error) {
+	var files []dirStatFile
+	for _, d := range diffs {
+		if d.Src.FileMode == ModeTree || d.Dst.FileMode == ModeTree {
+			continue
+		}
+		var damage uint64
+		switch {
+		case d.Src.Sha1 != (Sha1{}) && d.Src.Sha1 == d.Dst.Sha1:
+			// The content didn't change, so there's no need to
+			// look at it.
+		case byFile:
+			damage = 1
+		default:
+			src, dst, err := d.contents(c)
+			if err != nil {
+				return nil, err
+			}
+			// Both the removed material and the added material
+			// are damage to the original.
+			switch {
+			case d.Src.FileMode != 0 && d.Dst.FileMode != 0:
+				copied, added := countChanges(hashSpans(src), hashSpans(dst))
+				damage = uint64(len(src)) - copied + added
+			case d.Src.FileMode != 0:
+				damage = uint64(len(src))
+			default:
+				damage = uint64(len(dst))
+			}
+			// The hash changed, so something must have changed.
+			if damage == 0 {
+				damage = 1
+			}
+		}
+		files = append(files, dirStatFile{d.Name.String(), damage})
+	}
+	return files, nil
+}
+
+// Returns the amount of each diff for --dirstat=lines, which is the
+// number of lines changed. Binary files are assumed to have 64 bytes per
+// line.
+func dirStatLines(stats []fileStat) []dirStatFile {
+	var files []dirStatFile
+	for _, s := range stats {
+		damage := uint64(s.added + s.deleted)
+		if s.binary {
+			damage = (damage + 63) / 64
+		}
+		files = append(files, dirStatFile{s.path, damage})
+	}
+	return files
+}
+
+// Writes the percentage of the changes in files which were in each
+// directory to w.
+func writeDirStat(w io.Writer, p dirStatParams, files []dirStatFile) {
+	var changed uint64
+	for _, f := range files {
+		changed += f.changed
+	}
+	// This can happen even with files, if everything was renamed.
+	if changed == 0 {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	gatherDirStat(w, p, &files, changed, "")
+}
+
+// Adds up the changes in files which are in the directory base,
+// recursing into subdirectories, and writes the percentage for base if
+// it's at least the cut-off. files is sorted by name, and the files in
+// base are removed from the start of it.
+//
+// The top level isn't shown, and neither are directories where all of
+// the changes were in a single subdirectory.
+func gatherDirStat(w io.Writer, p dirStatParams, files *[]dirStatFile, changed uint64, base string) uint64 {
+	var sum uint64
+	sources := 0
+	for len(*files) > 0 {
+		f := (*files)[0]
+		if !strings.HasPrefix(f.name, base) {
+			break
+		}
+		if slash := strings.IndexByte(f.name[len(base):], '/'); slash >= 0 {
+			sum += gatherDirStat(w, p, files, changed, f.name[:len(base)+slash+1])
+			sources++
+		} else {
+			sum += f.changed
+			*files = (*files)[1:]
+			sources += 2
+		}
+	}
+	if base != "" && sources != 1 && sum > 0 {
+		permille := int(sum * 1000 / changed)
+		if permille >= p.permille {
+			fmt.Fprintf(w, "%4d.%01d%% %v\n", permille/10, permille%10, base)
+			if !p.cumulative {
+				return 0
+			}
+		}
+	}
+	return sum
+}
+
+// Writes a summary of files which were created, deleted, renamed, copied
+// or had their mode changed in diffs to w. Returns false if there was
+// nothing to write.
+func writeSummary(w io.Writer, diffs []HashDiff) bool {
+	written := false
+	for _, d := range diffs {
+		if d.Src.FileMode == ModeTree || d.Dst.FileMode == ModeTree {
+			continue
+		}
+		modeChanged := d.Src.FileMode != 0 && d.Dst.FileMode != 0 && d.Src.FileMode != d.Dst.FileMode
+		switch d.Status() {
+		case "A":
+			fmt.Fprintf(w, " create mode %06o %v\n", d.Dst.FileMode, d.Name)
+		case "D":
+			fmt.Fprintf(w, " delete mode %06o %v\n", d.Src.FileMode, d.Name)
+		default:
+			if d.SrcName != "" {
+				verb := "rename"
+				if d.Copied {
+					verb = "copy"
+				}
+				fmt.Fprintf(w, " %v %v (%d%%)\n", verb, renameName(d.SrcName.String(), d.Name.String()), d.Similarity)
+				if modeChanged {
+					fmt.Fprintf(w, " mode change %06o => %06o\n", d.Src.FileMode, d.Dst.FileMode)
+				}
+			} else if modeChanged {
+				fmt.Fprintf(w, " mode change %06o => %06o %v\n", d.Src.FileMode, d.Dst.FileMode, d.Name)
+			} else {
+				continue
+			}
+		}
+		written = true
+	}
+	return written
+}
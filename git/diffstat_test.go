@@ -0,0 +1,132 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRenameName(t *testing.T) {
+	tests := []struct {
+		src, dst, want string
+	}{
+		{"f", "g", "f => g"},
+		{"docs/readme", "docs/README", "docs/{readme => README}"},
+		{"a/x/f", "b/x/f", "{a => b}/x/f"},
+		{"s/f", "s/b/f", "s/{ => b}/f"},
+	}
+	for _, tc := range tests {
+		if got := renameName(tc.src, tc.dst); got != tc.want {
+			t.Errorf("%v => %v: got %q want %q", tc.src, tc.dst, got, tc.want)
+		}
+	}
+}
+
+func TestDiffStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitdiffstat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := func(files ...string) TreeID {
+		t.Helper()
+		var listing bytes.Buffer
+		for i := 0; i < len(files); i += 2 {
+			blob, err := c.WriteObject("blob", []byte(files[i+1]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&listing, "100644 blob %v\t%v\n", blob, files[i])
+		}
+		tid, err := MkTree(c, MkTreeOptions{}, &listing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tid
+	}
+	seq := func(from, to int) string {
+		var s string
+		for i := from; i <= to; i++ {
+			s += fmt.Sprintf("%d\n", i)
+		}
+		return s
+	}
+	from := tree("bin", "x\000y", "dir/a.txt", seq(1, 10), "dir/sub/b.txt", seq(1, 5), "gone", "g\n")
+	to := tree("bin", "x\000yz", "dir/a.txt", seq(1, 8)+"new\nlines\n", "dir/sub/b.txt", seq(1, 5), "dir/sub/c.txt", seq(1, 3))
+	diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true}, from, to, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts DiffCommonOptions
+		want string
+	}{
+		{
+			"stat",
+			DiffCommonOptions{Stat: true},
+			` bin           | Bin 3 -> 4 bytes
+ dir/a.txt     |   4 ++--
+ dir/sub/c.txt |   3 +++
+ gone          |   1 -
+ 4 files changed, 5 insertions(+), 3 deletions(-)
+`,
+		},
+		{
+			"stat count",
+			DiffCommonOptions{Stat: true, StatWidth: 30, StatCount: 2},
+			` bin       | Bin 3 -> 4 bytes
+ dir/a.txt |   4 ++--
+ ...
+ 4 files changed, 5 insertions(+), 3 deletions(-)
+`,
+		},
+		{
+			"numstat",
+			DiffCommonOptions{NumStat: true},
+			"-\t-\tbin\n2\t2\tdir/a.txt\n3\t0\tdir/sub/c.txt\n0\t1\tgone\n",
+		},
+		{
+			"shortstat",
+			DiffCommonOptions{ShortStat: true},
+			" 4 files changed, 5 insertions(+), 3 deletions(-)\n",
+		},
+		{
+			"summary",
+			DiffCommonOptions{Summary: true},
+			" create mode 100644 dir/sub/c.txt\n delete mode 100644 gone\n",
+		},
+		{
+			"dirstat",
+			DiffCommonOptions{DirStat: true, DirStatParams: "0"},
+			"  23.0% dir/sub/\n  57.6% dir/\n",
+		},
+		{
+			"dirstat cumulative",
+			DiffCommonOptions{DirStat: true, DirStatParams: "files,cumulative,30"},
+			"  50.0% dir/\n",
+		},
+	}
+	for _, tc := range tests {
+		var out bytes.Buffer
+		if err := GeneratePatch(c, tc.opts, diffs, &out); err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if got := out.String(); got != tc.want {
+			t.Errorf("%v: got\n%v\nwant\n%v", tc.name, got, tc.want)
+		}
+	}
+
+	if err := GeneratePatch(c, DiffCommonOptions{DirStat: true, DirStatParams: "bogus"}, diffs, ioutil.Discard); err == nil {
+		t.Errorf("Expected an error for an invalid dirstat parameter")
+	}
+}
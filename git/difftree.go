@@ -1,59 +1,24 @@
 package git
 
 import (
-	"regexp"
 	"sort"
+	"strings"
 )
 
 // Describes the options that may be specified on the command line for
-// "git diff-index". Note that only raw mode is currently supported, even
-// though all the other options are parsed/set in this struct.
+// "git diff-tree". Note that only the output formats in DiffCommonOptions
+// are currently supported, even though all the other options are parsed/set
+// in this struct.
 type DiffTreeOptions struct {
-	Patch bool
-
-	// The 0 value implies 3.
-	NumContextLines int
-
-	Raw bool
+	DiffCommonOptions
 
 	// Unimplemented. Probably never will be.
 	CompactionHeuristic bool
 
-	// Can be "default", "myers", "minimal", "patience", or "histogram"
-	DiffAlgorithm string
-
-	StatWidth, StatNameWidth, StatCount int
-	NumStat                             bool
-	ShortStat                           bool
-
-	DirStat string
-
-	Summary bool
-
 	NullTerminate bool
 
-	NameOnly, NameStatus bool
-
 	Submodule string
 
-	// Colour can have three states: "always" (true), "never" (false), or "auto" (nil)
-	Color *bool
-
-	// "color", "plain", "porcelain", or "none"
-	WordDiff string
-
-	WordDiffRegex *regexp.Regexp
-
-	NoRenames bool
-
-	// Warn if changes introduce conflict markers or whitespace errors.
-	Check bool
-
-	// Valid options in the []string are "old", "new", or "context"
-	WhitespaceErrorHighlight []string
-
-	FullIndex, Binary bool
-
 	// Number of characters to abbreviate the hexadecimal object name to.
 	Abbrev int
 
@@ -73,20 +38,35 @@ func DiffTree(c *Client, opt *DiffTreeOptions, tree1, tree2 Treeish, paths []str
 		return nil, err
 	}
 
-	tree1Objects, err := t1.GetAllObjects(c, "", opt.Recurse, opt.Recurse)
+	var pathspecs []IndexPath
+	for _, p := range paths {
+		pathspecs = append(pathspecs, cleanPathspec(IndexPath(p)))
+	}
+	tree1Objects, err := treeObjectsAt(c, t1, pathspecs, opt.Recurse)
 	if err != nil {
 		return nil, err
 	}
-	tree2Objects, err := t2.GetAllObjects(c, "", opt.Recurse, opt.Recurse)
+	tree2Objects, err := treeObjectsAt(c, t2, pathspecs, opt.Recurse)
 	if err != nil {
 		return nil, err
 	}
+	if opt.Recurse {
+		// When recursing, only the leaves are compared, not the
+		// subtrees themselves.
+		for _, objects := range []map[IndexPath]TreeEntry{tree1Objects, tree2Objects} {
+			for name, entry := range objects {
+				if entry.FileMode == ModeTree {
+					delete(objects, name)
+				}
+			}
+		}
+	}
 
 	var val []HashDiff
 
 	for name, sha := range tree1Objects {
 		if osha := tree2Objects[name]; sha != osha {
-			val = append(val, HashDiff{name, sha, osha, 0, 0})
+			val = append(val, HashDiff{Name: name, Src: sha, Dst: osha})
 		}
 	}
 
@@ -94,11 +74,49 @@ func DiffTree(c *Client, opt *DiffTreeOptions, tree1, tree2 Treeish, paths []str
 	// would have gotten caught by the above ranging.
 	for name, sha := range tree2Objects {
 		if _, ok := tree1Objects[name]; !ok {
-			val = append(val, HashDiff{name, TreeEntry{Sha1{}, 0}, sha, 0, 0})
+			val = append(val, HashDiff{Name: name, Dst: sha})
 		}
 	}
 
 	sort.Sort(ByName(val))
 
+	return detectRenames(c, opt.DiffCommonOptions, val, tree1Objects)
+}
+
+// Returns the objects in t at or under paths, keyed by their path from the
+// root of t. Only the subtrees containing the paths are read. If paths is
+// empty, every object in t is returned.
+func treeObjectsAt(c *Client, t TreeID, paths []IndexPath, recurse bool) (map[IndexPath]TreeEntry, error) {
+	if len(paths) == 0 {
+		return t.GetAllObjects(c, "", recurse, recurse)
+	}
+	val := make(map[IndexPath]TreeEntry)
+	for _, path := range paths {
+		if path == "" {
+			return t.GetAllObjects(c, "", recurse, recurse)
+		}
+		if !recurse {
+			// Without recursing, the top level entry containing
+			// the path is compared.
+			path = IndexPath(strings.SplitN(string(path), "/", 2)[0])
+		}
+		entry, err := treeEntryAt(c, t, path)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Sha1 == (Sha1{}) {
+			continue
+		}
+		val[path] = entry
+		if entry.FileMode == ModeTree && recurse {
+			children, err := TreeID(entry.Sha1).GetAllObjects(c, "", true, true)
+			if err != nil {
+				return nil, err
+			}
+			for name, child := range children {
+				val[path+"/"+name] = child
+			}
+		}
+	}
 	return val, nil
 }
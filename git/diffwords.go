@@ -0,0 +1,237 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/driusan/dgit/diff"
+)
+
+// How one type of text (removed, added or unchanged) is shown in a word
+// diff.
+type wordStyle struct {
+	color, prefix, suffix string
+}
+
+// A wordDiffer accumulates the removed and added lines of a change in a
+// patch, and writes them as a word diff when the change is complete. This
+// is the same algorithm as git's: the words of the removed and added text
+// are diffed as if each word was a line, and the unchanged text between
+// the changed words is shown from the added text.
+type wordDiffer struct {
+	w     io.Writer
+	regex *regexp.Regexp
+
+	// The mode of the word diff, which is "plain", "color" or
+	// "porcelain".
+	mode string
+
+	old, new, context wordStyle
+	newline           string
+
+	// The colours of unchanged lines outside of the changes.
+	contextColor, reset string
+
+	minus, plus strings.Builder
+}
+
+func newWordDiffer(w io.Writer, opts DiffCommonOptions, colors diffColors) *wordDiffer {
+	wd := &wordDiffer{
+		w:            w,
+		regex:        opts.WordDiffRegex,
+		mode:         opts.WordDiff,
+		newline:      "\n",
+		contextColor: colors.context,
+		reset:        colors.reset,
+	}
+	switch wd.mode {
+	case "porcelain":
+		wd.new = wordStyle{prefix: "+", suffix: "\n"}
+		wd.old = wordStyle{prefix: "-", suffix: "\n"}
+		wd.context = wordStyle{prefix: " ", suffix: "\n"}
+		wd.newline = "~\n"
+	case "plain":
+		wd.new = wordStyle{prefix: "{+", suffix: "+}"}
+		wd.old = wordStyle{prefix: "[-", suffix: "-]"}
+	}
+	wd.new.color = colors.new
+	wd.old.color = colors.old
+	wd.context.color = colors.context
+	return wd
+}
+
+// Adds a line of a hunk to the word diff. Unchanged lines are written
+// immediately, after any change before them.
+func (wd *wordDiffer) add(l diff.Line) {
+	text := l.Text
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	switch l.Op {
+	case diff.Delete:
+		wd.minus.WriteString(text)
+	case diff.Insert:
+		wd.plus.WriteString(text)
+	default:
+		wd.flush()
+		switch {
+		case wd.mode == "porcelain":
+			fmt.Fprintf(wd.w, "%v %v%v\n~\n", wd.contextColor, strings.TrimSuffix(text, "\n"), wd.reset)
+		case text == "\n":
+			// Empty lines aren't coloured.
+			fmt.Fprintln(wd.w)
+		default:
+			fmt.Fprintf(wd.w, "%v%v%v\n", wd.contextColor, strings.TrimSuffix(text, "\n"), wd.reset)
+		}
+	}
+}
+
+// Writes text in the style st. Each line of the text is styled separately,
+// and the newlines between them are replaced by the newline of the mode.
+func (wd *wordDiffer) write(st wordStyle, text string) {
+	for text != "" {
+		i := strings.IndexByte(text, '\n')
+		line := text
+		if i >= 0 {
+			line = text[:i]
+		}
+		if line != "" {
+			reset := ""
+			if st.color != "" {
+				reset = colorReset
+			}
+			fmt.Fprintf(wd.w, "%v%v%v%v%v", st.color, st.prefix, line, st.suffix, reset)
+		}
+		if i < 0 {
+			return
+		}
+		fmt.Fprint(wd.w, wd.newline)
+		text = text[i+1:]
+	}
+}
+
+// A word in the text being diffed, text[start:end].
+type word struct {
+	start, end int
+}
+
+// Splits text into words, which are either the matches of the regex, or
+// runs of non-whitespace if regex is nil. Words never include a newline.
+func splitWords(text string, regex *regexp.Regexp) []word {
+	var words []word
+	isSpace := func(b byte) bool {
+		return strings.IndexByte(" \t\n\v\f\r", b) >= 0
+	}
+	for i := 0; i < len(text); {
+		if regex != nil {
+			loc := regex.FindStringIndex(text[i:])
+			if loc == nil {
+				break
+			}
+			start, end := i+loc[0], i+loc[1]
+			if nl := strings.IndexByte(text[start:end], '\n'); nl >= 0 {
+				end = start + nl
+			}
+			if start == end {
+				i = start + 1
+				continue
+			}
+			words = append(words, word{start, end})
+			i = end
+			continue
+		}
+		for i < len(text) && isSpace(text[i]) {
+			i++
+		}
+		if i == len(text) {
+			break
+		}
+		start := i
+		for i < len(text) && !isSpace(text[i]) {
+			i++
+		}
+		words = append(words, word{start, i})
+	}
+	return words
+}
+
+// Writes the word diff of the lines added since the last flush.
+func (wd *wordDiffer) flush() {
+	minus, plus := wd.minus.String(), wd.plus.String()
+	wd.minus.Reset()
+	wd.plus.Reset()
+	if minus == "" && plus == "" {
+		return
+	}
+	if plus == "" {
+		wd.write(wd.old, minus)
+		return
+	}
+
+	minusWords, plusWords := splitWords(minus, wd.regex), splitWords(plus, wd.regex)
+	texts := func(text string, words []word) []string {
+		s := make([]string, len(words))
+		for i, w := range words {
+			s[i] = text[w.start:w.end]
+		}
+		return s
+	}
+
+	// The position in plus which has been written, and the number of
+	// words in minus and plus before the current line of the script.
+	written := 0
+	mi, pi := 0, 0
+
+	// The words which were removed and added by the current change.
+	var ml, pl int
+	changeEnd := func() {
+		if ml == 0 && pl == 0 {
+			return
+		}
+		// Changes with no words are at the end of the word before
+		// them.
+		bounds := func(words []word, i, n int) (int, int) {
+			switch {
+			case n > 0:
+				return words[i].start, words[i+n-1].end
+			case i > 0:
+				return words[i-1].end, words[i-1].end
+			default:
+				return 0, 0
+			}
+		}
+		minusStart, minusEnd := bounds(minusWords, mi-ml, ml)
+		plusStart, plusEnd := bounds(plusWords, pi-pl, pl)
+		if written != plusStart {
+			wd.write(wd.context, plus[written:plusStart])
+		}
+		if minusStart != minusEnd {
+			wd.write(wd.old, minus[minusStart:minusEnd])
+		}
+		if plusStart != plusEnd {
+			wd.write(wd.new, plus[plusStart:plusEnd])
+		}
+		written = plusEnd
+		ml, pl = 0, 0
+	}
+	for _, l := range diff.Diff(diff.Myers, texts(minus, minusWords), texts(plus, plusWords)) {
+		switch l.Op {
+		case diff.Delete:
+			mi++
+			ml++
+		case diff.Insert:
+			pi++
+			pl++
+		default:
+			changeEnd()
+			mi++
+			pi++
+		}
+	}
+	changeEnd()
+	if written != len(plus) {
+		wd.write(wd.context, plus[written:])
+	}
+}
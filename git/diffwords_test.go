@@ -0,0 +1,137 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWordDiffAndCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitdiffwords")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := func(files ...string) TreeID {
+		t.Helper()
+		var listing bytes.Buffer
+		for i := 0; i < len(files); i += 2 {
+			blob, err := c.WriteObject("blob", []byte(files[i+1]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&listing, "100644 blob %v\t%v\n", blob, files[i])
+		}
+		tid, err := MkTree(c, MkTreeOptions{}, &listing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tid
+	}
+	from := tree(
+		"a.txt", "the quick brown fox\njumps over\nthe lazy dog\n",
+		"ws.txt", "x\n",
+	)
+	to := tree(
+		"a.txt", "the slow brown fox\njumps over\nthe lazy cat\n",
+		"ws.txt", "x \n \tindent\n<<<<<<< ours\n\n",
+	)
+	diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true}, from, to, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	on := true
+
+	tests := []struct {
+		name    string
+		opts    DiffCommonOptions
+		want    string
+		wantErr error
+	}{
+		{
+			"plain",
+			DiffCommonOptions{Patch: true, NumContextLines: 3, WordDiff: "plain"},
+			`diff --git a/a.txt b/a.txt
+index d557117..e90d0af 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+the [-quick-]{+slow+} brown fox
+jumps over
+the lazy [-dog-]{+cat+}
+`,
+			nil,
+		},
+		{
+			"porcelain",
+			DiffCommonOptions{Patch: true, NumContextLines: 3, WordDiff: "porcelain"},
+			`diff --git a/a.txt b/a.txt
+index d557117..e90d0af 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+ the 
+-quick
++slow
+  brown fox
+~
+ jumps over
+~
+ the lazy 
+-dog
++cat
+~
+`,
+			nil,
+		},
+		{
+			"check",
+			DiffCommonOptions{Check: true},
+			`ws.txt:1: trailing whitespace.
++x 
+ws.txt:2: space before tab in indent.
++ 	indent
+ws.txt:3: leftover conflict marker
+ws.txt:4: new blank line at EOF.
+`,
+			ErrCheckFailed,
+		},
+		{
+			"color",
+			DiffCommonOptions{Patch: true, NumContextLines: 3, Color: &on},
+			"\033[1mdiff --git a/ws.txt b/ws.txt\033[m\n" +
+				"\033[1mindex 587be6b..45d721d 100644\033[m\n" +
+				"\033[1m--- a/ws.txt\033[m\n" +
+				"\033[1m+++ b/ws.txt\033[m\n" +
+				"\033[36m@@ -1 +1,4 @@\033[m\n" +
+				"\033[31m-x\033[m\n" +
+				"\033[32m+\033[m\033[32mx\033[m\033[41m \033[m\n" +
+				"\033[32m+\033[m\033[41m \033[m\t\033[32mindent\033[m\n" +
+				"\033[32m+\033[m\033[32m<<<<<<< ours\033[m\n" +
+				"\033[41m+\033[m\n",
+			nil,
+		},
+	}
+	for _, tc := range tests {
+		ds := diffs
+		if tc.name == "color" {
+			ds = diffs[1:]
+		} else if tc.name != "check" {
+			ds = diffs[:1]
+		}
+		var out bytes.Buffer
+		if err := GeneratePatch(c, tc.opts, ds, &out); err != tc.wantErr {
+			t.Errorf("%v: got error %v want %v", tc.name, err, tc.wantErr)
+		}
+		if got := out.String(); got != tc.want {
+			t.Errorf("%v: got\n%q\nwant\n%q", tc.name, got, tc.want)
+		}
+	}
+}
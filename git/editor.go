@@ -23,3 +23,15 @@ func (c *Client) ExecEditor(f File) error {
 
 	return cmd.Run()
 }
+
+// Runs command with the shell in the top level of the work tree, as the
+// exec command of an interactive rebase does.
+func (c *Client) execShell(command string) error {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Dir = c.WorkDir.String()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
@@ -48,3 +48,9 @@ func ModeFromString(s string) (EntryMode, error) {
 		return 0, fmt.Errorf("Unknown mode: %v", s)
 	}
 }
+
+// IsRegular returns true if the entry mode is for a regular (possibly
+// executable) file, whose content can be merged.
+func (e EntryMode) IsRegular() bool {
+	return e == ModeBlob || e == ModeExec
+}
@@ -19,10 +19,8 @@ func Fetch(c *Client, opts FetchOptions, rmt Remote, refs []RefSpec) error {
 	// If none were provided then we check to see if there are any
 	//  configured refspecs for this remote
 	if refs == nil {
-		// FIXME this only handles one value for the configuration while there can be many with different refspecs for this operation
-		cfg := c.GetConfig(fmt.Sprintf("remote.%s.fetch", rmt))
-		if cfg != "" {
-			refs = []RefSpec{RefSpec(cfg)}
+		for _, cfg := range c.GetConfigAll(fmt.Sprintf("remote.%s.fetch", rmt)) {
+			refs = append(refs, RefSpec(cfg))
 		}
 	}
 
@@ -1,32 +1,20 @@
 package git
 
-import (
-	"os"
-	"path/filepath"
-	"strings"
-)
-
 // Calls callback for each ref under c's GitDir which has prefix as a prefix.
+// Both loose and packed refs are included, sorted by name.
 func ForEachRefCallback(c *Client, prefix string, callback func(*Client, Ref) error) error {
-	// FIXME: Include packed refs.
-	err := filepath.Walk(
-		c.GitDir.File("refs").String(),
-		func(path string, info os.FileInfo, err error) error {
-			if info.IsDir() {
-				return nil
-			}
-			refname := strings.TrimPrefix(path, c.GitDir.String()+"/")
-			if strings.HasPrefix(refname, prefix) {
-				r, err := parseRef(c, refname)
-				if err != nil {
-					return err
-				}
-				if err := callback(c, r); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-	)
-	return err
+	names, err := c.refNames(prefix)
+	if err != nil {
+		return err
+	}
+	for _, refname := range names {
+		r, err := parseRef(c, refname)
+		if err != nil {
+			return err
+		}
+		if err := callback(c, r); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,198 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GcOptions are the options that may be passed to Gc.
+type GcOptions struct {
+	// Spend more time looking for deltas, and don't reuse the
+	// existing ones.
+	Aggressive bool
+
+	// Only run if there are enough loose objects or packs to make it
+	// worthwhile, according to gc.auto and gc.autoPackLimit.
+	Auto bool
+
+	Quiet bool
+
+	// Prune unreachable loose objects older than this. If empty,
+	// gc.pruneExpire is used, or 2 weeks if it's not set.
+	Prune string
+
+	// Don't prune any unreachable objects.
+	NoPrune bool
+}
+
+// Parses an expiry date such as "now", "2.weeks.ago" or an absolute
+// date. Anything not after the returned time has expired. For "never"
+// the zero time is returned, which nothing is before.
+func parseExpiry(s string) (time.Time, error) {
+	switch s {
+	case "never", "false":
+		return time.Time{}, nil
+	case "now", "all":
+		return time.Now(), nil
+	}
+
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == '.' || r == ' ' })
+	if len(fields) == 3 && fields[2] == "ago" {
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid expiry date: %v", s)
+		}
+		now := time.Now()
+		switch strings.TrimSuffix(fields[1], "s") {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
+		return time.Time{}, fmt.Errorf("Invalid expiry date: %v", s)
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	// ISO 8601 dates with a time zone, which is either Z or an offset.
+	for _, layout := range []string{
+		"2006-01-02T15:04:05Z07:00", "2006-01-02T15:04:05Z0700",
+		"2006-01-02 15:04:05Z07:00", "2006-01-02 15:04:05 Z07:00", "2006-01-02 15:04:05 -0700",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := parseDate(s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid expiry date: %v", s)
+}
+
+// Returns an integer config variable, or def if it's not set or invalid.
+func intConfig(c *Client, name string, def int) int {
+	if v, err := strconv.Atoi(c.GetConfig(name)); err == nil {
+		return v
+	}
+	return def
+}
+
+// Returns true if there are enough loose objects or packs that gc --auto
+// should do something.
+func needsGc(c *Client) (bool, error) {
+	limit := intConfig(c, "gc.auto", 6700)
+	if limit <= 0 {
+		return false, nil
+	}
+
+	// Like git, estimate the number of loose objects from a single
+	// fan out directory.
+	files, err := ioutil.ReadDir(c.GitDir.File("objects/17").String())
+	if err == nil {
+		n := 0
+		for _, f := range files {
+			if len(f.Name()) == 38 {
+				n++
+			}
+		}
+		if n > (limit+255)/256 {
+			return true, nil
+		}
+	}
+
+	packlimit := intConfig(c, "gc.autopacklimit", 50)
+	if packlimit <= 0 {
+		return false, nil
+	}
+	packs, err := c.packfiles()
+	if err != nil {
+		return false, err
+	}
+	n := 0
+	for _, pack := range packs {
+		if !File(pack.String() + ".keep").Exists() {
+			n++
+		}
+	}
+	return n > packlimit, nil
+}
+
+// Gc implements "git gc". It packs refs, expires old reflog entries,
+// repacks all reachable objects into a single pack and prunes unreachable
+// loose objects.
+func Gc(c *Client, opts GcOptions) error {
+	if opts.Auto {
+		need, err := needsGc(c)
+		if err != nil || !need {
+			return err
+		}
+		if !opts.Quiet {
+			fmt.Println("Auto packing the repository for optimum performance.")
+		}
+	}
+
+	prune := opts.Prune
+	if prune == "" {
+		prune = c.GetConfig("gc.pruneexpire")
+		if prune == "" {
+			prune = "2.weeks.ago"
+		}
+	}
+	if opts.NoPrune {
+		prune = "never"
+	}
+	if _, err := parseExpiry(prune); err != nil {
+		return err
+	}
+
+	if c.GetConfig("gc.packrefs") != "false" {
+		if err := PackRefs(c, PackRefsOptions{All: true, Prune: true}); err != nil {
+			return err
+		}
+	}
+	if err := ReflogExpire(c, ReflogExpireOptions{All: true}, nil); err != nil {
+		return err
+	}
+
+	repack := RepackOptions{
+		Delete: true,
+		Quiet:  opts.Quiet,
+		Window: intConfig(c, "pack.window", 10),
+		Depth:  intConfig(c, "pack.depth", 50),
+	}
+	if opts.Aggressive {
+		repack.Window = intConfig(c, "gc.aggressivewindow", 250)
+		repack.Depth = intConfig(c, "gc.aggressivedepth", 50)
+		repack.NoReuseDelta = true
+	}
+	if prune == "now" {
+		// Unreachable objects would be pruned as soon as they're
+		// loosened, so don't bother.
+		repack.All = true
+	} else {
+		repack.LoosenUnreachable = true
+	}
+	if err := Repack(c, repack); err != nil {
+		return err
+	}
+
+	if prune == "never" || prune == "false" {
+		return nil
+	}
+	return Prune(c, PruneOptions{Expire: prune}, nil)
+}
@@ -0,0 +1,132 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		expiry string
+		want   time.Time
+	}{
+		{"never", time.Time{}},
+		{"2.weeks.ago", now.AddDate(0, 0, -14)},
+		{"90.days.ago", now.AddDate(0, 0, -90)},
+		{"1 hour ago", now.Add(-time.Hour)},
+		{"now", now},
+	}
+	for _, tc := range tests {
+		got, err := parseExpiry(tc.expiry)
+		if err != nil {
+			t.Errorf("%v: %v", tc.expiry, err)
+			continue
+		}
+		if d := got.Sub(tc.want); d > time.Minute || d < -time.Minute {
+			t.Errorf("%v: got %v want %v", tc.expiry, got, tc.want)
+		}
+	}
+	for _, expiry := range []string{"2020-01-01T00:00:05Z", "2020-01-01T01:00:05+01:00", "2020-01-01T01:00:05+0100", "2020-01-01 00:00:05 +0000"} {
+		got, err := parseExpiry(expiry)
+		if err != nil {
+			t.Errorf("%v: %v", expiry, err)
+			continue
+		}
+		if want := time.Date(2020, 1, 1, 0, 0, 5, 0, time.UTC); !got.Equal(want) {
+			t.Errorf("%v: got %v want %v", expiry, got, want)
+		}
+	}
+	if _, err := parseExpiry("3.fortnights.ago"); err == nil {
+		t.Error("Expected an error for an invalid unit")
+	}
+}
+
+func TestRepackAndPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Commit(c, CommitOptions{}, "first", nil); err != nil {
+		t.Fatal(err)
+	}
+	dangling, err := c.WriteObject("blob", []byte("nobody refers to me\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Repack(c, RepackOptions{All: true, Delete: true, Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	packs, err := c.packfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packs) != 1 {
+		t.Fatalf("Unexpected number of packs: got %v want 1", len(packs))
+	}
+	loose, err := looseObjects(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loose) != 1 || loose[0].Sha1 != dangling {
+		t.Errorf("Unexpected loose objects after repack: %v", loose)
+	}
+	// Everything that was reachable should still be readable.
+	if _, err := c.GetHeadCommit(); err != nil {
+		t.Error(err)
+	}
+	if _, err := RevList(c, RevListOptions{Quiet: true, Objects: true}, nil, []Commitish{RefSpec("refs/heads/master")}, nil); err != nil {
+		t.Error(err)
+	}
+
+	// A kept pack is not repacked or removed.
+	if err := ioutil.WriteFile(packs[0].String()+".keep", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Repack(c, RepackOptions{All: true, Delete: true, Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.packfiles(); err != nil || len(got) != 1 || got[0] != packs[0] {
+		t.Errorf("Kept pack was not kept: got %v (%v)", got, err)
+	}
+
+	// The dangling object is too new to be pruned with an expiry...
+	if err := Prune(c, PruneOptions{Expire: "2.weeks.ago"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if have, _, _ := c.HaveObject(dangling); !have {
+		t.Error("Object was pruned before it expired")
+	}
+
+	// ...but is once it's old enough.
+	old := time.Now().AddDate(0, 0, -15)
+	path := filepath.Join(c.GetObjectsDir().String(), dangling.String()[:2], dangling.String()[2:])
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := Prune(c, PruneOptions{Expire: "2.weeks.ago"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expired object was not pruned")
+	}
+}
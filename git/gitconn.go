@@ -27,13 +27,20 @@ func (g *gitConn) OpenConn() error {
 	g.conn = conn
 	g.packProtocolReader = &packProtocolReader{g.conn, PktLineMode, nil, nil}
 
-	// Advertise the connection and try to negotiate protocol version 2
-	fmt.Fprintf(
-		g,
-		"git-upload-pack %s\x00host=%s\x00\x00version=2\x00",
-		g.uri.Path,
-		host,
-	)
+	if g.service == receivePackService {
+		// receive-pack doesn't support protocol version 2, so don't
+		// ask for it.
+		fmt.Fprintf(g, "%s %s\x00host=%s\x00", g.service, g.uri.Path, host)
+	} else {
+		// Advertise the connection and try to negotiate protocol version 2
+		fmt.Fprintf(
+			g,
+			"%s %s\x00host=%s\x00\x00version=2\x00",
+			g.service,
+			g.uri.Path,
+			host,
+		)
+	}
 
 	v, cap, refs, err := parseRemoteInitialConnection(conn, false)
 	if err != nil {
@@ -89,6 +96,15 @@ func (g gitConn) SetUploadPack(up string) error {
 	return nil
 }
 
+func (g gitConn) SetReceivePack(rp string) error {
+	// not applicable for git protocol
+	return nil
+}
+
+func (g *gitConn) RawWriter() io.Writer {
+	return g.conn
+}
+
 func (g *gitConn) Write(data []byte) (int, error) {
 	l, err := PktLineEncodeNoNl(data)
 	if err != nil {
@@ -1,12 +1,14 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
-	"strconv"
+	"strings"
+
+	"github.com/driusan/dgit/diff"
 )
 
 // A HashDiff represents a single line in a git diff-index type output.
@@ -14,80 +16,339 @@ type HashDiff struct {
 	Name             IndexPath
 	Src, Dst         TreeEntry
 	SrcSize, DstSize uint
-}
 
-func (h HashDiff) String() string {
-	var status string = "?"
+	// If the diff is a rename or copy, the name of the source file and
+	// the percentage of the destination which is the same as it.
+	SrcName    IndexPath
+	Similarity int
+
+	// Set if the source still exists, so the diff is a copy rather than
+	// a rename.
+	Copied bool
+}
 
+// Returns the status letter for the diff, as used by --name-status and
+// the raw diff format. Renames and copies have the similarity appended.
+func (h HashDiff) Status() string {
 	empty := Sha1{}
-	if h.Src.Sha1 == empty && h.Dst.Sha1 != empty {
-		status = "A"
-	} else if h.Src.Sha1 != empty && h.Dst.Sha1 == empty {
-		if h.Dst.FileMode == 0 {
-			status = "D"
-		} else {
-			status = "M"
-		}
-	} else {
-		status = "M"
+	switch {
+	case h.SrcName != "" && h.Copied:
+		return fmt.Sprintf("C%03d", h.Similarity)
+	case h.SrcName != "":
+		return fmt.Sprintf("R%03d", h.Similarity)
+	case h.Src.Sha1 == empty && h.Dst.Sha1 != empty:
+		return "A"
+	case h.Src.Sha1 != empty && h.Dst.Sha1 == empty && h.Dst.FileMode == 0:
+		return "D"
+	default:
+		return "M"
 	}
-	return fmt.Sprintf(":%0.6o %0.6o %v %v %v	%v", h.Src.FileMode, h.Dst.FileMode, h.Src.Sha1, h.Dst.Sha1, status, h.Name)
 }
 
-// Returns a diff in the format of the command "diff". Note: this invokes
-// an external diff tool. It should be rewritten in Go to avoid the overhead
-// (and the possibility that diff isn't installed.)
-func (h HashDiff) ExternalDiff(c *Client, s1, s2 TreeEntry, f File, opts DiffCommonOptions) (string, error) {
-	tmpfile1, err := ioutil.TempFile("", "gitdiff")
-	if err != nil {
-		return "", err
+// Returns the name of the source of the diff, which is only different
+// from the destination for renames and copies.
+func (h HashDiff) srcName() IndexPath {
+	if h.SrcName != "" {
+		return h.SrcName
 	}
-	defer os.Remove(tmpfile1.Name())
+	return h.Name
+}
 
+func (h HashDiff) String() string {
+	if h.SrcName != "" {
+		return fmt.Sprintf(":%0.6o %0.6o %v %v %v	%v	%v", h.Src.FileMode, h.Dst.FileMode, h.Src.Sha1, h.Dst.Sha1, h.Status(), h.SrcName, h.Name)
+	}
+	return fmt.Sprintf(":%0.6o %0.6o %v %v %v	%v", h.Src.FileMode, h.Dst.FileMode, h.Src.Sha1, h.Dst.Sha1, h.Status(), h.Name)
+}
+
+// Returns the content of the source and destination of the diff. If the
+// destination has a mode but no sha1, it refers to the file in the work
+// tree and is read from the filesystem.
+func (h HashDiff) contents(c *Client) (src, dst []byte, err error) {
 	var emptySha Sha1
-	if s1.Sha1 != emptySha {
-		obj, err := c.GetObject(s1.Sha1)
+	if h.Src.FileMode == ModeCommit {
+		src = []byte(fmt.Sprintf("Subproject commit %v\n", h.Src.Sha1))
+	} else if h.Src.Sha1 != emptySha {
+		obj, err := c.GetObject(h.Src.Sha1)
 		if err != nil {
-			return "", err
+			return nil, nil, err
 		}
-		tmpfile1.Write(obj.GetContent())
+		src = obj.GetContent()
 	}
 
-	tmpfile1.Close()
+	if h.Dst.FileMode == ModeCommit {
+		dst = []byte(fmt.Sprintf("Subproject commit %v\n", h.Dst.Sha1))
+	} else if h.Dst.Sha1 != emptySha {
+		obj, err := c.GetObject(h.Dst.Sha1)
+		if err != nil {
+			return nil, nil, err
+		}
+		dst = obj.GetContent()
+	} else if h.Dst.FileMode != 0 {
+		f, err := h.Name.FilePath(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if h.Dst.FileMode == ModeSymlink {
+			target, err := os.Readlink(f.String())
+			if err != nil {
+				return nil, nil, err
+			}
+			dst = []byte(target)
+		} else {
+			dst, err = ioutil.ReadFile(f.String())
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return src, dst, nil
+}
 
-	tmpfile2, err := ioutil.TempFile("", "gitdiff")
+// WritePatch writes the unified diff for h to w, in the format of the
+// output of "git diff" (without any "diff --git" header.)
+func (h HashDiff) WritePatch(c *Client, w io.Writer, opts DiffCommonOptions) error {
+	colors, err := opts.diffColors(c, w)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer os.Remove(tmpfile2.Name())
+	return h.writePatch(c, w, opts, colors, false)
+}
 
-	var file2 = tmpfile2.Name()
-	if s2.Sha1 != emptySha {
-		obj, err := c.GetObject(s2.Sha1)
+// HasChanges returns true if h changes anything which isn't ignored by
+// the whitespace options in opts.
+func (h HashDiff) HasChanges(c *Client, opts DiffCommonOptions) (bool, error) {
+	ws := opts.whitespace()
+	if ws == 0 || h.SrcName != "" || h.Src.FileMode != h.Dst.FileMode {
+		return true, nil
+	}
+	alg, err := opts.diffAlgorithm(c)
+	if err != nil {
+		return false, err
+	}
+	src, dst, err := h.contents(c)
+	if err != nil {
+		return false, err
+	}
+	if diff.IsBinary(src) || diff.IsBinary(dst) || bytes.Equal(src, dst) {
+		return true, nil
+	}
+	script := diff.DiffWhitespace(alg, ws, diff.Lines(src), diff.Lines(dst))
+	if ws&diff.IgnoreBlankLines != 0 {
+		return len(diff.HunksIgnoringBlankLines(script, opts.NumContextLines, ws)) > 0, nil
+	}
+	for _, l := range script {
+		if l.Op != diff.Equal {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns the "diff --git" line and the extended header lines of the patch
+// for h. dst is the content of the destination, which is hashed for the
+// index line if it's in the work tree.
+func (h HashDiff) patchHeader(opts DiffCommonOptions, dst []byte, binary bool) ([]string, error) {
+	header := []string{fmt.Sprintf("diff --git a/%v b/%v", h.srcName(), h.Name)}
+	switch {
+	case h.Src.FileMode == 0:
+		header = append(header, fmt.Sprintf("new file mode %o", h.Dst.FileMode))
+	case h.Dst.FileMode == 0:
+		header = append(header, fmt.Sprintf("deleted file mode %o", h.Src.FileMode))
+	case h.Src.FileMode != h.Dst.FileMode:
+		header = append(header,
+			fmt.Sprintf("old mode %o", h.Src.FileMode),
+			fmt.Sprintf("new mode %o", h.Dst.FileMode),
+		)
+	}
+	if h.SrcName != "" {
+		verb := "rename"
+		if h.Copied {
+			verb = "copy"
+		}
+		header = append(header,
+			fmt.Sprintf("similarity index %d%%", h.Similarity),
+			fmt.Sprintf("%v from %v", verb, h.SrcName),
+			fmt.Sprintf("%v to %v", verb, h.Name),
+		)
+	}
+
+	dstSha := h.Dst.Sha1
+	if dstSha == (Sha1{}) && h.Dst.FileMode != 0 {
+		sha, _, err := HashSlice("blob", dst)
 		if err != nil {
-			return "", err
+			return nil, err
+		}
+		dstSha = sha
+	}
+	if h.Src.Sha1 != dstSha {
+		// Binary patches always have the full object names, since
+		// git apply needs them to check that they apply.
+		src, dst := h.Src.Sha1.String(), dstSha.String()
+		if !opts.FullIndex && !(opts.Binary && binary) {
+			src, dst = src[:7], dst[:7]
+		}
+		index := fmt.Sprintf("index %v..%v", src, dst)
+		if h.Src.FileMode == h.Dst.FileMode {
+			index += fmt.Sprintf(" %o", h.Src.FileMode)
 		}
-		tmpfile2.Write(obj.GetContent())
-	} else if s2.FileMode != 0 {
-		file2 = f.String()
+		header = append(header, index)
 	}
-	tmpfile2.Close()
+	return header, nil
+}
 
-	indexPath, err := f.IndexPath(c)
+// Writes the patch for h to w, preceded by the header lines if header is
+// set. Nothing is written if all of the changes are ignored by the
+// whitespace options.
+func (h HashDiff) writePatch(c *Client, w io.Writer, opts DiffCommonOptions, colors diffColors, header bool) error {
+	alg, err := opts.diffAlgorithm(c)
 	if err != nil {
-		// If it couldn't be converted, fall back on the file name.
-		indexPath = IndexPath(f)
+		return err
+	}
+	src, dst, err := h.contents(c)
+	if err != nil {
+		return err
+	}
+	binary := diff.IsBinary(src) || diff.IsBinary(dst)
+	var headerLines []string
+	if header {
+		if headerLines, err = h.patchHeader(opts, dst, binary); err != nil {
+			return err
+		}
+	}
+	writeHeader := func() {
+		for _, l := range headerLines {
+			fmt.Fprintf(w, "%v%v%v\n", colors.meta, l, colors.reset)
+		}
+	}
+
+	// New and deleted files are compared to /dev/null.
+	srcLabel, dstLabel := fmt.Sprintf("a/%v", h.srcName()), fmt.Sprintf("b/%v", h.Name)
+	if h.Src.FileMode == 0 {
+		srcLabel = "/dev/null"
+	}
+	if h.Dst.FileMode == 0 {
+		dstLabel = "/dev/null"
+	}
+
+	if binary {
+		writeHeader()
+		switch {
+		case bytes.Equal(src, dst):
+		case opts.Binary:
+			return writeBinaryPatch(w, src, dst)
+		default:
+			fmt.Fprintf(w, "Binary files %v and %v differ\n", srcLabel, dstLabel)
+		}
+		return nil
+	}
+
+	ws := opts.whitespace()
+	srcLines, dstLines := diff.Lines(src), diff.Lines(dst)
+	script := diff.DiffWhitespace(alg, ws, srcLines, dstLines)
+	var hunks []diff.Hunk
+	if ws&diff.IgnoreBlankLines != 0 {
+		hunks = diff.HunksIgnoringBlankLines(script, opts.NumContextLines, ws)
+	} else {
+		hunks = diff.Hunks(script, opts.NumContextLines)
+	}
+	if len(hunks) == 0 && !bytes.Equal(src, dst) && h.SrcName == "" && h.Src.FileMode == h.Dst.FileMode {
+		// The file changed, but every change was ignored.
+		return nil
+	}
+	writeHeader()
+	if len(hunks) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "%v--- %v%v\n", colors.meta, srcLabel, colors.reset)
+	fmt.Fprintf(w, "%v+++ %v%v\n", colors.meta, dstLabel, colors.reset)
+
+	var words *wordDiffer
+	switch opts.WordDiff {
+	case "color", "plain", "porcelain":
+		words = newWordDiffer(w, opts, colors)
 	}
-	diffcmd := exec.Command(posixDiff, "-u", "-U", strconv.Itoa(opts.NumContextLines), "-L", ("a/" + indexPath).String(), "-L", ("b/" + indexPath).String(), tmpfile1.Name(), file2)
-	// diff returns an error code if there's any differences, so just throw
-	// away the error.
-	diffcmd.Stderr = os.Stderr
 
-	// diff returns > 0 if any diffs are found, but we don't want to treat
-	// it as an error.
-	out, _ := diffcmd.Output()
-	return string(out), err
+	// Whitespace errors are only highlighted when there are colours
+	// to highlight them with.
+	var rule wsRule
+	var oldWS, newWS, contextWS string
 
+	// Blank lines added after these lines are highlighted as blank lines
+	// at the end of the file.
+	var blankSrcLine, blankDstLine int
+	if colors.whitespace != "" {
+		rule = whitespaceRule(c)
+		old, new, context := opts.wsErrorHighlight()
+		if old {
+			oldWS = colors.whitespace
+		}
+		if new {
+			newWS = colors.whitespace
+			if rule&wsBlankAtEOF != 0 {
+				blankSrcLine, blankDstLine = blankAtEOF(src, dst)
+			}
+		}
+		if context {
+			contextWS = colors.whitespace
+		}
+	}
+
+	for _, hunk := range hunks {
+		if words != nil {
+			words.flush()
+		}
+		fmt.Fprintf(w, "%v%v%v\n", colors.frag, hunk.Header(), colors.reset)
+
+		// The position in src and dst, which is tracked the same way
+		// as git so that the same blank lines are highlighted.
+		srcLine, dstLine := hunk.SrcStart, hunk.DstStart
+		for _, l := range hunk.Lines {
+			if l.Op != diff.Insert {
+				srcLine++
+			}
+			if l.Op != diff.Delete {
+				dstLine++
+			}
+			if words != nil {
+				words.add(l)
+				continue
+			}
+			switch l.Op {
+			case diff.Delete:
+				writePatchLine(w, l, colors.old, colors.reset, rule, oldWS)
+			case diff.Insert:
+				if blankDstLine > 0 && blankSrcLine <= srcLine && blankDstLine <= dstLine && blankLine(l.Text) {
+					// Blank lines added to the end of the file
+					// are highlighted entirely.
+					writePatchLine(w, l, newWS, colors.reset, 0, "")
+				} else {
+					writePatchLine(w, l, colors.new, colors.reset, rule, newWS)
+				}
+			default:
+				writePatchLine(w, l, colors.context, colors.reset, rule, contextWS)
+			}
+			if !strings.HasSuffix(l.Text, "\n") {
+				fmt.Fprintf(w, "%v\\ No newline at end of file%v\n", colors.context, colors.reset)
+			}
+		}
+	}
+	if words != nil {
+		words.flush()
+	}
+	return nil
+}
+
+// Writes a line of a patch to w in the colour set. If ws isn't empty,
+// whitespace errors in the line are highlighted in it.
+func writePatchLine(w io.Writer, l diff.Line, set, reset string, rule wsRule, ws string) {
+	text := strings.TrimSuffix(l.Text, "\n")
+	if ws == "" {
+		fmt.Fprintf(w, "%v%c%v%v\n", set, l.Op, text, reset)
+		return
+	}
+	fmt.Fprintf(w, "%v%c%v", set, l.Op, reset)
+	checkWhitespace(w, text+"\n", rule, set, reset, ws)
 }
 
 // Implement the sort interface on *GitIndexEntry, so that
@@ -105,26 +366,107 @@ func printDiffHeader(w io.Writer, name IndexPath, full bool) {
 	}
 }
 
+// GeneratePatch writes diffs to dst (or stdout if dst is nil) in each of
+// the formats requested in options, in the same order as git: the raw
+// format (or names), then the diffstats and summary, and finally the
+// patch, separated from anything before it by a blank line.
+//
+// If options.Check is set, only the whitespace errors and conflict markers
+// introduced by the diffs are written, and ErrCheckFailed is returned if
+// there are any.
 func GeneratePatch(c *Client, options DiffCommonOptions, diffs []HashDiff, dst io.Writer) error {
 	if dst == nil {
 		dst = os.Stdout
 	}
-	for _, diff := range diffs {
-		if options.Raw {
-			fmt.Fprintf(dst, "%v\n", diff)
-		}
-		if options.Patch {
-			f, err := diff.Name.FilePath(c)
+	if len(diffs) == 0 {
+		return nil
+	}
+	colors, err := options.diffColors(c, dst)
+	if err != nil {
+		return err
+	}
+	if options.Check {
+		// --check replaces every other format.
+		failed := false
+		for _, diff := range diffs {
+			found, err := diff.writeCheck(c, dst, options, colors)
 			if err != nil {
 				return err
 			}
+			if found {
+				failed = true
+			}
+		}
+		if failed {
+			return ErrCheckFailed
+		}
+		return nil
+	}
+
+	separator := false
+	if options.NameOnly || options.NameStatus || options.Raw {
+		for _, diff := range diffs {
+			switch {
+			case options.NameOnly:
+				fmt.Fprintf(dst, "%v\n", diff.Name)
+			case options.NameStatus && diff.SrcName != "":
+				fmt.Fprintf(dst, "%v\t%v\t%v\n", diff.Status(), diff.SrcName, diff.Name)
+			case options.NameStatus:
+				fmt.Fprintf(dst, "%v\t%v\n", diff.Status(), diff.Name)
+			default:
+				fmt.Fprintf(dst, "%v\n", diff)
+			}
+		}
+		separator = true
+	}
 
-			patch, err := diff.ExternalDiff(c, diff.Src, diff.Dst, f, options)
-			if err != nil {
+	var dirstat dirStatParams
+	if options.DirStat {
+		if dirstat, err = options.dirStatParams(c); err != nil {
+			return err
+		}
+	}
+	if options.Stat || options.NumStat || options.ShortStat || (options.DirStat && dirstat.byLine) {
+		stats, err := diffStats(c, options, diffs)
+		if err != nil {
+			return err
+		}
+		if options.NumStat {
+			writeNumStat(dst, stats)
+		}
+		if options.Stat {
+			writeStat(dst, options, colors, stats)
+		}
+		if options.ShortStat {
+			writeShortStat(dst, stats)
+		}
+		if options.DirStat && dirstat.byLine {
+			writeDirStat(dst, dirstat, dirStatLines(stats))
+		}
+		separator = true
+	}
+	if options.DirStat && !dirstat.byLine {
+		files, err := dirStatChanges(c, diffs, dirstat.byFile)
+		if err != nil {
+			return err
+		}
+		writeDirStat(dst, dirstat, files)
+	}
+	if options.Summary && writeSummary(dst, diffs) {
+		separator = true
+	}
+
+	if options.Patch && !options.NameOnly && !options.NameStatus {
+		if separator {
+			fmt.Fprintln(dst)
+		}
+		for _, diff := range diffs {
+			if diff.Src.FileMode == ModeTree || diff.Dst.FileMode == ModeTree {
+				// Trees can't be diffed line by line.
+				continue
+			}
+			if err := diff.writePatch(c, dst, options, colors, true); err != nil {
 				return err
-			} else {
-				printDiffHeader(dst, diff.Name, false)
-				fmt.Fprintf(dst, "%v\n", patch)
 			}
 		}
 	}
@@ -43,14 +43,14 @@ type smartHTTPConn struct {
 func (s *smartHTTPConn) OpenConn() error {
 	// Try directly accessing the server's URL.
 	s.giturl = strings.TrimSuffix(s.giturl, "/")
-	expectedmime := "application/x-git-upload-pack-advertisement"
+	expectedmime := "application/x-" + s.service + "-advertisement"
 
 	// Make variable references out of true and false so we can take
 	// their address for isopen
 	var trueref bool = true
 	var falseref bool = false
 
-	req, err := http.NewRequest("GET", s.giturl+"/info/refs?service=git-upload-pack", nil)
+	req, err := http.NewRequest("GET", s.giturl+"/info/refs?service="+s.service, nil)
 	if err != nil {
 		// We couldn't even try making a request, so give up early.
 		return err
@@ -60,24 +60,43 @@ func (s *smartHTTPConn) OpenConn() error {
 	if s.username != "" || s.password != "" {
 		req.SetBasicAuth(s.username, s.password)
 	}
-	req.Header.Set("Git-Protocol", "version=2")
+	if s.service == uploadPackService {
+		req.Header.Set("Git-Protocol", "version=2")
+	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		// If we couldn't perform the request, there's probably a
 		// network issue so give up.
 		return err
 	}
+	if resp.StatusCode == http.StatusUnauthorized && s.username == "" {
+		// Servers commonly require authentication for pushing, so
+		// ask for a username and password and try again.
+		resp.Body.Close()
+		userpass, err := getUserPassword(s.giturl)
+		if err != nil {
+			return err
+		}
+		s.username, s.password = userpass.user, userpass.password
+		req.SetBasicAuth(s.username, s.password)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+	}
 	defer resp.Body.Close()
 	var respreader io.Reader
 	if ct := resp.Header.Get("Content-Type"); ct != expectedmime || resp.StatusCode != 200 {
 		// If the content-type was wrong, try again at "url.git"
 		log.Printf("Unexpected Content-Type for %v: got %v\n", s.giturl, ct)
 		s.giturl = s.giturl + ".git"
-		req, err = http.NewRequest("GET", s.giturl+"/info/refs?service=git-upload-pack", nil)
+		req, err = http.NewRequest("GET", s.giturl+"/info/refs?service="+s.service, nil)
 		if s.username != "" || s.password != "" {
 			req.SetBasicAuth(s.username, s.password)
 		}
-		req.Header.Set("Git-Protocol", "version=2")
+		if s.service == uploadPackService {
+			req.Header.Set("Git-Protocol", "version=2")
+		}
 		newresp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			s.isopen = &falseref
@@ -118,7 +137,8 @@ func (s *smartHTTPConn) OpenConn() error {
 func parseRemoteInitialConnection(r io.Reader, stateless bool) (uint8, map[string]map[string]struct{}, []Ref, error) {
 	line := loadLine(r)
 	switch line {
-	case "# service=git-upload-pack", "# service=git-upload-pack\n":
+	case "# service=git-upload-pack", "# service=git-upload-pack\n",
+		"# service=git-receive-pack", "# service=git-receive-pack\n":
 		// An http connection starts with the service announcement. We
 		// need to parse the next line
 		line = loadLine(r)
@@ -162,7 +182,7 @@ func parseRemoteInitialConnection(r io.Reader, stateless bool) (uint8, map[strin
 			//  can cause a name to end)
 			var nameEnd int
 			for idx, char := range s {
-				if char == ' ' && ret.Value == (Sha1{}) {
+				if char == ' ' && firstSpace == 0 {
 					sha1, err := Sha1FromString(s[0:idx])
 					if err != nil {
 						return nil, err
@@ -188,19 +208,25 @@ func parseRemoteInitialConnection(r io.Reader, stateless bool) (uint8, map[strin
 						return &ret, nil
 					}
 					// The first line, so parse the capabilities
-					caps := strings.Split(s[nameEnd:], " ")
+					caps := strings.Fields(s[nameEnd:])
 					for _, c := range caps {
 						if eq := strings.Index(c, "="); eq == -1 {
 							cap[c] = make(map[string]struct{})
 						} else {
-							name := line[:eq]
-							args := make(map[string]struct{})
-							for _, opt := range strings.Fields(line[eq+1:]) {
-								args[opt] = struct{}{}
+							name := c[:eq]
+							args, ok := cap[name]
+							if !ok {
+								args = make(map[string]struct{})
 							}
+							args[c[eq+1:]] = struct{}{}
 							cap[name] = args
 						}
 					}
+					if ret.Name == "capabilities^{}" {
+						// An empty repository advertises its
+						// capabilities on a fake ref.
+						return nil, nil
+					}
 					return &ret, nil
 				}
 			}
@@ -279,6 +305,11 @@ func (s smartHTTPConn) SetUploadPack(string) error {
 	return nil
 }
 
+func (s smartHTTPConn) SetReceivePack(string) error {
+	// Not applicable for http
+	return nil
+}
+
 func (s *smartHTTPConn) Write(data []byte) (int, error) {
 	l, err := PktLineEncodeNoNl(data)
 	if err != nil {
@@ -290,7 +321,7 @@ func (s *smartHTTPConn) Write(data []byte) (int, error) {
 	// protocol v2 sends "done" and then a flush, while v1 sends a flush
 	// and then done. So if it's v2, don't send the request when we see
 	// "done"
-	if s.protocolversion != 2 {
+	if s.protocolversion != 2 && s.service == uploadPackService {
 		if string(data) == "done\n" || string(data) == "done" {
 			if err := s.sendRequest("application/x-git-upload-pack-result"); err != nil {
 				return 0, err
@@ -305,9 +336,18 @@ func (s *smartHTTPConn) Flush() error {
 	if s.almostdone && s.protocolversion == 1 {
 		return nil
 	}
+	if s.service == receivePackService {
+		// The packfile comes after the flush, so the request is
+		// sent on the next Read instead.
+		return nil
+	}
 	return s.sendRequest("application/x-git-upload-pack-result")
 }
 
+func (s *smartHTTPConn) RawWriter() io.Writer {
+	return &s.buf
+}
+
 func (s *smartHTTPConn) Delim() error {
 	fmt.Fprintf(&s.buf, "0001")
 	return nil
@@ -316,13 +356,14 @@ func (s *smartHTTPConn) Delim() error {
 func (s *smartHTTPConn) sendRequest(expectedmime string) error {
 	log.Println("Sending HTTP Request")
 	topost := s.buf.String()
-	r, err := http.NewRequest("POST", s.giturl+"/git-upload-pack", strings.NewReader(topost))
+	s.buf.Reset()
+	r, err := http.NewRequest("POST", s.giturl+"/"+s.service, strings.NewReader(topost))
 	r.Header.Set("User-Agent", "dgit/0.0.2")
 	if s.protocolversion == 2 {
 		r.Header.Set("Git-Protocol", "version=2")
 	}
 
-	r.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	r.Header.Set("Content-Type", "application/x-"+s.service+"-request")
 	r.ContentLength = int64(len([]byte(topost)))
 	if s.username != "" || s.password != "" {
 		r.SetBasicAuth(s.username, s.password)
@@ -349,6 +390,11 @@ func (s *smartHTTPConn) Read(buf []byte) (int, error) {
 	if s.isopen == nil || *s.isopen == false {
 		return 0, fmt.Errorf("Connection not open")
 	}
+	if s.service == receivePackService && s.buf.Len() > 0 {
+		if err := s.sendRequest("application/x-git-receive-pack-result"); err != nil {
+			return 0, err
+		}
+	}
 	if s.lastresp == nil {
 		return 0, fmt.Errorf("Can not read until after first Flush() call")
 	}
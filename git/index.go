@@ -577,6 +577,19 @@ type UnmergedPath struct {
 	Stage1, Stage2, Stage3 *IndexEntry
 }
 
+// contents returns the content of the blob that e refers to, or nil if e
+// is nil (such as when a stage is missing from an unmerged path.)
+func (e *IndexEntry) contents(c *Client) ([]byte, error) {
+	if e == nil {
+		return nil, nil
+	}
+	obj, err := c.GetObject(e.Sha1)
+	if err != nil {
+		return nil, err
+	}
+	return obj.GetContent(), nil
+}
+
 func (i *Index) GetUnmerged() map[IndexPath]*UnmergedPath {
 	r := make(map[IndexPath]*UnmergedPath)
 	for _, entry := range i.Objects {
@@ -73,70 +73,6 @@ type PackfileIndexV2 struct {
 	Packfile, IdxFile Sha1
 }
 
-// Gets a list of objects in a pack file according to the index.
-func v2PackObjectListFromIndex(idx io.Reader) []Sha1 {
-	var pack PackfileIndexV2
-	binary.Read(idx, binary.BigEndian, &pack.magic)
-	binary.Read(idx, binary.BigEndian, &pack.Version)
-	binary.Read(idx, binary.BigEndian, &pack.Fanout)
-	pack.Sha1Table = make([]Sha1, pack.Fanout[255])
-	// Load the tables. The first three are based on the number of
-	// objects in the packfile (stored in Fanout[255]), the last
-	// table is dynamicly sized.
-
-	for i := 0; i < len(pack.Sha1Table); i++ {
-		if err := binary.Read(idx, binary.BigEndian, &pack.Sha1Table[i]); err != nil {
-			panic(err)
-		}
-	}
-	return pack.Sha1Table
-}
-
-// reads a v2 pack file from r and tells if it has object inside it.
-func v2PackIndexHasSha1(c *Client, pfile File, r io.Reader, obj Sha1) bool {
-	var pack PackfileIndexV2
-	binary.Read(r, binary.BigEndian, &pack.magic)
-	binary.Read(r, binary.BigEndian, &pack.Version)
-	binary.Read(r, binary.BigEndian, &pack.Fanout)
-	pack.Sha1Table = make([]Sha1, pack.Fanout[255])
-	pack.CRC32 = make([]uint32, pack.Fanout[255])
-	pack.FourByteOffsets = make([]uint32, pack.Fanout[255])
-	// Load the tables. The first three are based on the number of
-	// objects in the packfile (stored in Fanout[255]), the last
-	// table is dynamicly sized.
-
-	for i := 0; i < len(pack.Sha1Table); i++ {
-		if err := binary.Read(r, binary.BigEndian, &pack.Sha1Table[i]); err != nil {
-			panic(err)
-		}
-	}
-	for i := 0; i < len(pack.CRC32); i++ {
-		if err := binary.Read(r, binary.BigEndian, &pack.CRC32[i]); err != nil {
-			panic(err)
-		}
-	}
-	for i := 0; i < len(pack.FourByteOffsets); i++ {
-		if err := binary.Read(r, binary.BigEndian, &pack.FourByteOffsets[i]); err != nil {
-			panic(err)
-		}
-		var offset int64
-		if pack.FourByteOffsets[i]&(1<<31) != 0 {
-			// clear out the MSB to get the offset
-			eightbyteOffset := pack.FourByteOffsets[i] ^ (1 << 31)
-			if eightbyteOffset&(1<<31) != 0 {
-				var val uint64
-				binary.Read(r, binary.BigEndian, &val)
-				pack.EightByteOffsets = append(pack.EightByteOffsets, val)
-				offset = int64(val)
-			}
-		} else {
-			offset = int64(pack.FourByteOffsets[i])
-		}
-		c.objectCache[pack.Sha1Table[i]] = objectLocation{false, pfile, &pack, offset}
-	}
-	return pack.HasObject(obj)
-}
-
 func (idx PackfileIndexV2) WriteIndex(w io.Writer) error {
 	return idx.writeIndex(w, true)
 }
@@ -187,6 +123,8 @@ func (idx PackfileIndexV2) getObjectAtOffset(r io.ReaderAt, offset int64, metaOn
 		return GitTreeObject{int(sz), rawdata}, nil
 	case OBJ_BLOB:
 		return GitBlobObject{int(sz), rawdata}, nil
+	case OBJ_TAG:
+		return GitTagObject{int(sz), rawdata}, nil
 	case OBJ_OFS_DELTA:
 		// Things aren't very consistent with if types are strings, types,
 		// or interfaces, making this far more difficult than it needs to be.
@@ -205,6 +143,8 @@ func (idx PackfileIndexV2) getObjectAtOffset(r io.ReaderAt, offset int64, metaOn
 			res.Type = OBJ_TREE
 		case "blob":
 			res.Type = OBJ_BLOB
+		case "tag":
+			res.Type = OBJ_TAG
 		default:
 			return nil, InvalidObject
 		}
@@ -221,6 +161,8 @@ func (idx PackfileIndexV2) getObjectAtOffset(r io.ReaderAt, offset int64, metaOn
 			return GitTreeObject{len(val), val}, nil
 		case OBJ_BLOB:
 			return GitBlobObject{len(val), val}, nil
+		case OBJ_TAG:
+			return GitTagObject{len(val), val}, nil
 		default:
 			return nil, InvalidObject
 		}
@@ -240,6 +182,8 @@ func (idx PackfileIndexV2) getObjectAtOffset(r io.ReaderAt, offset int64, metaOn
 			res.Type = OBJ_TREE
 		case "blob":
 			res.Type = OBJ_BLOB
+		case "tag":
+			res.Type = OBJ_TAG
 		default:
 			return nil, InvalidObject
 		}
@@ -256,6 +200,8 @@ func (idx PackfileIndexV2) getObjectAtOffset(r io.ReaderAt, offset int64, metaOn
 			return GitTreeObject{len(val), val}, nil
 		case OBJ_BLOB:
 			return GitBlobObject{len(val), val}, nil
+		case OBJ_TAG:
+			return GitTagObject{len(val), val}, nil
 		default:
 			return nil, InvalidObject
 		}
@@ -266,121 +212,38 @@ func (idx PackfileIndexV2) getObjectAtOffset(r io.ReaderAt, offset int64, metaOn
 
 // Find the object in the table.
 func (idx PackfileIndexV2) GetObjectMetadata(r io.ReaderAt, s Sha1) (GitObject, error) {
-	foundIdx := -1
-	startIdx := idx.Fanout[s[0]]
-
-	// Packfiles are designed so that we could do a binary search here, but
-	// we don't need that optimization yet, so just do a linear search through
-	// the objects with the same first byte.
-	for i := startIdx - 1; idx.Sha1Table[i][0] == s[0]; i-- {
-		if s == idx.Sha1Table[i] {
-			foundIdx = int(i)
-			break
-		}
-	}
-	if foundIdx == -1 {
+	i, found := idx.findObject(s)
+	if !found {
 		return nil, fmt.Errorf("Object not found: %v", s)
 	}
 
-	var offset int64
-	if idx.FourByteOffsets[foundIdx]&(1<<31) != 0 {
-		// clear out the MSB to get the offset
-		eightbyteOffset := idx.FourByteOffsets[foundIdx] ^ (1 << 31)
-		offset = int64(idx.EightByteOffsets[eightbyteOffset])
-	} else {
-		offset = int64(idx.FourByteOffsets[foundIdx])
-	}
-
 	// Now that we've figured out where the object lives, use the packfile
 	// to get the value from the packfile.
-	return idx.getObjectAtOffset(r, offset, true)
+	return idx.getObjectAtOffset(r, idx.entryOffset(i), true)
 }
 
 func (idx PackfileIndexV2) GetObject(r io.ReaderAt, s Sha1) (GitObject, error) {
-	foundIdx := -1
-	startIdx := idx.Fanout[s[0]]
-	if startIdx <= 0 {
-		// The fanout table holds the number of entries less than x, so we
-		// subtract 1 to make sure we don't miss the hash we're looking for,
-		// but we need a special case for s[0] == 0 to prevent underflow
-		startIdx = 1
-	}
-
-	// Packfiles are designed so that we could do a binary search here, but
-	// we don't need that optimization yet, so just do a linear search through
-	// the objects with the same first byte.
-	for i := startIdx - 1; idx.Sha1Table[i][0] == s[0]; i-- {
-		if s == idx.Sha1Table[i] {
-			foundIdx = int(i)
-			break
-		}
-	}
-	if foundIdx == -1 {
+	i, found := idx.findObject(s)
+	if !found {
 		return nil, fmt.Errorf("Object not found: %v", s)
 	}
 
-	var offset int64
-	if idx.FourByteOffsets[foundIdx]&(1<<31) != 0 {
-		// clear out the MSB to get the offset
-		eightbyteOffset := idx.FourByteOffsets[foundIdx] ^ (1 << 31)
-		offset = int64(idx.EightByteOffsets[eightbyteOffset])
-	} else {
-		offset = int64(idx.FourByteOffsets[foundIdx])
-	}
-
 	// Now that we've figured out where the object lives, use the packfile
 	// to get the value from the packfile.
-	return idx.getObjectAtOffset(r, offset, false)
+	return idx.getObjectAtOffset(r, idx.entryOffset(i), false)
 }
 
 func getPackFileObject(idx io.Reader, packfile io.ReaderAt, s Sha1, metaOnly bool) (GitObject, error) {
-	var pack PackfileIndexV2
-	if err := binary.Read(idx, binary.BigEndian, &pack.magic); err != nil {
-		return nil, err
-	}
-	if err := binary.Read(idx, binary.BigEndian, &pack.Version); err != nil {
-		return nil, err
-	}
-	if err := binary.Read(idx, binary.BigEndian, &pack.Fanout); err != nil {
+	pack, err := readPackfileIndexV2(idx)
+	if err != nil {
 		return nil, err
 	}
-	pack.Sha1Table = make([]Sha1, pack.Fanout[255])
-	pack.CRC32 = make([]uint32, pack.Fanout[255])
-	pack.FourByteOffsets = make([]uint32, pack.Fanout[255])
-	// Load the tables. The first three are based on the number of
-	// objects in the packfile (stored in Fanout[255]), the last
-	// table is dynamicly sized.
-
-	for i := 0; i < len(pack.Sha1Table); i++ {
-		if err := binary.Read(idx, binary.BigEndian, &pack.Sha1Table[i]); err != nil {
-			return nil, err
-		}
-	}
-	for i := 0; i < len(pack.CRC32); i++ {
-		if err := binary.Read(idx, binary.BigEndian, &pack.CRC32[i]); err != nil {
-			return nil, err
-		}
-	}
-	for i := 0; i < len(pack.FourByteOffsets); i++ {
-		if err := binary.Read(idx, binary.BigEndian, &pack.FourByteOffsets[i]); err != nil {
-			return nil, err
-		}
-	}
-
-	// The number of eight byte offsets is dynamic, based on how many
-	// four byte offsets have the MSB set.
-	for _, offset := range pack.FourByteOffsets {
-		if offset&(1<<31) != 0 {
-			var val uint64
-			binary.Read(idx, binary.BigEndian, &val)
-			pack.EightByteOffsets = append(pack.EightByteOffsets, val)
-		}
-	}
 	if metaOnly {
 		return pack.GetObjectMetadata(packfile, s)
 	}
 	return pack.GetObject(packfile, s)
 }
+
 func (idx PackfileIndexV2) GetTrailer() (Sha1, Sha1) {
 	return idx.Packfile, idx.IdxFile
 }
@@ -428,23 +291,8 @@ func (idx PackfileIndexV2) writeIndex(w io.Writer, withTrailer bool) error {
 	return nil
 }
 func (idx PackfileIndexV2) HasObject(s Sha1) bool {
-	startIdx := idx.Fanout[s[0]]
-	if startIdx <= 0 {
-		// The fanout table holds the number of entries less than x, so we
-		// subtract 1 to make sure we don't miss the hash we're looking for,
-		// but we need a special case for s[0] == 0 to prevent underflow
-		startIdx = 1
-	}
-
-	// Packfiles are designed so that we could do a binary search here, but
-	// we don't need that optimization yet, so just do a linear search through
-	// the objects with the same first byte.
-	for i := startIdx - 1; idx.Sha1Table[i][0] == s[0] && i >= 0; i-- {
-		if s == idx.Sha1Table[i] {
-			return true
-		}
-	}
-	return false
+	_, found := idx.findObject(s)
+	return found
 }
 
 // Implements the Sorter interface on PackfileIndexV2, in order to sort the
@@ -648,6 +496,8 @@ func IndexPack(c *Client, opts IndexPackOptions, r io.Reader) (idx PackfileIndex
 				t = OBJ_TREE
 			case "blob":
 				t = OBJ_BLOB
+			case "tag":
+				t = OBJ_TAG
 			default:
 				panic("Unhandled delta base type" + base.GetType())
 			}
@@ -687,6 +537,8 @@ func IndexPack(c *Client, opts IndexPackOptions, r io.Reader) (idx PackfileIndex
 				t = OBJ_TREE
 			case "blob":
 				t = OBJ_BLOB
+			case "tag":
+				t = OBJ_TAG
 			default:
 				panic("Unhandled delta base type" + base.GetType())
 			}
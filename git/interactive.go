@@ -23,20 +23,25 @@ func splitPatch(fullpatch string, nameonly bool) ([]patchHunk, error) {
 	for i, match := range filechunks {
 		a := fullpatch[match[2]:match[3]]
 		b := fullpatch[match[4]:match[5]]
-		if a != b {
-			return nil, fmt.Errorf("Filenames do not match")
-		}
 		var patch string
 		if i == len(filechunks)-1 {
 			patch = fullpatch[match[0]:]
 		} else {
 			patch = fullpatch[match[0]:filechunks[i+1][0]]
 		}
+		header, err := parsePatchFileHeader(IndexPath(a), IndexPath(b), patch)
+		if err != nil {
+			return nil, err
+		}
 		if nameonly {
-			ret = append(ret, patchHunk{IndexPath(a), ""})
-
+			// Both of the files involved in a rename or copy are
+			// part of the patch.
+			if header.oldName != header.newName {
+				ret = append(ret, patchHunk{header.oldName, ""})
+			}
+			ret = append(ret, patchHunk{header.newName, ""})
 		} else {
-			pieces := extractPatchHunks(IndexPath(a), patch)
+			pieces := extractPatchHunks(header.newName, patch)
 			ret = append(ret, pieces...)
 		}
 	}
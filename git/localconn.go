@@ -18,6 +18,9 @@ type localConn struct {
 	// name of the remote upload pack command
 	uploadpack string
 
+	// name of the remote receive pack command
+	receivepack string
+
 	stdin  io.ReadCloser
 	stdout io.WriteCloser
 	cmd    *exec.Cmd
@@ -26,9 +29,14 @@ type localConn struct {
 func (s *localConn) OpenConn() error {
 	var cmd *exec.Cmd
 	log.Println("Connecting locally via", s.uri.Path)
-	if s.uploadpack == "" {
+	switch {
+	case s.service == receivePackService && s.receivepack == "":
+		cmd = exec.Command("git-receive-pack", s.uri.Path)
+	case s.service == receivePackService:
+		cmd = exec.Command(s.receivepack, s.uri.Path)
+	case s.uploadpack == "":
 		cmd = exec.Command("git-upload-pack", s.uri.Path)
-	} else {
+	default:
 		cmd = exec.Command(s.uploadpack, s.uri.Path)
 	}
 	cmd.Stderr = os.Stderr
@@ -43,9 +51,11 @@ func (s *localConn) OpenConn() error {
 	}
 	s.stdin = cmdOut
 
-	// We don't check error on setenv because if it failed we'll just fall
-	// back on protocol v1
-	os.Setenv("GIT_PROTOCOL", "version=2")
+	if s.service != receivePackService {
+		// We don't check error on setenv because if it failed we'll just fall
+		// back on protocol v1
+		os.Setenv("GIT_PROTOCOL", "version=2")
+	}
 
 	if err := cmd.Start(); err != nil {
 		return err
@@ -111,6 +121,15 @@ func (s *localConn) SetUploadPack(up string) error {
 	return nil
 }
 
+func (s *localConn) SetReceivePack(rp string) error {
+	s.receivepack = rp
+	return nil
+}
+
+func (s localConn) RawWriter() io.Writer {
+	return s.stdout
+}
+
 func (s localConn) Flush() error {
 	fmt.Fprintf(s.stdout, "0000")
 	return nil
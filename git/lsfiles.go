@@ -39,19 +39,50 @@ files:
 		if fi.Name() == ".git" {
 			continue
 		}
+		var name File
+		if parent == "" {
+			name = fname
+		} else {
+			name = parent + "/" + fname
+		}
+		ignored := false
 		for _, pattern := range ignorePatterns {
-			var name File
-			if parent == "" {
-				name = fname
-			} else {
-				name = parent + "/" + fname
-			}
 			if pattern.Matches(name.String(), fi.IsDir()) {
-				continue files
+				if !opts.Ignored {
+					continue files
+				}
+				ignored = true
+				break
 			}
 		}
 		if fi.IsDir() {
-			if !recursedir {
+			var newparent, newdir File
+			if parent == "" {
+				newparent = fname
+			} else {
+				newparent = parent + "/" + fname
+			}
+			if dir == "" {
+				newdir = fname
+			} else {
+				newdir = dir + "/" + fname
+			}
+			if ignored {
+				// Everything in an ignored directory is ignored, so
+				// either show the directory or all of its untracked
+				// files without looking at any more patterns.
+				if opts.Directory {
+					indexPath := IndexPath(strings.TrimPrefix(name.String(), root.String()))
+					untracked = append(untracked, &IndexEntry{PathName: indexPath + "/"})
+					continue
+				}
+				all := opts
+				all.Ignored = false
+				all.ExcludePerDirectory = nil
+				untracked = append(untracked, findUntrackedFilesFromDir(c, all, root, newparent, newdir, tracked, true, nil)...)
+				continue
+			}
+			if !recursedir && !opts.Ignored {
 				// This isn't very efficient, but lets us implement git ls-files --directory
 				// without too many changes.
 				indexPath, err := (parent + "/" + fname).IndexPath(c)
@@ -68,7 +99,9 @@ files:
 				if !dirHasTracked {
 					if opts.Directory {
 						if opts.NoEmptyDirectory {
-							if files, err := ioutil.ReadDir(fname.String()); len(files) == 0 && err == nil {
+							// A directory which only has ignored files
+							// in it is empty too.
+							if contents := findUntrackedFilesFromDir(c, opts, root, newparent, newdir, tracked, true, ignorePatterns); len(contents) == 0 {
 								continue
 							}
 						}
@@ -78,28 +111,14 @@ files:
 					continue
 				}
 			}
-			var newparent, newdir File
-			if parent == "" {
-				newparent = fname
-			} else {
-				newparent = parent + "/" + fname
-			}
-			if dir == "" {
-				newdir = fname
-			} else {
-				newdir = dir + "/" + fname
-			}
 
 			recurseFiles := findUntrackedFilesFromDir(c, opts, root, newparent, newdir, tracked, recursedir, ignorePatterns)
 			untracked = append(untracked, recurseFiles...)
 		} else {
-			var filePath File
-			if parent == "" {
-				filePath = File(strings.TrimPrefix(fname.String(), root.String()))
-
-			} else {
-				filePath = File(strings.TrimPrefix((parent + "/" + fname).String(), root.String()))
+			if opts.Ignored && !ignored {
+				continue
 			}
+			filePath := File(strings.TrimPrefix(name.String(), root.String()))
 			indexPath, err := filePath.IndexPath(c)
 			if err != nil {
 				panic(err)
@@ -2,28 +2,35 @@ package git
 
 import (
 	"fmt"
-	"io"
+	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
 type MergeStrategy string
 
 func commitishName(c Commitish) string {
-	if b, ok := c.(Branch); ok {
+	switch b := c.(type) {
+	case Branch:
 		return b.BranchName()
+	case RefSpec:
+		return strings.TrimPrefix(strings.TrimPrefix(b.String(), "refs/remotes/"), "refs/tags/")
 	}
 	return ""
 }
 
 const (
 	MergeRecursive = MergeStrategy("recursive")
+	MergeOrt       = MergeStrategy("ort")
 	MergeOctopus   = MergeStrategy("octopus")
 )
 
 // Merge options represent the options that may be passed on
 // the command line to "git merge"
 type MergeOptions struct {
-	// Not implemented
+	// Perform the merge, but stop before committing the result.
 	NoCommit bool
 
 	// Not implemented
@@ -37,16 +44,18 @@ type MergeOptions struct {
 	// Not implemented
 	Stat bool
 
-	// Not implemented
+	// Update the index and work tree as if a merge happened, but don't
+	// create a merge commit or record MERGE_HEAD.
 	Squash bool
 
-	// Not implemented
+	// The merge strategy to use. "recursive" and "ort" are both
+	// supported and use the same implementation.
 	Strategy MergeStrategy
 
 	// Not implemented
 	VerifySignatures bool
 
-	// Not implemented
+	// Suppress the output of Auto-merging and CONFLICT messages.
 	Quiet bool
 	// Not implemented
 	Verbose bool
@@ -54,14 +63,156 @@ type MergeOptions struct {
 	// Not implemented
 	NoProgress bool
 
-	// Not implemented
+	// The message to use for the merge commit. If empty, a default
+	// message is generated.
 	Message string
 }
 
+// Files in the GitDir which record an in progress merge.
+const (
+	mergeHeadFile = File("MERGE_HEAD")
+	mergeMsgFile  = File("MERGE_MSG")
+	mergeModeFile = File("MERGE_MODE")
+	squashMsgFile = File("SQUASH_MSG")
+	origHeadFile  = File("ORIG_HEAD")
+)
+
+// Returns true if there is a merge in progress.
+func (c *Client) IsMerging() bool {
+	return c.GitDir.File(mergeHeadFile).Exists()
+}
+
+// Returns the commits recorded in MERGE_HEAD for an in progress merge.
+func (c *Client) GetMergeHeads() ([]CommitID, error) {
+	content, err := c.GitDir.ReadFile(mergeHeadFile)
+	if err != nil {
+		return nil, err
+	}
+	var heads []CommitID
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		cmt, err := CommitIDFromString(line)
+		if err != nil {
+			return nil, err
+		}
+		heads = append(heads, cmt)
+	}
+	return heads, nil
+}
+
+// Removes the files which record an in progress merge, or the commit being
+// cherry-picked or reverted.
+func (c *Client) clearMergeState() {
+	for _, f := range []File{mergeHeadFile, mergeMsgFile, mergeModeFile, squashMsgFile, cherryPickHeadFile, revertHeadFile} {
+		os.Remove(c.GitDir.File(f).String())
+	}
+}
+
 // Aborts an in progress merge as "git merge --abort"
-// (Not implemented)
+//
+// The index and any files which were changed by the merge are reset to
+// HEAD. Other local modifications in the work tree are preserved.
 func MergeAbort(c *Client, opt MergeOptions) error {
-	return fmt.Errorf("MergeAbort not yet implemented")
+	if !c.IsMerging() {
+		return fmt.Errorf("There is no merge to abort (MERGE_HEAD missing).")
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	if err := resetMerge(c, head); err != nil {
+		return err
+	}
+	c.clearMergeState()
+	return nil
+}
+
+// Resets the index to cmt, and the files in the work tree which are
+// unmerged or staged relative to it, as "git reset --merge" does after a
+// merge. Other local modifications in the work tree are preserved.
+func resetMerge(c *Client, cmt CommitID) error {
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return err
+	}
+	headMap, err := GetIndexMap(c, cmt)
+	if err != nil {
+		return err
+	}
+
+	// Find everything that the merge touched, which is anything
+	// that's unmerged or staged relative to cmt.
+	changed := make(map[IndexPath]struct{})
+	oldMap := make(IndexMap)
+	for _, entry := range idx.Objects {
+		if entry.Stage() != Stage0 {
+			changed[entry.PathName] = struct{}{}
+			continue
+		}
+		oldMap[entry.PathName] = entry
+		if h, ok := headMap[entry.PathName]; !ok || h.Sha1 != entry.Sha1 || h.Mode != entry.Mode {
+			changed[entry.PathName] = struct{}{}
+		}
+	}
+	for path := range headMap {
+		if _, ok := oldMap[path]; !ok {
+			changed[path] = struct{}{}
+		}
+	}
+
+	newidx := NewIndex()
+	if err := newidx.ResetIndex(c, cmt); err != nil {
+		return err
+	}
+	for _, entry := range newidx.Objects {
+		if _, ok := changed[entry.PathName]; ok {
+			if err := checkoutFile(c, entry, CheckoutIndexOptions{Force: true, UpdateStat: true}); err != nil {
+				return err
+			}
+		} else if old, ok := oldMap[entry.PathName]; ok {
+			// Keep the stat info of untouched files so that
+			// they aren't considered modified.
+			entry.FixedIndexEntry = old.FixedIndexEntry
+		}
+	}
+	for path := range changed {
+		if _, ok := headMap[path]; ok {
+			continue
+		}
+		f, err := path.FilePath(c)
+		if err != nil {
+			return err
+		}
+		if f.Exists() {
+			if err := removeFileClean(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	f, err := c.GitDir.Create(File("index"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return newidx.WriteIndex(f)
+}
+
+// MergeContinue concludes an in progress merge after conflicts have been
+// resolved, as "git merge --continue". The merge commit is created with
+// the message from MERGE_MSG.
+func MergeContinue(c *Client, opt MergeOptions) (CommitID, error) {
+	if !c.IsMerging() {
+		return CommitID{}, fmt.Errorf("There is no merge in progress (MERGE_HEAD missing).")
+	}
+	msg, err := c.GitDir.ReadFile(mergeMsgFile)
+	if err != nil {
+		return CommitID{}, err
+	}
+	return Commit(c, CommitOptions{CleanupMode: "strip"}, CommitMessage(msg), nil)
 }
 
 // Implements the "git merge" porcelain command to merge other commits
@@ -70,6 +221,17 @@ func Merge(c *Client, opts MergeOptions, others []Commitish) error {
 	if len(others) < 1 {
 		return fmt.Errorf("Can't merge nothing.")
 	}
+	if c.IsMerging() {
+		return fmt.Errorf("You have not concluded your merge (MERGE_HEAD exists).")
+	}
+	switch opts.Strategy {
+	case "", MergeRecursive, MergeOrt:
+	default:
+		return fmt.Errorf("Merge strategy %v not implemented", opts.Strategy)
+	}
+	if opts.Squash && opts.NoFastForward {
+		return fmt.Errorf("You cannot combine --squash with --no-ff.")
+	}
 
 	head, err := c.GetHeadCommit()
 	if err != nil {
@@ -107,7 +269,7 @@ func Merge(c *Client, opts MergeOptions, others []Commitish) error {
 
 	// Check if it's a fast-forward commit. If the merge base is HEAD,
 	// it's a fast-forward
-	if base == head && !opts.NoFastForward {
+	if base == head && !opts.NoFastForward && !opts.Squash {
 		dst := others[0]
 
 		// Resolve to a CommitID to implement Treeish for ReadTree
@@ -124,6 +286,9 @@ func Merge(c *Client, opts MergeOptions, others []Commitish) error {
 		if err != nil {
 			return err
 		}
+		if err := c.GitDir.WriteFile(origHeadFile, []byte(head.String()+"\n"), 0644); err != nil {
+			return err
+		}
 		var refmsg string
 		if b, ok := others[0].(Branch); ok && b.BranchName() != "" {
 			refmsg = fmt.Sprintf("merge %s into %s: Fast-forward (dgit)", b.BranchName(), c.GetHeadBranch().BranchName())
@@ -147,13 +312,21 @@ func Merge(c *Client, opts MergeOptions, others []Commitish) error {
 	if err != nil {
 		return err
 	}
+	baseTree, err := mergeBaseTree(c, head, tree)
+	if err != nil {
+		return err
+	}
+
+	if err := c.GitDir.WriteFile(origHeadFile, []byte(head.String()+"\n"), 0644); err != nil {
+		return err
+	}
 
 	idx, err := ReadTreeThreeWay(c,
 		ReadTreeOptions{
 			Merge:  true,
 			Update: true,
 		},
-		base,
+		baseTree,
 		head,
 		tree,
 	)
@@ -161,69 +334,421 @@ func Merge(c *Client, opts MergeOptions, others []Commitish) error {
 		return err
 	}
 
-	// run MergeFile on any unmerged entries.
-	unmerged := idx.GetUnmerged()
-	if len(unmerged) != 0 {
-		// Flag conflicts in the tree if necessary.
-		conflictLabel := commitishName(others[0])
-		if conflictLabel == "" {
-			conflictLabel = tree.String()
-		}
-		var errStr string
-		for path, file := range unmerged {
-			fp, err := path.FilePath(c)
+	// Flag conflicts in the tree if necessary.
+	conflictLabel := commitishName(others[0])
+	if conflictLabel == "" {
+		conflictLabel = tree.String()
+	}
+	conflicts, err := resolveMerge(c, idx, "HEAD", conflictLabel, opts.Quiet)
+	if err != nil {
+		return err
+	}
+	if err := readtreeSaveIndex(c, ReadTreeOptions{}, idx); err != nil {
+		return err
+	}
+
+	msg := opts.Message
+	if msg == "" {
+		msg = defaultMergeMessage(c, others[0], tree)
+	}
+	if opts.Squash {
+		squash := fmt.Sprintf("Squashed commit of the following:\n\n%v\n", msg)
+		if err := c.GitDir.WriteFile(squashMsgFile, []byte(squash), 0644); err != nil {
+			return err
+		}
+		if len(conflicts) > 0 {
+			return fmt.Errorf("Automatic merge failed; fix conflicts and then commit the result.")
+		}
+		fmt.Println("Squash commit -- not updating HEAD")
+		return nil
+	}
+
+	// Record the state of the merge, so that it can be committed or
+	// aborted later.
+	if len(conflicts) > 0 {
+		msg = strings.TrimRight(msg, "\n") + "\n\n# Conflicts:\n"
+		for _, path := range conflicts {
+			msg += "#\t" + path.String() + "\n"
+		}
+	}
+	if err := c.GitDir.WriteFile(mergeHeadFile, []byte(tree.String()+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := c.GitDir.WriteFile(mergeMsgFile, []byte(msg), 0644); err != nil {
+		return err
+	}
+	var mode string
+	if opts.NoFastForward {
+		mode = "no-ff"
+	}
+	if err := c.GitDir.WriteFile(mergeModeFile, []byte(mode), 0644); err != nil {
+		return err
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("Automatic merge failed; fix conflicts and then commit the result.")
+	}
+	if opts.NoCommit {
+		fmt.Println("Automatic merge went well; stopped before committing as requested")
+		return nil
+	}
+	if _, err := Commit(c, CommitOptions{AllowEmpty: true, NoEdit: true}, CommitMessage(msg), nil); err != nil && err != NoGlobalConfig {
+		return err
+	}
+	if !opts.Quiet {
+		strategy := opts.Strategy
+		if strategy == "" {
+			strategy = MergeRecursive
+		}
+		fmt.Printf("Merge made by the '%v' strategy.\n", strategy)
+	}
+	return nil
+}
+
+// Returns the default commit message for merging other into HEAD.
+func defaultMergeMessage(c *Client, other Commitish, cmt CommitID) string {
+	var msg string
+	switch o := other.(type) {
+	case Branch:
+		msg = fmt.Sprintf("Merge branch '%v'", o.BranchName())
+	case RefSpec:
+		if strings.HasPrefix(o.String(), "refs/remotes/") {
+			msg = fmt.Sprintf("Merge remote-tracking branch '%v'", commitishName(o))
+		} else if strings.HasPrefix(o.String(), "refs/tags/") {
+			msg = fmt.Sprintf("Merge tag '%v'", commitishName(o))
+		} else {
+			msg = fmt.Sprintf("Merge commit '%v'", o.String())
+		}
+	default:
+		msg = fmt.Sprintf("Merge commit '%v'", cmt)
+	}
+	switch head := c.GetHeadBranch().BranchName(); head {
+	case "", "master", "main":
+	default:
+		msg += " into " + head
+	}
+	return msg + "\n"
+}
+
+// mergeBases returns the best common ancestors of a and b. There may be more
+// than one if there are criss-cross merges in the history.
+func mergeBases(c *Client, a, b CommitID) ([]CommitID, error) {
+	return virtualMergeBases(c, []CommitID{a}, b)
+}
+
+// virtualMergeBases returns the best common ancestors of b and a virtual
+// commit whose parents are as, oldest first as git orders them.
+func virtualMergeBases(c *Client, as []CommitID, b CommitID) ([]CommitID, error) {
+	aAncestors := make(map[CommitID]struct{})
+	for _, a := range as {
+		ancestors, err := a.AncestorMap(c)
+		if err != nil {
+			return nil, err
+		}
+		for cmt := range ancestors {
+			aAncestors[cmt] = struct{}{}
+		}
+	}
+	bAncestors, err := b.AncestorMap(c)
+	if err != nil {
+		return nil, err
+	}
+	common := make(map[CommitID]struct{})
+	for cmt := range aAncestors {
+		if _, ok := bAncestors[cmt]; ok {
+			common[cmt] = struct{}{}
+		}
+	}
+
+	// Any ancestor of a common ancestor is also a common ancestor, so
+	// the best ones are those which aren't the parent of another.
+	notBest := make(map[CommitID]struct{})
+	for cmt := range common {
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parents {
+			notBest[p] = struct{}{}
+		}
+	}
+	var bases []CommitID
+	dates := make(map[CommitID]time.Time)
+	for cmt := range common {
+		if _, ok := notBest[cmt]; !ok {
+			date, err := cmt.GetCommitterDate(c)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			bases = append(bases, cmt)
+			dates[cmt] = date
+		}
+	}
+	sort.Slice(bases, func(i, j int) bool {
+		if !dates[bases[i]].Equal(dates[bases[j]]) {
+			return dates[bases[i]].Before(dates[bases[j]])
+		}
+		return bases[i].String() < bases[j].String()
+	})
+	return bases, nil
+}
 
-			fmt.Fprintf(os.Stderr, "Auto-merging %v\n", fp)
+// mergeBaseTree returns the tree to use as the base of a three-way merge
+// of a and b. If there are multiple merge bases, they're recursively merged
+// together into a virtual tree, as the recursive strategy does.
+func mergeBaseTree(c *Client, a, b CommitID) (TreeID, error) {
+	return virtualMergeBaseTree(c, []CommitID{a}, b)
+}
 
-			// Check out the stages to temporary files for MergeFile
-			stage1tmp, _ := checkoutTemp(c, file.Stage1, CheckoutIndexOptions{})
-			defer os.Remove(stage1tmp)
-			stage2tmp, _ := checkoutTemp(c, file.Stage2, CheckoutIndexOptions{})
-			defer os.Remove(stage2tmp)
-			stage3tmp, _ := checkoutTemp(c, file.Stage3, CheckoutIndexOptions{})
-			defer os.Remove(stage3tmp)
+// virtualMergeBaseTree returns the merge base tree of b and a virtual
+// commit whose parents are as. Each merge base is merged in turn into the
+// virtual tree built from the ones before it, and the base for that merge
+// is found from the history of all of those, the same way as git.
+func virtualMergeBaseTree(c *Client, as []CommitID, b CommitID) (TreeID, error) {
+	bases, err := virtualMergeBases(c, as, b)
+	if err != nil {
+		return TreeID{}, err
+	}
+	if len(bases) == 0 {
+		return TreeID{}, fmt.Errorf("refusing to merge unrelated histories")
+	}
+	tree, err := bases[0].TreeID(c)
+	if err != nil {
+		return TreeID{}, err
+	}
+	for i, other := range bases[1:] {
+		vbase, err := virtualMergeBaseTree(c, bases[:i+1], other)
+		if err != nil {
+			return TreeID{}, err
+		}
+		otherTree, err := other.TreeID(c)
+		if err != nil {
+			return TreeID{}, err
+		}
+		tree, err = mergeTrees(c, vbase, tree, otherTree)
+		if err != nil {
+			return TreeID{}, err
+		}
+	}
+	return tree, nil
+}
 
-			// run git merge-file with the appropriate parameters.
-			r, err := MergeFile(c,
+// mergeTrees does a three-way merge of the trees entirely in memory and
+// writes the result as a new tree, which is used as a virtual merge base.
+// Conflicting content is left in the tree with conflict markers, and a
+// path which was modified on one side and deleted on the other keeps the
+// modified version.
+func mergeTrees(c *Client, base, ours, theirs TreeID) (TreeID, error) {
+	b, err := GetIndexMap(c, base)
+	if err != nil {
+		return TreeID{}, err
+	}
+	o, err := GetIndexMap(c, ours)
+	if err != nil {
+		return TreeID{}, err
+	}
+	t, err := GetIndexMap(c, theirs)
+	if err != nil {
+		return TreeID{}, err
+	}
+	paths := make(map[IndexPath]struct{})
+	for _, m := range []IndexMap{b, o, t} {
+		for path := range m {
+			paths[path] = struct{}{}
+		}
+	}
+
+	idx := NewIndex()
+	for path := range paths {
+		var entry *IndexEntry
+		switch {
+		case samePath(o, t, path):
+			entry = o[path]
+		case samePath(b, o, path):
+			entry = t[path]
+		case samePath(b, t, path):
+			entry = o[path]
+		case o[path] == nil:
+			entry = t[path]
+		case t[path] == nil:
+			entry = o[path]
+		default:
+			baseContent, err := b[path].contents(c)
+			if err != nil {
+				return TreeID{}, err
+			}
+			ourContent, err := o[path].contents(c)
+			if err != nil {
+				return TreeID{}, err
+			}
+			theirContent, err := t[path].contents(c)
+			if err != nil {
+				return TreeID{}, err
+			}
+			result, err := MergeFileContents(c,
 				MergeFileOptions{
-					Current: MergeFileFile{
-						Filename: File(stage2tmp),
-						Label:    "HEAD",
-					},
-					Base: MergeFileFile{
-						Filename: File(stage1tmp),
-						Label:    "merged common ancestors",
-					},
-					Other: MergeFileFile{
-						Filename: File(stage3tmp),
-						Label:    conflictLabel,
-					},
+					Current: MergeFileFile{Label: "Temporary merge branch 1"},
+					Other:   MergeFileFile{Label: "Temporary merge branch 2"},
 				},
+				baseContent, ourContent, theirContent,
 			)
+			if err != nil && err != MergeConflict {
+				// Binary files can't be merged, so just
+				// use ours.
+				entry = o[path]
+				break
+			}
+			sha, err := c.WriteObject("blob", result.Content)
 			if err != nil {
-				errStr += "CONFLICT (content): Merge conflict in " + fp.String() + "\n"
+				return TreeID{}, err
 			}
+			e := *o[path]
+			e.Sha1 = sha
+			e.Fsize = uint32(len(result.Content))
+			entry = &e
+		}
+		if entry != nil {
+			idx.Objects = append(idx.Objects, entry)
+		}
+	}
+	sort.Sort(ByPath(idx.Objects))
+	idx.NumberIndexEntries = uint32(len(idx.Objects))
+	return WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+}
+
+// resolveMerge merges the content of the unmerged entries that
+// ReadTreeThreeWay left in idx. Paths which merge cleanly are added to
+// idx as stage 0 and the result is written to the work tree. Paths which
+// conflict keep their stage 1, 2 and 3 entries, and the work tree gets a
+// file with conflict markers (or the surviving version, if one side deleted
+// it.)
+//
+// It returns the paths which had conflicts.
+func resolveMerge(c *Client, idx *Index, oursLabel, theirsLabel string, quiet bool) ([]IndexPath, error) {
+	unmerged := idx.GetUnmerged()
+	paths := make([]IndexPath, 0, len(unmerged))
+	for path := range unmerged {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i] < paths[j] })
 
-			// Write the output with conflict markers into the file.
-			f2, err := os.Create(fp.String())
+	report := func(format string, args ...interface{}) {
+		if !quiet {
+			fmt.Printf(format, args...)
+		}
+	}
+
+	var conflicts []IndexPath
+	for _, path := range paths {
+		file := unmerged[path]
+		switch {
+		case file.Stage2 == nil && file.Stage3 == nil:
+			// Deleted in both, so there's nothing to do but remove it.
+			if err := idx.RemoveUnmergedStages(c, path); err != nil {
+				return nil, err
+			}
+			continue
+		case file.Stage2 == nil && file.Stage1 != nil && file.Stage3.Sha1 == file.Stage1.Sha1:
+			// Deleted by us and unchanged by them.
+			if err := idx.RemoveUnmergedStages(c, path); err != nil {
+				return nil, err
+			}
+			continue
+		case file.Stage3 == nil && file.Stage1 != nil && file.Stage2.Sha1 == file.Stage1.Sha1:
+			// Deleted by them and unchanged by us.
+			if err := idx.RemoveUnmergedStages(c, path); err != nil {
+				return nil, err
+			}
+			fp, err := path.FilePath(c)
 			if err != nil {
-				return err
+				return nil, err
+			}
+			if fp.Exists() {
+				if err := removeFileClean(fp); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case file.Stage2 == nil:
+			report("CONFLICT (modify/delete): %v deleted in %v and modified in %v. Version %v of %v left in tree.\n", path, oursLabel, theirsLabel, theirsLabel, path)
+			if err := checkoutFile(c, file.Stage3, CheckoutIndexOptions{Force: true}); err != nil {
+				return nil, err
 			}
+			conflicts = append(conflicts, path)
+			continue
+		case file.Stage3 == nil:
+			report("CONFLICT (modify/delete): %v deleted in %v and modified in %v. Version %v of %v left in tree.\n", path, theirsLabel, oursLabel, oursLabel, path)
+			conflicts = append(conflicts, path)
+			continue
+		case !file.Stage2.Mode.IsRegular() || !file.Stage3.Mode.IsRegular():
+			report("CONFLICT (content): Merge conflict in %v\n", path)
+			conflicts = append(conflicts, path)
+			continue
+		}
 
-			io.Copy(f2, r)
-			f2.Close()
+		fp, err := path.FilePath(c)
+		if err != nil {
+			return nil, err
 		}
+		report("Auto-merging %v\n", path)
 
-		// Only error out if there was at least 1 conflict, otherwise it was
-		// a success.
-		if errStr != "" {
-			return fmt.Errorf("%vAutomatic merge failed; fix conflicts and then commit the result.", errStr)
+		base, err := file.Stage1.contents(c)
+		if err != nil {
+			return nil, err
+		}
+		ours, err := file.Stage2.contents(c)
+		if err != nil {
+			return nil, err
+		}
+		theirs, err := file.Stage3.contents(c)
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	// TODO: Finally, create the new commit.
-	return nil
+		// If only one side changed the mode, use the changed mode.
+		mode := file.Stage2.Mode
+		if file.Stage1 != nil && file.Stage1.Mode == mode {
+			mode = file.Stage3.Mode
+		}
+
+		result, err := MergeFileContents(c,
+			MergeFileOptions{
+				Current: MergeFileFile{Label: oursLabel},
+				Base:    MergeFileFile{Label: "merged common ancestors"},
+				Other:   MergeFileFile{Label: theirsLabel},
+			},
+			base, ours, theirs,
+		)
+		switch err {
+		case nil:
+			sha, err := c.WriteObject("blob", result.Content)
+			if err != nil {
+				return nil, err
+			}
+			if err := ioutil.WriteFile(fp.String(), result.Content, os.FileMode(mode)); err != nil {
+				return nil, err
+			}
+			os.Chmod(fp.String(), os.FileMode(mode))
+			mtime, err := fp.MTime()
+			if err != nil {
+				return nil, err
+			}
+			if err := idx.AddStage(c, path, mode, sha, Stage0, uint32(len(result.Content)), mtime, UpdateIndexOptions{Add: true}); err != nil {
+				return nil, err
+			}
+		case MergeConflict:
+			report("CONFLICT (content): Merge conflict in %v\n", path)
+			if err := ioutil.WriteFile(fp.String(), result.Content, os.FileMode(mode)); err != nil {
+				return nil, err
+			}
+			conflicts = append(conflicts, path)
+		default:
+			// Binary files can't be merged. Leave our version
+			// in the work tree.
+			report("warning: %v\n", err)
+			report("CONFLICT (content): Merge conflict in %v\n", path)
+			conflicts = append(conflicts, path)
+		}
+	}
+	return conflicts, nil
 }
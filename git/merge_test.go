@@ -0,0 +1,292 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Sets up a repo with a branch "other" which diverged from master. Both
+// branches modify foo.txt, and other also adds bar.txt. If conflict is true,
+// they modify the same line.
+func setupMergeRepo(t *testing.T, dir string, conflict bool) (*Client, Branch) {
+	t.Helper()
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	base := commitFile(t, c, "foo.txt", "1\n2\n3\n4\n5\n6\n7\n8\n", "base")
+	if err := c.CreateBranch("other", base); err != nil {
+		t.Fatal(err)
+	}
+	if conflict {
+		commitFile(t, c, "foo.txt", "1\n2\nmaster\n4\n5\n6\n7\n8\n", "master")
+	} else {
+		commitFile(t, c, "foo.txt", "1\n2\n3\n4\n5\n6\n7\nmaster\n", "master")
+	}
+
+	if err := Checkout(c, CheckoutOptions{}, "other", nil); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, c, "foo.txt", "1\n2\nother\n4\n5\n6\n7\n8\n", "other")
+	commitFile(t, c, "bar.txt", "bar\n", "bar")
+	if err := Checkout(c, CheckoutOptions{}, "master", nil); err != nil {
+		t.Fatal(err)
+	}
+	return c, Branch("refs/heads/other")
+}
+
+// Writes content to file in the current directory, adds it, and commits
+// it with the message msg.
+func commitFile(t *testing.T, c *Client, file, content, msg string) CommitID {
+	t.Helper()
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{File(file)}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(c, CommitOptions{}, CommitMessage(msg), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cmt
+}
+
+// Fails the test if file doesn't have the content want.
+func expectContent(t *testing.T, file, want string) {
+	t.Helper()
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != want {
+		t.Errorf("Unexpected content of %v: got %q want %q", file, content, want)
+	}
+}
+
+func TestMergeClean(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitmerge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, other := setupMergeRepo(t, dir, false)
+	oldHead, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Merge(c, MergeOptions{Quiet: true}, []Commitish{other}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile("foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\n2\nother\n4\n5\n6\n7\nmaster\n"; string(content) != want {
+		t.Errorf("Unexpected merged content: got %q want %q", content, want)
+	}
+	if !File("bar.txt").Exists() {
+		t.Error("bar.txt was not added by merge")
+	}
+
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents, err := head.Parents(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCmt, _ := other.CommitID(c)
+	if len(parents) != 2 || parents[0] != oldHead || parents[1] != otherCmt {
+		t.Errorf("Unexpected parents of merge commit: got %v", parents)
+	}
+	if c.IsMerging() {
+		t.Error("MERGE_HEAD still exists after merge was committed")
+	}
+	origHead, err := c.GitDir.ReadFile("ORIG_HEAD")
+	if err != nil || string(origHead) != oldHead.String()+"\n" {
+		t.Errorf("Unexpected ORIG_HEAD: got %q (%v)", origHead, err)
+	}
+}
+
+func TestMergeConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitmerge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, other := setupMergeRepo(t, dir, true)
+	oldHead, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Merge(c, MergeOptions{Quiet: true}, []Commitish{other}); err == nil {
+		t.Fatal("Expected merge to fail with a conflict")
+	}
+
+	content, err := ioutil.ReadFile("foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\n2\n<<<<<<< HEAD\nmaster\n=======\nother\n>>>>>>> other\n4\n5\n6\n7\n8\n"; string(content) != want {
+		t.Errorf("Unexpected conflicted content: got %q want %q", content, want)
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmerged := idx.GetUnmerged()
+	if u, ok := unmerged["foo.txt"]; !ok || u.Stage1 == nil || u.Stage2 == nil || u.Stage3 == nil {
+		t.Errorf("Expected stage 1, 2 and 3 entries for foo.txt")
+	}
+	if len(unmerged) != 1 {
+		t.Errorf("Unexpected unmerged entries: got %v", unmerged)
+	}
+	if !c.IsMerging() {
+		t.Fatal("MERGE_HEAD was not written")
+	}
+	if _, err := Commit(c, CommitOptions{}, "merge", nil); err == nil {
+		t.Error("Was able to commit with unmerged entries")
+	}
+
+	if err := MergeAbort(c, MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	content, err = ioutil.ReadFile("foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\n2\nmaster\n4\n5\n6\n7\n8\n"; string(content) != want {
+		t.Errorf("Unexpected content after abort: got %q want %q", content, want)
+	}
+	if File("bar.txt").Exists() {
+		t.Error("bar.txt was not removed by abort")
+	}
+	if c.IsMerging() {
+		t.Error("MERGE_HEAD still exists after abort")
+	}
+	if head, _ := c.GetHeadCommit(); head != oldHead {
+		t.Errorf("HEAD changed after abort")
+	}
+
+	// Do it again, but resolve the conflict and continue this time.
+	if err := Merge(c, MergeOptions{Quiet: true}, []Commitish{other}); err == nil {
+		t.Fatal("Expected merge to fail with a conflict")
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("resolved\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := MergeContinue(c, MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents, err := cmt.Parents(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 2 {
+		t.Errorf("Unexpected parents of merge commit: got %v", parents)
+	}
+	msg, err := cmt.GetCommitMessage(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Merge branch 'other'\n"; msg.String() != want {
+		t.Errorf("Unexpected merge commit message: got %q want %q", msg, want)
+	}
+}
+
+func TestMergeBaseTreeCrissCross(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitmergecrisscross")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("GIT_AUTHOR_DATE")
+	defer os.Unsetenv("GIT_COMMITTER_DATE")
+	// Each commit has a single file "p" with the given content.
+	commit := func(content string, date int, parents ...CommitID) CommitID {
+		t.Helper()
+		blob, err := c.WriteObject("blob", []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := MkTree(c, MkTreeOptions{}, strings.NewReader(fmt.Sprintf("100644 blob %v\tp\n", blob)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := fmt.Sprintf("%d +0000", 1500000000+date)
+		os.Setenv("GIT_AUTHOR_DATE", d)
+		os.Setenv("GIT_COMMITTER_DATE", d)
+		cmt, err := CommitTree(c, CommitTreeOptions{}, tree, parents, "msg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmt
+	}
+	// H1 and H2 both merge X1, X2 and X3, so those are all merge bases.
+	// X1 and X3 share P as a base, but X2 only shares O with either.
+	//
+	//   O - P - X1
+	//   |    \
+	//   |     X3
+	//    \
+	//     X2
+	O := commit("o\n", 100)
+	P := commit("p\n", 200, O)
+	X1 := commit("p\n", 300, P)
+	X2 := commit("o\n", 400, O)
+	X3 := commit("p3\n", 500, P)
+	H1 := commit("p3\n", 600, X1, X2, X3)
+	H2 := commit("p\n", 700, X3, X2, X1)
+
+	bases, err := mergeBases(c, H1, H2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []CommitID{X1, X2, X3}; fmt.Sprint(bases) != fmt.Sprint(want) {
+		t.Fatalf("Unexpected merge bases: got %v want %v", bases, want)
+	}
+
+	// X1 and X2 are merged into a virtual base first. X3 is then merged
+	// into that using P, the best base of X3 and the history of both X1
+	// and X2, so X3's change is taken cleanly. Using the base of X2 and
+	// X3 alone, O, would conflict.
+	tree, err := mergeBaseTree(c, H1, H2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := GetIndexMap(c, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := idx["p"]
+	if !ok {
+		t.Fatalf("p missing from virtual merge base %v", tree)
+	}
+	obj, err := c.GetObject(entry.Sha1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(obj.GetContent()); got != "p3\n" {
+		t.Errorf("Unexpected p in virtual merge base: got %q want %q", got, "p3\n")
+	}
+}
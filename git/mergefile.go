@@ -2,64 +2,136 @@ package git
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
-	"os"
-	"os/exec"
+	"io/ioutil"
+
+	"github.com/driusan/dgit/diff"
 )
 
+// MergeConflict is returned by MergeFile when the merge resulted in one or
+// more conflicts.
+var MergeConflict error = errors.New("Merge conflict")
+
 type MergeFileFile struct {
 	Filename File
 	Label    string
 }
 
+// Determines how MergeFile resolves conflicting changes.
+type MergeFileFavour uint8
+
+const (
+	// Conflicts are marked with conflict markers.
+	MergeFileFavourNone = MergeFileFavour(diff.FavorNone)
+
+	// Conflicts are resolved in favour of the current file.
+	MergeFileFavourOurs = MergeFileFavour(diff.FavorOurs)
+
+	// Conflicts are resolved in favour of the other file.
+	MergeFileFavourTheirs = MergeFileFavour(diff.FavorTheirs)
+
+	// Conflicts are resolved by including the lines from both.
+	MergeFileFavourUnion = MergeFileFavour(diff.FavorUnion)
+)
+
 type MergeFileOptions struct {
 	Current, Base, Other MergeFileFile
 
 	Quiet  bool
 	Stdout bool
-	Diff3  bool
+
+	// Use the diff3 conflict style. Equivalent to setting Style
+	// to "diff3".
+	Diff3 bool
+
+	// The conflict style to use. One of "merge", "diff3", or "zdiff3".
+	// If unset, the merge.conflictstyle config is used.
+	Style string
+
+	Favour MergeFileFavour
+
+	// The size of conflict markers. If 0, the default of 7 is used.
+	MarkerSize int
+
+	DiffAlgorithm string
+}
+
+func (opt MergeFileOptions) merge3Options(c *Client) (diff.Merge3Options, error) {
+	style := opt.Style
+	if opt.Diff3 {
+		style = "diff3"
+	} else if style == "" && c != nil {
+		style = c.GetConfig("merge.conflictstyle")
+	}
+	cs, err := diff.ParseConflictStyle(style)
+	if err != nil {
+		return diff.Merge3Options{}, err
+	}
+	alg, err := diff.ParseAlgorithm(opt.DiffAlgorithm)
+	if err != nil {
+		return diff.Merge3Options{}, err
+	}
+	return diff.Merge3Options{
+		Algorithm:   alg,
+		Style:       cs,
+		Favor:       diff.Favor(opt.Favour),
+		MarkerSize:  opt.MarkerSize,
+		OursLabel:   opt.Current.Label,
+		BaseLabel:   opt.Base.Label,
+		TheirsLabel: opt.Other.Label,
+	}, nil
 }
 
 // MergeFile merges changes that lead from opt.Base to opt.Other into opt.Current,
-// flagging conflictsas appropriate.
+// flagging conflicts as appropriate.
 //
-// This will return an io.Reader of the merged state rather than directly Current.
-//
-// BUG(driusan): this currently invokes an external diff3 tool, which needs to be
-// installed in order to work.
-func MergeFile(c *Client, opt MergeFileOptions) (io.Reader, error) {
-	var args []string
-	if opt.Current.Label != "" {
-		args = append(args, "-L", opt.Current.Label)
-	} else {
-		// Ensure there's the right amount of -L specified if base or other happen
-		// to have specified a label but current didn't.
-		args = append(args, "-L", opt.Current.Label)
+// This will return an io.Reader of the merged state rather than directly Current,
+// and the number of conflicts. If there were any conflicts, the error returned
+// will be MergeConflict and the reader will contain the content with conflict
+// markers.
+func MergeFile(c *Client, opt MergeFileOptions) (io.Reader, int, error) {
+	current, err := ioutil.ReadFile(opt.Current.Filename.String())
+	if err != nil {
+		return nil, 0, err
 	}
-
-	if opt.Base.Label != "" {
-		args = append(args, "-L", opt.Base.Label)
-	} else {
-		args = append(args, "-L", opt.Base.Label)
+	base, err := ioutil.ReadFile(opt.Base.Filename.String())
+	if err != nil {
+		return nil, 0, err
 	}
-
-	if opt.Other.Label != "" {
-		args = append(args, "-L", opt.Other.Label)
-	} else {
-		// Ensure there's the right amount of -L specified if base or other happen
-		// to have specified a label but current didn't.
-		args = append(args, "-L", opt.Other.Label)
+	other, err := ioutil.ReadFile(opt.Other.Filename.String())
+	if err != nil {
+		return nil, 0, err
 	}
+	result, err := MergeFileContents(c, opt, base, current, other)
+	if err != nil && err != MergeConflict {
+		return nil, 0, err
+	}
+	return bytes.NewReader(result.Content), len(result.Conflicts), err
+}
 
-	// FIXME: There should be a way to get output similar to -A for -X, but
-	// -X doesn't seem to include non-conflicting lines. So for now, this always
-	// uses diff3 output.
-	args = append(args, "-m", "-A")
-	args = append(args, opt.Current.Filename.String(), opt.Base.Filename.String(), opt.Other.Filename.String())
-	var output bytes.Buffer
-	diff3cmd := exec.Command(posixDiff3, args...)
-	diff3cmd.Stderr = os.Stderr
-	diff3cmd.Stdout = &output
-	err := diff3cmd.Run()
-	return &output, err
+// MergeFileContents does a three-way merge of the changes that lead from base to
+// other into current in memory. The filenames in opt are ignored, but the labels
+// and other options are used.
+//
+// If there were any conflicts, the error returned will be MergeConflict and
+// the result's Conflicts will describe them.
+func MergeFileContents(c *Client, opt MergeFileOptions, base, current, other []byte) (diff.Merge3Result, error) {
+	mopts, err := opt.merge3Options(c)
+	if err != nil {
+		return diff.Merge3Result{}, err
+	}
+	if diff.IsBinary(base) || diff.IsBinary(current) || diff.IsBinary(other) {
+		name := opt.Current.Label
+		if name == "" {
+			name = opt.Current.Filename.String()
+		}
+		return diff.Merge3Result{}, fmt.Errorf("Cannot merge binary files: %v", name)
+	}
+	result := diff.Merge3(base, current, other, mopts)
+	if len(result.Conflicts) > 0 {
+		return result, MergeConflict
+	}
+	return result, nil
 }
@@ -0,0 +1,347 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A PackedRef is a reference stored in the packed-refs file of a
+// repository, rather than as a loose file under refs/.
+type PackedRef struct {
+	Ref
+
+	// The object that an annotated tag ultimately points to, if it
+	// was recorded in the packed-refs file. The zero value if unknown
+	// or if the ref isn't an annotated tag.
+	Peeled Sha1
+}
+
+// The header written to the packed-refs file. "peeled" and "fully-peeled"
+// mean that every annotated tag has a peeled line, and "sorted" means the
+// refs are sorted by name.
+const packedRefsHeader = "# pack-refs with: peeled fully-peeled sorted \n"
+
+// ReadPackedRefs parses the packed-refs file in c's GitDir. If there is no
+// packed-refs file, it returns an empty slice without an error.
+func ReadPackedRefs(c *Client) ([]PackedRef, error) {
+	f, err := c.GitDir.Open("packed-refs")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var refs []PackedRef
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "" || line[0] == '#':
+			continue
+		case line[0] == '^':
+			// The peeled value of the previous ref.
+			if len(refs) == 0 {
+				return nil, fmt.Errorf("Invalid packed-refs file: peeled line without a ref")
+			}
+			peeled, err := Sha1FromString(line[1:])
+			if err != nil {
+				return nil, err
+			}
+			refs[len(refs)-1].Peeled = peeled
+		default:
+			pieces := strings.SplitN(line, " ", 2)
+			if len(pieces) != 2 {
+				return nil, fmt.Errorf("Invalid packed-refs line: %v", line)
+			}
+			sha, err := Sha1FromString(pieces[0])
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, PackedRef{Ref: Ref{Name: pieces[1], Value: sha}})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// WritePackedRefs replaces the packed-refs file in c's GitDir with refs.
+// The file is written to packed-refs.lock and then renamed, so that readers
+// never see a partially written file.
+func WritePackedRefs(c *Client, refs []PackedRef) error {
+	f, err := lockPackedRefs(c)
+	if err != nil {
+		return err
+	}
+	return commitPackedRefs(c, f, refs)
+}
+
+// Locks the packed-refs file by creating packed-refs.lock.
+func lockPackedRefs(c *Client) (*os.File, error) {
+	lockname := c.GitDir.File("packed-refs.lock").String()
+	f, err := os.OpenFile(lockname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("Unable to create '%v': File exists.", lockname)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Writes refs to the packed-refs lock file f, and renames it to packed-refs.
+// The lock is removed if anything goes wrong.
+func commitPackedRefs(c *Client, f *os.File, refs []PackedRef) error {
+	lockname := f.Name()
+	w := bufio.NewWriter(f)
+	writePackedRefs(w, refs)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(lockname)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(lockname)
+		return err
+	}
+	return os.Rename(lockname, c.GitDir.File("packed-refs").String())
+}
+
+// Replaces the packed-refs file with data, for a caller which holds the
+// packed-refs lock and needs to keep holding it afterwards. The data is
+// written to a temporary file which is renamed over packed-refs.
+func replacePackedRefs(c *Client, data []byte) error {
+	f, err := ioutil.TempFile(c.GitDir.String(), "packed-refs.new")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), c.GitDir.File("packed-refs").String()); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Writes refs to w in the format of the packed-refs file, sorting them by
+// name.
+func writePackedRefs(w io.Writer, refs []PackedRef) {
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+
+	fmt.Fprint(w, packedRefsHeader)
+	for _, ref := range refs {
+		fmt.Fprintf(w, "%v %v\n", ref.Value, ref.Name)
+		if ref.Peeled != (Sha1{}) {
+			fmt.Fprintf(w, "^%v\n", ref.Peeled)
+		}
+	}
+}
+
+// Returns the packed ref named name, and whether it was found.
+func (c *Client) getPackedRef(name string) (PackedRef, bool, error) {
+	refs, err := ReadPackedRefs(c)
+	if err != nil {
+		return PackedRef{}, false, err
+	}
+	i := sort.Search(len(refs), func(i int) bool { return refs[i].Name >= name })
+	if i < len(refs) && refs[i].Name == name {
+		return refs[i], true, nil
+	}
+	// Files written by other tools may not be sorted.
+	for _, ref := range refs {
+		if ref.Name == name {
+			return ref, true, nil
+		}
+	}
+	return PackedRef{}, false, nil
+}
+
+// Returns the names of all refs (loose or packed) which start with prefix,
+// sorted by name. A loose ref takes precedence over a packed ref with the
+// same name, but since only the name is returned that doesn't matter here.
+func (c *Client) refNames(prefix string) ([]string, error) {
+	names := make(map[string]struct{})
+	root := c.GitDir.File("refs").String()
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		name := filepath.ToSlash(strings.TrimPrefix(path, c.GitDir.String()+"/"))
+		if strings.HasPrefix(name, prefix) {
+			names[name] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	packed, err := ReadPackedRefs(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range packed {
+		if strings.HasPrefix(ref.Name, prefix) {
+			names[ref.Name] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// Deletes the ref named name, whether it's loose, packed, or both.
+func (c *Client) deleteRef(name string) error {
+	t := NewRefTransaction(c)
+	if err := t.Delete(name, nil, RefUpdateOptions{NoDeref: true}); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// PackRefsOptions are the options that may be passed to PackRefs.
+type PackRefsOptions struct {
+	// Pack all refs, not just tags and refs which are already packed.
+	All bool
+
+	// Remove the loose refs after packing them.
+	Prune bool
+}
+
+// PackRefs implements "git pack-refs". It moves loose refs into the
+// packed-refs file and, if Prune is set, removes the loose files.
+//
+// Without All, only tags (and any refs that are already packed) are
+// packed, since branches are expected to move.
+func PackRefs(c *Client, opts PackRefsOptions) error {
+	// Hold the lock while reading, so that a ref deleted from
+	// packed-refs by someone else can't be written back.
+	lock, err := lockPackedRefs(c)
+	if err != nil {
+		return err
+	}
+	loose, packed, err := refsToPack(c, opts)
+	if err != nil {
+		lock.Close()
+		os.Remove(lock.Name())
+		return err
+	}
+	packRefsRead()
+
+	refs := make([]PackedRef, 0, len(packed))
+	for _, ref := range packed {
+		refs = append(refs, ref)
+	}
+	if err := commitPackedRefs(c, lock, refs); err != nil {
+		return err
+	}
+	if !opts.Prune {
+		return nil
+	}
+	for _, name := range loose {
+		// Lock the ref while pruning it, and leave it alone if it
+		// was updated after we packed it.
+		lock, err := lockRef(c, name)
+		if err != nil {
+			return err
+		}
+		lock.Close()
+		val, _, err := readRefValue(c, name)
+		if err == nil && val == packed[name].Value {
+			err = os.Remove(c.GitDir.File(File(name)).String())
+		}
+		os.Remove(lock.Name())
+		if err != nil {
+			return err
+		}
+		// Clean up any directories that are now empty, but never
+		// the top level refs/heads or refs/tags.
+		for dir := filepath.Dir(name); strings.Count(dir, "/") > 1; dir = filepath.Dir(dir) {
+			if os.Remove(c.GitDir.File(File(dir)).String()) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Called by PackRefs after reading the refs to pack and before writing
+// them. Tests replace it to simulate a concurrent update.
+var packRefsRead = func() {}
+
+// Returns the names of the loose refs that PackRefs should pack, and the
+// full set of refs that packed-refs should contain afterwards. The caller
+// must hold the packed-refs lock.
+func refsToPack(c *Client, opts PackRefsOptions) ([]string, map[string]PackedRef, error) {
+	existing, err := ReadPackedRefs(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	packed := make(map[string]PackedRef)
+	for _, ref := range existing {
+		packed[ref.Name] = ref
+	}
+
+	names, err := c.refNames("refs/")
+	if err != nil {
+		return nil, nil, err
+	}
+	var loose []string
+	for _, name := range names {
+		f := c.GitDir.File(File(name))
+		if !f.Exists() {
+			continue
+		}
+		_, alreadyPacked := packed[name]
+		if !opts.All && !alreadyPacked && !strings.HasPrefix(name, "refs/tags/") {
+			continue
+		}
+		val, err := RefSpec(name).Value(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if strings.HasPrefix(val, "ref: ") {
+			// Symbolic refs can't be packed.
+			continue
+		}
+		sha, err := Sha1FromString(val)
+		if err != nil {
+			return nil, nil, err
+		}
+		ref := PackedRef{Ref: Ref{Name: name, Value: sha}}
+		if sha.Type(c) == "tag" {
+			peeled, err := RefSpec(name).CommitID(c)
+			if err == nil {
+				ref.Peeled = Sha1(peeled)
+			}
+		}
+		packed[name] = ref
+		loose = append(loose, name)
+	}
+	return loose, packed, nil
+}
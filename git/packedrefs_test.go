@@ -0,0 +1,185 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPackRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitpackrefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(c, CommitOptions{}, "initial", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBranch("other", cmt); err != nil {
+		t.Fatal(err)
+	}
+	if err := TagCommit(c, TagOptions{}, "light", cmt, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := TagCommit(c, TagOptions{Annotated: true}, "annotated", cmt, "msg\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without --all only tags are packed.
+	if err := PackRefs(c, PackRefsOptions{Prune: true}); err != nil {
+		t.Fatal(err)
+	}
+	packed, err := ReadPackedRefs(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packed) != 2 || packed[0].Name != "refs/tags/annotated" || packed[1].Name != "refs/tags/light" {
+		t.Fatalf("Unexpected packed refs: %v", packed)
+	}
+	if packed[0].Peeled != Sha1(cmt) {
+		t.Errorf("Annotated tag was not peeled: got %v want %v", packed[0].Peeled, cmt)
+	}
+	if packed[1].Peeled != (Sha1{}) {
+		t.Errorf("Lightweight tag should not be peeled")
+	}
+	if c.GitDir.File("refs/tags/light").Exists() {
+		t.Errorf("Loose tag was not pruned")
+	}
+
+	if err := PackRefs(c, PackRefsOptions{All: true, Prune: true}); err != nil {
+		t.Fatal(err)
+	}
+	if c.GitDir.File("refs/heads/other").Exists() {
+		t.Errorf("Loose branch was not pruned")
+	}
+
+	// Packed refs must still resolve everywhere.
+	if id, err := RevParseCommitish(c, &RevParseOptions{}, "light"); err != nil {
+		t.Error(err)
+	} else if got, _ := id.CommitID(c); got != cmt {
+		t.Errorf("Unexpected value for light: got %v want %v", got, cmt)
+	}
+	if b, err := GetBranch(c, "other"); err != nil {
+		t.Error(err)
+	} else if got, _ := b.CommitID(c); got != cmt {
+		t.Errorf("Unexpected value for other: got %v want %v", got, cmt)
+	}
+	if head, err := c.GetHeadCommit(); err != nil || head != cmt {
+		t.Errorf("Unexpected HEAD: got %v (%v)", head, err)
+	}
+	refs, err := ShowRef(c, ShowRefOptions{Dereference: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"refs/heads/master", "refs/heads/other", "refs/tags/annotated", "refs/tags/annotated^{}", "refs/tags/light"}
+	if len(refs) != len(want) {
+		t.Fatalf("Unexpected show-ref output: got %v", refs)
+	}
+	for i, r := range refs {
+		if r.Name != want[i] {
+			t.Errorf("Unexpected ref %d: got %v want %v", i, r.Name, want[i])
+		}
+	}
+
+	// A loose ref takes precedence over the packed one.
+	cmt2, err := Commit(c, CommitOptions{AllowEmpty: true}, "second", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head, err := c.GetHeadCommit(); err != nil || head != cmt2 {
+		t.Errorf("Loose ref did not take precedence: got %v (%v)", head, err)
+	}
+
+	// Deleting removes the packed entry.
+	if err := TagDelete(c, TagOptions{Delete: true}, []Refname{"light"}); err != nil {
+		t.Fatal(err)
+	}
+	if RefSpec("refs/tags/light").Exists(c) {
+		t.Errorf("Tag still exists after deletion")
+	}
+	tags, err := TagList(c, TagOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0] != "annotated" {
+		t.Errorf("Unexpected tags: got %v", tags)
+	}
+}
+
+func TestPackRefsConcurrentDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitpackrefsdelete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(c, CommitOptions{AllowEmpty: true}, "initial", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := TagCommit(c, TagOptions{}, "packed", cmt, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := PackRefs(c, PackRefsOptions{Prune: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := TagCommit(c, TagOptions{}, "loose", cmt, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete both tags after PackRefs has read the refs, but before it
+	// writes packed-refs. Either the deletions must be refused, or the
+	// tags must stay deleted.
+	defer func() { packRefsRead = func() {} }()
+	var errs [2]error
+	packRefsRead = func() {
+		errs[0] = c.deleteRef("refs/tags/packed")
+		errs[1] = c.deleteRef("refs/tags/loose")
+	}
+	if err := PackRefs(c, PackRefsOptions{Prune: true}); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"refs/tags/packed", "refs/tags/loose"} {
+		if errs[i] == nil && RefSpec(name).Exists(c) {
+			t.Errorf("%v was written back after being deleted", name)
+		}
+		if errs[i] != nil && !RefSpec(name).Exists(c) {
+			t.Errorf("%v was deleted even though deleting it failed", name)
+		}
+	}
+	if c.GitDir.File("packed-refs.lock").Exists() {
+		t.Error("packed-refs.lock was left behind")
+	}
+
+	// Without anything else holding the lock, they can be deleted.
+	for _, name := range []string{"refs/tags/packed", "refs/tags/loose"} {
+		if err := c.deleteRef(name); err != nil {
+			t.Fatal(err)
+		}
+		if RefSpec(name).Exists(c) {
+			t.Errorf("%v still exists after deletion", name)
+		}
+	}
+}
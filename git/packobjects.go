@@ -0,0 +1,548 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/driusan/dgit/zlib"
+)
+
+// PackObjectsOptions are the options that may be passed to PackObjects.
+type PackObjectsOptions struct {
+	// The number of objects to consider as a delta base for each
+	// object. 0 disables searching for new deltas.
+	Window int
+
+	// The maximum length of a delta chain.
+	Depth int
+
+	// Write deltas as OFS_DELTA entries, which refer to their base by
+	// its offset in the pack, rather than REF_DELTA entries which refer
+	// to their base by its Sha1.
+	DeltaBaseOffset bool
+
+	// Don't reuse deltas from existing packfiles.
+	NoReuseDelta bool
+
+	// The names (usually paths) of objects. They're used as a hint to
+	// sort objects so that similar objects are in the same window, but
+	// are optional.
+	Names map[Sha1]string
+
+	// Display progress information on stderr.
+	Progress bool
+}
+
+// A packEntry is an object being written to a packfile by PackObjects.
+type packEntry struct {
+	sha      Sha1
+	typ      PackEntryType
+	size     uint64
+	nameHash uint32
+
+	// The object that this is stored as a delta against, if any, and
+	// the length of the delta chain.
+	base  *packEntry
+	depth int
+
+	// The uncompressed delta against base, if it was computed.
+	delta []byte
+
+	// The compressed data and the size from the entry header of an
+	// existing packfile entry that is being copied as is. If base is
+	// set, it's a delta against base.
+	reuse     []byte
+	reuseSize uint64
+
+	// Set if a reused delta depends on this object.
+	hasDependents bool
+
+	// Where the entry was written in the packfile and the CRC32 of the
+	// entry.
+	offset  int64
+	crc     uint32
+	written bool
+}
+
+// Calculates the length of the delta chain of e, removing any deltas that
+// are too deep or that form a cycle.
+func (e *packEntry) resolveDepth(maxDepth int, visiting map[*packEntry]bool) int {
+	if e.base == nil {
+		return 0
+	}
+	if e.depth > 0 {
+		return e.depth
+	}
+	if visiting[e] {
+		e.base, e.reuse = nil, nil
+		return 0
+	}
+	visiting[e] = true
+	depth := e.base.resolveDepth(maxDepth, visiting) + 1
+	delete(visiting, e)
+	if e.base == nil {
+		// A cycle was broken at e.
+		return 0
+	}
+	if depth > maxDepth {
+		e.base, e.reuse = nil, nil
+		return 0
+	}
+	e.depth = depth
+	return depth
+}
+
+// Hashes name so that names which end the same way sort near each other.
+// This is the same hash that git uses, so that the same file in different
+// directories is considered for deltas.
+func packNameHash(name string) uint32 {
+	var hash uint32
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			continue
+		}
+		hash = (hash >> 2) + (uint32(c) << 24)
+	}
+	return hash
+}
+
+// An existing packfile that objects may be copied from.
+type reusePack struct {
+	f    *os.File
+	size int64
+	idx  *PackfileIndexV2
+
+	// The objects in the pack, and the offsets of every entry in the
+	// pack, in order, so that the length of an entry can be determined.
+	byOffset map[int64]Sha1
+	offsets  []int64
+}
+
+// Returns the offset of the i'th object in the index.
+func (idx *PackfileIndexV2) entryOffset(i int) int64 {
+	if idx.FourByteOffsets[i]&(1<<31) != 0 {
+		return int64(idx.EightByteOffsets[idx.FourByteOffsets[i]^(1<<31)])
+	}
+	return int64(idx.FourByteOffsets[i])
+}
+
+// Finds the raw data of objects in existing packfiles so that it can be
+// reused.
+type packReuser struct {
+	c     *Client
+	packs map[File]*reusePack
+}
+
+func (r *packReuser) open(pfile File, idx *PackfileIndexV2) (*reusePack, error) {
+	if p, ok := r.packs[pfile]; ok {
+		return p, nil
+	}
+	f, err := os.Open((pfile + ".pack").String())
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	p := &reusePack{
+		f:        f,
+		size:     stat.Size(),
+		idx:      idx,
+		byOffset: make(map[int64]Sha1),
+	}
+	for i, sha := range idx.Sha1Table {
+		offset := idx.entryOffset(i)
+		p.byOffset[offset] = sha
+		p.offsets = append(p.offsets, offset)
+	}
+	sort.Slice(p.offsets, func(i, j int) bool { return p.offsets[i] < p.offsets[j] })
+	r.packs[pfile] = p
+	return p, nil
+}
+
+// Returns the raw entry for sha from the packfile which contains it. typ is
+// the type from the entry header, and base is set if it's a delta. ok is
+// false if the object isn't in a pack or its data appears to be corrupt.
+func (r *packReuser) entry(sha Sha1) (typ PackEntryType, size uint64, base Sha1, data []byte, ok bool) {
+	if found, _, err := r.c.HaveObject(sha); !found || err != nil {
+		return
+	}
+	loc, cached := r.c.objectCache[sha]
+	if !cached || loc.loose || loc.index == nil {
+		return
+	}
+	p, err := r.open(loc.packfile, loc.index)
+	if err != nil {
+		return
+	}
+	i := sort.Search(len(p.offsets), func(i int) bool { return p.offsets[i] > loc.offset })
+	end := p.size - 20
+	if i < len(p.offsets) {
+		end = p.offsets[i]
+	}
+
+	var header PackfileHeader
+	t, sz, ref, refoffset, rawheader := header.ReadHeaderSize(io.NewSectionReader(p.f, loc.offset, 4096))
+	raw := make([]byte, end-loc.offset)
+	if _, err := p.f.ReadAt(raw, loc.offset); err != nil {
+		return
+	}
+
+	// Make sure that the data isn't corrupt before copying it.
+	for j, s := range p.idx.Sha1Table {
+		if s == sha {
+			if crc32.ChecksumIEEE(raw) != p.idx.CRC32[j] {
+				return
+			}
+			break
+		}
+	}
+
+	switch t {
+	case OBJ_OFS_DELTA:
+		b, found := p.byOffset[loc.offset-int64(refoffset)]
+		if !found {
+			return
+		}
+		base = b
+	case OBJ_REF_DELTA:
+		base = ref
+	}
+	return t, uint64(sz), base, raw[len(rawheader):], true
+}
+
+func (r *packReuser) Close() {
+	for _, p := range r.packs {
+		p.f.Close()
+	}
+}
+
+// Counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Returns data compressed with zlib.
+func compressPackData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Writes the offset of a delta base in the format used by OFS_DELTA entries.
+func writeDeltaOffset(w io.Writer, offset int64) error {
+	var buf [10]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(offset & 0x7f)
+	for offset >>= 7; offset != 0; offset >>= 7 {
+		offset--
+		pos--
+		buf[pos] = 0x80 | byte(offset&0x7f)
+	}
+	_, err := w.Write(buf[pos:])
+	return err
+}
+
+// PackObjects implements "git pack-objects". It writes a packfile to w
+// containing objects, and returns the index of the packfile that was
+// written.
+//
+// Existing deltas and compressed data in packfiles are copied as is where
+// possible. The rest of the objects are sorted by type, name and size, and
+// each object is compared against the previous opts.Window objects of the
+// same type to find a delta base. Delta bases are always written before
+// the objects that depend on them.
+func PackObjects(c *Client, opts PackObjectsOptions, w io.Writer, objects []Sha1) (PackfileIndex, error) {
+	entries := make([]*packEntry, 0, len(objects))
+	bySha := make(map[Sha1]*packEntry)
+	for _, sha := range objects {
+		if _, ok := bySha[sha]; ok {
+			continue
+		}
+		t, sz, err := c.GetObjectMetadata(sha)
+		if err != nil {
+			return nil, err
+		}
+		e := &packEntry{sha: sha, size: sz}
+		switch t {
+		case "commit":
+			e.typ = OBJ_COMMIT
+		case "tree":
+			e.typ = OBJ_TREE
+		case "blob":
+			e.typ = OBJ_BLOB
+		case "tag":
+			e.typ = OBJ_TAG
+		default:
+			return nil, fmt.Errorf("Unknown type %v for object %v", t, sha)
+		}
+		if name, ok := opts.Names[sha]; ok {
+			e.nameHash = packNameHash(name)
+		}
+		bySha[sha] = e
+		entries = append(entries, e)
+	}
+
+	// Find everything that can be copied from existing packs. Deltas can
+	// only be reused if their base is also in this pack.
+	reuser := &packReuser{c: c, packs: make(map[File]*reusePack)}
+	defer reuser.Close()
+	for _, e := range entries {
+		typ, size, base, data, ok := reuser.entry(e.sha)
+		if !ok {
+			continue
+		}
+		switch typ {
+		case OBJ_OFS_DELTA, OBJ_REF_DELTA:
+			b, ok := bySha[base]
+			if opts.NoReuseDelta || !ok {
+				continue
+			}
+			e.base = b
+		}
+		e.reuse, e.reuseSize = data, size
+	}
+	visiting := make(map[*packEntry]bool)
+	for _, e := range entries {
+		e.resolveDepth(opts.Depth, visiting)
+	}
+	for _, e := range entries {
+		if e.base != nil {
+			e.base.hasDependents = true
+		}
+	}
+
+	if opts.Window > 0 {
+		if err := findDeltas(c, opts, entries); err != nil {
+			return nil, err
+		}
+	}
+
+	// Write the pack.
+	trailer := sha1.New()
+	cw := &countingWriter{w: io.MultiWriter(w, trailer)}
+	if _, err := cw.Write([]byte{'P', 'A', 'C', 'K'}); err != nil {
+		return nil, err
+	}
+	binary.Write(cw, binary.BigEndian, uint32(2))
+	if err := binary.Write(cw, binary.BigEndian, uint32(len(entries))); err != nil {
+		return nil, err
+	}
+
+	written := 0
+	var write func(e *packEntry) error
+	write = func(e *packEntry) error {
+		if e.written {
+			return nil
+		}
+		if e.base != nil {
+			if err := write(e.base); err != nil {
+				return err
+			}
+		}
+		if err := writePackEntry(c, opts, cw, e); err != nil {
+			return err
+		}
+		written++
+		if opts.Progress {
+			progressF("Writing objects: %2.f%% (%d/%d)", float32(written)/float32(len(entries))*100, written, len(entries))
+		}
+		return nil
+	}
+	for _, e := range entries {
+		if err := write(e); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Progress && len(entries) > 0 {
+		progressF("Writing objects: 100%% (%d/%d), done.\n", len(entries), len(entries))
+	}
+	packsha, err := Sha1FromSlice(trailer.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(packsha[:]); err != nil {
+		return nil, err
+	}
+
+	// Build the index.
+	idx := &PackfileIndexV2{
+		magic:           [4]byte{0377, 't', 'O', 'c'},
+		Version:         2,
+		Sha1Table:       make([]Sha1, len(entries)),
+		CRC32:           make([]uint32, len(entries)),
+		FourByteOffsets: make([]uint32, len(entries)),
+		Packfile:        packsha,
+	}
+	var counts [256]uint32
+	for i, e := range entries {
+		idx.Sha1Table[i] = e.sha
+		idx.CRC32[i] = e.crc
+		if e.offset < (1 << 31) {
+			idx.FourByteOffsets[i] = uint32(e.offset)
+		} else {
+			idx.FourByteOffsets[i] = uint32(len(idx.EightByteOffsets)) | (1 << 31)
+			idx.EightByteOffsets = append(idx.EightByteOffsets, uint64(e.offset))
+		}
+		counts[e.sha[0]]++
+	}
+	var total uint32
+	for i, n := range counts {
+		total += n
+		idx.Fanout[i] = total
+	}
+	sort.Sort(idx)
+	if err := idx.calculateTrailer(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Searches for new deltas for the objects in entries which aren't already
+// deltas.
+func findDeltas(c *Client, opts PackObjectsOptions, entries []*packEntry) error {
+	sorted := make([]*packEntry, 0, len(entries))
+	for _, e := range entries {
+		// Tags are rarely similar enough to be worth it.
+		if e.typ != OBJ_TAG {
+			sorted = append(sorted, e)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.typ != b.typ {
+			return a.typ < b.typ
+		}
+		if a.nameHash != b.nameHash {
+			return a.nameHash < b.nameHash
+		}
+		return a.size > b.size
+	})
+
+	// The content of the objects in the window.
+	contents := make(map[*packEntry][]byte)
+	for i, e := range sorted {
+		if opts.Progress {
+			progressF("Compressing objects: %2.f%% (%d/%d)", float32(i+1)/float32(len(sorted))*100, i+1, len(sorted))
+		}
+		if i >= opts.Window {
+			delete(contents, sorted[i-opts.Window])
+		}
+		obj, err := c.GetObject(e.sha)
+		if err != nil {
+			return err
+		}
+		target := obj.GetContent()
+		contents[e] = target
+
+		// Objects which are already deltas, or which reused deltas
+		// depend on, are left alone. Tiny objects aren't worth it.
+		if e.base != nil || e.hasDependents || len(target) < 50 {
+			continue
+		}
+		maxSize := len(target)/2 - 20
+		var best *packEntry
+		var bestDelta []byte
+		for j := i - 1; j >= 0 && j >= i-opts.Window; j-- {
+			b := sorted[j]
+			if b.typ != e.typ || b.depth >= opts.Depth {
+				continue
+			}
+			base := contents[b]
+			if len(base) < len(target)/32 {
+				continue
+			}
+			delta := createDelta(base, target)
+			if len(delta) < maxSize && (best == nil || len(delta) < len(bestDelta)) {
+				best, bestDelta = b, delta
+			}
+		}
+		if best != nil {
+			e.base, e.delta, e.depth = best, bestDelta, best.depth+1
+			e.reuse = nil
+		}
+	}
+	if opts.Progress && len(sorted) > 0 {
+		progressF("Compressing objects: 100%% (%d/%d), done.\n", len(sorted), len(sorted))
+	}
+	return nil
+}
+
+// Writes a single entry to the packfile, recording its offset and CRC32.
+func writePackEntry(c *Client, opts PackObjectsOptions, cw *countingWriter, e *packEntry) error {
+	e.offset = cw.n
+	typ := e.typ
+	var size uint64
+	var data []byte
+	switch {
+	case e.reuse != nil:
+		data, size = e.reuse, e.reuseSize
+	case e.base != nil:
+		compressed, err := compressPackData(e.delta)
+		if err != nil {
+			return err
+		}
+		data, size = compressed, uint64(len(e.delta))
+	default:
+		obj, err := c.GetObject(e.sha)
+		if err != nil {
+			return err
+		}
+		content := obj.GetContent()
+		compressed, err := compressPackData(content)
+		if err != nil {
+			return err
+		}
+		data, size = compressed, uint64(len(content))
+	}
+	if e.base != nil {
+		typ = OBJ_REF_DELTA
+		if opts.DeltaBaseOffset {
+			typ = OBJ_OFS_DELTA
+		}
+	}
+
+	crc := crc32.NewIEEE()
+	w := io.MultiWriter(cw, crc)
+	if err := VariableLengthInt(size).WriteVariable(w, typ); err != nil {
+		return err
+	}
+	switch typ {
+	case OBJ_OFS_DELTA:
+		if err := writeDeltaOffset(w, e.offset-e.base.offset); err != nil {
+			return err
+		}
+	case OBJ_REF_DELTA:
+		if _, err := w.Write(e.base.sha[:]); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	e.crc = crc.Sum32()
+	e.written = true
+	return nil
+}
@@ -0,0 +1,128 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateDelta(t *testing.T) {
+	base := []byte("The quick brown fox jumps over the lazy dog.\nThe quick brown fox jumps over the lazy dog again.\n")
+	tests := [][]byte{
+		base,
+		[]byte("short"),
+		append([]byte("prefix "), base...),
+		append(append([]byte{}, base...), " suffix"...),
+		bytes.Repeat(base, 3000),
+		[]byte("The quick brown cat jumps over the lazy dog.\nThe quick brown fox jumps over the lazy dog again.\n"),
+	}
+	for i, target := range tests {
+		delta := createDelta(base, target)
+		_, got, err := calculateDelta(resolvedDelta{Value: base, Type: OBJ_BLOB}, delta)
+		if err != nil {
+			t.Errorf("Test %d: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(got, target) {
+			t.Errorf("Test %d: delta did not reproduce target: got %q", i, got)
+		}
+	}
+}
+
+// Writes the objects to a pack in a new repository in dir, and checks that
+// they can all be read back.
+func checkPackObjects(t *testing.T, c *Client, opts PackObjectsOptions, objects []Sha1, dir string) (*Client, int) {
+	t.Helper()
+	var pack bytes.Buffer
+	idx, err := PackObjects(c, opts, &pack, objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idxbuf bytes.Buffer
+	if err := idx.WriteIndex(&idxbuf); err != nil {
+		t.Fatal(err)
+	}
+
+	// IndexPack should agree with the index that was returned. This
+	// also copies the pack into c2.
+	idx2, err := IndexPack(c2, IndexPackOptions{}, bytes.NewReader(pack.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected bytes.Buffer
+	if err := idx2.WriteIndex(&expected); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected.Bytes(), idxbuf.Bytes()) {
+		t.Error("Index returned by PackObjects does not match IndexPack")
+	}
+
+	// Use our own index to read the objects back.
+	packsha, _ := idx.GetTrailer()
+	base := filepath.Join(c2.GitDir.File("objects/pack").String(), "pack-"+packsha.String())
+	if err := ioutil.WriteFile(base+".idx", idxbuf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sha := range objects {
+		want, err := c.GetObject(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c2.GetObject(sha)
+		if err != nil {
+			t.Errorf("Could not read %v from pack: %v", sha, err)
+			continue
+		}
+		if got.GetType() != want.GetType() || !bytes.Equal(got.GetContent(), want.GetContent()) {
+			t.Errorf("Object %v was not the same after packing", sha)
+		}
+	}
+	return c2, pack.Len()
+}
+
+func TestPackObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitpackobjects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objects []Sha1
+	var content bytes.Buffer
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&content, "This is line %d of a file that keeps growing.\n", i)
+		sha, err := c.WriteObject("blob", content.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, sha)
+	}
+
+	_, wholeSize := checkPackObjects(t, c, PackObjectsOptions{}, objects, filepath.Join(dir, "whole"))
+	for _, ofs := range []bool{false, true} {
+		opts := PackObjectsOptions{Window: 10, Depth: 50, DeltaBaseOffset: ofs}
+		c2, size := checkPackObjects(t, c, opts, objects, filepath.Join(dir, fmt.Sprintf("delta%v", ofs)))
+		if size >= wholeSize {
+			t.Errorf("Delta compressed pack was not smaller: got %d want < %d", size, wholeSize)
+		}
+
+		// Packing again from the new pack should reuse the deltas.
+		_, reusedSize := checkPackObjects(t, c2, PackObjectsOptions{DeltaBaseOffset: ofs, Depth: 50}, objects, filepath.Join(dir, fmt.Sprintf("reuse%v", ofs)))
+		if reusedSize != size {
+			t.Errorf("Unexpected size when reusing deltas: got %d want %d", reusedSize, size)
+		}
+	}
+}
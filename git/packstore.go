@@ -0,0 +1,235 @@
+package git
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A packStore keeps the indexes of the packfiles in a repository in memory,
+// so that objects can be found with a binary search instead of re-reading
+// every index file for each lookup.
+type packStore struct {
+	packs []storedPack
+
+	// The modification time of the objects/pack directory when it was
+	// last scanned, so that packs which appear while we're running are
+	// noticed.
+	scanned time.Time
+	loaded  bool
+}
+
+// A storedPack is a packfile whose index has been loaded into memory.
+type storedPack struct {
+	// The name of the packfile, without the .pack or .idx extension.
+	name File
+	idx  *PackfileIndexV2
+}
+
+// Reads a version 2 pack index from r.
+func readPackfileIndexV2(r io.Reader) (*PackfileIndexV2, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// magic, version and the fanout table, followed by the pack and
+	// index trailers at the end.
+	if len(data) < 8+256*4+40 {
+		return nil, fmt.Errorf("Pack index is too short")
+	}
+	idx := &PackfileIndexV2{}
+	copy(idx.magic[:], data[:4])
+	idx.Version = binary.BigEndian.Uint32(data[4:8])
+	if idx.magic != [4]byte{0377, 't', 'O', 'c'} || idx.Version != 2 {
+		return nil, fmt.Errorf("Unsupported pack index version")
+	}
+	pos := 8
+	for i := range idx.Fanout {
+		idx.Fanout[i] = binary.BigEndian.Uint32(data[pos:])
+		pos += 4
+	}
+
+	n := int(idx.Fanout[255])
+	if len(data) < pos+n*(20+4+4)+40 {
+		return nil, fmt.Errorf("Pack index is too short")
+	}
+	idx.Sha1Table = make([]Sha1, n)
+	for i := range idx.Sha1Table {
+		copy(idx.Sha1Table[i][:], data[pos:pos+20])
+		pos += 20
+	}
+	idx.CRC32 = make([]uint32, n)
+	for i := range idx.CRC32 {
+		idx.CRC32[i] = binary.BigEndian.Uint32(data[pos:])
+		pos += 4
+	}
+	idx.FourByteOffsets = make([]uint32, n)
+	large := 0
+	for i := range idx.FourByteOffsets {
+		idx.FourByteOffsets[i] = binary.BigEndian.Uint32(data[pos:])
+		if idx.FourByteOffsets[i]&(1<<31) != 0 {
+			large++
+		}
+		pos += 4
+	}
+	if len(data) < pos+large*8+40 {
+		return nil, fmt.Errorf("Pack index is too short")
+	}
+	for i := 0; i < large; i++ {
+		idx.EightByteOffsets = append(idx.EightByteOffsets, binary.BigEndian.Uint64(data[pos:]))
+		pos += 8
+	}
+	copy(idx.Packfile[:], data[pos:pos+20])
+	copy(idx.IdxFile[:], data[pos+20:pos+40])
+	return idx, nil
+}
+
+// Rescans the objects/pack directory if it changed since the last time it
+// was scanned. Indexes which were already loaded are not re-read. It
+// returns true if the set of packs changed.
+func (s *packStore) refresh(c *Client) (bool, error) {
+	packdir := c.GitDir.File("objects/pack").String()
+	fi, err := os.Stat(packdir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+		changed := len(s.packs) != 0
+		s.packs = nil
+		s.scanned = time.Time{}
+		s.loaded = true
+		return changed, nil
+	}
+	if s.loaded && fi.ModTime().Equal(s.scanned) {
+		return false, nil
+	}
+
+	files, err := ioutil.ReadDir(packdir)
+	if err != nil {
+		return false, err
+	}
+	old := make(map[File]*PackfileIndexV2)
+	for _, p := range s.packs {
+		old[p.name] = p.idx
+	}
+	changed := false
+	var packs []storedPack
+	for _, f := range files {
+		if filepath.Ext(f.Name()) != ".idx" {
+			continue
+		}
+		name := File(filepath.Join(packdir, strings.TrimSuffix(f.Name(), ".idx")))
+		if idx, ok := old[name]; ok {
+			packs = append(packs, storedPack{name, idx})
+			delete(old, name)
+			continue
+		}
+		fidx, err := os.Open(name.String() + ".idx")
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		idx, err := readPackfileIndexV2(fidx)
+		fidx.Close()
+		if err != nil {
+			log.Printf("Could not read index for %v: %v", name, err)
+			continue
+		}
+		packs = append(packs, storedPack{name, idx})
+		changed = true
+	}
+	if len(old) != 0 {
+		changed = true
+	}
+	s.packs = packs
+	s.scanned = fi.ModTime()
+	s.loaded = true
+	return changed, nil
+}
+
+// Returns the packs in the repository.
+func (s *packStore) list(c *Client) ([]storedPack, error) {
+	if _, err := s.refresh(c); err != nil {
+		return nil, err
+	}
+	return s.packs, nil
+}
+
+// Finds the pack containing sha and the offset of the object in it. If
+// sha isn't in any of the packs that we know about, the pack directory is
+// rescanned in case a new pack was added.
+func (s *packStore) find(c *Client, sha Sha1) (pack storedPack, offset int64, found bool, err error) {
+	if !s.loaded {
+		if _, err := s.refresh(c); err != nil {
+			return storedPack{}, 0, false, err
+		}
+	}
+	for {
+		for _, p := range s.packs {
+			if i, ok := p.idx.findObject(sha); ok {
+				return p, p.idx.entryOffset(i), true, nil
+			}
+		}
+		changed, err := s.refresh(c)
+		if err != nil || !changed {
+			return storedPack{}, 0, false, err
+		}
+	}
+}
+
+// Returns the objects in any pack whose hex representation starts with
+// prefix.
+func (s *packStore) findPrefix(c *Client, prefix string) ([]Sha1, error) {
+	packs, err := s.list(c)
+	if err != nil {
+		return nil, err
+	}
+	// The smallest object ID which could match is the prefix padded
+	// with zeros.
+	start, err := Sha1FromString(prefix + strings.Repeat("0", 40-len(prefix)))
+	if err != nil {
+		return nil, err
+	}
+	var matches []Sha1
+	for _, p := range packs {
+		i, _ := p.idx.findObject(start)
+		for ; i < len(p.idx.Sha1Table); i++ {
+			if !strings.HasPrefix(p.idx.Sha1Table[i].String(), prefix) {
+				break
+			}
+			matches = append(matches, p.idx.Sha1Table[i])
+		}
+	}
+	return matches, nil
+}
+
+// Forgets about all the loaded indexes, so that packs which were removed
+// aren't used.
+func (s *packStore) reset() {
+	*s = packStore{}
+}
+
+// Returns the position of s in the index, using a binary search of the
+// entries with the same first byte. If s isn't in the index, the position
+// that it would be inserted at is returned.
+func (idx *PackfileIndexV2) findObject(s Sha1) (int, bool) {
+	lo := 0
+	if s[0] > 0 {
+		lo = int(idx.Fanout[s[0]-1])
+	}
+	hi := int(idx.Fanout[s[0]])
+	if hi > len(idx.Sha1Table) || lo > hi {
+		return 0, false
+	}
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return string(idx.Sha1Table[lo+i][:]) >= string(s[:])
+	})
+	return i, i < hi && idx.Sha1Table[i] == s
+}
@@ -0,0 +1,87 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitpackstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objects []Sha1
+	for i := 0; i < 300; i++ {
+		sha, err := src.WriteObject("blob", []byte(fmt.Sprintf("blob %d\n", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, sha)
+	}
+
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Look up an object before there are any packs, so that the store
+	// has to notice the pack when it's added.
+	if have, _, err := c.HaveObject(objects[0]); err != nil || have {
+		t.Fatalf("Unexpected object before pack was added: %v %v", have, err)
+	}
+
+	var pack bytes.Buffer
+	if _, err := PackObjects(src, PackObjectsOptions{}, &pack, objects[:200]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IndexPack(c, IndexPackOptions{}, bytes.NewReader(pack.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, sha := range objects {
+		have, packfile, err := c.HaveObject(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i < 200; have != want {
+			t.Errorf("Object %d (%v): got have %v want %v", i, sha, have, want)
+		}
+		if have && packfile == "" {
+			t.Errorf("Object %d (%v) was not found in a pack", i, sha)
+		}
+	}
+	obj, err := c.GetObject(objects[42])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(obj.GetContent()); got != "blob 42\n" {
+		t.Errorf("Unexpected content: got %q", got)
+	}
+
+	// Prefix lookups should find exactly the objects with the prefix.
+	for _, sha := range objects[:200] {
+		prefix := sha.String()[:5]
+		var want []Sha1
+		for _, o := range objects[:200] {
+			if o.String()[:5] == prefix {
+				want = append(want, o)
+			}
+		}
+		got, err := c.packs.findPrefix(c, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("Prefix %v: got %v want %v", prefix, got, want)
+		}
+	}
+}
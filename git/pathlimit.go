@@ -0,0 +1,331 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A pathLimiter decides which commits in a rev-list walk change the paths
+// in RevListOptions.Paths, and which parents of each commit should be
+// walked to simplify the history, in the same way as git does.
+type pathLimiter struct {
+	c   *Client
+	opt RevListOptions
+
+	// The paths being limited to. With Follow, this changes to the
+	// name of the file before it was renamed as the walk goes past the
+	// rename.
+	paths []IndexPath
+
+	// The parents of each commit that have been walked.
+	walked map[CommitID][]CommitID
+
+	// Whether each commit has the same content at the paths as each
+	// of the parents that was walked. A root commit has a single
+	// entry comparing it to the empty tree.
+	sameAs map[CommitID][]bool
+
+	// The commits which are left after simplifying merges, if
+	// SimplifyMerges is set.
+	simplified map[CommitID]bool
+}
+
+func newPathLimiter(c *Client, opt RevListOptions) (*pathLimiter, error) {
+	if opt.Follow && len(opt.Paths) != 1 {
+		return nil, fmt.Errorf("fatal: --follow requires exactly one pathspec")
+	}
+	paths := make([]IndexPath, len(opt.Paths))
+	for i, p := range opt.Paths {
+		paths[i] = cleanPathspec(p)
+	}
+	return &pathLimiter{
+		c:      c,
+		opt:    opt,
+		paths:  paths,
+		walked: make(map[CommitID][]CommitID),
+		sameAs: make(map[CommitID][]bool),
+	}, nil
+}
+
+// Converts a path to the form used by treeEntryAt, with "." or an empty
+// path referring to the whole tree.
+func cleanPathspec(p IndexPath) IndexPath {
+	s := strings.Trim(string(p), "/")
+	if s == "." {
+		return ""
+	}
+	return IndexPath(s)
+}
+
+// Returns true if name is one of paths, or inside of one of them. Every
+// name is if there are no paths.
+func inPathspecs(name IndexPath, paths []IndexPath) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		if p == "" || name == p || strings.HasPrefix(string(name), string(p)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the entry for path in the tree t, looking up each directory
+// in the path in turn instead of reading the whole tree. If the path
+// doesn't exist, the zero TreeEntry is returned. The zero TreeID is
+// treated as an empty tree.
+func treeEntryAt(c *Client, t TreeID, path IndexPath) (TreeEntry, error) {
+	if t == (TreeID{}) {
+		return TreeEntry{}, nil
+	}
+	entry := TreeEntry{Sha1(t), ModeTree}
+	if path == "" {
+		return entry, nil
+	}
+	for _, name := range strings.Split(string(path), "/") {
+		if entry.FileMode != ModeTree {
+			return TreeEntry{}, nil
+		}
+		o, err := c.GetObject(entry.Sha1)
+		if err != nil {
+			return TreeEntry{}, err
+		}
+		if o.GetType() != "tree" {
+			return TreeEntry{}, fmt.Errorf("%s is not a tree object", entry.Sha1)
+		}
+		content := o.GetContent()
+		found := false
+		for i := 0; i < len(content); {
+			n, e, size, err := parseRawTreeLine(i, content)
+			if err != nil {
+				return TreeEntry{}, err
+			}
+			i += size
+			if string(n) == name {
+				entry, found = e, true
+				break
+			}
+		}
+		if !found {
+			return TreeEntry{}, nil
+		}
+	}
+	return entry, nil
+}
+
+// Returns true if the trees a and b have the same content at every path
+// being limited to. Only the entries for the paths are compared, so
+// unchanged subtrees are never read.
+func (p *pathLimiter) sameTrees(a, b TreeID) (bool, error) {
+	if a == b {
+		return true, nil
+	}
+	for _, path := range p.paths {
+		ea, err := treeEntryAt(p.c, a, path)
+		if err != nil {
+			return false, err
+		}
+		eb, err := treeEntryAt(p.c, b, path)
+		if err != nil {
+			return false, err
+		}
+		if ea != eb {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Returns the parents of cmt which should be walked, and records whether
+// cmt changes the paths compared to each of them. It's suitable for
+// passing to walkCommits.
+//
+// By default, if a merge has the same content at the paths as any of its
+// parents, only that parent is followed, since the history of the paths
+// came from it. With FullHistory or SimplifyMerges, every parent is
+// followed.
+func (p *pathLimiter) parents(cmt CommitID) ([]CommitID, error) {
+	if ps, ok := p.walked[cmt]; ok {
+		return ps, nil
+	}
+	ps, err := commitParents(p.c, p.opt.FirstParent)(cmt)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := cmt.TreeID(p.c)
+	if err != nil {
+		return nil, err
+	}
+	if len(ps) == 0 {
+		same, err := p.sameTrees(tree, TreeID{})
+		if err != nil {
+			return nil, err
+		}
+		p.walked[cmt] = nil
+		p.sameAs[cmt] = []bool{same}
+		return nil, nil
+	}
+
+	sameAs := make([]bool, len(ps))
+	for i, parent := range ps {
+		ptree, err := parent.TreeID(p.c)
+		if err != nil {
+			return nil, err
+		}
+		if sameAs[i], err = p.sameTrees(tree, ptree); err != nil {
+			return nil, err
+		}
+		if !sameAs[i] && p.opt.Follow && i == 0 {
+			if err := p.followRename(ptree, tree); err != nil {
+				return nil, err
+			}
+		}
+		if sameAs[i] && !p.opt.FullHistory && !p.opt.SimplifyMerges {
+			p.walked[cmt] = []CommitID{parent}
+			p.sameAs[cmt] = []bool{true}
+			return p.walked[cmt], nil
+		}
+	}
+	p.walked[cmt] = ps
+	p.sameAs[cmt] = sameAs
+	return ps, nil
+}
+
+// If the file being followed was added between the trees from and to,
+// looks for a removed file that it was renamed from, with the same rename
+// detection as "git diff -M", and follows that name instead for the rest
+// of the walk.
+func (p *pathLimiter) followRename(from, to TreeID) error {
+	path := p.paths[0]
+	diffs, err := DiffTree(p.c, &DiffTreeOptions{Recurse: true}, from, to, []string{path.String()})
+	if err != nil {
+		return err
+	}
+	if len(diffs) != 1 || diffs[0].Name != path || diffs[0].Src.Sha1 != (Sha1{}) {
+		return nil
+	}
+	opts := &DiffTreeOptions{DiffCommonOptions: DiffCommonOptions{DetectRenames: true}, Recurse: true}
+	all, err := DiffTree(p.c, opts, from, to, nil)
+	if err != nil {
+		return err
+	}
+	for _, d := range all {
+		if d.Name == path && d.SrcName != "" {
+			p.paths[0] = d.SrcName
+			return nil
+		}
+	}
+	return nil
+}
+
+// Returns true if cmt is TREESAME, that is it has the same content at
+// the paths as every parent that was walked.
+func (p *pathLimiter) treesame(cmt CommitID) bool {
+	for _, same := range p.sameAs[cmt] {
+		if !same {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if cmt should be listed.
+func (p *pathLimiter) shown(cmt CommitID) bool {
+	if p.simplified != nil {
+		return p.simplified[cmt]
+	}
+	return !p.treesame(cmt)
+}
+
+// Simplifies the history of the walked commits the same way as git's
+// --simplify-merges. Each commit is replaced by the commit that it
+// simplifies to: a merge whose parents simplify to a single commit (after
+// removing parents which are ancestors of other parents) and a commit
+// which is TREESAME to its only parent are replaced by that parent, and
+// root commits which don't have the paths disappear. The commits which
+// are left, and change the paths or are still merges, are shown.
+func (p *pathLimiter) simplifyMerges(commits []CommitID) error {
+	sorted, err := sortCommitsTopologically(p.c, commits, p.parents, false)
+	if err != nil {
+		return err
+	}
+	// What each commit simplifies to. The zero CommitID means that it
+	// disappears.
+	replacement := make(map[CommitID]CommitID, len(sorted))
+	p.simplified = make(map[CommitID]bool)
+
+	// Go through the commits parents first, so that the replacement of
+	// every parent is known.
+	for i := len(sorted) - 1; i >= 0; i-- {
+		cmt := sorted[i]
+		var ps []CommitID
+		// Whether cmt is TREESAME to every original parent which
+		// simplified to each of ps.
+		same := make(map[CommitID]bool)
+		for j, parent := range p.walked[cmt] {
+			r, ok := replacement[parent]
+			if !ok {
+				// The parent wasn't walked, so it's a boundary
+				// and stays as it is.
+				r = parent
+			}
+			if r == (CommitID{}) {
+				continue
+			}
+			if prev, ok := same[r]; ok {
+				same[r] = prev && p.sameAs[cmt][j]
+				continue
+			}
+			same[r] = p.sameAs[cmt][j]
+			ps = append(ps, r)
+		}
+		if len(ps) > 1 {
+			var independent []CommitID
+			for j, parent := range ps {
+				redundant := false
+				for k, other := range ps {
+					if j != k && parent.IsAncestor(p.c, other) {
+						redundant = true
+						break
+					}
+				}
+				if !redundant {
+					independent = append(independent, parent)
+				}
+			}
+			ps = independent
+		}
+
+		switch len(ps) {
+		case 0:
+			// The parents (if any) didn't have the paths, so it's
+			// shown if it adds them and otherwise disappears.
+			tree, err := cmt.TreeID(p.c)
+			if err != nil {
+				return err
+			}
+			empty, err := p.sameTrees(tree, TreeID{})
+			if err != nil {
+				return err
+			}
+			if empty {
+				replacement[cmt] = CommitID{}
+				continue
+			}
+			replacement[cmt] = cmt
+			p.simplified[cmt] = true
+		case 1:
+			if same[ps[0]] {
+				replacement[cmt] = ps[0]
+				continue
+			}
+			replacement[cmt] = cmt
+			p.simplified[cmt] = true
+		default:
+			replacement[cmt] = cmt
+			p.simplified[cmt] = true
+		}
+	}
+	return nil
+}
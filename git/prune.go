@@ -0,0 +1,295 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PruneOptions are the options that may be passed to Prune.
+type PruneOptions struct {
+	// Print the objects which would be removed without removing them.
+	DryRun bool
+
+	// Print the objects which are removed.
+	Verbose bool
+
+	// Only remove unreachable objects older than this. If empty, all
+	// unreachable loose objects are removed.
+	Expire string
+}
+
+// PrunePackedOptions are the options that may be passed to PrunePacked.
+type PrunePackedOptions struct {
+	// Print the commands that would remove the objects instead of
+	// removing them.
+	DryRun bool
+}
+
+// A looseObject is an object stored in its own file in the objects
+// directory.
+type looseObject struct {
+	Sha1  Sha1
+	Path  File
+	Mtime time.Time
+}
+
+// Returns all of the loose objects in c's object directory.
+func looseObjects(c *Client) ([]looseObject, error) {
+	objdir := c.GetObjectsDir().String()
+	prefixes, err := ioutil.ReadDir(objdir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var objects []looseObject
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() || len(prefix.Name()) != 2 {
+			continue
+		}
+		dir := filepath.Join(objdir, prefix.Name())
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			sha, err := Sha1FromString(prefix.Name() + f.Name())
+			if err != nil {
+				// Not an object. It might be a temporary file.
+				continue
+			}
+			objects = append(objects, looseObject{sha, File(filepath.Join(dir, f.Name())), f.ModTime()})
+		}
+	}
+	return objects, nil
+}
+
+// Returns a map of every object in a packfile in c to the packfile that
+// contains it, without the .pack or .idx extension.
+func packedObjects(c *Client) (map[Sha1]File, error) {
+	packs, err := c.packs.list(c)
+	if err != nil {
+		return nil, err
+	}
+	objects := make(map[Sha1]File)
+	for _, pack := range packs {
+		for _, sha := range pack.idx.Sha1Table {
+			objects[sha] = pack.name
+		}
+	}
+	return objects, nil
+}
+
+// Returns the packfiles in c, without the .pack or .idx extension.
+func (c *Client) packfiles() ([]File, error) {
+	packs, err := c.packs.list(c)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]File, 0, len(packs))
+	for _, pack := range packs {
+		names = append(names, pack.name)
+	}
+	return names, nil
+}
+
+// Returns the objects which are reachable from any ref, HEAD, the reflogs,
+// the index or extra in the order that they should be written to a
+// packfile. Objects which are referenced but don't exist (such as
+// submodule commits) are not included.
+func reachableObjects(c *Client, extra []Commitish) ([]Sha1, error) {
+	var tips []Sha1
+	refs, err := ShowRef(c, ShowRefOptions{IncludeHead: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		tips = append(tips, ref.Value)
+	}
+	for _, e := range extra {
+		cmt, err := e.CommitID(c)
+		if err != nil {
+			return nil, err
+		}
+		tips = append(tips, Sha1(cmt))
+	}
+	logs, err := reflogNames(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range logs {
+		entries, err := readReflog(c, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			tips = append(tips, e.Old, e.New)
+		}
+	}
+	if idx, err := c.GitDir.ReadIndex(); err == nil {
+		for _, entry := range idx.Objects {
+			if entry.Mode != ModeCommit {
+				tips = append(tips, entry.Sha1)
+			}
+		}
+	}
+
+	var objects []Sha1
+	seen := make(map[Sha1]struct{})
+	add := func(s Sha1) {
+		if _, ok := seen[s]; ok {
+			return
+		}
+		if have, _, err := c.HaveObject(s); err != nil || !have {
+			return
+		}
+		seen[s] = struct{}{}
+		objects = append(objects, s)
+	}
+
+	// Peel the tips until we get to commits, which are walked with
+	// RevList.
+	var commits []Commitish
+	var others []Sha1
+	for _, tip := range tips {
+		for tip != (Sha1{}) {
+			if have, _, err := c.HaveObject(tip); err != nil || !have {
+				break
+			}
+			switch tip.Type(c) {
+			case "commit":
+				commits = append(commits, CommitID(tip))
+			case "tree":
+				others = append(others, tip)
+				children, err := TreeID(tip).GetAllObjects(c, "", true, false)
+				if err != nil {
+					return nil, err
+				}
+				for _, child := range children {
+					others = append(others, child.Sha1)
+				}
+			case "blob":
+				others = append(others, tip)
+			case "tag":
+				others = append(others, tip)
+				target, err := tagTarget(c, tip)
+				if err != nil {
+					return nil, err
+				}
+				tip = target
+				continue
+			}
+			break
+		}
+	}
+	walked, err := RevList(c, RevListOptions{Quiet: true, Objects: true}, ioutil.Discard, commits, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range walked {
+		add(s)
+	}
+	for _, s := range others {
+		add(s)
+	}
+	return objects, nil
+}
+
+// Removes the now empty fan out directories in the objects directory.
+func removeEmptyObjectDirs(c *Client) {
+	objdir := c.GetObjectsDir().String()
+	prefixes, err := ioutil.ReadDir(objdir)
+	if err != nil {
+		return
+	}
+	for _, prefix := range prefixes {
+		if prefix.IsDir() && len(prefix.Name()) == 2 {
+			// Remove fails if the directory isn't empty, which is
+			// what we want.
+			os.Remove(filepath.Join(objdir, prefix.Name()))
+		}
+	}
+}
+
+// PrunePacked implements "git prune-packed". It removes loose objects
+// which are also in a packfile.
+func PrunePacked(c *Client, opts PrunePackedOptions) error {
+	packed, err := packedObjects(c)
+	if err != nil {
+		return err
+	}
+	loose, err := looseObjects(c)
+	if err != nil {
+		return err
+	}
+	for _, obj := range loose {
+		if _, ok := packed[obj.Sha1]; !ok {
+			continue
+		}
+		if opts.DryRun {
+			fmt.Printf("rm -f %v\n", obj.Path)
+			continue
+		}
+		if err := os.Remove(obj.Path.String()); err != nil {
+			return err
+		}
+		delete(c.objectCache, obj.Sha1)
+	}
+	if !opts.DryRun {
+		removeEmptyObjectDirs(c)
+	}
+	return nil
+}
+
+// Prune implements "git prune". It removes loose objects which are not
+// reachable from any ref, HEAD, reflog, the index or any of heads, and
+// then removes loose objects which are already packed.
+func Prune(c *Client, opts PruneOptions, heads []Commitish) error {
+	var expire time.Time
+	if opts.Expire == "" {
+		expire = time.Now()
+	} else {
+		e, err := parseExpiry(opts.Expire)
+		if err != nil {
+			return err
+		}
+		expire = e
+	}
+
+	reachable, err := reachableObjects(c, heads)
+	if err != nil {
+		return err
+	}
+	keep := make(map[Sha1]struct{}, len(reachable))
+	for _, s := range reachable {
+		keep[s] = struct{}{}
+	}
+
+	loose, err := looseObjects(c)
+	if err != nil {
+		return err
+	}
+	for _, obj := range loose {
+		if _, ok := keep[obj.Sha1]; ok {
+			continue
+		}
+		if obj.Mtime.After(expire) {
+			continue
+		}
+		if opts.DryRun || opts.Verbose {
+			fmt.Printf("%v %v\n", obj.Sha1, obj.Sha1.Type(c))
+		}
+		if opts.DryRun {
+			continue
+		}
+		if err := os.Remove(obj.Path.String()); err != nil {
+			return err
+		}
+		delete(c.objectCache, obj.Sha1)
+	}
+	return PrunePacked(c, PrunePackedOptions{DryRun: opts.DryRun})
+}
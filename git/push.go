@@ -0,0 +1,697 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PushOptions are the options that may be passed to Push.
+type PushOptions struct {
+	// Allow updates which are not fast-forwards, as if every refspec
+	// was prefixed with a "+".
+	Force bool
+
+	// Only allow updates if the remote value of the ref matches the
+	// remote-tracking ref that we have for it. This protects every
+	// ref that is being pushed. Leases can be used to protect specific
+	// refs instead.
+	ForceWithLease bool
+	Leases         []PushLease
+
+	// Treat every refspec as the name of a remote ref to delete.
+	Delete bool
+
+	// Push all tags, in addition to any refspecs.
+	Tags bool
+
+	// Ask the remote to either apply every update or none of them.
+	Atomic bool
+
+	// Do everything except send the updates.
+	DryRun bool
+
+	// Only print errors, or be extra verbose.
+	Quiet, Verbose bool
+
+	// Set the upstream of every successfully pushed branch to the
+	// ref that it was pushed to.
+	SetUpstream bool
+
+	// The path to git-receive-pack on the remote.
+	ReceivePack string
+}
+
+// A PushLease is an expectation about the current value of a ref on the
+// remote, given by --force-with-lease=<ref>[:<expect>]. The push of Ref
+// is rejected if the remote value doesn't match.
+type PushLease struct {
+	// The remote ref that the lease applies to. It may be abbreviated.
+	Ref string
+
+	// The expected value of the ref on the remote. If empty, the value
+	// of the remote-tracking ref for Ref is expected.
+	Expect string
+}
+
+// The states that a ref may be in after a push.
+type pushRefState uint8
+
+const (
+	pushPending pushRefState = iota
+	pushUpToDate
+	pushOK
+	pushRejectNonFastForward
+	pushRejectFetchFirst
+	pushRejectAlreadyExists
+	pushRejectStale
+	pushRejectNoRemoteRef
+	pushRejectAtomic
+	pushRemoteRejected
+)
+
+// A pushRef is a single remote ref which is being updated by Push.
+type pushRef struct {
+	// The name of the local side of the refspec, as it should be
+	// displayed. Empty for a deletion.
+	src string
+
+	// The full name of the ref on the remote.
+	dst Refname
+
+	old, new Sha1
+	force    bool
+
+	// Set if the update is allowed but isn't a fast-forward.
+	forced bool
+
+	lease    bool
+	expected Sha1
+
+	state  pushRefState
+	reason string
+}
+
+func (r *pushRef) rejected() bool {
+	return r.state != pushPending && r.state != pushUpToDate && r.state != pushOK
+}
+
+// Push implements the "git push" command. It expands refs into updates to
+// the refs on rmt, checks that each update is allowed, sends the updates
+// along with the objects that the remote needs, and prints a status line
+// for every ref to stderr.
+//
+// If refs is empty and the remote has a configured remote.<name>.push
+// refspec, it is used. Otherwise the current branch is pushed to its
+// upstream, or to the branch of the same name on rmt if it doesn't have
+// one.
+//
+// An error is returned if any ref could not be updated.
+func Push(c *Client, opts PushOptions, rmt Remote, refs []RefSpec) error {
+	if opts.Delete {
+		if len(refs) == 0 {
+			return fmt.Errorf("fatal: --delete doesn't make sense without any refs")
+		}
+		deletes := make([]RefSpec, len(refs))
+		for i, ref := range refs {
+			if strings.Contains(ref.String(), ":") {
+				return fmt.Errorf("fatal: --delete only accepts plain target ref names")
+			}
+			deletes[i] = RefSpec(":" + ref.String())
+		}
+		refs = deletes
+	}
+	if len(refs) == 0 && !opts.Tags {
+		if cfgs := c.GetConfigAll(fmt.Sprintf("remote.%v.push", rmt)); len(cfgs) > 0 {
+			for _, cfg := range cfgs {
+				refs = append(refs, RefSpec(cfg))
+			}
+		} else {
+			spec, err := defaultPushRefSpec(c, rmt)
+			if err != nil {
+				return err
+			}
+			refs = []RefSpec{spec}
+		}
+	}
+	if opts.Tags {
+		refs = append(refs, "refs/tags/*:refs/tags/*")
+	}
+
+	url, err := rmt.PushURL(c)
+	if err != nil {
+		return err
+	}
+	conn, err := NewReceivePackConn(c, rmt)
+	if err != nil {
+		return err
+	}
+	if opts.ReceivePack != "" {
+		if err := conn.SetReceivePack(opts.ReceivePack); err != nil {
+			return err
+		}
+	}
+	if err := conn.OpenConn(); err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rmtrefs, err := conn.GetRefs(LsRemoteOptions{}, nil)
+	if err != nil {
+		return err
+	}
+	remote := make(map[Refname]Sha1)
+	for _, ref := range rmtrefs {
+		if !strings.HasSuffix(ref.Name, "^{}") {
+			remote[Refname(ref.Name)] = ref.Value
+		}
+	}
+
+	updates, err := expandPushRefSpecs(c, opts, refs, remote)
+	if err != nil {
+		return err
+	}
+	if err := applyPushLeases(c, opts, rmt, updates); err != nil {
+		return err
+	}
+	checkPushUpdates(c, updates)
+
+	var tosend []PushUpdate
+	failed := false
+	for _, u := range updates {
+		if u.rejected() {
+			failed = true
+		} else if u.state == pushPending {
+			tosend = append(tosend, PushUpdate{Ref: u.dst, Old: u.old, New: u.new})
+		}
+	}
+	if opts.Atomic && failed {
+		for _, u := range updates {
+			if u.state == pushPending {
+				u.state = pushRejectAtomic
+			}
+		}
+		tosend = nil
+	}
+
+	if len(tosend) > 0 && !opts.DryRun {
+		statuses, err := SendPack(c, SendPackOptions{Quiet: opts.Quiet, Atomic: opts.Atomic}, conn, tosend)
+		if err != nil && statuses == nil {
+			return err
+		}
+		for _, st := range statuses {
+			for _, u := range updates {
+				if u.dst != st.Ref || u.state != pushPending {
+					continue
+				}
+				if st.OK {
+					u.state = pushOK
+				} else {
+					u.state = pushRemoteRejected
+					u.reason = st.Reason
+					failed = true
+				}
+			}
+		}
+		if err != nil {
+			failed = true
+		}
+	} else {
+		for _, u := range updates {
+			if u.state == pushPending {
+				u.state = pushOK
+			}
+		}
+	}
+
+	printPushStatus(os.Stderr, opts, url, updates)
+
+	if !opts.DryRun {
+		if err := updatePushTrackingRefs(c, opts, rmt, updates); err != nil {
+			return err
+		}
+	}
+	if failed {
+		return fmt.Errorf("error: failed to push some refs to '%v'", url)
+	}
+	return nil
+}
+
+// Returns the refspec to use when none were given and the remote doesn't
+// have one configured. This is the current branch pushed to its upstream
+// if its upstream is on rmt, or to the branch of the same name otherwise.
+func defaultPushRefSpec(c *Client, rmt Remote) (RefSpec, error) {
+	head := c.GetHeadBranch()
+	if head == "" {
+		return "", fmt.Errorf("You are not currently on a branch.")
+	}
+	name := head.BranchName()
+	if remote := c.GetConfig("branch." + name + ".remote"); remote == rmt.String() {
+		if merge := c.GetConfig("branch." + name + ".merge"); merge != "" {
+			return RefSpec(fmt.Sprintf("%v:%v", head, merge)), nil
+		}
+	}
+	return RefSpec(fmt.Sprintf("%v:%v", head, head)), nil
+}
+
+// Returns the full name of the local ref that name refers to, or the empty
+// string if it doesn't refer to a ref. HEAD resolves to the current branch.
+func dwimRef(c *Client, name string) Refname {
+	if name == "HEAD" {
+		return Refname(c.GetHeadBranch())
+	}
+	for _, candidate := range []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+	} {
+		if strings.HasPrefix(candidate, "refs/") && RefSpec(candidate).Exists(c) {
+			return Refname(candidate)
+		}
+	}
+	return ""
+}
+
+// Strips the common prefixes from a ref name for display.
+func prettyRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return name
+}
+
+// Expands refspecs into the list of remote refs that they update.
+func expandPushRefSpecs(c *Client, opts PushOptions, refs []RefSpec, remote map[Refname]Sha1) ([]*pushRef, error) {
+	var updates []*pushRef
+	seen := make(map[Refname]bool)
+	add := func(u *pushRef) error {
+		if seen[u.dst] {
+			return fmt.Errorf("Multiple updates for ref '%v' not allowed", u.dst)
+		}
+		seen[u.dst] = true
+		u.old = remote[u.dst]
+		u.force = u.force || opts.Force
+		updates = append(updates, u)
+		return nil
+	}
+
+	for _, spec := range refs {
+		s := spec.String()
+		force := strings.HasPrefix(s, "+")
+		s = strings.TrimPrefix(s, "+")
+
+		if s == ":" {
+			// The "matching" refspec pushes every branch which
+			// exists on both sides.
+			names, err := c.refNames("refs/heads/")
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				if _, ok := remote[Refname(name)]; !ok {
+					continue
+				}
+				sha, err := RefSpec(name).Sha1(c)
+				if err != nil {
+					return nil, err
+				}
+				if err := add(&pushRef{src: prettyRefName(name), dst: Refname(name), new: sha, force: force}); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		var src, dst string
+		if pos := strings.Index(s, ":"); pos >= 0 {
+			src, dst = s[:pos], s[pos+1:]
+		} else {
+			src = s
+		}
+
+		if strings.Contains(src, "*") {
+			if !strings.Contains(dst, "*") {
+				return nil, fmt.Errorf("Invalid refspec '%v'", spec)
+			}
+			star := strings.Index(src, "*")
+			prefix, suffix := src[:star], src[star+1:]
+			names, err := c.refNames(prefix)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				if !strings.HasSuffix(name, suffix) || len(name) < len(prefix)+len(suffix) {
+					continue
+				}
+				match := name[len(prefix) : len(name)-len(suffix)]
+				sha, err := RefSpec(name).Sha1(c)
+				if err != nil {
+					return nil, err
+				}
+				u := &pushRef{
+					src:   prettyRefName(name),
+					dst:   Refname(strings.Replace(dst, "*", match, 1)),
+					new:   sha,
+					force: force,
+				}
+				if err := add(u); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if src == "" {
+			// A deletion.
+			if dst == "" {
+				return nil, fmt.Errorf("Invalid refspec '%v'", spec)
+			}
+			full, err := pushDestination(c, dst, "", Sha1{}, remote)
+			if err != nil {
+				return nil, err
+			}
+			if err := add(&pushRef{dst: full, force: force}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var sha Sha1
+		srcref := dwimRef(c, src)
+		if srcref != "" {
+			v, err := RefSpec(srcref).Sha1(c)
+			if err != nil {
+				return nil, err
+			}
+			sha = v
+		} else if src == "HEAD" {
+			// A detached HEAD.
+			cmt, err := c.GetHeadCommit()
+			if err != nil {
+				return nil, err
+			}
+			sha = Sha1(cmt)
+		} else {
+			revs, err := RevParse(c, RevParseOptions{}, []string{src})
+			if err != nil || len(revs) != 1 {
+				return nil, fmt.Errorf("error: src refspec %v does not match any", src)
+			}
+			sha = revs[0].Id
+		}
+
+		if dst == "" {
+			if srcref == "" {
+				return nil, fmt.Errorf("error: The destination you provided is not a full refname (i.e., starting with \"refs/\")")
+			}
+			dst = string(srcref)
+		}
+		full, err := pushDestination(c, dst, srcref, sha, remote)
+		if err != nil {
+			return nil, err
+		}
+		display := src
+		if srcref != "" && src != "HEAD" {
+			display = prettyRefName(string(srcref))
+		}
+		if err := add(&pushRef{src: display, dst: full, new: sha, force: force}); err != nil {
+			return nil, err
+		}
+	}
+	return updates, nil
+}
+
+// Returns the full name of the remote ref that dst refers to.
+func pushDestination(c *Client, dst string, srcref Refname, sha Sha1, remote map[Refname]Sha1) (Refname, error) {
+	if strings.HasPrefix(dst, "refs/") {
+		return Refname(dst), nil
+	}
+	var matches []Refname
+	for _, candidate := range []Refname{
+		Refname("refs/heads/" + dst),
+		Refname("refs/tags/" + dst),
+	} {
+		if _, ok := remote[candidate]; ok {
+			matches = append(matches, candidate)
+		}
+	}
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+	default:
+		return "", fmt.Errorf("error: dst refspec %v matches more than one", dst)
+	}
+	if sha == (Sha1{}) {
+		return "", fmt.Errorf("error: unable to delete '%v': remote ref does not exist", dst)
+	}
+
+	// It doesn't exist on the remote, so guess based on what's being
+	// pushed.
+	switch {
+	case strings.HasPrefix(string(srcref), "refs/heads/"):
+		return Refname("refs/heads/" + dst), nil
+	case strings.HasPrefix(string(srcref), "refs/tags/"):
+		return Refname("refs/tags/" + dst), nil
+	}
+	switch sha.Type(c) {
+	case "commit":
+		return Refname("refs/heads/" + dst), nil
+	case "tag":
+		return Refname("refs/tags/" + dst), nil
+	}
+	return "", fmt.Errorf("error: The destination you provided is not a full refname (i.e., starting with \"refs/\")")
+}
+
+// Returns the remote-tracking ref which caches the value of ref on rmt, if
+// rmt is a configured remote.
+func pushTrackingRef(c *Client, rmt Remote, ref Refname) Refname {
+	if c.GetConfig(fmt.Sprintf("remote.%v.url", rmt)) == "" {
+		return ""
+	}
+	specs := c.GetConfigAll(fmt.Sprintf("remote.%v.fetch", rmt))
+	if len(specs) == 0 {
+		specs = []string{fmt.Sprintf("refs/heads/*:refs/remotes/%v/*", rmt)}
+	}
+	for _, s := range specs {
+		spec := RefSpec(s)
+		if strings.HasSuffix(string(spec.Src()), "/*") != strings.HasSuffix(string(spec.Dst()), "/*") {
+			continue
+		}
+		if match, dst := (Ref{Name: string(ref)}).MatchesRefSpecSrc(spec); match {
+			return dst
+		}
+	}
+	return ""
+}
+
+// Sets the expected value of every update which is protected by a lease.
+func applyPushLeases(c *Client, opts PushOptions, rmt Remote, updates []*pushRef) error {
+	expect := func(u *pushRef, value string) error {
+		u.lease = true
+		if value != "" {
+			revs, err := RevParse(c, RevParseOptions{}, []string{value})
+			if err != nil || len(revs) != 1 {
+				return fmt.Errorf("fatal: cannot parse expected object name '%v'", value)
+			}
+			u.expected = revs[0].Id
+			return nil
+		}
+		// If there's no tracking ref, the ref is expected to not
+		// exist on the remote.
+		u.expected = Sha1{}
+		if tracking := pushTrackingRef(c, rmt, u.dst); tracking != "" && RefSpec(tracking).Exists(c) {
+			sha, err := RefSpec(tracking).Sha1(c)
+			if err != nil {
+				return err
+			}
+			u.expected = sha
+		}
+		return nil
+	}
+
+	for _, u := range updates {
+		if opts.ForceWithLease {
+			if err := expect(u, ""); err != nil {
+				return err
+			}
+		}
+		for _, lease := range opts.Leases {
+			name := lease.Ref
+			if string(u.dst) != name && string(u.dst) != "refs/heads/"+name && string(u.dst) != "refs/tags/"+name {
+				continue
+			}
+			if err := expect(u, lease.Expect); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Checks that each update is allowed, and marks the ones that aren't as
+// rejected and the ones that don't change anything as up to date.
+func checkPushUpdates(c *Client, updates []*pushRef) {
+	for _, u := range updates {
+		switch {
+		case u.new == (Sha1{}) && u.old == (Sha1{}):
+			u.state = pushRejectNoRemoteRef
+		case u.lease && u.old != u.expected:
+			u.state = pushRejectStale
+		case u.old == u.new:
+			u.state = pushUpToDate
+		case u.old == (Sha1{}), u.new == (Sha1{}):
+			// Creating or deleting a ref is always allowed.
+		case u.lease, u.force:
+			// Leases replace the fast-forward check.
+			u.forced = !isFastForward(c, u.old, u.new)
+		case strings.HasPrefix(string(u.dst), "refs/tags/"):
+			u.state = pushRejectAlreadyExists
+		default:
+			if have, _, err := c.HaveObject(u.old); !have || err != nil {
+				u.state = pushRejectFetchFirst
+			} else if !isFastForward(c, u.old, u.new) {
+				u.state = pushRejectNonFastForward
+			}
+		}
+	}
+}
+
+// Returns true if updating a ref from old to new is a fast-forward.
+func isFastForward(c *Client, old, new Sha1) bool {
+	if old.Type(c) != "commit" || new.Type(c) != "commit" {
+		return false
+	}
+	return CommitID(old).IsAncestor(c, CommitID(new))
+}
+
+// Prints a git compatible status line for every update to w.
+func printPushStatus(w io.Writer, opts PushOptions, url string, updates []*pushRef) {
+	const width = 17
+	abbrev := func(s Sha1) string { return s.String()[:7] }
+
+	printedURL := false
+	for _, u := range updates {
+		if opts.Quiet && !u.rejected() {
+			continue
+		}
+		if u.state == pushUpToDate && !opts.Verbose {
+			continue
+		}
+		if !printedURL {
+			fmt.Fprintf(w, "To %v\n", url)
+			printedURL = true
+		}
+
+		dst := prettyRefName(string(u.dst))
+		refs := fmt.Sprintf("%v -> %v", u.src, dst)
+		if u.new == (Sha1{}) {
+			refs = dst
+		}
+		var flag byte
+		var summary, msg string
+		switch u.state {
+		case pushUpToDate:
+			flag, summary = '=', "[up to date]"
+		case pushOK:
+			switch {
+			case u.new == (Sha1{}):
+				flag, summary = '-', "[deleted]"
+			case u.old == (Sha1{}):
+				flag = '*'
+				switch {
+				case strings.HasPrefix(string(u.dst), "refs/heads/"):
+					summary = "[new branch]"
+				case strings.HasPrefix(string(u.dst), "refs/tags/"):
+					summary = "[new tag]"
+				default:
+					summary = "[new reference]"
+				}
+			case u.forced:
+				flag, summary, msg = '+', abbrev(u.old)+"..."+abbrev(u.new), "forced update"
+			default:
+				flag, summary = ' ', abbrev(u.old)+".."+abbrev(u.new)
+			}
+		case pushRemoteRejected:
+			flag, summary, msg = '!', "[remote rejected]", u.reason
+		default:
+			flag, summary = '!', "[rejected]"
+			switch u.state {
+			case pushRejectNonFastForward:
+				msg = "non-fast-forward"
+			case pushRejectFetchFirst:
+				msg = "fetch first"
+			case pushRejectAlreadyExists:
+				msg = "already exists"
+			case pushRejectStale:
+				msg = "stale info"
+			case pushRejectNoRemoteRef:
+				msg = "remote ref does not exist"
+			case pushRejectAtomic:
+				msg = "atomic push failed"
+			}
+		}
+		if msg != "" {
+			msg = " (" + msg + ")"
+		}
+		fmt.Fprintf(w, " %c %-*s %v%v\n", flag, width, summary, refs, msg)
+	}
+	if !printedURL && !opts.Quiet {
+		fmt.Fprintln(w, "Everything up-to-date")
+	}
+}
+
+// Updates the remote-tracking refs for the refs that were successfully
+// pushed, and sets the upstream of pushed branches if opts.SetUpstream is
+// set.
+func updatePushTrackingRefs(c *Client, opts PushOptions, rmt Remote, updates []*pushRef) error {
+	var config *GitConfig
+	for _, u := range updates {
+		if u.state != pushOK && u.state != pushUpToDate {
+			continue
+		}
+		if tracking := pushTrackingRef(c, rmt, u.dst); tracking != "" {
+			if u.new == (Sha1{}) {
+				if RefSpec(tracking).Exists(c) {
+					if err := c.deleteRef(string(tracking)); err != nil {
+						return err
+					}
+				}
+			} else if u.new.Type(c) == "commit" {
+				err := UpdateRefSpec(c, UpdateRefOptions{}, RefSpec(tracking), CommitID(u.new), "update by push")
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if !opts.SetUpstream || u.new == (Sha1{}) {
+			continue
+		}
+		src := dwimRef(c, u.src)
+		if !strings.HasPrefix(string(src), "refs/heads/") || !strings.HasPrefix(string(u.dst), "refs/heads/") {
+			continue
+		}
+		if config == nil {
+			cfg, err := LoadLocalConfig(c)
+			if err != nil {
+				return err
+			}
+			config = &cfg
+		}
+		name := strings.TrimPrefix(string(src), "refs/heads/")
+		config.SetConfig(fmt.Sprintf("branch.%v.remote", name), rmt.String())
+		config.SetConfig(fmt.Sprintf("branch.%v.merge", name), string(u.dst))
+		if !opts.Quiet {
+			fmt.Printf("branch '%v' set up to track '%v/%v'.\n", name, rmt, prettyRefName(string(u.dst)))
+		}
+	}
+	if config != nil {
+		return config.WriteConfig()
+	}
+	return nil
+}
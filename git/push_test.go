@@ -0,0 +1,105 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPushRefSpecs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitpush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := Commit(c, CommitOptions{}, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBranch("old", first); err != nil {
+		t.Fatal(err)
+	}
+	second, err := Commit(c, CommitOptions{AllowEmpty: true}, "second", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := map[Refname]Sha1{
+		"refs/heads/master": Sha1(first),
+		"refs/heads/old":    Sha1(second),
+		"refs/heads/same":   Sha1(second),
+	}
+	tests := []struct {
+		specs []RefSpec
+		opts  PushOptions
+		want  []pushRef
+	}{
+		{
+			[]RefSpec{"master", "old", "HEAD:same", "HEAD:new"},
+			PushOptions{},
+			[]pushRef{
+				{src: "master", dst: "refs/heads/master", old: Sha1(first), new: Sha1(second), state: pushPending},
+				{src: "old", dst: "refs/heads/old", old: Sha1(second), new: Sha1(first), state: pushRejectNonFastForward},
+				{src: "HEAD", dst: "refs/heads/same", old: Sha1(second), new: Sha1(second), state: pushUpToDate},
+				{src: "HEAD", dst: "refs/heads/new", new: Sha1(second), state: pushPending},
+			},
+		},
+		{
+			[]RefSpec{"+old", "master:refs/heads/master", ":same"},
+			PushOptions{},
+			[]pushRef{
+				{src: "old", dst: "refs/heads/old", old: Sha1(second), new: Sha1(first), force: true, forced: true, state: pushPending},
+				{src: "master", dst: "refs/heads/master", old: Sha1(first), new: Sha1(second), state: pushPending},
+				{dst: "refs/heads/same", old: Sha1(second), state: pushPending},
+			},
+		},
+		{
+			[]RefSpec{"refs/heads/*:refs/heads/*"},
+			PushOptions{Leases: []PushLease{{Ref: "old", Expect: second.String()}, {Ref: "master", Expect: second.String()}}},
+			[]pushRef{
+				{src: "master", dst: "refs/heads/master", old: Sha1(first), new: Sha1(second), lease: true, expected: Sha1(second), state: pushRejectStale},
+				{src: "old", dst: "refs/heads/old", old: Sha1(second), new: Sha1(first), lease: true, expected: Sha1(second), forced: true, state: pushPending},
+			},
+		},
+	}
+	for i, tc := range tests {
+		updates, err := expandPushRefSpecs(c, tc.opts, tc.specs, remote)
+		if err != nil {
+			t.Errorf("Test %d: %v", i, err)
+			continue
+		}
+		if err := applyPushLeases(c, tc.opts, "origin", updates); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+			continue
+		}
+		checkPushUpdates(c, updates)
+		if len(updates) != len(tc.want) {
+			t.Errorf("Test %d: got %d updates want %d", i, len(updates), len(tc.want))
+			continue
+		}
+		for j, u := range updates {
+			if *u != tc.want[j] {
+				t.Errorf("Test %d update %d: got %+v want %+v", i, j, *u, tc.want[j])
+			}
+		}
+	}
+
+	// Deleting a ref which doesn't exist on the remote is an error.
+	if _, err := expandPushRefSpecs(c, PushOptions{}, []RefSpec{":gone"}, remote); err == nil {
+		t.Error("Expected an error deleting a ref which isn't on the remote")
+	}
+}
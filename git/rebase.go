@@ -0,0 +1,919 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type RebaseOptions struct {
+	// The commit to rebase onto, if it's not the upstream.
+	Onto Commitish
+
+	// Let the user edit the list of commits to rebase before starting.
+	Interactive bool
+
+	// Move commits whose subjects start with "fixup! " or "squash! "
+	// after the commit they're fixing, and change them to a fixup or
+	// squash.
+	AutoSquash bool
+
+	Continue, Skip, Abort, Quit, EditTodo bool
+}
+
+// The directory in the GitDir which holds the state of an in progress
+// rebase. It contains:
+//
+//	head-name:       the branch being rebased, or "detached HEAD".
+//	onto:            the commit the branch is being rebased onto.
+//	orig-head:       the commit HEAD pointed to before the rebase.
+//	git-rebase-todo: the commands which are still to be run.
+//	done:            the commands which have been run, the last of which
+//	                 is the one the rebase stopped at.
+//	interactive:     exists if the rebase is interactive.
+//	stopped-sha:     the commit the rebase stopped at because of a
+//	                 conflict or an edit command.
+//	amend:           the commit HEAD pointed to after an edit command.
+//	message-squash:  the combined message of a series of squash and
+//	                 fixup commands.
+//	current-fixups:  the squash and fixup commands in the series.
+const rebaseMergeDir = File("rebase-merge")
+
+// The file in the GitDir which records the commit that a rebase stopped at
+// because of conflicts.
+const rebaseHeadFile = File("REBASE_HEAD")
+
+// A command in the todo list of a rebase.
+type rebaseItem struct {
+	action string
+	commit CommitID
+
+	// The rest of the line, which is the subject of the commit, or the
+	// shell command for exec.
+	rest string
+}
+
+// Returns the line for the item in the todo list. If short is set,
+// commits are abbreviated.
+func (i rebaseItem) line(short bool) string {
+	switch i.action {
+	case "exec":
+		return "exec " + i.rest
+	case "break":
+		return "break"
+	}
+	id := i.commit.String()
+	if short {
+		id = id[:7]
+	}
+	return fmt.Sprintf("%v %v %v", i.action, id, i.rest)
+}
+
+const rebaseTodoHelp = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash" but keep only the previous
+#                    commit's log message
+# x, exec <command> = run command (the rest of the line) using shell
+# b, break = stop here (continue rebase later with 'git rebase --continue')
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`
+
+// Parses a rebase todo list. Commits may be abbreviated.
+func parseRebaseTodo(c *Client, content string) ([]rebaseItem, error) {
+	var items []rebaseItem
+	for n, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		var item rebaseItem
+		switch fields[0] {
+		case "p", "pick":
+			item.action = "pick"
+		case "r", "reword":
+			item.action = "reword"
+		case "e", "edit":
+			item.action = "edit"
+		case "s", "squash":
+			item.action = "squash"
+		case "f", "fixup":
+			item.action = "fixup"
+		case "d", "drop":
+			item.action = "drop"
+		case "x", "exec":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("missing arguments for exec on line %d: %v", n+1, line)
+			}
+			item.action = "exec"
+			item.rest = strings.TrimSpace(line[len(fields[0]):])
+			items = append(items, item)
+			continue
+		case "b", "break":
+			items = append(items, rebaseItem{action: "break"})
+			continue
+		case "noop":
+			continue
+		default:
+			return nil, fmt.Errorf("invalid command '%v' on line %d: %v", fields[0], n+1, line)
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("missing commit on line %d: %v", n+1, line)
+		}
+		cmt, err := RevParseCommitish(c, &RevParseOptions{}, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit '%v' on line %d: %v", fields[1], n+1, line)
+		}
+		if item.commit, err = cmt.CommitID(c); err != nil {
+			return nil, err
+		}
+		if len(fields) > 2 {
+			item.rest = fields[2]
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+type rebase struct {
+	// The branch being rebased, or "detached HEAD".
+	headName string
+
+	onto, origHead CommitID
+
+	todo, done []rebaseItem
+
+	interactive bool
+}
+
+func (r *rebase) file(name string) File {
+	return rebaseMergeDir + "/" + File(name)
+}
+
+// Returns true if there is a rebase in progress.
+func (c *Client) IsRebasing() bool {
+	return c.GitDir.File(rebaseMergeDir).Exists()
+}
+
+// Rebase implements "git rebase [--onto <newbase>] [<upstream> [<branch>]]"
+// and its --continue, --skip, --abort, --quit and --edit-todo actions.
+//
+// The commits in branch (or HEAD, if branch is empty) which aren't in
+// upstream are replayed on top of opts.Onto, or upstream if Onto isn't
+// set. Commits which introduce the same change as a commit in upstream are
+// skipped. If upstream is nil, the upstream of the current branch is used.
+func Rebase(c *Client, opts RebaseOptions, upstream Commitish, branch string) error {
+	switch {
+	case opts.Continue:
+		return rebaseContinue(c)
+	case opts.Skip:
+		return rebaseSkip(c)
+	case opts.Abort:
+		return rebaseAbort(c)
+	case opts.Quit:
+		return os.RemoveAll(c.GitDir.File(rebaseMergeDir).String())
+	case opts.EditTodo:
+		return rebaseEditTodo(c)
+	}
+	if c.IsRebasing() {
+		return fmt.Errorf(`It seems that there is already a rebase-merge directory, and
+I wonder if you are in the middle of another rebase.  If that is the
+case, please try
+	git rebase (--continue | --abort | --skip)`)
+	}
+	if branch != "" {
+		if err := Checkout(c, CheckoutOptions{Quiet: true}, branch, nil); err != nil {
+			return err
+		}
+	}
+	if upstream == nil {
+		ref, err := upstreamRef(c, "", false)
+		if err != nil {
+			return fmt.Errorf("There is no tracking information for the current branch.\nPlease specify which branch you want to rebase against.")
+		}
+		upstream = RefSpec(ref)
+	}
+	upstreamID, err := upstream.CommitID(c)
+	if err != nil {
+		return err
+	}
+	onto := upstreamID
+	if opts.Onto != nil {
+		if onto, err = opts.Onto.CommitID(c); err != nil {
+			return err
+		}
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	headName := "detached HEAD"
+	if b := c.GetHeadBranch(); b != "" {
+		headName = b.String()
+	}
+	if err := checkRebaseClean(c, head); err != nil {
+		return err
+	}
+
+	// There's nothing to do if the branch is already based on onto.
+	if !opts.Interactive {
+		base, err := MergeBase(c, MergeBaseOptions{}, []Commitish{onto, head})
+		if err == nil && base == onto {
+			upstreamBase, err := MergeBase(c, MergeBaseOptions{}, []Commitish{upstreamID, head})
+			if err == nil && upstreamBase == onto {
+				if headName == "detached HEAD" {
+					fmt.Println("HEAD is up to date.")
+				} else {
+					fmt.Printf("Current branch %v is up to date.\n", Branch(headName).BranchName())
+				}
+				return nil
+			}
+		}
+	}
+
+	items, err := rebaseCommits(c, upstreamID, head)
+	if err != nil {
+		return err
+	}
+	if opts.AutoSquash {
+		items = autoSquash(items)
+	}
+	r := &rebase{
+		headName:    headName,
+		onto:        onto,
+		origHead:    head,
+		todo:        items,
+		interactive: opts.Interactive,
+	}
+	if err := os.MkdirAll(c.GitDir.File(rebaseMergeDir).String(), 0755); err != nil {
+		return err
+	}
+	if opts.Interactive {
+		if err := r.editTodo(c); err != nil {
+			r.remove(c)
+			return err
+		}
+		if len(r.todo) == 0 {
+			r.remove(c)
+			return fmt.Errorf("Nothing to do")
+		}
+	}
+
+	if err := c.GitDir.WriteFile(origHeadFile, []byte(head.String()+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := CheckoutCommit(c, CheckoutOptions{Quiet: true, Detach: true}, onto); err != nil {
+		r.remove(c)
+		return err
+	}
+	if err := r.save(c); err != nil {
+		return err
+	}
+	return r.run(c)
+}
+
+// Returns an error if there are changes in the index or work tree which
+// would be lost by rebasing.
+func checkRebaseClean(c *Client, head CommitID) error {
+	unstaged, err := DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil {
+		return err
+	}
+	if len(unstaged) > 0 {
+		return fmt.Errorf("cannot rebase: You have unstaged changes.\nPlease commit or stash them.")
+	}
+	staged, err := DiffIndex(c, DiffIndexOptions{Cached: true}, nil, head, nil)
+	if err != nil {
+		return err
+	}
+	if len(staged) > 0 {
+		return fmt.Errorf("cannot rebase: Your index contains uncommitted changes.\nPlease commit or stash them.")
+	}
+	return nil
+}
+
+// Returns the todo list to replay the commits in head which aren't in
+// upstream, oldest first. Merge commits and commits whose patch is already
+// in upstream are left out.
+func rebaseCommits(c *Client, upstream, head CommitID) ([]rebaseItem, error) {
+	revs, err := ParseRevisions(c, []string{upstream.String() + "..." + head.String()})
+	if err != nil {
+		return nil, err
+	}
+	maxParents := 1
+	shas, err := RevList(c, RevListOptions{
+		Quiet:      true,
+		Reverse:    true,
+		CherryPick: true,
+		RightOnly:  true,
+		Left:       revs.Left,
+		Right:      revs.Right,
+		MaxParents: &maxParents,
+	}, nil, revs.Includes, revs.Excludes)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]rebaseItem, 0, len(shas))
+	for _, s := range shas {
+		cmt := CommitID(s)
+		msg, err := cmt.GetCommitMessage(c)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, rebaseItem{action: "pick", commit: cmt, rest: msg.Subject()})
+	}
+	return items, nil
+}
+
+// Moves the commits in items whose subject starts with "fixup! " or
+// "squash! " after the commit they refer to, which is the earlier commit
+// with that subject, or whose ID or subject starts with the rest of the
+// subject.
+func autoSquash(items []rebaseItem) []rebaseItem {
+	moved := make(map[int]bool)
+	following := make(map[int][]int)
+	for i, item := range items {
+		var action string
+		target := item.rest
+		for {
+			if strings.HasPrefix(target, "fixup! ") {
+				target = strings.TrimPrefix(target, "fixup! ")
+			} else if strings.HasPrefix(target, "squash! ") {
+				target = strings.TrimPrefix(target, "squash! ")
+			} else {
+				break
+			}
+			if action == "" {
+				action = "fixup"
+				if strings.HasPrefix(item.rest, "squash! ") {
+					action = "squash"
+				}
+			}
+		}
+		if action == "" {
+			continue
+		}
+		found := -1
+		for _, match := range []func(rebaseItem) bool{
+			func(o rebaseItem) bool { return o.rest == target },
+			func(o rebaseItem) bool { return len(target) >= 4 && strings.HasPrefix(o.commit.String(), target) },
+			func(o rebaseItem) bool { return strings.HasPrefix(o.rest, target) },
+		} {
+			for j := 0; j < i && found < 0; j++ {
+				if !moved[j] && match(items[j]) {
+					found = j
+				}
+			}
+		}
+		if found < 0 {
+			continue
+		}
+		items[i].action = action
+		moved[i] = true
+		following[found] = append(following[found], i)
+	}
+
+	sorted := make([]rebaseItem, 0, len(items))
+	for i, item := range items {
+		if moved[i] {
+			continue
+		}
+		sorted = append(sorted, item)
+		for _, j := range following[i] {
+			sorted = append(sorted, items[j])
+		}
+	}
+	return sorted
+}
+
+// Lets the user edit the todo list of the rebase.
+func (r *rebase) editTodo(c *Client) error {
+	var todo strings.Builder
+	for _, item := range r.todo {
+		fmt.Fprintln(&todo, item.line(true))
+	}
+	fmt.Fprintf(&todo, "\n# Rebase %v..%v onto %v (%d commands)\n#",
+		r.onto.String()[:7], r.origHead.String()[:7], r.onto.String()[:7], len(r.todo),
+	)
+	todo.WriteString(rebaseTodoHelp)
+	if err := c.GitDir.WriteFile(r.file("git-rebase-todo"), []byte(todo.String()), 0644); err != nil {
+		return err
+	}
+	if err := c.ExecEditor(c.GitDir.File(r.file("git-rebase-todo"))); err != nil {
+		return err
+	}
+	edited, err := c.GitDir.ReadFile(r.file("git-rebase-todo"))
+	if err != nil {
+		return err
+	}
+	items, err := parseRebaseTodo(c, string(edited))
+	if err != nil {
+		return err
+	}
+	r.todo = items
+	return r.checkTodo()
+}
+
+// Checks that the first commit in the todo list can be applied, if the
+// rebase hasn't applied anything yet.
+func (r *rebase) checkTodo() error {
+	for _, item := range r.done {
+		switch item.action {
+		case "exec", "break", "drop":
+		default:
+			return nil
+		}
+	}
+	for _, item := range r.todo {
+		switch item.action {
+		case "exec", "break", "drop":
+		case "squash", "fixup":
+			return fmt.Errorf("cannot '%v' without a previous commit", item.action)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Loads the state of the in progress rebase.
+func loadRebase(c *Client) (*rebase, error) {
+	if !c.IsRebasing() {
+		return nil, fmt.Errorf("No rebase in progress?")
+	}
+	r := &rebase{}
+	headName, err := c.GitDir.ReadFile(r.file("head-name"))
+	if err != nil {
+		return nil, err
+	}
+	r.headName = strings.TrimSpace(string(headName))
+	if r.onto, err = c.readHeadFile(r.file("onto")); err != nil {
+		return nil, err
+	}
+	if r.origHead, err = c.readHeadFile(r.file("orig-head")); err != nil {
+		return nil, err
+	}
+	r.interactive = c.GitDir.File(r.file("interactive")).Exists()
+	for name, items := range map[string]*[]rebaseItem{"git-rebase-todo": &r.todo, "done": &r.done} {
+		content, err := c.GitDir.ReadFile(r.file(name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if *items, err = parseRebaseTodo(c, string(content)); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Saves the state of the rebase.
+func (r *rebase) save(c *Client) error {
+	var todo, done strings.Builder
+	for _, item := range r.todo {
+		fmt.Fprintln(&todo, item.line(false))
+	}
+	for _, item := range r.done {
+		fmt.Fprintln(&done, item.line(false))
+	}
+	for name, content := range map[string]string{
+		"head-name":       r.headName + "\n",
+		"onto":            r.onto.String() + "\n",
+		"orig-head":       r.origHead.String() + "\n",
+		"git-rebase-todo": todo.String(),
+		"done":            done.String(),
+	} {
+		if err := c.GitDir.WriteFile(r.file(name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	if r.interactive {
+		return c.GitDir.WriteFile(r.file("interactive"), nil, 0644)
+	}
+	return nil
+}
+
+// Removes the state of the rebase.
+func (r *rebase) remove(c *Client) error {
+	os.Remove(c.GitDir.File(rebaseHeadFile).String())
+	return os.RemoveAll(c.GitDir.File(rebaseMergeDir).String())
+}
+
+// Removes the files which record why the rebase stopped.
+func (r *rebase) clearStop(c *Client) {
+	for _, f := range []File{r.file("stopped-sha"), r.file("amend"), rebaseHeadFile} {
+		os.Remove(c.GitDir.File(f).String())
+	}
+	c.clearMergeState()
+}
+
+// Runs the commands in the todo list until it's empty or one of them stops
+// the rebase.
+func (r *rebase) run(c *Client) error {
+	for len(r.todo) > 0 {
+		item := r.todo[0]
+		r.todo = r.todo[1:]
+		r.done = append(r.done, item)
+		if err := r.save(c); err != nil {
+			return err
+		}
+		if stop, err := r.do(c, item); stop || err != nil {
+			return err
+		}
+	}
+	return r.finish(c)
+}
+
+// Runs a single command from the todo list. It returns true if the rebase
+// should stop.
+func (r *rebase) do(c *Client, item rebaseItem) (bool, error) {
+	switch item.action {
+	case "drop":
+		return false, nil
+	case "break":
+		return true, nil
+	case "exec":
+		fmt.Printf("Executing: %v\n", item.rest)
+		if err := c.execShell(item.rest); err != nil {
+			return true, fmt.Errorf("execution failed: %v\nYou can fix the problem, and then run\n\n  git rebase --continue\n", item.rest)
+		}
+		return false, nil
+	}
+
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return true, err
+	}
+	parents, err := item.commit.Parents(c)
+	if err != nil {
+		return true, err
+	}
+	// Commits which are already on top of HEAD are used as they are,
+	// rather than being recreated.
+	fastForward := (item.action == "pick" || item.action == "edit") && len(parents) == 1 && parents[0] == head
+
+	if item.action == "squash" || item.action == "fixup" {
+		if err := r.addToSquashMessage(c, item); err != nil {
+			return true, err
+		}
+	}
+	change, err := pickChange(c, item.commit, 0, false, false, "rebase")
+	if err != nil {
+		return true, err
+	}
+	short := item.commit.String()[:7]
+	subject := change.msg.Subject()
+	if len(change.conflicts) > 0 {
+		msg := strings.TrimRight(change.msg.String(), "\n") + "\n\n# Conflicts:\n"
+		for _, path := range change.conflicts {
+			msg += "#\t" + path.String() + "\n"
+		}
+		if err := c.GitDir.WriteFile(mergeMsgFile, []byte(msg), 0644); err != nil {
+			return true, err
+		}
+		for _, f := range []File{r.file("stopped-sha"), rebaseHeadFile} {
+			if err := c.GitDir.WriteFile(f, []byte(item.commit.String()+"\n"), 0644); err != nil {
+				return true, err
+			}
+		}
+		return true, fmt.Errorf(`could not apply %v... %v
+hint: Resolve all conflicts manually, mark them as resolved with
+hint: "git add/rm <conflicted_files>", then run "git rebase --continue".
+hint: You can instead skip this commit: run "git rebase --skip".
+hint: To abort and get back to the state before "git rebase", run "git rebase --abort".`, short, subject)
+	}
+
+	if fastForward {
+		if err := UpdateRef(c, UpdateRefOptions{NoDeref: true, OldValue: head, CreateReflog: true}, "HEAD", item.commit, "rebase (pick): "+subject+" (dgit)"); err != nil {
+			return true, err
+		}
+	} else if err := r.commitItem(c, item, change.empty); err != nil {
+		return true, err
+	}
+
+	if item.action == "edit" {
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			return true, err
+		}
+		if err := c.GitDir.WriteFile(r.file("amend"), []byte(head.String()+"\n"), 0644); err != nil {
+			return true, err
+		}
+		if err := c.GitDir.WriteFile(r.file("stopped-sha"), []byte(item.commit.String()+"\n"), 0644); err != nil {
+			return true, err
+		}
+		fmt.Printf(`Stopped at %v...  %v
+You can amend the commit now, with
+
+  git commit --amend
+
+Once you are satisfied with your changes, run
+
+  git rebase --continue
+`, short, subject)
+		return true, nil
+	}
+	return false, nil
+}
+
+// Commits the result of applying item, which is in the index. If
+// wasEmpty is set, the original commit didn't change anything, so it's
+// kept even though the commit is empty.
+func (r *rebase) commitItem(c *Client, item rebaseItem, wasEmpty bool) error {
+	switch item.action {
+	case "squash", "fixup":
+		message, err := c.GitDir.ReadFile(r.file("message-squash"))
+		if err != nil {
+			return err
+		}
+		fixups, err := c.GitDir.ReadFile(r.file("current-fixups"))
+		if err != nil {
+			return err
+		}
+		last := len(r.todo) == 0 || (r.todo[0].action != "squash" && r.todo[0].action != "fixup")
+		msg := string(message)
+		if last && strings.Contains("\n"+string(fixups), "\nsquash ") {
+			if msg, err = c.editCommitMessage(msg); err != nil {
+				return err
+			}
+		}
+		if _, err := Commit(c, CommitOptions{Amend: true, NoEdit: true, CleanupMode: "strip"}, CommitMessage(msg), nil); err != nil && err != NoGlobalConfig {
+			return err
+		}
+		if last {
+			r.clearSquash(c)
+		}
+		return nil
+	}
+
+	r.clearSquash(c)
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	headTree, err := head.TreeID(c)
+	if err != nil {
+		return err
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return err
+	}
+	tree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return err
+	}
+	if tree == headTree && !wasEmpty {
+		// The change is already in HEAD, so the commit is dropped.
+		return nil
+	}
+
+	msg, err := item.commit.GetCommitMessage(c)
+	if err != nil {
+		return err
+	}
+	opts := CommitOptions{AllowEmpty: true, NoEdit: true}
+	if item.action == "reword" {
+		edited, err := c.editCommitMessage(msg.String())
+		if err != nil {
+			return err
+		}
+		msg = CommitMessage(edited)
+		opts.NoEdit = false
+	}
+	restore, err := useCommitAuthor(c, item.commit)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	if _, err := Commit(c, opts, msg, nil); err != nil && err != NoGlobalConfig {
+		return err
+	}
+	return nil
+}
+
+// Adds the message of a squash or fixup command to the combined message
+// of the series of squashes and fixups which it's in.
+func (r *rebase) addToSquashMessage(c *Client, item rebaseItem) error {
+	fixups, err := c.GitDir.ReadFile(r.file("current-fixups"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	n := strings.Count(string(fixups), "\n") + 2
+
+	var message string
+	if content, err := c.GitDir.ReadFile(r.file("message-squash")); err == nil {
+		// Update the number of commits in the first line.
+		message = string(content)
+		if nl := strings.IndexByte(message, '\n'); nl >= 0 {
+			message = message[nl+1:]
+		}
+	} else {
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+		msg, err := head.GetCommitMessage(c)
+		if err != nil {
+			return err
+		}
+		message = "# This is the 1st commit message:\n\n" + strings.TrimRight(msg.String(), "\n") + "\n"
+	}
+	message = fmt.Sprintf("# This is a combination of %d commits.\n", n) + message
+
+	msg, err := item.commit.GetCommitMessage(c)
+	if err != nil {
+		return err
+	}
+	body := strings.TrimRight(msg.String(), "\n")
+	if item.action == "squash" {
+		// The subject of an autosquash commit is only there to
+		// say where it goes, so it's commented out.
+		if strings.HasPrefix(body, "squash!") || strings.HasPrefix(body, "fixup!") {
+			body = "# " + body
+		}
+		message += fmt.Sprintf("\n# This is the commit message #%d:\n\n%v\n", n, body)
+	} else {
+		message += fmt.Sprintf("\n# The commit message #%d will be skipped:\n\n", n)
+		for _, line := range strings.Split(body, "\n") {
+			if line == "" {
+				message += "#\n"
+			} else {
+				message += "# " + line + "\n"
+			}
+		}
+	}
+	fixups = append(fixups, []byte(fmt.Sprintf("%v %v\n", item.action, item.commit))...)
+	if err := c.GitDir.WriteFile(r.file("current-fixups"), fixups, 0644); err != nil {
+		return err
+	}
+	return c.GitDir.WriteFile(r.file("message-squash"), []byte(message), 0644)
+}
+
+// Removes the state of a series of squash and fixup commands.
+func (r *rebase) clearSquash(c *Client) {
+	os.Remove(c.GitDir.File(r.file("message-squash")).String())
+	os.Remove(c.GitDir.File(r.file("current-fixups")).String())
+}
+
+// Finishes the rebase by updating the branch which was rebased to HEAD and
+// checking it out again.
+func (r *rebase) finish(c *Client) error {
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	if r.headName != "detached HEAD" {
+		if err := UpdateRef(c, UpdateRefOptions{CreateReflog: true}, r.headName, head, fmt.Sprintf("rebase (finish): %v onto %v (dgit)", r.headName, r.onto)); err != nil {
+			return err
+		}
+		if err := SymbolicRefUpdate(c, SymbolicRefOptions{}, "HEAD", RefSpec(r.headName), fmt.Sprintf("rebase (finish): returning to %v (dgit)", r.headName)); err != nil {
+			return err
+		}
+	}
+	if err := r.remove(c); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Successfully rebased and updated %v.\n", r.headName)
+	return nil
+}
+
+// Continues a rebase which stopped, committing the resolution of any
+// conflicts first.
+func rebaseContinue(c *Client) error {
+	r, err := loadRebase(c)
+	if err != nil {
+		return err
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return err
+	}
+	if len(idx.GetUnmerged()) > 0 {
+		return fmt.Errorf("You must edit all merge conflicts and then\nmark them as resolved using git add")
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	headTree, err := head.TreeID(c)
+	if err != nil {
+		return err
+	}
+	tree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return err
+	}
+
+	stopped, err := c.readHeadFile(r.file("stopped-sha"))
+	switch {
+	case err != nil:
+		// The rebase stopped for an exec or break command, so
+		// there's nothing to commit.
+	case c.GitDir.File(r.file("amend")).Exists():
+		// The rebase stopped for an edit command, and the user may
+		// have staged changes to amend the commit with.
+		if tree != headTree {
+			amend, err := c.readHeadFile(r.file("amend"))
+			if err != nil {
+				return err
+			}
+			if amend != head {
+				return fmt.Errorf("You have uncommitted changes in your working tree. Please, commit them\nfirst and then run 'git rebase --continue' again.")
+			}
+			msg, err := head.GetCommitMessage(c)
+			if err != nil {
+				return err
+			}
+			if _, err := Commit(c, CommitOptions{Amend: true, NoEdit: true}, msg, nil); err != nil && err != NoGlobalConfig {
+				return err
+			}
+		}
+	case len(r.done) > 0:
+		// The rebase stopped because of conflicts, so the resolution
+		// needs to be committed.
+		cur := r.done[len(r.done)-1]
+		if cur.commit != stopped {
+			return fmt.Errorf("could not find the commit the rebase stopped at")
+		}
+		origTree, err := cur.commit.TreeID(c)
+		if err != nil {
+			return err
+		}
+		wasEmpty := false
+		if parents, err := cur.commit.Parents(c); err == nil && len(parents) == 1 {
+			parentTree, err := parents[0].TreeID(c)
+			wasEmpty = err == nil && parentTree == origTree
+		}
+		if err := r.commitItem(c, cur, wasEmpty); err != nil {
+			return err
+		}
+	}
+	r.clearStop(c)
+	return r.run(c)
+}
+
+// Skips the command the rebase stopped at, discarding any changes it made,
+// and continues the rebase.
+func rebaseSkip(c *Client) error {
+	r, err := loadRebase(c)
+	if err != nil {
+		return err
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	if err := ResetMode(c, ResetOptions{Hard: true}, head); err != nil {
+		return err
+	}
+	if len(r.done) > 0 {
+		if cur := r.done[len(r.done)-1]; cur.action == "squash" || cur.action == "fixup" {
+			r.clearSquash(c)
+		}
+	}
+	r.clearStop(c)
+	return r.run(c)
+}
+
+// Aborts the rebase, returning to the branch and commit it started from.
+func rebaseAbort(c *Client) error {
+	r, err := loadRebase(c)
+	if err != nil {
+		return err
+	}
+	if r.headName == "detached HEAD" {
+		if err := UpdateRef(c, UpdateRefOptions{NoDeref: true}, "HEAD", r.origHead, "rebase (abort): returning to "+r.origHead.String()+" (dgit)"); err != nil {
+			return err
+		}
+	} else if err := SymbolicRefUpdate(c, SymbolicRefOptions{}, "HEAD", RefSpec(r.headName), "rebase (abort): returning to "+r.headName+" (dgit)"); err != nil {
+		return err
+	}
+	if err := ResetMode(c, ResetOptions{Hard: true}, r.origHead); err != nil {
+		return err
+	}
+	r.clearStop(c)
+	return r.remove(c)
+}
+
+// Lets the user edit the remaining commands of the rebase.
+func rebaseEditTodo(c *Client) error {
+	r, err := loadRebase(c)
+	if err != nil {
+		return err
+	}
+	if err := r.editTodo(c); err != nil {
+		return err
+	}
+	return r.save(c)
+}
@@ -0,0 +1,167 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRebase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrebase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	subjects := func() []string {
+		t.Helper()
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for head != (CommitID{}) {
+			msg, err := head.GetCommitMessage(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, msg.Subject())
+			parents, err := head.Parents(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parents) == 0 {
+				break
+			}
+			head = parents[0]
+		}
+		return got
+	}
+
+	// The branch adds bar and baz, and changes foo. Master adds baz
+	// too, and changes foo in a way that conflicts.
+	base := commitFile(t, c, "foo.txt", "foo\n", "base")
+	if err := Checkout(c, CheckoutOptions{Quiet: true, Branch: "side"}, "master", nil); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, c, "bar.txt", "bar\n", "bar")
+	commitFile(t, c, "baz.txt", "baz\n", "baz")
+	commitFile(t, c, "foo.txt", "side\n", "foo")
+	if err := Checkout(c, CheckoutOptions{Quiet: true}, "master", nil); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, c, "baz.txt", "baz\n", "baz upstream")
+	master := commitFile(t, c, "foo.txt", "master\n", "master")
+	if err := Checkout(c, CheckoutOptions{Quiet: true}, "side", nil); err != nil {
+		t.Fatal(err)
+	}
+	orig, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rebase should skip baz, which is already in master, and stop
+	// at the conflict in foo.
+	if err := Rebase(c, RebaseOptions{}, master, ""); err == nil {
+		t.Fatal("Expected a conflict")
+	}
+	if !c.IsRebasing() {
+		t.Fatal("Rebase state was not saved")
+	}
+	if err := Rebase(c, RebaseOptions{}, base, ""); err == nil {
+		t.Error("Was able to start a rebase while one is in progress")
+	}
+
+	// Aborting should go back to the branch.
+	if err := Rebase(c, RebaseOptions{Abort: true}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if c.IsRebasing() {
+		t.Error("Rebase state left after abort")
+	}
+	if b := c.GetHeadBranch(); b != "refs/heads/side" {
+		t.Errorf("Unexpected branch after abort: got %v", b)
+	}
+	if head, err := c.GetHeadCommit(); err != nil || head != orig {
+		t.Errorf("Unexpected HEAD after abort: got %v, %v want %v", head, err, orig)
+	}
+
+	// Resolving the conflict and continuing should finish the rebase.
+	if err := Rebase(c, RebaseOptions{}, master, ""); err == nil {
+		t.Fatal("Expected a conflict")
+	}
+	if err := Rebase(c, RebaseOptions{Continue: true}, nil, ""); err == nil {
+		t.Error("Was able to continue with unmerged files")
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("resolved\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rebase(c, RebaseOptions{Continue: true}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if c.IsRebasing() {
+		t.Error("Rebase state left after finishing")
+	}
+	if b := c.GetHeadBranch(); b != "refs/heads/side" {
+		t.Errorf("Unexpected branch after rebase: got %v", b)
+	}
+	want := []string{"foo", "bar", "master", "baz upstream", "base"}
+	if got := subjects(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected history: got %q want %q", got, want)
+	}
+
+	// Rebasing again shouldn't do anything.
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Rebase(c, RebaseOptions{}, master, ""); err != nil {
+		t.Fatal(err)
+	}
+	if newHead, err := c.GetHeadCommit(); err != nil || newHead != head {
+		t.Errorf("Up to date rebase changed HEAD: got %v, %v want %v", newHead, err, head)
+	}
+}
+
+func TestAutoSquash(t *testing.T) {
+	item := func(b byte, subject string) rebaseItem {
+		var cmt CommitID
+		cmt[0] = b
+		return rebaseItem{action: "pick", commit: cmt, rest: subject}
+	}
+	items := []rebaseItem{
+		item(0x10, "first"),
+		item(0x20, "second commit"),
+		item(0x30, "fixup! second"),
+		item(0x40, "squash! first"),
+		item(0x50, "fixup! 1000"),
+		item(0x60, "fixup! nothing"),
+	}
+	got := autoSquash(items)
+	var lines []string
+	for _, i := range got {
+		lines = append(lines, i.action+" "+i.rest)
+	}
+	want := []string{
+		"pick first",
+		"squash squash! first",
+		"fixup fixup! 1000",
+		"pick second commit",
+		"fixup fixup! second",
+		"pick fixup! nothing",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("Unexpected todo list:\ngot  %q\nwant %q", lines, want)
+	}
+}
@@ -1,9 +1,15 @@
 package git
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type ReflogDeleteOptions struct{}
@@ -11,51 +17,244 @@ type ReflogDeleteOptions struct{}
 type ReflogExpireOptions struct {
 	ReflogDeleteOptions
 
+	// Entries older than this are removed. If empty, gc.reflogExpire
+	// is used, or 90 days if it's not set.
 	Expire string
-	All    bool
+
+	// Entries older than this which are not reachable from the
+	// current value of the ref are removed. If empty,
+	// gc.reflogExpireUnreachable is used, or 30 days if it's not set.
+	ExpireUnreachable string
+
+	All bool
+}
+
+// A reflogEntry is a single line of a reflog.
+type reflogEntry struct {
+	Old, New Sha1
+	Time     time.Time
+	Message  string
+
+	// The original line, without the trailing newline.
+	line string
 }
 
 // Returns true if a reflog exists for refname r under client.
 func ReflogExists(c *Client, r Refname) bool {
-	path := filepath.Join(c.GitDir.String(), "logs", string(r))
-	return c.GitDir.File(File(path)).Exists()
+	return c.GitDir.File(File("logs/" + string(r))).Exists()
 }
 
-func ReflogExpire(c *Client, opts ReflogExpireOptions, refpatterns []string) error {
-	if opts.Expire != "now" || len(refpatterns) != 0 {
-		return fmt.Errorf("Only reflog --expire=now --all currently supported")
+// Reads the entries of the reflog for the ref named name, oldest first.
+// A reflog which doesn't exist has no entries.
+func readReflog(c *Client, name string) ([]reflogEntry, error) {
+	data, err := ioutil.ReadFile(c.GitDir.File(File("logs/" + name)).String())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []reflogEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		e, err := parseReflogEntry(line)
+		if err != nil {
+			// Older versions of dgit could write messages
+			// with newlines in them, so treat anything we
+			// can't parse as a continuation of the previous
+			// entry's message.
+			if len(entries) == 0 {
+				return nil, fmt.Errorf("Invalid reflog for %v: %v", name, err)
+			}
+			entries[len(entries)-1].line += "\n" + line
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Parses a reflog line of the form
+// "<old> <new> Name <email> <timestamp> <tz>\t<message>"
+func parseReflogEntry(line string) (reflogEntry, error) {
+	e := reflogEntry{line: line}
+	header := line
+	if tab := strings.IndexByte(line, '\t'); tab >= 0 {
+		header = line[:tab]
+		e.Message = line[tab+1:]
+	}
+	if len(header) < 82 {
+		return e, fmt.Errorf("malformed entry %q", line)
+	}
+	var err error
+	if e.Old, err = Sha1FromString(header[:40]); err != nil {
+		return e, err
 	}
+	if e.New, err = Sha1FromString(header[41:81]); err != nil {
+		return e, err
+	}
+	email := strings.LastIndexByte(header, '>')
+	if email < 0 {
+		return e, fmt.Errorf("malformed entry %q", line)
+	}
+	fields := strings.Fields(header[email+1:])
+	if len(fields) < 1 {
+		return e, fmt.Errorf("malformed entry %q", line)
+	}
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return e, err
+	}
+	e.Time = time.Unix(ts, 0)
+	return e, nil
+}
+
+// Returns the names of every ref under c that has a reflog.
+func reflogNames(c *Client) ([]string, error) {
+	var names []string
+	logdir := c.GitDir.File("logs").String()
+	err := filepath.Walk(logdir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(logdir, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	return names, err
+}
+
+// ReflogExpire removes old entries from the reflogs of refpatterns (or
+// all reflogs if opts.All is set.)
+func ReflogExpire(c *Client, opts ReflogExpireOptions, refpatterns []string) error {
 	if opts.All && len(refpatterns) != 0 {
 		return fmt.Errorf("Can not combine --all with explicit refs")
 	}
+	if opts.Expire == "" {
+		opts.Expire = c.GetConfig("gc.reflogexpire")
+		if opts.Expire == "" {
+			opts.Expire = "90.days.ago"
+		}
+	}
+	if opts.ExpireUnreachable == "" {
+		opts.ExpireUnreachable = c.GetConfig("gc.reflogexpireunreachable")
+		if opts.ExpireUnreachable == "" {
+			opts.ExpireUnreachable = "30.days.ago"
+		}
+	}
+	expire, err := parseExpiry(opts.Expire)
+	if err != nil {
+		return err
+	}
+	unreachable, err := parseExpiry(opts.ExpireUnreachable)
+	if err != nil {
+		return err
+	}
 
-	// If expire is now, we just truncate applicable reflogs
-	if opts.Expire == "now" && opts.All {
-		// This is a hack to get fsck's test setup working. Since
-		// we're expiring everything, we just truncate everything
-		// in the .git/logs directory. (We can't delete them, because
-		// the reflogs need to still exist.)
-		//
-		// (There's a catch-22 where the fsck tests depend on reflog,
-		// and the reflog tests depend on fsck.)
-		filepath.Walk(filepath.Join(c.GitDir.String(), "logs"),
-			func(path string, info os.FileInfo, err error) error {
-				if info.IsDir() {
-					return nil
-				}
-
-				// Use Create to truncate the file. We know it exists
-				// because we got here from a Walk to it, so we don't
-				// worry about creating new files.
-				fi, err := os.Create(path)
-				if err != nil {
-					return err
-				}
-				if err := fi.Close(); err != nil {
-					return err
-				}
-				return nil
-			})
+	var names []string
+	if opts.All {
+		if names, err = reflogNames(c); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range refpatterns {
+		name := pattern
+		if name != "HEAD" {
+			name = string(dwimRef(c, pattern))
+		}
+		if name == "" || !ReflogExists(c, Refname(name)) {
+			return fmt.Errorf("error: reflog could not be found: '%v'", pattern)
+		}
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		if err := expireReflog(c, name, expire, unreachable); err != nil {
+			return err
+		}
 	}
 	return nil
 }
+
+// Rewrites the reflog for name without the entries which are older than
+// expire, or older than unreachable and not reachable from the current
+// value of the ref.
+func expireReflog(c *Client, name string, expire, unreachable time.Time) error {
+	entries, err := readReflog(c, name)
+	if err != nil {
+		return err
+	}
+
+	var tip Commitish
+	if name == "HEAD" {
+		if cmt, err := c.GetHeadCommit(); err == nil {
+			tip = cmt
+		}
+	} else if cmt, err := RefSpec(name).CommitID(c); err == nil {
+		tip = cmt
+	}
+	var kept bytes.Buffer
+	for _, e := range entries {
+		if !e.Time.After(expire) {
+			continue
+		}
+		if !e.Time.After(unreachable) {
+			if tip == nil || e.New == (Sha1{}) || !CommitID(e.New).IsAncestor(c, tip) {
+				continue
+			}
+		}
+		fmt.Fprintln(&kept, e.line)
+	}
+
+	// We don't remove the file even if everything expired, because the
+	// reflog should still exist for the ref.
+	return ioutil.WriteFile(c.GitDir.File(File("logs/"+name)).String(), kept.Bytes(), 0644)
+}
+
+// Removes the nth newest entry from the reflog of the ref name, like "git
+// reflog delete --rewrite --updateref". The entry after it is rewritten to
+// follow on from the one before it, and the ref is updated to the newest
+// remaining entry, or deleted along with its reflog if there are none left.
+func deleteReflogEntry(c *Client, name string, n int) error {
+	entries, err := readReflog(c, name)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= len(entries) {
+		return fmt.Errorf("log for '%v' only has %d entries", prettyRefName(name), len(entries))
+	}
+	i := len(entries) - 1 - n
+	if len(entries) == 1 {
+		return UpdateRef(c, UpdateRefOptions{Delete: true, NoDeref: true}, name, CommitID{}, "")
+	}
+	if i+1 < len(entries) {
+		next := &entries[i+1]
+		next.Old = entries[i].Old
+		next.line = next.Old.String() + next.line[40:]
+	}
+	entries = append(entries[:i], entries[i+1:]...)
+
+	// Updating the ref adds an entry to the reflog, so the log is
+	// rewritten afterwards.
+	if err := UpdateRef(c, UpdateRefOptions{NoDeref: true}, name, CommitID(entries[len(entries)-1].New), ""); err != nil {
+		return err
+	}
+	var kept bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintln(&kept, e.line)
+	}
+	return ioutil.WriteFile(c.GitDir.File(File("logs/"+name)).String(), kept.Bytes(), 0644)
+}
@@ -3,6 +3,7 @@ package git
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"strings"
 )
 
@@ -68,13 +69,28 @@ func (r RefSpec) File(c *Client) File {
 }
 
 // Returns the value of RefSpec in Client's GitDir, or the empty string
-// if it doesn't exist.
+// if it doesn't exist. Loose refs take precedence over packed refs.
 func (r RefSpec) Value(c *Client) (string, error) {
 	f := r.File(c)
 	val, err := f.ReadAll()
+	if err != nil && os.IsNotExist(err) {
+		if packed, ok, perr := c.getPackedRef(r.String()); perr == nil && ok {
+			return packed.Value.String(), nil
+		}
+	}
 	return strings.TrimSpace(val), err
 }
 
+// Returns true if r exists in c's GitDir, either as a loose ref or in
+// the packed-refs file.
+func (r RefSpec) Exists(c *Client) bool {
+	if r.File(c).Exists() {
+		return true
+	}
+	_, ok, err := c.getPackedRef(r.String())
+	return err == nil && ok
+}
+
 func (r RefSpec) Sha1(c *Client) (Sha1, error) {
 	v, err := r.Value(c)
 	if err != nil {
@@ -152,7 +168,7 @@ func GetBranch(c *Client, branchname string) (Branch, error) {
 
 // Returns true if the branch exists under c's GitDir
 func (b Branch) Exists(c *Client) bool {
-	return c.GitDir.File(File(b)).Exists()
+	return RefSpec(b).Exists(c)
 }
 
 // Implements Commitish interface on Branch.
@@ -185,10 +201,8 @@ func (b Branch) BranchName() string {
 
 // Delete a branch
 func (b Branch) DeleteBranch(c *Client) error {
-	location := c.GitDir.File(File(b))
-	err := location.Remove()
-	if err != nil {
-		return err
+	if !b.Exists(c) {
+		return InvalidBranch
 	}
-	return nil
+	return c.deleteRef(b.String())
 }
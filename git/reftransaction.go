@@ -0,0 +1,469 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RefUpdateOptions are the options for a single update in a
+// RefTransaction.
+type RefUpdateOptions struct {
+	// Update a symbolic ref itself, rather than the ref that it
+	// points to.
+	NoDeref bool
+
+	// Create the reflog for the ref if it doesn't already exist.
+	CreateReflog bool
+
+	// The message to record in the reflog.
+	Reason string
+}
+
+type refTransactionState int
+
+const (
+	refTransactionOpen = refTransactionState(iota)
+	refTransactionPrepared
+	refTransactionClosed
+)
+
+type refUpdateType int
+
+const (
+	refUpdate = refUpdateType(iota)
+	refDelete
+	refVerify
+)
+
+// A single queued update in a RefTransaction.
+type queuedRefUpdate struct {
+	typ  refUpdateType
+	name string
+	opts RefUpdateOptions
+
+	newval Sha1
+
+	// The value the ref must have for the transaction to succeed.
+	// If oldval is nil, the value is not checked. If it's the zero
+	// Sha1, the ref must not exist.
+	oldval *Sha1
+
+	// Filled in when the transaction is prepared.
+	ref        string
+	symref     string
+	lockname   string
+	prev       Sha1
+	prevExists bool
+
+	// The content of the loose ref's file before the transaction, if
+	// there was one, so that a rollback can restore it exactly.
+	prevLoose       []byte
+	prevLooseExists bool
+}
+
+// A RefTransaction is a set of ref updates which are either all applied or
+// all rejected. It's started with NewRefTransaction, has updates queued with
+// Update, Create, Delete and Verify, and is finished with Commit or Abort.
+//
+// Every ref in the transaction is locked by creating a "<ref>.lock" file
+// before its current value is checked, so that concurrent processes can't
+// update the same ref between the check and the update.
+type RefTransaction struct {
+	c       *Client
+	updates []*queuedRefUpdate
+	state   refTransactionState
+
+	// The lock on packed-refs, held if any ref is being deleted so that
+	// pack-refs can't write the ref back. If a ref being deleted is
+	// packed, rewritePacked is set, packed holds the packed refs that
+	// will remain after the deletion and oldPacked the content of
+	// packed-refs before it, to restore if the transaction has to be
+	// rolled back.
+	packedLock    *os.File
+	rewritePacked bool
+	packed        []PackedRef
+	oldPacked     []byte
+}
+
+// NewRefTransaction starts a new ref transaction in c's GitDir.
+func NewRefTransaction(c *Client) *RefTransaction {
+	return &RefTransaction{c: c}
+}
+
+func (t *RefTransaction) queue(u *queuedRefUpdate) error {
+	if t.state != refTransactionOpen {
+		return fmt.Errorf("Can not add updates to a prepared or closed transaction")
+	}
+	if err := validateRefName(u.name); err != nil {
+		return err
+	}
+	t.updates = append(t.updates, u)
+	return nil
+}
+
+// Update queues an update of ref to newval. If oldval is not nil, the
+// ref must currently have the value oldval (or not exist, if oldval is
+// the zero Sha1.)
+func (t *RefTransaction) Update(ref string, newval Sha1, oldval *Sha1, opts RefUpdateOptions) error {
+	return t.queue(&queuedRefUpdate{typ: refUpdate, name: ref, newval: newval, oldval: oldval, opts: opts})
+}
+
+// Create queues the creation of ref with the value newval. The ref must
+// not already exist.
+func (t *RefTransaction) Create(ref string, newval Sha1, opts RefUpdateOptions) error {
+	if newval == (Sha1{}) {
+		return fmt.Errorf("Create %v: zero new value", ref)
+	}
+	return t.queue(&queuedRefUpdate{typ: refUpdate, name: ref, newval: newval, oldval: &Sha1{}, opts: opts})
+}
+
+// Delete queues the deletion of ref. If oldval is not nil, the ref must
+// currently have the value oldval.
+func (t *RefTransaction) Delete(ref string, oldval *Sha1, opts RefUpdateOptions) error {
+	if oldval != nil && *oldval == (Sha1{}) {
+		return fmt.Errorf("Delete %v: zero old value", ref)
+	}
+	return t.queue(&queuedRefUpdate{typ: refDelete, name: ref, oldval: oldval, opts: opts})
+}
+
+// Verify queues a check that ref has the value oldval, without changing
+// it. If oldval is nil or the zero Sha1, the ref must not exist.
+func (t *RefTransaction) Verify(ref string, oldval *Sha1, opts RefUpdateOptions) error {
+	if oldval == nil {
+		oldval = &Sha1{}
+	}
+	return t.queue(&queuedRefUpdate{typ: refVerify, name: ref, oldval: oldval, opts: opts})
+}
+
+// Prepare locks every ref in the transaction and checks their old values.
+// If it succeeds, Commit is guaranteed not to fail because of a concurrent
+// update. If it fails, the transaction is aborted.
+func (t *RefTransaction) Prepare() error {
+	if t.state != refTransactionOpen {
+		return fmt.Errorf("Transaction has already been prepared or closed")
+	}
+	if err := t.prepare(); err != nil {
+		t.Abort()
+		return err
+	}
+	t.state = refTransactionPrepared
+	return nil
+}
+
+func (t *RefTransaction) prepare() error {
+	seen := make(map[string]struct{})
+	var deletes []string
+	for _, u := range t.updates {
+		ref, symref, err := resolveRefForUpdate(t.c, u.name, u.opts.NoDeref)
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[ref]; ok {
+			return fmt.Errorf("Multiple updates for ref '%v' not allowed", ref)
+		}
+		seen[ref] = struct{}{}
+		u.ref, u.symref = ref, symref
+
+		lock, err := lockRef(t.c, ref)
+		if err != nil {
+			return err
+		}
+		u.lockname = lock.Name()
+
+		// Now that we hold the lock, the value can't change underneath
+		// us.
+		u.prev, u.prevExists, err = readRefValue(t.c, ref)
+		if err != nil {
+			lock.Close()
+			return err
+		}
+		if err := u.checkOld(); err != nil {
+			lock.Close()
+			return err
+		}
+		u.prevLoose, err = ioutil.ReadFile(t.c.GitDir.File(File(ref)).String())
+		switch {
+		case err == nil:
+			u.prevLooseExists = true
+		case os.IsNotExist(err):
+			err = nil
+		default:
+			lock.Close()
+			return err
+		}
+
+		switch u.typ {
+		case refUpdate:
+			if strings.HasPrefix(ref, "refs/") {
+				_, err = fmt.Fprintf(lock, "%v\n", u.newval)
+			} else {
+				// A detached HEAD (or other top level ref)
+				// has always been written without a newline.
+				_, err = fmt.Fprintf(lock, "%v", u.newval)
+			}
+		case refDelete:
+			deletes = append(deletes, ref)
+		}
+		if cerr := lock.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(deletes) > 0 {
+		lock, err := lockPackedRefs(t.c)
+		if err != nil {
+			return err
+		}
+		t.packedLock = lock
+		packed, err := ReadPackedRefs(t.c)
+		if err != nil {
+			return err
+		}
+	outer:
+		for _, ref := range packed {
+			for _, del := range deletes {
+				if ref.Name == del {
+					t.rewritePacked = true
+					continue outer
+				}
+			}
+			t.packed = append(t.packed, ref)
+		}
+		if t.rewritePacked {
+			t.oldPacked, err = ioutil.ReadFile(t.c.GitDir.File("packed-refs").String())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Commit applies every update in the transaction, preparing it first if
+// it hasn't been prepared. If any update can't be applied, the ones that
+// were already applied are rolled back.
+func (t *RefTransaction) Commit() error {
+	switch t.state {
+	case refTransactionOpen:
+		if err := t.Prepare(); err != nil {
+			return err
+		}
+	case refTransactionClosed:
+		return fmt.Errorf("Transaction has already been closed")
+	}
+	defer t.Abort()
+
+	// Rewrite packed-refs before removing any loose refs, so that a
+	// stale packed value never becomes visible. The lock stays held
+	// until Abort, so that if the loose refs can't all be updated the
+	// old packed-refs can be put back without clobbering anyone else's
+	// changes.
+	if t.rewritePacked {
+		var buf bytes.Buffer
+		writePackedRefs(&buf, t.packed)
+		if err := replacePackedRefs(t.c, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	var applied []*queuedRefUpdate
+	for _, u := range t.updates {
+		var err error
+		switch u.typ {
+		case refUpdate:
+			err = os.Rename(u.lockname, t.c.GitDir.File(File(u.ref)).String())
+			if err == nil {
+				// The lock is gone, make sure Abort doesn't
+				// remove someone else's.
+				u.lockname = ""
+			}
+		case refDelete:
+			err = os.Remove(t.c.GitDir.File(File(u.ref)).String())
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		case refVerify:
+			continue
+		}
+		if err != nil {
+			for _, a := range applied {
+				a.rollback(t.c)
+			}
+			if t.rewritePacked {
+				replacePackedRefs(t.c, t.oldPacked)
+			}
+			return err
+		}
+		applied = append(applied, u)
+	}
+
+	// Only log the updates once we know they all happened.
+	for _, u := range applied {
+		if err := u.log(t.c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Abort releases all locks held by the transaction without applying any
+// of its updates.
+func (t *RefTransaction) Abort() error {
+	for _, u := range t.updates {
+		if u.lockname != "" {
+			os.Remove(u.lockname)
+			u.lockname = ""
+		}
+	}
+	if t.packedLock != nil {
+		t.packedLock.Close()
+		os.Remove(t.packedLock.Name())
+		t.packedLock = nil
+	}
+	t.state = refTransactionClosed
+	return nil
+}
+
+// Checks that the value of the ref read while preparing matches the
+// expected old value.
+func (u *queuedRefUpdate) checkOld() error {
+	if u.oldval == nil {
+		return nil
+	}
+	switch {
+	case *u.oldval == (Sha1{}) && u.prevExists:
+		return fmt.Errorf("Cannot lock ref '%v': reference already exists", u.name)
+	case *u.oldval != (Sha1{}) && !u.prevExists:
+		return fmt.Errorf("Cannot lock ref '%v': unable to resolve reference '%v'", u.name, u.ref)
+	case *u.oldval != u.prev:
+		return fmt.Errorf("Cannot lock ref '%v': is at %v but expected %v", u.name, u.prev, *u.oldval)
+	}
+	return nil
+}
+
+// Restores the ref to the value it had before the transaction. If it was
+// a loose ref, its file is written back exactly as it was, so that a
+// detached HEAD stays without a trailing newline. If it was only packed,
+// the packed value is visible again once the loose ref is removed.
+func (u *queuedRefUpdate) rollback(c *Client) {
+	f := c.GitDir.File(File(u.ref))
+	if !u.prevLooseExists {
+		os.Remove(f.String())
+		return
+	}
+	ioutil.WriteFile(f.String(), u.prevLoose, 0644)
+}
+
+// Records the update in the reflog of the ref, and of the symbolic ref that
+// pointed to it if the ref was dereferenced.
+func (u *queuedRefUpdate) log(c *Client) error {
+	logfile := c.GitDir.File(File("logs/" + u.ref))
+	if u.typ == refDelete {
+		if logfile.Exists() {
+			return logfile.Remove()
+		}
+		return nil
+	}
+	create := u.opts.CreateReflog || u.ref == "HEAD"
+	if err := updateReflog(c, create, logfile, CommitID(u.prev), CommitID(u.newval), u.opts.Reason); err != nil {
+		return err
+	}
+	if u.symref != "" {
+		symlog := c.GitDir.File(File("logs/" + u.symref))
+		return updateReflog(c, true, symlog, CommitID(u.prev), CommitID(u.newval), u.opts.Reason)
+	}
+	return nil
+}
+
+// Follows symbolic refs starting from name, unless noDeref is set, and
+// returns the ref which should be updated. If name was a symbolic ref, it
+// is returned as symref.
+func resolveRefForUpdate(c *Client, name string, noDeref bool) (ref, symref string, err error) {
+	ref = name
+	if noDeref {
+		return ref, "", nil
+	}
+	for i := 0; i < 5; i++ {
+		val, err := RefSpec(ref).Value(c)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return ref, symref, nil
+			}
+			return "", "", err
+		}
+		if !strings.HasPrefix(val, "ref: ") {
+			return ref, symref, nil
+		}
+		symref = name
+		ref = strings.TrimSpace(strings.TrimPrefix(val, "ref: "))
+		if err := validateRefName(ref); err != nil {
+			return "", "", err
+		}
+	}
+	return "", "", fmt.Errorf("Too many levels of symbolic refs for %v", name)
+}
+
+// Returns the current value of ref, and whether it exists. If ref is a
+// symbolic ref, the value of the ref that it points to is returned.
+func readRefValue(c *Client, ref string) (Sha1, bool, error) {
+	val, err := RefSpec(ref).Value(c)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Sha1{}, false, nil
+		}
+		return Sha1{}, false, err
+	}
+	if strings.HasPrefix(val, "ref: ") {
+		target := strings.TrimSpace(strings.TrimPrefix(val, "ref: "))
+		if !RefSpec(target).Exists(c) {
+			return Sha1{}, false, nil
+		}
+		sha, err := RefSpec(target).Sha1(c)
+		return sha, err == nil, err
+	}
+	sha, err := Sha1FromString(val)
+	if err != nil {
+		return Sha1{}, false, err
+	}
+	return sha, true, nil
+}
+
+// Locks ref by creating ref.lock in c's GitDir. The caller is responsible
+// for closing and removing (or renaming) the lock file.
+func lockRef(c *Client, ref string) (*os.File, error) {
+	lockname := c.GitDir.File(File(ref + ".lock")).String()
+	if err := os.MkdirAll(filepath.Dir(lockname), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(lockname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("Cannot lock ref '%v': Unable to create '%v': File exists.", ref, lockname)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Does a basic sanity check on a ref name, so that it can't be used to
+// write outside of the GitDir or to clobber a lock file.
+func validateRefName(name string) error {
+	switch {
+	case name == "",
+		strings.HasPrefix(name, "/"),
+		strings.HasSuffix(name, "/"),
+		strings.HasSuffix(name, ".lock"),
+		strings.Contains(name, ".."),
+		strings.Contains(name, "//"),
+		strings.ContainsAny(name, " ~^:?*[\\\x00\n"):
+		return fmt.Errorf("Invalid ref name: '%v'", name)
+	}
+	return nil
+}
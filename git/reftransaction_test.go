@@ -0,0 +1,176 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRefTransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitreftx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	first, err := Commit(c, CommitOptions{AllowEmpty: true}, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Commit(c, CommitOptions{AllowEmpty: true}, "second", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := Sha1(first), Sha1(second)
+
+	value := func(ref string) Sha1 {
+		t.Helper()
+		sha, ok, err := readRefValue(c, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return Sha1{}
+		}
+		return sha
+	}
+
+	// A failed verification rejects every update, and leaves no locks.
+	tx := NewRefTransaction(c)
+	if err := tx.Create("refs/heads/new", a, RefUpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Update("refs/heads/master", a, &a, RefUpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Error("Expected transaction with stale old value to fail")
+	}
+	if RefSpec("refs/heads/new").Exists(c) {
+		t.Error("refs/heads/new was created by a failed transaction")
+	}
+	if c.GitDir.File("refs/heads/new.lock").Exists() {
+		t.Error("Lock file was left behind by a failed transaction")
+	}
+
+	// A locked ref can't be updated.
+	lock, err := lockRef(c, "refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/heads/master", first, ""); err == nil {
+		t.Error("Was able to update a locked ref")
+	}
+	lock.Close()
+	os.Remove(lock.Name())
+
+	// Updating through HEAD updates the branch and both reflogs.
+	tx = NewRefTransaction(c)
+	if err := tx.Update("HEAD", a, &b, RefUpdateOptions{Reason: "reset"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Verify("refs/heads/nope", nil, RefUpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := value("refs/heads/master"); got != a {
+		t.Errorf("Unexpected master: got %v want %v", got, a)
+	}
+	for _, log := range []string{"logs/HEAD", "logs/refs/heads/master"} {
+		content, err := c.GitDir.ReadFile(File(log))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if last := lines[len(lines)-1]; !strings.HasPrefix(last, b.String()+" "+a.String()) || !strings.HasSuffix(last, "\treset") {
+			t.Errorf("Unexpected %v entry: %v", log, last)
+		}
+	}
+
+	// Deleting a packed ref removes it from packed-refs.
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/heads/other", second, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := PackRefs(c, PackRefsOptions{All: true, Prune: true}); err != nil {
+		t.Fatal(err)
+	}
+	stdin := "delete refs/heads/other " + b.String() + "\nupdate refs/heads/master " + b.String() + " " + a.String() + "\n"
+	if err := UpdateRef(c, UpdateRefOptions{Stdin: strings.NewReader(stdin)}, "", CommitID{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if RefSpec("refs/heads/other").Exists(c) {
+		t.Error("Packed ref still exists after deletion")
+	}
+	if got := value("refs/heads/master"); got != b {
+		t.Errorf("Unexpected master: got %v want %v", got, b)
+	}
+
+	// The same thing, NUL terminated, but with a failing verify.
+	stdin = "update refs/heads/master\x00" + a.String() + "\x00\x00verify refs/heads/master\x00" + a.String() + "\x00"
+	if err := UpdateRef(c, UpdateRefOptions{Stdin: strings.NewReader(stdin), NullTerminate: true}, "", CommitID{}, ""); err == nil {
+		t.Error("Expected error for duplicate ref in transaction")
+	}
+	if got := value("refs/heads/master"); got != b {
+		t.Errorf("Master was updated by failed transaction: got %v want %v", got, b)
+	}
+
+	// If an update fails while committing, the refs which were already
+	// changed, and packed-refs, are put back exactly as they were.
+	if err := UpdateRef(c, UpdateRefOptions{NoDeref: true}, "HEAD", first, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/heads/other", second, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := PackRefs(c, PackRefsOptions{All: true, Prune: true}); err != nil {
+		t.Fatal(err)
+	}
+	oldHead, err := c.GitDir.ReadFile("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPacked, err := c.GitDir.ReadFile("packed-refs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx = NewRefTransaction(c)
+	if err := tx.Update("HEAD", b, &a, RefUpdateOptions{NoDeref: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete("refs/heads/other", &b, RefUpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Create("refs/heads/new", a, RefUpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(tx.updates[2].lockname)
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Expected commit with a missing lock to fail")
+	}
+	if head, err := c.GitDir.ReadFile("HEAD"); err != nil || string(head) != string(oldHead) {
+		t.Errorf("HEAD not restored: got %q, %v want %q", head, err, oldHead)
+	}
+	if packed, err := c.GitDir.ReadFile("packed-refs"); err != nil || string(packed) != string(oldPacked) {
+		t.Errorf("packed-refs not restored: got %q, %v want %q", packed, err, oldPacked)
+	}
+	if got := value("refs/heads/other"); got != b {
+		t.Errorf("Deleted ref not restored: got %v want %v", got, b)
+	}
+	if files, err := filepath.Glob(c.GitDir.File("packed-refs.*").String()); err != nil || len(files) != 0 {
+		t.Errorf("Lock or temporary files left behind: %v (%v)", files, err)
+	}
+}
@@ -120,6 +120,10 @@ type RemoteConn interface {
 	// but was unable to set the variable.
 	SetUploadPack(string) error
 
+	// Like SetUploadPack, but sets the name of git-receive-pack for
+	// connections created with NewReceivePackConn.
+	SetReceivePack(string) error
+
 	// Gets the protocol version that was negotiated during connection
 	// opening. Only valid after calling OpenConn.
 	ProtocolVersion() uint8
@@ -153,38 +157,66 @@ type RemoteConn interface {
 
 	// Sends a Delimiter packet in protocol V2
 	Delim() error
+
+	// Returns a writer which writes directly to the underlying
+	// connection without pkt-line encoding the data, for sending
+	// packfiles.
+	RawWriter() io.Writer
 }
 
+// The services which a RemoteConn may connect to.
+const (
+	uploadPackService  = "git-upload-pack"
+	receivePackService = "git-receive-pack"
+)
+
+// Returns a RemoteConn which fetches from r using git-upload-pack.
 func NewRemoteConn(c *Client, r Remote) (RemoteConn, error) {
 	urls, err := r.RemoteURL(c)
 	if err != nil {
 		return nil, err
 	}
+	return newRemoteConn(r, urls, uploadPackService)
+}
+
+// Returns a RemoteConn which pushes to r using git-receive-pack.
+func NewReceivePackConn(c *Client, r Remote) (RemoteConn, error) {
+	urls, err := r.PushURL(c)
+	if err != nil {
+		return nil, err
+	}
+	return newRemoteConn(r, urls, receivePackService)
+}
+
+func newRemoteConn(r Remote, urls, service string) (RemoteConn, error) {
 	uri, err := url.Parse(urls)
 	if err != nil {
 		return nil, err
 	}
+	shared := &sharedRemoteConn{uri: uri, service: service}
 	switch uri.Scheme {
 	case "http", "https":
 		conn := &smartHTTPConn{
-			sharedRemoteConn: &sharedRemoteConn{uri: uri},
+			sharedRemoteConn: shared,
 			giturl:           urls,
 		}
 		return conn, nil
 	case "git":
 		conn := &gitConn{
-			sharedRemoteConn: &sharedRemoteConn{uri: uri},
+			sharedRemoteConn: shared,
 		}
 		return conn, nil
 	case "ssh":
 		return &sshConn{
-			sharedRemoteConn: &sharedRemoteConn{uri: uri},
+			sharedRemoteConn: shared,
 			uploadpack:       "git-upload-pack",
+			receivepack:      "git-receive-pack",
 		}, nil
 	case "file":
 		return &localConn{
-			sharedRemoteConn: &sharedRemoteConn{uri: uri},
+			sharedRemoteConn: shared,
 			uploadpack:       "git-upload-pack",
+			receivepack:      "git-receive-pack",
 		}, nil
 	default:
 		return nil, fmt.Errorf("Unsupported remote type for: %v", r)
@@ -198,6 +230,10 @@ type sharedRemoteConn struct {
 	protocolversion uint8
 	capabilities    map[string]map[string]struct{}
 
+	// The service on the remote which this connection talks to,
+	// either git-upload-pack or git-receive-pack.
+	service string
+
 	// References advertised during opening of connection. Only valid
 	// for protocol v1
 	refs []Ref
@@ -267,7 +303,13 @@ func RemoteList(c *Client, opts RemoteOptions) ([]Remote, error) {
 	}
 	configs := config.GetConfigSections("remote", "")
 	remotes := make([]Remote, 0, len(configs))
+	seen := make(map[string]bool)
 	for _, cfg := range configs {
+		// The same remote may have more than one section.
+		if seen[cfg.subsection] {
+			continue
+		}
+		seen[cfg.subsection] = true
 		remotes = append(remotes, Remote(cfg.subsection))
 	}
 	return remotes, nil
@@ -330,11 +372,11 @@ func RemoteShow(c *Client, opts RemoteShowOptions, r Remote, w io.Writer) error
 	}
 	var longest int
 	for _, branch := range branchconfigs {
-		if branch.values["remote"] == r.Name() {
+		if remote, _ := branch.Get("remote"); remote == r.Name() {
 			bname := struct {
 				local, remote string
 			}{local: branch.subsection}
-			if remote, ok := branch.values["merge"]; ok {
+			if remote, ok := branch.Get("merge"); ok {
 				bname.remote = branch.subsection
 			} else {
 				bname.remote = strings.TrimPrefix(remote, "refs/heads/")
@@ -0,0 +1,203 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// RepackOptions are the options that may be passed to Repack.
+type RepackOptions struct {
+	// Pack every reachable object into a single pack, instead of only
+	// packing the reachable loose objects.
+	All bool
+
+	// Like All, but if Delete is also set unreachable objects from the
+	// old packs are made loose instead of being removed, so that Prune
+	// can decide what to do with them.
+	LoosenUnreachable bool
+
+	// Remove packs and loose objects which are made redundant by the
+	// new pack.
+	Delete bool
+
+	// Don't print progress or "Nothing new to pack."
+	Quiet bool
+
+	// Passed to PackObjects.
+	NoReuseDelta  bool
+	Window, Depth int
+}
+
+// Repack implements "git repack". It packs the reachable loose objects
+// into a new pack or, with All, consolidates every reachable object into
+// a single pack. Objects in packs with a .keep file are never repacked and
+// those packs are never deleted.
+func Repack(c *Client, opts RepackOptions) error {
+	if opts.LoosenUnreachable {
+		opts.All = true
+	}
+
+	packs, err := c.packfiles()
+	if err != nil {
+		return err
+	}
+	kept := make(map[File]bool)
+	for _, pack := range packs {
+		if File(pack.String() + ".keep").Exists() {
+			kept[pack] = true
+		}
+	}
+	packed, err := packedObjects(c)
+	if err != nil {
+		return err
+	}
+
+	reachable, err := reachableObjects(c, nil)
+	if err != nil {
+		return err
+	}
+	var objects []Sha1
+	for _, sha := range reachable {
+		if pack, ok := packed[sha]; ok && (kept[pack] || !opts.All) {
+			continue
+		}
+		objects = append(objects, sha)
+	}
+
+	var newpack File
+	if len(objects) == 0 {
+		if !opts.Quiet {
+			fmt.Println("Nothing new to pack.")
+		}
+	} else {
+		newpack, err = writeRepackedPack(c, opts, objects)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !opts.Delete {
+		return nil
+	}
+	if opts.All {
+		inNew := make(map[Sha1]struct{}, len(objects))
+		for _, sha := range objects {
+			inNew[sha] = struct{}{}
+		}
+		for _, pack := range packs {
+			if kept[pack] || pack == newpack {
+				continue
+			}
+			if opts.LoosenUnreachable {
+				if err := loosenPackObjects(c, pack, packed, inNew); err != nil {
+					return err
+				}
+			}
+			for _, ext := range []string{".pack", ".idx"} {
+				if err := os.Remove(pack.String() + ext); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+		// The cached locations may refer to packs that we just
+		// removed.
+		c.objectCache = make(map[Sha1]objectLocation)
+		c.packs.reset()
+	}
+	return PrunePacked(c, PrunePackedOptions{})
+}
+
+// Writes objects to a new pack in the objects/pack directory and returns
+// its name without an extension.
+func writeRepackedPack(c *Client, opts RepackOptions, objects []Sha1) (File, error) {
+	packdir := c.GitDir.File("objects/pack").String()
+	if err := os.MkdirAll(packdir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(packdir, "tmp_pack_")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	idx, err := PackObjects(c, PackObjectsOptions{
+		Window:          opts.Window,
+		Depth:           opts.Depth,
+		DeltaBaseOffset: true,
+		NoReuseDelta:    opts.NoReuseDelta,
+		Progress:        !opts.Quiet,
+	}, tmp, objects)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	packsha, _ := idx.GetTrailer()
+	name := File(filepath.Join(packdir, "pack-"+packsha.String()))
+
+	// The pack needs to be in place before the index, since the index
+	// is what makes the pack visible.
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), name.String()+".pack"); err != nil {
+		return "", err
+	}
+	tmpidx, err := ioutil.TempFile(packdir, "tmp_idx_")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpidx.Name())
+	err = idx.WriteIndex(tmpidx)
+	if cerr := tmpidx.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmpidx.Name(), 0444); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpidx.Name(), name.String()+".idx"); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Writes the objects in pack which are not in the new pack as loose
+// objects, with the modification time of the pack so that they
+// expire from when they were packed rather than from now.
+func loosenPackObjects(c *Client, pack File, packed map[Sha1]File, inNew map[Sha1]struct{}) error {
+	fi, err := os.Stat(pack.String() + ".pack")
+	if err != nil {
+		return err
+	}
+	for sha, p := range packed {
+		if p != pack {
+			continue
+		}
+		if _, ok := inNew[sha]; ok {
+			continue
+		}
+		path := c.GitDir.File(File(fmt.Sprintf("objects/%02x/%018x", sha[0], sha[1:])))
+		if path.Exists() {
+			continue
+		}
+		obj, err := c.GetObject(sha)
+		if err != nil {
+			return err
+		}
+		content := obj.GetContent()
+		raw := append([]byte(fmt.Sprintf("%s %d\000", obj.GetType(), len(content))), content...)
+		if err := c.writeLooseObject(sha, raw); err != nil {
+			return err
+		}
+		if err := os.Chtimes(path.String(), fi.ModTime(), fi.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
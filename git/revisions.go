@@ -0,0 +1,683 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Returns the position of the first revision modifier (@{, ~ or ^) in arg,
+// or -1 if there isn't one.
+func revisionModifierPos(arg string) int {
+	pos := strings.Index(arg, "@{")
+	if i := strings.IndexAny(arg, "~^"); i >= 0 && (pos < 0 || i < pos) {
+		pos = i
+	}
+	return pos
+}
+
+// Returns true if arg is more than a plain name or object ID and needs to
+// be parsed by revParseObject.
+func isRevisionExpression(arg string) bool {
+	return arg == "@" || strings.HasPrefix(arg, ":/") || revisionModifierPos(arg) >= 0
+}
+
+// Resolves a revision as described in gitrevisions(7), such as
+// "HEAD~3^2", "master@{1}", "@{upstream}", "v1.0^{tree}" or ":/fix typo",
+// into the object that it names. The object may be of any type. Ranges
+// and <rev>:<path> are handled by the callers.
+func revParseObject(c *Client, opt *RevParseOptions, arg string) (Sha1, error) {
+	if strings.HasPrefix(arg, ":/") {
+		return searchCommitMessages(c, nil, arg[2:])
+	}
+
+	base, rest := arg, ""
+	if pos := revisionModifierPos(arg); pos >= 0 {
+		base, rest = arg[:pos], arg[pos:]
+	}
+	var obj Sha1
+	var err error
+	if strings.HasPrefix(rest, "@{") {
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			return Sha1{}, fmt.Errorf("Invalid revision: %v", arg)
+		}
+		obj, err = resolveAtSelector(c, opt, base, rest[2:end])
+		rest = rest[end+1:]
+	} else {
+		obj, err = resolveRevisionName(c, opt, base)
+	}
+	if err != nil {
+		return Sha1{}, err
+	}
+
+	for rest != "" {
+		if strings.HasPrefix(rest, "^{") {
+			// A ^{...} peel must be the last modifier, since the
+			// contents of ^{/regex} may contain anything.
+			if !strings.HasSuffix(rest, "}") {
+				return Sha1{}, fmt.Errorf("Invalid revision: %v", arg)
+			}
+			return peelRevision(c, obj, rest[2:len(rest)-1])
+		}
+		op := rest[0]
+		if op != '^' && op != '~' {
+			return Sha1{}, fmt.Errorf("Invalid revision: %v", arg)
+		}
+		digits := 1
+		for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+		n := 1
+		if digits > 1 {
+			if n, err = strconv.Atoi(rest[1:digits]); err != nil {
+				return Sha1{}, fmt.Errorf("Invalid revision: %v", arg)
+			}
+		}
+		rest = rest[digits:]
+
+		cmt, err := peelToCommit(c, obj)
+		if err != nil {
+			return Sha1{}, err
+		}
+		if op == '~' {
+			for i := 0; i < n; i++ {
+				parents, err := cmt.Parents(c)
+				if err != nil {
+					return Sha1{}, err
+				}
+				if len(parents) == 0 {
+					return Sha1{}, fmt.Errorf("Invalid revision: %v", arg)
+				}
+				cmt = parents[0]
+			}
+		} else if n > 0 {
+			parents, err := cmt.Parents(c)
+			if err != nil {
+				return Sha1{}, err
+			}
+			if n > len(parents) {
+				return Sha1{}, fmt.Errorf("Invalid revision: %v", arg)
+			}
+			cmt = parents[n-1]
+		}
+		obj = Sha1(cmt)
+	}
+	return obj, nil
+}
+
+// Resolves a name without any modifiers into the object that it refers
+// to. Annotated tags are not peeled.
+func resolveRevisionName(c *Client, opt *RevParseOptions, name string) (Sha1, error) {
+	switch name {
+	case "":
+		return Sha1{}, fmt.Errorf("Invalid revision")
+	case "@", "HEAD":
+		cmt, err := c.GetHeadCommit()
+		return Sha1(cmt), err
+	}
+	cmt, err := RevParseCommitish(c, opt, name)
+	if err != nil {
+		return Sha1{}, err
+	}
+	switch v := cmt.(type) {
+	case RefSpec:
+		return v.Sha1(c)
+	case CommitID:
+		return Sha1(v), nil
+	default:
+		cid, err := cmt.CommitID(c)
+		return Sha1(cid), err
+	}
+}
+
+// Follows tags starting at obj until it reaches a commit.
+func peelToCommit(c *Client, obj Sha1) (CommitID, error) {
+	peeled, err := peelRevision(c, obj, "commit")
+	return CommitID(peeled), err
+}
+
+// Implements the <rev>^{<type>} syntax. typ may be a type of object,
+// "object", an empty string to peel all tags, or "/regex" to search
+// commit messages.
+func peelRevision(c *Client, obj Sha1, typ string) (Sha1, error) {
+	if strings.HasPrefix(typ, "/") {
+		cmt, err := peelToCommit(c, obj)
+		if err != nil {
+			return Sha1{}, err
+		}
+		return searchCommitMessages(c, []Commitish{cmt}, typ[1:])
+	}
+	switch typ {
+	case "", "object", "commit", "tree", "blob", "tag":
+	default:
+		return Sha1{}, fmt.Errorf("Invalid object type: %v", typ)
+	}
+	if have, _, err := c.HaveObject(obj); err != nil || !have {
+		return Sha1{}, fmt.Errorf("Object not found: %v", obj)
+	}
+	if typ == "object" {
+		return obj, nil
+	}
+	for {
+		t := obj.Type(c)
+		switch {
+		case t == typ:
+			return obj, nil
+		case t == "tag":
+			target, err := tagTarget(c, obj)
+			if err != nil {
+				return Sha1{}, err
+			}
+			obj = target
+			continue
+		case typ == "":
+			return obj, nil
+		case t == "commit" && typ == "tree":
+			tree, err := CommitID(obj).TreeID(c)
+			return Sha1(tree), err
+		}
+		return Sha1{}, fmt.Errorf("%v: expected %v type, but the object dereferences to %v type", obj, typ, t)
+	}
+}
+
+// Returns the youngest commit reachable from tips (or any ref, if tips is
+// nil) whose message matches pattern, as used by :/<text> and
+// <rev>^{/<text>}. A pattern starting with "!-" matches commits which
+// don't match the rest of the pattern, and "!!" is a literal "!".
+func searchCommitMessages(c *Client, tips []Commitish, pattern string) (Sha1, error) {
+	negate := false
+	switch {
+	case strings.HasPrefix(pattern, "!-"):
+		negate = true
+		pattern = pattern[2:]
+	case strings.HasPrefix(pattern, "!!"):
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, "!"):
+		return Sha1{}, fmt.Errorf("Invalid search pattern: %v", pattern)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Sha1{}, err
+	}
+
+	if tips == nil {
+		refs, err := ShowRef(c, ShowRefOptions{IncludeHead: true}, nil)
+		if err != nil {
+			return Sha1{}, err
+		}
+		for _, ref := range refs {
+			if cmt, err := peelToCommit(c, ref.Value); err == nil {
+				tips = append(tips, cmt)
+			}
+		}
+	}
+	commits, err := RevList(c, RevListOptions{Quiet: true}, nil, tips, nil)
+	if err != nil {
+		return Sha1{}, err
+	}
+	type datedCommit struct {
+		cmt  CommitID
+		date int64
+	}
+	dated := make([]datedCommit, 0, len(commits))
+	for _, s := range commits {
+		d, err := CommitID(s).GetCommitterDate(c)
+		if err != nil {
+			return Sha1{}, err
+		}
+		dated = append(dated, datedCommit{CommitID(s), d.Unix()})
+	}
+	sort.SliceStable(dated, func(i, j int) bool { return dated[i].date > dated[j].date })
+	for _, d := range dated {
+		msg, err := d.cmt.GetCommitMessage(c)
+		if err != nil {
+			return Sha1{}, err
+		}
+		if re.MatchString(string(msg)) != negate {
+			return Sha1(d.cmt), nil
+		}
+	}
+	return Sha1{}, fmt.Errorf("No commit message matches: %v", pattern)
+}
+
+// Resolves <base>@{<sel>}, where sel is a reflog entry number, a date,
+// -N for the Nth previously checked out branch, upstream or push.
+func resolveAtSelector(c *Client, opt *RevParseOptions, base, sel string) (Sha1, error) {
+	switch strings.ToLower(sel) {
+	case "u", "upstream", "push":
+		ref, err := upstreamRef(c, base, strings.ToLower(sel) == "push")
+		if err != nil {
+			return Sha1{}, err
+		}
+		return RefSpec(ref).Sha1(c)
+	}
+	if strings.HasPrefix(sel, "-") {
+		if base != "" {
+			return Sha1{}, fmt.Errorf("Invalid revision: %v@{%v}", base, sel)
+		}
+		n, err := strconv.Atoi(sel[1:])
+		if err != nil || n < 1 {
+			return Sha1{}, fmt.Errorf("Invalid revision: @{%v}", sel)
+		}
+		name, err := previousCheckout(c, n)
+		if err != nil {
+			return Sha1{}, err
+		}
+		return resolveRevisionName(c, opt, name)
+	}
+
+	refname := "HEAD"
+	switch base {
+	case "":
+		// @{n} is the reflog of the current branch, not of HEAD.
+		if b := c.GetHeadBranch(); b != "" {
+			refname = b.String()
+		}
+	case "HEAD", "@":
+	default:
+		refname = string(dwimRef(c, base))
+		if refname == "" {
+			return Sha1{}, fmt.Errorf("Invalid revision: %v", base)
+		}
+	}
+	entries, err := readReflog(c, refname)
+	if err != nil {
+		return Sha1{}, err
+	}
+	if len(entries) == 0 {
+		return Sha1{}, fmt.Errorf("log for '%v' is empty", prettyRefName(refname))
+	}
+
+	if n, err := strconv.Atoi(sel); err == nil && n >= 0 {
+		switch {
+		case n < len(entries):
+			return entries[len(entries)-1-n].New, nil
+		case n == len(entries) && entries[0].Old != (Sha1{}):
+			return entries[0].Old, nil
+		}
+		return Sha1{}, fmt.Errorf("log for '%v' only has %d entries", prettyRefName(refname), len(entries))
+	}
+
+	date, err := parseExpiry(sel)
+	if err != nil {
+		return Sha1{}, fmt.Errorf("Invalid reflog selector: @{%v}", sel)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Time.After(date) {
+			return entries[i].New, nil
+		}
+	}
+	// The date is before the start of the log, so use the oldest value
+	// that we know about.
+	if entries[0].Old != (Sha1{}) {
+		return entries[0].Old, nil
+	}
+	return entries[0].New, nil
+}
+
+// Returns the name of the branch (or commit) that was checked out before
+// the nth most recent checkout, according to the HEAD reflog.
+func previousCheckout(c *Client, n int) (string, error) {
+	entries, err := readReflog(c, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	found := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg := entries[i].Message
+		if !strings.HasPrefix(msg, "checkout: moving from ") {
+			continue
+		}
+		found++
+		if found != n {
+			continue
+		}
+		msg = strings.TrimPrefix(msg, "checkout: moving from ")
+		if to := strings.Index(msg, " to "); to >= 0 {
+			return msg[:to], nil
+		}
+		return "", fmt.Errorf("Invalid checkout reflog entry: %v", entries[i].Message)
+	}
+	return "", fmt.Errorf("@{-%d}: only %d checkouts in the reflog", n, found)
+}
+
+// Returns the remote tracking ref for the upstream of branch (or the
+// current branch if branch is empty), or the ref that "git push" would
+// update if push is true.
+func upstreamRef(c *Client, branch string, push bool) (Refname, error) {
+	if branch == "" || branch == "HEAD" || branch == "@" {
+		b := c.GetHeadBranch()
+		if b == "" {
+			return "", fmt.Errorf("HEAD does not point to a branch")
+		}
+		branch = b.BranchName()
+	}
+	branch = strings.TrimPrefix(branch, "refs/heads/")
+	if !Branch("refs/heads/" + branch).Exists(c) {
+		return "", fmt.Errorf("no such branch: '%v'", branch)
+	}
+	remote := c.GetConfig("branch." + branch + ".remote")
+	merge := c.GetConfig("branch." + branch + ".merge")
+
+	if push {
+		pushRemote := c.GetConfig("branch." + branch + ".pushremote")
+		if pushRemote == "" {
+			pushRemote = c.GetConfig("remote.pushdefault")
+		}
+		if pushRemote == "" {
+			pushRemote = remote
+		}
+		if pushRemote == "" {
+			pushRemote = "origin"
+		}
+		switch mode := c.GetConfig("push.default"); mode {
+		case "nothing":
+			return "", fmt.Errorf("push has no destination (push.default is 'nothing')")
+		case "current", "matching":
+			merge = "refs/heads/" + branch
+		default:
+			if pushRemote != remote {
+				// Pushing to a different remote than we fetch
+				// from pushes to the same name.
+				merge = "refs/heads/" + branch
+			} else if merge == "" {
+				return "", fmt.Errorf("no upstream configured for branch '%v'", branch)
+			} else if mode != "upstream" && merge != "refs/heads/"+branch {
+				return "", fmt.Errorf("cannot resolve 'simple' push to a single destination")
+			}
+		}
+		remote = pushRemote
+	}
+
+	if remote == "" || merge == "" {
+		return "", fmt.Errorf("no upstream configured for branch '%v'", branch)
+	}
+	if remote == "." {
+		return Refname(merge), nil
+	}
+	tracking := pushTrackingRef(c, Remote(remote), Refname(merge))
+	if tracking == "" {
+		return "", fmt.Errorf("upstream branch '%v' not stored as a remote-tracking branch", merge)
+	}
+	return tracking, nil
+}
+
+// A RevisionSet is the set of commits selected by the revision arguments
+// of a command which walks history, such as rev-list or log.
+type RevisionSet struct {
+	Includes, Excludes []Commitish
+
+	// The paths which were given after the revisions.
+	Paths []File
+
+	// The tips of each side of any symmetric differences (A...B).
+	Left, Right []Commitish
+}
+
+// ParseRevisions parses the revision arguments of commands which walk
+// history. It supports ranges (A..B), symmetric differences (A...B),
+// exclusions (^A), the ^@, ^! and ^-<n> suffixes, and the --not, --all,
+// --branches, --tags, --remotes, --glob and --exclude pseudo-options.
+// Arguments after "--", or after the first argument which isn't a
+// revision but is a file, are returned as paths.
+func ParseRevisions(c *Client, args []string) (RevisionSet, error) {
+	var rs RevisionSet
+	not := false
+	var excludePatterns []string
+	add := func(cmt Commitish, exclude bool) {
+		if exclude != not {
+			rs.Excludes = append(rs.Excludes, cmt)
+		} else {
+			rs.Includes = append(rs.Includes, cmt)
+		}
+	}
+	addRefs := func(prefix, pattern, strip string) error {
+		tips, err := refTips(c, prefix, pattern, excludePatterns, strip)
+		if err != nil {
+			return err
+		}
+		for _, tip := range tips {
+			add(tip, false)
+		}
+		// --exclude only applies to the next ref selector.
+		excludePatterns = nil
+		return nil
+	}
+
+	for i, arg := range args {
+		var err error
+		switch {
+		case arg == "--":
+			for _, p := range args[i+1:] {
+				rs.Paths = append(rs.Paths, File(p))
+			}
+			return rs, nil
+		case arg == "--not":
+			not = !not
+		case arg == "--all":
+			if err = addRefs("refs/", "", ""); err == nil {
+				if head, herr := c.GetHeadCommit(); herr == nil {
+					add(head, false)
+				}
+			}
+		case arg == "--branches" || strings.HasPrefix(arg, "--branches="):
+			err = addRefs("refs/heads/", strings.TrimPrefix(strings.TrimPrefix(arg, "--branches"), "="), "refs/heads/")
+		case arg == "--tags" || strings.HasPrefix(arg, "--tags="):
+			err = addRefs("refs/tags/", strings.TrimPrefix(strings.TrimPrefix(arg, "--tags"), "="), "refs/tags/")
+		case arg == "--remotes" || strings.HasPrefix(arg, "--remotes="):
+			err = addRefs("refs/remotes/", strings.TrimPrefix(strings.TrimPrefix(arg, "--remotes"), "="), "refs/remotes/")
+		case strings.HasPrefix(arg, "--glob="):
+			pattern := strings.TrimPrefix(arg, "--glob=")
+			if !strings.HasPrefix(pattern, "refs/") {
+				pattern = "refs/" + pattern
+			}
+			err = addRefs("", pattern, "")
+		case strings.HasPrefix(arg, "--exclude="):
+			excludePatterns = append(excludePatterns, strings.TrimPrefix(arg, "--exclude="))
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return rs, fmt.Errorf("Unsupported option in revisions: %v", arg)
+		default:
+			err = rs.parseRevision(c, arg, add)
+			if err != nil && File(arg).Exists() {
+				// It's not a revision, so it and everything
+				// after it are paths.
+				for _, p := range args[i:] {
+					rs.Paths = append(rs.Paths, File(p))
+				}
+				return rs, nil
+			} else if err != nil {
+				return rs, fmt.Errorf("fatal: ambiguous argument '%v': unknown revision or path not in the working tree.", arg)
+			}
+		}
+		if err != nil {
+			return rs, err
+		}
+	}
+	return rs, nil
+}
+
+// Parses a single revision argument, calling add for each commit that it
+// includes or excludes.
+func (rs *RevisionSet) parseRevision(c *Client, arg string, add func(Commitish, bool)) error {
+	opt := &RevParseOptions{}
+	if len(arg) > 1 && arg[0] == '^' {
+		cmt, err := RevParseCommitish(c, opt, arg[1:])
+		if err != nil {
+			return err
+		}
+		add(cmt, true)
+		return nil
+	}
+
+	if pos := strings.Index(arg, "..."); pos >= 0 {
+		left, right, err := revisionRangeEnds(c, opt, arg[:pos], arg[pos+3:])
+		if err != nil {
+			return err
+		}
+		add(left, false)
+		add(right, false)
+		base, err := MergeBase(c, MergeBaseOptions{}, []Commitish{left, right})
+		if err != nil {
+			return err
+		}
+		if base != (CommitID{}) {
+			add(base, true)
+		}
+		rs.Left = append(rs.Left, left)
+		rs.Right = append(rs.Right, right)
+		return nil
+	}
+	if pos := strings.Index(arg, ".."); pos >= 0 {
+		from, to, err := revisionRangeEnds(c, opt, arg[:pos], arg[pos+2:])
+		if err != nil {
+			return err
+		}
+		add(from, true)
+		add(to, false)
+		return nil
+	}
+
+	// <rev>^@ is all of the parents of rev, <rev>^! is rev without
+	// its parents and <rev>^-<n> is <rev>^<n>..<rev>
+	if strings.HasSuffix(arg, "^@") || strings.HasSuffix(arg, "^!") {
+		cmt, err := RevParseCommit(c, opt, arg[:len(arg)-2])
+		if err != nil {
+			return err
+		}
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return err
+		}
+		exclude := strings.HasSuffix(arg, "^!")
+		if exclude {
+			add(cmt, false)
+		}
+		for _, p := range parents {
+			add(p, exclude)
+		}
+		return nil
+	}
+	if pos := strings.LastIndex(arg, "^-"); pos > 0 {
+		n := arg[pos+2:]
+		if n == "" {
+			n = "1"
+		}
+		if _, err := strconv.Atoi(n); err == nil {
+			base := arg[:pos]
+			cmt, err := RevParseCommitish(c, opt, base)
+			if err != nil {
+				return err
+			}
+			parent, err := RevParseCommitish(c, opt, base+"^"+n)
+			if err != nil {
+				return err
+			}
+			add(parent, true)
+			add(cmt, false)
+			return nil
+		}
+	}
+
+	cmt, err := RevParseCommitish(c, opt, arg)
+	if err != nil {
+		return err
+	}
+	add(cmt, false)
+	return nil
+}
+
+// Resolves the two ends of a range. An empty end means HEAD.
+func revisionRangeEnds(c *Client, opt *RevParseOptions, from, to string) (Commitish, Commitish, error) {
+	if from == "" {
+		from = "HEAD"
+	}
+	if to == "" {
+		to = "HEAD"
+	}
+	f, err := RevParseCommitish(c, opt, from)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := RevParseCommitish(c, opt, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, t, nil
+}
+
+// Returns the commits pointed to by the refs starting with prefix which
+// match pattern and none of excludes. Patterns without any wildcards
+// match everything under them, and excludes are matched against the
+// refname with strip removed. Refs which don't point to a commit are
+// skipped.
+func refTips(c *Client, prefix, pattern string, excludes []string, strip string) ([]Commitish, error) {
+	if pattern != "" && !strings.HasPrefix(pattern, prefix) {
+		pattern = prefix + pattern
+	}
+	if pattern != "" && !strings.ContainsAny(pattern, "*?[") {
+		pattern = strings.TrimSuffix(pattern, "/") + "/*"
+	}
+	names, err := c.refNames(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var tips []Commitish
+	for _, name := range names {
+		if pattern != "" && !refGlobMatch(pattern, name) {
+			continue
+		}
+		excluded := false
+		for _, e := range excludes {
+			if refGlobMatch(e, strings.TrimPrefix(name, strip)) {
+				excluded = true
+				break
+			}
+		}
+		if excluded {
+			continue
+		}
+		ref, err := parseRef(c, name)
+		if err != nil {
+			continue
+		}
+		if cmt, err := peelToCommit(c, ref.Value); err == nil {
+			tips = append(tips, cmt)
+		}
+	}
+	return tips, nil
+}
+
+// Matches a ref name against a glob pattern, where "*" can also match
+// slashes.
+func refGlobMatch(pattern, name string) bool {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end
+		default:
+			re.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	re.WriteString("$")
+	matched, err := regexp.MatchString(re.String(), name)
+	return err == nil && matched
+}
@@ -0,0 +1,216 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRevisionSyntax(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrevisions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	var commits []CommitID
+	for _, msg := range []string{"first", "fix typo", "third"} {
+		if err := ioutil.WriteFile("foo.txt", []byte(msg+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		cmt, err := Commit(c, CommitOptions{}, CommitMessage(msg), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, cmt)
+	}
+	A, B, C := commits[0], commits[1], commits[2]
+
+	// Make a merge of C and A, so that there's a second parent.
+	tree, err := C.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	M, err := CommitTree(c, CommitTreeOptions{}, tree, []CommitID{C, A}, "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/heads/master", M, "merge"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check out another branch and come back, so that there's an
+	// @{-1}, and make it the upstream of master.
+	if err := c.CreateBranch("side", B); err != nil {
+		t.Fatal(err)
+	}
+	if err := Checkout(c, CheckoutOptions{}, "side", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Checkout(c, CheckoutOptions{}, "master", nil); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("branch.master.remote", ".")
+	c.SetCachedConfig("branch.master.merge", "refs/heads/side")
+
+	tests := []struct {
+		rev  string
+		want Sha1
+	}{
+		{"HEAD~1", Sha1(C)},
+		{"HEAD~3", Sha1(A)},
+		{"master^2", Sha1(A)},
+		{"HEAD^1^", Sha1(B)},
+		{"HEAD^0", Sha1(M)},
+		{"@", Sha1(M)},
+		{"master@{0}", Sha1(M)},
+		{"master@{1}", Sha1(C)},
+		{"@{2}", Sha1(B)},
+		{"@{-1}", Sha1(B)},
+		{"@{u}", Sha1(B)},
+		{"master@{upstream}~1", Sha1(A)},
+		{"HEAD^{tree}", Sha1(tree)},
+		{"HEAD^{}", Sha1(M)},
+		{":/fix typo", Sha1(B)},
+		{"HEAD^2^{/fir}", Sha1(A)},
+	}
+	for _, tc := range tests {
+		got, err := revParseObject(c, &RevParseOptions{}, tc.rev)
+		if err != nil {
+			t.Errorf("%v: %v", tc.rev, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%v: got %v want %v", tc.rev, got, tc.want)
+		}
+	}
+
+	for _, rev := range []string{"HEAD~4", "HEAD^3", "master@{10}", "side@{u}", "HEAD^{blob}", ":/no such commit"} {
+		if got, err := revParseObject(c, &RevParseOptions{}, rev); err == nil {
+			t.Errorf("%v: expected an error, got %v", rev, got)
+		}
+	}
+
+	// @{-1} on its own should be the branch, so that checking it out
+	// doesn't detach HEAD.
+	cmt, err := RevParseCommitish(c, &RevParseOptions{}, "@{-1}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := cmt.(Branch); !ok || b.BranchName() != "side" {
+		t.Errorf("Unexpected @{-1}: got %v want branch side", cmt)
+	}
+}
+
+func TestParseRevisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrevisionranges")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	A := commitFile(t, c, "foo.txt", "foo\n", "A")
+	B := commitFile(t, c, "foo.txt", "bar\n", "B")
+	if err := c.CreateBranch("topic", A); err != nil {
+		t.Fatal(err)
+	}
+	if err := Checkout(c, CheckoutOptions{}, "topic", nil); err != nil {
+		t.Fatal(err)
+	}
+	C := commitFile(t, c, "bar.txt", "bar\n", "C")
+	if err := Checkout(c, CheckoutOptions{}, "master", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(cmts []Commitish) []CommitID {
+		var ids []CommitID
+		for _, cmt := range cmts {
+			id, err := cmt.CommitID(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	same := func(got, want []CommitID) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	}
+	tests := []struct {
+		args               []string
+		includes, excludes []CommitID
+	}{
+		{[]string{"master..topic"}, []CommitID{C}, []CommitID{B}},
+		{[]string{"..topic"}, []CommitID{C}, []CommitID{B}},
+		{[]string{"master...topic"}, []CommitID{B, C}, []CommitID{A}},
+		{[]string{"topic", "^master"}, []CommitID{C}, []CommitID{B}},
+		{[]string{"topic", "--not", "master", "--not", "HEAD"}, []CommitID{C, B}, []CommitID{B}},
+		{[]string{"master^!"}, []CommitID{B}, []CommitID{A}},
+		{[]string{"master^@"}, []CommitID{A}, nil},
+		{[]string{"master^-"}, []CommitID{B}, []CommitID{A}},
+		{[]string{"--branches=top*"}, []CommitID{C}, nil},
+		{[]string{"--branches=top"}, nil, nil},
+		{[]string{"--exclude=t*", "--branches"}, []CommitID{B}, nil},
+		{[]string{"--glob=heads/m*"}, []CommitID{B}, nil},
+	}
+	for _, tc := range tests {
+		rs, err := ParseRevisions(c, tc.args)
+		if err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		if got := ids(rs.Includes); !same(got, tc.includes) {
+			t.Errorf("%v: got includes %v want %v", tc.args, got, tc.includes)
+		}
+		if got := ids(rs.Excludes); !same(got, tc.excludes) {
+			t.Errorf("%v: got excludes %v want %v", tc.args, got, tc.excludes)
+		}
+	}
+
+	rs, err := ParseRevisions(c, []string{"master...topic", "--", "foo.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.Paths) != 1 || rs.Paths[0] != "foo.txt" {
+		t.Errorf("Unexpected paths: %v", rs.Paths)
+	}
+	if left, right := ids(rs.Left), ids(rs.Right); !same(left, []CommitID{B}) || !same(right, []CommitID{C}) {
+		t.Errorf("Unexpected sides: got %v...%v want %v...%v", left, right, B, C)
+	}
+	marks, err := RevListMarker(c, RevListOptions{LeftRight: true, Left: rs.Left, Right: rs.Right})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marks(Sha1(B)) != "<" || marks(Sha1(C)) != ">" || marks(Sha1(A)) != "" {
+		t.Errorf("Unexpected marks: %v %v %v", marks(Sha1(A)), marks(Sha1(B)), marks(Sha1(C)))
+	}
+
+	if _, err := ParseRevisions(c, []string{"nosuchrevision"}); err == nil {
+		t.Error("Expected an error for an unknown revision")
+	}
+}
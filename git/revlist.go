@@ -1,9 +1,15 @@
 package git
 
 import (
+	"bytes"
+	"container/heap"
+	"crypto/sha1"
 	"fmt"
 	"io"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 )
 
 // List of command line options that may be passed to RevList
@@ -12,16 +18,93 @@ type RevListOptions struct {
 	MaxCount       *uint
 	VerifyObjects  bool
 	All            bool
+
+	// Mark commits with < or > depending on which side of a symmetric
+	// difference (A...B) they're reachable from.
+	LeftRight bool
+
+	// Only list the commits from one side of a symmetric difference.
+	LeftOnly, RightOnly bool
+
+	// Mark commits which have an equivalent change on the other side
+	// of a symmetric difference with =, and other commits with +.
+	CherryMark bool
+
+	// Omit commits which have an equivalent change on the other side
+	// of a symmetric difference.
+	CherryPick bool
+
+	// The tips of each side of the symmetric differences being listed,
+	// as returned by ParseRevisions.
+	Left, Right []Commitish
+
+	// By default, commits are listed newest first by committer date.
+	// DateOrder and TopoOrder both make sure that no parent is listed
+	// before all of its children. TopoOrder also avoids interleaving
+	// commits from different lines of history.
+	TopoOrder, DateOrder bool
+
+	// List the commits in the opposite order, after limiting them.
+	Reverse bool
+
+	// Only follow the first parent of merge commits.
+	FirstParent bool
+
+	// Only list commits committed after Since or before Until. The
+	// walk doesn't go past commits older than Since. They may be any
+	// date accepted by git, such as "2.weeks.ago" or "2006-01-02".
+	Since, Until string
+
+	// Only list commits whose author, committer and message match any
+	// of these regular expressions. If AllMatch is set, the message
+	// must match every Grep pattern, and if InvertGrep is set, it must
+	// not match.
+	Author, Committer, Grep []string
+	AllMatch, InvertGrep    bool
+	RegexpIgnoreCase        bool
+
+	// Only list commits with at least MinParents parents, and at most
+	// MaxParents parents if it's set and not negative.
+	MinParents int
+	MaxParents *int
+
+	// The number of commits to skip before listing any.
+	Skip uint
+
+	// Only list commits which change one of these paths. By default,
+	// only the first parent of a merge with the same content at the
+	// paths as the merge is followed.
+	Paths []IndexPath
+
+	// Follow every parent of merges when limiting by Paths, and list
+	// merges whose content at the paths differs from any parent.
+	FullHistory bool
+
+	// Like FullHistory, but hide merges which don't contribute
+	// anything to the paths once their parents are simplified.
+	SimplifyMerges bool
+
+	// Continue listing the history of Paths, which must be a single
+	// file, before it was renamed.
+	Follow bool
 }
 
 var maxCountError = fmt.Errorf("Maximum number of objects has been reached")
 
 func RevList(c *Client, opt RevListOptions, w io.Writer, includes, excludes []Commitish) ([]Sha1, error) {
 	var vals []Sha1
+	var mark func(Sha1) string
+	if !opt.Quiet {
+		m, err := RevListMarker(c, opt)
+		if err != nil {
+			return nil, err
+		}
+		mark = m
+	}
 	err := RevListCallback(c, opt, includes, excludes, func(s Sha1) error {
 		vals = append(vals, s)
 		if !opt.Quiet {
-			fmt.Fprintf(w, "%v\n", s)
+			fmt.Fprintf(w, "%v%v\n", mark(s), s)
 		}
 		if opt.VerifyObjects {
 			switch t := s.Type(c); t {
@@ -57,22 +140,31 @@ func RevList(c *Client, opt RevListOptions, w io.Writer, includes, excludes []Co
 }
 
 func RevListCallback(c *Client, opt RevListOptions, includes, excludes []Commitish, callback func(Sha1) error) error {
-	excludeList := make(map[Sha1]struct{})
-	buildExcludeList := func(s Sha1) error {
-		if _, ok := excludeList[s]; ok {
-			return nil
+	if opt.All {
+		refs, err := refTips(c, "refs/", "", nil, "")
+		if err != nil {
+			return err
 		}
-		if opt.Objects {
-			cmt := CommitID(s)
-			_, err := cmt.GetAllObjectsExcept(c, excludeList)
-			if err != nil {
-				return err
-			}
+		includes = append(includes, refs...)
+		if head, err := c.GetHeadCommit(); err == nil {
+			includes = append(includes, head)
+		}
+	}
+	filter, err := revListFilter(c, opt)
+	if err != nil {
+		return err
+	}
+	var since time.Time
+	if opt.Since != "" {
+		if since, err = parseExpiry(opt.Since); err != nil {
+			return err
 		}
-		excludeList[s] = struct{}{}
-		return nil
 	}
 
+	// Everything reachable from an excluded commit is marked as seen
+	// before we start, so that the walk of the included commits stops
+	// when it gets to them.
+	excludeList := make(map[Sha1]struct{})
 	if len(excludes) > 0 {
 		var cIDs []CommitID = make([]CommitID, 0, len(excludes))
 		for _, e := range excludes {
@@ -82,7 +174,14 @@ func RevListCallback(c *Client, opt RevListOptions, includes, excludes []Commiti
 			}
 			cIDs = append(cIDs, cmt)
 		}
-		if err := revListCallback(c, opt, cIDs, excludeList, buildExcludeList); err != nil {
+		err := walkCommits(c, cIDs, excludeList, commitParents(c, false), time.Time{}, func(cmt CommitID) error {
+			if opt.Objects {
+				_, err := cmt.GetAllObjectsExcept(c, excludeList)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
 			return err
 		}
 	}
@@ -96,34 +195,37 @@ func RevListCallback(c *Client, opt RevListOptions, includes, excludes []Commiti
 		cIDs = append(cIDs, cmt)
 	}
 
-	callbackCount := uint(0)
-	callbackCountWrapper := func(s Sha1) error {
-		callbackCount++
-		if opt.MaxCount != nil && callbackCount > *opt.MaxCount {
-			return maxCountError
+	parents := commitParents(c, opt.FirstParent)
+	var limiter *pathLimiter
+	if len(opt.Paths) > 0 {
+		if limiter, err = newPathLimiter(c, opt); err != nil {
+			return err
 		}
-
-		return callback(s)
+		parents = limiter.parents
 	}
 
-	err := revListCallback(c, opt, cIDs, excludeList, callbackCountWrapper)
-	if err == maxCountError {
-		return nil
+	var skipped, count uint
+	selected := func(cmt CommitID) (bool, error) {
+		if limiter != nil && !limiter.shown(cmt) {
+			return false, nil
+		}
+		if ok, err := filter(cmt); err != nil || !ok {
+			return false, err
+		}
+		if skipped < opt.Skip {
+			skipped++
+			return false, nil
+		}
+		return true, nil
 	}
-	return err
-}
-
-func revListCallback(c *Client, opt RevListOptions, commits []CommitID, excludeList map[Sha1]struct{}, callback func(Sha1) error) error {
-	for _, cmt := range commits {
-		if _, ok := excludeList[Sha1(cmt)]; ok {
-			continue
+	emit := func(cmt CommitID) error {
+		if opt.MaxCount != nil && count >= *opt.MaxCount {
+			return maxCountError
 		}
-
+		count++
 		if err := callback(Sha1(cmt)); err != nil {
 			return err
 		}
-		excludeList[Sha1(cmt)] = struct{}{}
-
 		if opt.Objects {
 			objs, err := cmt.GetAllObjectsExcept(c, excludeList)
 			if err != nil {
@@ -136,13 +238,497 @@ func revListCallback(c *Client, opt RevListOptions, commits []CommitID, excludeL
 				}
 			}
 		}
-		parents, err := cmt.Parents(c)
+		return nil
+	}
+
+	if !opt.TopoOrder && !opt.DateOrder && !opt.Reverse && !opt.SimplifyMerges {
+		// The commits can be listed as they're found, so that
+		// we don't need to walk the whole history for log -n.
+		err := walkCommits(c, cIDs, excludeList, parents, since, func(cmt CommitID) error {
+			if ok, err := selected(cmt); err != nil || !ok {
+				return err
+			}
+			return emit(cmt)
+		})
+		if err == maxCountError {
+			return nil
+		}
+		return err
+	}
+
+	var commits []CommitID
+	err = walkCommits(c, cIDs, excludeList, parents, since, func(cmt CommitID) error {
+		commits = append(commits, cmt)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if opt.SimplifyMerges {
+		if err := limiter.simplifyMerges(commits); err != nil {
+			return err
+		}
+	}
+	// Like git, --simplify-merges implies --topo-order.
+	if opt.TopoOrder || opt.DateOrder || opt.SimplifyMerges {
+		if commits, err = sortCommitsTopologically(c, commits, parents, opt.DateOrder); err != nil {
+			return err
+		}
+	}
+	var shown []CommitID
+	for _, cmt := range commits {
+		if opt.MaxCount != nil && uint(len(shown)) >= *opt.MaxCount {
+			break
+		}
+		ok, err := selected(cmt)
 		if err != nil {
 			return err
 		}
-		if err := revListCallback(c, opt, parents, excludeList, callback); err != nil {
+		if ok {
+			shown = append(shown, cmt)
+		}
+	}
+	if opt.Reverse {
+		for i, j := 0, len(shown)-1; i < j; i, j = i+1, j-1 {
+			shown[i], shown[j] = shown[j], shown[i]
+		}
+	}
+	for _, cmt := range shown {
+		if err := emit(cmt); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// A queuedCommit is a commit waiting to be visited by walkCommits.
+type queuedCommit struct {
+	cmt  CommitID
+	date time.Time
+
+	// The order that the commit was added to the queue, so that
+	// commits with the same date are visited in a stable order.
+	seq int
+}
+
+// A commitQueue is a priority queue of commits, with the most recently
+// committed commit first. It implements heap.Interface.
+type commitQueue []queuedCommit
+
+func (q commitQueue) Len() int      { return len(q) }
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q commitQueue) Less(i, j int) bool {
+	if q[i].date.Equal(q[j].date) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].date.After(q[j].date)
+}
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(queuedCommit)) }
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	qc := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return qc
+}
+
+// Returns a function which returns the parents of a commit which should
+// be walked. If firstParent is set, only the first parent of merges is
+// returned.
+func commitParents(c *Client, firstParent bool) func(CommitID) ([]CommitID, error) {
+	return func(cmt CommitID) ([]CommitID, error) {
+		ps, err := cmt.Parents(c)
+		if err != nil {
+			return nil, err
+		}
+		if firstParent && len(ps) > 1 {
+			ps = ps[:1]
+		}
+		return ps, nil
+	}
+}
+
+// Calls fn for every commit reachable from tips which isn't in seen, in
+// reverse chronological order of committer date, and adds them to seen.
+// Only the commits returned by parents are followed, and if since is set,
+// the walk doesn't go past commits older than it. parents is called for
+// each commit before fn.
+func walkCommits(c *Client, tips []CommitID, seen map[Sha1]struct{}, parents func(CommitID) ([]CommitID, error), since time.Time, fn func(CommitID) error) error {
+	var q commitQueue
+	seq := 0
+	push := func(cmt CommitID) error {
+		if _, ok := seen[Sha1(cmt)]; ok {
+			return nil
+		}
+		seen[Sha1(cmt)] = struct{}{}
+		date, err := cmt.GetCommitterDate(c)
+		if err != nil {
+			return err
+		}
+		heap.Push(&q, queuedCommit{cmt, date, seq})
+		seq++
+		return nil
+	}
+	for _, tip := range tips {
+		if err := push(tip); err != nil {
+			return err
+		}
+	}
+	for q.Len() > 0 {
+		qc := heap.Pop(&q).(queuedCommit)
+		if !since.IsZero() && qc.date.Before(since) {
+			continue
+		}
+		ps, err := parents(qc.cmt)
+		if err != nil {
+			return err
+		}
+		if err := fn(qc.cmt); err != nil {
+			return err
+		}
+		for _, p := range ps {
+			if err := push(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Sorts commits so that every commit comes before the parents returned by
+// parents. If byDate is set, commits which are ready are listed newest
+// first. Otherwise, the parents of the last commit listed come next where
+// possible, so that lines of history aren't interleaved.
+func sortCommitsTopologically(c *Client, commits []CommitID, parents func(CommitID) ([]CommitID, error), byDate bool) ([]CommitID, error) {
+	parentMap := make(map[CommitID][]CommitID, len(commits))
+	children := make(map[CommitID]int, len(commits))
+	for _, cmt := range commits {
+		children[cmt] = 0
+	}
+	for _, cmt := range commits {
+		ps, err := parents(cmt)
+		if err != nil {
+			return nil, err
+		}
+		parentMap[cmt] = ps
+		for _, p := range ps {
+			if n, ok := children[p]; ok {
+				children[p] = n + 1
+			}
+		}
+	}
+
+	var byDateQueue commitQueue
+	var stack []CommitID
+	seq := 0
+	ready := func(cmt CommitID) error {
+		if !byDate {
+			stack = append(stack, cmt)
+			return nil
+		}
+		date, err := cmt.GetCommitterDate(c)
+		if err != nil {
+			return err
+		}
+		heap.Push(&byDateQueue, queuedCommit{cmt, date, seq})
+		seq++
+		return nil
+	}
+	// The tips are added in reverse so that the first one is on the top
+	// of the stack.
+	for i := len(commits) - 1; i >= 0; i-- {
+		if children[commits[i]] == 0 {
+			if err := ready(commits[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sorted := make([]CommitID, 0, len(commits))
+	for len(stack) > 0 || byDateQueue.Len() > 0 {
+		var cmt CommitID
+		if byDate {
+			cmt = heap.Pop(&byDateQueue).(queuedCommit).cmt
+		} else {
+			cmt = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		}
+		sorted = append(sorted, cmt)
+		for _, p := range parentMap[cmt] {
+			n, ok := children[p]
+			if !ok {
+				continue
+			}
+			children[p] = n - 1
+			if n == 1 {
+				if err := ready(p); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return sorted, nil
+}
+
+// Returns a function which reports whether a commit should be listed
+// according to the filtering options in opt.
+func revListFilter(c *Client, opt RevListOptions) (func(CommitID) (bool, error), error) {
+	var sides *revListSides
+	if opt.LeftOnly || opt.RightOnly || opt.CherryPick {
+		s, err := symmetricSides(c, opt)
+		if err != nil {
+			return nil, err
+		}
+		sides = s
+	}
+	var until time.Time
+	if opt.Until != "" {
+		u, err := parseExpiry(opt.Until)
+		if err != nil {
+			return nil, err
+		}
+		until = u
+	}
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		var res []*regexp.Regexp
+		for _, p := range patterns {
+			if opt.RegexpIgnoreCase {
+				p = "(?i)" + p
+			}
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, re)
+		}
+		return res, nil
+	}
+	authors, err := compile(opt.Author)
+	if err != nil {
+		return nil, err
+	}
+	committers, err := compile(opt.Committer)
+	if err != nil {
+		return nil, err
+	}
+	greps, err := compile(opt.Grep)
+	if err != nil {
+		return nil, err
+	}
+	anyMatch := func(res []*regexp.Regexp, s string) bool {
+		for _, re := range res {
+			if re.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(cmt CommitID) (bool, error) {
+		if sides != nil {
+			_, left := sides.left[Sha1(cmt)]
+			_, right := sides.right[Sha1(cmt)]
+			_, same := sides.same[Sha1(cmt)]
+			if (opt.LeftOnly && right) || (opt.RightOnly && left) || (opt.CherryPick && same) {
+				return false, nil
+			}
+		}
+		if opt.MinParents > 0 || opt.MaxParents != nil {
+			parents, err := cmt.Parents(c)
+			if err != nil {
+				return false, err
+			}
+			if len(parents) < opt.MinParents {
+				return false, nil
+			}
+			if opt.MaxParents != nil && *opt.MaxParents >= 0 && len(parents) > *opt.MaxParents {
+				return false, nil
+			}
+		}
+		if !until.IsZero() {
+			date, err := cmt.GetCommitterDate(c)
+			if err != nil {
+				return false, err
+			}
+			if date.After(until) {
+				return false, nil
+			}
+		}
+		if len(authors) > 0 {
+			author, err := cmt.GetAuthor(c)
+			if err != nil {
+				return false, err
+			}
+			if !anyMatch(authors, author.String()) {
+				return false, nil
+			}
+		}
+		if len(committers) > 0 {
+			committer, err := cmt.GetCommitter(c)
+			if err != nil {
+				return false, err
+			}
+			if !anyMatch(committers, committer.String()) {
+				return false, nil
+			}
+		}
+		if len(greps) > 0 {
+			msg, err := cmt.GetCommitMessage(c)
+			if err != nil {
+				return false, err
+			}
+			matched := anyMatch(greps, string(msg))
+			if opt.AllMatch {
+				for _, re := range greps {
+					if !re.MatchString(string(msg)) {
+						matched = false
+						break
+					}
+				}
+			}
+			if matched == opt.InvertGrep {
+				return false, nil
+			}
+		}
+		return true, nil
+	}, nil
+}
+
+// The commits on each side of the symmetric differences in a RevListOptions.
+type revListSides struct {
+	left, right map[Sha1]struct{}
+
+	// Commits with an equivalent change on the other side, if
+	// CherryMark or CherryPick is set.
+	same map[Sha1]struct{}
+}
+
+// Finds the commits on each side of the symmetric differences in opt.
+func symmetricSides(c *Client, opt RevListOptions) (*revListSides, error) {
+	sides := &revListSides{
+		left:  make(map[Sha1]struct{}),
+		right: make(map[Sha1]struct{}),
+		same:  make(map[Sha1]struct{}),
+	}
+	if len(opt.Left) == 0 && len(opt.Right) == 0 {
+		return sides, nil
+	}
+	left, err := RevList(c, RevListOptions{Quiet: true}, nil, opt.Left, opt.Right)
+	if err != nil {
+		return nil, err
+	}
+	right, err := RevList(c, RevListOptions{Quiet: true}, nil, opt.Right, opt.Left)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range left {
+		sides.left[s] = struct{}{}
+	}
+	for _, s := range right {
+		sides.right[s] = struct{}{}
+	}
+	if !opt.CherryMark && !opt.CherryPick {
+		return sides, nil
+	}
+
+	patchIDs := func(commits []Sha1) (map[Sha1]Sha1, error) {
+		ids := make(map[Sha1]Sha1)
+		for _, s := range commits {
+			parents, err := CommitID(s).Parents(c)
+			if err != nil {
+				return nil, err
+			}
+			if len(parents) > 1 {
+				// Merges are never considered equivalent.
+				continue
+			}
+			id, err := patchID(c, CommitID(s))
+			if err != nil {
+				return nil, err
+			}
+			ids[s] = id
+		}
+		return ids, nil
+	}
+	leftIDs, err := patchIDs(left)
+	if err != nil {
+		return nil, err
+	}
+	rightIDs, err := patchIDs(right)
+	if err != nil {
+		return nil, err
+	}
+	inRight := make(map[Sha1]struct{})
+	for _, id := range rightIDs {
+		inRight[id] = struct{}{}
+	}
+	inLeft := make(map[Sha1]struct{})
+	for s, id := range leftIDs {
+		inLeft[id] = struct{}{}
+		if _, ok := inRight[id]; ok {
+			sides.same[s] = struct{}{}
+		}
+	}
+	for s, id := range rightIDs {
+		if _, ok := inLeft[id]; ok {
+			sides.same[s] = struct{}{}
+		}
+	}
+	return sides, nil
+}
+
+// RevListMarker returns a function which returns the mark that should
+// be printed before an object listed by RevListCallback for the
+// --left-right and --cherry-mark options in opt. Objects which aren't
+// marked return an empty string.
+func RevListMarker(c *Client, opt RevListOptions) (func(Sha1) string, error) {
+	if !opt.LeftRight && !opt.CherryMark {
+		return func(Sha1) string { return "" }, nil
+	}
+	sides, err := symmetricSides(c, opt)
+	if err != nil {
+		return nil, err
+	}
+	return func(s Sha1) string {
+		_, left := sides.left[s]
+		_, right := sides.right[s]
+		if !left && !right {
+			return ""
+		}
+		if _, same := sides.same[s]; same && opt.CherryMark {
+			return "="
+		}
+		switch {
+		case opt.LeftRight && left:
+			return "<"
+		case opt.LeftRight && right:
+			return ">"
+		case opt.CherryMark:
+			return "+"
+		}
+		return ""
+	}, nil
+}
+
+// Returns an ID for the change introduced by cmt which ignores whitespace
+// and line numbers, so that the same change applied on top of a different
+// commit has the same ID.
+func patchID(c *Client, cmt CommitID) (Sha1, error) {
+	diffs, err := showCommitDiffs(c, DiffCommonOptions{}, cmt)
+	if err != nil {
+		return Sha1{}, err
+	}
+	var patch bytes.Buffer
+	if err := GeneratePatch(c, DiffCommonOptions{Patch: true}, diffs, &patch); err != nil {
+		return Sha1{}, err
+	}
+	h := sha1.New()
+	for _, line := range strings.Split(patch.String(), "\n") {
+		if strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "index ") {
+			continue
+		}
+		io.WriteString(h, strings.Join(strings.Fields(line), ""))
+	}
+	var id Sha1
+	copy(id[:], h.Sum(nil))
+	return id, nil
+}
@@ -0,0 +1,292 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRevListOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrevlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := c.WriteObject("tree", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("GIT_AUTHOR_DATE")
+	defer os.Unsetenv("GIT_COMMITTER_DATE")
+	commit := func(msg string, date int, parents ...CommitID) CommitID {
+		t.Helper()
+		d := fmt.Sprintf("%d +0000", 1500000000+date)
+		os.Setenv("GIT_AUTHOR_DATE", d)
+		os.Setenv("GIT_COMMITTER_DATE", d)
+		cmt, err := CommitTree(c, CommitTreeOptions{}, TreeID(tree), parents, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmt
+	}
+	// A history where D has a skewed clock, so that it's older than its
+	// parent:
+	//
+	//  A - B - D - M
+	//   \         /
+	//    C ------
+	A := commit("A", 100)
+	B := commit("B", 200, A)
+	D := commit("D fix", 50, B)
+	C := commit("C fix", 300, A)
+	M := commit("M", 400, D, C)
+
+	maxCount := func(n uint) *uint { return &n }
+	noMerges := 1
+	tests := []struct {
+		name string
+		opt  RevListOptions
+		want []CommitID
+	}{
+		{"default", RevListOptions{}, []CommitID{M, C, A, D, B}},
+		{"date-order", RevListOptions{DateOrder: true}, []CommitID{M, C, D, B, A}},
+		{"topo-order", RevListOptions{TopoOrder: true}, []CommitID{M, C, D, B, A}},
+		{"reverse", RevListOptions{TopoOrder: true, Reverse: true}, []CommitID{A, B, D, C, M}},
+		{"first-parent", RevListOptions{FirstParent: true}, []CommitID{M, D, B, A}},
+		{"skip", RevListOptions{DateOrder: true, Skip: 1, MaxCount: maxCount(2)}, []CommitID{C, D}},
+		{"reverse max-count", RevListOptions{DateOrder: true, Reverse: true, MaxCount: maxCount(2)}, []CommitID{C, M}},
+		{"merges", RevListOptions{MinParents: 2}, []CommitID{M}},
+		{"no-merges", RevListOptions{MaxParents: &noMerges, TopoOrder: true}, []CommitID{C, D, B, A}},
+		{"grep", RevListOptions{Grep: []string{"FIX"}, RegexpIgnoreCase: true}, []CommitID{C, D}},
+		{"invert-grep", RevListOptions{Grep: []string{"fix"}, InvertGrep: true}, []CommitID{M, A, B}},
+		{"since", RevListOptions{Since: "1500000250 +0000"}, []CommitID{M, C}},
+		{"until", RevListOptions{Until: "1500000250 +0000"}, []CommitID{A, D, B}},
+	}
+	for _, tc := range tests {
+		tc.opt.Quiet = true
+		got, err := RevList(c, tc.opt, nil, []Commitish{M}, nil)
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: got %v want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != Sha1(tc.want[i]) {
+				t.Errorf("%v: got %v want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRevListPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrevlistpaths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := func(files ...string) TreeID {
+		t.Helper()
+		var listing bytes.Buffer
+		for i := 0; i < len(files); i += 2 {
+			blob, err := c.WriteObject("blob", []byte(files[i+1]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&listing, "100644 blob %v\t%v\n", blob, files[i])
+		}
+		tid, err := MkTree(c, MkTreeOptions{}, &listing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tid
+	}
+	defer os.Unsetenv("GIT_AUTHOR_DATE")
+	defer os.Unsetenv("GIT_COMMITTER_DATE")
+	commit := func(tree TreeID, date int, parents ...CommitID) CommitID {
+		t.Helper()
+		d := fmt.Sprintf("%d +0000", 1500000000+date)
+		os.Setenv("GIT_AUTHOR_DATE", d)
+		os.Setenv("GIT_COMMITTER_DATE", d)
+		cmt, err := CommitTree(c, CommitTreeOptions{}, tree, parents, "msg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmt
+	}
+	// B changes foo.txt on a branch, C changes bar.txt, M merges them
+	// and then R renames foo.txt to baz.txt.
+	//
+	//  A - C - M - R
+	//   \     /
+	//    B ---
+	A := commit(tree("bar.txt", "bar\n", "foo.txt", "foo\n"), 100)
+	B := commit(tree("bar.txt", "bar\n", "foo.txt", "foo2\n"), 200, A)
+	C := commit(tree("bar.txt", "bar2\n", "foo.txt", "foo\n"), 300, A)
+	M := commit(tree("bar.txt", "bar2\n", "foo.txt", "foo2\n"), 400, C, B)
+	R := commit(tree("bar.txt", "bar2\n", "baz.txt", "foo2\n"), 500, M)
+
+	tests := []struct {
+		name string
+		opt  RevListOptions
+		want []CommitID
+	}{
+		{"default", RevListOptions{Paths: []IndexPath{"foo.txt"}}, []CommitID{R, B, A}},
+		{"other side", RevListOptions{Paths: []IndexPath{"bar.txt"}}, []CommitID{C, A}},
+		{"whole tree", RevListOptions{Paths: []IndexPath{"."}}, []CommitID{R, M, C, B, A}},
+		{"missing", RevListOptions{Paths: []IndexPath{"nonexistent"}}, nil},
+		{"full-history", RevListOptions{Paths: []IndexPath{"foo.txt"}, FullHistory: true}, []CommitID{R, M, B, A}},
+		{"simplify-merges", RevListOptions{Paths: []IndexPath{"foo.txt"}, SimplifyMerges: true}, []CommitID{R, B, A}},
+		{"renamed", RevListOptions{Paths: []IndexPath{"baz.txt"}}, []CommitID{R}},
+		{"follow", RevListOptions{Paths: []IndexPath{"baz.txt"}, Follow: true}, []CommitID{R, B, A}},
+	}
+	for _, tc := range tests {
+		tc.opt.Quiet = true
+		got, err := RevList(c, tc.opt, nil, []Commitish{R}, nil)
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: got %v want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != Sha1(tc.want[i]) {
+				t.Errorf("%v: got %v want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+
+	// S renames baz.txt to qux.txt and changes it at the same time, which
+	// --follow still follows because they're at least 50% similar.
+	S := commit(tree("bar.txt", "bar2\n", "qux.txt", "foo2\nfoo3\n"), 600, R)
+	got, err := RevList(c, RevListOptions{Quiet: true, Paths: []IndexPath{"qux.txt"}, Follow: true}, nil, []Commitish{S}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []CommitID{S, R, B, A}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("follow with changes: got %v want %v", got, want)
+	}
+
+	diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true}, M, R, []string{"baz.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Name != "baz.txt" {
+		t.Errorf("Unexpected diff-tree for baz.txt: %v", diffs)
+	}
+}
+
+func TestRevListSimplifyMerges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrevlistsimplify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each tree has bar, foo and other with the given contents.
+	tree := func(bar, foo, other string) TreeID {
+		t.Helper()
+		var listing bytes.Buffer
+		for _, f := range [][2]string{{"bar", bar}, {"foo", foo}, {"other", other}} {
+			blob, err := c.WriteObject("blob", []byte(f[1]+"\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&listing, "100644 blob %v\t%v\n", blob, f[0])
+		}
+		tid, err := MkTree(c, MkTreeOptions{}, &listing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tid
+	}
+	defer os.Unsetenv("GIT_AUTHOR_DATE")
+	defer os.Unsetenv("GIT_COMMITTER_DATE")
+	date := 0
+	commit := func(tree TreeID, parents ...CommitID) CommitID {
+		t.Helper()
+		date += 100
+		d := fmt.Sprintf("%d +0000", 1500000000+date)
+		os.Setenv("GIT_AUTHOR_DATE", d)
+		os.Setenv("GIT_COMMITTER_DATE", d)
+		cmt, err := CommitTree(c, CommitTreeOptions{}, tree, parents, "msg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmt
+	}
+	// A history with a branch merged in at each of M1, M2 and M3, where
+	// the commits on either side of each merge change foo or bar.
+	//
+	//    B ----- C
+	//   /         \
+	//  A - D - E - M1 - H - M2 - J - M3 - K
+	//           \          /    \       /
+	//            F ------ G      I -----
+	A := commit(tree("a", "a", "a"))
+	B := commit(tree("a", "b", "a"), A)
+	C := commit(tree("c", "b", "a"), B)
+	D := commit(tree("a", "a", "d"), A)
+	E := commit(tree("a", "e", "d"), D)
+	M1 := commit(tree("c", "m1", "d"), E, C)
+	F := commit(tree("a", "f", "d"), E)
+	G := commit(tree("g", "f", "d"), F)
+	H := commit(tree("c", "m1", "h"), M1)
+	M2 := commit(tree("g", "m2", "h"), H, G)
+	I := commit(tree("g", "i", "h"), M2)
+	J := commit(tree("j", "m2", "h"), M2)
+	M3 := commit(tree("j", "i", "h"), J, I)
+	K := commit(tree("j", "i", "k"), M3)
+
+	// The expected orders are the same as git's.
+	tests := []struct {
+		name string
+		opt  RevListOptions
+		want []CommitID
+	}{
+		{"foo", RevListOptions{Paths: []IndexPath{"foo"}}, []CommitID{I, M2, F, M1, B, E, A}},
+		{"foo reverse", RevListOptions{Paths: []IndexPath{"foo"}, Reverse: true}, []CommitID{A, E, B, M1, F, M2, I}},
+		{"bar", RevListOptions{Paths: []IndexPath{"bar"}}, []CommitID{J, M2, G, C, A}},
+	}
+	for _, tc := range tests {
+		tc.opt.Quiet = true
+		tc.opt.SimplifyMerges = true
+		got, err := RevList(c, tc.opt, nil, []Commitish{K}, nil)
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: got %v want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != Sha1(tc.want[i]) {
+				t.Errorf("%v: got %v want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -178,6 +179,17 @@ func RevParseTreeish(c *Client, opt *RevParseOptions, arg string) (Treeish, erro
 	if arg == "HEAD" {
 		return c.GetHeadCommit()
 	}
+	if isRevisionExpression(arg) {
+		obj, err := revParseObject(c, opt, arg)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := peelRevision(c, obj, "tree")
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a tree-ish", arg)
+		}
+		return TreeID(tree), nil
+	}
 
 	refs, err := ShowRef(c, ShowRefOptions{}, []string{arg})
 	if err == nil && len(refs) > 0 {
@@ -194,47 +206,26 @@ func RevParseTreeish(c *Client, opt *RevParseOptions, arg string) (Treeish, erro
 
 // RevParse will parse a single revision into a Commitish object.
 func RevParseCommitish(c *Client, opt *RevParseOptions, arg string) (cmt Commitish, err error) {
-	var cmtbase string
-	if pos := strings.IndexAny(arg, "@^"); pos >= 0 {
-		cmtbase = arg[:pos]
-		defer func(mod string) {
+	if strings.HasPrefix(arg, "@{-") && strings.HasSuffix(arg, "}") {
+		// @{-N} on its own refers to the branch that was checked
+		// out, not just its commit, so that it can be checked out
+		// again.
+		if n, err := strconv.Atoi(arg[3 : len(arg)-1]); err == nil && n > 0 {
+			name, err := previousCheckout(c, n)
 			if err != nil {
-				// If there was already an error, then just let it be.
-				return
+				return nil, err
 			}
-			// FIXME: This should actually implement various ^ and @{} modifiers
-			switch mod {
-			case "^0":
-				basecmt, newerr := cmt.CommitID(c)
-				if newerr != nil {
-					err = newerr
-					return
-				}
-				cmt = basecmt
-				return
-			case "^":
-				basecmt, newerr := cmt.CommitID(c)
-				if newerr != nil {
-					err = newerr
-					return
-				}
-				parents, newerr := basecmt.Parents(c)
-				if newerr != nil {
-					err = newerr
-					return
-				}
-				if len(parents) != 1 {
-					err = fmt.Errorf("Can not use ^ modifier on merge commit.")
-					return
-				}
-				cmt = parents[0]
-				return
-			}
-			err = fmt.Errorf("Unhandled commit modifier: %v", mod)
-		}(arg[pos:])
-	} else {
-		cmtbase = arg
+			return RevParseCommitish(c, opt, name)
+		}
+	}
+	if isRevisionExpression(arg) {
+		obj, err := revParseObject(c, opt, arg)
+		if err != nil {
+			return nil, err
+		}
+		return peelToCommit(c, obj)
 	}
+	cmtbase := arg
 	if len(cmtbase) == 40 {
 		sha1, err := Sha1FromString(cmtbase)
 		return CommitID(sha1), err
@@ -243,6 +234,15 @@ func RevParseCommitish(c *Client, opt *RevParseOptions, arg string) (cmt Commiti
 		return c.GetHeadCommit()
 	}
 
+	// Pseudo-refs such as ORIG_HEAD and FETCH_HEAD are files in the
+	// git directory containing an object ID.
+	if cmtbase != "" && strings.ToUpper(cmtbase) == cmtbase && strings.HasSuffix(cmtbase, "HEAD") {
+		if data, err := ioutil.ReadFile(c.GitDir.File(File(cmtbase)).String()); err == nil && len(data) >= 40 {
+			sha1, err := Sha1FromString(string(data[:40]))
+			return CommitID(sha1), err
+		}
+	}
+
 	// Check if it's a symbolic ref
 	var b Branch
 	r, err := SymbolicRefGet(c, SymbolicRefOptions{}, SymbolicRef(cmtbase))
@@ -253,12 +253,12 @@ func RevParseCommitish(c *Client, opt *RevParseOptions, arg string) (cmt Commiti
 		}
 	}
 	if strings.HasPrefix(cmtbase, "refs/") {
-		if rs := c.GitDir.File(File(cmtbase)); rs.Exists() {
-			return RefSpec(cmtbase), nil
+		if rs := RefSpec(cmtbase); rs.Exists(c) {
+			return rs, nil
 		}
 	}
-	if rs := c.GitDir.File("refs/tags/" + File(cmtbase)); rs.Exists() {
-		return RefSpec("refs/tags/" + cmtbase), nil
+	if rs := RefSpec("refs/tags/" + cmtbase); rs.Exists(c) {
+		return rs, nil
 	}
 
 	// arg was not a Sha or a symbolic ref, it might still be a branch.
@@ -292,41 +292,25 @@ func RevParseCommitish(c *Client, opt *RevParseOptions, arg string) (cmt Commiti
 		// We need to check the pack file indexes even
 		// if we already found something in order to
 		// ensure that it's not an ambiguous reference.
-		packdir := filepath.Join(c.GitDir.String(), "objects", "pack")
-		packs, err := ioutil.ReadDir(packdir)
-		if err != nil {
-			// There was an error getting the packfiles,
-			// so assume there aren't any.
-			goto donecommit
-		}
-
-		for _, fi := range packs {
-			if filepath.Ext(fi.Name()) != ".idx" {
-				continue
-			}
-			packfile := filepath.Join(
-				c.GitDir.String(),
-				"objects",
-				"pack",
-				filepath.Base(fi.Name()),
-			)
-			f, err := os.Open(packfile)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-			defer f.Close()
-
-			objects := v2PackObjectListFromIndex(f)
-			for _, obj := range objects {
-				cand := obj.String()
-				if strings.HasPrefix(cand, cmtbase) {
+		// An object may be both loose and packed, so it
+		// is only ambiguous if there's a different
+		// object.
+		packed, err := c.packs.findPrefix(c, cmtbase)
+		if err == nil {
+			for _, obj := range packed {
+				dup := false
+				for _, cand := range candidates {
+					if cand == CommitID(obj) {
+						dup = true
+						break
+					}
+				}
+				if !dup {
 					candidates = append(candidates, CommitID(obj))
 				}
 			}
 		}
 
-	donecommit:
 		if len(candidates) == 1 {
 			return candidates[0], nil
 		} else if len(candidates) > 1 {
@@ -435,23 +419,19 @@ func RevParse(c *Client, opt RevParseOptions, args []string) (commits []ParsedRe
 					sha = arg
 					exclude = false
 				}
-				if strings.Contains(arg, ":") {
-					sha, err := RevParsePath(c, &opt, arg)
+				if strings.HasPrefix(sha, ":/") || (isRevisionExpression(sha) && !strings.Contains(sha, ":")) {
+					obj, err := revParseObject(c, &opt, sha)
 					if err != nil {
 						err2 = err
 					} else {
-						commits = append(commits, ParsedRevision{sha, exclude})
+						commits = append(commits, ParsedRevision{obj, exclude})
 					}
-				} else if strings.HasSuffix(arg, "^{tree}") {
-					tree, err := RevParseTreeish(c, &opt, strings.TrimSuffix(sha, "^{tree}"))
+				} else if strings.Contains(arg, ":") {
+					sha, err := RevParsePath(c, &opt, arg)
 					if err != nil {
 						err2 = err
 					} else {
-						treeid, err := tree.TreeID(c)
-						if err != nil {
-							err2 = err
-						}
-						commits = append(commits, ParsedRevision{Sha1(treeid), exclude})
+						commits = append(commits, ParsedRevision{sha, exclude})
 					}
 				} else {
 					obj, err := RevParseCommitish(c, &opt, sha)
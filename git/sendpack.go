@@ -0,0 +1,347 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A PushUpdate is a request to update a single ref on a remote from
+// one value to another.
+type PushUpdate struct {
+	// The name of the ref on the remote.
+	Ref Refname
+
+	// The value the ref currently has on the remote, or the zero
+	// Sha1 if it's being created.
+	Old Sha1
+
+	// The value to set the ref to, or the zero Sha1 if it's being
+	// deleted.
+	New Sha1
+}
+
+// Returns true if the update deletes the remote ref.
+func (u PushUpdate) IsDelete() bool {
+	return u.New == (Sha1{})
+}
+
+// The status of a PushUpdate, as reported by the remote.
+type PushRefStatus struct {
+	Ref Refname
+
+	// Whether the remote accepted the update.
+	OK bool
+
+	// The reason that the remote gave for rejecting the update, if
+	// it wasn't OK.
+	Reason string
+}
+
+// SendPackOptions are the options that may be passed to SendPack.
+type SendPackOptions struct {
+	// Ask the remote not to print progress information.
+	Quiet bool
+
+	// Ask the remote to either apply all of the updates or none of
+	// them.
+	Atomic bool
+
+	// Where to print progress messages that the remote sends over
+	// the sideband. If nil, os.Stderr is used.
+	Progress io.Writer
+}
+
+// SendPack sends updates to the git-receive-pack service on conn, which
+// must have been created by NewReceivePackConn and opened. If any of the
+// updates set a ref, a packfile of the objects that the remote needs is
+// also sent. The objects which are reachable from refs that the remote
+// advertised and that we have locally are excluded from the pack.
+//
+// It returns the status of each update as reported by the remote. An
+// error is only returned if the push as a whole failed.
+func SendPack(c *Client, opts SendPackOptions, conn RemoteConn, updates []PushUpdate) ([]PushRefStatus, error) {
+	if len(updates) == 0 {
+		return nil, nil
+	}
+	caps := conn.Capabilities()
+
+	// Build the capabilities that we want to use on the first line.
+	var wanted []string
+	_, report := caps["report-status"]
+	if report {
+		wanted = append(wanted, "report-status")
+	}
+	sideband := false
+	if _, ok := caps["side-band-64k"]; ok {
+		wanted = append(wanted, "side-band-64k")
+		sideband = true
+	}
+	if _, ok := caps["quiet"]; ok && opts.Quiet {
+		wanted = append(wanted, "quiet")
+	}
+	if opts.Atomic {
+		if _, ok := caps["atomic"]; !ok {
+			return nil, fmt.Errorf("The receiving end does not support --atomic push")
+		}
+		wanted = append(wanted, "atomic")
+	}
+	if _, ok := caps["agent"]; ok {
+		wanted = append(wanted, "agent=dgit/0.0.2")
+	}
+
+	sendpack := false
+	for _, u := range updates {
+		if u.IsDelete() {
+			if _, ok := caps["delete-refs"]; !ok {
+				return nil, fmt.Errorf("The receiving end does not support deleting refs")
+			}
+		} else {
+			sendpack = true
+		}
+	}
+
+	for i, u := range updates {
+		line := fmt.Sprintf("%v %v %v", u.Old, u.New, u.Ref)
+		if i == 0 {
+			line += "\000" + strings.Join(wanted, " ")
+		}
+		log.Println("Sending ref update", line)
+		if _, err := fmt.Fprintf(conn, "%s\n", line); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	if sendpack {
+		objects, err := pushObjects(c, conn, updates)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Sending %d objects\n", len(objects))
+		_, ofsdelta := caps["ofs-delta"]
+		packopts := PackObjectsOptions{Window: 10, Depth: 50, DeltaBaseOffset: ofsdelta}
+		if _, err := PackObjects(c, packopts, conn.RawWriter(), objects); err != nil {
+			return nil, err
+		}
+	}
+
+	if !report {
+		// The remote isn't going to tell us what happened, so assume
+		// that everything worked.
+		statuses := make([]PushRefStatus, len(updates))
+		for i, u := range updates {
+			statuses[i] = PushRefStatus{Ref: u.Ref, OK: true}
+		}
+		return statuses, nil
+	}
+
+	// Read the report-status, which may be multiplexed over the
+	// sideband.
+	var status io.Reader
+	if sideband {
+		progress := opts.Progress
+		if progress == nil {
+			progress = os.Stderr
+		}
+		conn.SetSideband(progress)
+		conn.SetReadMode(PktLineSidebandMode)
+		var buf bytes.Buffer
+		data := make([]byte, 65536)
+		for {
+			n, err := conn.Read(data)
+			if err == flushPkt || err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			buf.Write(data[:n])
+		}
+		status = &buf
+	} else {
+		conn.SetReadMode(DirectReadMode)
+		status = conn
+	}
+	return parseReportStatus(status, updates)
+}
+
+// Returns the objects that need to be sent to the remote for updates. This
+// is every object reachable from the new values, except for those reachable
+// from any value that the remote has advertised and we have locally.
+func pushObjects(c *Client, conn RemoteConn, updates []PushUpdate) ([]Sha1, error) {
+	var extra []Sha1
+	var includes, excludes []Commitish
+	for _, u := range updates {
+		if u.IsDelete() {
+			continue
+		}
+		// Tags need to send the tag objects themselves in addition
+		// to the commits that they point to.
+		sha := u.New
+		for sha.Type(c) == "tag" {
+			extra = append(extra, sha)
+			target, err := tagTarget(c, sha)
+			if err != nil {
+				return nil, err
+			}
+			sha = target
+		}
+		switch t := sha.Type(c); t {
+		case "commit":
+			includes = append(includes, CommitID(sha))
+		case "tree", "blob":
+			extra = append(extra, sha)
+		default:
+			return nil, fmt.Errorf("Can not push object %v of type %v", u.New, t)
+		}
+	}
+
+	rmtrefs, err := conn.GetRefs(LsRemoteOptions{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var haves []Sha1
+	for _, ref := range rmtrefs {
+		haves = append(haves, ref.Value)
+	}
+	for _, u := range updates {
+		haves = append(haves, u.Old)
+	}
+	for _, sha := range haves {
+		if sha == (Sha1{}) {
+			continue
+		}
+		if have, _, err := c.HaveObject(sha); !have || err != nil {
+			continue
+		}
+		for sha.Type(c) == "tag" {
+			target, err := tagTarget(c, sha)
+			if err != nil {
+				break
+			}
+			sha = target
+		}
+		if sha.Type(c) == "commit" {
+			excludes = append(excludes, CommitID(sha))
+		}
+	}
+
+	seen := make(map[Sha1]struct{})
+	var objects []Sha1
+	for _, sha := range extra {
+		if _, ok := seen[sha]; !ok {
+			seen[sha] = struct{}{}
+			objects = append(objects, sha)
+		}
+	}
+	if len(includes) > 0 {
+		revs, err := RevList(c, RevListOptions{Objects: true, Quiet: true}, ioutil.Discard, includes, excludes)
+		if err != nil {
+			return nil, err
+		}
+		for _, sha := range revs {
+			if _, ok := seen[sha]; !ok {
+				seen[sha] = struct{}{}
+				objects = append(objects, sha)
+			}
+		}
+	}
+	return objects, nil
+}
+
+// Returns the object that the annotated tag tag points to.
+func tagTarget(c *Client, tag Sha1) (Sha1, error) {
+	obj, err := c.GetObject(tag)
+	if err != nil {
+		return Sha1{}, err
+	}
+	content := obj.GetContent()
+	if !bytes.HasPrefix(content, []byte("object ")) || len(content) < 47 {
+		return Sha1{}, fmt.Errorf("Invalid tag object %v", tag)
+	}
+	return Sha1FromString(string(content[7:47]))
+}
+
+// Reads one pkt-line from r. It returns flushPkt for a flush packet.
+func readPktLine(r io.Reader) (string, error) {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(r, size); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(size), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("Invalid pkt-line length %q", size)
+	}
+	switch {
+	case n == 0:
+		return "", flushPkt
+	case n < 4:
+		return "", fmt.Errorf("Invalid pkt-line length %q", size)
+	}
+	line := make([]byte, n-4)
+	if _, err := io.ReadFull(r, line); err != nil {
+		return "", err
+	}
+	return string(line), nil
+}
+
+// Parses a report-status response from r.
+func parseReportStatus(r io.Reader, updates []PushUpdate) ([]PushRefStatus, error) {
+	line, err := readPktLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read status from remote: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	if !strings.HasPrefix(line, "unpack ") {
+		return nil, fmt.Errorf("Invalid status from remote: %v", line)
+	}
+	var unpackErr error
+	if unpack := strings.TrimPrefix(line, "unpack "); unpack != "ok" {
+		unpackErr = fmt.Errorf("Remote unpack failed: %v", unpack)
+	}
+
+	reported := make(map[Refname]PushRefStatus)
+	for {
+		line, err := readPktLine(r)
+		if err == flushPkt || err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "ok "):
+			ref := Refname(strings.TrimPrefix(line, "ok "))
+			reported[ref] = PushRefStatus{Ref: ref, OK: true}
+		case strings.HasPrefix(line, "ng "):
+			pieces := strings.SplitN(strings.TrimPrefix(line, "ng "), " ", 2)
+			st := PushRefStatus{Ref: Refname(pieces[0])}
+			if len(pieces) == 2 {
+				st.Reason = pieces[1]
+			}
+			reported[st.Ref] = st
+		default:
+			return nil, fmt.Errorf("Invalid status from remote: %v", line)
+		}
+	}
+
+	statuses := make([]PushRefStatus, len(updates))
+	for i, u := range updates {
+		st, ok := reported[u.Ref]
+		if !ok {
+			st = PushRefStatus{Ref: u.Ref, Reason: "remote failed to report status"}
+			if unpackErr != nil {
+				st.Reason = "unpacker error"
+			}
+		}
+		statuses[i] = st
+	}
+	return statuses, unpackErr
+}
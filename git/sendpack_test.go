@@ -0,0 +1,56 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReportStatus(t *testing.T) {
+	updates := []PushUpdate{
+		{Ref: "refs/heads/master"},
+		{Ref: "refs/heads/other"},
+	}
+	tests := []struct {
+		Report  string
+		Want    []PushRefStatus
+		WantErr bool
+	}{
+		{
+			Report: "000eunpack ok\n0019ok refs/heads/master\n0018ok refs/heads/other\n0000",
+			Want: []PushRefStatus{
+				{Ref: "refs/heads/master", OK: true},
+				{Ref: "refs/heads/other", OK: true},
+			},
+		},
+		{
+			Report: "000eunpack ok\n0019ok refs/heads/master\n0032ng refs/heads/other pre-receive hook declined\n0000",
+			Want: []PushRefStatus{
+				{Ref: "refs/heads/master", OK: true},
+				{Ref: "refs/heads/other", Reason: "pre-receive hook declined"},
+			},
+		},
+		{
+			Report: "001dunpack index-pack failed\n0000",
+			Want: []PushRefStatus{
+				{Ref: "refs/heads/master", Reason: "unpacker error"},
+				{Ref: "refs/heads/other", Reason: "unpacker error"},
+			},
+			WantErr: true,
+		},
+	}
+	for i, test := range tests {
+		got, err := parseReportStatus(strings.NewReader(test.Report), updates)
+		if (err != nil) != test.WantErr {
+			t.Errorf("Case %d: unexpected error %v", i, err)
+		}
+		if len(got) != len(test.Want) {
+			t.Errorf("Case %d: got %v want %v", i, got, test.Want)
+			continue
+		}
+		for j := range got {
+			if got[j] != test.Want[j] {
+				t.Errorf("Case %d: got %v want %v", i, got[j], test.Want[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,706 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Files in the GitDir which record the commit that's being cherry-picked or
+// reverted when the sequencer stops.
+const (
+	cherryPickHeadFile = File("CHERRY_PICK_HEAD")
+	revertHeadFile     = File("REVERT_HEAD")
+)
+
+// The directory in the GitDir which holds the state of an in progress
+// sequence of cherry-picks or reverts. It contains:
+//
+//	todo:         the commits which still need to be applied, the first of
+//	              which is the one the sequencer stopped at.
+//	head:         the commit HEAD pointed to before the sequence started.
+//	abort-safety: the commit HEAD pointed to when the sequencer stopped.
+//	opts:         the options of the sequence, as a config file.
+const sequencerDir = File("sequencer")
+
+// Options which affect how each commit in a sequence is applied. They're
+// saved so that they're still in effect when the sequence is continued.
+type sequencerOptions struct {
+	Edit         bool
+	Mainline     int
+	NoCommit     bool
+	SignOff      bool
+	RecordOrigin bool
+	AllowEmpty   bool
+	Strategy     string
+}
+
+// The sequencer's actions for a commit in the todo list.
+const (
+	pickAction   = "pick"
+	revertAction = "revert"
+)
+
+// A commit to be applied by the sequencer.
+type todoItem struct {
+	action string
+	commit CommitID
+}
+
+type sequencer struct {
+	opts sequencerOptions
+	todo []todoItem
+
+	// The commit that HEAD pointed to before the sequence started.
+	head CommitID
+	// The commit that HEAD pointed to when the sequencer stopped.
+	abortSafety CommitID
+}
+
+// Returns true if there is a cherry-pick or revert sequence in progress.
+func (c *Client) IsSequencing() bool {
+	return c.GitDir.File(sequencerDir).Exists()
+}
+
+// Returns the commit which is being cherry-picked, as recorded in
+// CHERRY_PICK_HEAD.
+func (c *Client) GetPickHead() (CommitID, error) {
+	return c.readHeadFile(cherryPickHeadFile)
+}
+
+// Returns the commit which is being reverted, as recorded in REVERT_HEAD.
+func (c *Client) GetRevertHead() (CommitID, error) {
+	return c.readHeadFile(revertHeadFile)
+}
+
+func (c *Client) readHeadFile(f File) (CommitID, error) {
+	content, err := c.GitDir.ReadFile(f)
+	if err != nil {
+		return CommitID{}, err
+	}
+	return CommitIDFromString(strings.TrimSpace(string(content)))
+}
+
+// Returns the name of the command which performs action, for use in
+// messages.
+func sequencerCommand(action string) string {
+	if action == revertAction {
+		return "revert"
+	}
+	return "cherry-pick"
+}
+
+// Starts a new sequence which applies each of commits with action.
+func startSequence(c *Client, opts sequencerOptions, action string, commits []Commitish) error {
+	if len(commits) == 0 {
+		return fmt.Errorf("empty commit set passed")
+	}
+	if c.IsSequencing() || c.GitDir.File(cherryPickHeadFile).Exists() || c.GitDir.File(revertHeadFile).Exists() {
+		return fmt.Errorf("a cherry-pick or revert is already in progress\nhint: try \"git %v (--continue | --quit | --abort)\"", sequencerCommand(action))
+	}
+	if c.IsMerging() {
+		return fmt.Errorf("You have not concluded your merge (MERGE_HEAD exists).")
+	}
+	switch opts.Strategy {
+	case "", string(MergeRecursive), "ort", "resolve":
+	default:
+		return fmt.Errorf("Merge strategy %v not implemented", opts.Strategy)
+	}
+
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	s := &sequencer{opts: opts, head: head}
+	for _, cmt := range commits {
+		id, err := cmt.CommitID(c)
+		if err != nil {
+			return err
+		}
+		s.todo = append(s.todo, todoItem{action, id})
+	}
+	return s.run(c, true)
+}
+
+// Applies the remaining commits in the todo list. If fresh is true, the
+// sequence has just started and nothing is saved if the first commit fails
+// before anything was changed.
+func (s *sequencer) run(c *Client, fresh bool) error {
+	for len(s.todo) > 0 {
+		stopped, err := s.apply(c, s.todo[0])
+		if err != nil {
+			if fresh && !stopped {
+				// Nothing was changed, so there's nothing to
+				// continue or abort.
+				s.remove(c)
+				return err
+			}
+			if err2 := s.save(c); err2 != nil {
+				return err2
+			}
+			return err
+		}
+		fresh = false
+		s.todo = s.todo[1:]
+	}
+	return s.remove(c)
+}
+
+// Applies a single commit to the index and work tree, and commits it
+// unless the NoCommit option was given. It returns true if the sequencer
+// stopped after changing the index, either because of conflicts or
+// because the result was empty.
+func (s *sequencer) apply(c *Client, item todoItem) (bool, error) {
+	cmt := item.commit
+	command := sequencerCommand(item.action)
+	change, err := pickChange(c, cmt, s.opts.Mainline, item.action == revertAction, s.opts.NoCommit, command)
+	if err != nil {
+		return false, err
+	}
+	msg, conflicts, headTree := change.msg, change.conflicts, change.headTree
+	short := cmt.String()[:7]
+	subject := msg.Subject()
+
+	message, err := s.message(c, item, msg, change.parent, change.merge)
+	if err != nil {
+		return false, err
+	}
+	headFile := cherryPickHeadFile
+	if item.action == revertAction {
+		headFile = revertHeadFile
+	}
+	if len(conflicts) > 0 {
+		message = strings.TrimRight(message, "\n") + "\n\n# Conflicts:\n"
+		for _, path := range conflicts {
+			message += "#\t" + path.String() + "\n"
+		}
+		if err := c.GitDir.WriteFile(mergeMsgFile, []byte(message), 0644); err != nil {
+			return true, err
+		}
+		if !s.opts.NoCommit {
+			if err := c.GitDir.WriteFile(headFile, []byte(cmt.String()+"\n"), 0644); err != nil {
+				return true, err
+			}
+		}
+		verb := "apply"
+		if item.action == revertAction {
+			verb = "revert"
+		}
+		return true, fmt.Errorf(`could not %v %v... %v
+hint: After resolving the conflicts, mark them with
+hint: "git add/rm <pathspec>", then run
+hint: "git %v --continue".
+hint: You can instead skip this commit with "git %v --skip".
+hint: To abort and get back to the state before "git %v",
+hint: run "git %v --abort".`, verb, short, subject, command, command, command, command)
+	}
+	if s.opts.NoCommit {
+		return false, nil
+	}
+
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return true, err
+	}
+	tree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return true, err
+	}
+	// Recording the commit in the head file keeps the author of picked
+	// commits, and along with the message lets a commit which is empty,
+	// or which couldn't be made, be continued by committing it.
+	if err := c.GitDir.WriteFile(headFile, []byte(cmt.String()+"\n"), 0644); err != nil {
+		return true, err
+	}
+	if err := c.GitDir.WriteFile(mergeMsgFile, []byte(message), 0644); err != nil {
+		return true, err
+	}
+	if tree == headTree && !s.opts.AllowEmpty {
+		return true, fmt.Errorf(`The previous %v is now empty, possibly due to conflict resolution.
+If you wish to commit it anyway, use:
+
+    git commit --allow-empty
+
+Otherwise, please use 'git %v --skip'`, command, command)
+	}
+
+	copts := CommitOptions{AllowEmpty: true, NoEdit: true}
+	if s.opts.Edit {
+		if message, err = c.editCommitMessage(message); err != nil {
+			return true, err
+		}
+		copts.NoEdit = false
+	}
+	if _, err := Commit(c, copts, CommitMessage(message), nil); err != nil && err != NoGlobalConfig {
+		return true, err
+	}
+	c.clearMergeState()
+	return false, nil
+}
+
+// The change introduced by a commit, which has been merged into the index
+// and work tree by pickChange.
+type pickedChange struct {
+	// The message of the commit.
+	msg CommitMessage
+
+	// The parent which the change is relative to, which is the zero
+	// CommitID for a root commit, and whether the commit is a merge.
+	parent CommitID
+	merge  bool
+
+	// Set if the commit doesn't change anything relative to parent.
+	empty bool
+
+	// The tree of HEAD before the change was merged.
+	headTree TreeID
+
+	// The paths which had conflicts.
+	conflicts []IndexPath
+}
+
+// Merges the change introduced by cmt relative to its parent, or the
+// reverse of the change if revert is set, into the index and the work tree
+// with a three-way merge. Mainline chooses the parent of merge commits.
+//
+// Unless dirtyIndex is set, the index must match HEAD. Files in the work
+// tree which the change touches must not have local changes. Command is
+// the name of the command for error messages.
+func pickChange(c *Client, cmt CommitID, mainline int, revert, dirtyIndex bool, command string) (*pickedChange, error) {
+	msg, err := cmt.GetCommitMessage(c)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := cmt.Parents(c)
+	if err != nil {
+		return nil, err
+	}
+	var parent CommitID
+	switch {
+	case len(parents) > 1 && mainline == 0:
+		return nil, fmt.Errorf("commit %v is a merge but no -m option was given.", cmt)
+	case len(parents) > 1 && mainline > len(parents):
+		return nil, fmt.Errorf("commit %v does not have parent %d", cmt, mainline)
+	case len(parents) > 1:
+		parent = parents[mainline-1]
+	case mainline > 0:
+		return nil, fmt.Errorf("mainline was specified but commit %v is not a merge.", cmt)
+	case len(parents) == 1:
+		parent = parents[0]
+	}
+
+	cmtTree, err := cmt.TreeID(c)
+	if err != nil {
+		return nil, err
+	}
+	var parentTree TreeID
+	if len(parents) == 0 {
+		// A root commit is applied as the difference from an empty
+		// tree.
+		empty, err := c.WriteObject("tree", nil)
+		if err != nil {
+			return nil, err
+		}
+		parentTree = TreeID(empty)
+	} else if parentTree, err = parent.TreeID(c); err != nil {
+		return nil, err
+	}
+
+	label := fmt.Sprintf("%v (%v)", cmt.String()[:7], msg.Subject())
+	base, theirs := parentTree, cmtTree
+	if revert {
+		base, theirs = cmtTree, parentTree
+		label = "parent of " + label
+	}
+
+	// Make sure there are no local changes which would be lost.
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	if len(idx.GetUnmerged()) > 0 {
+		return nil, fmt.Errorf("%v failed: you need to resolve your current index first", command)
+	}
+	ours, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return nil, err
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := head.TreeID(c)
+	if err != nil {
+		return nil, err
+	}
+	if !dirtyIndex && ours != headTree {
+		return nil, fmt.Errorf("your local changes would be overwritten by %v.\nhint: commit your changes or stash them to proceed.", command)
+	}
+	if err := checkSequencerWorktree(c, base, theirs, command); err != nil {
+		return nil, err
+	}
+
+	newidx, err := ReadTreeThreeWay(c, ReadTreeOptions{Merge: true, Update: true}, base, ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+	conflicts, err := resolveMerge(c, newidx, "HEAD", label, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := readtreeSaveIndex(c, ReadTreeOptions{}, newidx); err != nil {
+		return nil, err
+	}
+	return &pickedChange{
+		msg:       msg,
+		parent:    parent,
+		merge:     len(parents) > 1,
+		empty:     cmtTree == parentTree,
+		headTree:  headTree,
+		conflicts: conflicts,
+	}, nil
+}
+
+// Lets the user edit message in their editor, and returns the result.
+func (c *Client) editCommitMessage(message string) (string, error) {
+	if err := c.GitDir.WriteFile("COMMIT_EDITMSG", []byte(message), 0644); err != nil {
+		return "", err
+	}
+	if err := c.ExecEditor(c.GitDir.File("COMMIT_EDITMSG")); err != nil {
+		return "", err
+	}
+	edited, err := c.GitDir.ReadFile("COMMIT_EDITMSG")
+	return string(edited), err
+}
+
+// Returns an error if any file which is changed between the trees base and
+// theirs has unstaged changes in the work tree, which applying the change
+// would overwrite.
+func checkSequencerWorktree(c *Client, base, theirs TreeID, command string) error {
+	baseMap, err := GetIndexMap(c, base)
+	if err != nil {
+		return err
+	}
+	theirsMap, err := GetIndexMap(c, theirs)
+	if err != nil {
+		return err
+	}
+	dirty, err := DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirty {
+		b, inBase := baseMap[d.Name]
+		t, inTheirs := theirsMap[d.Name]
+		if inBase != inTheirs || (inBase && (b.Sha1 != t.Sha1 || b.Mode != t.Mode)) {
+			return fmt.Errorf("Your local changes to %v would be overwritten by %v.\nhint: commit your changes or stash them to proceed.", d.Name, command)
+		}
+	}
+	return nil
+}
+
+// Returns the commit message for applying item, whose original message
+// is msg.
+func (s *sequencer) message(c *Client, item todoItem, msg CommitMessage, parent CommitID, merge bool) (string, error) {
+	var message string
+	if item.action == revertAction {
+		message = fmt.Sprintf("Revert \"%v\"\n\nThis reverts commit %v", msg.Subject(), item.commit)
+		if merge {
+			message += fmt.Sprintf(", reversing\nchanges made to %v", parent)
+		}
+		message += ".\n"
+	} else {
+		message = strings.TrimRight(msg.String(), "\n") + "\n"
+		if s.opts.RecordOrigin {
+			message = addTrailer(message, fmt.Sprintf("(cherry picked from commit %v)", item.commit))
+		}
+	}
+	if s.opts.SignOff {
+		committer, err := c.GetCommitter(nil)
+		if err != nil {
+			return "", err
+		}
+		signoff := "Signed-off-by: " + committer.String()
+		if !strings.HasSuffix(message, "\n"+signoff+"\n") {
+			message = addTrailer(message, signoff)
+		}
+	}
+	return message, nil
+}
+
+var trailerRE = regexp.MustCompile(`^[A-Za-z0-9-]+: `)
+
+// Adds a trailer line to the end of message, separated from the body by a
+// blank line unless the message already ends with trailers.
+func addTrailer(message, trailer string) string {
+	paragraphs := strings.Split(strings.TrimRight(message, "\n"), "\n\n")
+	hasTrailers := len(paragraphs) > 1
+	if hasTrailers {
+		for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+			if !trailerRE.MatchString(line) && !strings.HasPrefix(line, "(cherry picked from commit ") {
+				hasTrailers = false
+				break
+			}
+		}
+	}
+	message = strings.TrimRight(message, "\n") + "\n"
+	if !hasTrailers {
+		message += "\n"
+	}
+	return message + trailer + "\n"
+}
+
+// Loads the state of the in progress sequence.
+func loadSequencer(c *Client) (*sequencer, error) {
+	if !c.IsSequencing() {
+		return nil, fmt.Errorf("no cherry-pick or revert in progress")
+	}
+	s := &sequencer{}
+	var err error
+	if s.head, err = c.readHeadFile(sequencerDir + "/head"); err != nil {
+		return nil, err
+	}
+	if s.abortSafety, err = c.readHeadFile(sequencerDir + "/abort-safety"); err != nil {
+		return nil, err
+	}
+
+	todo, err := c.GitDir.ReadFile(sequencerDir + "/todo")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(todo), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var item todoItem
+		switch fields[0] {
+		case "pick", "p":
+			item.action = pickAction
+		case "revert":
+			item.action = revertAction
+		default:
+			return nil, fmt.Errorf("invalid line in sequencer todo: %v", line)
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid line in sequencer todo: %v", line)
+		}
+		if item.commit, err = CommitIDFromString(fields[1]); err != nil {
+			return nil, err
+		}
+		s.todo = append(s.todo, item)
+	}
+
+	opts, err := LoadConfigFile(c.GitDir.File(sequencerDir + "/opts").String())
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range opts.Values() {
+		if v.NoValue {
+			v.Value = "true"
+		}
+		switch v.Name {
+		case "options.edit":
+			s.opts.Edit, err = parseConfigBool(v.Value)
+		case "options.no-commit":
+			s.opts.NoCommit, err = parseConfigBool(v.Value)
+		case "options.signoff":
+			s.opts.SignOff, err = parseConfigBool(v.Value)
+		case "options.record-origin":
+			s.opts.RecordOrigin, err = parseConfigBool(v.Value)
+		case "options.allow-empty":
+			s.opts.AllowEmpty, err = parseConfigBool(v.Value)
+		case "options.mainline":
+			s.opts.Mainline, err = strconv.Atoi(v.Value)
+		case "options.strategy":
+			s.opts.Strategy = v.Value
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %v: %v", v.Name, v.Value)
+		}
+	}
+	return s, nil
+}
+
+// Saves the state of the sequence, so that it can be continued later.
+func (s *sequencer) save(c *Client) error {
+	if err := os.MkdirAll(c.GitDir.File(sequencerDir).String(), 0755); err != nil {
+		return err
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	s.abortSafety = head
+	if err := c.GitDir.WriteFile(sequencerDir+"/head", []byte(s.head.String()+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := c.GitDir.WriteFile(sequencerDir+"/abort-safety", []byte(head.String()+"\n"), 0644); err != nil {
+		return err
+	}
+
+	var todo strings.Builder
+	for _, item := range s.todo {
+		var subject string
+		if msg, err := item.commit.GetCommitMessage(c); err == nil {
+			subject = msg.Subject()
+		}
+		fmt.Fprintf(&todo, "%v %v %v\n", item.action, item.commit, subject)
+	}
+	if err := c.GitDir.WriteFile(sequencerDir+"/todo", []byte(todo.String()), 0644); err != nil {
+		return err
+	}
+
+	optsFile := c.GitDir.File(sequencerDir + "/opts").String()
+	os.Remove(optsFile)
+	opts, err := LoadConfigFile(optsFile)
+	if err != nil {
+		return err
+	}
+	for name, set := range map[string]bool{
+		"edit":          s.opts.Edit,
+		"no-commit":     s.opts.NoCommit,
+		"signoff":       s.opts.SignOff,
+		"record-origin": s.opts.RecordOrigin,
+		"allow-empty":   s.opts.AllowEmpty,
+	} {
+		if set {
+			opts.SetConfig("options."+name, "true")
+		}
+	}
+	if s.opts.Mainline > 0 {
+		opts.SetConfig("options.mainline", strconv.Itoa(s.opts.Mainline))
+	}
+	if s.opts.Strategy != "" {
+		opts.SetConfig("options.strategy", s.opts.Strategy)
+	}
+	return opts.WriteConfig()
+}
+
+// Removes the state of the sequence.
+func (s *sequencer) remove(c *Client) error {
+	return os.RemoveAll(c.GitDir.File(sequencerDir).String())
+}
+
+// Continues the in progress sequence, committing the commit that the
+// sequencer stopped at if it hasn't been committed yet.
+func sequencerContinue(c *Client) error {
+	s, err := loadSequencer(c)
+	if err != nil {
+		return err
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	pickHead, pickErr := c.GetPickHead()
+	revertHead, revertErr := c.GetRevertHead()
+	switch {
+	case pickErr == nil || revertErr == nil:
+		// The commit was applied with conflicts or was empty, and
+		// hasn't been committed yet.
+		msg, err := c.GitDir.ReadFile(mergeMsgFile)
+		if err != nil {
+			return err
+		}
+		cmt := pickHead
+		if pickErr != nil {
+			cmt = revertHead
+		}
+		copts := CommitOptions{
+			NoEdit:      !s.opts.Edit,
+			CleanupMode: "strip",
+			AllowEmpty:  s.opts.AllowEmpty,
+		}
+		if _, err := Commit(c, copts, CommitMessage(msg), nil); err != nil && err != NoGlobalConfig {
+			return fmt.Errorf("could not commit %v: %v", cmt.String()[:7], err)
+		}
+	case c.GitDir.File(mergeMsgFile).Exists():
+		// The commit was applied with conflicts, but the NoCommit
+		// option means it should only be staged.
+		idx, err := c.GitDir.ReadIndex()
+		if err != nil {
+			return err
+		}
+		if len(idx.GetUnmerged()) > 0 {
+			return fmt.Errorf("Committing is not possible because you have unmerged files.")
+		}
+		os.Remove(c.GitDir.File(mergeMsgFile).String())
+	case head == s.abortSafety:
+		// The sequencer stopped before applying the commit, so it
+		// needs to be tried again.
+		return s.run(c, false)
+	}
+	if len(s.todo) > 0 {
+		s.todo = s.todo[1:]
+	}
+	return s.run(c, false)
+}
+
+// Skips the commit that the sequencer stopped at, resetting any changes
+// that were made by it, and continues the sequence.
+func sequencerSkip(c *Client) error {
+	s, err := loadSequencer(c)
+	if err != nil {
+		return err
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	stopped := c.GitDir.File(cherryPickHeadFile).Exists() ||
+		c.GitDir.File(revertHeadFile).Exists() ||
+		c.GitDir.File(mergeMsgFile).Exists()
+	if !stopped && head != s.abortSafety {
+		command := pickAction
+		if len(s.todo) > 0 {
+			command = sequencerCommand(s.todo[0].action)
+		}
+		return fmt.Errorf("have you committed already?\nhint: try \"git %v --continue\"", command)
+	}
+	if err := resetMerge(c, head); err != nil {
+		return err
+	}
+	c.clearMergeState()
+	if len(s.todo) > 0 {
+		s.todo = s.todo[1:]
+	}
+	return s.run(c, false)
+}
+
+// Aborts the in progress sequence, returning HEAD, the index and the work
+// tree to the state before it started.
+func sequencerAbort(c *Client) error {
+	s, err := loadSequencer(c)
+	if err != nil {
+		return err
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	c.clearMergeState()
+	if head != s.abortSafety {
+		s.remove(c)
+		return fmt.Errorf("You seem to have moved HEAD. Not rewinding, check your HEAD!")
+	}
+	if err := resetMerge(c, s.head); err != nil {
+		return err
+	}
+	if head != s.head {
+		if err := UpdateRef(c, UpdateRefOptions{OldValue: head, CreateReflog: true}, "HEAD", s.head, "reset: moving to "+s.head.String()+" (dgit)"); err != nil {
+			return err
+		}
+	}
+	return s.remove(c)
+}
+
+// Forgets about the in progress sequence, leaving the index and work tree
+// as they are.
+func sequencerQuit(c *Client) error {
+	os.Remove(c.GitDir.File(cherryPickHeadFile).String())
+	os.Remove(c.GitDir.File(revertHeadFile).String())
+	return os.RemoveAll(c.GitDir.File(sequencerDir).String())
+}
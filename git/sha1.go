@@ -108,13 +108,16 @@ func (id Sha1) Type(c *Client) string {
 	t, _, err := c.GetObjectMetadata(id)
 	if err != nil {
 		panic(err)
-		return ""
 	}
 	return t
 }
 
-// Returns all direct parents of commit c.
+// Returns all direct parents of commit c. If the commit is in the
+// commit-graph, the commit object isn't read.
 func (cmt CommitID) Parents(c *Client) ([]CommitID, error) {
+	if g, i, ok := c.graphCommit(cmt); ok {
+		return g.parents(i)
+	}
 	obj, err := c.GetObject(Sha1(cmt))
 	if err != nil {
 		return nil, err
@@ -144,6 +147,13 @@ func (child CommitID) IsAncestor(c *Client, parent Commitish) bool {
 	if err != nil {
 		return false
 	}
+	if g := c.commitGraph(); g != nil {
+		// The commit-graph's generation numbers let us avoid
+		// walking most of the history.
+		if reachable, ok, err := g.reachable(child, p); ok && err == nil {
+			return reachable
+		}
+	}
 
 	ancestorMap, err := p.AncestorMap(c)
 	if err != nil {
@@ -729,6 +739,9 @@ func (t TreeID) GetAllObjectsExcept(cl *Client, excludeList map[Sha1]struct{}, p
 }
 
 func (c CommitID) TreeID(cl *Client) (TreeID, error) {
+	if g, i, ok := cl.graphCommit(c); ok {
+		return g.tree(i), nil
+	}
 	obj, err := cl.GetCommitObject(c)
 	if err != nil {
 		return TreeID{}, err
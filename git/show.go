@@ -2,6 +2,8 @@ package git
 
 import (
 	"fmt"
+	"os"
+	"sort"
 	"strings"
 )
 
@@ -55,12 +57,70 @@ func Show(c *Client, opts ShowOptions, objects []string) error {
 		if err != nil {
 			return err
 		}
+		if opts.Stat && opts.Patch && strings.HasSuffix(output, "\n\n") {
+			// The diffstat is separated from the message by a
+			// "---" line when it's followed by a patch, like an
+			// email.
+			output = output[:len(output)-1] + "---\n"
+		}
+		colors, err := opts.diffColors(c, os.Stdout)
+		if err != nil {
+			return err
+		}
+		if colors.commit != "" && strings.HasPrefix(output, "commit ") {
+			nl := strings.IndexByte(output, '\n')
+			output = colors.commit + output[:nl] + colors.reset + output[nl:]
+		}
 		fmt.Printf("%v", output)
+
+		if opts.showsDiffs() {
+			diffs, err := showCommitDiffs(c, opts.DiffCommonOptions, commit)
+			if err != nil {
+				return err
+			}
+			if err := GeneratePatch(c, opts.DiffCommonOptions, diffs, nil); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
+// Returns the diffs that were introduced by commit. Merge commits don't
+// have any diffs shown, since combined diffs aren't implemented.
+func showCommitDiffs(c *Client, opts DiffCommonOptions, commit CommitID) ([]HashDiff, error) {
+	parents, err := commit.Parents(c)
+	if err != nil {
+		return nil, err
+	}
+	switch len(parents) {
+	case 0:
+		// It's a root commit, so everything was added.
+		tree, err := commit.TreeID(c)
+		if err != nil {
+			return nil, err
+		}
+		objects, err := tree.GetAllObjects(c, "", true, true)
+		if err != nil {
+			return nil, err
+		}
+		var diffs []HashDiff
+		for name, entry := range objects {
+			if entry.FileMode == ModeTree {
+				continue
+			}
+			diffs = append(diffs, HashDiff{Name: name, Dst: entry})
+		}
+		sort.Sort(ByName(diffs))
+		return diffs, nil
+	case 1:
+		return DiffTree(c, &DiffTreeOptions{DiffCommonOptions: opts, Recurse: true}, parents[0], commit, nil)
+	default:
+		return nil, nil
+	}
+}
+
 func formatCommitMedium(cmt CommitID, c *Client) (string, error) {
 	author, err := cmt.GetAuthor(c)
 	if err != nil {
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strings"
 )
 
@@ -109,7 +108,7 @@ func ShowRef(c *Client, opts ShowRefOptions, patterns []string) ([]Ref, error) {
 	if opts.Verify {
 		// If verify is specified, everything must be an exact match
 		for _, ref := range patterns {
-			if f := c.GitDir.File(File(ref)); !f.Exists() {
+			if !RefSpec(ref).Exists(c) {
 				return nil, fmt.Errorf("fatal: '%v' - not a valid ref", ref)
 			}
 			r, err := parseRef(c, ref)
@@ -138,129 +137,51 @@ func ShowRef(c *Client, opts ShowRefOptions, patterns []string) ([]Ref, error) {
 			vals = append(vals, Ref{"HEAD", Sha1(hcid)})
 		}
 	}
-	// FIXME: Include packed refs
-	if !opts.Heads && !opts.Tags {
-		err := filepath.Walk(c.GitDir.File("refs").String(),
-			func(path string, info os.FileInfo, err error) error {
-				if info.IsDir() {
-					return nil
-				}
-				refname := strings.TrimPrefix(path, c.GitDir.String())
-				ref, err := parseRef(c, refname)
-				if err != nil && err != InvalidCommit {
-					// Invalid commit can just mean we don't
-					// have a local copy of the commit, so
-					// we don't care for the purpose of show-ref
-					return err
-				}
-				if len(patterns) == 0 {
-
-					vals = append(vals, ref)
-					deref, err := getDeref(c, opts, ref)
-					if err != nil {
-						return err
-					}
-					if deref != nil {
-						vals = append(vals, *deref)
-					}
-					return nil
-				}
-				for _, p := range patterns {
-					if ref.Matches(p) {
-						vals = append(vals, ref)
-						deref, err := getDeref(c, opts, ref)
-						if err != nil {
-							return err
-						}
-						if deref != nil {
-							vals = append(vals, *deref)
-						}
-						return nil
-					}
-				}
-				return nil
-			},
-		)
-		if err != nil {
-			return nil, err
-		}
-		return vals, nil
-	}
+	var prefixes []string
 	if opts.Heads {
-		heads, err := ioutil.ReadDir(c.GitDir.File("refs/heads").String())
+		prefixes = append(prefixes, "refs/heads/")
+	}
+	if opts.Tags {
+		prefixes = append(prefixes, "refs/tags/")
+	}
+	if len(prefixes) == 0 {
+		prefixes = []string{"refs/"}
+	}
+	for _, prefix := range prefixes {
+		names, err := c.refNames(prefix)
 		if err != nil {
 			return nil, err
 		}
-		for _, ref := range heads {
-			refname := "refs/heads/" + ref.Name()
+		for _, refname := range names {
 			ref, err := parseRef(c, refname)
-			if err != nil {
+			if err != nil && err != InvalidCommit {
+				// Invalid commit can just mean we don't
+				// have a local copy of the commit, so
+				// we don't care for the purpose of show-ref
 				return nil, err
 			}
-			if len(patterns) == 0 {
-				vals = append(vals, ref)
-				deref, err := getDeref(c, opts, ref)
-				if err != nil {
-					return nil, err
-				}
-				if deref != nil {
-					vals = append(vals, *deref)
-				}
-				continue
-			}
-			for _, p := range patterns {
-				if ref.Matches(p) {
-					vals = append(vals, ref)
-					deref, err := getDeref(c, opts, ref)
-					if err != nil {
-						return nil, err
-					}
-					if deref != nil {
-						vals = append(vals, *deref)
+			if len(patterns) > 0 {
+				matched := false
+				for _, p := range patterns {
+					if ref.Matches(p) {
+						matched = true
+						break
 					}
-					break
+				}
+				if !matched {
+					continue
 				}
 			}
-		}
-	}
-	if opts.Tags {
-		tags, err := ioutil.ReadDir(c.GitDir.File("refs/tags").String())
-		if err != nil {
-			return nil, err
-		}
-		for _, ref := range tags {
-			refname := "refs/tags/" + ref.Name()
-			ref, err := parseRef(c, refname)
+			vals = append(vals, ref)
+			deref, err := getDeref(c, opts, ref)
 			if err != nil {
 				return nil, err
 			}
-			if len(patterns) == 0 {
-				vals = append(vals, ref)
-				deref, err := getDeref(c, opts, ref)
-				if err != nil {
-					return nil, err
-				}
-				if deref != nil {
-					vals = append(vals, *deref)
-				}
-				continue
-			}
-			for _, p := range patterns {
-				if ref.Matches(p) {
-					vals = append(vals, ref)
-					deref, err := getDeref(c, opts, ref)
-					if err != nil {
-						return nil, err
-					}
-					if deref != nil {
-						vals = append(vals, *deref)
-					}
-					break
-				}
+			if deref != nil {
+				vals = append(vals, *deref)
 			}
 		}
 	}
-
 	return vals, nil
 }
 
@@ -268,7 +189,20 @@ func parseRef(c *Client, filename string) (Ref, error) {
 	refname := strings.TrimPrefix(filename, "/")
 	data, err := ioutil.ReadFile(c.GitDir.File(File(refname)).String())
 	if err != nil {
-		return Ref{}, err
+		if !os.IsNotExist(err) {
+			return Ref{}, err
+		}
+		packed, ok, perr := c.getPackedRef(refname)
+		if perr != nil {
+			return Ref{}, perr
+		}
+		if !ok {
+			return Ref{}, err
+		}
+		if _, err := c.GetObject(packed.Value); err != nil {
+			return packed.Ref, InvalidCommit
+		}
+		return packed.Ref, nil
 	}
 	if strings.HasPrefix(string(data), "ref: ") {
 		deref, err := SymbolicRefGet(c, SymbolicRefOptions{}, SymbolicRef(refname))
@@ -298,6 +232,11 @@ func getDeref(c *Client, opts ShowRefOptions, ref Ref) (*Ref, error) {
 		return nil, nil
 	}
 	if ref.Value.Type(c) == "tag" {
+		// Use the peeled value from packed-refs if there is one,
+		// so that we don't need to parse the tag object.
+		if packed, ok, err := c.getPackedRef(ref.Name); err == nil && ok && packed.Peeled != (Sha1{}) && packed.Value == ref.Value {
+			return &Ref{ref.Name + "^{}", packed.Peeled}, nil
+		}
 		deref, err := RevParse(c, RevParseOptions{}, []string{ref.Name + "^0"})
 		if err != nil {
 			return nil, err
@@ -19,6 +19,9 @@ type sshConn struct {
 	// name of the remote upload pack command
 	uploadpack string
 
+	// name of the remote receive pack command
+	receivepack string
+
 	session *ssh.Session
 
 	stdin  io.Reader
@@ -62,12 +65,17 @@ func (s *sshConn) OpenConn() error {
 	session.Stderr = os.Stderr
 	s.stdin, session.Stdout = io.Pipe()
 	session.Stdin, s.stdout = io.Pipe()
-	// We don't check error on setenv because if it failed we'll just fall
-	// back on protocol v1
-	session.Setenv("GIT_PROTOCOL", "version=2")
 	s.session = session
 
-	if err := session.Start(s.uploadpack + " " + s.uri.Path); err != nil {
+	command := s.uploadpack
+	if s.service == receivePackService {
+		command = s.receivepack
+	} else {
+		// We don't check error on setenv because if it failed we'll just fall
+		// back on protocol v1
+		session.Setenv("GIT_PROTOCOL", "version=2")
+	}
+	if err := session.Start(command + " " + s.uri.Path); err != nil {
 		return err
 	}
 
@@ -130,6 +138,18 @@ func (s *sshConn) SetUploadPack(up string) error {
 	return nil
 }
 
+func (s *sshConn) SetReceivePack(rp string) error {
+	if s.session != nil {
+		return fmt.Errorf("Must call SetReceivePack before opening connection")
+	}
+	s.receivepack = rp
+	return nil
+}
+
+func (s sshConn) RawWriter() io.Writer {
+	return s.stdout
+}
+
 func (s sshConn) Flush() error {
 	fmt.Fprintf(s.stdout, "0000")
 	return nil
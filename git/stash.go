@@ -0,0 +1,677 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The ref whose reflog holds the stash entries, newest last.
+const stashRef = "refs/stash"
+
+// ErrStashConflicts is returned when applying a stash results in conflicts
+// which need to be resolved by hand.
+var ErrStashConflicts = errors.New("Conflicts while applying stash")
+
+// Options for "git stash push".
+type StashPushOptions struct {
+	Quiet bool
+
+	// The message to use instead of "WIP on <branch>: <commit>".
+	Message string
+
+	// Leave the changes which are in the index in place.
+	KeepIndex bool
+
+	// Stash untracked files, and with All ignored files too.
+	IncludeUntracked, All bool
+}
+
+// Options for "git stash apply", "pop" and "branch".
+type StashApplyOptions struct {
+	Quiet bool
+
+	// Restore the changes that were in the index as well as the working
+	// tree.
+	Index bool
+}
+
+type StashDropOptions struct {
+	Quiet bool
+}
+
+type StashShowOptions struct {
+	DiffCommonOptions
+
+	// Include the untracked files in the stash in the diff, or only
+	// show them.
+	IncludeUntracked, OnlyUntracked bool
+}
+
+// A StashEntry is a single entry in the stash list.
+type StashEntry struct {
+	// The name of the entry, in the form stash@{n}.
+	Name    string
+	Commit  CommitID
+	Message string
+}
+
+// A stashInfo is a stash entry, resolved to the commits that it's made of.
+type stashInfo struct {
+	// The name the stash was referred to by, for messages.
+	revision string
+
+	// The position of the entry in the refs/stash reflog, or -1 if the
+	// stash wasn't named by a stash reference.
+	n int
+
+	// The working tree commit, and the HEAD it was based on.
+	w, b CommitID
+
+	// The trees of the working tree, base, index and untracked files
+	// commits. uTree is empty if there were no untracked files stashed.
+	wTree, bTree, iTree, uTree TreeID
+}
+
+// Resolves the stash entry named by name, which may be a number, a reflog
+// selector such as stash@{1}, or any stash-like commit. If name is empty,
+// the newest entry is used.
+func resolveStash(c *Client, name string) (*stashInfo, error) {
+	if name == "" {
+		entries, err := readReflog(c, stashRef)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("No stash entries found.")
+		}
+		name = "0"
+	}
+	s := &stashInfo{revision: name, n: -1}
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 {
+		s.revision = fmt.Sprintf("%v@{%d}", stashRef, n)
+	}
+	base, sel := s.revision, "0"
+	if pos := strings.Index(s.revision, "@{"); pos >= 0 && strings.HasSuffix(s.revision, "}") {
+		base, sel = s.revision[:pos], s.revision[pos+2:len(s.revision)-1]
+	}
+	if n, err := strconv.Atoi(sel); err == nil && n >= 0 && dwimRef(c, base) == stashRef {
+		entries, err := readReflog(c, stashRef)
+		if err != nil {
+			return nil, err
+		}
+		if n >= len(entries) {
+			return nil, fmt.Errorf("log for '%v' only has %d entries", stashRef, len(entries))
+		}
+		s.n = n
+	}
+
+	w, err := RevParseCommit(c, &RevParseOptions{}, s.revision)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := w.Parents(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(parents) != 2 && len(parents) != 3 {
+		return nil, fmt.Errorf("'%v' is not a stash-like commit", s.revision)
+	}
+	s.w, s.b = w, parents[0]
+	if s.wTree, err = w.TreeID(c); err != nil {
+		return nil, err
+	}
+	if s.bTree, err = parents[0].TreeID(c); err != nil {
+		return nil, err
+	}
+	if s.iTree, err = parents[1].TreeID(c); err != nil {
+		return nil, err
+	}
+	if len(parents) == 3 {
+		if s.uTree, err = parents[2].TreeID(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// StashPush implements "git stash push". It saves the local changes to
+// files (or to every file, if files is empty) in a new stash entry, and
+// then reverts them.
+//
+// The stash entry is a commit of the working tree whose parents are HEAD,
+// a commit of the index and, if untracked files were included, a commit of
+// the untracked files.
+func StashPush(c *Client, opts StashPushOptions, files []File) error {
+	if err := refreshIndex(c); err != nil {
+		return err
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return err
+	}
+	if len(idx.GetUnmerged()) > 0 {
+		return fmt.Errorf("Cannot save the current index state")
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return fmt.Errorf("You do not have the initial commit yet")
+	}
+
+	var untracked []File
+	if opts.IncludeUntracked || opts.All {
+		others, err := LsFiles(c, LsFilesOptions{Others: true, ExcludeStandard: !opts.All}, files)
+		if err != nil {
+			return err
+		}
+		for _, o := range others {
+			f, err := o.PathName.FilePath(c)
+			if err != nil {
+				return err
+			}
+			untracked = append(untracked, f)
+		}
+	}
+	if err := checkStashPathspec(c, idx, files, untracked); err != nil {
+		return err
+	}
+
+	staged, err := DiffIndex(c, DiffIndexOptions{Cached: true}, idx, head, files)
+	if err != nil {
+		return err
+	}
+	unstaged, err := DiffFiles(c, DiffFilesOptions{}, files)
+	if err != nil {
+		return err
+	}
+	if len(staged) == 0 && len(unstaged) == 0 && len(untracked) == 0 {
+		if !opts.Quiet {
+			fmt.Println("No local changes to save")
+		}
+		return nil
+	}
+
+	// The index tree has everything in the index, but the working tree
+	// tree only has the unstaged changes to files.
+	iTree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return err
+	}
+	widx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return err
+	}
+	for _, d := range unstaged {
+		f, err := d.Name.FilePath(c)
+		if err != nil {
+			return err
+		}
+		if !f.Exists() {
+			widx.RemoveFile(d.Name)
+			continue
+		}
+		if err := widx.AddFile(c, f, UpdateIndexOptions{Add: true, Replace: true}); err != nil {
+			return err
+		}
+	}
+	wTree, err := WriteTreeFromIndex(c, widx, WriteTreeOptions{})
+	if err != nil {
+		return err
+	}
+
+	branch := "(no branch)"
+	if b := c.GetHeadBranch(); b != "" {
+		branch = b.BranchName()
+	}
+	headMsg, err := head.GetCommitMessage(c)
+	if err != nil {
+		return err
+	}
+	desc := fmt.Sprintf("%v: %v %v", branch, head.String()[:7], headMsg.Subject())
+	msg := "WIP on " + desc
+	if opts.Message != "" {
+		msg = fmt.Sprintf("On %v: %v", branch, opts.Message)
+	}
+
+	icmt, err := CommitTree(c, CommitTreeOptions{}, iTree, []CommitID{head}, "index on "+desc+"\n")
+	if err != nil {
+		return err
+	}
+	parents := []CommitID{head, icmt}
+	if len(untracked) > 0 {
+		uidx := NewIndex()
+		for _, f := range untracked {
+			if err := uidx.AddFile(c, f, UpdateIndexOptions{Add: true}); err != nil {
+				return err
+			}
+		}
+		uTree, err := WriteTreeFromIndex(c, uidx, WriteTreeOptions{})
+		if err != nil {
+			return err
+		}
+		ucmt, err := CommitTree(c, CommitTreeOptions{}, uTree, nil, "untracked files on "+desc+"\n")
+		if err != nil {
+			return err
+		}
+		parents = append(parents, ucmt)
+	}
+	wcmt, err := CommitTree(c, CommitTreeOptions{}, wTree, parents, msg)
+	if err != nil {
+		return err
+	}
+	if err := UpdateRef(c, UpdateRefOptions{CreateReflog: true}, stashRef, wcmt, msg); err != nil {
+		return err
+	}
+	if !opts.Quiet {
+		fmt.Printf("Saved working directory and index state %v\n", msg)
+	}
+
+	// Put the stashed files back the way they are in HEAD, or in the
+	// index if it's being kept.
+	target, err := GetIndexMap(c, head)
+	if opts.KeepIndex {
+		target, err = GetIndexMap(c, iTree)
+	}
+	if err != nil {
+		return err
+	}
+	changed := make(map[IndexPath]bool)
+	for _, d := range staged {
+		changed[d.Name] = true
+	}
+	for _, d := range unstaged {
+		changed[d.Name] = true
+	}
+	restored := make(IndexMap)
+	for path := range changed {
+		if e, ok := target[path]; ok {
+			restored[path] = e
+			continue
+		}
+		idx.RemoveFile(path)
+		f, err := path.FilePath(c)
+		if err != nil {
+			return err
+		}
+		if f.Exists() {
+			if err := removeFileClean(f); err != nil {
+				return err
+			}
+		}
+	}
+	if err := resetIndexEntries(c, idx, restored, true); err != nil {
+		return err
+	}
+	for _, entry := range idx.Objects {
+		if _, ok := restored[entry.PathName]; !ok {
+			continue
+		}
+		if err := checkoutFile(c, entry, CheckoutIndexOptions{Force: true, UpdateStat: true}); err != nil {
+			return err
+		}
+	}
+	if err := readtreeSaveIndex(c, ReadTreeOptions{}, idx); err != nil {
+		return err
+	}
+	for _, f := range untracked {
+		if err := removeFileClean(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks that every pathspec given to stash push matches a file in the
+// index, or an untracked file which is being stashed.
+func checkStashPathspec(c *Client, idx *Index, files, untracked []File) error {
+	imap := idx.GetMap()
+outer:
+	for _, f := range files {
+		path, err := f.IndexPath(c)
+		if err != nil {
+			return err
+		}
+		if path == "" || imap.Contains(path) {
+			continue
+		}
+		for _, u := range untracked {
+			upath, err := u.IndexPath(c)
+			if err != nil {
+				return err
+			}
+			if upath == path || strings.HasPrefix(string(upath), string(path)+"/") {
+				continue outer
+			}
+		}
+		return fmt.Errorf("error: pathspec '%v' did not match any file(s) known to git\nDid you forget to 'git add'?", f)
+	}
+	return nil
+}
+
+// Changes the entries of idx to the ones in entries, without touching the
+// working tree. If keepOthers is set, entries in idx which aren't in
+// entries are left alone, otherwise they're removed.
+func resetIndexEntries(c *Client, idx *Index, entries IndexMap, keepOthers bool) error {
+	current := idx.GetMap()
+	if !keepOthers {
+		for path := range current {
+			if _, ok := entries[path]; !ok {
+				idx.RemoveFile(path)
+			}
+		}
+	}
+	for path, e := range entries {
+		if cur, ok := current[path]; ok {
+			if cur.Sha1 == e.Sha1 && cur.Mode == e.Mode {
+				continue
+			}
+			idx.RemoveFile(path)
+		}
+		if err := idx.AddStage(c, path, e.Mode, e.Sha1, Stage0, e.Fsize, 0, UpdateIndexOptions{Add: true, Replace: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StashApply implements "git stash apply". It applies the changes in the
+// stash entry named by stash, or the newest entry if stash is empty, to
+// the working tree.
+func StashApply(c *Client, opts StashApplyOptions, stash string) error {
+	s, err := resolveStash(c, stash)
+	if err != nil {
+		return err
+	}
+	return s.apply(c, opts)
+}
+
+func (s *stashInfo) apply(c *Client, opts StashApplyOptions) error {
+	if err := refreshIndex(c); err != nil {
+		return err
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return err
+	}
+	if len(idx.GetUnmerged()) > 0 {
+		return fmt.Errorf("Cannot apply a stash in the middle of a merge")
+	}
+	cTree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return err
+	}
+
+	// The stashed index is only restored if it had changes which aren't
+	// already in the current index.
+	var indexEntries IndexMap
+	if opts.Index && s.bTree != s.iTree && cTree != s.iTree {
+		if indexEntries, err = mergeStashIndex(c, s.bTree, cTree, s.iTree); err != nil {
+			return err
+		}
+	}
+	if err := checkSequencerWorktree(c, s.bTree, s.wTree, "merge"); err != nil {
+		return err
+	}
+	if s.uTree != (TreeID{}) {
+		if err := restoreUntracked(c, s.uTree); err != nil {
+			return err
+		}
+	}
+
+	oursLabel := "Updated upstream"
+	if s.bTree == cTree {
+		oursLabel = "Version stash was based on"
+	}
+	if s.wTree == s.bTree && !opts.Quiet {
+		fmt.Println("Already up to date.")
+	}
+	newidx, err := ReadTreeThreeWay(c, ReadTreeOptions{Merge: true, Update: true}, s.bTree, cTree, s.wTree)
+	if err != nil {
+		return err
+	}
+	conflicts, err := resolveMerge(c, newidx, oursLabel, "Stashed changes", opts.Quiet)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		// With --index the stashed index is restored, otherwise
+		// everything except new files is unstaged again.
+		if indexEntries != nil {
+			err = resetIndexEntries(c, newidx, indexEntries, false)
+		} else {
+			cMap, err2 := GetIndexMap(c, cTree)
+			if err2 != nil {
+				return err2
+			}
+			err = resetIndexEntries(c, newidx, cMap, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := readtreeSaveIndex(c, ReadTreeOptions{}, newidx); err != nil {
+		return err
+	}
+	if len(conflicts) > 0 && opts.Index {
+		fmt.Fprintln(os.Stderr, "Index was not unstashed.")
+	}
+	if !opts.Quiet {
+		status, err := Status(c, StatusOptions{Long: true, UntrackedMode: StatusUntrackedNormal}, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Print(status)
+	}
+	if len(conflicts) > 0 {
+		return ErrStashConflicts
+	}
+	return nil
+}
+
+// Applies the changes between the trees base and theirs to the tree ours
+// for "stash apply --index", and returns the resulting index entries. Any
+// file which was changed differently in ours and theirs is a conflict.
+func mergeStashIndex(c *Client, base, ours, theirs TreeID) (IndexMap, error) {
+	baseMap, err := GetIndexMap(c, base)
+	if err != nil {
+		return nil, err
+	}
+	oursMap, err := GetIndexMap(c, ours)
+	if err != nil {
+		return nil, err
+	}
+	theirsMap, err := GetIndexMap(c, theirs)
+	if err != nil {
+		return nil, err
+	}
+	same := func(a, b *IndexEntry) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.Sha1 == b.Sha1 && a.Mode == b.Mode
+	}
+
+	result := make(IndexMap)
+	for path, e := range oursMap {
+		result[path] = e
+	}
+	paths := make(map[IndexPath]bool)
+	for path := range baseMap {
+		paths[path] = true
+	}
+	for path := range theirsMap {
+		paths[path] = true
+	}
+	for path := range paths {
+		b, t, o := baseMap[path], theirsMap[path], oursMap[path]
+		if same(b, t) || same(t, o) {
+			continue
+		}
+		if !same(b, o) {
+			return nil, fmt.Errorf("Conflicts in index. Try without --index.")
+		}
+		if t == nil {
+			delete(result, path)
+		} else {
+			result[path] = t
+		}
+	}
+	return result, nil
+}
+
+// Writes the untracked files from the stash's untracked tree to the
+// working tree, without adding them to the index. Nothing is written if
+// any of them already exist.
+func restoreUntracked(c *Client, tree TreeID) error {
+	entries, err := expandGitTreeIntoIndexes(c, tree, true, false, false)
+	if err != nil {
+		return err
+	}
+	exists := false
+	for _, e := range entries {
+		f, err := e.PathName.FilePath(c)
+		if err != nil {
+			return err
+		}
+		if f.Exists() {
+			fmt.Fprintf(os.Stderr, "%v already exists, no checkout\n", e.PathName)
+			exists = true
+		}
+	}
+	if exists {
+		return fmt.Errorf("could not restore untracked files from stash")
+	}
+	for _, e := range entries {
+		if err := checkoutFile(c, e, CheckoutIndexOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StashPop implements "git stash pop". It applies the stash entry like
+// StashApply, and drops it if it applied without conflicts.
+func StashPop(c *Client, opts StashApplyOptions, stash string) error {
+	s, err := resolveStash(c, stash)
+	if err != nil {
+		return err
+	}
+	if s.n < 0 {
+		return fmt.Errorf("'%v' is not a stash reference", s.revision)
+	}
+	if err := s.apply(c, opts); err != nil {
+		if err == ErrStashConflicts {
+			fmt.Println("The stash entry is kept in case you need it again.")
+		}
+		return err
+	}
+	return s.drop(c, opts.Quiet)
+}
+
+// StashDrop implements "git stash drop". It removes the stash entry named
+// by stash, or the newest entry if stash is empty, from the stash list.
+func StashDrop(c *Client, opts StashDropOptions, stash string) error {
+	s, err := resolveStash(c, stash)
+	if err != nil {
+		return err
+	}
+	if s.n < 0 {
+		return fmt.Errorf("'%v' is not a stash reference", s.revision)
+	}
+	return s.drop(c, opts.Quiet)
+}
+
+func (s *stashInfo) drop(c *Client, quiet bool) error {
+	if err := deleteReflogEntry(c, stashRef, s.n); err != nil {
+		return err
+	}
+	if !quiet {
+		fmt.Printf("Dropped %v (%v)\n", s.revision, s.w)
+	}
+	return nil
+}
+
+// StashClear implements "git stash clear". It removes every stash entry.
+func StashClear(c *Client) error {
+	if !RefSpec(stashRef).Exists(c) {
+		return nil
+	}
+	return UpdateRef(c, UpdateRefOptions{Delete: true, NoDeref: true}, stashRef, CommitID{}, "")
+}
+
+// StashList returns the stash entries, newest first.
+func StashList(c *Client) ([]StashEntry, error) {
+	entries, err := readReflog(c, stashRef)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]StashEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		list = append(list, StashEntry{
+			Name:    fmt.Sprintf("stash@{%d}", len(list)),
+			Commit:  CommitID(entries[i].New),
+			Message: entries[i].Message,
+		})
+	}
+	return list, nil
+}
+
+// StashShow implements "git stash show". It returns the diffs between the
+// commit that the stash entry was based on and the stashed working tree.
+func StashShow(c *Client, opts StashShowOptions, stash string) ([]HashDiff, error) {
+	s, err := resolveStash(c, stash)
+	if err != nil {
+		return nil, err
+	}
+	from, to := s.bTree, s.wTree
+	switch {
+	case opts.OnlyUntracked:
+		if s.uTree == (TreeID{}) {
+			return nil, nil
+		}
+		empty, err := c.WriteObject("tree", nil)
+		if err != nil {
+			return nil, err
+		}
+		from, to = TreeID(empty), s.uTree
+	case opts.IncludeUntracked && s.uTree != (TreeID{}):
+		idx := NewIndex()
+		if err := idx.ResetIndex(c, s.wTree); err != nil {
+			return nil, err
+		}
+		untracked, err := expandGitTreeIntoIndexes(c, s.uTree, true, false, false)
+		if err != nil {
+			return nil, err
+		}
+		idx.Objects = append(idx.Objects, untracked...)
+		sort.Sort(ByPath(idx.Objects))
+		if to, err = WriteTreeFromIndex(c, idx, WriteTreeOptions{}); err != nil {
+			return nil, err
+		}
+	}
+	return DiffTree(c, &DiffTreeOptions{DiffCommonOptions: opts.DiffCommonOptions, Recurse: true}, from, to, nil)
+}
+
+// StashBranch implements "git stash branch". It creates and checks out a
+// new branch at the commit the stash entry was based on, applies the stash
+// with its index, and drops it if it applied cleanly.
+func StashBranch(c *Client, opts StashApplyOptions, branch, stash string) error {
+	s, err := resolveStash(c, stash)
+	if err != nil {
+		return err
+	}
+	if err := Checkout(c, CheckoutOptions{Quiet: opts.Quiet, Branch: branch}, s.b.String(), nil); err != nil {
+		return err
+	}
+	opts.Index = true
+	if err := s.apply(c, opts); err != nil {
+		return err
+	}
+	if s.n < 0 {
+		return nil
+	}
+	return s.drop(c, opts.Quiet)
+}
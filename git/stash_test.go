@@ -0,0 +1,193 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitstash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	write := func(file, content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add := func(files ...File) {
+		t.Helper()
+		if _, err := Add(c, AddOptions{}, files); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectStaged := func(want ...IndexPath) {
+		t.Helper()
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		diffs, err := DiffIndex(c, DiffIndexOptions{Cached: true}, nil, head, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []IndexPath
+		for _, d := range diffs {
+			got = append(got, d.Name)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("Unexpected staged files: got %v want %v", got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("Unexpected staged files: got %v want %v", got, want)
+			}
+		}
+	}
+	expectEntries := func(n int) {
+		t.Helper()
+		list, err := StashList(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != n {
+			t.Errorf("Unexpected number of stash entries: got %v want %v", len(list), n)
+		}
+	}
+
+	write("foo.txt", "foo\n")
+	write("bar.txt", "bar\n")
+	add("foo.txt", "bar.txt")
+	if _, err := Commit(c, CommitOptions{}, CommitMessage("base"), nil); err != nil {
+		t.Fatal(err)
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to stash shouldn't create an entry.
+	if err := StashPush(c, StashPushOptions{Quiet: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(0)
+
+	// Stash a staged change, an unstaged change on top of it, a new
+	// file and an untracked file.
+	write("foo.txt", "foo\nstaged\n")
+	write("new.txt", "new\n")
+	add("foo.txt", "new.txt")
+	write("foo.txt", "foo\nstaged\nunstaged\n")
+	write("bar.txt", "bar\nunstaged\n")
+	write("untracked.txt", "untracked\n")
+	if err := StashPush(c, StashPushOptions{Quiet: true, IncludeUntracked: true, Message: "test"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(1)
+	expectContent(t, "foo.txt", "foo\n")
+	expectContent(t, "bar.txt", "bar\n")
+	for _, f := range []string{"new.txt", "untracked.txt"} {
+		if _, err := os.Stat(f); err == nil {
+			t.Errorf("%v left after stash", f)
+		}
+	}
+	expectStaged()
+
+	list, err := StashList(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list[0].Name != "stash@{0}" || list[0].Message != "On master: test" {
+		t.Errorf("Unexpected stash entry: %v", list[0])
+	}
+	parents, err := list[0].Commit.Parents(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 3 || parents[0] != head {
+		t.Errorf("Unexpected stash parents: %v", parents)
+	}
+
+	// Applying with --index should restore everything as it was.
+	if err := StashApply(c, StashApplyOptions{Quiet: true, Index: true}, ""); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, "foo.txt", "foo\nstaged\nunstaged\n")
+	expectContent(t, "bar.txt", "bar\nunstaged\n")
+	expectContent(t, "new.txt", "new\n")
+	expectContent(t, "untracked.txt", "untracked\n")
+	expectStaged("foo.txt", "new.txt")
+	expectEntries(1)
+
+	// --keep-index should leave the staged changes alone.
+	if err := StashPush(c, StashPushOptions{Quiet: true, KeepIndex: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(2)
+	expectContent(t, "foo.txt", "foo\nstaged\n")
+	expectContent(t, "bar.txt", "bar\n")
+	expectStaged("foo.txt", "new.txt")
+	if err := ResetMode(c, ResetOptions{Hard: true}, head); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("untracked.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Popping without --index only leaves new files staged.
+	if err := StashPop(c, StashApplyOptions{Quiet: true}, "1"); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(1)
+	expectContent(t, "foo.txt", "foo\nstaged\nunstaged\n")
+	expectContent(t, "untracked.txt", "untracked\n")
+	expectStaged("new.txt")
+
+	// Only the files in the pathspec are stashed from the working tree.
+	if err := StashPush(c, StashPushOptions{Quiet: true}, []File{"bar.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(2)
+	expectContent(t, "foo.txt", "foo\nstaged\nunstaged\n")
+	expectContent(t, "bar.txt", "bar\n")
+	if err := StashPush(c, StashPushOptions{Quiet: true}, []File{"nomatch.txt"}); err == nil {
+		t.Error("Was able to stash a pathspec that didn't match anything")
+	}
+
+	// A stash that conflicts is kept when popping.
+	write("bar.txt", "bar\nconflict\n")
+	add("bar.txt")
+	if err := StashPop(c, StashApplyOptions{Quiet: true}, ""); err != ErrStashConflicts {
+		t.Errorf("Unexpected error popping a conflicting stash: %v", err)
+	}
+	expectEntries(2)
+
+	if err := StashDrop(c, StashDropOptions{Quiet: true}, "stash@{5}"); err == nil {
+		t.Error("Was able to drop a stash entry that doesn't exist")
+	}
+	if err := StashDrop(c, StashDropOptions{Quiet: true}, head.String()); err == nil {
+		t.Error("Was able to drop a commit that isn't a stash")
+	}
+	if err := StashDrop(c, StashDropOptions{Quiet: true}, ""); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(1)
+	if list, err := StashList(c); err != nil || list[0].Message != "WIP on master: "+head.String()[:7]+" base" {
+		t.Errorf("Unexpected stash list after drop: %v, %v", list, err)
+	}
+	if err := StashClear(c); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(0)
+}
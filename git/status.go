@@ -3,6 +3,8 @@ package git
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 )
 
 type StatusUntrackedMode uint8
@@ -22,6 +24,22 @@ const (
 	StatusIgnoreSubmodulesAll
 )
 
+// StatusIgnoredMode describes how ignored files are shown by git status
+// --ignored.
+type StatusIgnoredMode uint8
+
+const (
+	// Don't show ignored files.
+	StatusIgnoredNo = StatusIgnoredMode(iota)
+
+	// Show ignored files and directories. A directory whose untracked
+	// files are all ignored is shown instead of its contents.
+	StatusIgnoredTraditional
+
+	// Show ignored files and directories which match an ignore pattern.
+	StatusIgnoredMatching
+)
+
 type StatusColumnOptions string
 
 type StatusOptions struct {
@@ -31,7 +49,7 @@ type StatusOptions struct {
 	Porcelain uint8
 	Long      bool
 	Verbose   bool
-	Ignored   bool
+	Ignored   StatusIgnoredMode
 
 	NullTerminate bool
 
@@ -67,12 +85,15 @@ func Status(c *Client, opts StatusOptions, files []File) (string, error) {
 	if err := refreshIndex(c); err != nil {
 		return "", err
 	}
-	if opts.Porcelain > 1 || opts.ShowStash || opts.Verbose || opts.Ignored || (opts.Column != "default" && opts.Column != "") {
+	if opts.Porcelain > 2 || opts.Verbose || (opts.Column != "default" && opts.Column != "") {
 		return "", fmt.Errorf("Unsupported option for Status")
 	}
 	if opts.Column == "" {
 		opts.Column = "column"
 	}
+	if opts.Porcelain == 2 {
+		return StatusPorcelainV2(c, opts, files)
+	}
 	var ret string
 	if opts.Branch || opts.Long {
 		branch, err := StatusBranch(c, opts, "")
@@ -89,17 +110,30 @@ func Status(c *Client, opts StatusOptions, files []File) (string, error) {
 		if opts.NullTerminate {
 			lineending = "\000"
 		}
-		status, err := StatusShort(c, files, opts.UntrackedMode, "", lineending)
+		status, err := statusShort(c, files, opts.UntrackedMode, opts.Ignored, "", lineending)
 		if err != nil {
 			return "", err
 		}
 		ret += status
 	} else if opts.Long {
-		status, err := StatusLong(c, files, opts.UntrackedMode, "")
+		status, err := statusLong(c, files, opts.UntrackedMode, opts.Ignored, "")
 		if err != nil {
 			return "", err
 		}
 		ret += status
+		if opts.ShowStash {
+			stash, err := readReflog(c, "refs/stash")
+			if err != nil {
+				return "", err
+			}
+			switch len(stash) {
+			case 0:
+			case 1:
+				ret += "Your stash currently has 1 entry\n"
+			default:
+				ret += fmt.Sprintf("Your stash currently has %d entries\n", len(stash))
+			}
+		}
 	}
 	return ret, nil
 }
@@ -140,6 +174,10 @@ func StatusBranch(c *Client, opts StatusOptions, lineprefix string) (string, err
 
 // Return a string of the status
 func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, lineprefix string) (string, error) {
+	return statusLong(c, files, untracked, StatusIgnoredNo, lineprefix)
+}
+
+func statusLong(c *Client, files []File, untracked StatusUntrackedMode, ignored StatusIgnoredMode, lineprefix string) (string, error) {
 	// If no head commit: "no changes yet", else branch info
 	// Changes to be committed: dgit diff-index --cached HEAD
 	// Unmerged: git ls-files -u
@@ -205,7 +243,7 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 		}
 	} else {
 		hasCommit = true
-		staged, err = DiffIndex(c, DiffIndexOptions{Cached: true}, index, head, files)
+		staged, err = DiffIndex(c, DiffIndexOptions{DiffCommonOptions: statusRenameOptions(c), Cached: true}, index, head, files)
 		if err != nil {
 			return "", err
 		}
@@ -227,7 +265,17 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 				continue
 			}
 
-			if f.Src == (TreeEntry{}) {
+			if f.SrcName != "" {
+				src, err := f.SrcName.FilePath(c)
+				if err != nil {
+					return "", err
+				}
+				verb := "renamed"
+				if f.Copied {
+					verb = "copied"
+				}
+				stagedMsg += fmt.Sprintf("%v\t%v:\t%v -> %v\n", lineprefix, verb, src, fname)
+			} else if f.Src == (TreeEntry{}) {
 				stagedMsg += fmt.Sprintf("%v\tnew file:\t%v\n", lineprefix, fname)
 			} else if f.Dst == (TreeEntry{}) {
 				stagedMsg += fmt.Sprintf("%v\tdeleted:\t%v\n", lineprefix, fname)
@@ -347,36 +395,36 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 
 	hasUntracked := false
 	if untracked != StatusUntrackedNo {
-		lsfilesopts := LsFilesOptions{
-			Others:          true,
-			ExcludeStandard: true, // Configurable some day
-		}
-		if untracked == StatusUntrackedNormal {
-			lsfilesopts.Directory = true
-		}
-
-		untracked, err := LsFiles(c, lsfilesopts, lsfiles)
-		if len(untracked) > 0 {
-			hasUntracked = true
-		}
+		untrackedFiles, ignoredFiles, err := statusOthers(c, lsfiles, untracked, ignored)
 		if err != nil {
 			return "", err
 		}
-		if len(untracked) > 0 {
+		if len(untrackedFiles) > 0 {
+			hasUntracked = true
 			ret += fmt.Sprintf("%vUntracked files:\n", lineprefix)
 			ret += fmt.Sprintf("%v  (use \"git add <file>...\" to include in what will be committed)\n", lineprefix)
 			ret += fmt.Sprintf("%v\n", lineprefix)
 
-			for _, f := range untracked {
-				fname, err := f.PathName.FilePath(c)
+			for _, f := range untrackedFiles {
+				fname, err := statusPath(c, f, false)
 				if err != nil {
 					return "", err
 				}
-				if fname.IsDir() {
-					ret += fmt.Sprintf("%v\t%v/\n", lineprefix, fname)
-				} else {
-					ret += fmt.Sprintf("%v\t%v\n", lineprefix, fname)
+				ret += fmt.Sprintf("%v\t%v\n", lineprefix, fname)
+			}
+			ret += fmt.Sprintf("%v\n", lineprefix)
+		}
+		if len(ignoredFiles) > 0 {
+			ret += fmt.Sprintf("%vIgnored files:\n", lineprefix)
+			ret += fmt.Sprintf("%v  (use \"git add -f <file>...\" to include in what will be committed)\n", lineprefix)
+			ret += fmt.Sprintf("%v\n", lineprefix)
+
+			for _, f := range ignoredFiles {
+				fname, err := statusPath(c, f, false)
+				if err != nil {
+					return "", err
 				}
+				ret += fmt.Sprintf("%v\t%v\n", lineprefix, fname)
 			}
 			ret += fmt.Sprintf("%v\n", lineprefix)
 		}
@@ -415,6 +463,216 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 
 // Implements git status --short
 func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, lineprefix, lineending string) (string, error) {
+	return statusShort(c, files, untracked, StatusIgnoredNo, lineprefix, lineending)
+}
+
+func statusShort(c *Client, files []File, untracked StatusUntrackedMode, ignored StatusIgnoredMode, lineprefix, lineending string) (string, error) {
+	entries, err := statusEntries(c, files)
+	if err != nil {
+		return "", err
+	}
+	var ret string
+	for _, e := range entries {
+		fname, err := statusPath(c, e.Path, false)
+		if err != nil {
+			return "", err
+		}
+		x, y := e.X, e.Y
+		if x == '.' {
+			x = ' '
+		}
+		if y == '.' {
+			y = ' '
+		}
+		if e.OrigPath != "" {
+			orig, err := statusPath(c, e.OrigPath, false)
+			if err != nil {
+				return "", err
+			}
+			fname = orig + " -> " + fname
+		}
+		ret += fmt.Sprintf("%v%c%c %v%v", lineprefix, x, y, fname, lineending)
+	}
+	if untracked != StatusUntrackedNo {
+		untrackedFiles, ignoredFiles, err := statusOthers(c, files, untracked, ignored)
+		if err != nil {
+			return "", err
+		}
+		for _, f := range untrackedFiles {
+			fname, err := statusPath(c, f, false)
+			if err != nil {
+				return "", err
+			}
+			ret += lineprefix + "?? " + fname + lineending
+		}
+		for _, f := range ignoredFiles {
+			fname, err := statusPath(c, f, false)
+			if err != nil {
+				return "", err
+			}
+			ret += lineprefix + "!! " + fname + lineending
+		}
+	}
+	return ret, nil
+}
+
+// Implements git status --porcelain=v2. With NullTerminate, entries end
+// with a NUL instead of a newline and paths are relative to the top of
+// the work tree instead of the current directory.
+func StatusPorcelainV2(c *Client, opts StatusOptions, files []File) (string, error) {
+	lineending := "\n"
+	if opts.NullTerminate {
+		lineending = "\000"
+	}
+	var ret string
+	if opts.Branch {
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			ret += "# branch.oid (initial)" + lineending
+		} else {
+			ret += "# branch.oid " + head.String() + lineending
+		}
+		branch := c.GetHeadBranch()
+		if branch == "" {
+			ret += "# branch.head (detached)" + lineending
+		} else {
+			ret += "# branch.head " + branch.BranchName() + lineending
+			if upstream, err := upstreamRef(c, "", false); err == nil {
+				name := strings.TrimPrefix(string(upstream), "refs/remotes/")
+				name = strings.TrimPrefix(name, "refs/heads/")
+				ret += "# branch.upstream " + name + lineending
+
+				// If the upstream is gone or there are no commits
+				// yet, there's nothing to compare.
+				if up, err := RefSpec(upstream).CommitID(c); err == nil && head != (CommitID{}) {
+					ahead, behind, err := aheadBehind(c, head, up)
+					if err != nil {
+						return "", err
+					}
+					ret += fmt.Sprintf("# branch.ab +%d -%d%v", ahead, behind, lineending)
+				}
+			}
+		}
+	}
+	if opts.ShowStash {
+		stash, err := readReflog(c, "refs/stash")
+		if err != nil {
+			return "", err
+		}
+		if len(stash) > 0 {
+			ret += fmt.Sprintf("# stash %d%v", len(stash), lineending)
+		}
+	}
+
+	entries, err := statusEntries(c, files)
+	if err != nil {
+		return "", err
+	}
+	// Unmerged entries come after the other changes.
+	for _, e := range entries {
+		if e.Unmerged {
+			continue
+		}
+		fname, err := statusPath(c, e.Path, opts.NullTerminate)
+		if err != nil {
+			return "", err
+		}
+		if e.OrigPath != "" {
+			orig, err := statusPath(c, e.OrigPath, opts.NullTerminate)
+			if err != nil {
+				return "", err
+			}
+			// The paths are separated by a tab, or a NUL with -z.
+			sep := "\t"
+			if opts.NullTerminate {
+				sep = "\000"
+			}
+			ret += fmt.Sprintf("2 %c%c N... %06o %06o %06o %v %v %c%d %v%v%v%v",
+				e.X, e.Y,
+				e.Head.FileMode, e.Index.FileMode, e.WorktreeMode,
+				e.Head.Sha1, e.Index.Sha1,
+				e.X, e.Similarity,
+				fname, sep, orig, lineending,
+			)
+			continue
+		}
+		ret += fmt.Sprintf("1 %c%c N... %06o %06o %06o %v %v %v%v",
+			e.X, e.Y,
+			e.Head.FileMode, e.Index.FileMode, e.WorktreeMode,
+			e.Head.Sha1, e.Index.Sha1,
+			fname, lineending,
+		)
+	}
+	for _, e := range entries {
+		if !e.Unmerged {
+			continue
+		}
+		fname, err := statusPath(c, e.Path, opts.NullTerminate)
+		if err != nil {
+			return "", err
+		}
+		ret += fmt.Sprintf("u %c%c N... %06o %06o %06o %06o %v %v %v %v%v",
+			e.X, e.Y,
+			e.Stages[0].FileMode, e.Stages[1].FileMode, e.Stages[2].FileMode, e.WorktreeMode,
+			e.Stages[0].Sha1, e.Stages[1].Sha1, e.Stages[2].Sha1,
+			fname, lineending,
+		)
+	}
+
+	if opts.UntrackedMode != StatusUntrackedNo {
+		untrackedFiles, ignoredFiles, err := statusOthers(c, files, opts.UntrackedMode, opts.Ignored)
+		if err != nil {
+			return "", err
+		}
+		for _, f := range untrackedFiles {
+			fname, err := statusPath(c, f, opts.NullTerminate)
+			if err != nil {
+				return "", err
+			}
+			ret += "? " + fname + lineending
+		}
+		for _, f := range ignoredFiles {
+			fname, err := statusPath(c, f, opts.NullTerminate)
+			if err != nil {
+				return "", err
+			}
+			ret += "! " + fname + lineending
+		}
+	}
+	return ret, nil
+}
+
+// A statusEntry describes a tracked path which is different in HEAD, the
+// index or the work tree.
+type statusEntry struct {
+	Path IndexPath
+
+	// The status of the index compared to HEAD, and the work tree
+	// compared to the index, with '.' meaning unmodified, as in git
+	// status --porcelain=v2.
+	X, Y rune
+
+	// The path in HEAD and in the index. The zero TreeEntry is used if
+	// it's not there.
+	Head, Index TreeEntry
+
+	// If the path is unmerged, the entries in stage 1, 2 and 3 of the
+	// index instead of Index.
+	Unmerged bool
+	Stages   [3]TreeEntry
+
+	// The mode of the file in the work tree, or 0 if it was deleted.
+	WorktreeMode EntryMode
+
+	// If the path was renamed or copied in the index, the path in HEAD
+	// that it came from and how similar they are as a percentage.
+	OrigPath   IndexPath
+	Similarity int
+}
+
+// Returns the status of every changed path in files (or the whole work
+// tree if there are none), sorted by path.
+func statusEntries(c *Client, files []File) ([]statusEntry, error) {
 	var lsfiles []File
 	if len(files) == 0 {
 		lsfiles = []File{File(c.WorkDir)}
@@ -424,7 +682,7 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 
 	cfiles, err := LsFiles(c, LsFilesOptions{Cached: true}, lsfiles)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	tree := make(map[IndexPath]*IndexEntry)
 	// It's not an error to use "git status" before the first commit,
@@ -432,119 +690,329 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 	if head, err := c.GetHeadCommit(); err == nil {
 		i, err := LsTree(c, LsTreeOptions{FullTree: true, Recurse: true}, head, files)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-
-		// this should probably be an LsTreeMap library function, it would be
-		// useful other places..
 		for _, e := range i {
 			tree[e.PathName] = e
 		}
 	}
-	var ret string
-	var wtst, ist rune
-	for i, f := range cfiles {
-		wtst = ' '
-		ist = ' '
+	wtdiffs, err := DiffFiles(c, DiffFilesOptions{}, lsfiles)
+	if err != nil {
+		return nil, err
+	}
+	modified := make(map[IndexPath]bool)
+	for _, d := range wtdiffs {
+		modified[d.Name] = true
+	}
+	filemode := c.GetConfig("core.filemode") != "false"
+
+	var entries []statusEntry
+	inIndex := make(map[IndexPath]bool)
+	for i := 0; i < len(cfiles); i++ {
+		f := cfiles[i]
+		inIndex[f.PathName] = true
+		e := statusEntry{Path: f.PathName}
+		if h, ok := tree[f.PathName]; ok {
+			e.Head = TreeEntry{h.Sha1, h.Mode}
+		}
 		fname, err := f.PathName.FilePath(c)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		switch f.Stage() {
-		case Stage0:
-			if head, ok := tree[f.PathName]; !ok {
-				ist = 'A'
-			} else {
-				if head.Sha1 == f.Sha1 {
-					ist = ' '
-				} else {
-					ist = 'M'
-				}
-			}
+		e.WorktreeMode = worktreeMode(fname, f.Mode, filemode)
 
-			stat, err := fname.Stat()
-			if os.IsNotExist(err) {
-				wtst = 'D'
-			} else {
-				mtime, err := fname.MTime()
-				if err != nil {
-					return "", err
-				}
-				if mtime != f.Mtime || stat.Size() != int64(f.Fsize) {
-					wtst = 'M'
-				} else {
-					wtst = ' '
-				}
+		if f.Stage() != Stage0 {
+			e.Unmerged = true
+			for ; i < len(cfiles) && cfiles[i].PathName == f.PathName; i++ {
+				e.Stages[cfiles[i].Stage()-1] = TreeEntry{cfiles[i].Sha1, cfiles[i].Mode}
 			}
-			if ist != ' ' || wtst != ' ' {
-				ret += fmt.Sprintf("%c%c %v%v", ist, wtst, fname, lineending)
-			}
-		case Stage1:
-			switch cfiles[i+1].Stage() {
-			case Stage2:
-				if i >= len(cfiles)-2 {
-					// Stage3 is missing, we've reached the end of the index.
-					ret += fmt.Sprintf("MD %v%v", fname, lineending)
-					continue
-				}
-				switch cfiles[i+2].Stage() {
-				case Stage3:
-					// There's a stage1, stage2, and stage3. If they weren't all different, read-tree would
-					// have resolved it as a trivial stage0 merge.
-					ret += fmt.Sprintf("UU %v%v", fname, lineending)
-				default:
-					// Stage3 is missing, but we haven't reached the end of the index.
-					ret += fmt.Sprintf("MD%v%v", fname, lineending)
-				}
-				continue
-			case Stage3:
-				// Stage2 is missing
-				ret += fmt.Sprintf("DM %v%v", fname, lineending)
-				continue
-			default:
-				panic("Unhandled index")
-			}
-		case Stage2:
-			if i == 0 || cfiles[i-1].Stage() != Stage1 {
-				// If this is a Stage2, and the previous wasn't Stage1,
-				// then we know the next one must be Stage3 or read-tree
-				// would have handled it as a trivial merge.
-				ret += fmt.Sprintf("AA %v%v", fname, lineending)
-			}
-			// If the previous was Stage1, it was handled by the previous
-			// loop iteration.
+			i--
+			e.X, e.Y = unmergedStatus(e.Stages)
+			entries = append(entries, e)
 			continue
-		case Stage3:
-			// There can't be just a Stage3 or read-tree would
-			// have resolved it as Stage0. All cases were handled
-			// by Stage1 or Stage2
+		}
+
+		e.Index = TreeEntry{f.Sha1, f.Mode}
+		switch {
+		case e.Head == (TreeEntry{}):
+			e.X = 'A'
+		case !sameFileType(e.Head.FileMode, e.Index.FileMode):
+			e.X = 'T'
+		case e.Head != e.Index:
+			e.X = 'M'
+		default:
+			e.X = '.'
+		}
+		switch {
+		case e.WorktreeMode == 0:
+			e.Y = 'D'
+		case !sameFileType(e.Index.FileMode, e.WorktreeMode):
+			e.Y = 'T'
+		case modified[f.PathName] || e.Index.FileMode != e.WorktreeMode:
+			e.Y = 'M'
+		default:
+			e.Y = '.'
+		}
+		if e.X != '.' || e.Y != '.' {
+			entries = append(entries, e)
+		}
+	}
+	for path, h := range tree {
+		if !inIndex[path] {
+			entries = append(entries, statusEntry{
+				Path: path,
+				X:    'D',
+				Y:    '.',
+				Head: TreeEntry{h.Sha1, h.Mode},
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return statusRenames(c, entries)
+}
+
+// Returns the options for detecting renames in the index for status,
+// from the status.renames config, or diff.renames if it isn't set.
+func statusRenameOptions(c *Client) DiffCommonOptions {
+	renames := c.GetConfig("status.renames")
+	if renames == "" {
+		renames = c.GetConfig("diff.renames")
+	}
+	switch renames {
+	case "false", "no", "off", "0":
+		return DiffCommonOptions{}
+	case "copies", "copy":
+		return DiffCommonOptions{DetectRenames: true, DetectCopies: true}
+	default:
+		return DiffCommonOptions{DetectRenames: true}
+	}
+}
+
+// Replaces the entries for files which were added to the index and are
+// renames or copies of files in HEAD with an entry for the rename or
+// copy, and removes the entries for the files that were renamed.
+func statusRenames(c *Client, entries []statusEntry) ([]statusEntry, error) {
+	opts := statusRenameOptions(c)
+	if !opts.DetectRenames {
+		return entries, nil
+	}
+	var diffs []HashDiff
+	for _, e := range entries {
+		if e.Unmerged {
 			continue
 		}
+		switch e.X {
+		case 'A':
+			diffs = append(diffs, HashDiff{Name: e.Path, Dst: e.Index})
+		case 'D':
+			diffs = append(diffs, HashDiff{Name: e.Path, Src: e.Head})
+		case 'M', 'T':
+			diffs = append(diffs, HashDiff{Name: e.Path, Src: e.Head, Dst: e.Index})
+		}
 	}
-	if untracked != StatusUntrackedNo {
-		lsfilesopts := LsFilesOptions{
-			Others: true,
+	renamed, err := detectRenames(c, opts, diffs, nil)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[IndexPath]HashDiff)
+	remaining := make(map[IndexPath]bool)
+	for _, d := range renamed {
+		if d.SrcName != "" {
+			sources[d.Name] = d
+		} else {
+			remaining[d.Name] = true
 		}
-		if untracked == StatusUntrackedNormal {
-			lsfilesopts.Directory = true
+	}
+	var val []statusEntry
+	for _, e := range entries {
+		if d, ok := sources[e.Path]; ok && e.X == 'A' {
+			e.X = 'R'
+			if d.Copied {
+				e.X = 'C'
+			}
+			e.Head = d.Src
+			e.OrigPath = d.SrcName
+			e.Similarity = d.Similarity
+		} else if e.X == 'D' && !e.Unmerged && !remaining[e.Path] {
+			// It was renamed.
+			continue
+		}
+		val = append(val, e)
+	}
+	return val, nil
+}
+
+// Returns the mode of the file f in the work tree, or 0 if it doesn't
+// exist. If filemode is false, the executable bit isn't trusted and the
+// mode from the index is used for regular files.
+func worktreeMode(f File, index EntryMode, filemode bool) EntryMode {
+	stat, err := f.Lstat()
+	if err != nil {
+		return 0
+	}
+	switch {
+	case stat.IsDir():
+		if index == ModeCommit {
+			return ModeCommit
+		}
+		return 0
+	case stat.Mode()&os.ModeSymlink != 0:
+		return ModeSymlink
+	case !filemode && index.IsRegular():
+		return index
+	case stat.Mode().Perm()&0100 != 0:
+		return ModeExec
+	default:
+		return ModeBlob
+	}
+}
+
+// Returns true if a and b are both regular files or are the same type of
+// entry, so that a change between them isn't a type change.
+func sameFileType(a, b EntryMode) bool {
+	return a == b || (a.IsRegular() && b.IsRegular())
+}
+
+// Returns the short status of an unmerged path, given its entries in the
+// stages 1 to 3 of the index.
+func unmergedStatus(stages [3]TreeEntry) (x, y rune) {
+	base, ours, theirs := stages[0] != (TreeEntry{}), stages[1] != (TreeEntry{}), stages[2] != (TreeEntry{})
+	switch {
+	case base && ours && theirs:
+		return 'U', 'U'
+	case base && ours:
+		return 'U', 'D'
+	case base && theirs:
+		return 'D', 'U'
+	case base:
+		return 'D', 'D'
+	case ours && theirs:
+		return 'A', 'A'
+	case ours:
+		return 'A', 'U'
+	default:
+		return 'U', 'A'
+	}
+}
+
+// Returns the untracked files in files (or the whole work tree if there
+// are none) and, if ignored isn't StatusIgnoredNo, the ignored files. An
+// untracked or ignored directory is listed instead of its contents unless
+// untracked is StatusUntrackedAll, and has a trailing slash.
+func statusOthers(c *Client, files []File, untracked StatusUntrackedMode, ignored StatusIgnoredMode) (untrackedFiles, ignoredFiles []IndexPath, err error) {
+	var lsfiles []File
+	if len(files) == 0 {
+		lsfiles = []File{File(c.WorkDir)}
+	} else {
+		lsfiles = files
+	}
+	opts := LsFilesOptions{
+		Others:           true,
+		ExcludeStandard:  true, // Configurable some day
+		Directory:        untracked == StatusUntrackedNormal,
+		NoEmptyDirectory: true,
+	}
+	others, err := LsFiles(c, opts, lsfiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	isUntracked := make(map[IndexPath]bool)
+	for _, f := range others {
+		untrackedFiles = append(untrackedFiles, f.PathName)
+		isUntracked[f.PathName] = true
+	}
+	if ignored == StatusIgnoredNo {
+		return untrackedFiles, nil, nil
+	}
+
+	// Ignored directories are only shown as a directory if they match
+	// a pattern, or everything is shown in the traditional mode.
+	opts.Ignored = true
+	opts.Directory = ignored == StatusIgnoredMatching || untracked == StatusUntrackedNormal
+	ign, err := LsFiles(c, opts, lsfiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ignored == StatusIgnoredMatching || untracked == StatusUntrackedAll {
+		for _, f := range ign {
+			ignoredFiles = append(ignoredFiles, f.PathName)
 		}
+		return untrackedFiles, ignoredFiles, nil
+	}
 
-		untracked, err := LsFiles(c, lsfilesopts, lsfiles)
-		if err != nil {
-			return "", err
+	// In the traditional mode, an ignored file in an untracked directory
+	// which doesn't have anything else in it is shown as the highest
+	// such directory instead.
+	index, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	hasTracked := make(map[IndexPath]bool)
+	for _, entry := range index.Objects {
+		for i, ch := range entry.PathName {
+			if ch == '/' {
+				hasTracked[entry.PathName[:i+1]] = true
+			}
 		}
-		for _, f := range untracked {
-			fname, err := f.PathName.FilePath(c)
-			if err != nil {
-				return "", err
+	}
+	shown := make(map[IndexPath]bool)
+	for _, f := range ign {
+		name := f.PathName
+		for i := 0; i < len(name)-1; i++ {
+			if name[i] != '/' {
+				continue
 			}
-			if name := fname.String(); name == "." {
-				ret += "?? ./" + lineending
-			} else {
-				ret += "?? " + name + lineending
+			dir := name[:i+1]
+			if hasTracked[dir] {
+				continue
+			}
+			if !isUntracked[dir] {
+				name = dir
 			}
+			break
+		}
+		if !shown[name] {
+			shown[name] = true
+			ignoredFiles = append(ignoredFiles, name)
 		}
 	}
-	return ret, nil
+	return untrackedFiles, ignoredFiles, nil
+}
+
+// Returns the name to use for the path p in status output. Unless fromRoot
+// is set, it's relative to the current directory. A trailing slash,
+// marking a directory, is kept.
+func statusPath(c *Client, p IndexPath, fromRoot bool) (string, error) {
+	if fromRoot {
+		return p.String(), nil
+	}
+	dir := strings.HasSuffix(string(p), "/")
+	f, err := IndexPath(strings.TrimSuffix(string(p), "/")).FilePath(c)
+	if err != nil {
+		return "", err
+	}
+	name := f.String()
+	if !dir {
+		return name, nil
+	}
+	if name == "." {
+		return "./", nil
+	}
+	return name + "/", nil
+}
 
+// Returns the number of commits in head which aren't in upstream, and in
+// upstream which aren't in head.
+func aheadBehind(c *Client, head, upstream CommitID) (ahead, behind int, err error) {
+	a, err := RevList(c, RevListOptions{Quiet: true}, nil, []Commitish{head}, []Commitish{upstream})
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := RevList(c, RevListOptions{Quiet: true}, nil, []Commitish{upstream}, []Commitish{head})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(a), len(b), nil
 }
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -470,3 +472,142 @@ UU ../foo.txt
 		t.Error(err)
 	}
 }
+
+func TestStatusPorcelainV2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitstatusv2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) Sha1 {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sha, _, err := HashFile("blob", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+	write(".gitignore", "*.log\n")
+	bar := write("bar.txt", "bar\n")
+	foo := write("foo.txt", "foo\n")
+	if _, err := Add(c, AddOptions{}, []File{".gitignore", "bar.txt", "foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(c, CommitOptions{}, "Initial commit", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBranch("up", cmt); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("branch.master.remote", ".")
+	c.SetCachedConfig("branch.master.merge", "refs/heads/up")
+
+	// Stage a change to foo.txt and then change it again, delete
+	// bar.txt, and add some untracked and ignored files.
+	foo2 := write("foo.txt", "foo2\n")
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	write("foo.txt", "foo3\n")
+	if err := os.Remove("bar.txt"); err != nil {
+		t.Fatal(err)
+	}
+	write("new.txt", "new\n")
+	write("dir/new.txt", "new\n")
+	write("x.log", "log\n")
+	write("logs/a.log", "log\n")
+
+	changes := fmt.Sprintf(`1 .D N... 100644 100644 000000 %v %v bar.txt
+1 MM N... 100644 100644 100644 %v %v foo.txt
+`, bar, bar, foo, foo2)
+	tests := []struct {
+		name string
+		opts StatusOptions
+		want string
+	}{
+		{
+			"branch",
+			StatusOptions{Porcelain: 2, Branch: true, UntrackedMode: StatusUntrackedNormal},
+			`# branch.oid ` + cmt.String() + `
+# branch.head master
+# branch.upstream up
+# branch.ab +0 -0
+` + changes + `? dir/
+? new.txt
+`,
+		},
+		{
+			"ignored",
+			StatusOptions{Porcelain: 2, UntrackedMode: StatusUntrackedNormal, Ignored: StatusIgnoredTraditional},
+			changes + `? dir/
+? new.txt
+! logs/
+! x.log
+`,
+		},
+		{
+			"ignored matching",
+			StatusOptions{Porcelain: 2, UntrackedMode: StatusUntrackedAll, Ignored: StatusIgnoredMatching},
+			changes + `? dir/new.txt
+? new.txt
+! logs/a.log
+! x.log
+`,
+		},
+		{
+			"short ignored",
+			StatusOptions{Short: true, UntrackedMode: StatusUntrackedNormal, Ignored: StatusIgnoredTraditional},
+			` D bar.txt
+MM foo.txt
+?? dir/
+?? new.txt
+!! logs/
+!! x.log
+`,
+		},
+	}
+	for _, tc := range tests {
+		got, err := Status(c, tc.opts, nil)
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%v: got `%v` want `%v`", tc.name, got, tc.want)
+		}
+	}
+
+	// Paths are relative to the current directory, unless -z is used.
+	if err := os.Chdir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Status(c, StatusOptions{Porcelain: 2, UntrackedMode: StatusUntrackedNo}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(changes, " bar.txt", " ../bar.txt", 1); got != strings.Replace(want, " foo.txt", " ../foo.txt", 1) {
+		t.Errorf("Unexpected status in subdirectory: got `%v`", got)
+	}
+	got, err = Status(c, StatusOptions{Porcelain: 2, NullTerminate: true, UntrackedMode: StatusUntrackedNo}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(changes, "\n", "\000", -1); got != want {
+		t.Errorf("Unexpected status with -z: got `%q` want `%q`", got, want)
+	}
+}
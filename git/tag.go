@@ -2,8 +2,6 @@ package git
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -31,25 +29,14 @@ func TagList(c *Client, opts TagOptions, patterns []string) ([]string, error) {
 		return nil, fmt.Errorf("Tag list with patterns not implemented")
 	}
 
-	files := []string{}
-
-	err := filepath.Walk(filepath.Join(c.GitDir.String(), "refs", "tags"),
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() {
-				files = append(files, path)
-			}
-			return nil
-		})
+	names, err := c.refNames("refs/tags/")
 	if err != nil {
 		return nil, err
 	}
 
 	var tags []string
-	for _, f := range files {
-		tags = append(tags, f[len(c.GitDir.String())+len("/refs/tags/"):])
+	for _, name := range names {
+		tags = append(tags, strings.TrimPrefix(name, "refs/tags/"))
 	}
 	sort.Slice(tags, func(i, j int) bool {
 		if opts.IgnoreCase {
@@ -76,7 +63,7 @@ func TagCommit(c *Client, opts TagOptions, tagname string, cmt Commitish, msg st
 		}
 		comm = cmmt
 	}
-	if refspec.File(c).Exists() && !opts.Force {
+	if refspec.Exists(c) && !opts.Force {
 		return fmt.Errorf("tag '%v' already exists", tagname)
 	}
 	if opts.Annotated {
@@ -122,8 +109,7 @@ func TagDelete(c *Client, opts TagOptions, tagnames []Refname) error {
 		if !strings.HasPrefix(tag.Name, "refs/tags") {
 			return fmt.Errorf("Invalid tag: %v", tag.Name)
 		}
-		file := c.GitDir.File(File(tag.Name))
-		if err := os.Remove(file.String()); err != nil {
+		if err := c.deleteRef(tag.Name); err != nil {
 			return err
 		}
 	}
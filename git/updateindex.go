@@ -226,6 +226,18 @@ func UpdateIndexRefresh(c *Client, idx *Index, opts UpdateIndexOptions) (*Index,
 			// Nothing to refresh
 			continue
 		}
+		if entry.CompareStat(f) != nil {
+			// Only refresh the stat info if the content didn't
+			// change, otherwise the modification would be hidden
+			// from anything that trusts the stat info.
+			hash, _, err := HashFile("blob", f.String())
+			if err != nil {
+				return nil, err
+			}
+			if hash != entry.Sha1 {
+				continue
+			}
+		}
 		if err := entry.RefreshStat(c); err != nil {
 			return nil, err
 		}
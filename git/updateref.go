@@ -1,8 +1,10 @@
 package git
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -14,8 +16,12 @@ type UpdateRefOptions struct {
 	CreateReflog bool
 	OldValue     Commitish
 
-	// Not implemented
-	Stdin         io.Reader
+	// Read commands from Stdin and apply them in a transaction,
+	// instead of updating a single ref.
+	Stdin io.Reader
+
+	// Commands on Stdin are NUL terminated instead of newline
+	// terminated.
 	NullTerminate bool
 }
 
@@ -53,6 +59,9 @@ func updateReflog(c *Client, create bool, file File, oldvalue, newvalue Commitis
 		}
 
 	}
+	// A reflog entry must be a single line, so collapse any whitespace
+	// in the message like git does.
+	reason = strings.Join(strings.Fields(reason), " ")
 	if reason == "" {
 		toAppend = fmt.Sprintf("%s %s %s\n", oldsha, newsha, commiter)
 	} else {
@@ -64,107 +73,277 @@ func updateReflog(c *Client, create bool, file File, oldvalue, newvalue Commitis
 // Safely updates ref to point to cmt under the client c, logging reason in the reflog.
 // If opts.OldValue is set, it will return an error if the current value is not OldValue.
 func UpdateRefSpec(c *Client, opts UpdateRefOptions, ref RefSpec, cmt CommitID, reason string) error {
+	return updateRef(c, opts, ref.String(), cmt, reason)
+}
+
+// Handles "git update-ref" command line. ref is what's passed on the command-line
+// it can be either a symbolic ref, or a refspec. We just use a string, because
+// Go doesn't support sum types.
+func UpdateRef(c *Client, opts UpdateRefOptions, ref string, cmt CommitID, reason string) error {
+	if opts.Stdin != nil {
+		return updateRefStdin(c, opts, reason)
+	}
+	return updateRef(c, opts, strings.TrimSpace(ref), cmt, reason)
+}
+
+// Updates or deletes a single ref in its own transaction.
+func updateRef(c *Client, opts UpdateRefOptions, ref string, cmt CommitID, reason string) error {
+	var oldval *Sha1
 	if opts.OldValue != nil {
-		oldval, err := opts.OldValue.CommitID(c)
+		old, err := opts.OldValue.CommitID(c)
 		if err != nil {
 			return err
 		}
-		curval, err := ref.CommitID(c)
-		if err != nil && oldval != (CommitID{}) {
-			return err
-		}
-		if curval != oldval {
-			return fmt.Errorf("%s is not equal to %s (is %s)", ref, oldval, curval)
-		}
+		oldsha := Sha1(old)
+		oldval = &oldsha
 	}
-	if opts.Delete {
-		return fmt.Errorf("Delete RefSpec not implemented")
+	uopts := RefUpdateOptions{
+		NoDeref:      opts.NoDeref,
+		CreateReflog: opts.CreateReflog,
+		Reason:       reason,
 	}
 
-	// The RefSpec Stringer method strips out trailing newlines and junk.
-	filename := File(ref.String())
-	if err := updateReflog(c, opts.CreateReflog, File(c.GitDir)+"/logs/"+filename, opts.OldValue, cmt, reason); err != nil {
-		return err
+	t := NewRefTransaction(c)
+	var err error
+	if opts.Delete {
+		if oldval != nil && *oldval == (Sha1{}) {
+			oldval = nil
+		}
+		err = t.Delete(ref, oldval, uopts)
+	} else {
+		err = t.Update(ref, Sha1(cmt), oldval, uopts)
 	}
-
-	file, err := c.GitDir.Create(filename)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	fmt.Fprintf(file, "%s\n", cmt)
-	return nil
+	return t.Commit()
 }
 
-// Handles "git update-ref" command line. ref is what's passed on the command-line
-// it can be either a symbolic ref, or a refspec. We just use a string, because
-// Go doesn't support sum types.
-func UpdateRef(c *Client, opts UpdateRefOptions, ref string, cmt CommitID, reason string) error {
-	if opts.Stdin != nil {
-		return fmt.Errorf("UpdateRef batch mode not implemented")
+// Implements "git update-ref --stdin". Commands are read from opts.Stdin
+// and queued in a transaction, which is committed at the end of input
+// unless the input controls the transaction with start, prepare, commit
+// and abort.
+func updateRefStdin(c *Client, opts UpdateRefOptions, reason string) error {
+	r := bufio.NewReader(opts.Stdin)
+	sep := byte('\n')
+	if opts.NullTerminate {
+		sep = 0
 	}
-
-	if opts.Delete {
-		// FIXME: This is more of a hack to ensure that the fsck passes
-		// than a real implementation.
-		f := c.GitDir.File(File(ref))
-		if !f.Exists() {
-			return nil
+	readToken := func() (string, bool, error) {
+		tok, err := r.ReadString(sep)
+		switch {
+		case err == io.EOF && tok == "":
+			return "", false, nil
+		case err == io.EOF && opts.NullTerminate:
+			return "", false, fmt.Errorf("Unterminated command: %v", tok)
+		case err != nil && err != io.EOF:
+			return "", false, err
 		}
-		return f.Remove()
+		return strings.TrimSuffix(tok, string(sep)), true, nil
 	}
-
-	// It's not a symbolic ref, it's a real ref. Just directly call UpdateRefSpec
-	if strings.HasPrefix(ref, "refs/") {
-		return UpdateRefSpec(c, opts, RefSpec(strings.TrimSpace(ref)), cmt, reason)
+	parseValue := func(cmd, val string) (*Sha1, error) {
+		if val == "" {
+			return nil, nil
+		}
+		if val == strings.Repeat("0", 40) {
+			return &Sha1{}, nil
+		}
+		revs, err := RevParse(c, RevParseOptions{}, []string{val})
+		if err != nil || len(revs) != 1 {
+			return nil, fmt.Errorf("%v: invalid value %v", cmd, val)
+		}
+		return &revs[0].Id, nil
 	}
 
-	// It's a symbolic ref. Dereference it, unless --deref
-	if !opts.NoDeref {
-		refspec, err := SymbolicRefGet(c, SymbolicRefOptions{}, SymbolicRef(ref))
-		if err == DetachedHead {
-			goto noderef
-		}
+	const (
+		open = iota
+		started
+		prepared
+		closed
+	)
+	state := open
+	t := NewRefTransaction(c)
+	noDerefNext := false
+	for {
+		line, ok, err := readToken()
 		if err != nil {
+			t.Abort()
 			return err
 		}
-		// This is duplicated from UpdateRefSpec, but we should check
-		// the value before updating the reflog. If we're not doing
-		// anything, we shouldn't update the reflog. (It stays in
-		// UpdateRefSpec because someone else might call it directly.)
-		if opts.OldValue != nil {
-			curval, err := SymbolicRef(ref).CommitID(c)
-			if err != nil && opts.OldValue != (CommitID{}) {
-				return err
+		if !ok {
+			break
+		}
+		cmd, rest := line, ""
+		if sp := strings.IndexByte(line, ' '); sp >= 0 {
+			cmd, rest = line[:sp], line[sp+1:]
+		}
+
+		switch cmd {
+		case "start", "prepare", "commit", "abort":
+			if rest != "" {
+				t.Abort()
+				return fmt.Errorf("%v: extra input: %v", cmd, rest)
+			}
+			switch {
+			case cmd == "start" && state == closed:
+				t = NewRefTransaction(c)
+			case cmd == "start" && state != open:
+				err = fmt.Errorf("start: transaction already started")
+			case cmd == "prepare" && state != open && state != started:
+				err = fmt.Errorf("prepare: transaction already prepared or closed")
+			case cmd == "prepare":
+				err = t.Prepare()
+			case cmd == "commit" && state == closed:
+				err = fmt.Errorf("commit: transaction is closed")
+			case cmd == "commit":
+				err = t.Commit()
+			case cmd == "abort":
+				err = t.Abort()
 			}
-			oldval, err := opts.OldValue.CommitID(c)
 			if err != nil {
+				t.Abort()
 				return err
 			}
-			if curval != oldval {
-				return fmt.Errorf("%s is not equal to %s (is %s)", ref, oldval, curval)
+			switch cmd {
+			case "start":
+				state = started
+			case "prepare":
+				state = prepared
+			default:
+				state = closed
 			}
+			fmt.Printf("%v: ok\n", cmd)
+			continue
+		case "option":
+			switch rest {
+			case "no-deref":
+				noDerefNext = true
+			default:
+				t.Abort()
+				return fmt.Errorf("option unknown: %v", rest)
+			}
+			continue
+		case "update", "create", "delete", "verify":
+		default:
+			t.Abort()
+			return fmt.Errorf("Unknown command: %v", line)
 		}
 
-		// Update the symbolic-ref reflog before doing anything. If it can't
-		// be updated, it's a fatal error and we can't update the refspec.
-		if err := updateReflog(c, true, File(c.GitDir)+"/logs/"+File(ref), opts.OldValue, cmt, reason); err != nil {
-			return err
+		if state == prepared || state == closed {
+			t.Abort()
+			return fmt.Errorf("%v: transaction is already prepared or closed", cmd)
 		}
-		return UpdateRefSpec(c, opts, refspec, cmt, reason)
-	}
 
-noderef:
-	// NoDeref was specified.
-	if err := updateReflog(c, true, File(c.GitDir)+"/logs/"+File(ref), opts.OldValue, cmt, reason); err != nil {
-		return err
+		// Parse the ref and its values, in whichever format we're
+		// reading.
+		nvals := map[string]int{"update": 2, "create": 1, "delete": 1, "verify": 1}[cmd]
+		var ref string
+		var vals []string
+		if opts.NullTerminate {
+			ref = rest
+			for i := 0; i < nvals; i++ {
+				val, ok, err := readToken()
+				if err == nil && !ok {
+					err = fmt.Errorf("%v %v: unexpected end of input", cmd, ref)
+				}
+				if err != nil {
+					t.Abort()
+					return err
+				}
+				vals = append(vals, val)
+			}
+		} else {
+			if strings.HasPrefix(rest, "\"") {
+				end := strings.Index(rest[1:], "\"")
+				if end < 0 {
+					t.Abort()
+					return fmt.Errorf("%v: badly quoted argument: %v", cmd, rest)
+				}
+				unquoted, err := strconv.Unquote(rest[:end+2])
+				if err != nil {
+					t.Abort()
+					return fmt.Errorf("%v: badly quoted argument: %v", cmd, rest)
+				}
+				ref, rest = unquoted, strings.TrimPrefix(rest[end+2:], " ")
+			} else if sp := strings.IndexByte(rest, ' '); sp >= 0 {
+				ref, rest = rest[:sp], rest[sp+1:]
+			} else {
+				ref, rest = rest, ""
+			}
+			if rest != "" {
+				vals = strings.Split(rest, " ")
+			}
+			if len(vals) > nvals {
+				t.Abort()
+				return fmt.Errorf("%v %v: extra input: %v", cmd, ref, strings.Join(vals[nvals:], " "))
+			}
+			for len(vals) < nvals {
+				vals = append(vals, "")
+			}
+		}
+		if ref == "" {
+			t.Abort()
+			return fmt.Errorf("%v: missing <ref>", cmd)
+		}
+
+		uopts := RefUpdateOptions{
+			NoDeref:      opts.NoDeref || noDerefNext,
+			CreateReflog: opts.CreateReflog,
+			Reason:       reason,
+		}
+		noDerefNext = false
+
+		var newval, oldval *Sha1
+		if cmd == "update" || cmd == "create" {
+			if vals[0] == "" {
+				if !opts.NullTerminate || cmd == "create" {
+					t.Abort()
+					return fmt.Errorf("%v %v: missing <newvalue>", cmd, ref)
+				}
+				// An empty new value in -z mode means zero.
+				newval = &Sha1{}
+			} else if newval, err = parseValue(cmd, vals[0]); err != nil {
+				t.Abort()
+				return err
+			}
+		}
+		if cmd != "create" {
+			if oldval, err = parseValue(cmd, vals[len(vals)-1]); err != nil {
+				t.Abort()
+				return err
+			}
+		}
+
+		switch cmd {
+		case "update":
+			if *newval == (Sha1{}) {
+				// Updating to zero is the same as deleting.
+				if oldval != nil && *oldval == (Sha1{}) {
+					oldval = nil
+				}
+				err = t.Delete(ref, oldval, uopts)
+			} else {
+				err = t.Update(ref, *newval, oldval, uopts)
+			}
+		case "create":
+			err = t.Create(ref, *newval, uopts)
+		case "delete":
+			err = t.Delete(ref, oldval, uopts)
+		case "verify":
+			err = t.Verify(ref, oldval, uopts)
+		}
+		if err != nil {
+			t.Abort()
+			return err
+		}
 	}
 
-	f, err := c.GitDir.Create(File(ref))
-	if err != nil {
-		return err
+	switch state {
+	case open:
+		// Commit by default if no transaction was explicitly started.
+		return t.Commit()
+	case started, prepared:
+		return t.Abort()
 	}
-	defer f.Close()
-	fmt.Fprintf(f, "%s", cmt)
 	return nil
 }
@@ -102,7 +102,7 @@ func main() {
 	c, err := git.NewClient(*gitdir, *workdir)
 	// Pass any local configuration values to the client
 	for _, config := range configs {
-		parts := strings.Split(config, "=")
+		parts := strings.SplitN(config, "=", 2)
 		varname := parts[0]
 		value := "true"
 		if len(parts) > 1 {
@@ -243,9 +243,51 @@ func main() {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(2)
 		}
+	case "pack-refs":
+		subcommandUsage = "[--all] [--no-prune]"
+		if err := cmd.PackRefs(c, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(128)
+		}
+	case "repack":
+		subcommandUsage = "[-a] [-A] [-d] [-f] [-q] [--window=<n>] [--depth=<n>]"
+		if err := cmd.Repack(c, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(128)
+		}
+	case "prune-packed":
+		subcommandUsage = "[-n] [-q]"
+		if err := cmd.PrunePacked(c, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(128)
+		}
+	case "prune":
+		subcommandUsage = "[-n] [-v] [--expire <time>] [<head>...]"
+		if err := cmd.Prune(c, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(128)
+		}
+	case "gc":
+		subcommandUsage = "[--aggressive] [--auto] [--quiet] [--prune=<date> | --no-prune]"
+		if err := cmd.Gc(c, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(128)
+		}
+	case "commit-graph":
+		subcommandUsage = "write [--reachable | --stdin-packs | --stdin-commits] [--append]"
+		if err := cmd.CommitGraph(c, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(128)
+		}
 	case "merge-file":
 		subcommandUsage = "<current-file> <base-file> <other-file>"
-		if err := cmd.MergeFile(c, args); err != nil {
+		if conflicts, err := cmd.MergeFile(c, args); err == git.MergeConflict {
+			// Like git, the exit code is the number of conflicts.
+			if conflicts > 127 {
+				conflicts = 127
+			}
+			os.Exit(conflicts)
+		} else if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(2)
 		}
@@ -312,14 +354,17 @@ func main() {
 			os.Exit(4)
 		}
 	case "push":
-		subcommandUsage = "<repository>"
+		subcommandUsage = "[<repository> [<refspec>...]]"
 		if err := cmd.Push(c, args); err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(4)
 		}
 	case "pack-objects":
 		subcommandUsage = "<basename>"
-		cmd.PackObjects(c, os.Stdin, args)
+		if err := cmd.PackObjects(c, os.Stdin, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(128)
+		}
 	case "send-pack":
 		cmd.SendPack(c, args)
 	case "read-tree":
@@ -381,6 +426,35 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(4)
 		}
+	case "cherry-pick":
+		subcommandUsage = "<commit>..."
+		if err := cmd.CherryPick(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(4)
+		}
+	case "stash":
+		subcommandUsage = "[push | pop | apply | drop | list | show | branch | clear] [<options>]"
+		if err := cmd.Stash(c, args); err != nil {
+			if err != git.ErrStashConflicts {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			os.Exit(1)
+		}
+	case "bisect":
+		subcommandUsage = "[start | bad | good | skip | reset | log | replay | run] [<args>]"
+		if err := cmd.Bisect(c, args); err != nil {
+			if err == git.ErrBisectOnlySkipped {
+				os.Exit(2)
+			}
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "rebase":
+		subcommandUsage = "[-i] [--onto <newbase>] [<upstream> [<branch>]]"
+		if err := cmd.Rebase(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	case "revert":
 		subcommandUsage = "<commit>..."
 		if err := cmd.Revert(c, args); err != nil {
@@ -500,6 +574,12 @@ func main() {
    pull           Fetch from and integrate with another repository or a local branch
    push
    pack-objects
+   pack-refs      Pack heads and tags for efficient repository access
+   repack         Pack unpacked objects in a repository
+   prune-packed   Remove extra objects that are already in pack files
+   prune          Prune all unreachable objects from the object database
+   gc             Cleanup unnecessary files and optimize the local repository
+   commit-graph   Write the commit-graph file
    send-pack
    read-tree
    diff
@@ -513,6 +593,10 @@ func main() {
    grep
    apply
    revert
+   cherry-pick    Apply the changes introduced by some existing commits
+   rebase         Reapply commits on top of another base tip
+   stash          Stash the changes in a dirty working directory away
+   bisect         Use binary search to find the commit that introduced a bug
    help
    show             Show various types of objects
    var              Show a Git logical variable